@@ -54,6 +54,16 @@ type Error struct {
 	Message string `json:"message"`
 	Cause   string `json:"cause"`
 	Index   uint64 `json:"index"`
+	// CompactIndex is set for ErrorCodeEventIndexCleared. The keys
+	// should be listed again and watched from an index after it.
+	CompactIndex uint64 `json:"compactIndex,omitempty"`
+	// Reason is set for the errors of the server instead of Code, e.g.
+	// "TIMEOUT" or "LEADER_LOST". Index is then the raft index the member
+	// had applied when the request failed.
+	Reason string `json:"reason,omitempty"`
+	// Retryable is true if the request failed with an error of the server
+	// and may succeed when retried.
+	Retryable bool `json:"retryable,omitempty"`
 }
 
 func (e Error) Error() string {
@@ -142,6 +152,14 @@ type CreateInOrderOptions struct {
 	// that the zero-value is ignored, TTL cannot be used to set
 	// a TTL of 0.
 	TTL time.Duration
+
+	// Token identifies the operation. A retry of the operation with
+	// the same Token, e.g. after a timeout, gets the result of the
+	// operation back instead of being applied again. The server
+	// remembers the tokens of the last operations only.
+	//
+	// If Token is empty (default), every attempt is applied.
+	Token string
 }
 
 type SetOptions struct {
@@ -170,6 +188,14 @@ type SetOptions struct {
 	// that the zero-value is ignored, TTL cannot be used to set
 	// a TTL of 0.
 	TTL time.Duration
+
+	// Token identifies the operation. A retry of the operation with
+	// the same Token, e.g. after a timeout, gets the result of the
+	// operation back instead of being applied again. The server
+	// remembers the tokens of the last operations only.
+	//
+	// If Token is empty (default), every attempt is applied.
+	Token string
 }
 
 type GetOptions struct {
@@ -200,12 +226,30 @@ type DeleteOptions struct {
 	// If PrevIndex is set to 0 (default), no comparison is made.
 	PrevIndex uint64
 
+	// PrevMarker names a key under the directory Node, relative to
+	// it, whose current value must be PrevValue in order for the
+	// Delete operation to succeed. It requires Recursive, and lets a
+	// whole namespace be deleted in a single operation only if it is
+	// still owned by the caller.
+	//
+	// If PrevMarker is empty (default), PrevValue and PrevIndex are
+	// compared with the Node itself.
+	PrevMarker string
+
 	// Recursive defines whether or not all children of the Node
 	// should be deleted. If set to true, all children of the Node
 	// identified by the given key will be deleted. If left unset
 	// or explicitly set to false, only a single Node will be
 	// deleted.
 	Recursive bool
+
+	// Token identifies the operation. A retry of the operation with
+	// the same Token, e.g. after a timeout, gets the result of the
+	// operation back instead of being applied again. The server
+	// remembers the tokens of the last operations only.
+	//
+	// If Token is empty (default), every attempt is applied.
+	Token string
 }
 
 type Watcher interface {
@@ -291,6 +335,7 @@ func (k *httpKeysAPI) Set(ctx context.Context, key, val string, opts *SetOptions
 		act.PrevIndex = opts.PrevIndex
 		act.PrevExist = opts.PrevExist
 		act.TTL = opts.TTL
+		act.Token = opts.Token
 	}
 	// httpclient执行
 	resp, body, err := k.client.Do(ctx, act)
@@ -315,6 +360,7 @@ func (k *httpKeysAPI) CreateInOrder(ctx context.Context, dir, val string, opts *
 
 	if opts != nil {
 		act.TTL = opts.TTL
+		act.Token = opts.Token
 	}
 
 	resp, body, err := k.client.Do(ctx, act)
@@ -338,7 +384,9 @@ func (k *httpKeysAPI) Delete(ctx context.Context, key string, opts *DeleteOption
 	if opts != nil {
 		act.PrevValue = opts.PrevValue
 		act.PrevIndex = opts.PrevIndex
+		act.PrevMarker = opts.PrevMarker
 		act.Recursive = opts.Recursive
+		act.Token = opts.Token
 	}
 
 	resp, body, err := k.client.Do(ctx, act)
@@ -464,6 +512,7 @@ type setAction struct {
 	PrevIndex uint64
 	PrevExist PrevExistType
 	TTL       time.Duration
+	Token     string
 }
 
 func (a *setAction) HTTPRequest(ep url.URL) *http.Request {
@@ -479,6 +528,9 @@ func (a *setAction) HTTPRequest(ep url.URL) *http.Request {
 	if a.PrevExist != PrevIgnore {
 		params.Set("prevExist", string(a.PrevExist))
 	}
+	if a.Token != "" {
+		params.Set("token", a.Token)
+	}
 	u.RawQuery = params.Encode()
 
 	form := url.Values{}
@@ -495,11 +547,13 @@ func (a *setAction) HTTPRequest(ep url.URL) *http.Request {
 }
 
 type deleteAction struct {
-	Prefix    string
-	Key       string
-	PrevValue string
-	PrevIndex uint64
-	Recursive bool
+	Prefix     string
+	Key        string
+	PrevValue  string
+	PrevIndex  uint64
+	PrevMarker string
+	Recursive  bool
+	Token      string
 }
 
 func (a *deleteAction) HTTPRequest(ep url.URL) *http.Request {
@@ -512,9 +566,15 @@ func (a *deleteAction) HTTPRequest(ep url.URL) *http.Request {
 	if a.PrevIndex != 0 {
 		params.Set("prevIndex", strconv.FormatUint(a.PrevIndex, 10))
 	}
+	if a.PrevMarker != "" {
+		params.Set("prevMarker", a.PrevMarker)
+	}
 	if a.Recursive {
 		params.Set("recursive", "true")
 	}
+	if a.Token != "" {
+		params.Set("token", a.Token)
+	}
 	u.RawQuery = params.Encode()
 
 	req, _ := http.NewRequest("DELETE", u.String(), nil)
@@ -528,6 +588,7 @@ type createInOrderAction struct {
 	Dir    string
 	Value  string
 	TTL    time.Duration
+	Token  string
 }
 
 func (a *createInOrderAction) HTTPRequest(ep url.URL) *http.Request {
@@ -538,6 +599,9 @@ func (a *createInOrderAction) HTTPRequest(ep url.URL) *http.Request {
 	if a.TTL > 0 {
 		form.Add("ttl", strconv.FormatUint(uint64(a.TTL.Seconds()), 10))
 	}
+	if a.Token != "" {
+		form.Add("token", a.Token)
+	}
 	body := strings.NewReader(form.Encode())
 
 	req, _ := http.NewRequest("POST", u.String(), body)
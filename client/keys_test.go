@@ -330,6 +330,16 @@ func TestSetAction(t *testing.T) {
 			wantURL:  "http://example.com/foo",
 			wantBody: "ttl=180&value=",
 		},
+
+		// Token is set
+		{
+			act: setAction{
+				Key:   "foo",
+				Token: "abc",
+			},
+			wantURL:  "http://example.com/foo?token=abc",
+			wantBody: "value=",
+		},
 	}
 
 	for i, tt := range tests {
@@ -533,6 +543,26 @@ func TestDeleteAction(t *testing.T) {
 			},
 			wantURL: "http://example.com/foo?prevIndex=12",
 		},
+
+		// PrevMarker is set
+		{
+			act: deleteAction{
+				Key:        "foo",
+				PrevValue:  "bar",
+				PrevMarker: "owner",
+				Recursive:  true,
+			},
+			wantURL: "http://example.com/foo?prevMarker=owner&prevValue=bar&recursive=true",
+		},
+
+		// Token is set
+		{
+			act: deleteAction{
+				Key:   "foo",
+				Token: "abc",
+			},
+			wantURL: "http://example.com/foo?token=abc",
+		},
 	}
 
 	for i, tt := range tests {
@@ -741,6 +771,22 @@ func TestUnmarshalFailedKeysResponse(t *testing.T) {
 	}
 }
 
+func TestUnmarshalFailedKeysResponseServerError(t *testing.T) {
+	body := []byte(`{"message":"etcdserver: request timed out, possibly due to leader failure","reason":"LEADER_LOST","index":18,"retryable":true}`)
+
+	wantErr := Error{
+		Message:   "etcdserver: request timed out, possibly due to leader failure",
+		Index:     uint64(18),
+		Reason:    "LEADER_LOST",
+		Retryable: true,
+	}
+
+	gotErr := unmarshalFailedKeysResponse(body)
+	if !reflect.DeepEqual(wantErr, gotErr) {
+		t.Errorf("unexpected error: want=%#v got=%#v", wantErr, gotErr)
+	}
+}
+
 func TestUnmarshalFailedKeysResponseBadJSON(t *testing.T) {
 	err := unmarshalFailedKeysResponse([]byte(`{"er`))
 	if err == nil {
@@ -45,6 +45,31 @@ type Member struct {
 	// ClientURLs represents the HTTP(S) endpoints on which this Member
 	// serves it's client-facing APIs.
 	ClientURLs []string `json:"clientURLs"`
+
+	// Version is the etcd version this Member published when it started.
+	// It is empty until then.
+	Version string `json:"version,omitempty"`
+
+	// Labels are the key/value pairs, e.g. the zone, this Member
+	// published with its version.
+	Labels map[string]string `json:"labels,omitempty"`
+
+	// Status is the status of this Member as seen from the member that
+	// listed it. It is only set by List.
+	Status *MemberStatus `json:"status,omitempty"`
+}
+
+type MemberStatus struct {
+	// IsLeader tells whether this Member is the leader.
+	IsLeader bool `json:"isLeader"`
+
+	// Reachable tells whether the listing member can reach this Member.
+	Reachable bool `json:"reachable"`
+
+	// Lag is how many committed entries this Member lags behind. It is
+	// nil if the listing member does not know it, i.e. it is a follower
+	// and this Member is another one.
+	Lag *uint64 `json:"lag,omitempty"`
 }
 
 type memberCollection []Member
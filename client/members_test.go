@@ -192,6 +192,7 @@ func TestMemberCollectionUnmarshalFail(t *testing.T) {
 }
 
 func TestMemberCollectionUnmarshal(t *testing.T) {
+	lag2 := uint64(2)
 	tests := []struct {
 		body []byte
 		want memberCollection
@@ -243,6 +244,27 @@ func TestMemberCollectionUnmarshal(t *testing.T) {
 				},
 			),
 		},
+		{
+			body: []byte(`{"members":[{"id":"42134f434382925","peerURLs":["http://127.0.0.1:2380"],"name":"node1","clientURLs":["http://127.0.0.1:2379"],"status":{"isLeader":true,"reachable":true,"lag":2}},{"id":"94088180e21eb87b","peerURLs":["http://127.0.0.1:7002"],"name":"node2","clientURLs":["http://127.0.0.1:4002"],"status":{"isLeader":false,"reachable":false}}]}`),
+			want: memberCollection(
+				[]Member{
+					{
+						ID:         "42134f434382925",
+						Name:       "node1",
+						PeerURLs:   []string{"http://127.0.0.1:2380"},
+						ClientURLs: []string{"http://127.0.0.1:2379"},
+						Status:     &MemberStatus{IsLeader: true, Reachable: true, Lag: &lag2},
+					},
+					{
+						ID:         "94088180e21eb87b",
+						Name:       "node2",
+						PeerURLs:   []string{"http://127.0.0.1:7002"},
+						ClientURLs: []string{"http://127.0.0.1:4002"},
+						Status:     &MemberStatus{IsLeader: false, Reachable: false},
+					},
+				},
+			),
+		},
 	}
 
 	for i, tt := range tests {
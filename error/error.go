@@ -35,6 +35,8 @@ var errors = map[int]string{
 	EcodeRootROnly:        "Root is read only",
 	EcodeDirNotEmpty:      "Directory not empty",
 	ecodeExistingPeerAddr: "Peer address has existed",
+	EcodeValueNaN:         "The value of the key is not a number",
+	EcodeQuotaExceeded:    "The directory quota is exceeded",
 
 	// Post form related errors
 	ecodeValueRequired:        "Value is Required in POST form",
@@ -65,13 +67,15 @@ var errors = map[int]string{
 }
 
 var errorStatus = map[int]int{
-	EcodeKeyNotFound:  http.StatusNotFound,
-	EcodeNotFile:      http.StatusForbidden,
-	EcodeDirNotEmpty:  http.StatusForbidden,
-	EcodeTestFailed:   http.StatusPreconditionFailed,
-	EcodeNodeExist:    http.StatusPreconditionFailed,
-	EcodeRaftInternal: http.StatusInternalServerError,
-	EcodeLeaderElect:  http.StatusInternalServerError,
+	EcodeKeyNotFound:   http.StatusNotFound,
+	EcodeNotFile:       http.StatusForbidden,
+	EcodeDirNotEmpty:   http.StatusForbidden,
+	EcodeValueNaN:      http.StatusForbidden,
+	EcodeQuotaExceeded: http.StatusForbidden,
+	EcodeTestFailed:    http.StatusPreconditionFailed,
+	EcodeNodeExist:     http.StatusPreconditionFailed,
+	EcodeRaftInternal:  http.StatusInternalServerError,
+	EcodeLeaderElect:   http.StatusInternalServerError,
 }
 
 const (
@@ -85,6 +89,8 @@ const (
 	EcodeRootROnly        = 107
 	EcodeDirNotEmpty      = 108
 	ecodeExistingPeerAddr = 109
+	EcodeValueNaN         = 110
+	EcodeQuotaExceeded    = 111
 
 	ecodeValueRequired        = 200
 	EcodePrevValueRequired    = 201
@@ -115,6 +121,10 @@ type Error struct {
 	Message   string `json:"message"`
 	Cause     string `json:"cause,omitempty"`
 	Index     uint64 `json:"index"`
+	// CompactIndex is set for EcodeEventIndexCleared. The events up to
+	// and including it have been cleared, so a watcher has to re-list
+	// the keys and watch from an index after it.
+	CompactIndex uint64 `json:"compactIndex,omitempty"`
 }
 
 func NewRequestError(errorCode int, cause string) *Error {
@@ -130,6 +140,15 @@ func NewError(errorCode int, cause string, index uint64) *Error {
 	}
 }
 
+// NewCompactedError returns an EcodeEventIndexCleared error telling that
+// the requested index has been compacted at compactIndex.
+func NewCompactedError(compactIndex, requestedIndex, index uint64) *Error {
+	err := NewError(EcodeEventIndexCleared,
+		fmt.Sprintf("the requested index %d has been compacted at index %d", requestedIndex, compactIndex), index)
+	err.CompactIndex = compactIndex
+	return err
+}
+
 // Only for error interface
 func (e Error) Error() string {
 	return e.Message + " (" + e.Cause + ")"
@@ -150,6 +169,9 @@ func (e Error) statusCode() int {
 
 func (e Error) WriteTo(w http.ResponseWriter) {
 	w.Header().Add("X-Etcd-Index", fmt.Sprint(e.Index))
+	if e.CompactIndex != 0 {
+		w.Header().Add("X-Etcd-Compact-Index", fmt.Sprint(e.CompactIndex))
+	}
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(e.statusCode())
 	fmt.Fprintln(w, e.toJsonString())
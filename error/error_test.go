@@ -48,3 +48,20 @@ func TestErrorWriteTo(t *testing.T) {
 	}
 
 }
+
+func TestCompactedErrorWriteTo(t *testing.T) {
+	err := NewCompactedError(10, 5, 20)
+	rr := httptest.NewRecorder()
+	err.WriteTo(rr)
+
+	if rr.Code != http.StatusBadRequest {
+		t.Errorf("HTTP status code %d, want %d", rr.Code, http.StatusBadRequest)
+	}
+	wbody := `{"errorCode":401,"message":"The event in requested index is outdated and cleared","cause":"the requested index 5 has been compacted at index 10","index":20,"compactIndex":10}`
+	if gbody := strings.TrimSuffix(rr.Body.String(), "\n"); gbody != wbody {
+		t.Errorf("HTTP body %q, want %q", gbody, wbody)
+	}
+	if g := rr.HeaderMap.Get("X-Etcd-Compact-Index"); g != "10" {
+		t.Errorf("X-Etcd-Compact-Index = %q, want %q", g, "10")
+	}
+}
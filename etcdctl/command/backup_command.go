@@ -36,6 +36,7 @@ func NewBackupCommand() cli.Command {
 		Usage: "backup an etcd directory",
 		Flags: []cli.Flag{
 			cli.StringFlag{Name: "data-dir", Value: "", Usage: "Path to the etcd data dir"},
+			cli.StringFlag{Name: "wal-dir", Value: "", Usage: "Path to the etcd wal dir if it is not under the data dir"},
 			cli.StringFlag{Name: "backup-dir", Value: "", Usage: "Path to the backup dir"},
 		},
 		Action: handleBackup,
@@ -47,6 +48,9 @@ func handleBackup(c *cli.Context) {
 	srcSnap := path.Join(c.String("data-dir"), "member", "snap")
 	destSnap := path.Join(c.String("backup-dir"), "member", "snap")
 	srcWAL := path.Join(c.String("data-dir"), "member", "wal")
+	if c.String("wal-dir") != "" {
+		srcWAL = c.String("wal-dir")
+	}
 	destWAL := path.Join(c.String("backup-dir"), "member", "wal")
 
 	if err := os.MkdirAll(destSnap, 0700); err != nil {
@@ -21,11 +21,17 @@ import (
 	"net/url"
 	"os"
 	"strings"
+	"time"
 
 	"github.com/coreos/etcd/etcdserver"
+	"github.com/coreos/etcd/etcdserver/etcdhttp"
 	"github.com/coreos/etcd/pkg/cors"
 	"github.com/coreos/etcd/pkg/flags"
 	"github.com/coreos/etcd/pkg/transport"
+	"github.com/coreos/etcd/proxy"
+	"github.com/coreos/etcd/rafthttp"
+	"github.com/coreos/etcd/standby"
+	"github.com/coreos/etcd/store"
 	"github.com/coreos/etcd/version"
 )
 
@@ -73,12 +79,44 @@ type config struct {
 	// member
 	corsInfo *cors.CORSInfo
 	dir      string
-	//lpurls表示监听peer的urls，lcurls表示监听client的urls.
+	walDir   string
+	//lpurls表示监听peer的urls，lcurls表示监听client的urls，lmurls表示额外监听metrics的urls.
 	lpurls, lcurls []url.URL
+	lmurls         []url.URL
+	enablePprof    bool
 	maxSnapFiles   uint
 	maxWalFiles    uint
 	name           string
+	labels         flags.LabelsValue
 	snapCount      uint64
+	catchUpEntries uint64
+	maxMsgSize     uint64
+	maxInflightMsg int
+	leaderZone     string
+	preVote        bool
+	storeBackend   *flags.StringsFlag
+	snapshotFormat *flags.StringsFlag
+	historySize    int
+	quotaBytes     int64
+	minFreeDisk    int64
+	maxValueBytes  int64
+	maxReqBytes    int64
+	maxInflight    int
+	fdWarn         int
+	fdShedWatch    int
+	slowRequestMs  uint
+	maxWatchConns  int
+	maxWatchPerIP  int
+	hashCheckMs    uint
+	retention      time.Duration
+	syncMs         int
+	walSyncMs      uint
+	encryptionKey  string
+	repairWAL      bool
+	walStorage     bool
+	auditLog       string
+	auditMaxBytes  int64
+	auditMaxFiles  int
 	// TODO: decouple tickMs and heartbeat tick (current heartbeat tick = 1).
 	// make ticks a cluster wide configuration.
 	TickMs     uint
@@ -95,9 +133,20 @@ type config struct {
 	fallback            *flags.StringsFlag
 	initialCluster      string
 	initialClusterToken string
+	restoreSnapshot     string
+	peerCompression     *flags.StringsFlag
+	peerDialTimeoutMs   uint
+	peerReadTimeoutMs   uint
+	peerWriteTimeoutMs  uint
+	snapshotSendRate    int64
 
 	// proxy
-	proxy *flags.StringsFlag
+	proxy                *flags.StringsFlag
+	proxyRefreshInterval uint
+
+	// standby
+	standby             bool
+	standbySyncInterval uint
 
 	// security
 	clientTLSInfo, peerTLSInfo transport.TLSInfo
@@ -122,11 +171,24 @@ func NewConfig() *config {
 			fallbackFlagProxy,
 		),
 		ignored: ignored,
+		storeBackend: flags.NewStringsFlag(
+			etcdserver.StoreBackendV2,
+			etcdserver.StoreBackendMVCC,
+			etcdserver.StoreBackendBolt,
+		),
+		snapshotFormat: flags.NewStringsFlag(
+			store.SnapshotFormatJSON,
+			store.SnapshotFormatGob,
+		),
 		proxy: flags.NewStringsFlag(
 			proxyFlagOff,
 			proxyFlagReadonly,
 			proxyFlagOn,
 		),
+		peerCompression: flags.NewStringsFlag(
+			rafthttp.CompressionNone,
+			rafthttp.CompressionGzip,
+		),
 	}
 
 	cfg.FlagSet = flag.NewFlagSet("etcd", flag.ContinueOnError)
@@ -139,14 +201,54 @@ func NewConfig() *config {
 	// member
 	fs.Var(cfg.corsInfo, "cors", "Comma-separated white list of origins for CORS (cross-origin resource sharing).")
 	fs.StringVar(&cfg.dir, "data-dir", "", "Path to the data directory")
+	fs.StringVar(&cfg.walDir, "wal-dir", "", "Path to the dedicated wal directory")
 	fs.Var(flags.NewURLsValue("http://localhost:2380,http://localhost:7001"), "listen-peer-urls", "List of URLs to listen on for peer traffic")
 	fs.Var(flags.NewURLsValue("http://localhost:2379,http://localhost:4001"), "listen-client-urls", "List of URLs to listen on for client traffic")
+	fs.Var(&flags.URLsValue{}, "listen-metrics-urls", "List of additional URLs to listen on for the metrics, expvar and pprof requests")
+	fs.BoolVar(&cfg.enablePprof, "enable-pprof", false, "Serve the runtime profiles of the process under /debug/pprof on the metrics URLs, or on the client URLs if no metrics URL is given")
 	fs.UintVar(&cfg.maxSnapFiles, "max-snapshots", defaultMaxSnapshots, "Maximum number of snapshot files to retain (0 is unlimited)")
 	fs.UintVar(&cfg.maxWalFiles, "max-wals", defaultMaxWALs, "Maximum number of wal files to retain (0 is unlimited)")
 	fs.StringVar(&cfg.name, "name", defaultName, "Unique human-readable name for this node")
+	fs.Var(&cfg.labels, "labels", "Comma-separated key=value labels of this member, e.g. zone=us-east-1a,rack=r1, published with its attributes")
 	fs.Uint64Var(&cfg.snapCount, "snapshot-count", etcdserver.DefaultSnapCount, "Number of committed transactions to trigger a snapshot")
+	fs.Uint64Var(&cfg.maxMsgSize, "raft-max-size-per-msg", etcdserver.DefaultMaxSizePerMsg, "Max size in bytes of a raft append message")
+	fs.IntVar(&cfg.maxInflightMsg, "raft-max-inflight-msgs", etcdserver.DefaultMaxInflightMsgs, "Max number of the raft append messages in flight to a follower")
+	fs.StringVar(&cfg.leaderZone, "preferred-leader-zone", "", "Zone the leader is preferred in; the members whose zone label is another zone delay their campaigns")
+	fs.BoolVar(&cfg.preVote, "raft-pre-vote", false, "Enable the raft Pre-Vote once the cluster version supports it, so that a rejoining member does not disrupt the leader")
+	fs.Uint64Var(&cfg.catchUpEntries, "snapshot-catchup-entries", etcdserver.DefaultSnapCatchUpEntries, "Number of entries kept before a snapshot when the raft log is compacted, for the slow followers to catch up from")
 	fs.UintVar(&cfg.TickMs, "heartbeat-interval", 100, "Time (in milliseconds) of a heartbeat interval.")
 	fs.UintVar(&cfg.ElectionMs, "election-timeout", 1000, "Time (in milliseconds) for an election to timeout.")
+	fs.Var(cfg.storeBackend, "store-backend", fmt.Sprintf("Valid values include %s", strings.Join(cfg.storeBackend.Values, ", ")))
+	if err := cfg.storeBackend.Set(etcdserver.StoreBackendV2); err != nil {
+		// Should never happen.
+		log.Panicf("unexpected error setting up store-backend flag: %v", err)
+	}
+	fs.Var(cfg.snapshotFormat, "snapshot-format", fmt.Sprintf("Format of the store in the snapshots. Valid values include %s", strings.Join(cfg.snapshotFormat.Values, ", ")))
+	if err := cfg.snapshotFormat.Set(store.SnapshotFormatJSON); err != nil {
+		// Should never happen.
+		log.Panicf("unexpected error setting up snapshot-format flag: %v", err)
+	}
+	fs.IntVar(&cfg.historySize, "watch-history-size", store.DefaultHistorySize, "Number of events kept for watchers to resume from")
+	fs.Int64Var(&cfg.quotaBytes, "quota-backend-bytes", 0, "Raise the NOSPACE alarm when the store exceeds the given size in bytes. 0 uses the default quota, a negative value disables it")
+	fs.Int64Var(&cfg.minFreeDisk, "min-free-disk-bytes", 0, "Reject the client writes with 507 and raise the LOWDISK alarm while the filesystem of the WAL or the snapshots has less free space in bytes. 0 uses the default, a negative value disables the check")
+	fs.Int64Var(&cfg.maxValueBytes, "max-value-bytes", 0, "Reject the client writes of a value larger than the given size in bytes with 413. 0 uses the default limit, a negative value disables it")
+	fs.Int64Var(&cfg.maxReqBytes, "max-request-bytes", 0, "Reject the client writes larger than the given size in bytes with 413. 0 uses the default limit, a negative value disables it")
+	fs.IntVar(&cfg.maxInflight, "max-inflight-proposals", 0, "Reject the client writes with 429 when the given number of proposals are in flight. 0 uses the default limit, a negative value disables it")
+	fs.IntVar(&cfg.fdWarn, "fd-warn-percent", 0, "Log the file descriptor usage above the given percent of the limit. 0 uses the default, a negative value disables the log")
+	fs.IntVar(&cfg.fdShedWatch, "fd-shed-watch-percent", 0, "Reject the new watches with 503 above the given percent of the file descriptor limit. 0 uses the default, a negative value disables it")
+	fs.IntVar(&cfg.maxWatchConns, "max-watch-connections", etcdhttp.DefaultMaxWatchConns, "Reject the watches with 429 when the given number of watch connections are served on a client listener. 0 disables the limit")
+	fs.IntVar(&cfg.maxWatchPerIP, "max-watch-connections-per-ip", 0, "Reject the watches with 429 when the given number of watch connections from the same IP are served on a client listener. 0 disables the limit")
+	fs.UintVar(&cfg.slowRequestMs, "slow-request-threshold", uint(etcdserver.DefaultSlowRequestThreshold/time.Millisecond), "Time (in milliseconds) a write may take before it is logged as slow. 0 disables the log")
+	fs.UintVar(&cfg.hashCheckMs, "hash-check-interval", 0, "Time (in milliseconds) of the interval at which the leader compares the store hashes of the members. 0 disables the check")
+	fs.DurationVar(&cfg.retention, "auto-compaction-retention", 0, "Time the watch history and the store revisions are kept for, e.g. 1h, before they are compacted along with the raft log. 0 disables the auto-compaction")
+	fs.IntVar(&cfg.syncMs, "sync-interval", 0, "Time (in milliseconds) of the interval at which the leader expires the TTL keys. 0 uses the default interval, a negative value disables the expiration on the leader")
+	fs.UintVar(&cfg.walSyncMs, "wal-sync-window", 0, "Time (in milliseconds) a save to the WAL waits for other saves to share its fsync. 0 fsyncs every save immediately")
+	fs.StringVar(&cfg.encryptionKey, "encryption-key-file", "", "Path to the file holding the hex-encoded AES key the WAL and the snapshot files are encrypted with")
+	fs.BoolVar(&cfg.repairWAL, "repair-wal", false, "Discard the torn last record of the WAL, e.g. after a power loss, instead of refusing to start")
+	fs.BoolVar(&cfg.walStorage, "wal-storage", false, "Read the raft log back from the WAL, caching only the latest entries in memory, instead of holding the whole uncompacted log in memory")
+	fs.StringVar(&cfg.auditLog, "audit-log", "", "Path to the file the applied writes are appended to, one JSON record per line. Empty disables the audit log")
+	fs.Int64Var(&cfg.auditMaxBytes, "audit-log-max-bytes", 0, "Size in bytes the audit log is rotated at. 0 uses the default size, a negative value never rotates it")
+	fs.IntVar(&cfg.auditMaxFiles, "audit-log-max-files", 0, "Number of the rotated audit logs kept. 0 uses the default number, a negative value keeps none")
 
 	// clustering
 	// 应该搞清楚advertise peer url和listen peer url之间的关系
@@ -167,9 +269,24 @@ func NewConfig() *config {
 		// Should never happen.
 		log.Panicf("unexpected error setting up clusterStateFlag: %v", err)
 	}
+	fs.StringVar(&cfg.restoreSnapshot, "restore-snapshot", "", "Path to a snapshot file to bootstrap a new single-member cluster from")
+	fs.Var(cfg.peerCompression, "peer-compression", fmt.Sprintf("Compression of the raft streams sent to the peers. Valid values include %s", strings.Join(cfg.peerCompression.Values, ", ")))
+	if err := cfg.peerCompression.Set(rafthttp.CompressionNone); err != nil {
+		// Should never happen.
+		log.Panicf("unexpected error setting up peer-compression flag: %v", err)
+	}
+	fs.UintVar(&cfg.peerDialTimeoutMs, "peer-dial-timeout", uint(rafthttp.DialTimeout/time.Millisecond), "Time (in milliseconds) for dialing a peer to timeout.")
+	fs.UintVar(&cfg.peerReadTimeoutMs, "peer-read-timeout", uint(rafthttp.ConnReadTimeout/time.Millisecond), "Time (in milliseconds) for a read on a peer connection to timeout.")
+	fs.UintVar(&cfg.peerWriteTimeoutMs, "peer-write-timeout", uint(rafthttp.ConnWriteTimeout/time.Millisecond), "Time (in milliseconds) for a write on a peer connection to timeout.")
+	fs.Int64Var(&cfg.snapshotSendRate, "snapshot-send-rate", 0, "Max bytes per second of the snapshots sent to the peers (0 is unlimited)")
 
 	// proxy
 	fs.Var(cfg.proxy, "proxy", fmt.Sprintf("Valid values include %s", strings.Join(cfg.proxy.Values, ", ")))
+	fs.UintVar(&cfg.proxyRefreshInterval, "proxy-refresh-interval", uint(proxy.DefaultRefreshInterval/time.Millisecond), "Time (in milliseconds) of the endpoints refresh interval.")
+
+	// standby
+	fs.BoolVar(&cfg.standby, "standby", false, "Run as a hot standby that replicates the store without joining the cluster until promoted")
+	fs.UintVar(&cfg.standbySyncInterval, "standby-sync-interval", uint(standby.DefaultSyncInterval/time.Millisecond), "Time (in milliseconds) between the snapshots pulled by a standby.")
 	if err := cfg.proxy.Set(proxyFlagOff); err != nil {
 		// Should never happen.
 		log.Panicf("unexpected error setting up proxyFlag: %v", err)
@@ -264,10 +381,29 @@ func (cfg *config) Parse(arguments []string) error {
 	if err != nil {
 		return err
 	}
+	cfg.lmurls = []url.URL(*cfg.FlagSet.Lookup("listen-metrics-urls").Value.(*flags.URLsValue))
 	// 选举Timeout应该大于心跳timeout的5倍
 	if 5*cfg.TickMs > cfg.ElectionMs {
 		return fmt.Errorf("-election-timeout[%vms] should be at least as 5 times as -heartbeat-interval[%vms]", cfg.ElectionMs, cfg.TickMs)
 	}
+	if cfg.peerDialTimeoutMs == 0 || cfg.peerReadTimeoutMs == 0 || cfg.peerWriteTimeoutMs == 0 {
+		return fmt.Errorf("-peer-dial-timeout[%vms], -peer-read-timeout[%vms] and -peer-write-timeout[%vms] should be positive", cfg.peerDialTimeoutMs, cfg.peerReadTimeoutMs, cfg.peerWriteTimeoutMs)
+	}
+	if cfg.snapshotSendRate < 0 {
+		return fmt.Errorf("-snapshot-send-rate[%d] should not be negative", cfg.snapshotSendRate)
+	}
+	if cfg.historySize <= 0 {
+		return fmt.Errorf("-watch-history-size[%v] should be positive", cfg.historySize)
+	}
+	if cfg.proxyRefreshInterval == 0 {
+		return fmt.Errorf("-proxy-refresh-interval[%vms] should be positive", cfg.proxyRefreshInterval)
+	}
+	if cfg.standby && cfg.isProxy() {
+		return fmt.Errorf("-standby cannot be set with -proxy %s", cfg.proxy)
+	}
+	if cfg.standbySyncInterval == 0 {
+		return fmt.Errorf("-standby-sync-interval[%vms] should be positive", cfg.standbySyncInterval)
+	}
 
 	return nil
 }
@@ -287,3 +423,15 @@ func (cfg config) shouldFallbackToProxy() bool { return cfg.fallback.String() ==
 
 // 选举timeout大于heartbeat timeout的倍数
 func (cfg config) electionTicks() int { return int(cfg.ElectionMs / cfg.TickMs) }
+
+func (cfg config) peerDialTimeout() time.Duration {
+	return time.Duration(cfg.peerDialTimeoutMs) * time.Millisecond
+}
+
+func (cfg config) peerReadTimeout() time.Duration {
+	return time.Duration(cfg.peerReadTimeoutMs) * time.Millisecond
+}
+
+func (cfg config) peerWriteTimeout() time.Duration {
+	return time.Duration(cfg.peerWriteTimeoutMs) * time.Millisecond
+}
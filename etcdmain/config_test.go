@@ -18,6 +18,7 @@ import (
 	"net/url"
 	"reflect"
 	"testing"
+	"time"
 )
 
 func TestConfigParsingMemberFlags(t *testing.T) {
@@ -29,11 +30,13 @@ func TestConfigParsingMemberFlags(t *testing.T) {
 		"-snapshot-count=10",
 		"-listen-peer-urls=http://localhost:8000,https://localhost:8001",
 		"-listen-client-urls=http://localhost:7000,https://localhost:7001",
+		"-listen-metrics-urls=http://localhost:9000",
 	}
 	wcfg := &config{
 		dir:          "testdir",
 		lpurls:       []url.URL{{Scheme: "http", Host: "localhost:8000"}, {Scheme: "https", Host: "localhost:8001"}},
 		lcurls:       []url.URL{{Scheme: "http", Host: "localhost:7000"}, {Scheme: "https", Host: "localhost:7001"}},
+		lmurls:       []url.URL{{Scheme: "http", Host: "localhost:9000"}},
 		maxSnapFiles: 10,
 		maxWalFiles:  10,
 		name:         "testname",
@@ -66,6 +69,9 @@ func TestConfigParsingMemberFlags(t *testing.T) {
 	if !reflect.DeepEqual(cfg.lcurls, wcfg.lcurls) {
 		t.Errorf("listen-client-urls = %v, want %v", cfg.lcurls, wcfg.lcurls)
 	}
+	if !reflect.DeepEqual(cfg.lmurls, wcfg.lmurls) {
+		t.Errorf("listen-metrics-urls = %v, want %v", cfg.lmurls, wcfg.lmurls)
+	}
 }
 
 func TestConfigParsingClusteringFlags(t *testing.T) {
@@ -113,6 +119,7 @@ func TestConfigParsingClusteringFlags(t *testing.T) {
 func TestConfigParsingOtherFlags(t *testing.T) {
 	args := []string{
 		"-proxy=readonly",
+		"-proxy-refresh-interval=10000",
 		"-ca-file=cafile",
 		"-cert-file=certfile",
 		"-key-file=keyfile",
@@ -124,6 +131,7 @@ func TestConfigParsingOtherFlags(t *testing.T) {
 
 	wcfg := NewConfig()
 	wcfg.proxy.Set(proxyFlagReadonly)
+	wcfg.proxyRefreshInterval = 10000
 	wcfg.clientTLSInfo.CAFile = "cafile"
 	wcfg.clientTLSInfo.CertFile = "certfile"
 	wcfg.clientTLSInfo.KeyFile = "keyfile"
@@ -140,6 +148,9 @@ func TestConfigParsingOtherFlags(t *testing.T) {
 	if cfg.proxy.String() != wcfg.proxy.String() {
 		t.Errorf("proxy = %v, want %v", cfg.proxy, wcfg.proxy)
 	}
+	if cfg.proxyRefreshInterval != wcfg.proxyRefreshInterval {
+		t.Errorf("proxyRefreshInterval = %v, want %v", cfg.proxyRefreshInterval, wcfg.proxyRefreshInterval)
+	}
 	if cfg.clientTLSInfo.String() != wcfg.clientTLSInfo.String() {
 		t.Errorf("clientTLS = %v, want %v", cfg.clientTLSInfo, wcfg.clientTLSInfo)
 	}
@@ -151,6 +162,32 @@ func TestConfigParsingOtherFlags(t *testing.T) {
 	}
 }
 
+func TestConfigParsingPeerTimeoutFlags(t *testing.T) {
+	cfg := NewConfig()
+	err := cfg.Parse([]string{
+		"-peer-dial-timeout=2000",
+		"-peer-read-timeout=10000",
+		"-peer-write-timeout=8000",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if g := cfg.peerDialTimeout(); g != 2*time.Second {
+		t.Errorf("peerDialTimeout = %v, want %v", g, 2*time.Second)
+	}
+	if g := cfg.peerReadTimeout(); g != 10*time.Second {
+		t.Errorf("peerReadTimeout = %v, want %v", g, 10*time.Second)
+	}
+	if g := cfg.peerWriteTimeout(); g != 8*time.Second {
+		t.Errorf("peerWriteTimeout = %v, want %v", g, 8*time.Second)
+	}
+
+	cfg = NewConfig()
+	if err = cfg.Parse([]string{"-peer-read-timeout=0"}); err == nil {
+		t.Errorf("err = nil, want not nil for a zero peer read timeout")
+	}
+}
+
 func TestConfigParsingV1Flags(t *testing.T) {
 	args := []string{
 		"-peer-addr=127.0.0.1:2380",
@@ -25,18 +25,21 @@ import (
 	"path"
 	"reflect"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/coreos/etcd/discovery"
 	"github.com/coreos/etcd/etcdserver"
+	"github.com/coreos/etcd/etcdserver/api"
 	"github.com/coreos/etcd/etcdserver/etcdhttp"
 	"github.com/coreos/etcd/pkg/cors"
+	"github.com/coreos/etcd/pkg/encryption"
 	"github.com/coreos/etcd/pkg/fileutil"
 	"github.com/coreos/etcd/pkg/osutil"
 	"github.com/coreos/etcd/pkg/transport"
 	"github.com/coreos/etcd/pkg/types"
 	"github.com/coreos/etcd/proxy"
-	"github.com/coreos/etcd/rafthttp"
+	"github.com/coreos/etcd/standby"
 )
 
 type dirType string
@@ -52,7 +55,7 @@ var (
 	dirEmpty  = dirType("empty")
 )
 
-//入口
+// 入口
 func Main() {
 	//新建配置，使用默认配置,配置来自于命令行或者环境变量
 	cfg := NewConfig()
@@ -80,7 +83,14 @@ func Main() {
 	}
 	//根据配置参数决定是以proxy还是etcdServer模式启动
 	shouldProxy := cfg.isProxy() || which == dirProxy
-	if !shouldProxy {
+	// a promoted standby has a member dir, and starts as a member
+	shouldStandby := cfg.standby && which == dirEmpty
+	if cfg.standby && which == dirMember {
+		log.Printf("etcd: standby has been promoted before, ignoring -standby")
+	}
+	if shouldStandby {
+		stopped, err = startStandby(cfg)
+	} else if !shouldProxy {
 		stopped, err = startEtcd(cfg)
 		if err == discovery.ErrFullCluster && cfg.shouldFallbackToProxy() {
 			log.Printf("etcd: discovery cluster full, falling back to %s", fallbackFlagProxy)
@@ -114,7 +124,7 @@ func startEtcd(cfg *config) (<-chan struct{}, error) {
 		return nil, fmt.Errorf("error setting up initial cluster: %v", err)
 	}
 	//采用NewTimeoutTransport与cluster的其他节点通信
-	pt, err := transport.NewTimeoutTransport(cfg.peerTLSInfo, rafthttp.DialTimeout, rafthttp.ConnReadTimeout, rafthttp.ConnWriteTimeout)
+	pt, err := transport.NewTimeoutTransport(cfg.peerTLSInfo, cfg.peerDialTimeout(), cfg.peerReadTimeout(), cfg.peerWriteTimeout())
 	if err != nil {
 		return nil, err
 	}
@@ -125,7 +135,7 @@ func startEtcd(cfg *config) (<-chan struct{}, error) {
 	plns := make([]net.Listener, 0)
 	for _, u := range cfg.lpurls {
 		var l net.Listener
-		l, err = transport.NewTimeoutListener(u.Host, u.Scheme, cfg.peerTLSInfo, rafthttp.ConnReadTimeout, rafthttp.ConnWriteTimeout)
+		l, err = transport.NewTimeoutListener(u.Host, u.Scheme, cfg.peerTLSInfo, cfg.peerReadTimeout(), cfg.peerWriteTimeout())
 		if err != nil {
 			return nil, err
 		}
@@ -145,6 +155,8 @@ func startEtcd(cfg *config) (<-chan struct{}, error) {
 		log.Printf("etcd: clientTLS: %s", cfg.clientTLSInfo)
 	}
 	clns := make([]net.Listener, 0)
+	// glns holds the gRPC listeners split from the plain client listeners
+	glns := make([]net.Listener, 0)
 	for _, u := range cfg.lcurls {
 		var l net.Listener
 		//采用NewKeepAliveListener设置与client之间的listener
@@ -161,25 +173,88 @@ func startEtcd(cfg *config) (<-chan struct{}, error) {
 				log.Print("etcd: stopping listening for client requests on ", urlStr)
 			}
 		}()
+		if u.Scheme == "http" || u.Scheme == "unix" {
+			// the v3 KV API is served over gRPC on the plain client
+			// listeners only, which can be sniffed for gRPC connections
+			var gl net.Listener
+			gl, l = api.NewSplitListener(l)
+			glns = append(glns, gl)
+		}
 		clns = append(clns, l)
 	}
+	mlns := make([]net.Listener, 0)
+	for _, u := range cfg.lmurls {
+		var l net.Listener
+		l, err = transport.NewKeepAliveListener(u.Host, u.Scheme, cfg.clientTLSInfo)
+		if err != nil {
+			return nil, err
+		}
+
+		urlStr := u.String()
+		log.Print("etcd: listening for metrics on ", urlStr)
+		defer func() {
+			if err != nil {
+				l.Close()
+				log.Print("etcd: stopping listening for metrics on ", urlStr)
+			}
+		}()
+		mlns = append(mlns, l)
+	}
 	//构造etcdServer的配置信息
 	srvcfg := &etcdserver.ServerConfig{
-		Name:            cfg.name,
-		ClientURLs:      cfg.acurls,
-		PeerURLs:        cfg.apurls,
-		DataDir:         cfg.dir,
-		SnapCount:       cfg.snapCount,
-		MaxSnapFiles:    cfg.maxSnapFiles,
-		MaxWALFiles:     cfg.maxWalFiles,
-		Cluster:         cls,
-		DiscoveryURL:    cfg.durl,
-		DiscoveryProxy:  cfg.dproxy,
-		NewCluster:      cfg.isNewCluster(),
-		ForceNewCluster: cfg.forceNewCluster,
-		Transport:       pt,
-		TickMs:          cfg.TickMs,
-		ElectionTicks:   cfg.electionTicks(),
+		Name:             cfg.name,
+		Labels:           cfg.labels,
+		ClientURLs:       cfg.acurls,
+		PeerURLs:         cfg.apurls,
+		DataDir:          cfg.dir,
+		DedicatedWALDir:  cfg.walDir,
+		SnapCount:        cfg.snapCount,
+		MaxSnapFiles:     cfg.maxSnapFiles,
+		MaxWALFiles:      cfg.maxWalFiles,
+		Cluster:          cls,
+		DiscoveryURL:     cfg.durl,
+		DiscoveryProxy:   cfg.dproxy,
+		NewCluster:       cfg.isNewCluster(),
+		ForceNewCluster:  cfg.forceNewCluster,
+		RestoreSnapshot:  cfg.restoreSnapshot,
+		Transport:        pt,
+		TickMs:           cfg.TickMs,
+		ElectionTicks:    cfg.electionTicks(),
+		StoreBackend:     cfg.storeBackend.String(),
+		SnapshotFormat:   cfg.snapshotFormat.String(),
+		WatchHistorySize: cfg.historySize,
+
+		ClientCertAuthEnabled:   cfg.clientTLSInfo.ClientCertAuth,
+		QuotaBackendBytes:       cfg.quotaBytes,
+		MinFreeDiskBytes:        cfg.minFreeDisk,
+		FDWarnPercent:           cfg.fdWarn,
+		FDShedWatchPercent:      cfg.fdShedWatch,
+		MaxValueBytes:           cfg.maxValueBytes,
+		MaxRequestBytes:         cfg.maxReqBytes,
+		MaxInflightProposals:    cfg.maxInflight,
+		SlowRequestThreshold:    time.Duration(cfg.slowRequestMs) * time.Millisecond,
+		HashCheckInterval:       time.Duration(cfg.hashCheckMs) * time.Millisecond,
+		AutoCompactionRetention: cfg.retention,
+		SyncInterval:            time.Duration(cfg.syncMs) * time.Millisecond,
+		WALSyncWindow:           time.Duration(cfg.walSyncMs) * time.Millisecond,
+		RepairWAL:               cfg.repairWAL,
+		SnapCatchUpEntries:      cfg.catchUpEntries,
+		MaxSizePerMsg:           cfg.maxMsgSize,
+		MaxInflightMsgs:         cfg.maxInflightMsg,
+		PreferredLeaderZone:     cfg.leaderZone,
+		PreVote:                 cfg.preVote,
+		WALStorage:              cfg.walStorage,
+		AuditLogPath:            cfg.auditLog,
+		AuditLogMaxBytes:        cfg.auditMaxBytes,
+		AuditLogMaxFiles:        cfg.auditMaxFiles,
+		PeerCompression:         cfg.peerCompression.String(),
+		PeerDialTimeout:         cfg.peerDialTimeout(),
+		PeerReadTimeout:         cfg.peerReadTimeout(),
+		PeerWriteTimeout:        cfg.peerWriteTimeout(),
+		SnapshotSendRate:        cfg.snapshotSendRate,
+	}
+	if srvcfg.Encryption, err = encryptionProvider(cfg); err != nil {
+		return nil, err
 	}
 	var s *etcdserver.EtcdServer
 	s, err = etcdserver.NewServer(srvcfg)
@@ -192,12 +267,28 @@ func startEtcd(cfg *config) (<-chan struct{}, error) {
 	if cfg.corsInfo.String() != "" {
 		log.Printf("etcd: cors = %s", cfg.corsInfo)
 	}
+	// the followers forward the writes to the client URLs of the leader
+	ct, err := transport.NewTransport(cfg.clientTLSInfo)
+	if err != nil {
+		return nil, err
+	}
 	//http协议的client handler 和peer handler
-	ch := &cors.CORSHandler{
-		Handler: etcdhttp.NewClientHandler(s),
+	var ch http.Handler = &cors.CORSHandler{
+		Handler: etcdhttp.NewForwardHandler(etcdhttp.NewClientHandler(s), s, ct),
 		Info:    cfg.corsInfo,
 	}
-	ph := etcdhttp.NewPeerHandler(s.Cluster, etcdserver.RaftTimer(s), s.RaftHandler())
+	mh := etcdhttp.NewMetricsHandler()
+	// the profiles are kept off the client URLs if there is a listener
+	// dedicated to metrics and debugging
+	switch {
+	case cfg.enablePprof && len(mlns) > 0:
+		log.Printf("etcd: pprof is enabled under /debug/pprof on the metrics URLs")
+		mh = etcdhttp.NewPprofHandler(mh)
+	case cfg.enablePprof:
+		log.Printf("etcd: pprof is enabled under /debug/pprof on the client URLs")
+		ch = etcdhttp.NewPprofHandler(ch)
+	}
+	ph := etcdhttp.NewPeerHandler(s.Cluster, etcdserver.RaftTimer(s), s, s.RaftHandler())
 	// Start the peer server in a goroutine
 	// 处理peer节点之间的请求
 	for _, l := range plns {
@@ -207,10 +298,23 @@ func startEtcd(cfg *config) (<-chan struct{}, error) {
 	}
 	// Start a client server goroutine for each listen address
 	for _, l := range clns {
+		// the watch connections are limited per listener
+		lh := etcdhttp.NewWatchLimitHandler(ch, s, cfg.maxWatchConns, cfg.maxWatchPerIP)
 		go func(l net.Listener) {
 			// read timeout does not work with http close notify
 			// TODO: https://github.com/golang/go/issues/9524
-			log.Fatal(serveHTTP(l, ch, 0))
+			log.Fatal(serveHTTP(l, lh, 0))
+		}(l)
+	}
+	gs := api.NewServer(s)
+	for _, l := range glns {
+		go func(l net.Listener) {
+			log.Fatal(gs.Serve(l))
+		}(l)
+	}
+	for _, l := range mlns {
+		go func(l net.Listener) {
+			log.Fatal(serveHTTP(l, mh, 0))
 		}(l)
 	}
 	return s.StopNotify(), nil
@@ -250,18 +354,18 @@ func startProxy(cfg *config) error {
 		return err
 	}
 
-	var peerURLs []string
+	var peerURLs, clientURLs []string
 	clusterfile := path.Join(cfg.dir, "cluster")
 
 	b, err := ioutil.ReadFile(clusterfile)
 	switch {
 	case err == nil:
-		urls := struct{ PeerURLs []string }{}
+		urls := struct{ PeerURLs, ClientURLs []string }{}
 		err := json.Unmarshal(b, &urls)
 		if err != nil {
 			return err
 		}
-		peerURLs = urls.PeerURLs
+		peerURLs, clientURLs = urls.PeerURLs, urls.ClientURLs
 		log.Printf("proxy: using peer urls %v from cluster file ./%s", peerURLs, clusterfile)
 	case os.IsNotExist(err):
 		peerURLs = cls.PeerURLs()
@@ -272,6 +376,13 @@ func startProxy(cfg *config) error {
 
 	uf := func() []string {
 		gcls, err := etcdserver.GetClusterFromRemotePeers(peerURLs, tr)
+		if err != nil && len(clientURLs) != 0 {
+			// the proxy may only reach the client urls of the members,
+			// e.g. when it runs on an application host.
+			// 无法访问peer url时，通过client url的/v2/members获取members
+			log.Printf("proxy: %v, falling back to client urls %v", err, clientURLs)
+			gcls, err = etcdserver.GetClusterFromRemoteClients(clientURLs, pt)
+		}
 		// TODO: remove the 2nd check when we fix GetClusterFromPeers
 		// GetClusterFromPeers should not return nil error with an invaild empty cluster
 		if err != nil {
@@ -283,7 +394,7 @@ func startProxy(cfg *config) error {
 		}
 		cls = gcls
 
-		urls := struct{ PeerURLs []string }{cls.PeerURLs()}
+		urls := struct{ PeerURLs, ClientURLs []string }{cls.PeerURLs(), cls.ClientURLs()}
 		b, err := json.Marshal(urls)
 		if err != nil {
 			log.Printf("proxy: error on marshal peer urls %s", err)
@@ -304,10 +415,11 @@ func startProxy(cfg *config) error {
 			log.Printf("proxy: updated peer urls in cluster file from %v to %v", peerURLs, cls.PeerURLs())
 		}
 		peerURLs = cls.PeerURLs()
+		clientURLs = cls.ClientURLs()
 
 		return cls.ClientURLs()
 	}
-	ph := proxy.NewHandler(pt, uf)
+	ph := proxy.NewHandler(pt, uf, time.Duration(cfg.proxyRefreshInterval)*time.Millisecond)
 	ph = &cors.CORSHandler{
 		Handler: ph,
 		Info:    cfg.corsInfo,
@@ -339,6 +451,119 @@ func startProxy(cfg *config) error {
 	return nil
 }
 
+// startStandby launches a hot standby of the cluster, which starts as a
+// member on the same listeners once it is promoted.
+// 启动热备节点，被提升后以member模式启动
+func startStandby(cfg *config) (<-chan struct{}, error) {
+	cls, err := setupCluster(cfg)
+	if err != nil {
+		return nil, fmt.Errorf("error setting up initial cluster: %v", err)
+	}
+
+	if cfg.durl != "" {
+		s, err := discovery.GetCluster(cfg.durl, cfg.dproxy)
+		if err != nil {
+			return nil, err
+		}
+		if cls, err = etcdserver.NewClusterFromString(cfg.durl, s); err != nil {
+			return nil, err
+		}
+	}
+
+	pt, err := transport.NewTransport(cfg.clientTLSInfo)
+	if err != nil {
+		return nil, err
+	}
+
+	tr, err := transport.NewTransport(cfg.peerTLSInfo)
+	if err != nil {
+		return nil, err
+	}
+
+	var mu sync.Mutex
+	uf := func() []string {
+		mu.Lock()
+		defer mu.Unlock()
+		gcls, err := etcdserver.GetClusterFromRemotePeers(cls.PeerURLs(), tr)
+		if err != nil {
+			log.Printf("standby: %v", err)
+			return cls.ClientURLs()
+		}
+		cls = gcls
+		return cls.ClientURLs()
+	}
+	ep, err := encryptionProvider(cfg)
+	if err != nil {
+		return nil, err
+	}
+	sb := standby.New(standby.Config{
+		PeerURLs:     types.URLs(cfg.apurls).StringSlice(),
+		DataDir:      cfg.dir,
+		WALDir:       cfg.walDir,
+		Transport:    pt,
+		ClientURLs:   uf,
+		SyncInterval: time.Duration(cfg.standbySyncInterval) * time.Millisecond,
+		Encryption:   ep,
+	})
+
+	var lns []net.Listener
+	for _, u := range cfg.lcurls {
+		l, err := transport.NewListener(u.Host, u.Scheme, cfg.clientTLSInfo)
+		if err != nil {
+			for _, ln := range lns {
+				ln.Close()
+			}
+			return nil, err
+		}
+		lns = append(lns, l)
+	}
+	closing := make(chan struct{})
+	for _, l := range lns {
+		l := l
+		go func() {
+			log.Print("standby: listening for client requests on ", l.Addr().String())
+			err := http.Serve(l, sb.Handler())
+			select {
+			case <-closing:
+			default:
+				log.Fatal(err)
+			}
+		}()
+	}
+	go sb.Run()
+
+	stopped := make(chan struct{})
+	go func() {
+		<-sb.Promoted()
+		sb.Stop()
+		close(closing)
+		for _, l := range lns {
+			l.Close()
+		}
+		log.Printf("etcd: starting promoted standby as a member")
+		s, err := startEtcd(cfg)
+		if err != nil {
+			log.Fatalf("etcd: %v", err)
+		}
+		<-s
+		close(stopped)
+	}()
+	return stopped, nil
+}
+
+// encryptionProvider returns the provider the member dir is encrypted
+// with, or nil if the encryption at rest is disabled.
+func encryptionProvider(cfg *config) (encryption.Provider, error) {
+	if cfg.encryptionKey == "" {
+		return nil, nil
+	}
+	p, err := encryption.NewAESGCMFromFile(cfg.encryptionKey)
+	if err != nil {
+		return nil, fmt.Errorf("cannot load encryption key %s: %v", cfg.encryptionKey, err)
+	}
+	return p, nil
+}
+
 // setupCluster sets up an initial cluster definition for bootstrap or discovery.
 func setupCluster(cfg *config) (*etcdserver.Cluster, error) {
 	var cls *etcdserver.Cluster
@@ -367,8 +592,8 @@ func setupCluster(cfg *config) (*etcdserver.Cluster, error) {
 }
 
 // clusterString是由clustername和peer节点的url组成的字符串
-//name:集群名，urls：通知对等节点的urls。
-//例如：clst0=http://127.0.0.1:2222,clst0=http://127.0.0.2:3333
+// name:集群名，urls：通知对等节点的urls。
+// 例如：clst0=http://127.0.0.1:2222,clst0=http://127.0.0.2:3333
 func genClusterString(name string, urls types.URLs) string {
 	addrs := make([]string, 0)
 	for _, u := range urls {
@@ -29,18 +29,126 @@ member flags:
 
 	--name 'default'
 		human-readable name for this member.
+	--labels ''
+		comma-separated key=value labels of this member, e.g. zone=us-east-1a,rack=r1.
 	--data-dir '${name}.etcd'
 		path to the data directory.
+	--wal-dir ''
+		path to the dedicated wal directory.
 	--snapshot-count '10000'
 		number of committed transactions to trigger a snapshot to disk.
+	--snapshot-catchup-entries '5000'
+		number of entries kept before a snapshot when the raft log is
+		compacted, for the slow followers to catch up from. More entries
+		suit the slow links, fewer save memory.
+	--raft-max-size-per-msg '1048576'
+		max size in bytes of a raft append message.
+	--raft-max-inflight-msgs '512'
+		max number of the raft append messages in flight to a follower. The
+		links of a high latency or bandwidth may need more.
+	--preferred-leader-zone ''
+		zone the leader is preferred in. The members whose zone label is
+		another zone wait about another election timeout before campaigning.
+	--raft-pre-vote 'false'
+		enable the raft Pre-Vote, so that a rejoining member does not disrupt
+		the leader. It is enabled once the cluster version is 2.1 or later.
 	--heartbeat-interval '100'
 		time (in milliseconds) of a heartbeat interval.
 	--election-timeout '1000'
 		time (in milliseconds) for an election to timeout.
+	--store-backend 'v2'
+		key-value store backend, 'v2', 'mvcc' or 'bolt'. The mvcc backend
+		keeps every revision of the keys until it is compacted. The bolt
+		backend keeps the keys on disk under the member directory.
+	--snapshot-format 'json'
+		format of the store in the snapshots, 'json' or 'gob'. The gob
+		format is smaller and faster to recover from, but only the members
+		of this version can load it.
+	--watch-history-size '1000'
+		number of events kept for watchers to resume from.
+	--quota-backend-bytes '0'
+		raise the NOSPACE alarm when the store exceeds the given size in
+		bytes. 0 uses the default quota of 2GB, a negative value disables it.
+	--min-free-disk-bytes '0'
+		reject the client writes and raise the LOWDISK alarm while the
+		filesystem of the WAL or the snapshots has less free space in bytes.
+		0 uses the default of 256MB, a negative value disables the check.
+	--max-value-bytes '0'
+		reject the client writes of a value larger than the given size in
+		bytes with 413. 0 uses the default limit of 1MB, a negative value
+		disables it.
+	--max-request-bytes '0'
+		reject the client writes larger than the given size in bytes with
+		413. 0 uses the default limit of 1.5MB, a negative value disables it.
+	--max-inflight-proposals '0'
+		reject the client writes with 429 when the given number of proposals
+		are in flight. 0 uses the default limit of 5000, a negative value
+		disables it.
+	--fd-warn-percent '0'
+		log the file descriptor usage above the given percent of the limit.
+		0 uses the default of 80, a negative value disables the log.
+	--fd-shed-watch-percent '0'
+		reject the new watches with 503 above the given percent of the file
+		descriptor limit. 0 uses the default of 90, a negative value
+		disables it.
+	--max-watch-connections '10000'
+		reject the watches with 429 when the given number of watch
+		connections are served on a client listener. 0 disables the limit.
+	--max-watch-connections-per-ip '0'
+		reject the watches with 429 when the given number of watch
+		connections from the same IP are served on a client listener. 0
+		disables the limit.
+	--slow-request-threshold '500'
+		time (in milliseconds) a write may take before it is logged as slow,
+		with the time spent proposing, committing and applying it. 0 disables
+		the log.
+	--hash-check-interval '0'
+		time (in milliseconds) of the interval at which the leader compares
+		the store hashes of the members and raises the CORRUPT alarm for the
+		diverged ones. 0 disables the check.
+	--auto-compaction-retention '0'
+		time the watch history and the store revisions are kept for, e.g.
+		1h. The older ones are compacted, and the raft log with them.
+		0 disables the auto-compaction.
+	--sync-interval '0'
+		time (in milliseconds) of the interval at which the leader expires
+		the TTL keys. 0 uses the default interval of 500ms, a negative value
+		disables the expiration on the leader.
+	--wal-sync-window '0'
+		time (in milliseconds) a save to the WAL waits for other saves to
+		share its fsync. 0 fsyncs every save immediately.
+	--encryption-key-file ''
+		path to the file holding the hex-encoded AES key the WAL and the
+		snapshot files are encrypted with.
+	--repair-wal 'false'
+		discard the torn last record of the WAL, e.g. after a power loss,
+		instead of refusing to start.
+	--wal-storage 'false'
+		read the raft log back from the WAL, caching only the latest entries
+		in memory, instead of holding the whole uncompacted log in memory.
+	--audit-log ''
+		path to the file the applied writes are appended to, one JSON record
+		per line. Empty disables the audit log.
+	--audit-log-max-bytes '0'
+		size in bytes the audit log is rotated at. 0 uses the default of
+		100MB, a negative value never rotates it.
+	--audit-log-max-files '0'
+		number of the rotated audit logs kept. 0 uses the default of 5, a
+		negative value keeps none.
 	--listen-peer-urls 'http://localhost:2380,http://localhost:7001'
 		list of URLs to listen on for peer traffic.
 	--listen-client-urls 'http://localhost:2379,http://localhost:4001'
-		list of URLs to listen on for client traffic.
+		list of URLs to listen on for client traffic. The unix and unixs
+		URLs, like 'unix://localhost:2379', listen on the unix domain
+		socket named by their host, in the working directory; so do the
+		peer URLs.
+	--listen-metrics-urls ''
+		list of additional URLs to listen on for the metrics, expvar and
+		pprof requests, e.g. on localhost or an ops network only.
+	--enable-pprof 'false'
+		serve the runtime profiles of the process, e.g. CPU, heap and
+		goroutines, under /debug/pprof on the metrics URLs, or on the client
+		URLs if no metrics URL is given.
 	-cors ''
 		comma-separated whitelist of origins for CORS (cross-origin resource sharing).
 
@@ -55,6 +163,26 @@ clustering flags:
 		initial cluster state ('new' or 'existing').
 	--initial-cluster-token 'etcd-cluster'
 		initial cluster token for the etcd cluster during bootstrap.
+	--restore-snapshot ''
+		path to a snapshot file to bootstrap a new single-member cluster from.
+	--peer-compression 'none'
+		compression of the raft streams sent to the peers ('none' or 'gzip'),
+		for the clusters replicating large values across slow links. The
+		peers of an older version are sent the streams uncompressed.
+	--peer-dial-timeout '1000'
+		time (in milliseconds) for dialing a peer to timeout.
+	--peer-read-timeout '5000'
+		time (in milliseconds) for a read on a peer connection to timeout.
+		All the members should use the same read timeout, as the streams
+		are kept alive in a third of the timeout of the reading member.
+	--peer-write-timeout '5000'
+		time (in milliseconds) for a write on a peer connection to timeout.
+		Congested networks may need the peer timeouts raised, when the
+		peers keep being reported unreachable.
+	--snapshot-send-rate '0'
+		max bytes per second of the snapshots sent to the peers (0 is
+		unlimited), so sending a large snapshot to a new member does not
+		starve the heartbeats and the appends on the same link.
 	--advertise-client-urls 'http://localhost:2379,http://localhost:4001'
 		list of this member's client URLs to advertise to the rest of the cluster.
 	--discovery ''
@@ -71,6 +199,16 @@ proxy flags:
 
 	--proxy 'off'
 		proxy mode setting ('off', 'readonly' or 'on').
+	--proxy-refresh-interval 30000
+		time (in milliseconds) of the endpoints refresh interval.
+
+
+standby flags:
+
+	--standby 'false'
+		run as a hot standby that replicates the store without joining the cluster until promoted.
+	--standby-sync-interval 30000
+		time (in milliseconds) between the snapshots pulled by a standby.
 
 
 security flags:
@@ -0,0 +1,257 @@
+// Copyright 2015 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package etcdserver
+
+import (
+	"log"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/coreos/etcd/Godeps/_workspace/src/golang.org/x/net/context"
+	pb "github.com/coreos/etcd/etcdserver/etcdserverpb"
+	"github.com/coreos/etcd/pkg/types"
+	"github.com/coreos/etcd/store"
+)
+
+// AlarmType is the kind of a cluster-wide alarm.
+type AlarmType string
+
+const (
+	// AlarmNoSpace is raised by a member whose store exceeds the quota.
+	// While it is active, the cluster rejects the writes except deletes.
+	AlarmNoSpace AlarmType = "NOSPACE"
+	// AlarmCorrupt is raised by the leader for a member whose store
+	// diverges from its own. While it is active, the cluster rejects the
+	// requests to the keys.
+	AlarmCorrupt AlarmType = "CORRUPT"
+	// AlarmLowDisk is raised by a member whose filesystem of the WAL or
+	// the snapshots has less free space than the minimum. It only tells
+	// the operator: the member itself rejects the proposals of its
+	// clients, and clears the alarm once the space is freed.
+	AlarmLowDisk AlarmType = "LOWDISK"
+
+	// raiseAlarmTimeout is the time to wait for an alarm to be committed.
+	raiseAlarmTimeout = 5 * time.Second
+)
+
+var storeAlarmsPrefix = path.Join(StoreAdminPrefix, "alarms")
+
+// Valid reports whether the alarm type is known.
+func (t AlarmType) Valid() bool {
+	switch t {
+	case AlarmNoSpace, AlarmCorrupt, AlarmLowDisk:
+		return true
+	default:
+		return false
+	}
+}
+
+// Alarm is an alarm raised by a member.
+type Alarm struct {
+	MemberID types.ID  `json:"memberID"`
+	Type     AlarmType `json:"alarm"`
+}
+
+// Alarmer lists and clears the alarms of the cluster.
+type Alarmer interface {
+	// Alarms returns the active alarms.
+	Alarms() []Alarm
+	// DisarmAlarm clears the alarms of the given type raised by all
+	// the members.
+	DisarmAlarm(ctx context.Context, t AlarmType) error
+}
+
+// alarmStorePath returns the store path of the alarm of the given type
+// raised by the member. The alarms are kept in the store, so they go
+// through raft and are recovered from the snapshots.
+// 告警保存在store里，通过raft同步到所有member
+func alarmStorePath(t AlarmType, id types.ID) string {
+	return path.Join(storeAlarmsPrefix, string(t), id.String())
+}
+
+// alarmSet keeps the active alarms of the cluster in memory, so that the
+// requests can be checked against them without reading the store. The
+// zero value is an empty set.
+type alarmSet struct {
+	mu     sync.RWMutex
+	alarms map[AlarmType]map[types.ID]bool
+	// raising holds the alarms being proposed by the local member.
+	raising map[Alarm]bool
+}
+
+// recover reloads the alarms from the store.
+func (as *alarmSet) recover(st store.Store) {
+	alarms := alarmsFromStore(st)
+	as.mu.Lock()
+	defer as.mu.Unlock()
+	as.alarms = alarms
+}
+
+// active reports whether any member has raised the alarm of the given type.
+func (as *alarmSet) active(t AlarmType) bool {
+	as.mu.RLock()
+	defer as.mu.RUnlock()
+	return len(as.alarms[t]) != 0
+}
+
+// raised reports whether the alarm of the given type is active for the
+// given member.
+func (as *alarmSet) raised(t AlarmType, id types.ID) bool {
+	as.mu.RLock()
+	defer as.mu.RUnlock()
+	return as.alarms[t][id]
+}
+
+func (as *alarmSet) list() []Alarm {
+	as.mu.RLock()
+	defer as.mu.RUnlock()
+	var alarms []Alarm
+	for t, ids := range as.alarms {
+		for id := range ids {
+			alarms = append(alarms, Alarm{MemberID: id, Type: t})
+		}
+	}
+	sort.Sort(alarmsByTypeAndID(alarms))
+	return alarms
+}
+
+// startRaise reports whether the local member should propose the alarm
+// for the given member. It returns false if the alarm has been raised
+// already, or is being proposed. finishRaise must be called after a
+// proposal.
+func (as *alarmSet) startRaise(t AlarmType, id types.ID) bool {
+	as.mu.Lock()
+	defer as.mu.Unlock()
+	a := Alarm{MemberID: id, Type: t}
+	if as.alarms[t][id] || as.raising[a] {
+		return false
+	}
+	if as.raising == nil {
+		as.raising = make(map[Alarm]bool)
+	}
+	as.raising[a] = true
+	return true
+}
+
+func (as *alarmSet) finishRaise(t AlarmType, id types.ID) {
+	as.mu.Lock()
+	defer as.mu.Unlock()
+	delete(as.raising, Alarm{MemberID: id, Type: t})
+}
+
+func alarmsFromStore(st store.Store) map[AlarmType]map[types.ID]bool {
+	alarms := make(map[AlarmType]map[types.ID]bool)
+	e, err := st.Get(storeAlarmsPrefix, true, true)
+	if err != nil {
+		if isKeyNotFound(err) {
+			return alarms
+		}
+		log.Panicf("get storeAlarms should never fail: %v", err)
+	}
+	for _, tn := range e.Node.Nodes {
+		t := AlarmType(path.Base(tn.Key))
+		for _, n := range tn.Nodes {
+			id, err := types.IDFromString(path.Base(n.Key))
+			if err != nil {
+				log.Panicf("unexpected alarm key %s: %v", n.Key, err)
+			}
+			if alarms[t] == nil {
+				alarms[t] = make(map[types.ID]bool)
+			}
+			alarms[t][id] = true
+		}
+	}
+	return alarms
+}
+
+type alarmsByTypeAndID []Alarm
+
+func (a alarmsByTypeAndID) Len() int      { return len(a) }
+func (a alarmsByTypeAndID) Swap(i, j int) { a[i], a[j] = a[j], a[i] }
+func (a alarmsByTypeAndID) Less(i, j int) bool {
+	if a[i].Type != a[j].Type {
+		return a[i].Type < a[j].Type
+	}
+	return a[i].MemberID < a[j].MemberID
+}
+
+// Alarms returns the active alarms of the cluster.
+func (s *EtcdServer) Alarms() []Alarm { return s.Cluster.Alarms() }
+
+// DisarmAlarm clears the alarms of the given type through consensus.
+// It returns nil if no such alarm is active, or ErrUnknownAlarm if the
+// type is not valid.
+func (s *EtcdServer) DisarmAlarm(ctx context.Context, t AlarmType) error {
+	if !t.Valid() {
+		return ErrUnknownAlarm
+	}
+	req := pb.Request{
+		Method:    "DELETE",
+		Path:      path.Join(storeAlarmsPrefix, string(t)),
+		Dir:       true,
+		Recursive: true,
+	}
+	_, err := s.Do(ctx, req)
+	if err != nil && !isKeyNotFound(err) {
+		return err
+	}
+	log.Printf("etcdserver: disarmed %s alarm", t)
+	return nil
+}
+
+// raiseAlarm proposes the alarm of the given type for the given member,
+// unless it has been raised already.
+func (s *EtcdServer) raiseAlarm(t AlarmType, id types.ID) {
+	if !s.Cluster.alarms.startRaise(t, id) {
+		return
+	}
+	defer s.Cluster.alarms.finishRaise(t, id)
+
+	req := pb.Request{
+		Method: "PUT",
+		Path:   alarmStorePath(t, id),
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), raiseAlarmTimeout)
+	defer cancel()
+	if _, err := s.Do(ctx, req); err != nil {
+		log.Printf("etcdserver: failed to raise %s alarm for %s: %v", t, id, err)
+		return
+	}
+	log.Printf("etcdserver: raised %s alarm for %s", t, id)
+}
+
+// clearAlarm clears the alarm of the given type for the given member
+// through consensus.
+func (s *EtcdServer) clearAlarm(t AlarmType, id types.ID) {
+	req := pb.Request{
+		Method: "DELETE",
+		Path:   alarmStorePath(t, id),
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), raiseAlarmTimeout)
+	defer cancel()
+	if _, err := s.Do(ctx, req); err != nil && !isKeyNotFound(err) {
+		log.Printf("etcdserver: failed to clear %s alarm for %s: %v", t, id, err)
+		return
+	}
+	log.Printf("etcdserver: cleared %s alarm for %s", t, id)
+}
+
+// isAlarmRequest reports whether the request changes the alarms.
+func isAlarmRequest(r pb.Request) bool {
+	return r.Path == storeAlarmsPrefix || strings.HasPrefix(r.Path, storeAlarmsPrefix+"/")
+}
@@ -0,0 +1,212 @@
+// Copyright 2015 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package api serves the v3 KV API of the etcd server over gRPC. The gRPC
+// connections share the plain client listeners with the v2 HTTP API.
+package api
+
+import (
+	"time"
+
+	"github.com/coreos/etcd/Godeps/_workspace/src/golang.org/x/net/context"
+	"github.com/coreos/etcd/Godeps/_workspace/src/google.golang.org/grpc"
+	"github.com/coreos/etcd/Godeps/_workspace/src/google.golang.org/grpc/codes"
+	etcdErr "github.com/coreos/etcd/error"
+	"github.com/coreos/etcd/etcdserver"
+	"github.com/coreos/etcd/etcdserver/api/kvpb"
+	"github.com/coreos/etcd/etcdserver/security"
+)
+
+const (
+	// time to wait for a request to go through raft, the same as the v2
+	// HTTP API.
+	defaultServerTimeout = 5*time.Second + 10*(100*time.Millisecond)
+)
+
+var (
+	errEmptyKey        = grpc.Errorf(codes.InvalidArgument, "api: key is not provided")
+	errNegativeLimit   = grpc.Errorf(codes.InvalidArgument, "api: limit is negative")
+	errInvalidUnion    = grpc.Errorf(codes.InvalidArgument, "api: a request union must hold exactly one request")
+	errSecurityEnabled = grpc.Errorf(codes.PermissionDenied, "api: the KV API is not served while security is enabled")
+)
+
+// RaftKV is the part of the etcd server the KV service is built on.
+type RaftKV interface {
+	Range(ctx context.Context, r *kvpb.RangeRequest) (*kvpb.RangeResponse, error)
+	Put(ctx context.Context, r *kvpb.PutRequest) (*kvpb.PutResponse, error)
+	DeleteRange(ctx context.Context, r *kvpb.DeleteRangeRequest) (*kvpb.DeleteRangeResponse, error)
+	Txn(ctx context.Context, r *kvpb.TxnRequest) (*kvpb.TxnResponse, error)
+}
+
+type securityStore interface {
+	SecurityEnabled() bool
+}
+
+// NewServer returns a gRPC server with the KV service of the etcd server.
+func NewServer(s *etcdserver.EtcdServer) *grpc.Server {
+	g := grpc.NewServer()
+	kvpb.RegisterKVServer(g, NewKVServer(s, security.NewStore(s, defaultServerTimeout)))
+	return g
+}
+
+// NewKVServer returns the KV service of kv. The keys of the v3 API have no
+// permissions yet, so the service refuses every request while security is
+// enabled.
+func NewKVServer(kv RaftKV, sec securityStore) kvpb.KVServer {
+	return &kvServer{kv: kv, sec: sec}
+}
+
+type kvServer struct {
+	kv  RaftKV
+	sec securityStore
+}
+
+func (ks *kvServer) Range(ctx context.Context, r *kvpb.RangeRequest) (*kvpb.RangeResponse, error) {
+	if err := ks.checkRange(r); err != nil {
+		return nil, err
+	}
+	ctx, cancel := context.WithTimeout(ctx, defaultServerTimeout)
+	defer cancel()
+	resp, err := ks.kv.Range(ctx, r)
+	if err != nil {
+		return nil, togRPCError(err)
+	}
+	return resp, nil
+}
+
+func (ks *kvServer) Put(ctx context.Context, r *kvpb.PutRequest) (*kvpb.PutResponse, error) {
+	if err := ks.checkPut(r); err != nil {
+		return nil, err
+	}
+	ctx, cancel := context.WithTimeout(ctx, defaultServerTimeout)
+	defer cancel()
+	resp, err := ks.kv.Put(ctx, r)
+	if err != nil {
+		return nil, togRPCError(err)
+	}
+	return resp, nil
+}
+
+func (ks *kvServer) DeleteRange(ctx context.Context, r *kvpb.DeleteRangeRequest) (*kvpb.DeleteRangeResponse, error) {
+	if err := ks.checkDeleteRange(r); err != nil {
+		return nil, err
+	}
+	ctx, cancel := context.WithTimeout(ctx, defaultServerTimeout)
+	defer cancel()
+	resp, err := ks.kv.DeleteRange(ctx, r)
+	if err != nil {
+		return nil, togRPCError(err)
+	}
+	return resp, nil
+}
+
+func (ks *kvServer) Txn(ctx context.Context, r *kvpb.TxnRequest) (*kvpb.TxnResponse, error) {
+	if err := ks.checkTxn(r); err != nil {
+		return nil, err
+	}
+	ctx, cancel := context.WithTimeout(ctx, defaultServerTimeout)
+	defer cancel()
+	resp, err := ks.kv.Txn(ctx, r)
+	if err != nil {
+		return nil, togRPCError(err)
+	}
+	return resp, nil
+}
+
+func (ks *kvServer) checkRange(r *kvpb.RangeRequest) error {
+	if ks.sec.SecurityEnabled() {
+		return errSecurityEnabled
+	}
+	if len(r.Key) == 0 {
+		return errEmptyKey
+	}
+	if r.Limit < 0 {
+		return errNegativeLimit
+	}
+	return nil
+}
+
+func (ks *kvServer) checkPut(r *kvpb.PutRequest) error {
+	if ks.sec.SecurityEnabled() {
+		return errSecurityEnabled
+	}
+	if len(r.Key) == 0 {
+		return errEmptyKey
+	}
+	return nil
+}
+
+func (ks *kvServer) checkDeleteRange(r *kvpb.DeleteRangeRequest) error {
+	if ks.sec.SecurityEnabled() {
+		return errSecurityEnabled
+	}
+	if len(r.Key) == 0 {
+		return errEmptyKey
+	}
+	return nil
+}
+
+func (ks *kvServer) checkTxn(r *kvpb.TxnRequest) error {
+	if ks.sec.SecurityEnabled() {
+		return errSecurityEnabled
+	}
+	for _, c := range r.Compare {
+		if len(c.Key) == 0 {
+			return errEmptyKey
+		}
+	}
+	for _, u := range append(r.Success, r.Failure...) {
+		var err error
+		switch {
+		case u.RequestRange != nil && u.RequestPut == nil && u.RequestDeleteRange == nil:
+			err = ks.checkRange(u.RequestRange)
+		case u.RequestPut != nil && u.RequestRange == nil && u.RequestDeleteRange == nil:
+			err = ks.checkPut(u.RequestPut)
+		case u.RequestDeleteRange != nil && u.RequestRange == nil && u.RequestPut == nil:
+			err = ks.checkDeleteRange(u.RequestDeleteRange)
+		default:
+			err = errInvalidUnion
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// togRPCError converts the error of the etcd server into a gRPC status
+// error with the matching code.
+func togRPCError(err error) error {
+	switch err {
+	case etcdserver.ErrValueTooLarge, etcdserver.ErrRequestTooLarge, etcdserver.ErrInvalidKVRequest:
+		return grpc.Errorf(codes.InvalidArgument, "%s", err)
+	case etcdserver.ErrNoSpace, etcdserver.ErrLowDiskSpace, etcdserver.ErrTooManyRequests:
+		return grpc.Errorf(codes.ResourceExhausted, "%s", err)
+	case etcdserver.ErrTimeout, etcdserver.ErrTimeoutDueToLeaderFail:
+		return grpc.Errorf(codes.DeadlineExceeded, "%s", err)
+	case etcdserver.ErrCanceled:
+		return grpc.Errorf(codes.Canceled, "%s", err)
+	case etcdserver.ErrNoLeader, etcdserver.ErrProposalDropped, etcdserver.ErrStopped, etcdserver.ErrNotCapable:
+		return grpc.Errorf(codes.Unavailable, "%s", err)
+	case etcdserver.ErrCorrupt:
+		return grpc.Errorf(codes.DataLoss, "%s", err)
+	}
+	if e, ok := err.(*etcdErr.Error); ok {
+		switch e.ErrorCode {
+		case etcdErr.EcodeInvalidField, etcdErr.EcodeRootROnly:
+			return grpc.Errorf(codes.InvalidArgument, "%s", e)
+		}
+	}
+	return grpc.Errorf(codes.Unknown, "%s", err)
+}
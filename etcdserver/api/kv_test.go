@@ -0,0 +1,185 @@
+// Copyright 2015 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package api
+
+import (
+	"testing"
+
+	"github.com/coreos/etcd/Godeps/_workspace/src/golang.org/x/net/context"
+	"github.com/coreos/etcd/Godeps/_workspace/src/google.golang.org/grpc"
+	"github.com/coreos/etcd/Godeps/_workspace/src/google.golang.org/grpc/codes"
+	etcdErr "github.com/coreos/etcd/error"
+	"github.com/coreos/etcd/etcdserver"
+	"github.com/coreos/etcd/etcdserver/api/kvpb"
+)
+
+type fakeKV struct {
+	reqs []interface{}
+	err  error
+}
+
+func (kv *fakeKV) Range(ctx context.Context, r *kvpb.RangeRequest) (*kvpb.RangeResponse, error) {
+	kv.reqs = append(kv.reqs, r)
+	return &kvpb.RangeResponse{}, kv.err
+}
+
+func (kv *fakeKV) Put(ctx context.Context, r *kvpb.PutRequest) (*kvpb.PutResponse, error) {
+	kv.reqs = append(kv.reqs, r)
+	return &kvpb.PutResponse{Header: &kvpb.ResponseHeader{Index: 1}}, kv.err
+}
+
+func (kv *fakeKV) DeleteRange(ctx context.Context, r *kvpb.DeleteRangeRequest) (*kvpb.DeleteRangeResponse, error) {
+	kv.reqs = append(kv.reqs, r)
+	return &kvpb.DeleteRangeResponse{}, kv.err
+}
+
+func (kv *fakeKV) Txn(ctx context.Context, r *kvpb.TxnRequest) (*kvpb.TxnResponse, error) {
+	kv.reqs = append(kv.reqs, r)
+	return &kvpb.TxnResponse{}, kv.err
+}
+
+type fakeSecurity bool
+
+func (s fakeSecurity) SecurityEnabled() bool { return bool(s) }
+
+func TestKVServerCheck(t *testing.T) {
+	tests := []struct {
+		sec  bool
+		call func(kvpb.KVServer) error
+
+		werr error
+	}{
+		{
+			false,
+			func(ks kvpb.KVServer) error {
+				_, err := ks.Range(context.TODO(), &kvpb.RangeRequest{Key: []byte("a")})
+				return err
+			},
+			nil,
+		},
+		{
+			false,
+			func(ks kvpb.KVServer) error { _, err := ks.Range(context.TODO(), &kvpb.RangeRequest{}); return err },
+			errEmptyKey,
+		},
+		{
+			false,
+			func(ks kvpb.KVServer) error {
+				_, err := ks.Range(context.TODO(), &kvpb.RangeRequest{Key: []byte("a"), Limit: -1})
+				return err
+			},
+			errNegativeLimit,
+		},
+		{
+			false,
+			func(ks kvpb.KVServer) error {
+				_, err := ks.Put(context.TODO(), &kvpb.PutRequest{Value: []byte("v")})
+				return err
+			},
+			errEmptyKey,
+		},
+		{
+			false,
+			func(ks kvpb.KVServer) error {
+				_, err := ks.DeleteRange(context.TODO(), &kvpb.DeleteRangeRequest{})
+				return err
+			},
+			errEmptyKey,
+		},
+		{
+			false,
+			func(ks kvpb.KVServer) error {
+				_, err := ks.Txn(context.TODO(), &kvpb.TxnRequest{Compare: []*kvpb.Compare{{}}})
+				return err
+			},
+			errEmptyKey,
+		},
+		{
+			false,
+			func(ks kvpb.KVServer) error {
+				_, err := ks.Txn(context.TODO(), &kvpb.TxnRequest{Failure: []*kvpb.RequestUnion{{}}})
+				return err
+			},
+			errInvalidUnion,
+		},
+		{
+			false,
+			func(ks kvpb.KVServer) error {
+				_, err := ks.Txn(context.TODO(), &kvpb.TxnRequest{Success: []*kvpb.RequestUnion{{
+					RequestPut:   &kvpb.PutRequest{Key: []byte("a")},
+					RequestRange: &kvpb.RangeRequest{Key: []byte("a")},
+				}}})
+				return err
+			},
+			errInvalidUnion,
+		},
+		{
+			false,
+			func(ks kvpb.KVServer) error {
+				_, err := ks.Txn(context.TODO(), &kvpb.TxnRequest{Success: []*kvpb.RequestUnion{{RequestPut: &kvpb.PutRequest{}}}})
+				return err
+			},
+			errEmptyKey,
+		},
+		{
+			true,
+			func(ks kvpb.KVServer) error {
+				_, err := ks.Put(context.TODO(), &kvpb.PutRequest{Key: []byte("a")})
+				return err
+			},
+			errSecurityEnabled,
+		},
+	}
+	for i, tt := range tests {
+		kv := &fakeKV{}
+		err := tt.call(NewKVServer(kv, fakeSecurity(tt.sec)))
+		if err != tt.werr {
+			t.Errorf("#%d: err = %v, want %v", i, err, tt.werr)
+		}
+		if tt.werr != nil && len(kv.reqs) != 0 {
+			t.Errorf("#%d: requests = %v, want none", i, kv.reqs)
+		}
+	}
+}
+
+func TestTogRPCError(t *testing.T) {
+	tests := []struct {
+		err   error
+		wcode codes.Code
+	}{
+		{etcdserver.ErrValueTooLarge, codes.InvalidArgument},
+		{etcdserver.ErrInvalidKVRequest, codes.InvalidArgument},
+		{etcdserver.ErrNoSpace, codes.ResourceExhausted},
+		{etcdserver.ErrTooManyRequests, codes.ResourceExhausted},
+		{etcdserver.ErrTimeout, codes.DeadlineExceeded},
+		{etcdserver.ErrCanceled, codes.Canceled},
+		{etcdserver.ErrNoLeader, codes.Unavailable},
+		{etcdserver.ErrProposalDropped, codes.Unavailable},
+		{etcdserver.ErrStopped, codes.Unavailable},
+		{etcdserver.ErrNotCapable, codes.Unavailable},
+		{etcdserver.ErrCorrupt, codes.DataLoss},
+		{etcdErr.NewError(etcdErr.EcodeInvalidField, "txn: overlapped key", 1), codes.InvalidArgument},
+		{etcdErr.NewError(etcdErr.EcodeRaftInternal, "", 1), codes.Unknown},
+	}
+	for i, tt := range tests {
+		err := togRPCError(tt.err)
+		if g := grpc.Code(err); g != tt.wcode {
+			t.Errorf("#%d: code = %v, want %v", i, g, tt.wcode)
+		}
+		if w := grpc.Errorf(tt.wcode, "%s", tt.err); err != w {
+			t.Errorf("#%d: err = %v, want %v", i, err, w)
+		}
+	}
+}
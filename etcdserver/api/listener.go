@@ -0,0 +1,152 @@
+// Copyright 2015 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package api
+
+import (
+	"net"
+	"sync"
+	"time"
+)
+
+const (
+	// http2Preface is the first bytes a HTTP/2 client, and so a gRPC
+	// client, sends on a new connection.
+	http2Preface = "PRI * HTTP/2.0\r\n\r\nSM\r\n\r\n"
+
+	// time to wait for the first bytes of a new connection
+	sniffTimeout = 5 * time.Second
+)
+
+// NewSplitListener splits the connections accepted by l between a gRPC
+// listener and a HTTP listener. A connection that starts with the HTTP/2
+// client preface goes to the gRPC listener, and every other connection
+// goes to the HTTP listener. The connections are read in plain text, so l
+// must not be a TLS listener.
+// 根据连接的前几个字节区分gRPC连接和HTTP连接
+func NewSplitListener(l net.Listener) (grpcl, httpl net.Listener) {
+	sl := &splitListener{
+		Listener: l,
+		grpcc:    make(chan net.Conn),
+		httpc:    make(chan net.Conn),
+		donec:    make(chan struct{}),
+	}
+	go sl.serve()
+	return &childListener{sl: sl, connc: sl.grpcc}, &childListener{sl: sl, connc: sl.httpc}
+}
+
+type splitListener struct {
+	net.Listener
+	grpcc chan net.Conn
+	httpc chan net.Conn
+
+	// err is the error that stopped the root listener. It is set before
+	// donec is closed.
+	err   error
+	donec chan struct{}
+	once  sync.Once
+}
+
+func (sl *splitListener) serve() {
+	for {
+		c, err := sl.Listener.Accept()
+		if err != nil {
+			if ne, ok := err.(net.Error); ok && ne.Temporary() {
+				// the servers return on any accept error, so retry
+				// the temporary ones here
+				time.Sleep(5 * time.Millisecond)
+				continue
+			}
+			sl.err = err
+			close(sl.donec)
+			return
+		}
+		// sniff in another goroutine so that a slow client does not
+		// block the others
+		go sl.route(c)
+	}
+}
+
+func (sl *splitListener) route(c net.Conn) {
+	buf, ok := sniff(c)
+	connc := sl.httpc
+	if ok {
+		connc = sl.grpcc
+	}
+	select {
+	case connc <- &sniffedConn{Conn: c, buf: buf}:
+	case <-sl.donec:
+		c.Close()
+	}
+}
+
+func (sl *splitListener) close() error {
+	var err error
+	sl.once.Do(func() { err = sl.Listener.Close() })
+	return err
+}
+
+// sniff reads the first bytes of c and reports whether they are the HTTP/2
+// client preface. It stops at the first byte that does not match, so a
+// HTTP/1 request is not held back. It returns the bytes read.
+func sniff(c net.Conn) ([]byte, bool) {
+	c.SetReadDeadline(time.Now().Add(sniffTimeout))
+	defer c.SetReadDeadline(time.Time{})
+	buf := make([]byte, len(http2Preface))
+	n := 0
+	for n < len(buf) {
+		m, err := c.Read(buf[n:])
+		n += m
+		if string(buf[:n]) != http2Preface[:n] || err != nil {
+			return buf[:n], false
+		}
+	}
+	return buf, true
+}
+
+// childListener accepts the connections routed to it by its split
+// listener. Closing it closes the root listener.
+type childListener struct {
+	sl    *splitListener
+	connc chan net.Conn
+}
+
+func (cl *childListener) Accept() (net.Conn, error) {
+	select {
+	case c := <-cl.connc:
+		return c, nil
+	case <-cl.sl.donec:
+		return nil, cl.sl.err
+	}
+}
+
+func (cl *childListener) Close() error { return cl.sl.close() }
+
+func (cl *childListener) Addr() net.Addr { return cl.sl.Addr() }
+
+// sniffedConn replays the sniffed bytes before reading from the
+// connection.
+type sniffedConn struct {
+	net.Conn
+	buf []byte
+}
+
+func (c *sniffedConn) Read(p []byte) (int, error) {
+	if len(c.buf) > 0 {
+		n := copy(p, c.buf)
+		c.buf = c.buf[n:]
+		return n, nil
+	}
+	return c.Conn.Read(p)
+}
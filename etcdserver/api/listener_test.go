@@ -0,0 +1,140 @@
+// Copyright 2015 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package api
+
+import (
+	"bufio"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/coreos/etcd/Godeps/_workspace/src/golang.org/x/net/context"
+	"github.com/coreos/etcd/Godeps/_workspace/src/google.golang.org/grpc"
+	"github.com/coreos/etcd/etcdserver/api/kvpb"
+)
+
+// TestSplitListener tests that the gRPC connections are served by the gRPC
+// server, and the other connections by the HTTP server.
+func TestSplitListener(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	grpcl, httpl := NewSplitListener(l)
+	defer httpl.Close()
+
+	kv := &fakeKV{}
+	g := grpc.NewServer()
+	kvpb.RegisterKVServer(g, NewKVServer(kv, fakeSecurity(false)))
+	go g.Serve(grpcl)
+	h := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("v2"))
+	})
+	go (&http.Server{Handler: h}).Serve(httpl)
+
+	resp, err := http.Get("http://" + l.Addr().String() + "/v2/keys/foo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(b) != "v2" {
+		t.Errorf("body = %q, want %q", b, "v2")
+	}
+
+	conn, err := grpc.Dial(l.Addr().String(), grpc.WithTimeout(time.Second))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	r := &kvpb.PutRequest{Key: []byte("foo"), Value: []byte("bar")}
+	presp, err := kvpb.NewKVClient(conn).Put(context.Background(), r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if presp.Header.Index != 1 {
+		t.Errorf("index = %d, want 1", presp.Header.Index)
+	}
+	if len(kv.reqs) != 1 || !reflect.DeepEqual(kv.reqs[0], r) {
+		t.Errorf("requests = %v, want [%v]", kv.reqs, r)
+	}
+}
+
+// TestSplitListenerShortRequest tests that a HTTP request shorter than the
+// HTTP/2 preface is not held back by the sniffing.
+func TestSplitListenerShortRequest(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, httpl := NewSplitListener(l)
+	defer httpl.Close()
+	h := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+	go (&http.Server{Handler: h}).Serve(httpl)
+
+	c, err := net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	// shorter than the preface, and the connection stays open
+	if _, err := c.Write([]byte("GET / HTTP/1.0\r\n\r\n")); err != nil {
+		t.Fatal(err)
+	}
+	c.SetReadDeadline(time.Now().Add(sniffTimeout / 2))
+	resp, err := http.ReadResponse(bufio.NewReader(c), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("status = %d, want %d", resp.StatusCode, http.StatusOK)
+	}
+}
+
+// TestSplitListenerClose tests that closing a split listener stops the
+// accepts of both listeners.
+func TestSplitListenerClose(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	grpcl, httpl := NewSplitListener(l)
+	errc := make(chan error, 2)
+	for _, cl := range []net.Listener{grpcl, httpl} {
+		go func(cl net.Listener) {
+			_, err := cl.Accept()
+			errc <- err
+		}(cl)
+	}
+	grpcl.Close()
+	httpl.Close()
+	for i := 0; i < 2; i++ {
+		select {
+		case err := <-errc:
+			if err == nil {
+				t.Errorf("#%d: err = nil, want error", i)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("#%d: accept is not stopped", i)
+		}
+	}
+}
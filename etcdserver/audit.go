@@ -0,0 +1,148 @@
+// Copyright 2015 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package etcdserver
+
+import (
+	"encoding/json"
+	"log"
+	"time"
+
+	pb "github.com/coreos/etcd/etcdserver/etcdserverpb"
+	"github.com/coreos/etcd/pkg/ioutil"
+)
+
+const (
+	// DefaultAuditLogMaxBytes is the default size the audit log grows to
+	// before it is rotated.
+	DefaultAuditLogMaxBytes = 100 * 1024 * 1024
+	// DefaultAuditLogMaxFiles is the default number of the rotated audit
+	// logs kept.
+	DefaultAuditLogMaxFiles = 5
+)
+
+// auditLogMaxBytes returns the size the audit log is rotated at, or zero
+// if it is never rotated.
+func (c *ServerConfig) auditLogMaxBytes() int64 {
+	switch {
+	case c.AuditLogMaxBytes == 0:
+		return DefaultAuditLogMaxBytes
+	case c.AuditLogMaxBytes < 0:
+		return 0
+	default:
+		return c.AuditLogMaxBytes
+	}
+}
+
+// auditLogMaxFiles returns the number of the rotated audit logs kept.
+func (c *ServerConfig) auditLogMaxFiles() int {
+	switch {
+	case c.AuditLogMaxFiles == 0:
+		return DefaultAuditLogMaxFiles
+	case c.AuditLogMaxFiles < 0:
+		return 0
+	default:
+		return c.AuditLogMaxFiles
+	}
+}
+
+// auditRecord is a line of the audit log.
+type auditRecord struct {
+	Time time.Time `json:"time"`
+	// Index is the raft index of the request.
+	Index  uint64 `json:"index"`
+	Method string `json:"method"`
+	Path   string `json:"path,omitempty"`
+	// MoveTo is the new path of a MOVE, and Paths the paths written by
+	// the operations of a TXN.
+	MoveTo string   `json:"moveTo,omitempty"`
+	Paths  []string `json:"paths,omitempty"`
+	// User is the user that made the request, if security is enabled.
+	User string `json:"user,omitempty"`
+	// EtcdIndex is the store index after the request, and Error the error
+	// it failed with.
+	EtcdIndex uint64 `json:"etcdIndex,omitempty"`
+	Error     string `json:"error,omitempty"`
+}
+
+// auditLog records the writes applied by the member, one JSON record per
+// line. It is only written by the applies, which run one after another.
+// 审计日志，记录member apply的每个写请求
+type auditLog struct {
+	f *ioutil.RotatingFile
+}
+
+func openAuditLog(cfg *ServerConfig) (*auditLog, error) {
+	f, err := ioutil.OpenRotatingFile(cfg.AuditLogPath, cfg.auditLogMaxBytes(), cfg.auditLogMaxFiles())
+	if err != nil {
+		return nil, err
+	}
+	return &auditLog{f: f}, nil
+}
+
+// isAuditRequest reports whether the request writes the store on behalf
+// of a client or the cluster. The SYNC requests, which expire the TTL
+// keys, and the HASH requests are not recorded.
+func isAuditRequest(r pb.Request) bool {
+	switch r.Method {
+	case "POST", "PUT", "DELETE", "INCR", "MOVE", "TXN", "IMPORT", "COMPACT":
+		return true
+	case "KV":
+		return isKVWrite(r)
+	default:
+		return false
+	}
+}
+
+// record appends the applied request r of the given raft index, with its
+// result, to the audit log. A failed write is logged, and does not stop
+// the apply.
+func (a *auditLog) record(index uint64, r pb.Request, resp Response) {
+	rec := auditRecord{
+		Time:   time.Now(),
+		Index:  index,
+		Method: r.Method,
+		Path:   r.Path,
+		MoveTo: r.MoveTo,
+		User:   r.User,
+	}
+	for _, ops := range [][]pb.Request{r.Success, r.Failure} {
+		for _, op := range ops {
+			rec.Paths = append(rec.Paths, op.Path)
+		}
+	}
+	switch {
+	case resp.err != nil:
+		rec.Error = resp.err.Error()
+	case resp.Event != nil:
+		rec.EtcdIndex = resp.Event.EtcdIndex
+	case resp.Txn != nil:
+		rec.EtcdIndex = resp.Txn.EtcdIndex
+	case resp.KV != nil:
+		rec.EtcdIndex = resp.KV.Header.Index
+	}
+	b, err := json.Marshal(rec)
+	if err != nil {
+		log.Panicf("marshal audit record should never fail: %v", err)
+	}
+	if _, err := a.f.Write(append(b, '\n')); err != nil {
+		log.Printf("etcdserver: failed to write the audit log (%v)", err)
+	}
+}
+
+func (a *auditLog) close() {
+	if err := a.f.Close(); err != nil {
+		log.Printf("etcdserver: failed to close the audit log (%v)", err)
+	}
+}
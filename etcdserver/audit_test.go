@@ -0,0 +1,138 @@
+// Copyright 2015 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package etcdserver
+
+import (
+	"bufio"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path"
+	"reflect"
+	"testing"
+
+	"github.com/coreos/etcd/etcdserver/api/kvpb"
+	pb "github.com/coreos/etcd/etcdserver/etcdserverpb"
+	"github.com/coreos/etcd/pkg/pbutil"
+	"github.com/coreos/etcd/raft/raftpb"
+	"github.com/coreos/etcd/store"
+)
+
+// TestApplyAudit tests that the applied writes are recorded in the audit
+// log with their user and result, and the reads, the read-only KV
+// requests and the SYNC requests are not.
+func TestApplyAudit(t *testing.T) {
+	dir, err := ioutil.TempDir(os.TempDir(), "audit")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	cfg := &ServerConfig{AuditLogPath: path.Join(dir, "audit.log")}
+	audit, err := openAuditLog(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	st := store.New()
+	cl := newCluster("")
+	cl.SetStore(st)
+	srv := &EtcdServer{
+		store:   st,
+		Cluster: cl,
+		w:       &waitRecorder{},
+		audit:   audit,
+	}
+	kvWrite, err := (&kvpb.TxnRequest{Success: []*kvpb.RequestUnion{kvPut("k", "v")}}).Marshal()
+	if err != nil {
+		t.Fatal(err)
+	}
+	kvRead, err := (&kvpb.TxnRequest{Success: []*kvpb.RequestUnion{{RequestRange: &kvpb.RangeRequest{Key: []byte("k")}}}}).Marshal()
+	if err != nil {
+		t.Fatal(err)
+	}
+	reqs := []pb.Request{
+		{Method: "PUT", ID: 1, Path: "/foo", Val: "bar", User: "root"},
+		{Method: "QGET", ID: 2, Path: "/foo"},
+		{Method: "POST", ID: 3, Path: "/foo", Val: "bar"},
+		{Method: "SYNC", ID: 4},
+		{Method: "TXN", ID: 5, Success: []pb.Request{{Method: "PUT", Path: "/a"}, {Method: "DELETE", Path: "/foo"}}},
+		{Method: "KV", ID: 6, Path: StoreKVPrefix, KV: kvWrite},
+		{Method: "KV", ID: 7, Path: StoreKVPrefix, KV: kvRead},
+	}
+	var ents []raftpb.Entry
+	for i := range reqs {
+		ents = append(ents, raftpb.Entry{Index: uint64(i + 1), Data: pbutil.MustMarshal(&reqs[i])})
+	}
+	srv.apply(ents, &raftpb.ConfState{})
+	audit.close()
+
+	f, err := os.Open(cfg.AuditLogPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	var recs []auditRecord
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		var rec auditRecord
+		if err := json.Unmarshal(sc.Bytes(), &rec); err != nil {
+			t.Fatal(err)
+		}
+		recs = append(recs, rec)
+	}
+	if err := sc.Err(); err != nil {
+		t.Fatal(err)
+	}
+	wrecs := []auditRecord{
+		{Index: 1, Method: "PUT", Path: "/foo", User: "root", EtcdIndex: 1},
+		{Index: 3, Method: "POST", Path: "/foo", Error: "Not a directory (/foo)"},
+		{Index: 5, Method: "TXN", Paths: []string{"/a", "/foo"}, EtcdIndex: 3},
+		{Index: 6, Method: "KV", Path: StoreKVPrefix, EtcdIndex: 4},
+	}
+	if len(recs) != len(wrecs) {
+		t.Fatalf("len(records) = %d, want %d: %+v", len(recs), len(wrecs), recs)
+	}
+	for i := range recs {
+		if recs[i].Time.IsZero() {
+			t.Errorf("#%d: time is zero", i)
+		}
+		recs[i].Time = wrecs[i].Time
+		if !reflect.DeepEqual(recs[i], wrecs[i]) {
+			t.Errorf("#%d: record = %+v, want %+v", i, recs[i], wrecs[i])
+		}
+	}
+}
+
+func TestAuditLogMaxBytesAndFiles(t *testing.T) {
+	tests := []struct {
+		bytes  int64
+		files  int
+		wbytes int64
+		wfiles int
+	}{
+		{0, 0, DefaultAuditLogMaxBytes, DefaultAuditLogMaxFiles},
+		{-1, -1, 0, 0},
+		{1024, 2, 1024, 2},
+	}
+	for i, tt := range tests {
+		cfg := ServerConfig{AuditLogMaxBytes: tt.bytes, AuditLogMaxFiles: tt.files}
+		if g := cfg.auditLogMaxBytes(); g != tt.wbytes {
+			t.Errorf("#%d: max bytes = %d, want %d", i, g, tt.wbytes)
+		}
+		if g := cfg.auditLogMaxFiles(); g != tt.wfiles {
+			t.Errorf("#%d: max files = %d, want %d", i, g, tt.wfiles)
+		}
+	}
+}
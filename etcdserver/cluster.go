@@ -32,6 +32,7 @@ import (
 	"github.com/coreos/etcd/raft/raftpb"
 	"github.com/coreos/etcd/rafthttp"
 	"github.com/coreos/etcd/store"
+	"github.com/coreos/etcd/version"
 )
 
 const (
@@ -53,6 +54,8 @@ type ClusterInfo interface {
 	// IsIDRemoved checks whether the given ID has been removed from this
 	// cluster at some point in the past
 	IsIDRemoved(id types.ID) bool
+	// Version returns the cluster version, or empty if it is unknown
+	Version() string
 }
 
 // Cluster is a list of Members that belong to the same raft cluster
@@ -80,6 +83,17 @@ type Cluster struct {
 	// removed contains the ids of removed members in the cluster.
 	// removed id cannot be reused.
 	removed map[types.ID]bool
+
+	// alarms are the active alarms raised by the members.
+	alarms alarmSet
+	// dirQuotas are the quotas of the directories.
+	dirQuotas dirQuotaSet
+	// runtimeConfigs are the runtime configs of the members.
+	runtimeConfigs runtimeConfigSet
+	// config is the cluster config shared by the members.
+	config clusterConfigValue
+	// version is the cluster version. It is guarded by the mutex.
+	version string
 }
 
 // NewClusterFromString returns a Cluster instantiated from the given cluster token
@@ -117,6 +131,11 @@ func NewClusterFromStore(token string, st store.Store) *Cluster {
 	c := newCluster(token)
 	c.store = st
 	c.members, c.removed = membersFromStore(c.store)
+	c.alarms.recover(c.store)
+	c.dirQuotas.recover(c.store)
+	c.runtimeConfigs.recover(c.store)
+	c.config.recover(c.store)
+	c.version = clusterVersionFromStore(c.store)
 	return c
 }
 
@@ -185,6 +204,53 @@ func (c *Cluster) MemberIDs() []types.ID {
 	return ids
 }
 
+// Version returns the cluster version replicated through raft: the
+// lowest major and minor version of the members, e.g. "2.1.0". It is
+// decided by the leader once all the members have published their
+// versions, and it never goes down. It is empty until it is decided. A
+// wire or store feature new in a version should be enabled only once the
+// cluster version reaches it, so that a cluster under a rolling upgrade
+// keeps working.
+func (c *Cluster) Version() string {
+	c.Lock()
+	defer c.Unlock()
+	return c.version
+}
+
+// MinMemberVersion returns the lowest major and minor version published
+// by the members, e.g. "2.1.0". It is empty while a member has not
+// published its version.
+func (c *Cluster) MinMemberVersion() string {
+	c.Lock()
+	defer c.Unlock()
+	if len(c.members) == 0 {
+		return ""
+	}
+	minMajor, minMinor := -1, -1
+	for id, m := range c.members {
+		if m.Version == "" {
+			return ""
+		}
+		major, minor, err := version.MajorMinor(m.Version)
+		if err != nil {
+			log.Printf("etcdserver: cannot parse the version of member %s: %v", id, err)
+			return ""
+		}
+		if minMajor < 0 || major < minMajor || (major == minMajor && minor < minMinor) {
+			minMajor, minMinor = major, minor
+		}
+	}
+	return fmt.Sprintf("%d.%d.0", minMajor, minMinor)
+}
+
+// RecoverVersion reloads the cluster version from the store.
+func (c *Cluster) RecoverVersion() {
+	v := clusterVersionFromStore(c.store)
+	c.Lock()
+	defer c.Unlock()
+	c.version = v
+}
+
 // 对于已经removed的node，将node的id保存在removed数组中。
 func (c *Cluster) IsIDRemoved(id types.ID) bool {
 	c.Lock()
@@ -261,6 +327,41 @@ func (c *Cluster) Recover() {
 	}
 }
 
+// RecoverAlarms reloads the alarms from the store.
+func (c *Cluster) RecoverAlarms() { c.alarms.recover(c.store) }
+
+// Alarms returns the active alarms sorted by their type and member ID.
+func (c *Cluster) Alarms() []Alarm { return c.alarms.list() }
+
+// IsAlarmActive reports whether any member has raised the alarm of the
+// given type.
+func (c *Cluster) IsAlarmActive(t AlarmType) bool { return c.alarms.active(t) }
+
+// RecoverDirQuotas reloads the quotas of the directories from the store.
+func (c *Cluster) RecoverDirQuotas() { c.dirQuotas.recover(c.store) }
+
+// DirQuotas returns the quotas of the directories sorted by directory.
+func (c *Cluster) DirQuotas() []DirQuota { return c.dirQuotas.list() }
+
+// RecoverRuntimeConfigs reloads the runtime configs of the members from
+// the store.
+func (c *Cluster) RecoverRuntimeConfigs() { c.runtimeConfigs.recover(c.store) }
+
+// RuntimeConfigs returns the runtime configs of the members sorted by
+// member ID.
+func (c *Cluster) RuntimeConfigs() []RuntimeConfig { return c.runtimeConfigs.list() }
+
+// RuntimeConfig returns the runtime config of the member, which is empty
+// if it has none.
+func (c *Cluster) RuntimeConfig(id types.ID) RuntimeConfig { return c.runtimeConfigs.get(id) }
+
+// RecoverClusterConfig reloads the cluster config from the store.
+func (c *Cluster) RecoverClusterConfig() { c.config.recover(c.store) }
+
+// ClusterConfig returns the cluster config, which is empty if none is
+// set.
+func (c *Cluster) ClusterConfig() ClusterConfig { return c.config.get() }
+
 func (c *Cluster) SetTransport(tr rafthttp.Transporter) {
 	c.transport = tr
 	// add all the remote members into transport
@@ -639,6 +639,50 @@ func TestClusterRemoveMember(t *testing.T) {
 	}
 }
 
+func TestClusterMinMemberVersion(t *testing.T) {
+	tests := []struct {
+		versions []string
+
+		w string
+	}{
+		{[]string{"2.1.0", "2.1.3+git", "2.2.0"}, "2.1.0"},
+		{[]string{"2.1.0", "3.0.0", "2.0.4"}, "2.0.0"},
+		// a member older than the published version, or not started yet
+		{[]string{"2.1.0", "", "2.1.0"}, ""},
+		{[]string{"2.1.0", "2.1.0", "v2"}, ""},
+	}
+	for i, tt := range tests {
+		var membs []*Member
+		for j, v := range tt.versions {
+			m := newTestMember(uint64(j+1), nil, "", nil)
+			m.Version = v
+			membs = append(membs, m)
+		}
+		c := newTestCluster(membs)
+		if g := c.MinMemberVersion(); g != tt.w {
+			t.Errorf("#%d: version = %q, want %q", i, g, tt.w)
+		}
+	}
+}
+
+func TestClusterVersionFromStore(t *testing.T) {
+	st := store.New()
+	c := NewClusterFromStore("", st)
+	if g := c.Version(); g != "" {
+		t.Errorf("version = %q, want empty", g)
+	}
+	if _, err := st.Set(storeClusterVersionKey, false, "2.1.0", store.Permanent); err != nil {
+		t.Fatal(err)
+	}
+	c.RecoverVersion()
+	if g := c.Version(); g != "2.1.0" {
+		t.Errorf("version = %q, want %q", g, "2.1.0")
+	}
+	if g := NewClusterFromStore("", st).Version(); g != "2.1.0" {
+		t.Errorf("version = %q, want %q", g, "2.1.0")
+	}
+}
+
 func TestNodeToMember(t *testing.T) {
 	n := &store.NodeExtern{Key: "/1234", Nodes: []*store.NodeExtern{
 		{Key: "/1234/attributes", Value: stringp(`{"name":"node1","clientURLs":null}`)},
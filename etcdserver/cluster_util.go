@@ -24,6 +24,7 @@ import (
 	"strconv"
 	"time"
 
+	"github.com/coreos/etcd/etcdserver/etcdhttp/httptypes"
 	"github.com/coreos/etcd/pkg/types"
 )
 
@@ -109,6 +110,63 @@ func getClusterFromRemotePeers(urls []string, logerr bool, tr *http.Transport) (
 	return nil, fmt.Errorf("etcdserver: could not retrieve cluster information from the given urls")
 }
 
+// GetClusterFromRemoteClients gets the members of the cluster through the
+// client API of the given client urls, for the hosts that cannot reach the
+// peer urls. The members from the client API have no raft index.
+// 通过client url的/v2/members获取members，用于无法访问peer url的情况
+func GetClusterFromRemoteClients(urls []string, tr *http.Transport) (*Cluster, error) {
+	cc := &http.Client{
+		Transport: tr,
+		Timeout:   time.Second,
+	}
+	for _, u := range urls {
+		resp, err := cc.Get(u + "/v2/members")
+		if err != nil {
+			log.Printf("etcdserver: could not get members from %s: %v", u, err)
+			continue
+		}
+		b, err := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err != nil {
+			log.Printf("etcdserver: could not read the body of members response: %v", err)
+			continue
+		}
+		if resp.StatusCode != http.StatusOK {
+			log.Printf("etcdserver: could not get members from %s: %s", u, resp.Status)
+			continue
+		}
+		var mc httptypes.MemberCollection
+		if err := json.Unmarshal(b, &mc); err != nil {
+			log.Printf("etcdserver: could not unmarshal members response: %v", err)
+			continue
+		}
+		id, err := types.IDFromString(resp.Header.Get("X-Etcd-Cluster-ID"))
+		if err != nil {
+			log.Printf("etcdserver: could not parse the cluster ID from members response: %v", err)
+			continue
+		}
+		membs := make([]*Member, 0, len(mc))
+		for _, m := range mc {
+			mid, err := types.IDFromString(m.ID)
+			if err != nil {
+				log.Printf("etcdserver: could not parse member ID %q: %v", m.ID, err)
+				membs = nil
+				break
+			}
+			membs = append(membs, &Member{
+				ID:             mid,
+				RaftAttributes: RaftAttributes{PeerURLs: m.PeerURLs},
+				Attributes:     Attributes{Name: m.Name, ClientURLs: m.ClientURLs},
+			})
+		}
+		if membs == nil {
+			continue
+		}
+		return NewClusterFromMembers("", id, membs), nil
+	}
+	return nil, fmt.Errorf("etcdserver: could not retrieve members from the given client urls")
+}
+
 // getRemotePeerURLs returns peer urls of remote members in the cluster. The
 // returned list is sorted in ascending lexicographical order.
 func getRemotePeerURLs(cl ClusterInfo, local string) []string {
@@ -0,0 +1,59 @@
+// Copyright 2015 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package etcdserver
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	"github.com/coreos/etcd/pkg/types"
+)
+
+func TestGetClusterFromRemoteClients(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v2/members" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("X-Etcd-Cluster-ID", "10")
+		w.Write([]byte(`{"members":[{"id":"c","name":"node1","peerURLs":["http://127.0.0.1:2380"],"clientURLs":["http://127.0.0.1:2379"]}]}`))
+	}))
+	defer ts.Close()
+	bad := httptest.NewServer(http.NotFoundHandler())
+	defer bad.Close()
+
+	cl, err := GetClusterFromRemoteClients([]string{bad.URL, ts.URL}, &http.Transport{})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if cl.ID() != 0x10 {
+		t.Errorf("cluster id = %s, want 10", cl.ID())
+	}
+	if w := []types.ID{0xc}; !reflect.DeepEqual(cl.MemberIDs(), w) {
+		t.Errorf("member ids = %v, want %v", cl.MemberIDs(), w)
+	}
+	if w := []string{"http://127.0.0.1:2379"}; !reflect.DeepEqual(cl.ClientURLs(), w) {
+		t.Errorf("client urls = %v, want %v", cl.ClientURLs(), w)
+	}
+	if w := []string{"http://127.0.0.1:2380"}; !reflect.DeepEqual(cl.PeerURLs(), w) {
+		t.Errorf("peer urls = %v, want %v", cl.PeerURLs(), w)
+	}
+
+	if _, err = GetClusterFromRemoteClients([]string{bad.URL}, &http.Transport{}); err == nil {
+		t.Errorf("err = nil, want not nil")
+	}
+}
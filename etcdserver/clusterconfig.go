@@ -0,0 +1,197 @@
+// Copyright 2015 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package etcdserver
+
+import (
+	"encoding/json"
+	"log"
+	"path"
+	"sync"
+	"sync/atomic"
+
+	"github.com/coreos/etcd/Godeps/_workspace/src/golang.org/x/net/context"
+	pb "github.com/coreos/etcd/etcdserver/etcdserverpb"
+	"github.com/coreos/etcd/store"
+)
+
+var storeClusterConfigKey = path.Join(StoreAdminPrefix, "cluster_config")
+
+// ClusterConfig holds the policy shared by all the members of the
+// cluster. It is kept in the store and changed through consensus, so all
+// the members enforce the same policy instead of relying on matching
+// flags. A nil setting is left as given by the flags of each member.
+// The sizes follow the flags: zero is the default, and a negative size
+// disables the limit.
+type ClusterConfig struct {
+	// ActiveSize is the number of the members the standbys keep: a
+	// standby promotes itself while the cluster has fewer members. Zero
+	// leaves the promotion to the operator.
+	ActiveSize *int `json:"activeSize,omitempty"`
+	// QuotaBackendBytes is the size the store may grow to before the
+	// NOSPACE alarm is raised.
+	QuotaBackendBytes *int64 `json:"quotaBackendBytes,omitempty"`
+	// MaxValueBytes and MaxRequestBytes are the max sizes of a value
+	// and of a client write.
+	MaxValueBytes   *int64 `json:"maxValueBytes,omitempty"`
+	MaxRequestBytes *int64 `json:"maxRequestBytes,omitempty"`
+}
+
+// ClusterConfiger gets and changes the cluster config.
+type ClusterConfiger interface {
+	ClusterConfig() ClusterConfig
+	// UpdateClusterConfig merges the settings of c into the cluster
+	// config.
+	UpdateClusterConfig(ctx context.Context, c ClusterConfig) error
+	// ResetClusterConfig removes the cluster config, so that the members
+	// run as given by their flags again.
+	ResetClusterConfig(ctx context.Context) error
+}
+
+// merge returns c with the settings of o set over its own.
+func (c ClusterConfig) merge(o ClusterConfig) ClusterConfig {
+	if o.ActiveSize != nil {
+		c.ActiveSize = o.ActiveSize
+	}
+	if o.QuotaBackendBytes != nil {
+		c.QuotaBackendBytes = o.QuotaBackendBytes
+	}
+	if o.MaxValueBytes != nil {
+		c.MaxValueBytes = o.MaxValueBytes
+	}
+	if o.MaxRequestBytes != nil {
+		c.MaxRequestBytes = o.MaxRequestBytes
+	}
+	return c
+}
+
+func (c ClusterConfig) valid() bool {
+	return c.ActiveSize == nil || *c.ActiveSize >= 0
+}
+
+// clusterConfigValue keeps the cluster config in memory. The zero value
+// is an empty config.
+type clusterConfigValue struct {
+	mu     sync.RWMutex
+	config ClusterConfig
+}
+
+// recover reloads the cluster config from the store.
+func (cv *clusterConfigValue) recover(st store.Store) {
+	c := clusterConfigFromStore(st)
+	cv.mu.Lock()
+	defer cv.mu.Unlock()
+	cv.config = c
+}
+
+func (cv *clusterConfigValue) get() ClusterConfig {
+	cv.mu.RLock()
+	defer cv.mu.RUnlock()
+	return cv.config
+}
+
+func clusterConfigFromStore(st store.Store) ClusterConfig {
+	var c ClusterConfig
+	e, err := st.Get(storeClusterConfigKey, false, false)
+	if err != nil {
+		if isKeyNotFound(err) {
+			return c
+		}
+		log.Panicf("get storeClusterConfig should never fail: %v", err)
+	}
+	if err := json.Unmarshal([]byte(*e.Node.Value), &c); err != nil {
+		log.Panicf("unmarshal cluster config should never fail: %v", err)
+	}
+	return c
+}
+
+// ClusterConfig returns the cluster config.
+func (s *EtcdServer) ClusterConfig() ClusterConfig { return s.Cluster.ClusterConfig() }
+
+// UpdateClusterConfig merges the settings of c into the cluster config
+// through consensus. It returns ErrInvalidClusterConfig if a setting is
+// not valid.
+// 通过raft修改集群配置，所有member执行相同的策略
+func (s *EtcdServer) UpdateClusterConfig(ctx context.Context, c ClusterConfig) error {
+	if !c.valid() {
+		return ErrInvalidClusterConfig
+	}
+	c = s.Cluster.ClusterConfig().merge(c)
+	b, err := json.Marshal(c)
+	if err != nil {
+		log.Panicf("marshal cluster config should never fail: %v", err)
+	}
+	req := pb.Request{
+		Method: "PUT",
+		Path:   storeClusterConfigKey,
+		Val:    string(b),
+	}
+	if _, err := s.Do(ctx, req); err != nil {
+		return err
+	}
+	log.Printf("etcdserver: set cluster config to %s", b)
+	return nil
+}
+
+// ResetClusterConfig removes the cluster config through consensus. It
+// returns nil if there is no cluster config.
+func (s *EtcdServer) ResetClusterConfig(ctx context.Context) error {
+	req := pb.Request{
+		Method: "DELETE",
+		Path:   storeClusterConfigKey,
+	}
+	_, err := s.Do(ctx, req)
+	if err != nil && !isKeyNotFound(err) {
+		return err
+	}
+	log.Printf("etcdserver: removed cluster config")
+	return nil
+}
+
+// isClusterConfigRequest reports whether the request changes the cluster
+// config.
+func isClusterConfigRequest(r pb.Request) bool {
+	return r.Path == storeClusterConfigKey
+}
+
+// flagClusterConfig returns the cluster config given by the flags of the
+// member, which the cluster config overrides.
+func flagClusterConfig(cfg *ServerConfig) ClusterConfig {
+	quota, maxValue, maxRequest := cfg.QuotaBackendBytes, cfg.MaxValueBytes, cfg.MaxRequestBytes
+	return ClusterConfig{
+		QuotaBackendBytes: &quota,
+		MaxValueBytes:     &maxValue,
+		MaxRequestBytes:   &maxRequest,
+	}
+}
+
+// applyClusterConfig applies the limits of the cluster config over the
+// flags. It is called when the cluster config changes, and after a
+// snapshot is applied.
+// 应用集群配置中的限制，未设置的项使用启动参数
+func (s *EtcdServer) applyClusterConfig() {
+	c := s.flagClusterConfig.merge(s.Cluster.ClusterConfig())
+	if c.QuotaBackendBytes != nil {
+		lc := &ServerConfig{QuotaBackendBytes: *c.QuotaBackendBytes}
+		atomic.StoreInt64(&s.quota, lc.quotaBackendBytes())
+	}
+	if c.MaxValueBytes != nil {
+		lc := &ServerConfig{MaxValueBytes: *c.MaxValueBytes}
+		atomic.StoreInt64(&s.maxValueBytes, lc.maxValueBytes())
+	}
+	if c.MaxRequestBytes != nil {
+		lc := &ServerConfig{MaxRequestBytes: *c.MaxRequestBytes}
+		atomic.StoreInt64(&s.maxRequestBytes, lc.maxRequestBytes())
+	}
+}
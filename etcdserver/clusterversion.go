@@ -0,0 +1,249 @@
+// Copyright 2015 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package etcdserver
+
+import (
+	"fmt"
+	"log"
+	"path"
+	"time"
+
+	"github.com/coreos/etcd/Godeps/_workspace/src/golang.org/x/net/context"
+	pb "github.com/coreos/etcd/etcdserver/etcdserverpb"
+	"github.com/coreos/etcd/store"
+	"github.com/coreos/etcd/version"
+)
+
+const (
+	// monitorVersionInterval is the interval at which the leader checks
+	// whether the cluster version should be raised.
+	monitorVersionInterval = time.Second
+	// updateClusterVersionTimeout is the timeout of the proposal of a
+	// new cluster version.
+	updateClusterVersionTimeout = 5 * time.Second
+)
+
+var storeClusterVersionKey = path.Join(StoreAdminPrefix, "version")
+
+// Capability is a wire or store feature that is enabled only once the
+// cluster version reaches the version it is introduced in, so that the
+// members of an older version are never sent what they cannot read.
+type Capability string
+
+const (
+	// GobSnapshotCapability is the gob format of the store in the
+	// snapshots, which the older members cannot recover from.
+	GobSnapshotCapability Capability = "gobSnapshot"
+
+	// The raft messages new in 2.1, which the older members cannot read.
+	// The server does not quiesce raft nor read by MsgReadIndex yet; they
+	// must be gated on their capabilities once it does.
+	PreVoteCapability    Capability = "raftPreVote"
+	TimeoutNowCapability Capability = "raftTimeoutNow"
+	QuiesceCapability    Capability = "raftQuiesce"
+	ReadIndexCapability  Capability = "raftReadIndex"
+
+	// The request methods new in 2.1, which the older members cannot
+	// apply.
+	TxnCapability     Capability = "txn"
+	IncrCapability    Capability = "incr"
+	MoveCapability    Capability = "move"
+	ImportCapability  Capability = "import"
+	KVCapability      Capability = "kv"
+	CompactCapability Capability = "compact"
+	HashCapability    Capability = "hash"
+)
+
+// capabilityVersions are the cluster versions the capabilities are
+// introduced in.
+var capabilityVersions = map[Capability]string{
+	GobSnapshotCapability: "2.1.0",
+
+	PreVoteCapability:    "2.1.0",
+	TimeoutNowCapability: "2.1.0",
+	QuiesceCapability:    "2.1.0",
+	ReadIndexCapability:  "2.1.0",
+
+	TxnCapability:     "2.1.0",
+	IncrCapability:    "2.1.0",
+	MoveCapability:    "2.1.0",
+	ImportCapability:  "2.1.0",
+	KVCapability:      "2.1.0",
+	CompactCapability: "2.1.0",
+	HashCapability:    "2.1.0",
+}
+
+// methodCapabilities are the capabilities of the request methods which
+// are proposed only once the whole cluster can apply them.
+var methodCapabilities = map[string]Capability{
+	"TXN":     TxnCapability,
+	"INCR":    IncrCapability,
+	"MOVE":    MoveCapability,
+	"IMPORT":  ImportCapability,
+	"KV":      KVCapability,
+	"COMPACT": CompactCapability,
+	"HASH":    HashCapability,
+}
+
+func clusterVersionFromStore(st store.Store) string {
+	e, err := st.Get(storeClusterVersionKey, false, false)
+	if err != nil {
+		if isKeyNotFound(err) {
+			return ""
+		}
+		log.Panicf("get storeClusterVersion should never fail: %v", err)
+	}
+	return *e.Node.Value
+}
+
+// checkClusterVersion returns an error if the member runs a version
+// older than the cluster version, which it may not understand the
+// features of.
+func checkClusterVersion(cv string) error {
+	if cv == "" {
+		return nil
+	}
+	less, err := version.LessMajorMinor(version.Version, cv)
+	if err != nil {
+		return err
+	}
+	if less {
+		return fmt.Errorf("etcdserver: the cluster version %s is newer than the member version %s; the cluster cannot be downgraded", cv, version.Version)
+	}
+	return nil
+}
+
+// IsCapabilityEnabled reports whether the cluster version has reached the
+// version the capability is introduced in.
+func (s *EtcdServer) IsCapabilityEnabled(c Capability) bool {
+	return isCapabilityEnabled(s.Cluster.Version(), c)
+}
+
+func isCapabilityEnabled(cv string, c Capability) bool {
+	if cv == "" {
+		return false
+	}
+	less, err := version.LessMajorMinor(cv, capabilityVersions[c])
+	if err != nil {
+		log.Printf("etcdserver: cannot compare the cluster version %s: %v", cv, err)
+		return false
+	}
+	return !less
+}
+
+// checkCapability returns ErrNotCapable if the method of the request is
+// not supported by the whole cluster yet.
+// 集群中所有成员都支持之前，拒绝新增的请求方法
+func (s *EtcdServer) checkCapability(r pb.Request) error {
+	if c, ok := methodCapabilities[r.Method]; ok && !s.IsCapabilityEnabled(c) {
+		return ErrNotCapable
+	}
+	return nil
+}
+
+// preVoteEnabled returns whether the raft Pre-Vote asked in the config may
+// be enabled. The older members cannot read MsgPreVote, so it is enabled
+// only if the cluster version the member starts with supports it.
+// Otherwise it is enabled by the first restart after the cluster version
+// reaches it.
+func preVoteEnabled(cfg *ServerConfig) bool {
+	if !cfg.PreVote {
+		return false
+	}
+	if !isCapabilityEnabled(cfg.Cluster.Version(), PreVoteCapability) {
+		log.Printf("etcdserver: raft pre-vote is not enabled until the cluster version reaches %s", capabilityVersions[PreVoteCapability])
+		return false
+	}
+	return true
+}
+
+// monitorVersions raises the cluster version to the lowest version of
+// the members while the member is the leader.
+// leader定期检查各member的版本，提升cluster version
+func (s *EtcdServer) monitorVersions() {
+	ticker := time.NewTicker(monitorVersionInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+		case <-s.done:
+			return
+		}
+		if s.Leader() != s.ID() {
+			continue
+		}
+		if v := decideClusterVersion(s.Cluster); v != "" {
+			s.updateClusterVersion(v)
+		}
+	}
+}
+
+// decideClusterVersion returns the version the cluster version should be
+// raised to, or empty if it should be kept. The cluster version never
+// goes down.
+func decideClusterVersion(c *Cluster) string {
+	v := c.MinMemberVersion()
+	if v == "" {
+		return ""
+	}
+	cv := c.Version()
+	if cv == "" {
+		return v
+	}
+	less, err := version.LessMajorMinor(cv, v)
+	if err != nil {
+		log.Printf("etcdserver: cannot compare the cluster version %s: %v", cv, err)
+		return ""
+	}
+	if !less {
+		return ""
+	}
+	return v
+}
+
+// updateClusterVersion sets the cluster version through consensus.
+func (s *EtcdServer) updateClusterVersion(v string) {
+	ctx, cancel := context.WithTimeout(context.Background(), updateClusterVersionTimeout)
+	defer cancel()
+	req := pb.Request{
+		Method: "PUT",
+		Path:   storeClusterVersionKey,
+		Val:    v,
+	}
+	if _, err := s.Do(ctx, req); err != nil {
+		log.Printf("etcdserver: error updating the cluster version to %s: %v", v, err)
+		return
+	}
+	log.Printf("etcdserver: set the cluster version to %s", v)
+}
+
+// isClusterVersionRequest reports whether the request changes the
+// cluster version.
+func isClusterVersionRequest(r pb.Request) bool {
+	return r.Path == storeClusterVersionKey
+}
+
+// applyClusterVersion enables the capabilities of the cluster version. It
+// is called when the cluster version changes, and after a snapshot is
+// applied.
+func (s *EtcdServer) applyClusterVersion() {
+	format := s.snapshotFormat
+	if format == store.SnapshotFormatGob && !s.IsCapabilityEnabled(GobSnapshotCapability) {
+		format = store.SnapshotFormatJSON
+	}
+	if format != "" {
+		s.store.SetSnapshotFormat(format)
+	}
+}
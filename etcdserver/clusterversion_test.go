@@ -0,0 +1,205 @@
+// Copyright 2015 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package etcdserver
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/coreos/etcd/Godeps/_workspace/src/golang.org/x/net/context"
+	pb "github.com/coreos/etcd/etcdserver/etcdserverpb"
+	"github.com/coreos/etcd/pkg/idutil"
+	"github.com/coreos/etcd/raft"
+	"github.com/coreos/etcd/store"
+	"github.com/coreos/etcd/version"
+)
+
+func TestDecideClusterVersion(t *testing.T) {
+	tests := []struct {
+		cv       string
+		versions []string
+
+		w string
+	}{
+		{"", []string{"2.1.0", "2.1.3"}, "2.1.0"},
+		{"2.0.0", []string{"2.1.0", "2.2.0"}, "2.1.0"},
+		// up to date
+		{"2.1.0", []string{"2.1.0", "2.1.3"}, ""},
+		// never goes down
+		{"2.1.0", []string{"2.0.4", "2.1.0"}, ""},
+		// a member has not published its version
+		{"", []string{"2.1.0", ""}, ""},
+	}
+	for i, tt := range tests {
+		var membs []*Member
+		for j, v := range tt.versions {
+			m := newTestMember(uint64(j+1), nil, "", nil)
+			m.Version = v
+			membs = append(membs, m)
+		}
+		c := newTestCluster(membs)
+		c.version = tt.cv
+		if g := decideClusterVersion(c); g != tt.w {
+			t.Errorf("#%d: version = %q, want %q", i, g, tt.w)
+		}
+	}
+}
+
+func TestCheckClusterVersion(t *testing.T) {
+	major, minor, err := version.MajorMinor(version.Version)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		cv   string
+		werr bool
+	}{
+		{"", false},
+		{"2.0.0", false},
+		{version.Version, false},
+		{fmt.Sprintf("%d.%d.0", major, minor+1), true},
+		{"99.0.0", true},
+	}
+	for i, tt := range tests {
+		if err := checkClusterVersion(tt.cv); (err != nil) != tt.werr {
+			t.Errorf("#%d: err = %v, want error %t", i, err, tt.werr)
+		}
+	}
+}
+
+// TestDoNotCapable tests that the requests of the methods new in 2.1 are
+// rejected until the cluster version reaches 2.1, and the other requests
+// are proposed.
+func TestDoNotCapable(t *testing.T) {
+	tests := []struct {
+		method string
+		cv     string
+
+		werr error
+	}{
+		{"PUT", "", nil},
+		{"DELETE", "2.0.0", nil},
+		{"TXN", "", ErrNotCapable},
+		{"INCR", "", ErrNotCapable},
+		{"MOVE", "2.0.0", ErrNotCapable},
+		{"IMPORT", "", ErrNotCapable},
+		{"KV", "", ErrNotCapable},
+		{"COMPACT", "", ErrNotCapable},
+		{"HASH", "2.0.0", ErrNotCapable},
+		{"TXN", "2.1.0", nil},
+		{"KV", "2.2.0", nil},
+	}
+	for i, tt := range tests {
+		n := &nodeRecorder{}
+		srv := &EtcdServer{
+			r:        raftNode{Node: n},
+			w:        &waitRecorder{},
+			Cluster:  &Cluster{version: tt.cv},
+			reqIDGen: idutil.NewGenerator(0, time.Time{}),
+		}
+		// the proposed requests time out, as nothing is applied
+		ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond)
+		_, err := srv.Do(ctx, pb.Request{Method: tt.method, Path: "/foo"})
+		cancel()
+		if tt.werr != nil {
+			if err != tt.werr {
+				t.Errorf("#%d: err = %v, want %v", i, err, tt.werr)
+			}
+			if a := n.Action(); len(a) != 0 {
+				t.Errorf("#%d: action = %+v, want none", i, a)
+			}
+			continue
+		}
+		if err == ErrNotCapable {
+			t.Errorf("#%d: err = %v, want the request proposed", i, err)
+		}
+	}
+}
+
+func TestPreVoteEnabled(t *testing.T) {
+	tests := []struct {
+		preVote bool
+		cv      string
+
+		w bool
+	}{
+		{false, "2.1.0", false},
+		{true, "", false},
+		{true, "2.0.0", false},
+		{true, "2.1.0", true},
+	}
+	for i, tt := range tests {
+		cfg := &ServerConfig{PreVote: tt.preVote, Cluster: &Cluster{version: tt.cv}}
+		if g := preVoteEnabled(cfg); g != tt.w {
+			t.Errorf("#%d: pre-vote = %t, want %t", i, g, tt.w)
+		}
+	}
+}
+
+// TestUpdateClusterVersion tests that the cluster version is replicated
+// through the store, and enables the gob snapshots once it supports them.
+func TestUpdateClusterVersion(t *testing.T) {
+	st := store.New()
+	cl := newTestCluster([]*Member{{ID: 1}})
+	cl.SetStore(st)
+	srv := &EtcdServer{
+		id: 1,
+		r: raftNode{
+			Node:        newNodeCommitter(),
+			storage:     &storageRecorder{},
+			raftStorage: raft.NewMemoryStorage(),
+			transport:   &nopTransporter{},
+		},
+		store:          st,
+		Cluster:        cl,
+		reqIDGen:       idutil.NewGenerator(0, time.Time{}),
+		snapshotFormat: store.SnapshotFormatGob,
+	}
+	srv.applyClusterVersion()
+	srv.start()
+	defer srv.Stop()
+
+	if srv.IsCapabilityEnabled(GobSnapshotCapability) {
+		t.Errorf("gob snapshot enabled, want disabled before the cluster version is decided")
+	}
+	if isGobSnapshot(t, st) {
+		t.Errorf("snapshot in gob, want json")
+	}
+
+	srv.updateClusterVersion("2.0.0")
+	if g := srv.Cluster.Version(); g != "2.0.0" {
+		t.Fatalf("cluster version = %q, want %q", g, "2.0.0")
+	}
+	if srv.IsCapabilityEnabled(GobSnapshotCapability) || isGobSnapshot(t, st) {
+		t.Errorf("gob snapshot enabled, want disabled in cluster version 2.0.0")
+	}
+
+	srv.updateClusterVersion("2.1.0")
+	if g := clusterVersionFromStore(st); g != "2.1.0" {
+		t.Fatalf("stored cluster version = %q, want %q", g, "2.1.0")
+	}
+	if !srv.IsCapabilityEnabled(GobSnapshotCapability) || !isGobSnapshot(t, st) {
+		t.Errorf("gob snapshot disabled, want enabled in cluster version 2.1.0")
+	}
+}
+
+func isGobSnapshot(t *testing.T, st store.Store) bool {
+	b, err := st.Save()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return len(b) > 0 && b[0] != '{'
+}
@@ -0,0 +1,110 @@
+// Copyright 2015 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package etcdserver
+
+import (
+	"log"
+	"time"
+
+	"github.com/coreos/etcd/Godeps/_workspace/src/golang.org/x/net/context"
+	pb "github.com/coreos/etcd/etcdserver/etcdserverpb"
+	"github.com/coreos/etcd/store"
+)
+
+const (
+	// compactionSamples is the number of the store indexes the compactor
+	// samples per retention, so the history is kept at most a tenth of the
+	// retention longer than the retention.
+	compactionSamples = 10
+	// compactTimeout is the timeout of a COMPACT proposal.
+	compactTimeout = 5 * time.Second
+)
+
+// indexSample is the store index at a time.
+type indexSample struct {
+	t     time.Time
+	index uint64
+}
+
+// monitorCompaction samples the store index every retention /
+// compactionSamples, and compacts the history at the index sampled a
+// retention ago while the local member is the leader.
+// 按时间自动压缩：leader定期提议COMPACT，丢弃超过保留时间的历史
+func (s *EtcdServer) monitorCompaction(retention time.Duration) {
+	if retention <= 0 {
+		return
+	}
+	ticker := time.NewTicker(retention / compactionSamples)
+	defer ticker.Stop()
+	var samples []indexSample
+	for {
+		var now time.Time
+		select {
+		case now = <-ticker.C:
+		case <-s.done:
+			return
+		}
+		samples = append(samples, indexSample{t: now, index: s.store.Index()})
+		var index uint64
+		index, samples = compactionIndex(samples, now, retention)
+		if index == 0 || s.Leader() != s.ID() {
+			continue
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), compactTimeout)
+		if err := s.compact(ctx, index); err != nil {
+			log.Printf("etcdserver: failed to compact the history at index %d: %v", index, err)
+		}
+		cancel()
+	}
+}
+
+// compactionIndex returns the latest store index sampled at least a
+// retention before now, or 0 if there is none, and the samples after it.
+func compactionIndex(samples []indexSample, now time.Time, retention time.Duration) (uint64, []indexSample) {
+	i := -1
+	for j, sm := range samples {
+		if now.Sub(sm.t) < retention {
+			break
+		}
+		i = j
+	}
+	if i < 0 {
+		return 0, samples
+	}
+	return samples[i].index, samples[i+1:]
+}
+
+// compact proposes a COMPACT request, so that all the members compact
+// their history at the same store index when applying it.
+func (s *EtcdServer) compact(ctx context.Context, index uint64) error {
+	if _, err := s.Do(ctx, pb.Request{Method: "COMPACT", Since: index}); err != nil {
+		return err
+	}
+	log.Printf("etcdserver: compacted the history at index %d", index)
+	return nil
+}
+
+// applyCompact drops the events and, for the mvcc store, the revisions
+// at or before the index, and has a snapshot taken after the apply, which
+// compacts the raft log.
+func (s *EtcdServer) applyCompact(index uint64) {
+	s.store.CompactHistory(index)
+	if ms, ok := s.store.(store.MVCCStore); ok && index > ms.CompactedRevision() {
+		if err := ms.Compact(index); err != nil {
+			log.Printf("etcdserver: failed to compact the revisions at index %d: %v", index, err)
+		}
+	}
+	s.compacted = true
+}
@@ -0,0 +1,117 @@
+// Copyright 2015 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package etcdserver
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/coreos/etcd/Godeps/_workspace/src/golang.org/x/net/context"
+	pb "github.com/coreos/etcd/etcdserver/etcdserverpb"
+	"github.com/coreos/etcd/pkg/idutil"
+	"github.com/coreos/etcd/pkg/testutil"
+	"github.com/coreos/etcd/raft"
+	"github.com/coreos/etcd/store"
+)
+
+func TestCompactionIndex(t *testing.T) {
+	t0 := time.Unix(0, 0)
+	samples := []indexSample{
+		{t0, 1},
+		{t0.Add(time.Minute), 5},
+		{t0.Add(2 * time.Minute), 9},
+	}
+	tests := []struct {
+		now time.Time
+
+		windex   uint64
+		wsamples []indexSample
+	}{
+		{t0.Add(59 * time.Second), 0, samples},
+		{t0.Add(time.Minute), 1, samples[1:]},
+		{t0.Add(2*time.Minute + time.Second), 5, samples[2:]},
+		{t0.Add(time.Hour), 9, samples[3:]},
+	}
+	for i, tt := range tests {
+		index, rest := compactionIndex(samples, tt.now, time.Minute)
+		if index != tt.windex {
+			t.Errorf("#%d: index = %d, want %d", i, index, tt.windex)
+		}
+		if !reflect.DeepEqual(rest, tt.wsamples) {
+			t.Errorf("#%d: samples = %v, want %v", i, rest, tt.wsamples)
+		}
+	}
+}
+
+// TestApplyCompact tests that a COMPACT request compacts the watch history
+// and the revisions of the mvcc store, and has a snapshot taken.
+func TestApplyCompact(t *testing.T) {
+	for i, st := range []store.Store{store.New(), store.NewMVCC(store.DefaultHistorySize)} {
+		for j := 0; j < 10; j++ {
+			st.Set("/1/foo", false, "bar", store.Permanent)
+		}
+		srv := &EtcdServer{store: st, Cluster: &Cluster{}}
+		if resp := srv.applyRequest(pb.Request{Method: "COMPACT", Since: 6}); resp.err != nil {
+			t.Fatalf("#%d: err = %v, want nil", i, resp.err)
+		}
+		if g := st.OldestWatchableIndex(); g != 7 {
+			t.Errorf("#%d: oldest watchable index = %d, want 7", i, g)
+		}
+		if ms, ok := st.(store.MVCCStore); ok {
+			if g := ms.CompactedRevision(); g != 6 {
+				t.Errorf("#%d: compacted revision = %d, want 6", i, g)
+			}
+		}
+		if !srv.compacted {
+			t.Errorf("#%d: compacted = false, want true", i)
+		}
+	}
+}
+
+// TestCompactTriggerSnap tests that a member snapshots after applying a
+// COMPACT request, before the snapshot count is reached.
+func TestCompactTriggerSnap(t *testing.T) {
+	p := &storageRecorder{}
+	srv := &EtcdServer{
+		snapCount: 100,
+		r: raftNode{
+			Node:        newNodeCommitter(),
+			raftStorage: raft.NewMemoryStorage(),
+			storage:     p,
+			transport:   &nopTransporter{},
+		},
+		store:    store.New(),
+		Cluster:  &Cluster{version: "2.1.0"},
+		reqIDGen: idutil.NewGenerator(0, time.Time{}),
+	}
+	srv.start()
+	defer srv.Stop()
+	srv.Do(context.Background(), pb.Request{Method: "PUT", Path: "/1/foo"})
+	if err := srv.compact(context.Background(), 1); err != nil {
+		t.Fatal(err)
+	}
+
+	// the snapshot is saved asynchronously
+	for i := 0; i < 100; i++ {
+		for _, a := range p.Action() {
+			if reflect.DeepEqual(a, testutil.Action{Name: "SaveSnap"}) {
+				return
+			}
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Errorf("actions = %v, want SaveSnap", p.Action())
+}
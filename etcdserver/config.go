@@ -20,20 +20,25 @@ import (
 	"net/http"
 	"path"
 	"sort"
+	"time"
 
+	"github.com/coreos/etcd/pkg/encryption"
 	"github.com/coreos/etcd/pkg/netutil"
 	"github.com/coreos/etcd/pkg/types"
 	"github.com/coreos/etcd/raft"
+	"github.com/coreos/etcd/rafthttp"
 )
 
 // ServerConfig holds the configuration of etcd as taken from the command line or discovery.
 type ServerConfig struct {
 	Name            string
+	Labels          map[string]string
 	DiscoveryURL    string
 	DiscoveryProxy  string
 	ClientURLs      types.URLs
 	PeerURLs        types.URLs
 	DataDir         string
+	DedicatedWALDir string
 	SnapCount       uint64
 	MaxSnapFiles    uint
 	MaxWALFiles     uint
@@ -42,8 +47,154 @@ type ServerConfig struct {
 	ForceNewCluster bool
 	Transport       *http.Transport
 
+	// PeerDialTimeout, PeerReadTimeout and PeerWriteTimeout are the
+	// timeouts of the connections to the peers, which Transport and the
+	// peer listeners are set up with. If they are zero,
+	// rafthttp.DialTimeout, rafthttp.ConnReadTimeout and
+	// rafthttp.ConnWriteTimeout are used.
+	PeerDialTimeout  time.Duration
+	PeerReadTimeout  time.Duration
+	PeerWriteTimeout time.Duration
+
+	// RestoreSnapshot is the path of a snapshot file to initialize a new
+	// single-member cluster from, if the member has no WAL.
+	RestoreSnapshot string
+
 	TickMs        uint
 	ElectionTicks int
+
+	// StoreBackend selects the implementation of the key-value store.
+	// It is StoreBackendV2, StoreBackendMVCC or StoreBackendBolt.
+	StoreBackend string
+	// SnapshotFormat is the format of the store in the snapshots, either
+	// store.SnapshotFormatJSON or store.SnapshotFormatGob. Empty is
+	// SnapshotFormatJSON. The snapshots in either format are recovered.
+	SnapshotFormat string
+	// WatchHistorySize is the number of events the store keeps for
+	// watchers. If it is zero, store.DefaultHistorySize is used.
+	WatchHistorySize int
+
+	// ClientCertAuthEnabled is true when the client certificates are
+	// verified. The client requests are then authenticated as the user
+	// named by the CommonName of their certificate.
+	ClientCertAuthEnabled bool
+
+	// QuotaBackendBytes is the size in bytes the store may grow to before
+	// the NOSPACE alarm is raised. If it is zero, DefaultQuotaBackendBytes
+	// is used. A negative value disables the quota.
+	QuotaBackendBytes int64
+
+	// MinFreeDiskBytes is the free space in bytes the filesystems of the
+	// WAL and the snapshots keep. Below it, the member rejects the
+	// proposals of its clients and raises the LOWDISK alarm. If it is
+	// zero, DefaultMinFreeDiskBytes is used. A negative value disables the
+	// check.
+	MinFreeDiskBytes int64
+
+	// MaxValueBytes is the max size in bytes of a value written by a
+	// client. MaxRequestBytes is the max size in bytes of a client write.
+	// If they are zero, DefaultMaxValueBytes and DefaultMaxRequestBytes
+	// are used. A negative value disables the limit.
+	MaxValueBytes   int64
+	MaxRequestBytes int64
+
+	// MaxInflightProposals is the number of the client proposals that may
+	// wait to be applied at the same time. If it is zero,
+	// DefaultMaxInflightProposals is used. A negative value disables the
+	// limit.
+	MaxInflightProposals int
+
+	// FDWarnPercent is the percent of the file descriptor limit above
+	// which the usage is logged. FDShedWatchPercent is the percent above
+	// which the new watches are rejected. If they are zero,
+	// DefaultFDWarnPercent and DefaultFDShedWatchPercent are used. A
+	// negative value disables them.
+	FDWarnPercent      int
+	FDShedWatchPercent int
+
+	// SlowRequestThreshold is the time a proposed request may take before
+	// it is logged as slow. Zero disables the log.
+	SlowRequestThreshold time.Duration
+
+	// HashCheckInterval is the interval at which the leader compares the
+	// store hashes of the members. Zero disables the check.
+	HashCheckInterval time.Duration
+
+	// AutoCompactionRetention is the time the event history, and the
+	// revisions of the mvcc store, are kept for. The leader compacts
+	// what is older through consensus, and the members then snapshot,
+	// which compacts their raft log. Zero disables the auto-compaction.
+	AutoCompactionRetention time.Duration
+
+	// SyncInterval is the interval at which the member proposes a SYNC
+	// request to expire the TTL keys while it is the leader. If it is zero,
+	// DefaultSyncInterval is used. A negative value disables the SYNC, and
+	// the TTL keys then never expire while the member is the leader.
+	SyncInterval time.Duration
+
+	// WALSyncWindow is the time a save to the WAL waits before it fsyncs,
+	// so that the saves within the window share one fsync. Zero fsyncs
+	// every save immediately.
+	WALSyncWindow time.Duration
+
+	// Encryption seals the records of the WAL and the snapshot files. If
+	// it is nil, they are written in plaintext. The member dir must be
+	// created with the same encryption it is restarted with.
+	Encryption encryption.Provider
+
+	// AuditLogPath is the file the member appends the applied writes to.
+	// Empty disables the audit log. The file is rotated once it grows
+	// beyond AuditLogMaxBytes, keeping AuditLogMaxFiles rotated files. If
+	// they are zero, DefaultAuditLogMaxBytes and DefaultAuditLogMaxFiles
+	// are used; a negative AuditLogMaxBytes never rotates the file, and a
+	// negative AuditLogMaxFiles keeps no rotated file.
+	AuditLogPath     string
+	AuditLogMaxBytes int64
+	AuditLogMaxFiles int
+
+	// RepairWAL discards the torn last record of the WAL, e.g. after a
+	// power loss, instead of refusing to start.
+	RepairWAL bool
+
+	// SnapCatchUpEntries is the number of entries kept in the raft log
+	// before the index of a snapshot when the log is compacted, so that a
+	// slow follower catches up from them instead of the snapshot. If it is
+	// zero, DefaultSnapCatchUpEntries is used.
+	SnapCatchUpEntries uint64
+
+	// MaxSizePerMsg limits the size of a raft append message, and
+	// MaxInflightMsgs the number of the append messages in flight to a
+	// follower. The links of a high latency or bandwidth may need them
+	// raised; beyond 512 messages the rafthttp buffers may overflow and drop
+	// the messages, which are then resent. If they are zero,
+	// DefaultMaxSizePerMsg and DefaultMaxInflightMsgs are used.
+	MaxSizePerMsg   uint64
+	MaxInflightMsgs int
+
+	// PreferredLeaderZone is the zone the leader is preferred in. A member
+	// whose ZoneLabel is another zone waits about another election timeout
+	// before campaigning, so that the members of the preferred zone win the
+	// elections while they are up. Empty has no preference.
+	PreferredLeaderZone string
+
+	// PreVote enables the raft Pre-Vote, so that a partitioned member
+	// does not disrupt the cluster by raising the term when it rejoins.
+	// The members older than 2.1 cannot read its messages, so it is only
+	// enabled if the cluster version supports it when the member starts.
+	PreVote bool
+
+	// WALStorage reads the raft log back from the WAL files, caching only
+	// the latest entries in memory, instead of holding all the entries
+	// since the last compaction in memory.
+	WALStorage bool
+
+	// PeerCompression is the compression of the raft streams sent to the
+	// peers which can decode it, rafthttp.CompressionNone or
+	// rafthttp.CompressionGzip. Empty is CompressionNone.
+	PeerCompression string
+	// SnapshotSendRate limits the bytes per second of the snapshots sent
+	// to all the peers. Zero is unlimited.
+	SnapshotSendRate int64
 }
 
 // VerifyBootstrapConfig sanity-checks the initial config for bootstrap case
@@ -106,9 +257,18 @@ func (c *ServerConfig) verifyLocalMember(strict bool) error {
 
 func (c *ServerConfig) MemberDir() string { return path.Join(c.DataDir, "member") }
 
-func (c *ServerConfig) WALDir() string { return path.Join(c.MemberDir(), "wal") }
+// WALDir returns the dir of the WAL, which is DedicatedWALDir if it is
+// set, e.g. on a dedicated disk, or the wal dir under the member dir.
+func (c *ServerConfig) WALDir() string {
+	if c.DedicatedWALDir != "" {
+		return c.DedicatedWALDir
+	}
+	return path.Join(c.MemberDir(), "wal")
+}
 
 func (c *ServerConfig) SnapDir() string { return path.Join(c.MemberDir(), "snap") }
+
+func (c *ServerConfig) StoreDir() string { return path.Join(c.MemberDir(), "store") }
 // 是否启用服务发现
 func (c *ServerConfig) ShouldDiscover() bool { return c.DiscoveryURL != "" }
 
@@ -118,14 +278,115 @@ func (c *ServerConfig) Print() { c.print(false) }
 
 func (c *ServerConfig) print(initial bool) {
 	log.Printf("etcdserver: name = %s", c.Name)
+	if len(c.Labels) != 0 {
+		log.Printf("etcdserver: labels = %v", c.Labels)
+	}
 	if c.ForceNewCluster {
 		log.Println("etcdserver: force new cluster")
 	}
+	if c.RestoreSnapshot != "" {
+		log.Printf("etcdserver: restore from snapshot = %s", c.RestoreSnapshot)
+	}
 	log.Printf("etcdserver: data dir = %s", c.DataDir)
 	log.Printf("etcdserver: member dir = %s", c.MemberDir())
+	if c.DedicatedWALDir != "" {
+		log.Printf("etcdserver: dedicated wal dir = %s", c.DedicatedWALDir)
+	}
 	log.Printf("etcdserver: heartbeat = %dms", c.TickMs)
 	log.Printf("etcdserver: election = %dms", c.ElectionTicks*int(c.TickMs))
 	log.Printf("etcdserver: snapshot count = %d", c.SnapCount)
+	if c.SnapCatchUpEntries != 0 {
+		log.Printf("etcdserver: snapshot catch-up entries = %d", c.SnapCatchUpEntries)
+	}
+	if c.MaxSizePerMsg != 0 {
+		log.Printf("etcdserver: max size per raft message = %d", c.MaxSizePerMsg)
+	}
+	if c.MaxInflightMsgs != 0 {
+		log.Printf("etcdserver: max inflight raft messages = %d", c.MaxInflightMsgs)
+	}
+	if c.PreferredLeaderZone != "" {
+		log.Printf("etcdserver: preferred leader zone = %s (member zone = %q)", c.PreferredLeaderZone, c.Labels[ZoneLabel])
+	}
+	if c.StoreBackend != "" {
+		log.Printf("etcdserver: store backend = %s", c.StoreBackend)
+	}
+	if c.SnapshotFormat != "" {
+		log.Printf("etcdserver: snapshot format = %s", c.SnapshotFormat)
+	}
+	if c.WatchHistorySize != 0 {
+		log.Printf("etcdserver: watch history size = %d", c.WatchHistorySize)
+	}
+	if c.ClientCertAuthEnabled {
+		log.Println("etcdserver: client cert authentication enabled")
+	}
+	if q := c.quotaBackendBytes(); q > 0 {
+		log.Printf("etcdserver: quota backend bytes = %d", q)
+	} else {
+		log.Println("etcdserver: quota backend disabled")
+	}
+	if n := c.minFreeDiskBytes(); n > 0 {
+		log.Printf("etcdserver: min free disk bytes = %d", n)
+	} else {
+		log.Println("etcdserver: free disk space check disabled")
+	}
+	if n := c.maxValueBytes(); n > 0 {
+		log.Printf("etcdserver: max value bytes = %d", n)
+	} else {
+		log.Println("etcdserver: max value bytes unlimited")
+	}
+	if n := c.maxRequestBytes(); n > 0 {
+		log.Printf("etcdserver: max request bytes = %d", n)
+	} else {
+		log.Println("etcdserver: max request bytes unlimited")
+	}
+	if n := c.maxInflightProposals(); n > 0 {
+		log.Printf("etcdserver: max inflight proposals = %d", n)
+	} else {
+		log.Println("etcdserver: max inflight proposals unlimited")
+	}
+	if n := c.fdShedWatchPercent(); n > 0 {
+		log.Printf("etcdserver: shed watches at %d%% of the file descriptor limit", n)
+	} else {
+		log.Println("etcdserver: watch shedding disabled")
+	}
+	if c.SlowRequestThreshold > 0 {
+		log.Printf("etcdserver: slow request threshold = %v", c.SlowRequestThreshold)
+	}
+	if c.HashCheckInterval > 0 {
+		log.Printf("etcdserver: hash check interval = %v", c.HashCheckInterval)
+	}
+	if c.AutoCompactionRetention > 0 {
+		log.Printf("etcdserver: auto compaction retention = %v", c.AutoCompactionRetention)
+	}
+	if d := c.syncInterval(); d > 0 {
+		log.Printf("etcdserver: sync interval = %v", d)
+	} else {
+		log.Println("etcdserver: sync disabled")
+	}
+	if c.WALSyncWindow > 0 {
+		log.Printf("etcdserver: wal sync window = %v", c.WALSyncWindow)
+	}
+	if c.Encryption != nil {
+		log.Println("etcdserver: encryption at rest enabled")
+	}
+	if c.AuditLogPath != "" {
+		log.Printf("etcdserver: audit log = %s, max bytes = %d, max files = %d", c.AuditLogPath, c.auditLogMaxBytes(), c.auditLogMaxFiles())
+	}
+	if c.RepairWAL {
+		log.Println("etcdserver: wal repair enabled")
+	}
+	if c.WALStorage {
+		log.Println("etcdserver: raft log read back from the wal")
+	}
+	if c.PeerDialTimeout != 0 || c.PeerReadTimeout != 0 || c.PeerWriteTimeout != 0 {
+		log.Printf("etcdserver: peer dial timeout = %v, read timeout = %v, write timeout = %v", c.PeerDialTimeout, c.PeerReadTimeout, c.PeerWriteTimeout)
+	}
+	if c.PeerCompression != "" && c.PeerCompression != rafthttp.CompressionNone {
+		log.Printf("etcdserver: peer streams compressed in %s", c.PeerCompression)
+	}
+	if c.SnapshotSendRate > 0 {
+		log.Printf("etcdserver: snapshot send rate = %d bytes/s", c.SnapshotSendRate)
+	}
 	if len(c.DiscoveryURL) != 0 {
 		log.Printf("etcdserver: discovery URL= %s", c.DiscoveryURL)
 		if len(c.DiscoveryProxy) != 0 {
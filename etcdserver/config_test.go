@@ -17,6 +17,7 @@ package etcdserver
 import (
 	"net/url"
 	"testing"
+	"time"
 
 	"github.com/coreos/etcd/pkg/types"
 )
@@ -182,6 +183,19 @@ func TestWALDir(t *testing.T) {
 	}
 }
 
+func TestDedicatedWALDir(t *testing.T) {
+	cfg := ServerConfig{
+		DataDir:         "/var/lib/etcd",
+		DedicatedWALDir: "/ssd/etcd/wal",
+	}
+	if g := cfg.WALDir(); g != "/ssd/etcd/wal" {
+		t.Errorf("WALDir()=%q, want=%q", g, "/ssd/etcd/wal")
+	}
+	if g := cfg.SnapDir(); g != "/var/lib/etcd/member/snap" {
+		t.Errorf("SnapDir()=%q, want=%q", g, "/var/lib/etcd/member/snap")
+	}
+}
+
 func TestShouldDiscover(t *testing.T) {
 	tests := map[string]bool{
 		"":    false,
@@ -197,3 +211,60 @@ func TestShouldDiscover(t *testing.T) {
 		}
 	}
 }
+
+func TestSyncInterval(t *testing.T) {
+	tests := map[time.Duration]time.Duration{
+		0:                     DefaultSyncInterval,
+		-1:                    0,
+		50 * time.Millisecond: 50 * time.Millisecond,
+		2 * time.Second:       2 * time.Second,
+	}
+	for d, w := range tests {
+		cfg := ServerConfig{
+			SyncInterval: d,
+		}
+		if g := cfg.syncInterval(); g != w {
+			t.Errorf("SyncInterval=%v: syncInterval()=%v, want=%v", d, g, w)
+		}
+	}
+}
+
+func TestMinFreeDiskBytes(t *testing.T) {
+	tests := map[int64]int64{
+		0:       DefaultMinFreeDiskBytes,
+		-1:      0,
+		1 << 20: 1 << 20,
+	}
+	for n, w := range tests {
+		cfg := ServerConfig{
+			MinFreeDiskBytes: n,
+		}
+		if g := cfg.minFreeDiskBytes(); g != w {
+			t.Errorf("MinFreeDiskBytes=%d: minFreeDiskBytes()=%d, want=%d", n, g, w)
+		}
+	}
+}
+
+func TestFDPercents(t *testing.T) {
+	tests := []struct {
+		n     int
+		wwarn int
+		wshed int
+	}{
+		{0, DefaultFDWarnPercent, DefaultFDShedWatchPercent},
+		{-1, 0, 0},
+		{70, 70, 70},
+	}
+	for _, tt := range tests {
+		cfg := ServerConfig{
+			FDWarnPercent:      tt.n,
+			FDShedWatchPercent: tt.n,
+		}
+		if g := cfg.fdWarnPercent(); g != tt.wwarn {
+			t.Errorf("FDWarnPercent=%d: fdWarnPercent()=%d, want=%d", tt.n, g, tt.wwarn)
+		}
+		if g := cfg.fdShedWatchPercent(); g != tt.wshed {
+			t.Errorf("FDShedWatchPercent=%d: fdShedWatchPercent()=%d, want=%d", tt.n, g, tt.wshed)
+		}
+	}
+}
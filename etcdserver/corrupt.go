@@ -0,0 +1,244 @@
+// Copyright 2015 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package etcdserver
+
+import (
+	"encoding/json"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/coreos/etcd/Godeps/_workspace/src/golang.org/x/net/context"
+	"github.com/coreos/etcd/etcdserver/etcdhttp/httptypes"
+	pb "github.com/coreos/etcd/etcdserver/etcdserverpb"
+	"github.com/coreos/etcd/store"
+)
+
+const (
+	// maxStoreHashes is the number of the latest store hashes kept by a
+	// member for the leader to compare with.
+	maxStoreHashes = 8
+
+	// hashCheckTimeout is the time for a hash check to be committed and
+	// for the peers to return their hashes.
+	hashCheckTimeout = 5 * time.Second
+	// peerHashRetryInterval is the interval to ask a peer again for a
+	// hash it has not computed yet.
+	peerHashRetryInterval = 100 * time.Millisecond
+)
+
+// StoreHash is the hash of the store of a member at a store index.
+type StoreHash struct {
+	Index uint64
+	Hash  uint32
+}
+
+// Hasher returns the store hashes computed by the local member.
+type Hasher interface {
+	// StoreHash returns the hash of the store at the given store index,
+	// or the latest hash if the index is zero. It returns false if the
+	// member has not computed the hash.
+	StoreHash(index uint64) (StoreHash, bool)
+}
+
+// storeHashes keeps the latest store hashes of the local member.
+type storeHashes struct {
+	mu     sync.Mutex
+	hashes []StoreHash
+}
+
+func (hs *storeHashes) add(h StoreHash) {
+	hs.mu.Lock()
+	defer hs.mu.Unlock()
+	hs.hashes = append(hs.hashes, h)
+	if len(hs.hashes) > maxStoreHashes {
+		hs.hashes = hs.hashes[len(hs.hashes)-maxStoreHashes:]
+	}
+}
+
+func (hs *storeHashes) get(index uint64) (StoreHash, bool) {
+	hs.mu.Lock()
+	defer hs.mu.Unlock()
+	for i := len(hs.hashes) - 1; i >= 0; i-- {
+		if index == 0 || hs.hashes[i].Index == index {
+			return hs.hashes[i], true
+		}
+	}
+	return StoreHash{}, false
+}
+
+// hashStore returns the hash of the keys of the store. It hashes the
+// fields that are the same on all the members applying the same entries,
+// so the TTLs, which depend on the local clock, are left out. The hidden
+// keys are left out as well, since Get does not return them.
+func hashStore(st store.Store) uint32 {
+	e, err := st.Get("/", true, true)
+	if err != nil {
+		log.Panicf("get root should never fail: %v", err)
+	}
+	h := crc32.NewIEEE()
+	hashNode(h, e.Node)
+	return h.Sum32()
+}
+
+func hashNode(w io.Writer, n *store.NodeExtern) {
+	fmt.Fprintf(w, "%q %t %d %d", n.Key, n.Dir, n.CreatedIndex, n.ModifiedIndex)
+	if n.Value != nil {
+		fmt.Fprintf(w, " %q", *n.Value)
+	}
+	if n.Expiration != nil {
+		fmt.Fprintf(w, " %d", n.Expiration.UnixNano())
+	}
+	io.WriteString(w, "\n")
+	for _, c := range n.Nodes {
+		hashNode(w, c)
+	}
+}
+
+// needsConsistency reports whether the request reads or writes the keys,
+// and so is rejected while the CORRUPT alarm is active. The requests to
+// the admin keys, e.g. to disarm the alarm, are still served.
+func needsConsistency(r pb.Request) bool {
+	switch r.Method {
+	case "SYNC", "HASH", "COMPACT":
+		return false
+	case "TXN":
+		for _, ops := range [][]pb.Request{r.Success, r.Failure} {
+			for _, op := range ops {
+				if !isAdminPath(op.Path) {
+					return true
+				}
+			}
+		}
+		for _, c := range r.Compares {
+			if !isAdminPath(c.Path) {
+				return true
+			}
+		}
+		return false
+	default:
+		return !isAdminPath(r.Path)
+	}
+}
+
+// StoreHash returns the store hash computed by the local member at the
+// given store index, or the latest one if the index is zero.
+func (s *EtcdServer) StoreHash(index uint64) (StoreHash, bool) {
+	return s.hashes.get(index)
+}
+
+// monitorHashes checks the store hashes of the members every interval,
+// while the local member is the leader.
+// 定时比较各member的store hash，发现不一致时触发CORRUPT告警
+func (s *EtcdServer) monitorHashes(interval time.Duration) {
+	if interval <= 0 {
+		return
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+		case <-s.done:
+			return
+		}
+		if s.Leader() != s.ID() {
+			continue
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), hashCheckTimeout)
+		if err := s.checkHashes(ctx); err != nil {
+			log.Printf("etcdserver: failed to check the store hashes: %v", err)
+		}
+		cancel()
+	}
+}
+
+// checkHashes proposes a HASH request, so that all the members hash the
+// store at the same index when applying it, and compares the hashes of
+// the peers with the local one. It raises the CORRUPT alarm for the
+// peers whose hash differs.
+func (s *EtcdServer) checkHashes(ctx context.Context) error {
+	if _, err := s.Do(ctx, pb.Request{Method: "HASH"}); err != nil {
+		return err
+	}
+	local, ok := s.hashes.get(0)
+	if !ok {
+		return fmt.Errorf("no local store hash")
+	}
+	for _, m := range s.Cluster.Members() {
+		if m.ID == s.id {
+			continue
+		}
+		h, err := s.peerHash(ctx, m, local.Index)
+		if err != nil {
+			log.Printf("etcdserver: cannot get the store hash of %s at index %d: %v", m.ID, local.Index, err)
+			continue
+		}
+		if h.Hash != local.Hash {
+			log.Printf("etcdserver: the store hash of %s is %d at index %d, want %d", m.ID, h.Hash, local.Index, local.Hash)
+			s.raiseAlarm(AlarmCorrupt, m.ID)
+		}
+	}
+	return nil
+}
+
+// peerHash asks the member for its store hash at the given index, until
+// the member has applied the HASH request or ctx is done.
+func (s *EtcdServer) peerHash(ctx context.Context, m *Member, index uint64) (StoreHash, error) {
+	cc := &http.Client{
+		Transport: s.cfg.Transport,
+		Timeout:   time.Second,
+	}
+	var lerr error
+	for {
+		for _, u := range m.PeerURLs {
+			resp, err := cc.Get(u + "/hash?index=" + strconv.FormatUint(index, 10))
+			if err != nil {
+				lerr = err
+				continue
+			}
+			var hr httptypes.HashResponse
+			switch resp.StatusCode {
+			case http.StatusOK:
+				err = json.NewDecoder(resp.Body).Decode(&hr)
+			case http.StatusNotFound:
+				err = fmt.Errorf("hash not computed")
+			default:
+				err = fmt.Errorf("unexpected status %s", resp.Status)
+			}
+			resp.Body.Close()
+			if err != nil {
+				lerr = err
+				continue
+			}
+			return StoreHash{Index: hr.Index, Hash: hr.Hash}, nil
+		}
+		select {
+		case <-time.After(peerHashRetryInterval):
+		case <-ctx.Done():
+			if lerr == nil {
+				lerr = ctx.Err()
+			}
+			return StoreHash{}, lerr
+		case <-s.done:
+			return StoreHash{}, ErrStopped
+		}
+	}
+}
@@ -0,0 +1,176 @@
+// Copyright 2015 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package etcdserver
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/coreos/etcd/Godeps/_workspace/src/golang.org/x/net/context"
+	"github.com/coreos/etcd/etcdserver/etcdhttp/httptypes"
+	pb "github.com/coreos/etcd/etcdserver/etcdserverpb"
+	"github.com/coreos/etcd/pkg/idutil"
+	"github.com/coreos/etcd/pkg/types"
+	"github.com/coreos/etcd/raft"
+	"github.com/coreos/etcd/store"
+)
+
+func TestHashStore(t *testing.T) {
+	newStore := func(val string) store.Store {
+		st := store.New()
+		st.Set("/1/foo", false, "bar", store.Permanent)
+		st.Set("/1/dir/foo", false, val, time.Unix(100, 0))
+		return st
+	}
+	h := hashStore(newStore("bar"))
+	if g := hashStore(newStore("bar")); g != h {
+		t.Errorf("hash = %d, want %d", g, h)
+	}
+	if g := hashStore(newStore("baz")); g == h {
+		t.Errorf("hash = %d, want a different hash", g)
+	}
+}
+
+func TestStoreHashes(t *testing.T) {
+	var hs storeHashes
+	if _, ok := hs.get(0); ok {
+		t.Errorf("ok = %v, want false", ok)
+	}
+	for i := uint64(1); i <= maxStoreHashes+2; i++ {
+		hs.add(StoreHash{Index: i, Hash: uint32(i)})
+	}
+	tests := []struct {
+		index uint64
+
+		wh  StoreHash
+		wok bool
+	}{
+		{0, StoreHash{Index: maxStoreHashes + 2, Hash: maxStoreHashes + 2}, true},
+		{3, StoreHash{Index: 3, Hash: 3}, true},
+		{2, StoreHash{}, false},
+		{maxStoreHashes + 3, StoreHash{}, false},
+	}
+	for i, tt := range tests {
+		h, ok := hs.get(tt.index)
+		if h != tt.wh || ok != tt.wok {
+			t.Errorf("#%d: get = %+v, %v, want %+v, %v", i, h, ok, tt.wh, tt.wok)
+		}
+	}
+}
+
+func TestApplyRequestHash(t *testing.T) {
+	st := store.New()
+	st.Set("/1/foo", false, "bar", store.Permanent)
+	srv := &EtcdServer{store: st, Cluster: &Cluster{}}
+	if resp := srv.applyRequest(pb.Request{Method: "HASH"}); resp.err != nil {
+		t.Fatalf("err = %v, want nil", resp.err)
+	}
+	w := StoreHash{Index: st.Index(), Hash: hashStore(st)}
+	if h, ok := srv.StoreHash(st.Index()); !ok || h != w {
+		t.Errorf("hash = %+v, %v, want %+v, true", h, ok, w)
+	}
+}
+
+func TestApplyRequestCorrupt(t *testing.T) {
+	st := store.New()
+	cl := newCluster("abc")
+	cl.SetStore(st)
+	st.Set(alarmStorePath(AlarmCorrupt, 1), false, "", store.Permanent)
+	cl.RecoverAlarms()
+	srv := &EtcdServer{store: st, Cluster: cl}
+
+	tests := []struct {
+		req  pb.Request
+		werr error
+	}{
+		{pb.Request{Method: "PUT", Path: "/1/foo"}, ErrCorrupt},
+		{pb.Request{Method: "DELETE", Path: "/1/foo"}, ErrCorrupt},
+		{pb.Request{Method: "QGET", Path: "/1/foo"}, ErrCorrupt},
+		{pb.Request{Method: "TXN", Success: []pb.Request{{Method: "DELETE", Path: "/1/foo"}}}, ErrCorrupt},
+		{pb.Request{Method: "PUT", Path: "/0/foo"}, nil},
+		{pb.Request{Method: "DELETE", Path: alarmStorePath(AlarmCorrupt, 1)}, nil},
+		{pb.Request{Method: "HASH"}, nil},
+		{pb.Request{Method: "COMPACT"}, nil},
+	}
+	for i, tt := range tests {
+		resp := srv.applyRequest(tt.req)
+		if resp.err != tt.werr {
+			t.Errorf("#%d: err = %v, want %v", i, resp.err, tt.werr)
+		}
+	}
+}
+
+// TestCheckHashes tests that checkHashes raises the CORRUPT alarm for the
+// peers whose store hash differs from the local one.
+func TestCheckHashes(t *testing.T) {
+	st := store.New()
+	cl := newCluster("abc")
+	cl.SetStore(st)
+	cl.version = "2.1.0"
+	srv := &EtcdServer{
+		id:  1,
+		cfg: &ServerConfig{Transport: &http.Transport{}},
+		r: raftNode{
+			Node:        newNodeCommitter(),
+			storage:     &storageRecorder{},
+			raftStorage: raft.NewMemoryStorage(),
+			transport:   &nopTransporter{},
+		},
+		store:    st,
+		Cluster:  cl,
+		reqIDGen: idutil.NewGenerator(0, time.Time{}),
+	}
+	srv.start()
+	defer srv.Stop()
+
+	newPeer := func(diff uint32) *httptest.Server {
+		return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			index, _ := strconv.ParseUint(r.FormValue("index"), 10, 64)
+			h, ok := srv.StoreHash(index)
+			if !ok {
+				http.NotFound(w, r)
+				return
+			}
+			json.NewEncoder(w).Encode(httptypes.HashResponse{Index: h.Index, Hash: h.Hash + diff})
+		}))
+	}
+	good, bad := newPeer(0), newPeer(1)
+	defer good.Close()
+	defer bad.Close()
+	cl.SetTransport(&nopTransporter{})
+	cl.AddMember(newTestMember(1, nil, "node1", nil), 1)
+	cl.AddMember(newTestMember(2, []string{good.URL}, "node2", nil), 2)
+	cl.AddMember(newTestMember(3, []string{bad.URL}, "node3", nil), 3)
+
+	if _, err := srv.Do(context.Background(), pb.Request{Method: "PUT", Path: "/foo", Val: "bar"}); err != nil {
+		t.Fatalf("err = %v, want nil", err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if err := srv.checkHashes(ctx); err != nil {
+		t.Fatalf("err = %v, want nil", err)
+	}
+	if w := []Alarm{{MemberID: types.ID(3), Type: AlarmCorrupt}}; !reflect.DeepEqual(srv.Alarms(), w) {
+		t.Errorf("alarms = %+v, want %+v", srv.Alarms(), w)
+	}
+	if _, err := srv.Do(context.Background(), pb.Request{Method: "GET", Path: "/foo"}); err != ErrCorrupt {
+		t.Errorf("err = %v, want %v", err, ErrCorrupt)
+	}
+}
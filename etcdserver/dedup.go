@@ -0,0 +1,54 @@
+// Copyright 2015 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package etcdserver
+
+import (
+	etcdErr "github.com/coreos/etcd/error"
+	pb "github.com/coreos/etcd/etcdserver/etcdserverpb"
+	"github.com/coreos/etcd/store"
+)
+
+// applyRequestOnce applies r, unless a request with the same token has
+// been applied already, whose result is returned instead. A client that
+// retries a write after a timeout thus does not apply it twice.
+// The results are recorded in the store as the entries are applied, so
+// all the members return the same result.
+// 根据请求的token去重，重试的请求直接返回第一次应用的结果
+func (s *EtcdServer) applyRequestOnce(r pb.Request) Response {
+	if r.Token == "" {
+		return s.applyRequest(r)
+	}
+	if rr, ok := s.store.RequestResult(r.Token); ok {
+		dedupedRequests.Inc()
+		resp := Response{Event: rr.Event, Txn: rr.Txn}
+		if rr.Err != nil {
+			resp.err = rr.Err
+		}
+		return resp
+	}
+	resp := s.applyRequest(r)
+	rr := &store.RequestResult{Token: r.Token, Event: resp.Event, Txn: resp.Txn}
+	switch err := resp.err.(type) {
+	case nil:
+	case *etcdErr.Error:
+		rr.Err = err
+	default:
+		// the request is rejected by the server, e.g. for lack of
+		// space, and not applied; a retry may be applied
+		return resp
+	}
+	s.store.RecordRequestResult(rr)
+	return resp
+}
@@ -0,0 +1,375 @@
+// Copyright 2015 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package etcdserver
+
+import (
+	"encoding/json"
+	"log"
+	"net/url"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/coreos/etcd/Godeps/_workspace/src/golang.org/x/net/context"
+	etcdErr "github.com/coreos/etcd/error"
+	pb "github.com/coreos/etcd/etcdserver/etcdserverpb"
+	"github.com/coreos/etcd/pkg/pbutil"
+	"github.com/coreos/etcd/store"
+)
+
+var storeDirQuotasPrefix = path.Join(StoreAdminPrefix, "dir_quotas")
+
+// DirQuota limits the number of the nodes under a directory, at any
+// depth, and the total size of their values. A zero limit is unlimited.
+type DirQuota struct {
+	// Dir is the path of the directory in the key space.
+	Dir      string `json:"dir"`
+	MaxKeys  int64  `json:"maxKeys,omitempty"`
+	MaxBytes int64  `json:"maxBytes,omitempty"`
+}
+
+// DirQuotaer lists and changes the quotas of the directories.
+type DirQuotaer interface {
+	// DirQuotas returns the quotas sorted by their directory.
+	DirQuotas() []DirQuota
+	// SetDirQuota sets the quota of the directory, replacing its
+	// current quota if any.
+	SetDirQuota(ctx context.Context, q DirQuota) error
+	// RemoveDirQuota removes the quota of the directory.
+	RemoveDirQuota(ctx context.Context, dir string) error
+}
+
+// dirQuotaStorePath returns the store path of the quota of the directory.
+// The quotas are kept in the store, so they go through raft and are
+// recovered from the snapshots. The directory is escaped, so that the
+// quotas of the nested directories do not nest.
+// 目录quota保存在store里，通过raft同步到所有member
+func dirQuotaStorePath(dir string) string {
+	return path.Join(storeDirQuotasPrefix, url.QueryEscape(dir))
+}
+
+// dirQuotaSet keeps the quotas of the directories in memory, so that
+// the requests are checked against them without reading the store. The
+// zero value is an empty set.
+type dirQuotaSet struct {
+	mu sync.RWMutex
+	// quotas maps the store paths of the directories to their quotas.
+	quotas map[string]DirQuota
+}
+
+// recover reloads the quotas from the store.
+func (qs *dirQuotaSet) recover(st store.Store) {
+	quotas := dirQuotasFromStore(st)
+	qs.mu.Lock()
+	defer qs.mu.Unlock()
+	qs.quotas = quotas
+}
+
+func (qs *dirQuotaSet) list() []DirQuota {
+	qs.mu.RLock()
+	defer qs.mu.RUnlock()
+	var quotas []DirQuota
+	for _, q := range qs.quotas {
+		quotas = append(quotas, q)
+	}
+	sort.Sort(dirQuotasByDir(quotas))
+	return quotas
+}
+
+// covering returns the quotas of the directories the node of the given
+// store path is under, keyed by the store paths of the directories.
+func (qs *dirQuotaSet) covering(p string) map[string]DirQuota {
+	qs.mu.RLock()
+	defer qs.mu.RUnlock()
+	if len(qs.quotas) == 0 {
+		return nil
+	}
+	var quotas map[string]DirQuota
+	for d := path.Dir(p); ; d = path.Dir(d) {
+		if q, ok := qs.quotas[d]; ok {
+			if quotas == nil {
+				quotas = make(map[string]DirQuota)
+			}
+			quotas[d] = q
+		}
+		if d == "/" {
+			return quotas
+		}
+	}
+}
+
+func dirQuotasFromStore(st store.Store) map[string]DirQuota {
+	quotas := make(map[string]DirQuota)
+	e, err := st.Get(storeDirQuotasPrefix, false, false)
+	if err != nil {
+		if isKeyNotFound(err) {
+			return quotas
+		}
+		log.Panicf("get storeDirQuotas should never fail: %v", err)
+	}
+	for _, n := range e.Node.Nodes {
+		var q DirQuota
+		if err := json.Unmarshal([]byte(*n.Value), &q); err != nil {
+			log.Panicf("unmarshal dir quota %s should never fail: %v", n.Key, err)
+		}
+		quotas[path.Join(StoreKeysPrefix, q.Dir)] = q
+	}
+	return quotas
+}
+
+type dirQuotasByDir []DirQuota
+
+func (a dirQuotasByDir) Len() int           { return len(a) }
+func (a dirQuotasByDir) Swap(i, j int)      { a[i], a[j] = a[j], a[i] }
+func (a dirQuotasByDir) Less(i, j int) bool { return a[i].Dir < a[j].Dir }
+
+// DirQuotas returns the quotas of the directories.
+func (s *EtcdServer) DirQuotas() []DirQuota { return s.Cluster.DirQuotas() }
+
+// SetDirQuota sets the quota of the directory through consensus. It
+// returns ErrInvalidDirQuota if a limit is negative.
+func (s *EtcdServer) SetDirQuota(ctx context.Context, q DirQuota) error {
+	if q.MaxKeys < 0 || q.MaxBytes < 0 {
+		return ErrInvalidDirQuota
+	}
+	q.Dir = path.Clean(path.Join("/", q.Dir))
+	b, err := json.Marshal(q)
+	if err != nil {
+		log.Panicf("marshal dir quota should never fail: %v", err)
+	}
+	req := pb.Request{
+		Method: "PUT",
+		Path:   dirQuotaStorePath(q.Dir),
+		Val:    string(b),
+	}
+	if _, err := s.Do(ctx, req); err != nil {
+		return err
+	}
+	log.Printf("etcdserver: set quota of %s to %d keys and %d bytes", q.Dir, q.MaxKeys, q.MaxBytes)
+	return nil
+}
+
+// RemoveDirQuota removes the quota of the directory through consensus.
+// It returns nil if the directory has no quota.
+func (s *EtcdServer) RemoveDirQuota(ctx context.Context, dir string) error {
+	dir = path.Clean(path.Join("/", dir))
+	req := pb.Request{
+		Method: "DELETE",
+		Path:   dirQuotaStorePath(dir),
+	}
+	_, err := s.Do(ctx, req)
+	if err != nil && !isKeyNotFound(err) {
+		return err
+	}
+	log.Printf("etcdserver: removed quota of %s", dir)
+	return nil
+}
+
+// isDirQuotaRequest reports whether the request changes the quotas of
+// the directories.
+func isDirQuotaRequest(r pb.Request) bool {
+	return r.Path == storeDirQuotasPrefix || strings.HasPrefix(r.Path, storeDirQuotasPrefix+"/")
+}
+
+// dirGrowth is the change of the number of the nodes under a node and
+// the total size of their values.
+type dirGrowth struct {
+	nodes      int64
+	valueBytes int64
+}
+
+// checkDirQuotas returns an EcodeQuotaExceeded error if the request would
+// take a directory beyond its quota. It is called at apply time, so all
+// the members reject the same requests. A request that does not grow a
+// directory is allowed even if the directory is over its quota already.
+// Each branch of a TXN request is checked on its own.
+// 在apply时检查目录quota，所有member对同一请求得到相同结果
+func (s *EtcdServer) checkDirQuotas(r pb.Request) error {
+	if !needsQuota(r) {
+		return nil
+	}
+	if r.Method == "TXN" {
+		for _, ops := range [][]pb.Request{r.Success, r.Failure} {
+			if err := s.checkDirQuotaOps(ops); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	return s.checkDirQuotaOps([]pb.Request{r})
+}
+
+func (s *EtcdServer) checkDirQuotaOps(ops []pb.Request) error {
+	growths := make(map[string]*dirGrowth)
+	quotas := make(map[string]DirQuota)
+	add := func(p string, nodes, valueBytes int64) {
+		for d, q := range s.Cluster.dirQuotas.covering(p) {
+			g, ok := growths[d]
+			if !ok {
+				g = &dirGrowth{}
+				growths[d] = g
+				quotas[d] = q
+			}
+			g.nodes += nodes
+			g.valueBytes += valueBytes
+		}
+	}
+	for _, op := range ops {
+		// the nodes a POST creates are under its path
+		if len(s.Cluster.dirQuotas.covering(path.Join(op.Path, "_"))) == 0 &&
+			(op.MoveTo == "" || len(s.Cluster.dirQuotas.covering(op.MoveTo)) == 0) {
+			continue
+		}
+		s.dirQuotaOpGrowth(op, add)
+	}
+
+	dirs := make([]string, 0, len(growths))
+	for d := range growths {
+		dirs = append(dirs, d)
+	}
+	sort.Strings(dirs)
+	for _, d := range dirs {
+		g, q := growths[d], quotas[d]
+		if g.nodes <= 0 && g.valueBytes <= 0 {
+			continue
+		}
+		nodes, valueBytes, err := s.store.Usage(d)
+		if err != nil && !isKeyNotFound(err) {
+			// the directory is a key; the request fails anyway.
+			continue
+		}
+		if (g.nodes > 0 && q.MaxKeys > 0 && nodes+g.nodes > q.MaxKeys) ||
+			(g.valueBytes > 0 && q.MaxBytes > 0 && valueBytes+g.valueBytes > q.MaxBytes) {
+			return etcdErr.NewError(etcdErr.EcodeQuotaExceeded, d, s.store.Index())
+		}
+	}
+	return nil
+}
+
+// dirQuotaOpGrowth calls add with the changes the operation makes to the
+// nodes at the given store paths, as if it were applied on its own.
+func (s *EtcdServer) dirQuotaOpGrowth(op pb.Request, add func(p string, nodes, valueBytes int64)) {
+	switch op.Method {
+	case "POST":
+		// the new node gets a unique name under the path
+		s.mkdirsGrowth(op.Path, add)
+		if op.Dir {
+			add(path.Join(op.Path, "_"), 1, 0)
+		} else {
+			add(path.Join(op.Path, "_"), 1, int64(len(op.Val)))
+		}
+	case "PUT":
+		if op.Refresh {
+			return
+		}
+		exists, existsSet := pbutil.GetBool(op.PrevExist)
+		if (existsSet && exists) || op.PrevIndex > 0 || op.PrevValue != "" {
+			// an update never creates the node or its parents
+			if n := s.getNode(op.Path); n != nil && !n.Dir {
+				add(op.Path, 0, int64(len(op.Val)-len(*n.Value)))
+			}
+			return
+		}
+		s.putGrowth(op.Path, op.Dir, op.Val, add)
+	case "INCR":
+		n := s.getNode(op.Path)
+		if n == nil {
+			s.putGrowth(op.Path, false, strconv.FormatInt(op.Delta, 10), add)
+			return
+		}
+		if n.Dir {
+			return
+		}
+		// a value that is not a number, or overflows, fails the request
+		if v, err := strconv.ParseInt(*n.Value, 10, 64); err == nil {
+			add(op.Path, 0, int64(len(strconv.FormatInt(v+op.Delta, 10))-len(*n.Value)))
+		}
+	case "MOVE":
+		nodes, valueBytes, ok := s.treeUsage(op.Path)
+		if !ok {
+			return
+		}
+		add(op.Path, -nodes, -valueBytes)
+		s.mkdirsGrowth(path.Dir(op.MoveTo), add)
+		add(op.MoveTo, nodes, valueBytes)
+	case "IMPORT":
+		var ex store.Export
+		if err := json.Unmarshal([]byte(op.Val), &ex); err != nil || ex.Validate() != nil {
+			// an invalid export fails the request
+			return
+		}
+		nodes, valueBytes := exportUsage(ex.Node)
+		s.mkdirsGrowth(path.Dir(op.Path), add)
+		add(op.Path, nodes, valueBytes)
+	case "DELETE":
+		if nodes, valueBytes, ok := s.treeUsage(op.Path); ok {
+			add(op.Path, -nodes, -valueBytes)
+		}
+	}
+}
+
+// putGrowth calls add with the changes of setting the node of the given
+// store path, and of creating its missing parents.
+func (s *EtcdServer) putGrowth(p string, dir bool, value string, add func(p string, nodes, valueBytes int64)) {
+	if n := s.getNode(p); n != nil {
+		if !n.Dir && !dir {
+			add(p, 0, int64(len(value)-len(*n.Value)))
+		}
+		return
+	}
+	s.mkdirsGrowth(path.Dir(p), add)
+	if dir {
+		add(p, 1, 0)
+	} else {
+		add(p, 1, int64(len(value)))
+	}
+}
+
+// mkdirsGrowth calls add for each missing directory on the given store path.
+func (s *EtcdServer) mkdirsGrowth(dir string, add func(p string, nodes, valueBytes int64)) {
+	for d := dir; d != "/" && s.getNode(d) == nil; d = path.Dir(d) {
+		add(d, 1, 0)
+	}
+}
+
+// treeUsage returns the number of the nodes of the tree of the node of
+// the given store path, including the node itself, and the total size of
+// their values. It returns false if the node does not exist.
+func (s *EtcdServer) treeUsage(p string) (int64, int64, bool) {
+	n := s.getNode(p)
+	if n == nil {
+		return 0, 0, false
+	}
+	if !n.Dir {
+		return 1, int64(len(*n.Value)), true
+	}
+	nodes, valueBytes, err := s.store.Usage(p)
+	if err != nil {
+		return 0, 0, false
+	}
+	return nodes + 1, valueBytes, true
+}
+
+// getNode returns the node of the given store path, or nil if it does
+// not exist.
+func (s *EtcdServer) getNode(p string) *store.NodeExtern {
+	e, err := s.store.Get(p, false, false)
+	if err != nil {
+		return nil
+	}
+	return e.Node
+}
@@ -0,0 +1,115 @@
+// Copyright 2015 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package etcdserver
+
+import (
+	"log"
+	"sync/atomic"
+	"time"
+
+	"github.com/coreos/etcd/pkg/fileutil"
+)
+
+const (
+	// DefaultMinFreeDiskBytes is the free space the filesystems of the WAL
+	// and the snapshots keep by default: room for a few preallocated WAL
+	// files and a snapshot.
+	DefaultMinFreeDiskBytes = 256 * 1024 * 1024
+
+	// monitorDiskInterval is the interval at which the member checks the
+	// free space of its filesystems.
+	monitorDiskInterval = time.Second
+)
+
+// minFreeDiskBytes returns the free space below which the member rejects
+// the proposals, or zero if the check is disabled.
+func (c *ServerConfig) minFreeDiskBytes() int64 {
+	switch {
+	case c.MinFreeDiskBytes == 0:
+		return DefaultMinFreeDiskBytes
+	case c.MinFreeDiskBytes < 0:
+		return 0
+	default:
+		return c.MinFreeDiskBytes
+	}
+}
+
+// lowestFreeDisk returns the dir whose filesystem has the least free
+// space, and its free bytes.
+func lowestFreeDisk(dirs []string) (string, uint64, error) {
+	var ldir string
+	var lfree uint64
+	for _, dir := range dirs {
+		free, err := fileutil.FreeDiskBytes(dir)
+		if err != nil {
+			return "", 0, err
+		}
+		if ldir == "" || free < lfree {
+			ldir, lfree = dir, free
+		}
+	}
+	return ldir, lfree, nil
+}
+
+// monitorDisk checks the free space of the filesystems of the WAL and the
+// snapshots every monitorDiskInterval. While it is below min, the member
+// rejects the proposals of its clients, raises the LOWDISK alarm and
+// hands the leadership over, so that a full disk never tears a write to
+// the WAL. The alarm is cleared once the space is freed.
+// 磁盘剩余空间不足时拒绝本地proposal并触发LOWDISK告警，leader让出leadership
+func (s *EtcdServer) monitorDisk(min int64) {
+	if min <= 0 {
+		return
+	}
+	dirs := []string{s.cfg.SnapDir(), s.cfg.WALDir()}
+	ticker := time.NewTicker(monitorDiskInterval)
+	defer ticker.Stop()
+	for {
+		dir, free, err := lowestFreeDisk(dirs)
+		if err != nil {
+			log.Printf("etcdserver: cannot monitor the free disk space (%v)", err)
+			return
+		}
+		s.setDiskLow(dir, free, min)
+		select {
+		case <-ticker.C:
+		case <-s.done:
+			return
+		}
+	}
+}
+
+func (s *EtcdServer) setDiskLow(dir string, free uint64, min int64) {
+	low := free < uint64(min)
+	if low != s.isDiskLow() {
+		if low {
+			log.Printf("etcdserver: %d bytes free on the filesystem of %s, below %d; rejecting the proposals", free, dir, min)
+			atomic.StoreInt32(&s.diskLow, 1)
+		} else {
+			log.Printf("etcdserver: %d bytes free on the filesystem of %s; accepting the proposals again", free, dir)
+			atomic.StoreInt32(&s.diskLow, 0)
+		}
+	}
+	switch {
+	case low:
+		s.raiseAlarm(AlarmLowDisk, s.id)
+		s.handOverLeadership("for low disk space")
+	case s.Cluster.alarms.raised(AlarmLowDisk, s.id):
+		s.clearAlarm(AlarmLowDisk, s.id)
+	}
+}
+
+// isDiskLow reports whether the free disk space is below the minimum.
+func (s *EtcdServer) isDiskLow() bool { return atomic.LoadInt32(&s.diskLow) == 1 }
@@ -20,6 +20,7 @@ import (
 	etcdErr "github.com/coreos/etcd/error"
 
 	"github.com/coreos/etcd/Godeps/_workspace/src/golang.org/x/net/context"
+	"github.com/coreos/etcd/raft"
 )
 
 var (
@@ -31,6 +32,66 @@ var (
 	ErrPeerURLexists = errors.New("etcdserver: peerURL exists")
 	ErrCanceled      = errors.New("etcdserver: request cancelled")
 	ErrTimeout       = errors.New("etcdserver: request timed out")
+	ErrNoLeader      = errors.New("etcdserver: no leader")
+	// ErrTimeoutDueToLeaderFail is returned when a proposal times out
+	// after the leader it was proposed to is lost. The request may be
+	// retried once a new leader is elected.
+	ErrTimeoutDueToLeaderFail = errors.New("etcdserver: request timed out, possibly due to leader failure")
+	// ErrTimeoutLeaderTransfer is returned when the transferee does not
+	// become leader before the request context is done.
+	ErrTimeoutLeaderTransfer = errors.New("etcdserver: request timed out, leader transfer took too long")
+	// ErrNoSpace is returned for the writes while the NOSPACE alarm is
+	// active.
+	ErrNoSpace = errors.New("etcdserver: no space")
+	// ErrLowDiskSpace is returned for the proposals of the clients of a
+	// member whose free disk space is below the minimum.
+	ErrLowDiskSpace = errors.New("etcdserver: low disk space")
+	// ErrUnknownAlarm is returned when disarming an alarm of an unknown type.
+	ErrUnknownAlarm = errors.New("etcdserver: unknown alarm")
+	// ErrProposalDropped is returned when raft drops a proposal, e.g.
+	// because there is no leader. The request may be retried, on this or
+	// another member.
+	ErrProposalDropped = errors.New("etcdserver: proposal dropped")
+	// ErrInvalidDirQuota is returned when setting a directory quota with
+	// a negative limit.
+	ErrInvalidDirQuota = errors.New("etcdserver: invalid directory quota")
+	// ErrInvalidRuntimeConfig is returned when setting a runtime config
+	// with a zero snapshot count, a negative interval or an unknown log
+	// level.
+	ErrInvalidRuntimeConfig = errors.New("etcdserver: invalid runtime config")
+	// ErrInvalidClusterConfig is returned when updating the cluster config
+	// with a setting that is not valid.
+	ErrInvalidClusterConfig = errors.New("etcdserver: invalid cluster config")
+	// ErrInvalidExport is returned when importing an export that is not
+	// in the current version or whose nodes do not form a tree.
+	ErrInvalidExport = errors.New("etcdserver: invalid export")
+	// ErrTooManyRequests is returned when too many proposals are in flight.
+	// The request may be retried later.
+	ErrTooManyRequests = errors.New("etcdserver: too many requests")
+	// ErrTooManyOpenFiles is returned for the new watches while the member
+	// uses too many file descriptors. The watch may be retried on another
+	// member.
+	ErrTooManyOpenFiles = errors.New("etcdserver: too many open files")
+	// ErrValueTooLarge is returned for the writes of a value beyond the
+	// max value size.
+	ErrValueTooLarge = errors.New("etcdserver: value is too large")
+	// ErrRequestTooLarge is returned for the writes beyond the max
+	// request size.
+	ErrRequestTooLarge = errors.New("etcdserver: request is too large")
+	// ErrCorrupt is returned for the requests to the keys while the
+	// CORRUPT alarm is active.
+	ErrCorrupt = errors.New("etcdserver: corrupt cluster")
+	// ErrTooStale is returned for the bounded stale reads when the member
+	// lags too far behind the leader, or there is no leader.
+	ErrTooStale = errors.New("etcdserver: member is too stale to serve the read")
+	// ErrInvalidKVRequest is returned for a v3 KV transaction with a
+	// request union that does not hold exactly one request, or with a
+	// write that overlaps another request of the same branch.
+	ErrInvalidKVRequest = errors.New("etcdserver: invalid KV request")
+	// ErrNotCapable is returned for the requests of a feature that some
+	// members of the cluster do not support yet, until the cluster
+	// version reaches the version of the feature.
+	ErrNotCapable = errors.New("etcdserver: not capable, the cluster version does not support the request yet")
 )
 
 func parseCtxErr(err error) error {
@@ -44,6 +105,18 @@ func parseCtxErr(err error) error {
 	}
 }
 
+// parseProposeErr returns the error of a proposal that raft did not take.
+func parseProposeErr(err error) error {
+	switch err {
+	case raft.ErrProposalDropped:
+		return ErrProposalDropped
+	case raft.ErrStopped:
+		return ErrStopped
+	default:
+		return parseCtxErr(err)
+	}
+}
+
 func isKeyNotFound(err error) bool {
 	e, ok := err.(*etcdErr.Error)
 	return ok && e.ErrorCode == etcdErr.EcodeKeyNotFound
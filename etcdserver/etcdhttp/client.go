@@ -22,6 +22,7 @@ import (
 	"io/ioutil"
 	"log"
 	"net/http"
+	"net/http/pprof"
 	"net/url"
 	"path"
 	"strconv"
@@ -39,6 +40,7 @@ import (
 	"github.com/coreos/etcd/etcdserver/stats"
 	"github.com/coreos/etcd/pkg/types"
 	"github.com/coreos/etcd/raft"
+	"github.com/coreos/etcd/snap"
 	"github.com/coreos/etcd/store"
 	"github.com/coreos/etcd/version"
 )
@@ -51,9 +53,19 @@ const (
 	keysPrefix               = "/v2/keys"
 	deprecatedMachinesPrefix = "/v2/machines"
 	membersPrefix            = "/v2/members"
+	alarmsPrefix             = "/v2/alarms"
+	dirQuotasPrefix          = "/v2/quotas"
+	runtimeConfigsPrefix     = "/v2/config"
+	clusterConfigPath        = runtimeConfigsPrefix + "/cluster"
+	maintenancePrefix        = "/v2/maintenance"
+	exportPrefix             = maintenancePrefix + "/export"
+	importPrefix             = maintenancePrefix + "/import"
+	backupPath               = "/v2/backup"
 	statsPrefix              = "/v2/stats"
 	varsPath                 = "/debug/vars"
 	metricsPath              = "/metrics"
+	pprofPrefix              = "/debug/pprof"
+	watchPath                = "/v2/watch"
 	healthPath               = "/health"
 	versionPath              = "/version"
 )
@@ -64,11 +76,13 @@ func NewClientHandler(server *etcdserver.EtcdServer) http.Handler {
 	sec := security.NewStore(server, defaultServerTimeout)
 
 	kh := &keysHandler{
-		sec:         sec,
-		server:      server,
-		clusterInfo: server.Cluster,
-		timer:       server,
-		timeout:     defaultServerTimeout,
+		sec:                   sec,
+		server:                server,
+		clusterInfo:           server.Cluster,
+		timer:                 server,
+		staleness:             server,
+		timeout:               defaultServerTimeout,
+		clientCertAuthEnabled: server.ClientCertAuthEnabled(),
 	}
 
 	sh := &statsHandler{
@@ -76,29 +90,78 @@ func NewClientHandler(server *etcdserver.EtcdServer) http.Handler {
 	}
 
 	mh := &membersHandler{
-		sec:         sec,
-		server:      server,
-		clusterInfo: server.Cluster,
-		clock:       clockwork.NewRealClock(),
+		sec:                   sec,
+		server:                server,
+		status:                server,
+		clusterInfo:           server.Cluster,
+		clock:                 clockwork.NewRealClock(),
+		clientCertAuthEnabled: server.ClientCertAuthEnabled(),
+	}
+
+	ah := &alarmsHandler{
+		sec:                   sec,
+		alarmer:               server,
+		clusterInfo:           server.Cluster,
+		clientCertAuthEnabled: server.ClientCertAuthEnabled(),
+	}
+
+	qh := &dirQuotasHandler{
+		sec:                   sec,
+		quotaer:               server,
+		clusterInfo:           server.Cluster,
+		clientCertAuthEnabled: server.ClientCertAuthEnabled(),
+	}
+
+	rch := &runtimeConfigsHandler{
+		sec:                   sec,
+		configer:              server,
+		clusterInfo:           server.Cluster,
+		clientCertAuthEnabled: server.ClientCertAuthEnabled(),
+	}
+
+	cch := &clusterConfigHandler{
+		sec:                   sec,
+		configer:              server,
+		clusterInfo:           server.Cluster,
+		clientCertAuthEnabled: server.ClientCertAuthEnabled(),
+	}
+
+	mth := &maintenanceHandler{
+		sec:                   sec,
+		maintainer:            server,
+		clusterInfo:           server.Cluster,
+		clientCertAuthEnabled: server.ClientCertAuthEnabled(),
 	}
 
 	dmh := &deprecatedMachinesHandler{
 		clusterInfo: server.Cluster,
 	}
 
+	wh := &watchHandler{
+		sec:                   sec,
+		server:                server,
+		clusterInfo:           server.Cluster,
+		timer:                 server,
+		clientCertAuthEnabled: server.ClientCertAuthEnabled(),
+	}
+
 	sech := &securityHandler{
-		sec:         sec,
-		clusterInfo: server.Cluster,
+		sec:                   sec,
+		clusterInfo:           server.Cluster,
+		clientCertAuthEnabled: server.ClientCertAuthEnabled(),
 	}
 	// mux处理各种请求
 	mux := http.NewServeMux()
 	mux.HandleFunc("/", http.NotFound)
 	// 处理以"/health"为前缀的请求
 	mux.Handle(healthPath, healthHandler(server))
-	mux.HandleFunc(versionPath, serveVersion)
+	mux.Handle(healthPath+"/live", liveHandler())
+	mux.Handle(healthPath+"/ready", readyHandler(server))
+	mux.HandleFunc(versionPath, versionHandler(server.Cluster))
 	// 处理以"/v2/keys"为前缀的请求
 	mux.Handle(keysPrefix, kh)
 	mux.Handle(keysPrefix+"/", kh)
+	mux.Handle(watchPath, wh)
 	// 处理以"/v2/stats"为前缀的请求
 	mux.HandleFunc(statsPrefix+"/store", sh.serveStore)
 	mux.HandleFunc(statsPrefix+"/self", sh.serveSelf)
@@ -107,17 +170,72 @@ func NewClientHandler(server *etcdserver.EtcdServer) http.Handler {
 	mux.Handle(metricsPath, prometheus.Handler())
 	mux.Handle(membersPrefix, mh)
 	mux.Handle(membersPrefix+"/", mh)
+	mux.Handle(alarmsPrefix, ah)
+	mux.Handle(alarmsPrefix+"/", ah)
+	mux.Handle(dirQuotasPrefix, qh)
+	mux.Handle(dirQuotasPrefix+"/", qh)
+	mux.Handle(runtimeConfigsPrefix, rch)
+	mux.Handle(runtimeConfigsPrefix+"/", rch)
+	mux.Handle(clusterConfigPath, cch)
+	mux.HandleFunc(maintenancePrefix+"/snapshot", mth.serveSnapshot)
+	mux.HandleFunc(maintenancePrefix+"/hash", mth.serveHash)
+	mux.HandleFunc(exportPrefix, mth.serveExport)
+	mux.HandleFunc(exportPrefix+"/", mth.serveExport)
+	mux.HandleFunc(importPrefix+"/", mth.serveImport)
+	mux.HandleFunc(backupPath, mth.serveBackup)
 	mux.Handle(deprecatedMachinesPrefix, dmh)
 	handleSecurity(mux, sech)
+	return raftHeaderHandler(mux, server)
+}
+
+// raftHeaderHandler sets the raft progress of the member on the headers
+// of every response, so that the clients and the tools can tell how far
+// the member has gone. The handlers may override them, e.g. with the
+// index the response is taken at.
+// 在每个response的header中写入raft的applied index、term和commit index
+func raftHeaderHandler(next http.Handler, rt etcdserver.RaftTimer) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Raft-Index", fmt.Sprint(rt.AppliedIndex()))
+		w.Header().Set("X-Raft-Term", fmt.Sprint(rt.Term()))
+		w.Header().Set("X-Raft-Commit-Index", fmt.Sprint(rt.CommittedIndex()))
+		next.ServeHTTP(w, r)
+	})
+}
+
+// NewMetricsHandler returns an http Handler that serves only the metrics
+// and the expvar variables, for a listener dedicated to metrics and
+// debugging.
+func NewMetricsHandler() http.Handler {
+	mux := http.NewServeMux()
+	mux.Handle(metricsPath, prometheus.Handler())
+	mux.HandleFunc(varsPath, serveVars)
+	return mux
+}
+
+// NewPprofHandler returns an http Handler that serves the profiles of the
+// process under /debug/pprof, and the other requests with next. The
+// profiles are served to anyone who can reach the listener.
+// 在client listener上提供pprof的profile
+func NewPprofHandler(next http.Handler) http.Handler {
+	mux := http.NewServeMux()
+	mux.Handle("/", next)
+	// pprof.Index serves the named profiles as well, e.g. heap and
+	// goroutine
+	mux.HandleFunc(pprofPrefix+"/", pprof.Index)
+	mux.HandleFunc(pprofPrefix+"/cmdline", pprof.Cmdline)
+	mux.HandleFunc(pprofPrefix+"/profile", pprof.Profile)
+	mux.HandleFunc(pprofPrefix+"/symbol", pprof.Symbol)
 	return mux
 }
 
 type keysHandler struct {
-	sec         *security.Store
-	server      etcdserver.Server
-	clusterInfo etcdserver.ClusterInfo
-	timer       etcdserver.RaftTimer
-	timeout     time.Duration
+	sec                   *security.Store
+	server                etcdserver.Server
+	clusterInfo           etcdserver.ClusterInfo
+	timer                 etcdserver.RaftTimer
+	staleness             etcdserver.StalenessChecker
+	timeout               time.Duration
+	clientCertAuthEnabled bool
 }
 
 // 处理client和server之间的HTTP K-V request
@@ -128,28 +246,85 @@ func (h *keysHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 
 	w.Header().Set("X-Etcd-Cluster-ID", h.clusterInfo.ID().String())
 
-	ctx, cancel := context.WithTimeout(context.Background(), h.timeout)
-	defer cancel()
-
 	rr, err := parseKeyRequest(r, clockwork.NewRealClock())
 	if err != nil {
 		writeError(w, err)
 		return
 	}
+	timeout, err := parseRequestTimeout(r.Form, h.timeout)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
 	// The path must be valid at this point (we've parsed the request successfully).
-	if !hasKeyPrefixAccess(h.sec, r, r.URL.Path[len(keysPrefix):]) {
+	if !hasKeyPrefixAccess(h.sec, r, r.URL.Path[len(keysPrefix):], h.clientCertAuthEnabled) {
 		writeNoAuth(w)
 		return
 	}
+	// a move writes the new path too
+	if rr.Method == "MOVE" && !hasKeyPrefixAccess(h.sec, r, strings.TrimPrefix(rr.MoveTo, etcdserver.StoreKeysPrefix), h.clientCertAuthEnabled) {
+		writeNoAuth(w)
+		return
+	}
+	for _, p := range rr.Paths {
+		if !hasKeyPrefixAccess(h.sec, r, strings.TrimPrefix(p, etcdserver.StoreKeysPrefix), h.clientCertAuthEnabled) {
+			writeNoAuth(w)
+			return
+		}
+	}
+	// the writes carry their user to the audit logs of the members
+	if rr.Method != "GET" && rr.Method != "HEAD" {
+		rr.User = requestUser(h.sec, r, h.clientCertAuthEnabled)
+	}
+	maxEntries, maxLag, err := parseStalenessBounds(r.Form)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	// a bounded stale read is served from the local store only if it is
+	// fresh enough; the quorum reads and the watches are not bounded.
+	if rr.Method == "GET" && !rr.Quorum && !rr.Wait && (maxEntries > 0 || maxLag > 0) {
+		if err := h.staleness.CheckStaleness(maxEntries, maxLag); err != nil {
+			writeError(w, err)
+			return
+		}
+	}
 	// 真正处理request的函数DO
 	resp, err := h.server.Do(ctx, rr)
 	if err != nil {
+		// the error tells the index the request failed at
+		w.Header().Set("X-Raft-Index", fmt.Sprint(h.timer.AppliedIndex()))
 		err = trimErrorPrefix(err, etcdserver.StoreKeysPrefix)
 		writeError(w, err)
 		return
 	}
+	// a get may list a large directory, so its body is compressed if the
+	// client accepts it; the watches are streamed as they are.
+	if r.Method == "GET" && resp.Watcher == nil && acceptsGzip(r) {
+		gw := newGzipResponseWriter(w)
+		defer func() {
+			if err := gw.Close(); err != nil {
+				log.Printf("error writing compressed response: %v", err)
+			}
+		}()
+		w = gw
+	}
 	switch {
+	case resp.Gets != nil:
+		if err := writeKeyGets(w, resp.Gets, h.timer); err != nil {
+			// Should never be reached
+			log.Printf("error writing gets: %v", err)
+		}
 	case resp.Event != nil:
+		if etag, ok := keyETag(resp.Event); ok {
+			w.Header().Set("ETag", etag)
+			if !noneMatch(r, etag) {
+				writeKeyNotModified(w, resp.Event, h.timer)
+				return
+			}
+		}
 		if err := writeKeyEvent(w, resp.Event, h.timer); err != nil {
 			// Should never be reached
 			log.Printf("error writing event: %v", err)
@@ -178,10 +353,12 @@ func (h *deprecatedMachinesHandler) ServeHTTP(w http.ResponseWriter, r *http.Req
 
 // client-->server 针对member的处理
 type membersHandler struct {
-	sec         *security.Store
-	server      etcdserver.Server
-	clusterInfo etcdserver.ClusterInfo
-	clock       clockwork.Clock
+	sec                   *security.Store
+	server                etcdserver.Server
+	status                memberStatuser
+	clusterInfo           etcdserver.ClusterInfo
+	clock                 clockwork.Clock
+	clientCertAuthEnabled bool
 }
 
 // 处理client-->server的针对member的命令的request
@@ -189,7 +366,7 @@ func (h *membersHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	if !allowMethod(w, r.Method, "GET", "POST", "DELETE", "PUT") {
 		return
 	}
-	if !hasWriteRootAccess(h.sec, r) {
+	if !hasWriteRootAccess(h.sec, r, h.clientCertAuthEnabled) {
 		writeNoAuth(w)
 		return
 	}
@@ -203,7 +380,19 @@ func (h *membersHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		switch trimPrefix(r.URL.Path, membersPrefix) {
 		// 请求所有members的信息
 		case "":
-			mc := newMemberCollection(h.clusterInfo.Members())
+			labels, herr := parseLabelSelector(r)
+			if herr != nil {
+				writeError(w, herr)
+				return
+			}
+			var ms []*etcdserver.Member
+			for _, m := range h.clusterInfo.Members() {
+				if m.HasLabels(labels) {
+					ms = append(ms, m)
+				}
+			}
+			mc := newMemberCollection(ms)
+			setMemberStatus(*mc, h.status)
 			w.Header().Set("Content-Type", "application/json")
 			if err := json.NewEncoder(w).Encode(mc); err != nil {
 				log.Printf("etcdhttp: %v", err)
@@ -226,6 +415,10 @@ func (h *membersHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		}
 	// POST请求产生新的member
 	case "POST":
+		if trimPrefix(r.URL.Path, membersPrefix) == "leader" {
+			h.transferLeadership(ctx, w, r)
+			return
+		}
 		req := httptypes.MemberCreateRequest{}
 		if ok := unmarshalRequest(r, &req, w); !ok {
 			return
@@ -295,6 +488,587 @@ func (h *membersHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+// memberStatuser is the part of the server telling the status of the
+// members in their list.
+type memberStatuser interface {
+	ID() types.ID
+	Leader() types.ID
+	CommittedIndex() uint64
+	AppliedIndex() uint64
+	UnreachablePeers() map[types.ID]time.Time
+	MatchIndexes() map[types.ID]uint64
+}
+
+// setMemberStatus sets the status of every member of mc as seen from the
+// local member, so that the health of the whole cluster can be told from
+// one list.
+// 在member列表中加入各member是否为leader、是否可达以及落后的entry数
+func setMemberStatus(mc httptypes.MemberCollection, s memberStatuser) {
+	lead, committed := s.Leader(), s.CommittedIndex()
+	unreachable := s.UnreachablePeers()
+	matches := s.MatchIndexes()
+	for i := range mc {
+		id, err := types.IDFromString(mc[i].ID)
+		if err != nil {
+			continue
+		}
+		_, ok := unreachable[id]
+		st := &httptypes.MemberStatus{IsLeader: id == lead, Reachable: !ok}
+		switch match, ok := matches[id]; {
+		case id == s.ID():
+			st.Lag = lagBehind(committed, s.AppliedIndex())
+		case ok:
+			st.Lag = lagBehind(committed, match)
+		}
+		mc[i].Status = st
+	}
+}
+
+func lagBehind(committed, index uint64) *uint64 {
+	var lag uint64
+	if index < committed {
+		lag = committed - index
+	}
+	return &lag
+}
+
+// transferLeadership moves the leadership to the member given in the request
+// body, so that operators can take the current leader down for maintenance
+// without waiting for an election.
+func (h *membersHandler) transferLeadership(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+	req := httptypes.LeaderTransferRequest{}
+	if ok := unmarshalRequest(r, &req, w); !ok {
+		return
+	}
+	err := h.server.TransferLeadership(ctx, req.ID)
+	switch {
+	case err == etcdserver.ErrIDNotFound:
+		writeError(w, httptypes.NewHTTPError(http.StatusNotFound, fmt.Sprintf("No such member: %s", req.ID)))
+	case err == etcdserver.ErrNoLeader:
+		writeError(w, httptypes.NewHTTPError(http.StatusServiceUnavailable, "During election"))
+	case err == etcdserver.ErrTimeoutLeaderTransfer:
+		writeError(w, httptypes.NewHTTPError(http.StatusServiceUnavailable, err.Error()))
+	case err != nil:
+		log.Printf("etcdhttp: error transferring leadership to %s: %v", req.ID, err)
+		writeError(w, err)
+	default:
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+type alarmsHandler struct {
+	sec                   *security.Store
+	alarmer               etcdserver.Alarmer
+	clusterInfo           etcdserver.ClusterInfo
+	clientCertAuthEnabled bool
+}
+
+// 查询集群的告警，或者解除某种类型的告警
+func (h *alarmsHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if !allowMethod(w, r.Method, "GET", "DELETE") {
+		return
+	}
+	if !hasWriteRootAccess(h.sec, r, h.clientCertAuthEnabled) {
+		writeNoAuth(w)
+		return
+	}
+	w.Header().Set("X-Etcd-Cluster-ID", h.clusterInfo.ID().String())
+
+	t := trimPrefix(r.URL.Path, alarmsPrefix)
+	switch r.Method {
+	case "GET":
+		if t != "" {
+			writeError(w, httptypes.NewHTTPError(http.StatusNotFound, "Not found"))
+			return
+		}
+		ac := newAlarmCollection(h.alarmer.Alarms())
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(ac); err != nil {
+			log.Printf("etcdhttp: %v", err)
+		}
+	// DELETE 解除某种类型的告警
+	case "DELETE":
+		if t == "" {
+			writeError(w, httptypes.NewHTTPError(http.StatusBadRequest, "No alarm given"))
+			return
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), defaultServerTimeout)
+		defer cancel()
+		err := h.alarmer.DisarmAlarm(ctx, etcdserver.AlarmType(t))
+		switch {
+		case err == etcdserver.ErrUnknownAlarm:
+			writeError(w, httptypes.NewHTTPError(http.StatusNotFound, fmt.Sprintf("No such alarm: %s", t)))
+		case err != nil:
+			log.Printf("etcdhttp: error disarming alarm %s: %v", t, err)
+			writeError(w, err)
+		default:
+			w.WriteHeader(http.StatusNoContent)
+		}
+	}
+}
+
+type dirQuotasHandler struct {
+	sec                   *security.Store
+	quotaer               etcdserver.DirQuotaer
+	clusterInfo           etcdserver.ClusterInfo
+	clientCertAuthEnabled bool
+}
+
+// 查询目录的quota，或者设置、删除某个目录的quota
+func (h *dirQuotasHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if !allowMethod(w, r.Method, "GET", "PUT", "DELETE") {
+		return
+	}
+	if !hasWriteRootAccess(h.sec, r, h.clientCertAuthEnabled) {
+		writeNoAuth(w)
+		return
+	}
+	w.Header().Set("X-Etcd-Cluster-ID", h.clusterInfo.ID().String())
+
+	dir := trimPrefix(r.URL.Path, dirQuotasPrefix)
+	if r.Method == "GET" {
+		if dir != "" {
+			writeError(w, httptypes.NewHTTPError(http.StatusNotFound, "Not found"))
+			return
+		}
+		qc := newDirQuotaCollection(h.quotaer.DirQuotas())
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(qc); err != nil {
+			log.Printf("etcdhttp: %v", err)
+		}
+		return
+	}
+
+	if dir == "" {
+		writeError(w, httptypes.NewHTTPError(http.StatusBadRequest, "No directory given"))
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), defaultServerTimeout)
+	defer cancel()
+	var err error
+	switch r.Method {
+	case "PUT":
+		q, herr := parseDirQuota(r, dir)
+		if herr != nil {
+			writeError(w, herr)
+			return
+		}
+		err = h.quotaer.SetDirQuota(ctx, q)
+	case "DELETE":
+		err = h.quotaer.RemoveDirQuota(ctx, "/"+dir)
+	}
+	switch {
+	case err == etcdserver.ErrInvalidDirQuota:
+		writeError(w, httptypes.NewHTTPError(http.StatusBadRequest, err.Error()))
+	case err != nil:
+		log.Printf("etcdhttp: error changing the quota of /%s: %v", dir, err)
+		writeError(w, err)
+	default:
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+type runtimeConfigsHandler struct {
+	sec                   *security.Store
+	configer              etcdserver.RuntimeConfiger
+	clusterInfo           etcdserver.ClusterInfo
+	clientCertAuthEnabled bool
+}
+
+// 查询各member的运行时配置，或者修改、删除某个member的运行时配置
+func (h *runtimeConfigsHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if !allowMethod(w, r.Method, "GET", "PUT", "DELETE") {
+		return
+	}
+	if !hasWriteRootAccess(h.sec, r, h.clientCertAuthEnabled) {
+		writeNoAuth(w)
+		return
+	}
+	w.Header().Set("X-Etcd-Cluster-ID", h.clusterInfo.ID().String())
+
+	idStr := trimPrefix(r.URL.Path, runtimeConfigsPrefix)
+	if r.Method == "GET" {
+		if idStr != "" {
+			writeError(w, httptypes.NewHTTPError(http.StatusNotFound, "Not found"))
+			return
+		}
+		cc := newRuntimeConfigCollection(h.configer.RuntimeConfigs())
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(cc); err != nil {
+			log.Printf("etcdhttp: %v", err)
+		}
+		return
+	}
+
+	if idStr == "" {
+		writeError(w, httptypes.NewHTTPError(http.StatusBadRequest, "No member given"))
+		return
+	}
+	id, err := types.IDFromString(idStr)
+	if err != nil {
+		writeError(w, httptypes.NewHTTPError(http.StatusNotFound, fmt.Sprintf("No such member: %s", idStr)))
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), defaultServerTimeout)
+	defer cancel()
+	switch r.Method {
+	case "PUT":
+		c, herr := parseRuntimeConfig(r, id)
+		if herr != nil {
+			writeError(w, herr)
+			return
+		}
+		err = h.configer.SetRuntimeConfig(ctx, c)
+	case "DELETE":
+		err = h.configer.RemoveRuntimeConfig(ctx, id)
+	}
+	switch {
+	case err == etcdserver.ErrIDNotFound:
+		writeError(w, httptypes.NewHTTPError(http.StatusNotFound, fmt.Sprintf("No such member: %s", id)))
+	case err == etcdserver.ErrInvalidRuntimeConfig:
+		writeError(w, httptypes.NewHTTPError(http.StatusBadRequest, err.Error()))
+	case err != nil:
+		log.Printf("etcdhttp: error changing the runtime config of member %s: %v", id, err)
+		writeError(w, err)
+	default:
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// parseRuntimeConfig parses the settings of the runtime config of the
+// member from the form of the request. At least one of them must be
+// given.
+func parseRuntimeConfig(r *http.Request, id types.ID) (etcdserver.RuntimeConfig, *httptypes.HTTPError) {
+	c := etcdserver.RuntimeConfig{MemberID: id}
+	if err := r.ParseForm(); err != nil {
+		return c, httptypes.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+	given := false
+	if s := r.FormValue("snapCount"); s != "" {
+		v, err := strconv.ParseUint(s, 10, 64)
+		if err != nil || v == 0 {
+			return c, httptypes.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("invalid snapCount: %q", s))
+		}
+		c.SnapCount, given = &v, true
+	}
+	for _, f := range []struct {
+		name string
+		v    **int64
+	}{
+		{"syncIntervalMs", &c.SyncIntervalMs},
+		{"slowRequestThresholdMs", &c.SlowRequestThresholdMs},
+	} {
+		s := r.FormValue(f.name)
+		if s == "" {
+			continue
+		}
+		v, err := strconv.ParseInt(s, 10, 64)
+		if err != nil || v < 0 {
+			return c, httptypes.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("invalid %s: %q", f.name, s))
+		}
+		*f.v, given = &v, true
+	}
+	switch s := r.FormValue("logLevel"); s {
+	case "":
+	case etcdserver.LogLevelInfo, etcdserver.LogLevelDebug:
+		c.LogLevel, given = s, true
+	default:
+		return c, httptypes.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("invalid logLevel: %q", s))
+	}
+	if !given {
+		return c, httptypes.NewHTTPError(http.StatusBadRequest, "snapCount, syncIntervalMs, slowRequestThresholdMs or logLevel is required")
+	}
+	return c, nil
+}
+
+type clusterConfigHandler struct {
+	sec                   *security.Store
+	configer              etcdserver.ClusterConfiger
+	clusterInfo           etcdserver.ClusterInfo
+	clientCertAuthEnabled bool
+}
+
+// 查询、修改或重置集群配置
+func (h *clusterConfigHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if !allowMethod(w, r.Method, "GET", "PUT", "DELETE") {
+		return
+	}
+	if !hasWriteRootAccess(h.sec, r, h.clientCertAuthEnabled) {
+		writeNoAuth(w)
+		return
+	}
+	w.Header().Set("X-Etcd-Cluster-ID", h.clusterInfo.ID().String())
+
+	if r.Method == "GET" {
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(h.configer.ClusterConfig()); err != nil {
+			log.Printf("etcdhttp: %v", err)
+		}
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), defaultServerTimeout)
+	defer cancel()
+	var err error
+	switch r.Method {
+	case "PUT":
+		c, herr := parseClusterConfig(r)
+		if herr != nil {
+			writeError(w, herr)
+			return
+		}
+		err = h.configer.UpdateClusterConfig(ctx, c)
+	case "DELETE":
+		err = h.configer.ResetClusterConfig(ctx)
+	}
+	switch {
+	case err == etcdserver.ErrInvalidClusterConfig:
+		writeError(w, httptypes.NewHTTPError(http.StatusBadRequest, err.Error()))
+	case err != nil:
+		log.Printf("etcdhttp: error changing the cluster config: %v", err)
+		writeError(w, err)
+	default:
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// parseClusterConfig parses the settings of the cluster config from the
+// form of the request. At least one of them must be given.
+func parseClusterConfig(r *http.Request) (etcdserver.ClusterConfig, *httptypes.HTTPError) {
+	var c etcdserver.ClusterConfig
+	if err := r.ParseForm(); err != nil {
+		return c, httptypes.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+	given := false
+	if s := r.FormValue("activeSize"); s != "" {
+		v, err := strconv.Atoi(s)
+		if err != nil || v < 0 {
+			return c, httptypes.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("invalid activeSize: %q", s))
+		}
+		c.ActiveSize, given = &v, true
+	}
+	for _, f := range []struct {
+		name string
+		v    **int64
+	}{
+		{"quotaBackendBytes", &c.QuotaBackendBytes},
+		{"maxValueBytes", &c.MaxValueBytes},
+		{"maxRequestBytes", &c.MaxRequestBytes},
+	} {
+		s := r.FormValue(f.name)
+		if s == "" {
+			continue
+		}
+		v, err := strconv.ParseInt(s, 10, 64)
+		if err != nil {
+			return c, httptypes.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("invalid %s: %q", f.name, s))
+		}
+		*f.v, given = &v, true
+	}
+	if !given {
+		return c, httptypes.NewHTTPError(http.StatusBadRequest, "activeSize, quotaBackendBytes, maxValueBytes or maxRequestBytes is required")
+	}
+	return c, nil
+}
+
+// parseDirQuota parses the limits of the quota of the directory from the
+// form of the request. At least one of them must be given.
+// parseLabelSelector returns the labels given by the label=key=value
+// query parameters of the request, which the listed members must all have.
+func parseLabelSelector(r *http.Request) (map[string]string, *httptypes.HTTPError) {
+	if err := r.ParseForm(); err != nil {
+		return nil, httptypes.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+	labels := make(map[string]string)
+	for _, s := range r.Form["label"] {
+		kv := strings.SplitN(s, "=", 2)
+		if len(kv) != 2 || kv[0] == "" {
+			return nil, httptypes.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("invalid label: %q", s))
+		}
+		labels[kv[0]] = kv[1]
+	}
+	return labels, nil
+}
+
+func parseDirQuota(r *http.Request, dir string) (etcdserver.DirQuota, *httptypes.HTTPError) {
+	q := etcdserver.DirQuota{Dir: "/" + dir}
+	if err := r.ParseForm(); err != nil {
+		return q, httptypes.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+	if r.FormValue("maxKeys") == "" && r.FormValue("maxBytes") == "" {
+		return q, httptypes.NewHTTPError(http.StatusBadRequest, "maxKeys or maxBytes is required")
+	}
+	for _, f := range []struct {
+		name string
+		v    *int64
+	}{
+		{"maxKeys", &q.MaxKeys},
+		{"maxBytes", &q.MaxBytes},
+	} {
+		s := r.FormValue(f.name)
+		if s == "" {
+			continue
+		}
+		v, err := strconv.ParseInt(s, 10, 64)
+		if err != nil || v < 0 {
+			return q, httptypes.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("invalid %s: %q", f.name, s))
+		}
+		*f.v = v
+	}
+	return q, nil
+}
+
+type maintenanceHandler struct {
+	sec                   *security.Store
+	maintainer            etcdserver.Maintainer
+	clusterInfo           etcdserver.ClusterInfo
+	clientCertAuthEnabled bool
+}
+
+// 立即创建snapshot并压缩raft log
+func (h *maintenanceHandler) serveSnapshot(w http.ResponseWriter, r *http.Request) {
+	if !allowMethod(w, r.Method, "POST") {
+		return
+	}
+	if !hasRootAccess(h.sec, r, h.clientCertAuthEnabled) {
+		writeNoAuth(w)
+		return
+	}
+	w.Header().Set("X-Etcd-Cluster-ID", h.clusterInfo.ID().String())
+
+	ctx, cancel := context.WithTimeout(context.Background(), defaultServerTimeout)
+	defer cancel()
+	index, err := h.maintainer.Snapshot(ctx)
+	if err != nil {
+		log.Printf("etcdhttp: error snapshotting: %v", err)
+		writeError(w, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(httptypes.SnapshotResponse{Index: index}); err != nil {
+		log.Printf("etcdhttp: %v", err)
+	}
+}
+
+// 在线备份，以snapshot文件的格式返回最新的snapshot
+func (h *maintenanceHandler) serveBackup(w http.ResponseWriter, r *http.Request) {
+	if !allowMethod(w, r.Method, "GET") {
+		return
+	}
+	// the backup holds all the data, including the users and roles.
+	if !hasRootAccess(h.sec, r, h.clientCertAuthEnabled) {
+		writeNoAuth(w)
+		return
+	}
+	w.Header().Set("X-Etcd-Cluster-ID", h.clusterInfo.ID().String())
+
+	ctx, cancel := context.WithTimeout(context.Background(), defaultServerTimeout)
+	defer cancel()
+	snapshot, err := h.maintainer.Backup(ctx)
+	if err != nil {
+		log.Printf("etcdhttp: error backing up: %v", err)
+		writeError(w, err)
+		return
+	}
+	fname := fmt.Sprintf("%016x-%016x.snap", snapshot.Metadata.Term, snapshot.Metadata.Index)
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", fname))
+	w.Header().Set("X-Raft-Index", fmt.Sprint(snapshot.Metadata.Index))
+	w.Header().Set("X-Raft-Term", fmt.Sprint(snapshot.Metadata.Term))
+	if err := snap.Write(w, snapshot); err != nil {
+		log.Printf("etcdhttp: error writing backup: %v", err)
+	}
+}
+
+// 返回本member最近计算的store hash
+func (h *maintenanceHandler) serveHash(w http.ResponseWriter, r *http.Request) {
+	if !allowMethod(w, r.Method, "GET") {
+		return
+	}
+	if !hasRootAccess(h.sec, r, h.clientCertAuthEnabled) {
+		writeNoAuth(w)
+		return
+	}
+	w.Header().Set("X-Etcd-Cluster-ID", h.clusterInfo.ID().String())
+	serveStoreHash(w, r, h.maintainer)
+}
+
+// 导出某个key的子树，包括隐藏节点、index和过期时间
+func (h *maintenanceHandler) serveExport(w http.ResponseWriter, r *http.Request) {
+	if !allowMethod(w, r.Method, "GET") {
+		return
+	}
+	// the export holds the hidden nodes as well.
+	if !hasRootAccess(h.sec, r, h.clientCertAuthEnabled) {
+		writeNoAuth(w)
+		return
+	}
+	w.Header().Set("X-Etcd-Cluster-ID", h.clusterInfo.ID().String())
+
+	key := "/" + trimPrefix(r.URL.Path, exportPrefix)
+	ctx, cancel := context.WithTimeout(context.Background(), defaultServerTimeout)
+	defer cancel()
+	ex, err := h.maintainer.Export(ctx, path.Join(etcdserver.StoreKeysPrefix, key))
+	if err != nil {
+		writeError(w, trimErrorPrefix(err, etcdserver.StoreKeysPrefix))
+		return
+	}
+	ex.Node = trimNodeExternPrefix(ex.Node, etcdserver.StoreKeysPrefix)
+	// the root is trimmed to an empty key
+	ex.Node.Key = path.Clean(key)
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("X-Etcd-Index", fmt.Sprint(ex.Index))
+	if err := json.NewEncoder(w).Encode(ex); err != nil {
+		log.Printf("etcdhttp: %v", err)
+	}
+}
+
+// 将导出的子树导入到某个key下，该key必须不存在
+func (h *maintenanceHandler) serveImport(w http.ResponseWriter, r *http.Request) {
+	if !allowMethod(w, r.Method, "PUT") {
+		return
+	}
+	if !hasRootAccess(h.sec, r, h.clientCertAuthEnabled) {
+		writeNoAuth(w)
+		return
+	}
+	w.Header().Set("X-Etcd-Cluster-ID", h.clusterInfo.ID().String())
+
+	key := trimPrefix(r.URL.Path, importPrefix)
+	if key == "" {
+		writeError(w, httptypes.NewHTTPError(http.StatusBadRequest, "No key given"))
+		return
+	}
+	var ex store.Export
+	if err := json.NewDecoder(r.Body).Decode(&ex); err != nil {
+		writeError(w, httptypes.NewHTTPError(http.StatusBadRequest, "Invalid export: "+err.Error()))
+		return
+	}
+	if err := ex.Validate(); err != nil {
+		writeError(w, httptypes.NewHTTPError(http.StatusBadRequest, "Invalid export: "+err.Error()))
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), defaultServerTimeout)
+	defer cancel()
+	ev, err := h.maintainer.Import(ctx, path.Join(etcdserver.StoreKeysPrefix, key), &ex)
+	switch {
+	case err == etcdserver.ErrInvalidExport:
+		writeError(w, httptypes.NewHTTPError(http.StatusBadRequest, err.Error()))
+		return
+	case err != nil:
+		writeError(w, trimErrorPrefix(err, etcdserver.StoreKeysPrefix))
+		return
+	}
+	ev = trimEventPrefix(ev, etcdserver.StoreKeysPrefix)
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("X-Etcd-Index", fmt.Sprint(ev.EtcdIndex))
+	w.WriteHeader(http.StatusCreated)
+	if err := json.NewEncoder(w).Encode(ev); err != nil {
+		log.Printf("etcdhttp: %v", err)
+	}
+}
+
 type statsHandler struct {
 	stats stats.Stats
 }
@@ -342,43 +1116,144 @@ func serveVars(w http.ResponseWriter, r *http.Request) {
 	fmt.Fprintf(w, "\n}\n")
 }
 
-// TODO: change etcdserver to raft interface when we have it.
-//       add test for healthHeadler when we have the interface ready.
-// 检测etcdServer的健康状况,
-func healthHandler(server *etcdserver.EtcdServer) http.HandlerFunc {
+// healthServer is the part of the server checked by the health
+// endpoint.
+type healthServer interface {
+	Leader() types.ID
+	Do(ctx context.Context, r etcdserverpb.Request) (etcdserver.Response, error)
+	CommittedIndex() uint64
+	AppliedIndex() uint64
+	UnreachablePeers() map[types.ID]time.Time
+	CheckDiskWriteable() error
+	CheckPurge() error
+}
+
+// healthKey is read with a quorum read to check that the member can
+// commit. It is in the admin dir, so the check is never rejected for too
+// many pending proposals, and reads no user key.
+var healthKey = path.Join(etcdserver.StoreAdminPrefix, "health")
+
+// healthTimeout is the time a health check waits for its quorum read.
+const healthTimeout = time.Second
+
+// 检测etcdServer的健康状况: 是否有leader、能否提交、磁盘是否可写
+func healthHandler(server healthServer) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		if !allowMethod(w, r.Method, "GET") {
 			return
 		}
 
-		if uint64(server.Leader()) == raft.None {
-			http.Error(w, `{"health": "false"}`, http.StatusServiceUnavailable)
-			return
+		h := health{Health: "false"}
+		if peers := server.UnreachablePeers(); len(peers) > 0 {
+			h.UnreachablePeers = make(map[string]time.Time, len(peers))
+			for id, since := range peers {
+				h.UnreachablePeers[id.String()] = since
+			}
+		}
+		if err := server.CheckDiskWriteable(); err != nil {
+			h.Errors = append(h.Errors, fmt.Sprintf("disk is not writeable: %v", err))
+		}
+		if err := server.CheckPurge(); err != nil {
+			h.Errors = append(h.Errors, fmt.Sprintf("cannot purge the old files: %v", err))
 		}
 
-		// wait for raft's progress
-		// 通过3次判断raft的index的值的变化来确定server的健康状况
-		// 也就是说正常情况下，raft的index在750ms之内是会变化的，这个index指的什么？
-		index := server.Index()
-		for i := 0; i < 3; i++ {
-			time.Sleep(250 * time.Millisecond)
-			if server.Index() > index {
-				w.WriteHeader(http.StatusOK)
-				w.Write([]byte(`{"health": "true"}`))
-				return
+		if lead := server.Leader(); uint64(lead) != raft.None {
+			h.Leader = lead.String()
+			// a quorum read commits an entry through the leader, so it
+			// returns once the member can make progress; the key is not
+			// found, which is fine.
+			ctx, cancel := context.WithTimeout(context.Background(), healthTimeout)
+			_, err := server.Do(ctx, etcdserverpb.Request{Method: "GET", Path: healthKey, Quorum: true})
+			cancel()
+			if _, ok := err.(*etcdErr.Error); err != nil && !ok {
+				h.Errors = append(h.Errors, fmt.Sprintf("cannot commit: %v", err))
 			}
+		} else {
+			h.Errors = append(h.Errors, "no leader")
+		}
+		if c, a := server.CommittedIndex(), server.AppliedIndex(); c > a {
+			h.AppliedLag = c - a
 		}
 
-		http.Error(w, `{"health": "false"}`, http.StatusServiceUnavailable)
-		return
+		if len(h.Errors) > 0 {
+			writeHealth(w, h, http.StatusServiceUnavailable)
+			return
+		}
+		h.Health = "true"
+		writeHealth(w, h, http.StatusOK)
 	}
 }
 
-func serveVersion(w http.ResponseWriter, r *http.Request) {
-	if !allowMethod(w, r.Method, "GET") {
-		return
+// health is the response of the health endpoint.
+type health struct {
+	Health string `json:"health"`
+	// Leader is the ID of the leader known by this member, empty if
+	// there is none.
+	Leader string `json:"leader,omitempty"`
+	// AppliedLag is the number of the committed entries which this member
+	// has not applied yet.
+	AppliedLag uint64 `json:"appliedLag"`
+	// Errors tell why the member is not healthy.
+	Errors []string `json:"errors,omitempty"`
+	// UnreachablePeers maps the IDs of the peers which this member cannot
+	// reach to since when, which helps to find a broken peer link.
+	UnreachablePeers map[string]time.Time `json:"unreachablePeers,omitempty"`
+}
+
+// liveHandler answers as long as the process serves the requests, e.g.
+// for an init system to restart a hung member.
+// 进程存活即返回200
+func liveHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !allowMethod(w, r.Method, "GET") {
+			return
+		}
+		writeHealth(w, probe{Health: "true"}, http.StatusOK)
+	}
+}
+
+// readyHandler answers with 200 once the member is ready to serve the
+// clients, e.g. for a load balancer to route the requests to it, and with
+// 503 and the reason until then.
+// member追上leader之后才返回200
+func readyHandler(rc etcdserver.ReadinessChecker) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !allowMethod(w, r.Method, "GET") {
+			return
+		}
+		if err := rc.CheckReady(); err != nil {
+			writeHealth(w, probe{Health: "false", Reason: err.Error()}, http.StatusServiceUnavailable)
+			return
+		}
+		writeHealth(w, probe{Health: "true"}, http.StatusOK)
+	}
+}
+
+// probe is the response of the liveness and readiness endpoints.
+type probe struct {
+	Health string `json:"health"`
+	Reason string `json:"reason,omitempty"`
+}
+
+func writeHealth(w http.ResponseWriter, h interface{}, code int) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	if err := json.NewEncoder(w).Encode(h); err != nil {
+		log.Printf("etcdhttp: error encoding health response: %v", err)
+	}
+}
+
+func versionHandler(c etcdserver.ClusterInfo) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !allowMethod(w, r.Method, "GET") {
+			return
+		}
+		b, err := json.Marshal(version.Versions{Server: version.Version, Cluster: c.Version()})
+		if err != nil {
+			log.Panicf("etcdhttp: cannot marshal versions to json (%v)", err)
+		}
+		w.Write(b)
 	}
-	w.Write(version.MarshalJSON())
 }
 
 // parseKeyRequest converts a received http.Request on keysPrefix to
@@ -418,7 +1293,7 @@ func parseKeyRequest(r *http.Request, clock clockwork.Clock) (etcdserverpb.Reque
 		)
 	}
 
-	var rec, sort, wait, dir, quorum, stream bool
+	var rec, sort, wait, dir, quorum, stream, refresh bool
 	if rec, err = getBool(r.Form, "recursive"); err != nil {
 		return emptyReq, etcdErr.NewRequestError(
 			etcdErr.EcodeInvalidField,
@@ -457,6 +1332,13 @@ func parseKeyRequest(r *http.Request, clock clockwork.Clock) (etcdserverpb.Reque
 		)
 	}
 
+	if refresh, err = getBool(r.Form, "refresh"); err != nil {
+		return emptyReq, etcdErr.NewRequestError(
+			etcdErr.EcodeInvalidField,
+			`invalid value for "refresh"`,
+		)
+	}
+
 	if wait && r.Method != "GET" {
 		return emptyReq, etcdErr.NewRequestError(
 			etcdErr.EcodeInvalidField,
@@ -464,6 +1346,68 @@ func parseKeyRequest(r *http.Request, clock clockwork.Clock) (etcdserverpb.Reque
 		)
 	}
 
+	// waitActions filters the events of a watch by action
+	var actions []string
+	if wa := r.FormValue("waitActions"); wa != "" {
+		if !wait {
+			return emptyReq, etcdErr.NewRequestError(
+				etcdErr.EcodeInvalidField,
+				`"waitActions" can only be used with "wait"`,
+			)
+		}
+		for _, a := range strings.Split(wa, ",") {
+			if !watchActions[a] {
+				return emptyReq, etcdErr.NewRequestError(
+					etcdErr.EcodeInvalidField,
+					fmt.Sprintf(`invalid action %q in "waitActions"`, a),
+				)
+			}
+			actions = append(actions, a)
+		}
+	}
+
+	// limit and continue page through the nodes of a get
+	var limit uint64
+	if limit, err = getUint64(r.Form, "limit"); err != nil {
+		return emptyReq, etcdErr.NewRequestError(
+			etcdErr.EcodeInvalidField,
+			`invalid value for "limit"`,
+		)
+	}
+	var cont string
+	if c := r.FormValue("continue"); c != "" {
+		cont = path.Join(etcdserver.StoreKeysPrefix, c)
+	}
+	if (limit > 0 || cont != "") && (wait || r.Method != "GET") {
+		return emptyReq, etcdErr.NewRequestError(
+			etcdErr.EcodeInvalidField,
+			`"limit" and "continue" can only be used with GET requests without "wait"`,
+		)
+	}
+
+	// path lists the keys of a multi-key get, relative to the key of
+	// the request
+	var paths []string
+	if ps, ok := r.Form["path"]; ok {
+		if r.Method != "GET" || wait || limit > 0 || cont != "" {
+			return emptyReq, etcdErr.NewRequestError(
+				etcdErr.EcodeInvalidField,
+				`"path" can only be used with GET requests without "wait", "limit" or "continue"`,
+			)
+		}
+		for _, mp := range ps {
+			if mp == "" {
+				return emptyReq, etcdErr.NewRequestError(
+					etcdErr.EcodeInvalidField,
+					`"path" cannot be empty`,
+				)
+			}
+			paths = append(paths, path.Join(p, mp))
+		}
+		// a multi-key get is always a quorum read
+		quorum = true
+	}
+
 	pV := r.FormValue("prevValue")
 	if _, ok := r.Form["prevValue"]; ok && pV == "" {
 		return emptyReq, etcdErr.NewRequestError(
@@ -472,6 +1416,30 @@ func parseKeyRequest(r *http.Request, clock clockwork.Clock) (etcdserverpb.Reque
 		)
 	}
 
+	// prevMarker names a key under the directory of a delete, whose value
+	// is compared with prevValue
+	pM := r.FormValue("prevMarker")
+	if _, ok := r.Form["prevMarker"]; ok {
+		if pM == "" {
+			return emptyReq, etcdErr.NewRequestError(
+				etcdErr.EcodeInvalidField,
+				`"prevMarker" cannot be empty`,
+			)
+		}
+		if r.Method != "DELETE" || !(dir || rec) {
+			return emptyReq, etcdErr.NewRequestError(
+				etcdErr.EcodeInvalidField,
+				`"prevMarker" can only be used with DELETE requests with "dir" or "recursive"`,
+			)
+		}
+		if pV == "" {
+			return emptyReq, etcdErr.NewRequestError(
+				etcdErr.EcodePrevValueRequired,
+				`"prevValue" is required with "prevMarker"`,
+			)
+		}
+	}
+
 	// TTL is nullable, so leave it null if not specified
 	// or an empty string
 	var ttl *uint64
@@ -499,26 +1467,133 @@ func parseKeyRequest(r *http.Request, clock clockwork.Clock) (etcdserverpb.Reque
 		pe = &bv
 	}
 
+	// refresh only resets the ttl of an existing key
+	if refresh {
+		if r.Method != "PUT" {
+			return emptyReq, etcdErr.NewRequestError(
+				etcdErr.EcodeInvalidField,
+				`"refresh" can only be used with PUT requests`,
+			)
+		}
+		if ttl == nil {
+			return emptyReq, etcdErr.NewRequestError(
+				etcdErr.EcodeTTLNaN,
+				`"ttl" is required with "refresh"`,
+			)
+		}
+		_, hasValue := r.Form["value"]
+		if hasValue || pV != "" || pIdx != 0 || (pe != nil && !*pe) {
+			return emptyReq, etcdErr.NewRequestError(
+				etcdErr.EcodeInvalidField,
+				`"refresh" cannot be used with "value", "prevValue", "prevIndex" or "prevExist=false"`,
+			)
+		}
+	}
+
+	// incr adds a delta to the integer value of the key
+	var delta int64
+	_, incr := r.Form["incr"]
+	if incr {
+		if delta, err = strconv.ParseInt(r.FormValue("incr"), 10, 64); err != nil {
+			return emptyReq, etcdErr.NewRequestError(
+				etcdErr.EcodeInvalidField,
+				`invalid value for "incr"`,
+			)
+		}
+		if r.Method != "PUT" {
+			return emptyReq, etcdErr.NewRequestError(
+				etcdErr.EcodeInvalidField,
+				`"incr" can only be used with PUT requests`,
+			)
+		}
+		_, hasValue := r.Form["value"]
+		if hasValue || pV != "" || pIdx != 0 || pe != nil || ttl != nil || dir || refresh {
+			return emptyReq, etcdErr.NewRequestError(
+				etcdErr.EcodeInvalidField,
+				`"incr" cannot be used with "value", "prevValue", "prevIndex", "prevExist", "ttl", "dir" or "refresh"`,
+			)
+		}
+	}
+
+	// moveTo moves the key to another path
+	var moveTo string
+	if mv, ok := r.Form["moveTo"]; ok {
+		if len(mv) == 0 || mv[0] == "" {
+			return emptyReq, etcdErr.NewRequestError(
+				etcdErr.EcodeInvalidField,
+				`"moveTo" cannot be empty`,
+			)
+		}
+		if r.Method != "PUT" {
+			return emptyReq, etcdErr.NewRequestError(
+				etcdErr.EcodeInvalidField,
+				`"moveTo" can only be used with PUT requests`,
+			)
+		}
+		_, hasValue := r.Form["value"]
+		if hasValue || pV != "" || pIdx != 0 || pe != nil || ttl != nil || dir || refresh || incr {
+			return emptyReq, etcdErr.NewRequestError(
+				etcdErr.EcodeInvalidField,
+				`"moveTo" cannot be used with "value", "prevValue", "prevIndex", "prevExist", "ttl", "dir", "refresh" or "incr"`,
+			)
+		}
+		moveTo = path.Join(etcdserver.StoreKeysPrefix, mv[0])
+	}
+
+	// token identifies a write, so that a retry of it is not applied
+	// twice
+	token := r.FormValue("token")
+	if _, ok := r.Form["token"]; ok {
+		if token == "" {
+			return emptyReq, etcdErr.NewRequestError(
+				etcdErr.EcodeInvalidField,
+				`"token" cannot be empty`,
+			)
+		}
+		if r.Method != "PUT" && r.Method != "POST" && r.Method != "DELETE" {
+			return emptyReq, etcdErr.NewRequestError(
+				etcdErr.EcodeInvalidField,
+				`"token" can only be used with PUT, POST and DELETE requests`,
+			)
+		}
+	}
+
 	rr := etcdserverpb.Request{
-		Method:    r.Method,
-		Path:      p,
-		Val:       r.FormValue("value"),
-		Dir:       dir,
-		PrevValue: pV,
-		PrevIndex: pIdx,
-		PrevExist: pe,
-		Wait:      wait,
-		Since:     wIdx,
-		Recursive: rec,
-		Sorted:    sort,
-		Quorum:    quorum,
-		Stream:    stream,
+		Method:     r.Method,
+		Path:       p,
+		Val:        r.FormValue("value"),
+		Dir:        dir,
+		PrevValue:  pV,
+		PrevIndex:  pIdx,
+		PrevExist:  pe,
+		Wait:       wait,
+		Since:      wIdx,
+		Recursive:  rec,
+		Sorted:     sort,
+		Quorum:     quorum,
+		Stream:     stream,
+		Limit:      limit,
+		Continue:   cont,
+		Actions:    actions,
+		Refresh:    refresh,
+		Paths:      paths,
+		PrevMarker: pM,
+		Token:      token,
 	}
 
 	if pe != nil {
 		rr.PrevExist = pe
 	}
 
+	if incr {
+		rr.Method = "INCR"
+		rr.Delta = delta
+	}
+	if moveTo != "" {
+		rr.Method = "MOVE"
+		rr.MoveTo = moveTo
+	}
+
 	// Null TTL is equivalent to unset Expiration
 	if ttl != nil {
 		expr := time.Duration(*ttl) * time.Second
@@ -528,6 +1603,57 @@ func parseKeyRequest(r *http.Request, clock clockwork.Clock) (etcdserverpb.Reque
 	return rr, nil
 }
 
+// watchActions are the actions a watch can be filtered on.
+var watchActions = map[string]bool{
+	store.Create:           true,
+	store.Set:              true,
+	store.Update:           true,
+	store.Delete:           true,
+	store.CompareAndSwap:   true,
+	store.CompareAndDelete: true,
+	store.Expire:           true,
+	store.Incr:             true,
+	store.Move:             true,
+}
+
+// parseStalenessBounds parses the bounds of a stale read: the max number
+// of the entries, and the max milliseconds, the member may lag behind the
+// leader. A missing bound is zero.
+func parseStalenessBounds(form url.Values) (uint64, time.Duration, error) {
+	maxEntries, err := getUint64(form, "maxStaleEntries")
+	if err != nil {
+		return 0, 0, etcdErr.NewRequestError(
+			etcdErr.EcodeInvalidField,
+			`invalid value for "maxStaleEntries"`,
+		)
+	}
+	maxMs, err := getUint64(form, "maxStaleMs")
+	if err != nil {
+		return 0, 0, etcdErr.NewRequestError(
+			etcdErr.EcodeInvalidField,
+			`invalid value for "maxStaleMs"`,
+		)
+	}
+	return maxEntries, time.Duration(maxMs) * time.Millisecond, nil
+}
+
+// parseRequestTimeout returns the timeout given by the client in
+// timeoutMs, which may not exceed maxRequestTimeout, or def if there is
+// none.
+func parseRequestTimeout(form url.Values, def time.Duration) (time.Duration, error) {
+	ms, err := getUint64(form, "timeoutMs")
+	if err != nil || ms > uint64(maxRequestTimeout/time.Millisecond) {
+		return 0, etcdErr.NewRequestError(
+			etcdErr.EcodeInvalidField,
+			`invalid value for "timeoutMs"`,
+		)
+	}
+	if ms == 0 {
+		return def, nil
+	}
+	return time.Duration(ms) * time.Millisecond, nil
+}
+
 // writeKeyEvent trims the prefix of key path in a single Event under
 // StoreKeysPrefix, serializes it and writes the resulting JSON to the given
 // ResponseWriter, along with the appropriate headers.
@@ -548,6 +1674,39 @@ func writeKeyEvent(w http.ResponseWriter, ev *store.Event, rt etcdserver.RaftTim
 	return json.NewEncoder(w).Encode(ev)
 }
 
+// keyGetResult is the result of the get of one path of a multi-key get,
+// written as the event of the get or the error.
+type keyGetResult struct {
+	*store.Event
+	Error error `json:"error,omitempty"`
+}
+
+// writeKeyGets writes the results of a multi-key get, one per path in
+// the order of the request, with the prefix of the key paths trimmed.
+func writeKeyGets(w http.ResponseWriter, gets []etcdserver.GetResult, rt etcdserver.RaftTimer) error {
+	var index uint64
+	results := make([]keyGetResult, len(gets))
+	for i, g := range gets {
+		if g.Err != nil {
+			err := trimErrorPrefix(g.Err, etcdserver.StoreKeysPrefix)
+			if e, ok := err.(*etcdErr.Error); ok {
+				index = e.Index
+			}
+			results[i].Error = err
+			continue
+		}
+		index = g.Event.EtcdIndex
+		results[i].Event = trimEventPrefix(g.Event, etcdserver.StoreKeysPrefix)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("X-Etcd-Index", fmt.Sprint(index))
+	w.Header().Set("X-Raft-Index", fmt.Sprint(rt.Index()))
+	w.Header().Set("X-Raft-Term", fmt.Sprint(rt.Term()))
+	return json.NewEncoder(w).Encode(struct {
+		Results []keyGetResult `json:"results"`
+	}{results})
+}
+
 // 处理key watch event,循环检测当watcher的event channel中有event消息时，将该消息写回需要监听该key的client
 func handleKeyWatch(ctx context.Context, w http.ResponseWriter, wa store.Watcher, stream bool, rt etcdserver.RaftTimer) {
 	defer wa.Remove()
@@ -588,7 +1747,9 @@ func handleKeyWatch(ctx context.Context, w http.ResponseWriter, wa store.Watcher
 				log.Printf("error writing event: %v\n", err)
 				return
 			}
-			if !stream {
+			// the watcher is removed after an overflow event, and the
+			// client re-syncs with a new watch
+			if !stream || ev.Action == store.Overflow {
 				return
 			}
 			w.(http.Flusher).Flush()
@@ -605,6 +1766,7 @@ func trimEventPrefix(ev *store.Event, prefix string) *store.Event {
 	e := ev.Clone()
 	e.Node = trimNodeExternPrefix(e.Node, prefix)
 	e.PrevNode = trimNodeExternPrefix(e.PrevNode, prefix)
+	e.Continue = strings.TrimPrefix(e.Continue, prefix)
 	return e
 }
 
@@ -688,6 +1850,36 @@ func trimPrefix(p, prefix string) (s string) {
 	return
 }
 
+func newAlarmCollection(as []etcdserver.Alarm) *httptypes.AlarmCollection {
+	c := httptypes.AlarmCollection(make([]httptypes.Alarm, len(as)))
+	for i, a := range as {
+		c[i] = httptypes.Alarm{MemberID: a.MemberID.String(), Alarm: string(a.Type)}
+	}
+	return &c
+}
+
+func newDirQuotaCollection(qs []etcdserver.DirQuota) *httptypes.DirQuotaCollection {
+	c := httptypes.DirQuotaCollection(make([]httptypes.DirQuota, len(qs)))
+	for i, q := range qs {
+		c[i] = httptypes.DirQuota{Dir: q.Dir, MaxKeys: q.MaxKeys, MaxBytes: q.MaxBytes}
+	}
+	return &c
+}
+
+func newRuntimeConfigCollection(cs []etcdserver.RuntimeConfig) *httptypes.RuntimeConfigCollection {
+	c := httptypes.RuntimeConfigCollection(make([]httptypes.RuntimeConfig, len(cs)))
+	for i, rc := range cs {
+		c[i] = httptypes.RuntimeConfig{
+			MemberID:               rc.MemberID.String(),
+			SnapCount:              rc.SnapCount,
+			SyncIntervalMs:         rc.SyncIntervalMs,
+			SlowRequestThresholdMs: rc.SlowRequestThresholdMs,
+			LogLevel:               rc.LogLevel,
+		}
+	}
+	return &c
+}
+
 func newMemberCollection(ms []*etcdserver.Member) *httptypes.MemberCollection {
 	c := httptypes.MemberCollection(make([]httptypes.Member, len(ms)))
 
@@ -704,6 +1896,8 @@ func newMember(m *etcdserver.Member) httptypes.Member {
 		Name:       m.Name,
 		PeerURLs:   make([]string, len(m.PeerURLs)),
 		ClientURLs: make([]string, len(m.ClientURLs)),
+		Version:    m.Version,
+		Labels:     m.Labels,
 	}
 
 	copy(tm.PeerURLs, m.PeerURLs)
@@ -28,18 +28,86 @@ import (
 )
 
 type securityHandler struct {
-	sec         *security.Store
-	clusterInfo etcdserver.ClusterInfo
+	sec                   *security.Store
+	clusterInfo           etcdserver.ClusterInfo
+	clientCertAuthEnabled bool
 }
 
-func hasWriteRootAccess(sec *security.Store, r *http.Request) bool {
+func hasWriteRootAccess(sec *security.Store, r *http.Request, clientCertAuthEnabled bool) bool {
 	if r.Method == "GET" || r.Method == "HEAD" {
 		return true
 	}
-	return hasRootAccess(sec, r)
+	return hasRootAccess(sec, r, clientCertAuthEnabled)
 }
 
-func hasRootAccess(sec *security.Store, r *http.Request) bool {
+// authenticate returns the user the request is authenticated as. The
+// basic auth credentials are checked first. If the request has none and
+// client cert authentication is enabled, the user is the one named by
+// the CommonName of the verified client certificate.
+// 优先使用basic auth，否则使用客户端证书的CommonName作为用户名
+func authenticate(sec *security.Store, r *http.Request, clientCertAuthEnabled bool) (security.User, bool) {
+	username, password, ok := netutil.BasicAuth(r)
+	if !ok {
+		if clientCertAuthEnabled {
+			return userFromClientCertificate(sec, r)
+		}
+		return security.User{}, false
+	}
+	user, err := sec.GetUser(username)
+	if err != nil {
+		log.Printf("security: No such user: %s.", username)
+		return security.User{}, false
+	}
+	if !user.CheckPassword(password) {
+		log.Printf("security: Incorrect password for user: %s.", username)
+		return security.User{}, false
+	}
+	return user, true
+}
+
+// requestUser returns the name of the user the request is made by, or
+// empty if security is disabled. It does not check the password, so it
+// must only be called once the request has been granted access.
+func requestUser(sec *security.Store, r *http.Request, clientCertAuthEnabled bool) string {
+	if sec == nil || !sec.SecurityEnabled() {
+		return ""
+	}
+	if username, _, ok := netutil.BasicAuth(r); ok {
+		return username
+	}
+	if clientCertAuthEnabled {
+		if user, ok := userFromClientCertificate(sec, r); ok {
+			return user.User
+		}
+	}
+	return ""
+}
+
+// userFromClientCertificate returns the user named by the CommonName of
+// the first verified client certificate that names an existing user.
+func userFromClientCertificate(sec *security.Store, r *http.Request) (security.User, bool) {
+	if r.TLS == nil {
+		return security.User{}, false
+	}
+	for _, chain := range r.TLS.VerifiedChains {
+		if len(chain) == 0 {
+			continue
+		}
+		cn := chain[0].Subject.CommonName
+		if cn == "" {
+			continue
+		}
+		user, err := sec.GetUser(cn)
+		if err != nil {
+			log.Printf("security: No such user for client certificate CommonName: %s.", cn)
+			continue
+		}
+		return user, true
+	}
+	return security.User{}, false
+}
+
+func hasRootAccess(sec *security.Store, r *http.Request, clientCertAuthEnabled bool) bool {
 	if sec == nil {
 		// No store means no security avaliable, eg, tests.
 		return true
@@ -47,26 +115,18 @@ func hasRootAccess(sec *security.Store, r *http.Request) bool {
 	if !sec.SecurityEnabled() {
 		return true
 	}
-	username, password, ok := netutil.BasicAuth(r)
+	user, ok := authenticate(sec, r, clientCertAuthEnabled)
 	if !ok {
 		return false
 	}
-	if username != "root" {
-		log.Printf("security: Attempting to use user %s for resource that requires root.", username)
+	if user.User != "root" {
+		log.Printf("security: Attempting to use user %s for resource that requires root.", user.User)
 		return false
 	}
-	root, err := sec.GetUser("root")
-	if err != nil {
-		return false
-	}
-	ok = root.CheckPassword(password)
-	if !ok {
-		log.Printf("security: Wrong password for user %s", username)
-	}
-	return ok
+	return true
 }
 
-func hasKeyPrefixAccess(sec *security.Store, r *http.Request, key string) bool {
+func hasKeyPrefixAccess(sec *security.Store, r *http.Request, key string, clientCertAuthEnabled bool) bool {
 	if sec == nil {
 		// No store means no security avaliable, eg, tests.
 		return true
@@ -74,20 +134,10 @@ func hasKeyPrefixAccess(sec *security.Store, r *http.Request, key string) bool {
 	if !sec.SecurityEnabled() {
 		return true
 	}
-	username, password, ok := netutil.BasicAuth(r)
+	user, ok := authenticate(sec, r, clientCertAuthEnabled)
 	if !ok {
 		return false
 	}
-	user, err := sec.GetUser(username)
-	if err != nil {
-		log.Printf("security: No such user: %s.", username)
-		return false
-	}
-	authAsUser := user.CheckPassword(password)
-	if !authAsUser {
-		log.Printf("security: Incorrect password for user: %s.", username)
-		return false
-	}
 	if user.User == "root" {
 		return true
 	}
@@ -101,7 +151,7 @@ func hasKeyPrefixAccess(sec *security.Store, r *http.Request, key string) bool {
 			return true
 		}
 	}
-	log.Printf("security: Invalid access for user %s on key %s.", username, key)
+	log.Printf("security: Invalid access for user %s on key %s.", user.User, key)
 	return false
 }
 
@@ -122,7 +172,7 @@ func (sh *securityHandler) baseRoles(w http.ResponseWriter, r *http.Request) {
 	if !allowMethod(w, r.Method, "GET") {
 		return
 	}
-	if !hasRootAccess(sh.sec, r) {
+	if !hasRootAccess(sh.sec, r, sh.clientCertAuthEnabled) {
 		writeNoAuth(w)
 		return
 	}
@@ -150,6 +200,8 @@ func (sh *securityHandler) handleRoles(w http.ResponseWriter, r *http.Request) {
 	// First item is an empty string, second is "roles"
 	pieces := strings.Split(subpath, "/")
 	if len(pieces) != 3 {
+		writeError(w, httptypes.NewHTTPError(http.StatusBadRequest, "Invalid path"))
+		return
 	}
 	sh.forRole(w, r, pieces[2])
 }
@@ -158,7 +210,7 @@ func (sh *securityHandler) forRole(w http.ResponseWriter, r *http.Request, role
 	if !allowMethod(w, r.Method, "GET", "PUT", "DELETE") {
 		return
 	}
-	if !hasRootAccess(sh.sec, r) {
+	if !hasRootAccess(sh.sec, r, sh.clientCertAuthEnabled) {
 		writeNoAuth(w)
 		return
 	}
@@ -214,7 +266,7 @@ func (sh *securityHandler) baseUsers(w http.ResponseWriter, r *http.Request) {
 	if !allowMethod(w, r.Method, "GET") {
 		return
 	}
-	if !hasRootAccess(sh.sec, r) {
+	if !hasRootAccess(sh.sec, r, sh.clientCertAuthEnabled) {
 		writeNoAuth(w)
 		return
 	}
@@ -252,7 +304,7 @@ func (sh *securityHandler) forUser(w http.ResponseWriter, r *http.Request, user
 	if !allowMethod(w, r.Method, "GET", "PUT", "DELETE") {
 		return
 	}
-	if !hasRootAccess(sh.sec, r) {
+	if !hasRootAccess(sh.sec, r, sh.clientCertAuthEnabled) {
 		writeNoAuth(w)
 		return
 	}
@@ -316,7 +368,7 @@ func (sh *securityHandler) enableDisable(w http.ResponseWriter, r *http.Request)
 	if !allowMethod(w, r.Method, "GET", "PUT", "DELETE") {
 		return
 	}
-	if !hasWriteRootAccess(sh.sec, r) {
+	if !hasWriteRootAccess(sh.sec, r, sh.clientCertAuthEnabled) {
 		writeNoAuth(w)
 		return
 	}
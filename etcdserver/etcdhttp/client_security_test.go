@@ -0,0 +1,116 @@
+// Copyright 2015 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package etcdhttp
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/coreos/etcd/Godeps/_workspace/src/golang.org/x/net/context"
+	"github.com/coreos/etcd/etcdserver"
+	"github.com/coreos/etcd/etcdserver/etcdserverpb"
+	"github.com/coreos/etcd/etcdserver/security"
+	"github.com/coreos/etcd/store"
+)
+
+func TestClientCertAuth(t *testing.T) {
+	sec := security.NewStore(&storeDoer{store.New(etcdserver.StoreAdminPrefix)}, time.Second)
+	if err := sec.CreateUser(security.User{User: "foo", Password: "bar"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := sec.EnableSecurity(security.User{User: "root", Password: "rootpw"}); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		cn       string
+		username string
+		password string
+		certAuth bool
+
+		wroot bool
+		wkey  bool
+		// the user of a request granted access
+		wuser string
+	}{
+		{cn: "root", certAuth: true, wroot: true, wkey: true, wuser: "root"},
+		// client cert authentication is disabled
+		{cn: "root", certAuth: false, wroot: false, wkey: false},
+		// foo has no roles
+		{cn: "foo", certAuth: true, wroot: false, wkey: false},
+		// no such user
+		{cn: "bar", certAuth: true, wroot: false, wkey: false},
+		{cn: "", certAuth: true, wroot: false, wkey: false},
+		// basic auth takes precedence over the client certificate
+		{cn: "root", username: "root", password: "wrong", certAuth: true, wroot: false, wkey: false},
+		{cn: "foo", username: "root", password: "rootpw", certAuth: true, wroot: true, wkey: true, wuser: "root"},
+	}
+	for i, tt := range tests {
+		r, err := http.NewRequest("PUT", "http://localhost:2379/v2/keys/foo", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if tt.username != "" {
+			r.SetBasicAuth(tt.username, tt.password)
+		}
+		r.TLS = &tls.ConnectionState{
+			VerifiedChains: [][]*x509.Certificate{
+				{&x509.Certificate{Subject: pkix.Name{CommonName: tt.cn}}},
+			},
+		}
+
+		if g := hasRootAccess(sec, r, tt.certAuth); g != tt.wroot {
+			t.Errorf("#%d: root access = %v, want %v", i, g, tt.wroot)
+		}
+		if g := hasKeyPrefixAccess(sec, r, "/foo", tt.certAuth); g != tt.wkey {
+			t.Errorf("#%d: key access = %v, want %v", i, g, tt.wkey)
+		}
+		if tt.wkey {
+			if g := requestUser(sec, r, tt.certAuth); g != tt.wuser {
+				t.Errorf("#%d: user = %q, want %q", i, g, tt.wuser)
+			}
+		}
+	}
+}
+
+// storeDoer applies the requests to a store like the server does.
+type storeDoer struct {
+	st store.Store
+}
+
+func (d *storeDoer) Do(_ context.Context, r etcdserverpb.Request) (etcdserver.Response, error) {
+	var ev *store.Event
+	var err error
+	switch r.Method {
+	case "GET":
+		ev, err = d.st.Get(r.Path, r.Recursive, r.Sorted)
+	case "PUT":
+		switch {
+		case r.PrevExist == nil:
+			ev, err = d.st.Set(r.Path, r.Dir, r.Val, store.Permanent)
+		case *r.PrevExist:
+			ev, err = d.st.Update(r.Path, r.Val, store.Permanent)
+		default:
+			ev, err = d.st.Create(r.Path, r.Dir, r.Val, false, store.Permanent)
+		}
+	case "DELETE":
+		ev, err = d.st.Delete(r.Path, r.Dir, r.Recursive)
+	}
+	return etcdserver.Response{Event: ev}, err
+}
@@ -18,10 +18,12 @@ import (
 	"bytes"
 	"encoding/json"
 	"errors"
+	"fmt"
 	"io/ioutil"
 	"net/http"
 	"net/http/httptest"
 	"net/url"
+	"os"
 	"path"
 	"reflect"
 	"strings"
@@ -37,6 +39,7 @@ import (
 	"github.com/coreos/etcd/pkg/testutil"
 	"github.com/coreos/etcd/pkg/types"
 	"github.com/coreos/etcd/raft/raftpb"
+	"github.com/coreos/etcd/snap"
 	"github.com/coreos/etcd/store"
 	"github.com/coreos/etcd/version"
 )
@@ -116,6 +119,11 @@ func (s *serverRecorder) UpdateMember(_ context.Context, m etcdserver.Member) er
 	return nil
 }
 
+func (s *serverRecorder) TransferLeadership(_ context.Context, id types.ID) error {
+	s.actions = append(s.actions, action{name: "TransferLeadership", params: []interface{}{id}})
+	return nil
+}
+
 type action struct {
 	name   string
 	params []interface{}
@@ -149,13 +157,17 @@ func (rs *resServer) Process(_ context.Context, _ raftpb.Message) error
 func (rs *resServer) AddMember(_ context.Context, _ etcdserver.Member) error    { return nil }
 func (rs *resServer) RemoveMember(_ context.Context, _ uint64) error            { return nil }
 func (rs *resServer) UpdateMember(_ context.Context, _ etcdserver.Member) error { return nil }
+func (rs *resServer) TransferLeadership(_ context.Context, _ types.ID) error    { return nil }
 
 func boolp(b bool) *bool { return &b }
 
 type dummyRaftTimer struct{}
 
-func (drt dummyRaftTimer) Index() uint64 { return uint64(100) }
-func (drt dummyRaftTimer) Term() uint64  { return uint64(5) }
+func (drt dummyRaftTimer) Index() uint64          { return uint64(100) }
+func (drt dummyRaftTimer) Term() uint64           { return uint64(5) }
+func (drt dummyRaftTimer) CommittedIndex() uint64 { return uint64(101) }
+func (drt dummyRaftTimer) AppliedIndex() uint64   { return uint64(100) }
+func (drt dummyRaftTimer) CurrentTerm() uint64    { return uint64(6) }
 
 type dummyWatcher struct {
 	echan chan *store.Event
@@ -284,6 +296,115 @@ func TestBadParseRequest(t *testing.T) {
 			mustNewMethodRequest(t, "HEAD", "foo?wait=true"),
 			etcdErr.EcodeInvalidField,
 		},
+		// path is only valid with GET requests without wait
+		{
+			mustNewRequest(t, "foo?path=a&wait=true"),
+			etcdErr.EcodeInvalidField,
+		},
+		{
+			mustNewMethodRequest(t, "DELETE", "foo?path=a"),
+			etcdErr.EcodeInvalidField,
+		},
+		{
+			mustNewRequest(t, "foo?path=a&path="),
+			etcdErr.EcodeInvalidField,
+		},
+		// moveTo is only valid with PUT requests without a value
+		{
+			mustNewForm(t, "foo", url.Values{"moveTo": []string{""}}),
+			etcdErr.EcodeInvalidField,
+		},
+		{
+			mustNewMethodRequest(t, "DELETE", "foo?moveTo=/bar"),
+			etcdErr.EcodeInvalidField,
+		},
+		{
+			mustNewForm(t, "foo", url.Values{"moveTo": []string{"/bar"}, "value": []string{"v"}}),
+			etcdErr.EcodeInvalidField,
+		},
+		// prevMarker is only valid with DELETE requests on a directory
+		// with a prevValue
+		{
+			mustNewMethodRequest(t, "DELETE", "foo?recursive=true&prevMarker=&prevValue=bar"),
+			etcdErr.EcodeInvalidField,
+		},
+		{
+			mustNewMethodRequest(t, "DELETE", "foo?prevMarker=owner&prevValue=bar"),
+			etcdErr.EcodeInvalidField,
+		},
+		{
+			mustNewForm(t, "foo", url.Values{"dir": []string{"true"}, "prevMarker": []string{"owner"}, "prevValue": []string{"bar"}}),
+			etcdErr.EcodeInvalidField,
+		},
+		{
+			mustNewMethodRequest(t, "DELETE", "foo?recursive=true&prevMarker=owner"),
+			etcdErr.EcodePrevValueRequired,
+		},
+		// token is only valid with non-empty writes
+		{
+			mustNewForm(t, "foo", url.Values{"token": []string{""}}),
+			etcdErr.EcodeInvalidField,
+		},
+		{
+			mustNewRequest(t, "foo?token=abc"),
+			etcdErr.EcodeInvalidField,
+		},
+		// incr is only valid with PUT requests without a value or a ttl
+		{
+			mustNewForm(t, "foo", url.Values{"incr": []string{"one"}}),
+			etcdErr.EcodeInvalidField,
+		},
+		{
+			mustNewRequest(t, "foo?incr=1"),
+			etcdErr.EcodeInvalidField,
+		},
+		{
+			mustNewForm(t, "foo", url.Values{"incr": []string{"1"}, "value": []string{"2"}}),
+			etcdErr.EcodeInvalidField,
+		},
+		{
+			mustNewForm(t, "foo", url.Values{"incr": []string{"1"}, "ttl": []string{"10"}}),
+			etcdErr.EcodeInvalidField,
+		},
+		// refresh is only valid with PUT requests with a ttl and no value
+		{
+			mustNewRequest(t, "foo?refresh=true&ttl=10"),
+			etcdErr.EcodeInvalidField,
+		},
+		{
+			mustNewForm(t, "foo", url.Values{"refresh": []string{"true"}}),
+			etcdErr.EcodeTTLNaN,
+		},
+		{
+			mustNewForm(t, "foo", url.Values{"refresh": []string{"true"}, "ttl": []string{"10"}, "value": []string{"bar"}}),
+			etcdErr.EcodeInvalidField,
+		},
+		{
+			mustNewForm(t, "foo", url.Values{"refresh": []string{"true"}, "ttl": []string{"10"}, "prevExist": []string{"false"}}),
+			etcdErr.EcodeInvalidField,
+		},
+		// waitActions is only valid with wait and known actions
+		{
+			mustNewRequest(t, "foo?waitActions=set"),
+			etcdErr.EcodeInvalidField,
+		},
+		{
+			mustNewRequest(t, "foo?wait=true&waitActions=set,get"),
+			etcdErr.EcodeInvalidField,
+		},
+		// limit and continue are only valid with GET requests without wait
+		{
+			mustNewRequest(t, "foo?limit=many"),
+			etcdErr.EcodeInvalidField,
+		},
+		{
+			mustNewRequest(t, "foo?limit=10&wait=true"),
+			etcdErr.EcodeInvalidField,
+		},
+		{
+			mustNewMethodRequest(t, "DELETE", "foo?continue=/foo/bar"),
+			etcdErr.EcodeInvalidField,
+		},
 		// query values are considered
 		{
 			mustNewRequest(t, "foo?prevExist=wrong"),
@@ -399,6 +520,27 @@ func TestGoodParseRequest(t *testing.T) {
 				Path:   path.Join(etcdserver.StoreKeysPrefix, "/foo"),
 			},
 		},
+		{
+			// limit and continue specified
+			mustNewRequest(t, "foo?recursive=true&limit=100&continue=/foo/bar"),
+			etcdserverpb.Request{
+				Method:    "GET",
+				Recursive: true,
+				Limit:     100,
+				Continue:  path.Join(etcdserver.StoreKeysPrefix, "/foo/bar"),
+				Path:      path.Join(etcdserver.StoreKeysPrefix, "/foo"),
+			},
+		},
+		{
+			// waitActions specified
+			mustNewRequest(t, "foo?wait=true&waitActions=set,compareAndSwap"),
+			etcdserverpb.Request{
+				Method:  "GET",
+				Wait:    true,
+				Actions: []string{"set", "compareAndSwap"},
+				Path:    path.Join(etcdserver.StoreKeysPrefix, "/foo"),
+			},
+		},
 		{
 			// quorum specified
 			mustNewForm(
@@ -448,6 +590,68 @@ func TestGoodParseRequest(t *testing.T) {
 				Expiration: fc.Now().UnixNano(),
 			},
 		},
+		{
+			// refresh specified
+			mustNewForm(t, "foo", url.Values{"refresh": []string{"true"}, "ttl": []string{"10"}}),
+			etcdserverpb.Request{
+				Method:     "PUT",
+				Refresh:    true,
+				Path:       path.Join(etcdserver.StoreKeysPrefix, "/foo"),
+				Expiration: fc.Now().Add(10 * time.Second).UnixNano(),
+			},
+		},
+		{
+			// path specified
+			mustNewRequest(t, "foo?path=a&path=b/c"),
+			etcdserverpb.Request{
+				Method: "GET",
+				Quorum: true,
+				Paths: []string{
+					path.Join(etcdserver.StoreKeysPrefix, "/foo/a"),
+					path.Join(etcdserver.StoreKeysPrefix, "/foo/b/c"),
+				},
+				Path: path.Join(etcdserver.StoreKeysPrefix, "/foo"),
+			},
+		},
+		{
+			// moveTo specified
+			mustNewForm(t, "foo", url.Values{"moveTo": []string{"/bar/baz"}}),
+			etcdserverpb.Request{
+				Method: "MOVE",
+				MoveTo: path.Join(etcdserver.StoreKeysPrefix, "/bar/baz"),
+				Path:   path.Join(etcdserver.StoreKeysPrefix, "/foo"),
+			},
+		},
+		{
+			// prevMarker specified
+			mustNewMethodRequest(t, "DELETE", "foo?recursive=true&prevMarker=owner&prevValue=bar"),
+			etcdserverpb.Request{
+				Method:     "DELETE",
+				Recursive:  true,
+				PrevMarker: "owner",
+				PrevValue:  "bar",
+				Path:       path.Join(etcdserver.StoreKeysPrefix, "/foo"),
+			},
+		},
+		{
+			// token specified
+			mustNewForm(t, "foo", url.Values{"value": []string{"bar"}, "token": []string{"abc"}}),
+			etcdserverpb.Request{
+				Method: "PUT",
+				Val:    "bar",
+				Token:  "abc",
+				Path:   path.Join(etcdserver.StoreKeysPrefix, "/foo"),
+			},
+		},
+		{
+			// incr specified
+			mustNewForm(t, "foo", url.Values{"incr": []string{"-5"}}),
+			etcdserverpb.Request{
+				Method: "INCR",
+				Delta:  -5,
+				Path:   path.Join(etcdserver.StoreKeysPrefix, "/foo"),
+			},
+		},
 		{
 			// dir specified
 			mustNewRequest(t, "foo?dir=true"),
@@ -553,19 +757,22 @@ func TestGoodParseRequest(t *testing.T) {
 }
 
 func TestServeMembers(t *testing.T) {
-	memb1 := etcdserver.Member{ID: 12, Attributes: etcdserver.Attributes{ClientURLs: []string{"http://localhost:8080"}}}
-	memb2 := etcdserver.Member{ID: 13, Attributes: etcdserver.Attributes{ClientURLs: []string{"http://localhost:8081"}}}
+	memb1 := etcdserver.Member{ID: 12, Attributes: etcdserver.Attributes{ClientURLs: []string{"http://localhost:8080"}, Labels: map[string]string{"zone": "a", "rack": "r1"}}}
+	memb2 := etcdserver.Member{ID: 13, Attributes: etcdserver.Attributes{ClientURLs: []string{"http://localhost:8081"}, Labels: map[string]string{"zone": "b", "rack": "r1"}}}
 	cluster := &fakeCluster{
 		id:      1,
 		members: map[uint64]*etcdserver.Member{1: &memb1, 2: &memb2},
 	}
 	h := &membersHandler{
 		server:      &serverRecorder{},
+		status:      &fakeMemberStatuser{id: 12, lead: 12, committed: 10, applied: 9},
 		clock:       clockwork.NewFakeClock(),
 		clusterInfo: cluster,
 	}
 
-	wmc := string(`{"members":[{"id":"c","name":"","peerURLs":[],"clientURLs":["http://localhost:8080"]},{"id":"d","name":"","peerURLs":[],"clientURLs":["http://localhost:8081"]}]}`)
+	wm1 := `{"id":"c","name":"","peerURLs":[],"clientURLs":["http://localhost:8080"],"labels":{"rack":"r1","zone":"a"},"status":{"isLeader":true,"reachable":true,"lag":1}}`
+	wm2 := `{"id":"d","name":"","peerURLs":[],"clientURLs":["http://localhost:8081"],"labels":{"rack":"r1","zone":"b"},"status":{"isLeader":false,"reachable":true}}`
+	wmc := `{"members":[` + wm1 + "," + wm2 + "]}"
 
 	tests := []struct {
 		path  string
@@ -575,6 +782,12 @@ func TestServeMembers(t *testing.T) {
 	}{
 		{membersPrefix, http.StatusOK, "application/json", wmc + "\n"},
 		{membersPrefix + "/", http.StatusOK, "application/json", wmc + "\n"},
+		// filter by labels
+		{membersPrefix + "?label=zone=a", http.StatusOK, "application/json", `{"members":[` + wm1 + "]}\n"},
+		{membersPrefix + "?label=rack=r1&label=zone=b", http.StatusOK, "application/json", `{"members":[` + wm2 + "]}\n"},
+		{membersPrefix + "?label=rack=r1", http.StatusOK, "application/json", wmc + "\n"},
+		{membersPrefix + "?label=zone=c", http.StatusOK, "application/json", `{"members":[]}` + "\n"},
+		{membersPrefix + "?label=zone", http.StatusBadRequest, "application/json", `{"message":"invalid label: \"zone\""}`},
 		{path.Join(membersPrefix, "100"), http.StatusNotFound, "application/json", `{"message":"Not found"}`},
 		{path.Join(membersPrefix, "foobar"), http.StatusNotFound, "application/json", `{"message":"Not found"}`},
 	}
@@ -604,6 +817,60 @@ func TestServeMembers(t *testing.T) {
 	}
 }
 
+type fakeMemberStatuser struct {
+	id, lead           types.ID
+	committed, applied uint64
+	unreachable        map[types.ID]time.Time
+	matches            map[types.ID]uint64
+}
+
+func (s *fakeMemberStatuser) ID() types.ID                             { return s.id }
+func (s *fakeMemberStatuser) Leader() types.ID                         { return s.lead }
+func (s *fakeMemberStatuser) CommittedIndex() uint64                   { return s.committed }
+func (s *fakeMemberStatuser) AppliedIndex() uint64                     { return s.applied }
+func (s *fakeMemberStatuser) UnreachablePeers() map[types.ID]time.Time { return s.unreachable }
+func (s *fakeMemberStatuser) MatchIndexes() map[types.ID]uint64        { return s.matches }
+
+func TestSetMemberStatus(t *testing.T) {
+	lag := func(n uint64) *uint64 { return &n }
+	mc := httptypes.MemberCollection{{ID: "1"}, {ID: "2"}, {ID: "3"}}
+	tests := []struct {
+		s  *fakeMemberStatuser
+		ws []httptypes.MemberStatus
+	}{
+		// the leader knows the lag of every member
+		{
+			&fakeMemberStatuser{
+				id: 1, lead: 1, committed: 10, applied: 8,
+				unreachable: map[types.ID]time.Time{3: time.Time{}},
+				matches:     map[types.ID]uint64{1: 10, 2: 10, 3: 4},
+			},
+			[]httptypes.MemberStatus{
+				{IsLeader: true, Reachable: true, Lag: lag(2)},
+				{IsLeader: false, Reachable: true, Lag: lag(0)},
+				{IsLeader: false, Reachable: false, Lag: lag(6)},
+			},
+		},
+		// a follower only knows its own lag
+		{
+			&fakeMemberStatuser{id: 2, lead: 1, committed: 10, applied: 10},
+			[]httptypes.MemberStatus{
+				{IsLeader: true, Reachable: true},
+				{IsLeader: false, Reachable: true, Lag: lag(0)},
+				{IsLeader: false, Reachable: true},
+			},
+		},
+	}
+	for i, tt := range tests {
+		setMemberStatus(mc, tt.s)
+		for j, m := range mc {
+			if !reflect.DeepEqual(*m.Status, tt.ws[j]) {
+				t.Errorf("#%d.%d: status = %+v, want %+v", i, j, *m.Status, tt.ws[j])
+			}
+		}
+	}
+}
+
 // TODO: consolidate **ALL** fake server implementations and add no leader test case.
 func TestServeLeader(t *testing.T) {
 	memb1 := etcdserver.Member{ID: 1, Attributes: etcdserver.Attributes{ClientURLs: []string{"http://localhost:8080"}}}
@@ -756,29 +1023,616 @@ func TestServeMembersUpdate(t *testing.T) {
 	}
 	rw := httptest.NewRecorder()
 
-	h.ServeHTTP(rw, req)
+	h.ServeHTTP(rw, req)
+
+	wcode := http.StatusNoContent
+	if rw.Code != wcode {
+		t.Errorf("code=%d, want %d", rw.Code, wcode)
+	}
+
+	gcid := rw.Header().Get("X-Etcd-Cluster-ID")
+	wcid := h.clusterInfo.ID().String()
+	if gcid != wcid {
+		t.Errorf("cid = %s, want %s", gcid, wcid)
+	}
+
+	wm := etcdserver.Member{
+		ID: 1,
+		RaftAttributes: etcdserver.RaftAttributes{
+			PeerURLs: []string{"http://127.0.0.1:1"},
+		},
+	}
+
+	wactions := []action{{name: "UpdateMember", params: []interface{}{wm}}}
+	if !reflect.DeepEqual(s.actions, wactions) {
+		t.Errorf("actions = %+v, want %+v", s.actions, wactions)
+	}
+}
+
+func TestServeMembersTransferLeadership(t *testing.T) {
+	u := testutil.MustNewURL(t, path.Join(membersPrefix, "leader"))
+	b := []byte(`{"id":"beef"}`)
+	req, err := http.NewRequest("POST", u.String(), bytes.NewReader(b))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	s := &serverRecorder{}
+	h := &membersHandler{
+		server:      s,
+		clock:       clockwork.NewFakeClock(),
+		clusterInfo: &fakeCluster{id: 1},
+	}
+	rw := httptest.NewRecorder()
+
+	h.ServeHTTP(rw, req)
+
+	wcode := http.StatusNoContent
+	if rw.Code != wcode {
+		t.Errorf("code=%d, want %d", rw.Code, wcode)
+	}
+	wactions := []action{{name: "TransferLeadership", params: []interface{}{types.ID(0xbeef)}}}
+	if !reflect.DeepEqual(s.actions, wactions) {
+		t.Errorf("actions = %+v, want %+v", s.actions, wactions)
+	}
+}
+
+func TestServeMembersTransferLeadershipFail(t *testing.T) {
+	tests := []struct {
+		body string
+		err  error
+
+		wcode int
+	}{
+		{`{"id":"beef"}`, etcdserver.ErrIDNotFound, http.StatusNotFound},
+		{`{"id":"beef"}`, etcdserver.ErrNoLeader, http.StatusServiceUnavailable},
+		{`{"id":"beef"}`, etcdserver.ErrTimeoutLeaderTransfer, http.StatusServiceUnavailable},
+		{`{"id":"beef"}`, errors.New("blah"), http.StatusInternalServerError},
+		{`{"id":"xyz"}`, nil, http.StatusBadRequest},
+	}
+	for i, tt := range tests {
+		u := testutil.MustNewURL(t, path.Join(membersPrefix, "leader"))
+		req, err := http.NewRequest("POST", u.String(), strings.NewReader(tt.body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set("Content-Type", "application/json")
+		h := &membersHandler{
+			server:      &errServer{tt.err},
+			clock:       clockwork.NewFakeClock(),
+			clusterInfo: &fakeCluster{id: 1},
+		}
+		rw := httptest.NewRecorder()
+		h.ServeHTTP(rw, req)
+		if rw.Code != tt.wcode {
+			t.Errorf("#%d: code=%d, want %d", i, rw.Code, tt.wcode)
+		}
+	}
+}
+
+type fakeAlarmer struct {
+	alarms   []etcdserver.Alarm
+	disarmed []etcdserver.AlarmType
+}
+
+func (a *fakeAlarmer) Alarms() []etcdserver.Alarm { return a.alarms }
+func (a *fakeAlarmer) DisarmAlarm(_ context.Context, t etcdserver.AlarmType) error {
+	if !t.Valid() {
+		return etcdserver.ErrUnknownAlarm
+	}
+	a.disarmed = append(a.disarmed, t)
+	return nil
+}
+
+func TestServeAlarms(t *testing.T) {
+	tests := []struct {
+		method string
+		path   string
+
+		wcode     int
+		wbody     string
+		wdisarmed []etcdserver.AlarmType
+	}{
+		{"GET", alarmsPrefix, http.StatusOK, `{"alarms":[{"memberID":"1","alarm":"NOSPACE"}]}` + "\n", nil},
+		{"GET", alarmsPrefix + "/NOSPACE", http.StatusNotFound, "", nil},
+		{"DELETE", alarmsPrefix + "/NOSPACE", http.StatusNoContent, "", []etcdserver.AlarmType{etcdserver.AlarmNoSpace}},
+		{"DELETE", alarmsPrefix + "/FOO", http.StatusNotFound, "", nil},
+		{"DELETE", alarmsPrefix, http.StatusBadRequest, "", nil},
+		{"PUT", alarmsPrefix, http.StatusMethodNotAllowed, "", nil},
+	}
+	for i, tt := range tests {
+		a := &fakeAlarmer{alarms: []etcdserver.Alarm{{MemberID: 1, Type: etcdserver.AlarmNoSpace}}}
+		h := &alarmsHandler{
+			alarmer:     a,
+			clusterInfo: &fakeCluster{id: 1},
+		}
+		req := &http.Request{
+			Method: tt.method,
+			URL:    testutil.MustNewURL(t, tt.path),
+		}
+		rw := httptest.NewRecorder()
+		h.ServeHTTP(rw, req)
+
+		if rw.Code != tt.wcode {
+			t.Errorf("#%d: code = %d, want %d", i, rw.Code, tt.wcode)
+		}
+		if tt.wbody != "" && rw.Body.String() != tt.wbody {
+			t.Errorf("#%d: body = %q, want %q", i, rw.Body.String(), tt.wbody)
+		}
+		if !reflect.DeepEqual(a.disarmed, tt.wdisarmed) {
+			t.Errorf("#%d: disarmed = %v, want %v", i, a.disarmed, tt.wdisarmed)
+		}
+	}
+}
+
+type fakeDirQuotaer struct {
+	quotas  []etcdserver.DirQuota
+	set     []etcdserver.DirQuota
+	removed []string
+}
+
+func (q *fakeDirQuotaer) DirQuotas() []etcdserver.DirQuota { return q.quotas }
+func (q *fakeDirQuotaer) SetDirQuota(_ context.Context, dq etcdserver.DirQuota) error {
+	q.set = append(q.set, dq)
+	return nil
+}
+func (q *fakeDirQuotaer) RemoveDirQuota(_ context.Context, dir string) error {
+	q.removed = append(q.removed, dir)
+	return nil
+}
+
+func TestServeDirQuotas(t *testing.T) {
+	tests := []struct {
+		method string
+		path   string
+		form   url.Values
+
+		wcode    int
+		wbody    string
+		wset     []etcdserver.DirQuota
+		wremoved []string
+	}{
+		{"GET", dirQuotasPrefix, nil, http.StatusOK, `{"quotas":[{"dir":"/foo","maxKeys":10}]}` + "\n", nil, nil},
+		{"GET", dirQuotasPrefix + "/foo", nil, http.StatusNotFound, "", nil, nil},
+		{
+			"PUT", dirQuotasPrefix + "/foo/bar", url.Values{"maxKeys": {"10"}, "maxBytes": {"1024"}},
+			http.StatusNoContent, "", []etcdserver.DirQuota{{Dir: "/foo/bar", MaxKeys: 10, MaxBytes: 1024}}, nil,
+		},
+		{
+			"PUT", dirQuotasPrefix + "/foo", url.Values{"maxBytes": {"1024"}},
+			http.StatusNoContent, "", []etcdserver.DirQuota{{Dir: "/foo", MaxBytes: 1024}}, nil,
+		},
+		{"PUT", dirQuotasPrefix + "/foo", url.Values{}, http.StatusBadRequest, "", nil, nil},
+		{"PUT", dirQuotasPrefix + "/foo", url.Values{"maxKeys": {"-1"}}, http.StatusBadRequest, "", nil, nil},
+		{"PUT", dirQuotasPrefix + "/foo", url.Values{"maxKeys": {"bar"}}, http.StatusBadRequest, "", nil, nil},
+		{"PUT", dirQuotasPrefix, url.Values{"maxKeys": {"10"}}, http.StatusBadRequest, "", nil, nil},
+		{"DELETE", dirQuotasPrefix + "/foo", nil, http.StatusNoContent, "", nil, []string{"/foo"}},
+		{"DELETE", dirQuotasPrefix, nil, http.StatusBadRequest, "", nil, nil},
+		{"POST", dirQuotasPrefix, nil, http.StatusMethodNotAllowed, "", nil, nil},
+	}
+	for i, tt := range tests {
+		q := &fakeDirQuotaer{quotas: []etcdserver.DirQuota{{Dir: "/foo", MaxKeys: 10}}}
+		h := &dirQuotasHandler{
+			quotaer:     q,
+			clusterInfo: &fakeCluster{id: 1},
+		}
+		req := &http.Request{
+			Method:   tt.method,
+			URL:      testutil.MustNewURL(t, tt.path),
+			Form:     tt.form,
+			PostForm: url.Values{},
+		}
+		rw := httptest.NewRecorder()
+		h.ServeHTTP(rw, req)
+
+		if rw.Code != tt.wcode {
+			t.Errorf("#%d: code = %d, want %d", i, rw.Code, tt.wcode)
+		}
+		if tt.wbody != "" && rw.Body.String() != tt.wbody {
+			t.Errorf("#%d: body = %q, want %q", i, rw.Body.String(), tt.wbody)
+		}
+		if !reflect.DeepEqual(q.set, tt.wset) {
+			t.Errorf("#%d: set = %v, want %v", i, q.set, tt.wset)
+		}
+		if !reflect.DeepEqual(q.removed, tt.wremoved) {
+			t.Errorf("#%d: removed = %v, want %v", i, q.removed, tt.wremoved)
+		}
+	}
+}
+
+type fakeRuntimeConfiger struct {
+	configs []etcdserver.RuntimeConfig
+	set     []etcdserver.RuntimeConfig
+	removed []types.ID
+}
+
+func (c *fakeRuntimeConfiger) RuntimeConfigs() []etcdserver.RuntimeConfig { return c.configs }
+func (c *fakeRuntimeConfiger) SetRuntimeConfig(_ context.Context, rc etcdserver.RuntimeConfig) error {
+	if rc.MemberID != 1 {
+		return etcdserver.ErrIDNotFound
+	}
+	c.set = append(c.set, rc)
+	return nil
+}
+func (c *fakeRuntimeConfiger) RemoveRuntimeConfig(_ context.Context, id types.ID) error {
+	c.removed = append(c.removed, id)
+	return nil
+}
+
+func TestServeRuntimeConfigs(t *testing.T) {
+	snapCount, syncMs, slowMs := uint64(100), int64(0), int64(200)
+	tests := []struct {
+		method string
+		path   string
+		form   url.Values
+
+		wcode    int
+		wbody    string
+		wset     []etcdserver.RuntimeConfig
+		wremoved []types.ID
+	}{
+		{"GET", runtimeConfigsPrefix, nil, http.StatusOK, `{"configs":[{"memberID":"1","snapCount":100,"logLevel":"debug"}]}` + "\n", nil, nil},
+		{"GET", runtimeConfigsPrefix + "/1", nil, http.StatusNotFound, "", nil, nil},
+		{
+			"PUT", runtimeConfigsPrefix + "/1", url.Values{"snapCount": {"100"}, "syncIntervalMs": {"0"}},
+			http.StatusNoContent, "", []etcdserver.RuntimeConfig{{MemberID: 1, SnapCount: &snapCount, SyncIntervalMs: &syncMs}}, nil,
+		},
+		{
+			"PUT", runtimeConfigsPrefix + "/1", url.Values{"slowRequestThresholdMs": {"200"}, "logLevel": {"info"}},
+			http.StatusNoContent, "", []etcdserver.RuntimeConfig{{MemberID: 1, SlowRequestThresholdMs: &slowMs, LogLevel: "info"}}, nil,
+		},
+		{"PUT", runtimeConfigsPrefix + "/1", url.Values{}, http.StatusBadRequest, "", nil, nil},
+		{"PUT", runtimeConfigsPrefix + "/1", url.Values{"snapCount": {"0"}}, http.StatusBadRequest, "", nil, nil},
+		{"PUT", runtimeConfigsPrefix + "/1", url.Values{"syncIntervalMs": {"-1"}}, http.StatusBadRequest, "", nil, nil},
+		{"PUT", runtimeConfigsPrefix + "/1", url.Values{"logLevel": {"verbose"}}, http.StatusBadRequest, "", nil, nil},
+		{"PUT", runtimeConfigsPrefix + "/2", url.Values{"snapCount": {"100"}}, http.StatusNotFound, "", nil, nil},
+		{"PUT", runtimeConfigsPrefix + "/bad", url.Values{"snapCount": {"100"}}, http.StatusNotFound, "", nil, nil},
+		{"PUT", runtimeConfigsPrefix, url.Values{"snapCount": {"100"}}, http.StatusBadRequest, "", nil, nil},
+		{"DELETE", runtimeConfigsPrefix + "/1", nil, http.StatusNoContent, "", nil, []types.ID{1}},
+		{"DELETE", runtimeConfigsPrefix, nil, http.StatusBadRequest, "", nil, nil},
+		{"POST", runtimeConfigsPrefix, nil, http.StatusMethodNotAllowed, "", nil, nil},
+	}
+	for i, tt := range tests {
+		c := &fakeRuntimeConfiger{configs: []etcdserver.RuntimeConfig{{MemberID: 1, SnapCount: &snapCount, LogLevel: "debug"}}}
+		h := &runtimeConfigsHandler{
+			configer:    c,
+			clusterInfo: &fakeCluster{id: 1},
+		}
+		req := &http.Request{
+			Method:   tt.method,
+			URL:      testutil.MustNewURL(t, tt.path),
+			Form:     tt.form,
+			PostForm: url.Values{},
+		}
+		rw := httptest.NewRecorder()
+		h.ServeHTTP(rw, req)
+
+		if rw.Code != tt.wcode {
+			t.Errorf("#%d: code = %d, want %d", i, rw.Code, tt.wcode)
+		}
+		if tt.wbody != "" && rw.Body.String() != tt.wbody {
+			t.Errorf("#%d: body = %q, want %q", i, rw.Body.String(), tt.wbody)
+		}
+		if !reflect.DeepEqual(c.set, tt.wset) {
+			t.Errorf("#%d: set = %+v, want %+v", i, c.set, tt.wset)
+		}
+		if !reflect.DeepEqual(c.removed, tt.wremoved) {
+			t.Errorf("#%d: removed = %v, want %v", i, c.removed, tt.wremoved)
+		}
+	}
+}
+
+type fakeClusterConfiger struct {
+	config  etcdserver.ClusterConfig
+	updated []etcdserver.ClusterConfig
+	reset   bool
+}
+
+func (c *fakeClusterConfiger) ClusterConfig() etcdserver.ClusterConfig { return c.config }
+func (c *fakeClusterConfiger) UpdateClusterConfig(_ context.Context, cc etcdserver.ClusterConfig) error {
+	c.updated = append(c.updated, cc)
+	return nil
+}
+func (c *fakeClusterConfiger) ResetClusterConfig(_ context.Context) error {
+	c.reset = true
+	return nil
+}
+
+func TestServeClusterConfig(t *testing.T) {
+	activeSize, quota, maxValue := 3, int64(-1), int64(1024)
+	tests := []struct {
+		method string
+		form   url.Values
+
+		wcode    int
+		wbody    string
+		wupdated []etcdserver.ClusterConfig
+		wreset   bool
+	}{
+		{"GET", nil, http.StatusOK, `{"activeSize":3,"quotaBackendBytes":-1}` + "\n", nil, false},
+		{
+			"PUT", url.Values{"activeSize": {"3"}, "maxValueBytes": {"1024"}},
+			http.StatusNoContent, "", []etcdserver.ClusterConfig{{ActiveSize: &activeSize, MaxValueBytes: &maxValue}}, false,
+		},
+		{
+			"PUT", url.Values{"quotaBackendBytes": {"-1"}},
+			http.StatusNoContent, "", []etcdserver.ClusterConfig{{QuotaBackendBytes: &quota}}, false,
+		},
+		{"PUT", url.Values{}, http.StatusBadRequest, "", nil, false},
+		{"PUT", url.Values{"activeSize": {"-1"}}, http.StatusBadRequest, "", nil, false},
+		{"PUT", url.Values{"maxRequestBytes": {"big"}}, http.StatusBadRequest, "", nil, false},
+		{"DELETE", nil, http.StatusNoContent, "", nil, true},
+		{"POST", nil, http.StatusMethodNotAllowed, "", nil, false},
+	}
+	for i, tt := range tests {
+		c := &fakeClusterConfiger{config: etcdserver.ClusterConfig{ActiveSize: &activeSize, QuotaBackendBytes: &quota}}
+		h := &clusterConfigHandler{
+			configer:    c,
+			clusterInfo: &fakeCluster{id: 1},
+		}
+		req := &http.Request{
+			Method:   tt.method,
+			URL:      testutil.MustNewURL(t, clusterConfigPath),
+			Form:     tt.form,
+			PostForm: url.Values{},
+		}
+		rw := httptest.NewRecorder()
+		h.ServeHTTP(rw, req)
+
+		if rw.Code != tt.wcode {
+			t.Errorf("#%d: code = %d, want %d", i, rw.Code, tt.wcode)
+		}
+		if tt.wbody != "" && rw.Body.String() != tt.wbody {
+			t.Errorf("#%d: body = %q, want %q", i, rw.Body.String(), tt.wbody)
+		}
+		if !reflect.DeepEqual(c.updated, tt.wupdated) {
+			t.Errorf("#%d: updated = %+v, want %+v", i, c.updated, tt.wupdated)
+		}
+		if c.reset != tt.wreset {
+			t.Errorf("#%d: reset = %v, want %v", i, c.reset, tt.wreset)
+		}
+	}
+}
+
+type fakeMaintainer struct {
+	index    uint64
+	snapshot raftpb.Snapshot
+	hashes   []etcdserver.StoreHash
+	export   *store.Export
+	event    *store.Event
+	err      error
+
+	// path and imported are the arguments of the last export or import.
+	path     string
+	imported *store.Export
+}
+
+func (m *fakeMaintainer) Snapshot(_ context.Context) (uint64, error) { return m.index, m.err }
+func (m *fakeMaintainer) Backup(_ context.Context) (raftpb.Snapshot, error) {
+	return m.snapshot, m.err
+}
+func (m *fakeMaintainer) Export(_ context.Context, p string) (*store.Export, error) {
+	m.path = p
+	return m.export, m.err
+}
+func (m *fakeMaintainer) Import(_ context.Context, p string, ex *store.Export) (*store.Event, error) {
+	m.path, m.imported = p, ex
+	return m.event, m.err
+}
+func (m *fakeMaintainer) StoreHash(index uint64) (etcdserver.StoreHash, bool) {
+	for i := len(m.hashes) - 1; i >= 0; i-- {
+		if index == 0 || m.hashes[i].Index == index {
+			return m.hashes[i], true
+		}
+	}
+	return etcdserver.StoreHash{}, false
+}
+
+func TestServeSnapshot(t *testing.T) {
+	tests := []struct {
+		method string
+		err    error
+
+		wcode int
+		wbody string
+	}{
+		{"POST", nil, http.StatusOK, `{"index":10}` + "\n"},
+		{"POST", etcdserver.ErrStopped, http.StatusInternalServerError, ""},
+		{"GET", nil, http.StatusMethodNotAllowed, ""},
+	}
+	for i, tt := range tests {
+		h := &maintenanceHandler{
+			maintainer:  &fakeMaintainer{index: 10, err: tt.err},
+			clusterInfo: &fakeCluster{id: 1},
+		}
+		req := &http.Request{
+			Method: tt.method,
+			URL:    testutil.MustNewURL(t, maintenancePrefix+"/snapshot"),
+		}
+		rw := httptest.NewRecorder()
+		h.serveSnapshot(rw, req)
+
+		if rw.Code != tt.wcode {
+			t.Errorf("#%d: code = %d, want %d", i, rw.Code, tt.wcode)
+		}
+		if tt.wbody != "" && rw.Body.String() != tt.wbody {
+			t.Errorf("#%d: body = %q, want %q", i, rw.Body.String(), tt.wbody)
+		}
+	}
+}
+
+func TestServeBackup(t *testing.T) {
+	wsnap := raftpb.Snapshot{
+		Data: []byte("some snapshot"),
+		Metadata: raftpb.SnapshotMetadata{
+			ConfState: raftpb.ConfState{Nodes: []uint64{1}},
+			Index:     10,
+			Term:      2,
+		},
+	}
+	h := &maintenanceHandler{
+		maintainer:  &fakeMaintainer{snapshot: wsnap},
+		clusterInfo: &fakeCluster{id: 1},
+	}
+	req := &http.Request{
+		Method: "GET",
+		URL:    testutil.MustNewURL(t, backupPath),
+	}
+	rw := httptest.NewRecorder()
+	h.serveBackup(rw, req)
+
+	if rw.Code != http.StatusOK {
+		t.Fatalf("code = %d, want %d", rw.Code, http.StatusOK)
+	}
+	wheader := map[string]string{
+		"Content-Type":        "application/octet-stream",
+		"Content-Disposition": `attachment; filename="0000000000000002-000000000000000a.snap"`,
+		"X-Etcd-Cluster-ID":   "1",
+		"X-Raft-Index":        "10",
+		"X-Raft-Term":         "2",
+	}
+	for k, v := range wheader {
+		if g := rw.Header().Get(k); g != v {
+			t.Errorf("header %s = %q, want %q", k, g, v)
+		}
+	}
+
+	f, err := ioutil.TempFile("", "backup")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(f.Name())
+	if _, err = f.Write(rw.Body.Bytes()); err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+	g, err := snap.Read(f.Name())
+	if err != nil {
+		t.Fatalf("unexpected read error: %v", err)
+	}
+	if !reflect.DeepEqual(*g, wsnap) {
+		t.Errorf("snapshot = %+v, want %+v", *g, wsnap)
+	}
+}
+
+func TestServeExport(t *testing.T) {
+	tests := []struct {
+		url string
+		err error
 
-	wcode := http.StatusNoContent
-	if rw.Code != wcode {
-		t.Errorf("code=%d, want %d", rw.Code, wcode)
+		wpath string
+		wcode int
+		wbody string
+	}{
+		{
+			maintenancePrefix + "/export/foo", nil,
+			"/1/foo", http.StatusOK,
+			`{"version":1,"index":5,"node":{"key":"/foo","dir":true,"nodes":[{"key":"/foo/bar","value":"baz","modifiedIndex":4,"createdIndex":4}],"modifiedIndex":3,"createdIndex":3}}` + "\n",
+		},
+		{
+			// the root is exported with its key
+			maintenancePrefix + "/export", nil,
+			"/1", http.StatusOK,
+			`{"version":1,"index":5,"node":{"key":"/","dir":true,"nodes":[{"key":"/foo/bar","value":"baz","modifiedIndex":4,"createdIndex":4}],"modifiedIndex":3,"createdIndex":3}}` + "\n",
+		},
+		{
+			maintenancePrefix + "/export/foo", etcdErr.NewError(etcdErr.EcodeKeyNotFound, "/1/foo", 5),
+			"/1/foo", http.StatusNotFound,
+			`{"errorCode":100,"message":"Key not found","cause":"/foo","index":5}` + "\n",
+		},
 	}
+	for i, tt := range tests {
+		bar := "baz"
+		m := &fakeMaintainer{
+			export: &store.Export{
+				Version: store.ExportVersion,
+				Index:   5,
+				Node: &store.NodeExtern{
+					Key: "/1/foo", Dir: true, ModifiedIndex: 3, CreatedIndex: 3,
+					Nodes: store.NodeExterns{
+						{Key: "/1/foo/bar", Value: &bar, ModifiedIndex: 4, CreatedIndex: 4},
+					},
+				},
+			},
+			err: tt.err,
+		}
+		h := &maintenanceHandler{maintainer: m, clusterInfo: &fakeCluster{id: 1}}
+		req := &http.Request{Method: "GET", URL: testutil.MustNewURL(t, tt.url)}
+		rw := httptest.NewRecorder()
+		h.serveExport(rw, req)
 
-	gcid := rw.Header().Get("X-Etcd-Cluster-ID")
-	wcid := h.clusterInfo.ID().String()
-	if gcid != wcid {
-		t.Errorf("cid = %s, want %s", gcid, wcid)
+		if m.path != tt.wpath {
+			t.Errorf("#%d: path = %q, want %q", i, m.path, tt.wpath)
+		}
+		if rw.Code != tt.wcode {
+			t.Errorf("#%d: code = %d, want %d", i, rw.Code, tt.wcode)
+		}
+		if rw.Body.String() != tt.wbody {
+			t.Errorf("#%d: body = %q, want %q", i, rw.Body.String(), tt.wbody)
+		}
 	}
+}
 
-	wm := etcdserver.Member{
-		ID: 1,
-		RaftAttributes: etcdserver.RaftAttributes{
-			PeerURLs: []string{"http://127.0.0.1:1"},
-		},
+func TestServeImport(t *testing.T) {
+	export := `{"version":1,"index":5,"node":{"key":"/foo","dir":true,"nodes":[{"key":"/foo/bar","value":"baz"}]}}`
+	tests := []struct {
+		method string
+		url    string
+		body   string
+		err    error
+
+		wimported bool
+		wcode     int
+	}{
+		{"PUT", importPrefix + "/new", export, nil, true, http.StatusCreated},
+		{"PUT", importPrefix + "/new", export, etcdErr.NewError(etcdErr.EcodeNodeExist, "/1/new", 5), true, http.StatusPreconditionFailed},
+		{"PUT", importPrefix + "/new", export, etcdserver.ErrInvalidExport, true, http.StatusBadRequest},
+		{"PUT", importPrefix + "/new", export, etcdserver.ErrValueTooLarge, true, http.StatusRequestEntityTooLarge},
+		// no key
+		{"PUT", importPrefix + "/", export, nil, false, http.StatusBadRequest},
+		// not JSON
+		{"PUT", importPrefix + "/new", "foo", nil, false, http.StatusBadRequest},
+		// unsupported version
+		{"PUT", importPrefix + "/new", `{"version":2,"node":{"key":"/foo","value":"bar"}}`, nil, false, http.StatusBadRequest},
+		// not a tree
+		{"PUT", importPrefix + "/new", `{"version":1,"node":{"key":"/foo","dir":true,"nodes":[{"key":"/bar","value":"baz"}]}}`, nil, false, http.StatusBadRequest},
+		{"POST", importPrefix + "/new", export, nil, false, http.StatusMethodNotAllowed},
 	}
+	for i, tt := range tests {
+		m := &fakeMaintainer{
+			event: &store.Event{Action: store.Import, Node: &store.NodeExtern{Key: "/1/new", Dir: true, ModifiedIndex: 6, CreatedIndex: 6}, EtcdIndex: 6},
+			err:   tt.err,
+		}
+		h := &maintenanceHandler{maintainer: m, clusterInfo: &fakeCluster{id: 1}}
+		req := &http.Request{
+			Method: tt.method,
+			URL:    testutil.MustNewURL(t, tt.url),
+			Body:   ioutil.NopCloser(strings.NewReader(tt.body)),
+		}
+		rw := httptest.NewRecorder()
+		h.serveImport(rw, req)
 
-	wactions := []action{{name: "UpdateMember", params: []interface{}{wm}}}
-	if !reflect.DeepEqual(s.actions, wactions) {
-		t.Errorf("actions = %+v, want %+v", s.actions, wactions)
+		if rw.Code != tt.wcode {
+			t.Errorf("#%d: code = %d, want %d", i, rw.Code, tt.wcode)
+		}
+		if g := m.imported != nil; g != tt.wimported {
+			t.Errorf("#%d: imported = %v, want %v", i, g, tt.wimported)
+		}
+		if !tt.wimported {
+			continue
+		}
+		if m.path != "/1/new" {
+			t.Errorf("#%d: path = %q, want %q", i, m.path, "/1/new")
+		}
+		if tt.err == nil {
+			wbody := `{"action":"import","node":{"key":"/new","dir":true,"modifiedIndex":6,"createdIndex":6}}` + "\n"
+			if rw.Body.String() != wbody {
+				t.Errorf("#%d: body = %q, want %q", i, rw.Body.String(), wbody)
+			}
+			if g := rw.Header().Get("X-Etcd-Index"); g != "6" {
+				t.Errorf("#%d: X-Etcd-Index = %q, want %q", i, g, "6")
+			}
+		}
 	}
 }
 
@@ -1127,6 +1981,30 @@ func TestWriteEvent(t *testing.T) {
 	}
 }
 
+func TestWriteKeyGets(t *testing.T) {
+	v := "bar"
+	gets := []etcdserver.GetResult{
+		{Event: &store.Event{
+			Action:    store.Get,
+			Node:      &store.NodeExtern{Key: etcdserver.StoreKeysPrefix + "/foo", Value: &v},
+			EtcdIndex: 7,
+		}},
+		{Err: etcdErr.NewError(etcdErr.EcodeKeyNotFound, etcdserver.StoreKeysPrefix+"/nokey", 7)},
+	}
+	rw := httptest.NewRecorder()
+	if err := writeKeyGets(rw, gets, dummyRaftTimer{}); err != nil {
+		t.Fatal(err)
+	}
+	if gei := rw.Header().Get("X-Etcd-Index"); gei != "7" {
+		t.Errorf("X-Etcd-Index = %s, want 7", gei)
+	}
+	wbody := `{"results":[{"action":"get","node":{"key":"/foo","value":"bar"}},` +
+		`{"error":{"errorCode":100,"message":"Key not found","cause":"/nokey","index":7}}]}` + "\n"
+	if g := rw.Body.String(); g != wbody {
+		t.Errorf("body = %s, want %s", g, wbody)
+	}
+}
+
 func TestV2DeprecatedMachinesEndpoint(t *testing.T) {
 	tests := []struct {
 		method string
@@ -1322,7 +2200,7 @@ func TestServeVersion(t *testing.T) {
 		t.Fatalf("error creating request: %v", err)
 	}
 	rw := httptest.NewRecorder()
-	serveVersion(rw, req)
+	versionHandler(&fakeCluster{})(rw, req)
 	if rw.Code != http.StatusOK {
 		t.Errorf("code=%d, want %d", rw.Code, http.StatusOK)
 	}
@@ -1332,6 +2210,158 @@ func TestServeVersion(t *testing.T) {
 	}
 }
 
+// fakeHealthServer is a healthServer whose checks return the given
+// results.
+type fakeHealthServer struct {
+	lead      types.ID
+	doErr     error
+	committed uint64
+	applied   uint64
+	diskErr   error
+	purgeErr  error
+}
+
+func (s *fakeHealthServer) Leader() types.ID { return s.lead }
+func (s *fakeHealthServer) Do(ctx context.Context, r etcdserverpb.Request) (etcdserver.Response, error) {
+	return etcdserver.Response{}, s.doErr
+}
+func (s *fakeHealthServer) CommittedIndex() uint64                   { return s.committed }
+func (s *fakeHealthServer) AppliedIndex() uint64                     { return s.applied }
+func (s *fakeHealthServer) UnreachablePeers() map[types.ID]time.Time { return nil }
+func (s *fakeHealthServer) CheckDiskWriteable() error                { return s.diskErr }
+func (s *fakeHealthServer) CheckPurge() error                        { return s.purgeErr }
+
+func TestHealthHandler(t *testing.T) {
+	notFound := etcdErr.NewError(etcdErr.EcodeKeyNotFound, healthKey, 10)
+	tests := []struct {
+		s       *fakeHealthServer
+		wcode   int
+		wleader string
+		wlag    uint64
+		werrs   int
+	}{
+		{&fakeHealthServer{lead: 1, doErr: notFound, committed: 10, applied: 10}, http.StatusOK, "1", 0, 0},
+		{&fakeHealthServer{lead: 1, committed: 12, applied: 10}, http.StatusOK, "1", 2, 0},
+		// no leader
+		{&fakeHealthServer{committed: 10, applied: 10}, http.StatusServiceUnavailable, "", 0, 1},
+		// cannot commit
+		{&fakeHealthServer{lead: 1, doErr: etcdserver.ErrTimeout, committed: 10, applied: 10}, http.StatusServiceUnavailable, "1", 0, 1},
+		// disk not writeable, and cannot commit
+		{&fakeHealthServer{lead: 1, doErr: etcdserver.ErrTimeout, diskErr: errors.New("read-only file system")}, http.StatusServiceUnavailable, "1", 0, 2},
+		// cannot purge the old files
+		{&fakeHealthServer{lead: 1, purgeErr: errors.New("wal: permission denied")}, http.StatusServiceUnavailable, "1", 0, 1},
+	}
+	for i, tt := range tests {
+		req, err := http.NewRequest("GET", healthPath, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		rw := httptest.NewRecorder()
+		healthHandler(tt.s).ServeHTTP(rw, req)
+
+		if rw.Code != tt.wcode {
+			t.Errorf("#%d: code = %d, want %d", i, rw.Code, tt.wcode)
+		}
+		var h health
+		if err := json.NewDecoder(rw.Body).Decode(&h); err != nil {
+			t.Fatalf("#%d: %v", i, err)
+		}
+		if whealth := fmt.Sprint(tt.wcode == http.StatusOK); h.Health != whealth {
+			t.Errorf("#%d: health = %s, want %s", i, h.Health, whealth)
+		}
+		if h.Leader != tt.wleader {
+			t.Errorf("#%d: leader = %q, want %q", i, h.Leader, tt.wleader)
+		}
+		if h.AppliedLag != tt.wlag {
+			t.Errorf("#%d: appliedLag = %d, want %d", i, h.AppliedLag, tt.wlag)
+		}
+		if len(h.Errors) != tt.werrs {
+			t.Errorf("#%d: errors = %v, want %d errors", i, h.Errors, tt.werrs)
+		}
+	}
+}
+
+type fakeReadinessChecker struct{ err error }
+
+func (rc *fakeReadinessChecker) CheckReady() error { return rc.err }
+
+func TestReadyHandler(t *testing.T) {
+	tests := []struct {
+		err   error
+		wcode int
+		wbody string
+	}{
+		{nil, http.StatusOK, `{"health":"true"}`},
+		{errors.New("no leader"), http.StatusServiceUnavailable, `{"health":"false","reason":"no leader"}`},
+	}
+	for i, tt := range tests {
+		req, err := http.NewRequest("GET", healthPath+"/ready", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		rw := httptest.NewRecorder()
+		readyHandler(&fakeReadinessChecker{err: tt.err}).ServeHTTP(rw, req)
+		if rw.Code != tt.wcode {
+			t.Errorf("#%d: code = %d, want %d", i, rw.Code, tt.wcode)
+		}
+		if g := strings.TrimSpace(rw.Body.String()); g != tt.wbody {
+			t.Errorf("#%d: body = %s, want %s", i, g, tt.wbody)
+		}
+	}
+}
+
+func TestMetricsHandler(t *testing.T) {
+	h := NewMetricsHandler()
+	tests := []struct {
+		path  string
+		wcode int
+	}{
+		{"/metrics", http.StatusOK},
+		{"/debug/vars", http.StatusOK},
+		{"/v2/keys/foo", http.StatusNotFound},
+		{"/debug/pprof/", http.StatusNotFound},
+	}
+	for i, tt := range tests {
+		req, err := http.NewRequest("GET", tt.path, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		rw := httptest.NewRecorder()
+		h.ServeHTTP(rw, req)
+		if rw.Code != tt.wcode {
+			t.Errorf("#%d: %s code = %d, want %d", i, tt.path, rw.Code, tt.wcode)
+		}
+	}
+}
+
+func TestPprofHandler(t *testing.T) {
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusTeapot)
+	})
+	h := NewPprofHandler(next)
+	tests := []struct {
+		path  string
+		wcode int
+	}{
+		{"/debug/pprof/", http.StatusOK},
+		{"/debug/pprof/goroutine", http.StatusOK},
+		{"/debug/pprof/cmdline", http.StatusOK},
+		{"/v2/keys/foo", http.StatusTeapot},
+		{"/debug/vars", http.StatusTeapot},
+	}
+	for i, tt := range tests {
+		req, err := http.NewRequest("GET", tt.path, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		rw := httptest.NewRecorder()
+		h.ServeHTTP(rw, req)
+		if rw.Code != tt.wcode {
+			t.Errorf("#%d: %s code = %d, want %d", i, tt.path, rw.Code, tt.wcode)
+		}
+	}
+}
+
 func TestServeVersionFails(t *testing.T) {
 	for _, m := range []string{
 		"CONNECT", "TRACE", "PUT", "POST", "HEAD",
@@ -1341,7 +2371,7 @@ func TestServeVersionFails(t *testing.T) {
 			t.Fatalf("error creating request: %v", err)
 		}
 		rw := httptest.NewRecorder()
-		serveVersion(rw, req)
+		versionHandler(&fakeCluster{})(rw, req)
 		if rw.Code != http.StatusMethodNotAllowed {
 			t.Errorf("method %s: code=%d, want %d", m, rw.Code, http.StatusMethodNotAllowed)
 		}
@@ -1423,6 +2453,7 @@ func TestBadServeKeys(t *testing.T) {
 			timeout:     0, // context times out immediately
 			server:      tt.server,
 			clusterInfo: &fakeCluster{id: 1},
+			timer:       &dummyRaftTimer{},
 		}
 		rw := httptest.NewRecorder()
 		h.ServeHTTP(rw, tt.req)
@@ -1491,6 +2522,170 @@ func TestServeKeysGood(t *testing.T) {
 	}
 }
 
+func TestParseRequestTimeout(t *testing.T) {
+	tests := []struct {
+		query    string
+		wtimeout time.Duration
+		werr     bool
+	}{
+		{"", time.Second, false},
+		{"timeoutMs=0", time.Second, false},
+		{"timeoutMs=200", 200 * time.Millisecond, false},
+		{"timeoutMs=60000", time.Minute, false},
+		{"timeoutMs=60001", 0, true},
+		{"timeoutMs=18446744073709551615", 0, true},
+		{"timeoutMs=-1", 0, true},
+		{"timeoutMs=abc", 0, true},
+	}
+	for i, tt := range tests {
+		form, err := url.ParseQuery(tt.query)
+		if err != nil {
+			t.Fatal(err)
+		}
+		timeout, err := parseRequestTimeout(form, time.Second)
+		if (err != nil) != tt.werr {
+			t.Errorf("#%d: err = %v, want error %v", i, err, tt.werr)
+		}
+		if timeout != tt.wtimeout {
+			t.Errorf("#%d: timeout = %v, want %v", i, timeout, tt.wtimeout)
+		}
+	}
+}
+
+// deadlineServer records the deadline of the context of the request.
+type deadlineServer struct {
+	resServer
+	deadline time.Time
+}
+
+func (s *deadlineServer) Do(ctx context.Context, r etcdserverpb.Request) (etcdserver.Response, error) {
+	s.deadline, _ = ctx.Deadline()
+	return s.resServer.Do(ctx, r)
+}
+
+// Ensure that the timeout given by the client, and the default one if
+// there is none, is used for the request.
+func TestServeKeysTimeout(t *testing.T) {
+	tests := []struct {
+		path     string
+		wtimeout time.Duration
+	}{
+		{"foo", time.Hour},
+		{"foo?timeoutMs=300", 300 * time.Millisecond},
+	}
+	for i, tt := range tests {
+		server := &deadlineServer{resServer: resServer{etcdserver.Response{
+			Event: &store.Event{Action: store.Get, Node: &store.NodeExtern{}},
+		}}}
+		h := &keysHandler{
+			timeout:     time.Hour,
+			server:      server,
+			timer:       &dummyRaftTimer{},
+			clusterInfo: &fakeCluster{id: 1},
+		}
+		start := time.Now()
+		rw := httptest.NewRecorder()
+		h.ServeHTTP(rw, mustNewRequest(t, tt.path))
+		if rw.Code != http.StatusOK {
+			t.Fatalf("#%d: code = %d, want %d", i, rw.Code, http.StatusOK)
+		}
+		if g := server.deadline.Sub(start); g < tt.wtimeout || g > tt.wtimeout+time.Second {
+			t.Errorf("#%d: timeout = %v, want %v", i, g, tt.wtimeout)
+		}
+	}
+}
+
+// fakeStaleness records the bounds it checks and returns err.
+type fakeStaleness struct {
+	maxEntries uint64
+	maxLag     time.Duration
+	checked    bool
+	err        error
+}
+
+func (f *fakeStaleness) CheckStaleness(maxEntries uint64, maxLag time.Duration) error {
+	f.maxEntries, f.maxLag, f.checked = maxEntries, maxLag, true
+	return f.err
+}
+
+func TestServeKeysStaleRead(t *testing.T) {
+	tests := []struct {
+		req *http.Request
+		err error
+
+		wchecked    bool
+		wmaxEntries uint64
+		wmaxLag     time.Duration
+		wcode       int
+	}{
+		// no bounds
+		{
+			mustNewRequest(t, "foo"), nil,
+			false, 0, 0, http.StatusOK,
+		},
+		{
+			mustNewRequest(t, "foo?maxStaleEntries=10"), nil,
+			true, 10, 0, http.StatusOK,
+		},
+		{
+			mustNewRequest(t, "foo?maxStaleEntries=10&maxStaleMs=500"), nil,
+			true, 10, 500 * time.Millisecond, http.StatusOK,
+		},
+		{
+			mustNewRequest(t, "foo?maxStaleMs=500"), etcdserver.ErrTooStale,
+			true, 0, 500 * time.Millisecond, http.StatusServiceUnavailable,
+		},
+		// the quorum reads are not bounded
+		{
+			mustNewRequest(t, "foo?maxStaleEntries=10&quorum=true"), etcdserver.ErrTooStale,
+			false, 0, 0, http.StatusOK,
+		},
+		// the writes are not bounded
+		{
+			mustNewMethodRequest(t, "DELETE", "foo?maxStaleEntries=10"), etcdserver.ErrTooStale,
+			false, 0, 0, http.StatusOK,
+		},
+		{
+			mustNewRequest(t, "foo?maxStaleEntries=bar"), nil,
+			false, 0, 0, http.StatusBadRequest,
+		},
+	}
+	server := &resServer{
+		etcdserver.Response{
+			Event: &store.Event{
+				Action: store.Get,
+				Node:   &store.NodeExtern{},
+			},
+		},
+	}
+	for i, tt := range tests {
+		st := &fakeStaleness{err: tt.err}
+		h := &keysHandler{
+			timeout:     time.Hour,
+			server:      server,
+			timer:       &dummyRaftTimer{},
+			staleness:   st,
+			clusterInfo: &fakeCluster{id: 1},
+		}
+		rw := httptest.NewRecorder()
+		h.ServeHTTP(rw, tt.req)
+		if rw.Code != tt.wcode {
+			t.Errorf("#%d: got code=%d, want %d", i, rw.Code, tt.wcode)
+		}
+		if st.checked != tt.wchecked {
+			t.Errorf("#%d: checked = %v, want %v", i, st.checked, tt.wchecked)
+		}
+		if st.maxEntries != tt.wmaxEntries || st.maxLag != tt.wmaxLag {
+			t.Errorf("#%d: bounds = (%d, %v), want (%d, %v)", i, st.maxEntries, st.maxLag, tt.wmaxEntries, tt.wmaxLag)
+		}
+		if tt.wcode == http.StatusOK {
+			if g := rw.Header().Get("X-Raft-Index"); g != "100" {
+				t.Errorf("#%d: X-Raft-Index = %q, want %q", i, g, "100")
+			}
+		}
+	}
+}
+
 func TestServeKeysEvent(t *testing.T) {
 	req := mustNewRequest(t, "foo")
 	server := &resServer{
@@ -1808,6 +3003,38 @@ func TestHandleWatchStreaming(t *testing.T) {
 	}
 }
 
+func TestHandleWatchStreamingOverflow(t *testing.T) {
+	rw := &flushingRecorder{
+		httptest.NewRecorder(),
+		make(chan struct{}, 1),
+	}
+	wa := &dummyWatcher{
+		echan: make(chan *store.Event, 1),
+	}
+	ev := &store.Event{
+		Action:      store.Overflow,
+		Node:        &store.NodeExtern{Key: "/foo", ModifiedIndex: 5},
+		ResyncIndex: 5,
+	}
+	wa.echan <- ev
+
+	done := make(chan struct{})
+	go func() {
+		handleKeyWatch(context.Background(), rw, wa, true, dummyRaftTimer{})
+		close(done)
+	}()
+
+	// the stream ends after the overflow event
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatalf("timed out waiting for done")
+	}
+	if g, w := rw.Body.String(), mustMarshalEvent(t, ev); g != w {
+		t.Errorf("got body=%#v, want %#v", g, w)
+	}
+}
+
 func TestTrimEventPrefix(t *testing.T) {
 	pre := "/abc"
 	tests := []struct {
@@ -1840,6 +3067,16 @@ func TestTrimEventPrefix(t *testing.T) {
 				PrevNode: &store.NodeExtern{Key: "/ghi"},
 			},
 		},
+		{
+			&store.Event{
+				Node:     &store.NodeExtern{Key: "/abc/def"},
+				Continue: "/abc/def/ghi",
+			},
+			&store.Event{
+				Node:     &store.NodeExtern{Key: "/def"},
+				Continue: "/def/ghi",
+			},
+		},
 	}
 	for i, tt := range tests {
 		ev := trimEventPrefix(tt.ev, pre)
@@ -1956,3 +3193,23 @@ func TestNewMember(t *testing.T) {
 		t.Fatalf("newMember failure: want=%#v, got=%#v", want, got)
 	}
 }
+
+func TestRaftHeaderHandler(t *testing.T) {
+	h := raftHeaderHandler(http.HandlerFunc(http.NotFound), dummyRaftTimer{})
+	rw := httptest.NewRecorder()
+	h.ServeHTTP(rw, &http.Request{Method: "GET", URL: testutil.MustNewURL(t, "/foo")})
+
+	if rw.Code != http.StatusNotFound {
+		t.Errorf("code = %d, want %d", rw.Code, http.StatusNotFound)
+	}
+	wh := map[string]string{
+		"X-Raft-Index":        "100",
+		"X-Raft-Term":         "5",
+		"X-Raft-Commit-Index": "101",
+	}
+	for k, v := range wh {
+		if g := rw.Header().Get(k); g != v {
+			t.Errorf("header %s = %s, want %s", k, g, v)
+		}
+	}
+}
@@ -0,0 +1,60 @@
+// Copyright 2015 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package etcdhttp
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/coreos/etcd/etcdserver"
+	"github.com/coreos/etcd/store"
+)
+
+// keyETag returns the ETag of the key read by the get event ev: its
+// modified index, which changes with every write of the key. A
+// directory has no ETag, as the writes of its children leave its
+// modified index as it is.
+// 以key的modifiedIndex作为ETag，目录没有ETag
+func keyETag(ev *store.Event) (string, bool) {
+	if ev.Action != store.Get || ev.Node == nil || ev.Node.Dir {
+		return "", false
+	}
+	return fmt.Sprintf("%q", fmt.Sprint(ev.Node.ModifiedIndex)), true
+}
+
+// noneMatch returns false if the If-None-Match header of r matches etag,
+// i.e. the client holds the current version of the key already.
+func noneMatch(r *http.Request, etag string) bool {
+	for _, h := range r.Header["If-None-Match"] {
+		for _, t := range strings.Split(h, ",") {
+			t = strings.TrimSpace(t)
+			// If-None-Match uses the weak comparison
+			if t == "*" || strings.TrimPrefix(t, "W/") == etag {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// writeKeyNotModified tells the client that the key read by ev is not
+// modified since the version it holds.
+func writeKeyNotModified(w http.ResponseWriter, ev *store.Event, rt etcdserver.RaftTimer) {
+	w.Header().Set("X-Etcd-Index", fmt.Sprint(ev.EtcdIndex))
+	w.Header().Set("X-Raft-Index", fmt.Sprint(rt.Index()))
+	w.Header().Set("X-Raft-Term", fmt.Sprint(rt.Term()))
+	w.WriteHeader(http.StatusNotModified)
+}
@@ -0,0 +1,123 @@
+// Copyright 2015 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package etcdhttp
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/coreos/etcd/etcdserver"
+	"github.com/coreos/etcd/store"
+)
+
+func TestKeyETag(t *testing.T) {
+	tests := []struct {
+		ev    *store.Event
+		wetag string
+		wok   bool
+	}{
+		{&store.Event{Action: store.Get, Node: &store.NodeExtern{Key: "/foo", ModifiedIndex: 7}}, `"7"`, true},
+		// a directory
+		{&store.Event{Action: store.Get, Node: &store.NodeExtern{Key: "/foo", Dir: true, ModifiedIndex: 7}}, "", false},
+		// not a get
+		{&store.Event{Action: store.Set, Node: &store.NodeExtern{Key: "/foo", ModifiedIndex: 7}}, "", false},
+	}
+	for i, tt := range tests {
+		etag, ok := keyETag(tt.ev)
+		if etag != tt.wetag || ok != tt.wok {
+			t.Errorf("#%d: keyETag = %q, %v, want %q, %v", i, etag, ok, tt.wetag, tt.wok)
+		}
+	}
+}
+
+func TestNoneMatch(t *testing.T) {
+	tests := []struct {
+		header string
+		w      bool
+	}{
+		{"", true},
+		{`"7"`, false},
+		{`W/"7"`, false},
+		{`"3", "7"`, false},
+		{"*", false},
+		{`"3"`, true},
+		{"7", true},
+	}
+	for i, tt := range tests {
+		req, err := http.NewRequest("GET", "/v2/keys/foo", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if tt.header != "" {
+			req.Header.Set("If-None-Match", tt.header)
+		}
+		if g := noneMatch(req, `"7"`); g != tt.w {
+			t.Errorf("#%d: noneMatch(%q) = %v, want %v", i, tt.header, g, tt.w)
+		}
+	}
+}
+
+// Ensure that a get of an unchanged key is answered with 304 and no
+// body, and that of a changed key with the key and its ETag.
+func TestServeKeysNotModified(t *testing.T) {
+	v := "bar"
+	server := &resServer{
+		etcdserver.Response{
+			Event: &store.Event{
+				Action:    store.Get,
+				Node:      &store.NodeExtern{Key: "/foo", Value: &v, ModifiedIndex: 7},
+				EtcdIndex: 9,
+			},
+		},
+	}
+	h := &keysHandler{
+		timeout:     time.Hour,
+		server:      server,
+		clusterInfo: &fakeCluster{id: 1},
+		timer:       &dummyRaftTimer{},
+	}
+	tests := []struct {
+		match string
+		wcode int
+	}{
+		{"", http.StatusOK},
+		{`"6"`, http.StatusOK},
+		{`"7"`, http.StatusNotModified},
+	}
+	for i, tt := range tests {
+		req := mustNewRequest(t, "foo")
+		req.Header = http.Header{}
+		if tt.match != "" {
+			req.Header.Set("If-None-Match", tt.match)
+		}
+		rw := httptest.NewRecorder()
+		h.ServeHTTP(rw, req)
+
+		if rw.Code != tt.wcode {
+			t.Errorf("#%d: code = %d, want %d", i, rw.Code, tt.wcode)
+		}
+		if g := rw.Header().Get("ETag"); g != `"7"` {
+			t.Errorf("#%d: etag = %s, want %s", i, g, `"7"`)
+		}
+		if g := rw.Header().Get("X-Etcd-Index"); g != "9" {
+			t.Errorf("#%d: X-Etcd-Index = %s, want 9", i, g)
+		}
+		if g := rw.Body.Len() == 0; g != (tt.wcode == http.StatusNotModified) {
+			t.Errorf("#%d: body = %q", i, rw.Body.String())
+		}
+	}
+}
@@ -0,0 +1,294 @@
+// Copyright 2015 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package etcdhttp
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/coreos/etcd/Godeps/_workspace/src/github.com/prometheus/client_golang/prometheus"
+	"github.com/coreos/etcd/etcdserver"
+	"github.com/coreos/etcd/etcdserver/etcdhttp/httptypes"
+	"github.com/coreos/etcd/pkg/types"
+	"github.com/coreos/etcd/raft"
+)
+
+const (
+	// forwardedByHeader records the member that forwarded a write to the
+	// leader. A write carrying it is served where it arrives, so that it
+	// is forwarded at most once and cannot loop between the members. It
+	// is trusted only if the write comes from a peer host of the member.
+	forwardedByHeader = "X-Etcd-Forwarded-By"
+	// forwardedToHeader tells the client the leader its write has been
+	// forwarded to.
+	forwardedToHeader = "X-Etcd-Forwarded-To"
+)
+
+// Hop-by-hop headers, which are not forwarded.
+var hopHeaders = []string{
+	"Connection",
+	"Keep-Alive",
+	"Proxy-Authenticate",
+	"Proxy-Authorization",
+	"Te",
+	"Trailers",
+	"Transfer-Encoding",
+	"Upgrade",
+}
+
+var writesForwarded = prometheus.NewCounter(prometheus.CounterOpts{
+	Name: "etcdhttp_writes_forwarded_total",
+	Help: "The total number of the key writes forwarded to the leader.",
+})
+
+func init() {
+	prometheus.MustRegister(writesForwarded)
+}
+
+// leaderGetter tells the member and its current leader.
+type leaderGetter interface {
+	ID() types.ID
+	Leader() types.ID
+}
+
+// forwardHandler proxies the key writes a follower receives to the
+// client URL of the leader, so that a client may send its writes to any
+// member and the leader serves them as if they were sent to it.
+// follower把收到的写请求转发给leader
+type forwardHandler struct {
+	next        http.Handler
+	server      leaderGetter
+	clusterInfo etcdserver.ClusterInfo
+	transport   http.RoundTripper
+	// the leader would authenticate a forwarded write by the certificate
+	// of the follower instead of the client, so the writes are served
+	// locally if the clients authenticate with their certificates
+	clientCertAuthEnabled bool
+}
+
+// NewForwardHandler returns a handler that forwards the key writes,
+// i.e. the POST, PUT and DELETE requests of the keys, to the leader over
+// tr while the server is a follower, and serves the other requests with
+// next. The writes are served with next too if there is no leader, the
+// write has been forwarded already, or the client certificates
+// authenticate the clients.
+func NewForwardHandler(next http.Handler, server *etcdserver.EtcdServer, tr http.RoundTripper) http.Handler {
+	return &forwardHandler{
+		next:                  next,
+		server:                server,
+		clusterInfo:           server.Cluster,
+		transport:             tr,
+		clientCertAuthEnabled: server.ClientCertAuthEnabled(),
+	}
+}
+
+func (h *forwardHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Header.Get(forwardedByHeader) != "" && !h.forwardedByPeer(r) {
+		// a client must not stop its write from being forwarded
+		r.Header.Del(forwardedByHeader)
+	}
+	m := h.leaderToForward(r)
+	if m == nil {
+		h.next.ServeHTTP(w, r)
+		return
+	}
+	// the body is kept to send the write again to another client URL
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		writeError(w, httptypes.NewHTTPError(http.StatusBadRequest, err.Error()))
+		return
+	}
+	r.Body.Close()
+
+	tried := make(map[string]bool)
+	if h.forwardTo(w, r, body, m, tried) {
+		return
+	}
+	// the leader may have changed while its client URLs were unreachable
+	if m = h.leaderToForward(r); m == nil {
+		r.Body = ioutil.NopCloser(bytes.NewReader(body))
+		h.next.ServeHTTP(w, r)
+		return
+	}
+	if h.forwardTo(w, r, body, m, tried) {
+		return
+	}
+	herr := httptypes.NewHTTPError(http.StatusBadGateway, "Failed to forward the write to the leader")
+	herr.Reason = httptypes.ReasonForwardFailed
+	herr.WriteTo(w)
+}
+
+// forwardTo forwards r with the given body to the client URLs of the
+// leader m that are not tried yet, in turn, until one of them can be
+// connected. It returns false if none of them can be connected, and true
+// once it has written the response.
+func (h *forwardHandler) forwardTo(w http.ResponseWriter, r *http.Request, body []byte, m *etcdserver.Member, tried map[string]bool) bool {
+	for _, cu := range m.ClientURLs {
+		if tried[cu] {
+			continue
+		}
+		tried[cu] = true
+		u, err := url.Parse(cu)
+		if err != nil {
+			log.Printf("etcdhttp: cannot parse the client URL %s of the leader %s: %v", cu, m.ID, err)
+			continue
+		}
+		resp, err := h.transport.RoundTrip(h.newForwardRequest(r, body, u))
+		if err != nil {
+			log.Printf("etcdhttp: failed to forward the write to the leader %s at %s: %v", m.ID, cu, err)
+			if isDialError(err) {
+				// the leader has not seen the write, so another URL is tried
+				continue
+			}
+			// the leader may have applied the write, so it is not retryable
+			herr := httptypes.NewHTTPError(http.StatusBadGateway, "Failed to forward the write to the leader")
+			herr.Reason = httptypes.ReasonForwardFailed
+			herr.WriteTo(w)
+			return true
+		}
+		writesForwarded.Inc()
+
+		for _, hh := range hopHeaders {
+			resp.Header.Del(hh)
+		}
+		// the response headers are those of the leader, e.g. its raft index
+		for k, vv := range resp.Header {
+			w.Header()[k] = vv
+		}
+		w.Header().Set(forwardedToHeader, m.ID.String())
+		w.WriteHeader(resp.StatusCode)
+		io.Copy(w, resp.Body)
+		resp.Body.Close()
+		return true
+	}
+	return false
+}
+
+// newForwardRequest returns the request that forwards r with the given
+// body to the client URL u of the leader.
+func (h *forwardHandler) newForwardRequest(r *http.Request, body []byte, u *url.URL) *http.Request {
+	req := new(http.Request)
+	*req = *r
+	ru := *r.URL
+	ru.Scheme, ru.Host = u.Scheme, u.Host
+	req.URL = &ru
+	req.Host = u.Host
+	req.RequestURI = ""
+	req.Proto, req.ProtoMajor, req.ProtoMinor = "HTTP/1.1", 1, 1
+	req.Close = false
+	req.Body = ioutil.NopCloser(bytes.NewReader(body))
+	req.ContentLength = int64(len(body))
+	req.Header = make(http.Header)
+	copyHeader(req.Header, r.Header)
+	for _, hh := range hopHeaders {
+		req.Header.Del(hh)
+	}
+	req.Header.Set(forwardedByHeader, h.server.ID().String())
+	return req
+}
+
+// forwardedByPeer returns true if r names another member of the cluster
+// in forwardedByHeader, and comes from a host of the peer URLs of that
+// member. A client may set the header too, so it is not trusted otherwise.
+func (h *forwardHandler) forwardedByPeer(r *http.Request) bool {
+	id, err := types.IDFromString(r.Header.Get(forwardedByHeader))
+	if err != nil || id == h.server.ID() {
+		return false
+	}
+	m := h.clusterInfo.Member(id)
+	if m == nil {
+		return false
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return false
+	}
+	for _, pu := range m.PeerURLs {
+		u, err := url.Parse(pu)
+		if err != nil {
+			continue
+		}
+		phost, _, err := net.SplitHostPort(u.Host)
+		if err != nil {
+			phost = u.Host
+		}
+		if phost == host {
+			return true
+		}
+		// the peer URL may name the host instead of its address
+		addrs, err := net.LookupHost(phost)
+		if err != nil {
+			continue
+		}
+		for _, addr := range addrs {
+			if addr == host {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// isDialError returns true if err tells that the connection could not be
+// established, i.e. the request has not been sent.
+func isDialError(err error) bool {
+	oe, ok := err.(*net.OpError)
+	return ok && oe.Op == "dial"
+}
+
+// leaderToForward returns the leader to forward r to, or nil if r is
+// served locally.
+func (h *forwardHandler) leaderToForward(r *http.Request) *etcdserver.Member {
+	if !isKeyWrite(r) || r.Header.Get(forwardedByHeader) != "" || h.clientCertAuthEnabled {
+		return nil
+	}
+	lead := h.server.Leader()
+	if uint64(lead) == raft.None || lead == h.server.ID() {
+		// without a leader the write fails fast with ErrProposalDropped
+		return nil
+	}
+	m := h.clusterInfo.Member(lead)
+	if m == nil || len(m.ClientURLs) == 0 {
+		return nil
+	}
+	return m
+}
+
+// isKeyWrite returns true if r writes the keys.
+func isKeyWrite(r *http.Request) bool {
+	if r.URL.Path != keysPrefix && !strings.HasPrefix(r.URL.Path, keysPrefix+"/") {
+		return false
+	}
+	switch r.Method {
+	case "POST", "PUT", "DELETE":
+		return true
+	default:
+		return false
+	}
+}
+
+func copyHeader(dst, src http.Header) {
+	for k, vv := range src {
+		for _, v := range vv {
+			dst.Add(k, v)
+		}
+	}
+}
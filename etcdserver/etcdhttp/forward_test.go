@@ -0,0 +1,355 @@
+// Copyright 2015 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package etcdhttp
+
+import (
+	"crypto/tls"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/coreos/etcd/etcdserver"
+	"github.com/coreos/etcd/pkg/types"
+)
+
+type fakeLeaderGetter struct {
+	id, lead types.ID
+}
+
+func (g *fakeLeaderGetter) ID() types.ID     { return g.id }
+func (g *fakeLeaderGetter) Leader() types.ID { return g.lead }
+
+// changingLeaderGetter tells the next of its leaders every time it is
+// asked, and the last one once it has told all the others.
+type changingLeaderGetter struct {
+	id    types.ID
+	leads []types.ID
+}
+
+func (g *changingLeaderGetter) ID() types.ID { return g.id }
+func (g *changingLeaderGetter) Leader() types.ID {
+	lead := g.leads[0]
+	if len(g.leads) > 1 {
+		g.leads = g.leads[1:]
+	}
+	return lead
+}
+
+// newFakeLeader returns a leader that tells its ID, the method, the path
+// and the body of the writes it receives.
+func newFakeLeader(id string) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, _ := ioutil.ReadAll(r.Body)
+		w.WriteHeader(http.StatusCreated)
+		w.Write([]byte("leader " + id + " " + r.Method + " " + r.URL.RequestURI() + " " + string(b)))
+	}))
+}
+
+// newClosedURL returns a client URL nothing listens on.
+func newClosedURL() string {
+	s := httptest.NewServer(http.NotFoundHandler())
+	s.Close()
+	return s.URL
+}
+
+func TestForwardHandler(t *testing.T) {
+	var forwardedBy string
+	leader := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		forwardedBy = r.Header.Get(forwardedByHeader)
+		b, _ := ioutil.ReadAll(r.Body)
+		w.Header().Set("X-Raft-Index", "10")
+		w.WriteHeader(http.StatusCreated)
+		w.Write([]byte("leader " + r.Method + " " + r.URL.RequestURI() + " " + string(b)))
+	}))
+	defer leader.Close()
+
+	cluster := &fakeCluster{members: map[uint64]*etcdserver.Member{
+		2: {ID: 2, Attributes: etcdserver.Attributes{ClientURLs: []string{leader.URL}}},
+		3: {ID: 3},
+		4: {ID: 4, RaftAttributes: etcdserver.RaftAttributes{PeerURLs: []string{"http://10.0.0.4:2380"}}},
+	}}
+	tests := []struct {
+		method   string
+		path     string
+		header   http.Header
+		remote   string
+		lead     types.ID
+		certAuth bool
+
+		wforward bool
+	}{
+		{"PUT", "/v2/keys/foo?prevExist=false", nil, "10.0.0.9:5000", 2, false, true},
+		{"POST", "/v2/keys/dir", nil, "10.0.0.9:5000", 2, false, true},
+		{"DELETE", "/v2/keys/foo", nil, "10.0.0.9:5000", 2, false, true},
+		// reads
+		{"GET", "/v2/keys/foo", nil, "10.0.0.9:5000", 2, false, false},
+		{"HEAD", "/v2/keys/foo", nil, "10.0.0.9:5000", 2, false, false},
+		// not a key
+		{"PUT", "/v2/members/1", nil, "10.0.0.9:5000", 2, false, false},
+		// forwarded already by the member 4
+		{"PUT", "/v2/keys/foo", http.Header{forwardedByHeader: {"4"}}, "10.0.0.4:5000", 2, false, false},
+		// the header is set by a client, not a peer host of the member
+		{"PUT", "/v2/keys/foo", http.Header{forwardedByHeader: {"4"}}, "10.0.0.9:5000", 2, false, true},
+		// the header names no member
+		{"PUT", "/v2/keys/foo", http.Header{forwardedByHeader: {"5"}}, "10.0.0.4:5000", 2, false, true},
+		{"PUT", "/v2/keys/foo", http.Header{forwardedByHeader: {"bad"}}, "10.0.0.4:5000", 2, false, true},
+		// no leader
+		{"PUT", "/v2/keys/foo", nil, "10.0.0.9:5000", 0, false, false},
+		// the leader itself
+		{"PUT", "/v2/keys/foo", nil, "10.0.0.9:5000", 1, false, false},
+		// no client URL of the leader
+		{"PUT", "/v2/keys/foo", nil, "10.0.0.9:5000", 3, false, false},
+		// the leader cannot tell the client certificate
+		{"PUT", "/v2/keys/foo", nil, "10.0.0.9:5000", 2, true, false},
+	}
+	for i, tt := range tests {
+		forwardedBy = ""
+		var served bool
+		h := &forwardHandler{
+			next: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				served = true
+				if g, w := r.Header.Get(forwardedByHeader), tt.header.Get(forwardedByHeader); g != w {
+					t.Errorf("#%d: served %s = %q, want %q", i, forwardedByHeader, g, w)
+				}
+			}),
+			server:                &fakeLeaderGetter{id: 1, lead: tt.lead},
+			clusterInfo:           cluster,
+			transport:             &http.Transport{},
+			clientCertAuthEnabled: tt.certAuth,
+		}
+		req, err := http.NewRequest(tt.method, "http://localhost:2379"+tt.path, strings.NewReader("value=bar"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		for k, v := range tt.header {
+			req.Header[k] = v
+		}
+		req.RemoteAddr = tt.remote
+		rw := httptest.NewRecorder()
+		h.ServeHTTP(rw, req)
+
+		if served == tt.wforward {
+			t.Errorf("#%d: served locally = %t, want %t", i, served, !tt.wforward)
+		}
+		if !tt.wforward {
+			continue
+		}
+		if forwardedBy != "1" {
+			t.Errorf("#%d: %s = %q, want %q", i, forwardedByHeader, forwardedBy, "1")
+		}
+		if rw.Code != http.StatusCreated {
+			t.Errorf("#%d: code = %d, want %d", i, rw.Code, http.StatusCreated)
+		}
+		if g := rw.HeaderMap.Get(forwardedToHeader); g != "2" {
+			t.Errorf("#%d: %s = %q, want %q", i, forwardedToHeader, g, "2")
+		}
+		if g := rw.HeaderMap.Get("X-Raft-Index"); g != "10" {
+			t.Errorf("#%d: X-Raft-Index = %q, want %q", i, g, "10")
+		}
+		if w := "leader " + tt.method + " " + tt.path + " value=bar"; rw.Body.String() != w {
+			t.Errorf("#%d: body = %q, want %q", i, rw.Body.String(), w)
+		}
+	}
+}
+
+func TestForwardHandlerLeaderUnreachable(t *testing.T) {
+	leader := httptest.NewServer(http.NotFoundHandler())
+	// the leader is gone
+	leader.Close()
+
+	h := &forwardHandler{
+		next: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			t.Errorf("write served locally")
+		}),
+		server: &fakeLeaderGetter{id: 1, lead: 2},
+		clusterInfo: &fakeCluster{members: map[uint64]*etcdserver.Member{
+			2: {ID: 2, Attributes: etcdserver.Attributes{ClientURLs: []string{leader.URL}}},
+		}},
+		transport: &http.Transport{},
+	}
+	req, err := http.NewRequest("PUT", "http://localhost:2379/v2/keys/foo", strings.NewReader("value=bar"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	rw := httptest.NewRecorder()
+	h.ServeHTTP(rw, req)
+	if rw.Code != http.StatusBadGateway {
+		t.Errorf("code = %d, want %d", rw.Code, http.StatusBadGateway)
+	}
+	if w := `"reason":"FORWARD_FAILED"`; !strings.Contains(rw.Body.String(), w) {
+		t.Errorf("body = %s, want to contain %s", rw.Body.String(), w)
+	}
+}
+
+func TestForwardHandlerNextClientURL(t *testing.T) {
+	leader := newFakeLeader("2")
+	defer leader.Close()
+
+	h := &forwardHandler{
+		next: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			t.Errorf("write served locally")
+		}),
+		server: &fakeLeaderGetter{id: 1, lead: 2},
+		clusterInfo: &fakeCluster{members: map[uint64]*etcdserver.Member{
+			2: {ID: 2, Attributes: etcdserver.Attributes{ClientURLs: []string{newClosedURL(), leader.URL}}},
+		}},
+		transport: &http.Transport{},
+	}
+	req, err := http.NewRequest("PUT", "http://localhost:2379/v2/keys/foo", strings.NewReader("value=bar"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	rw := httptest.NewRecorder()
+	h.ServeHTTP(rw, req)
+	if rw.Code != http.StatusCreated {
+		t.Errorf("code = %d, want %d", rw.Code, http.StatusCreated)
+	}
+	if w := "leader 2 PUT /v2/keys/foo value=bar"; rw.Body.String() != w {
+		t.Errorf("body = %q, want %q", rw.Body.String(), w)
+	}
+}
+
+// TestForwardHandlerLeaderChanged tests that the leader is resolved again
+// once none of the client URLs of the leader can be connected, and the
+// write is forwarded to the new leader, or served locally if the member
+// has become the leader.
+func TestForwardHandlerLeaderChanged(t *testing.T) {
+	leader := newFakeLeader("3")
+	defer leader.Close()
+	cluster := &fakeCluster{members: map[uint64]*etcdserver.Member{
+		2: {ID: 2, Attributes: etcdserver.Attributes{ClientURLs: []string{newClosedURL()}}},
+		3: {ID: 3, Attributes: etcdserver.Attributes{ClientURLs: []string{leader.URL}}},
+	}}
+	tests := []struct {
+		leads []types.ID
+
+		wserved bool
+		wcode   int
+		wbody   string
+	}{
+		{[]types.ID{2, 3}, false, http.StatusCreated, "leader 3 PUT /v2/keys/foo value=bar"},
+		{[]types.ID{2, 1}, true, http.StatusNoContent, ""},
+		{[]types.ID{2, 0}, true, http.StatusNoContent, ""},
+		// still the same leader
+		{[]types.ID{2}, false, http.StatusBadGateway, ""},
+	}
+	for i, tt := range tests {
+		var served bool
+		h := &forwardHandler{
+			next: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				served = true
+				// the body is served as it has been received
+				if b, _ := ioutil.ReadAll(r.Body); string(b) != "value=bar" {
+					t.Errorf("#%d: served body = %q, want %q", i, b, "value=bar")
+				}
+				w.WriteHeader(http.StatusNoContent)
+			}),
+			server:      &changingLeaderGetter{id: 1, leads: tt.leads},
+			clusterInfo: cluster,
+			transport:   &http.Transport{},
+		}
+		req, err := http.NewRequest("PUT", "http://localhost:2379/v2/keys/foo", strings.NewReader("value=bar"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		rw := httptest.NewRecorder()
+		h.ServeHTTP(rw, req)
+		if served != tt.wserved {
+			t.Errorf("#%d: served locally = %t, want %t", i, served, tt.wserved)
+		}
+		if rw.Code != tt.wcode {
+			t.Errorf("#%d: code = %d, want %d", i, rw.Code, tt.wcode)
+		}
+		if tt.wbody != "" && rw.Body.String() != tt.wbody {
+			t.Errorf("#%d: body = %q, want %q", i, rw.Body.String(), tt.wbody)
+		}
+	}
+}
+
+// TestForwardHandlerConnectionLost tests that the write is not sent again
+// once the leader may have received it.
+func TestForwardHandlerConnectionLost(t *testing.T) {
+	received := make(chan struct{}, 2)
+	lost := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received <- struct{}{}
+		conn, _, err := w.(http.Hijacker).Hijack()
+		if err != nil {
+			t.Fatal(err)
+		}
+		conn.Close()
+	}))
+	defer lost.Close()
+	leader := newFakeLeader("2")
+	defer leader.Close()
+
+	h := &forwardHandler{
+		next: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			t.Errorf("write served locally")
+		}),
+		server: &fakeLeaderGetter{id: 1, lead: 2},
+		clusterInfo: &fakeCluster{members: map[uint64]*etcdserver.Member{
+			2: {ID: 2, Attributes: etcdserver.Attributes{ClientURLs: []string{lost.URL, leader.URL}}},
+		}},
+		transport: &http.Transport{},
+	}
+	req, err := http.NewRequest("PUT", "http://localhost:2379/v2/keys/foo", strings.NewReader("value=bar"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	rw := httptest.NewRecorder()
+	h.ServeHTTP(rw, req)
+	if rw.Code != http.StatusBadGateway {
+		t.Errorf("code = %d, want %d", rw.Code, http.StatusBadGateway)
+	}
+	if n := len(received); n != 1 {
+		t.Errorf("received = %d, want 1", n)
+	}
+}
+
+// TestForwardHandlerClientCertAuth tests that the writes are served
+// locally with the certificate of the client if the client certificates
+// authenticate the clients, as the leader would see the certificate of
+// the follower instead.
+func TestForwardHandlerClientCertAuth(t *testing.T) {
+	leader := newFakeLeader("2")
+	defer leader.Close()
+
+	var served *http.Request
+	h := &forwardHandler{
+		next: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			served = r
+		}),
+		server: &fakeLeaderGetter{id: 1, lead: 2},
+		clusterInfo: &fakeCluster{members: map[uint64]*etcdserver.Member{
+			2: {ID: 2, Attributes: etcdserver.Attributes{ClientURLs: []string{leader.URL}}},
+		}},
+		transport:             &http.Transport{},
+		clientCertAuthEnabled: true,
+	}
+	req, err := http.NewRequest("PUT", "https://localhost:2379/v2/keys/foo", strings.NewReader("value=bar"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.TLS = &tls.ConnectionState{}
+	h.ServeHTTP(httptest.NewRecorder(), req)
+	if served != req {
+		t.Fatalf("served request = %v, want the request of the client", served)
+	}
+	if served.TLS != req.TLS {
+		t.Errorf("served TLS state = %v, want the state of the client", served.TLS)
+	}
+}
@@ -0,0 +1,106 @@
+// Copyright 2015 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package etcdhttp
+
+import (
+	"compress/gzip"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// minGzipSize is the size of a response body from which it is
+// compressed. A smaller body fits in a few packets anyway, and is not
+// worth the CPU.
+const minGzipSize = 1400
+
+// gzipResponseWriter compresses a response body with gzip once it grows
+// to minGzipSize. The body is buffered until then, so a small response
+// is written as it is. Close must be called to write the rest of the
+// body.
+// 响应体较大时才进行gzip压缩
+type gzipResponseWriter struct {
+	http.ResponseWriter
+	code int
+	buf  []byte
+	gz   *gzip.Writer
+}
+
+func newGzipResponseWriter(w http.ResponseWriter) *gzipResponseWriter {
+	return &gzipResponseWriter{ResponseWriter: w, code: http.StatusOK}
+}
+
+// WriteHeader holds the status code until it is known whether the body
+// is compressed.
+func (w *gzipResponseWriter) WriteHeader(code int) { w.code = code }
+
+func (w *gzipResponseWriter) Write(p []byte) (int, error) {
+	if w.gz != nil {
+		return w.gz.Write(p)
+	}
+	w.buf = append(w.buf, p...)
+	if len(w.buf) < minGzipSize {
+		return len(p), nil
+	}
+	h := w.Header()
+	h.Add("Vary", "Accept-Encoding")
+	h.Set("Content-Encoding", "gzip")
+	h.Del("Content-Length")
+	w.ResponseWriter.WriteHeader(w.code)
+	// gzip.NewWriterLevel never fails with a valid level
+	w.gz, _ = gzip.NewWriterLevel(w.ResponseWriter, gzip.BestSpeed)
+	buf := w.buf
+	w.buf = nil
+	if _, err := w.gz.Write(buf); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// Close writes the rest of the body, compressed or not.
+func (w *gzipResponseWriter) Close() error {
+	if w.gz != nil {
+		return w.gz.Close()
+	}
+	w.Header().Add("Vary", "Accept-Encoding")
+	w.ResponseWriter.WriteHeader(w.code)
+	_, err := w.ResponseWriter.Write(w.buf)
+	return err
+}
+
+// acceptsGzip returns true if the client of r accepts a body compressed
+// with gzip.
+func acceptsGzip(r *http.Request) bool {
+	for _, h := range r.Header["Accept-Encoding"] {
+		for _, enc := range strings.Split(h, ",") {
+			parts := strings.Split(enc, ";")
+			if strings.TrimSpace(parts[0]) != "gzip" {
+				continue
+			}
+			q := 1.0
+			for _, p := range parts[1:] {
+				p = strings.TrimSpace(p)
+				if strings.HasPrefix(p, "q=") {
+					var err error
+					if q, err = strconv.ParseFloat(p[2:], 64); err != nil {
+						q = 0
+					}
+				}
+			}
+			return q > 0
+		}
+	}
+	return false
+}
@@ -0,0 +1,144 @@
+// Copyright 2015 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package etcdhttp
+
+import (
+	"compress/gzip"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/coreos/etcd/etcdserver"
+	"github.com/coreos/etcd/store"
+)
+
+func TestAcceptsGzip(t *testing.T) {
+	tests := []struct {
+		header string
+		w      bool
+	}{
+		{"", false},
+		{"gzip", true},
+		{"deflate, gzip", true},
+		{"gzip;q=0.5, identity", true},
+		{"gzip; q=0", false},
+		{"gzip;q=bad", false},
+		{"deflate", false},
+		{"x-gzip", false},
+	}
+	for i, tt := range tests {
+		req, err := http.NewRequest("GET", "/v2/keys/foo", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if tt.header != "" {
+			req.Header.Set("Accept-Encoding", tt.header)
+		}
+		if g := acceptsGzip(req); g != tt.w {
+			t.Errorf("#%d: acceptsGzip(%q) = %v, want %v", i, tt.header, g, tt.w)
+		}
+	}
+}
+
+func TestGzipResponseWriter(t *testing.T) {
+	small := "small body"
+	large := strings.Repeat("a large body ", minGzipSize)
+	tests := []struct {
+		writes []string
+		wgzip  bool
+	}{
+		{nil, false},
+		{[]string{small}, false},
+		{[]string{large}, true},
+		// compressed from the write that reaches the threshold
+		{[]string{small, large, small}, true},
+	}
+	for i, tt := range tests {
+		rw := httptest.NewRecorder()
+		gw := newGzipResponseWriter(rw)
+		gw.WriteHeader(http.StatusCreated)
+		for _, s := range tt.writes {
+			if n, err := gw.Write([]byte(s)); n != len(s) || err != nil {
+				t.Fatalf("#%d: write = %d, %v, want %d, nil", i, n, err, len(s))
+			}
+		}
+		if err := gw.Close(); err != nil {
+			t.Fatalf("#%d: close error: %v", i, err)
+		}
+
+		if rw.Code != http.StatusCreated {
+			t.Errorf("#%d: code = %d, want %d", i, rw.Code, http.StatusCreated)
+		}
+		if g := rw.Header().Get("Vary"); g != "Accept-Encoding" {
+			t.Errorf("#%d: vary = %q, want %q", i, g, "Accept-Encoding")
+		}
+		gzipped := rw.Header().Get("Content-Encoding") == "gzip"
+		if gzipped != tt.wgzip {
+			t.Errorf("#%d: gzipped = %v, want %v", i, gzipped, tt.wgzip)
+		}
+		body := rw.Body.Bytes()
+		if gzipped {
+			gz, err := gzip.NewReader(rw.Body)
+			if err != nil {
+				t.Fatalf("#%d: %v", i, err)
+			}
+			if body, err = ioutil.ReadAll(gz); err != nil {
+				t.Fatalf("#%d: %v", i, err)
+			}
+		}
+		if g, w := string(body), strings.Join(tt.writes, ""); g != w {
+			t.Errorf("#%d: body length = %d, want %d", i, len(g), len(w))
+		}
+	}
+}
+
+// Ensure that a large get is compressed only if the client accepts it.
+func TestServeKeysGzip(t *testing.T) {
+	v := strings.Repeat("v", 2*minGzipSize)
+	server := &resServer{
+		etcdserver.Response{
+			Event: &store.Event{
+				Action: store.Get,
+				Node:   &store.NodeExtern{Key: "/foo", Value: &v},
+			},
+		},
+	}
+	h := &keysHandler{
+		timeout:     time.Hour,
+		server:      server,
+		clusterInfo: &fakeCluster{id: 1},
+		timer:       &dummyRaftTimer{},
+	}
+	for i, accept := range []string{"", "gzip"} {
+		req := mustNewRequest(t, "foo")
+		req.Header = http.Header{}
+		if accept != "" {
+			req.Header.Set("Accept-Encoding", accept)
+		}
+		rw := httptest.NewRecorder()
+		h.ServeHTTP(rw, req)
+
+		if rw.Code != http.StatusOK {
+			t.Errorf("#%d: code = %d, want %d", i, rw.Code, http.StatusOK)
+		}
+		wgzip := accept != ""
+		if g := rw.Header().Get("Content-Encoding") == "gzip"; g != wgzip {
+			t.Errorf("#%d: gzipped = %v, want %v", i, g, wgzip)
+		}
+	}
+}
@@ -19,10 +19,12 @@ import (
 	"log"
 	"math"
 	"net/http"
+	"strconv"
 	"strings"
 	"time"
 
 	etcdErr "github.com/coreos/etcd/error"
+	"github.com/coreos/etcd/etcdserver"
 	"github.com/coreos/etcd/etcdserver/etcdhttp/httptypes"
 	"github.com/coreos/etcd/etcdserver/security"
 )
@@ -36,10 +38,51 @@ const (
 
 	// time to wait for a Watch request
 	defaultWatchTimeout = time.Duration(math.MaxInt64)
+
+	// the longest timeout a client may give for a request
+	maxRequestTimeout = time.Minute
 )
 
 var errClosed = errors.New("etcdhttp: client closed connection")
 
+// serverError tells how an error of the server is written to the client.
+type serverError struct {
+	code      int
+	reason    string
+	retryable bool
+}
+
+// serverErrors maps the errors of the server to their status codes, their
+// reasons and whether the requests may be retried.
+// 服务端错误对应的状态码、reason以及是否可以重试
+var serverErrors = map[error]serverError{
+	// a timed-out write may still be applied, and the retries would load
+	// the cluster further
+	etcdserver.ErrTimeout: {http.StatusInternalServerError, httptypes.ReasonTimeout, false},
+	// the proposal is likely lost with the leader, and a new leader is
+	// expected to take the retry
+	etcdserver.ErrTimeoutDueToLeaderFail: {http.StatusInternalServerError, httptypes.ReasonLeaderLost, true},
+	etcdserver.ErrCanceled:               {http.StatusInternalServerError, httptypes.ReasonCanceled, false},
+	// another member may serve the retry
+	etcdserver.ErrStopped:         {http.StatusInternalServerError, httptypes.ReasonStopped, true},
+	etcdserver.ErrNoSpace:         {http.StatusInsufficientStorage, httptypes.ReasonNoSpace, false},
+	etcdserver.ErrCorrupt:         {http.StatusServiceUnavailable, httptypes.ReasonCorrupt, false},
+	etcdserver.ErrValueTooLarge:   {http.StatusRequestEntityTooLarge, httptypes.ReasonTooLarge, false},
+	etcdserver.ErrRequestTooLarge: {http.StatusRequestEntityTooLarge, httptypes.ReasonTooLarge, false},
+	// another member may be fresh enough to serve the read
+	etcdserver.ErrTooStale: {http.StatusServiceUnavailable, httptypes.ReasonTooStale, true},
+	// another member may have a leader to serve the retry
+	etcdserver.ErrProposalDropped: {http.StatusServiceUnavailable, httptypes.ReasonProposalDropped, true},
+	// the proposals in flight are expected to drain quickly
+	etcdserver.ErrTooManyRequests: {http.StatusTooManyRequests, httptypes.ReasonTooManyRequests, true},
+	// another member may have enough disk space to serve the retry
+	etcdserver.ErrLowDiskSpace: {http.StatusInsufficientStorage, httptypes.ReasonLowDisk, true},
+	// another member may have the file descriptors to serve the watch
+	etcdserver.ErrTooManyOpenFiles: {http.StatusServiceUnavailable, httptypes.ReasonTooManyOpenFiles, true},
+	// the request is supported once all the members are upgraded
+	etcdserver.ErrNotCapable: {http.StatusServiceUnavailable, httptypes.ReasonNotCapable, true},
+}
+
 // writeError logs and writes the given Error to the ResponseWriter
 // If Error is an etcdErr, it is rendered to the ResponseWriter
 // Otherwise, it is assumed to be an InternalServerError
@@ -47,6 +90,18 @@ func writeError(w http.ResponseWriter, err error) {
 	if err == nil {
 		return
 	}
+	if se, ok := serverErrors[err]; ok {
+		if err == etcdserver.ErrTooManyRequests {
+			w.Header().Set("Retry-After", "1")
+		}
+		herr := httptypes.NewHTTPError(se.code, err.Error())
+		herr.Reason = se.reason
+		herr.Retryable = se.retryable
+		// the raft index the member had applied when the request failed
+		herr.Index, _ = strconv.ParseUint(w.Header().Get("X-Raft-Index"), 10, 64)
+		herr.WriteTo(w)
+		return
+	}
 	switch e := err.(type) {
 	case *etcdErr.Error:
 		e.WriteTo(w)
@@ -15,6 +15,7 @@
 package etcdhttp
 
 import (
+	"encoding/json"
 	"errors"
 	"net/http"
 	"net/http/httptest"
@@ -24,6 +25,7 @@ import (
 	"github.com/coreos/etcd/Godeps/_workspace/src/golang.org/x/net/context"
 	etcdErr "github.com/coreos/etcd/error"
 	"github.com/coreos/etcd/etcdserver"
+	"github.com/coreos/etcd/etcdserver/etcdhttp/httptypes"
 	"github.com/coreos/etcd/etcdserver/etcdserverpb"
 	"github.com/coreos/etcd/pkg/types"
 	"github.com/coreos/etcd/raft/raftpb"
@@ -33,6 +35,7 @@ type fakeCluster struct {
 	id         uint64
 	clientURLs []string
 	members    map[uint64]*etcdserver.Member
+	version    string
 }
 
 func (c *fakeCluster) ID() types.ID         { return types.ID(c.id) }
@@ -47,6 +50,7 @@ func (c *fakeCluster) Members() []*etcdserver.Member {
 }
 func (c *fakeCluster) Member(id types.ID) *etcdserver.Member { return c.members[uint64(id)] }
 func (c *fakeCluster) IsIDRemoved(id types.ID) bool          { return false }
+func (c *fakeCluster) Version() string                       { return c.version }
 
 // errServer implements the etcd.Server interface for testing.
 // It returns the given error from any Do/Process/AddMember/RemoveMember calls.
@@ -73,6 +77,9 @@ func (fs *errServer) RemoveMember(ctx context.Context, id uint64) error {
 func (fs *errServer) UpdateMember(ctx context.Context, m etcdserver.Member) error {
 	return fs.err
 }
+func (fs *errServer) TransferLeadership(ctx context.Context, id types.ID) error {
+	return fs.err
+}
 
 func TestWriteError(t *testing.T) {
 	// nil error should not panic
@@ -106,6 +113,30 @@ func TestWriteError(t *testing.T) {
 			err:   errors.New("something went wrong"),
 			wcode: http.StatusInternalServerError,
 		},
+		{
+			err:   etcdserver.ErrNoSpace,
+			wcode: http.StatusInsufficientStorage,
+		},
+		{
+			err:   etcdserver.ErrTooManyRequests,
+			wcode: http.StatusTooManyRequests,
+		},
+		{
+			err:   etcdserver.ErrCorrupt,
+			wcode: http.StatusServiceUnavailable,
+		},
+		{
+			err:   etcdserver.ErrProposalDropped,
+			wcode: http.StatusServiceUnavailable,
+		},
+		{
+			err:   etcdserver.ErrValueTooLarge,
+			wcode: http.StatusRequestEntityTooLarge,
+		},
+		{
+			err:   etcdserver.ErrRequestTooLarge,
+			wcode: http.StatusRequestEntityTooLarge,
+		},
 	}
 
 	for i, tt := range tests {
@@ -120,6 +151,51 @@ func TestWriteError(t *testing.T) {
 	}
 }
 
+func TestWriteServerError(t *testing.T) {
+	tests := []struct {
+		err        error
+		wcode      int
+		wreason    string
+		wretryable bool
+	}{
+		{etcdserver.ErrTimeout, http.StatusInternalServerError, httptypes.ReasonTimeout, false},
+		{etcdserver.ErrTimeoutDueToLeaderFail, http.StatusInternalServerError, httptypes.ReasonLeaderLost, true},
+		{etcdserver.ErrCanceled, http.StatusInternalServerError, httptypes.ReasonCanceled, false},
+		{etcdserver.ErrStopped, http.StatusInternalServerError, httptypes.ReasonStopped, true},
+		{etcdserver.ErrNoSpace, http.StatusInsufficientStorage, httptypes.ReasonNoSpace, false},
+		{etcdserver.ErrTooStale, http.StatusServiceUnavailable, httptypes.ReasonTooStale, true},
+		{etcdserver.ErrProposalDropped, http.StatusServiceUnavailable, httptypes.ReasonProposalDropped, true},
+		{etcdserver.ErrTooManyRequests, http.StatusTooManyRequests, httptypes.ReasonTooManyRequests, true},
+		{etcdserver.ErrLowDiskSpace, http.StatusInsufficientStorage, httptypes.ReasonLowDisk, true},
+		{etcdserver.ErrTooManyOpenFiles, http.StatusServiceUnavailable, httptypes.ReasonTooManyOpenFiles, true},
+		{etcdserver.ErrNotCapable, http.StatusServiceUnavailable, httptypes.ReasonNotCapable, true},
+		// not a server error
+		{errors.New("something went wrong"), http.StatusInternalServerError, "", false},
+	}
+	for i, tt := range tests {
+		rw := httptest.NewRecorder()
+		rw.Header().Set("X-Raft-Index", "7")
+		writeError(rw, tt.err)
+		if rw.Code != tt.wcode {
+			t.Errorf("#%d: code = %d, want %d", i, rw.Code, tt.wcode)
+		}
+		var herr httptypes.HTTPError
+		if err := json.Unmarshal(rw.Body.Bytes(), &herr); err != nil {
+			t.Fatalf("#%d: %v", i, err)
+		}
+		if herr.Reason != tt.wreason || herr.Retryable != tt.wretryable {
+			t.Errorf("#%d: reason, retryable = %q, %v, want %q, %v", i, herr.Reason, herr.Retryable, tt.wreason, tt.wretryable)
+		}
+		windex := uint64(0)
+		if tt.wreason != "" {
+			windex = 7
+		}
+		if herr.Index != windex {
+			t.Errorf("#%d: index = %d, want %d", i, herr.Index, windex)
+		}
+	}
+}
+
 func TestAllowMethod(t *testing.T) {
 	tests := []struct {
 		m  string
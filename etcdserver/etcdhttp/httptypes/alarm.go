@@ -0,0 +1,34 @@
+// Copyright 2015 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package httptypes
+
+import "encoding/json"
+
+type Alarm struct {
+	MemberID string `json:"memberID"`
+	Alarm    string `json:"alarm"`
+}
+
+type AlarmCollection []Alarm
+
+func (c *AlarmCollection) MarshalJSON() ([]byte, error) {
+	d := struct {
+		Alarms []Alarm `json:"alarms"`
+	}{
+		Alarms: []Alarm(*c),
+	}
+
+	return json.Marshal(d)
+}
@@ -0,0 +1,35 @@
+// Copyright 2015 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package httptypes
+
+import "encoding/json"
+
+type DirQuota struct {
+	Dir      string `json:"dir"`
+	MaxKeys  int64  `json:"maxKeys,omitempty"`
+	MaxBytes int64  `json:"maxBytes,omitempty"`
+}
+
+type DirQuotaCollection []DirQuota
+
+func (c *DirQuotaCollection) MarshalJSON() ([]byte, error) {
+	d := struct {
+		Quotas []DirQuota `json:"quotas"`
+	}{
+		Quotas: []DirQuota(*c),
+	}
+
+	return json.Marshal(d)
+}
@@ -20,8 +20,58 @@ import (
 	"net/http"
 )
 
+// The reasons of the server errors. They are kept stable across the
+// versions, so that the clients can tell the errors apart without parsing
+// the messages.
+const (
+	// ReasonTimeout: the request timed out, e.g. as the cluster is
+	// overloaded. A timed-out write may still be applied.
+	ReasonTimeout = "TIMEOUT"
+	// ReasonLeaderLost: the request timed out after the leader was lost.
+	// A timed-out write may still be applied.
+	ReasonLeaderLost = "LEADER_LOST"
+	// ReasonCanceled: the request was canceled.
+	ReasonCanceled = "CANCELED"
+	// ReasonStopped: the member is stopping.
+	ReasonStopped = "STOPPED"
+	// ReasonNoSpace: the NOSPACE alarm is active.
+	ReasonNoSpace = "NO_SPACE"
+	// ReasonLowDisk: the member is low on disk space.
+	ReasonLowDisk = "LOW_DISK"
+	// ReasonCorrupt: the CORRUPT alarm is active.
+	ReasonCorrupt = "CORRUPT"
+	// ReasonTooLarge: the value or the request is too large.
+	ReasonTooLarge = "TOO_LARGE"
+	// ReasonTooStale: the member lags too far behind the leader to serve
+	// a bounded stale read.
+	ReasonTooStale = "TOO_STALE"
+	// ReasonProposalDropped: raft dropped the proposal, e.g. as there is
+	// no leader.
+	ReasonProposalDropped = "PROPOSAL_DROPPED"
+	// ReasonTooManyRequests: too many proposals are in flight.
+	ReasonTooManyRequests = "TOO_MANY_REQUESTS"
+	// ReasonTooManyOpenFiles: the member uses too many file descriptors
+	// to serve a new watch.
+	ReasonTooManyOpenFiles = "TOO_MANY_OPEN_FILES"
+	// ReasonForwardFailed: the follower failed to forward the write to
+	// the leader.
+	ReasonForwardFailed = "FORWARD_FAILED"
+	// ReasonNotCapable: the cluster version does not support the request
+	// yet, as some members are older.
+	ReasonNotCapable = "NOT_CAPABLE"
+)
+
 type HTTPError struct {
 	Message string `json:"message"`
+	// Reason is the stable reason of a server error, one of the Reason
+	// constants, or empty for the other errors.
+	Reason string `json:"reason,omitempty"`
+	// Index is the raft index the member had applied when the request
+	// failed.
+	Index uint64 `json:"index,omitempty"`
+	// Retryable is true if the same request may succeed when retried,
+	// later or on another member.
+	Retryable bool `json:"retryable,omitempty"`
 	// HTTP return code
 	Code int `json:"-"`
 }
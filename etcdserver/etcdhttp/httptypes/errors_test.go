@@ -45,3 +45,17 @@ func TestHTTPErrorWriteTo(t *testing.T) {
 		t.Errorf("HTTP body %q, want %q", gbody, wbody)
 	}
 }
+
+func TestHTTPErrorWriteToReason(t *testing.T) {
+	err := NewHTTPError(http.StatusInternalServerError, "etcdserver: request timed out")
+	err.Reason = ReasonLeaderLost
+	err.Index = 12
+	err.Retryable = true
+	rr := httptest.NewRecorder()
+	err.WriteTo(rr)
+
+	wbody := `{"message":"etcdserver: request timed out","reason":"LEADER_LOST","index":12,"retryable":true}`
+	if gbody := rr.Body.String(); gbody != wbody {
+		t.Errorf("HTTP body %q, want %q", gbody, wbody)
+	}
+}
@@ -25,6 +25,24 @@ type Member struct {
 	Name       string   `json:"name"`
 	PeerURLs   []string `json:"peerURLs"`
 	ClientURLs []string `json:"clientURLs"`
+	// Version is the etcd version the member published when it started.
+	Version string `json:"version,omitempty"`
+	// Labels are the labels the member published when it started.
+	Labels map[string]string `json:"labels,omitempty"`
+	// Status is only set in the list of the members.
+	Status *MemberStatus `json:"status,omitempty"`
+}
+
+// MemberStatus is the status of a member as seen from the member that
+// lists the members.
+type MemberStatus struct {
+	IsLeader bool `json:"isLeader"`
+	// Reachable tells whether the listing member can reach the member.
+	Reachable bool `json:"reachable"`
+	// Lag is how many committed entries the listing member has not
+	// applied yet, or, as seen from the leader, the member has not
+	// replicated yet. It is unknown to a follower for the other members.
+	Lag *uint64 `json:"lag,omitempty"`
 }
 
 type MemberCreateRequest struct {
@@ -54,6 +72,29 @@ func (m *MemberCreateRequest) UnmarshalJSON(data []byte) error {
 	return nil
 }
 
+type LeaderTransferRequest struct {
+	ID types.ID
+}
+
+func (l *LeaderTransferRequest) UnmarshalJSON(data []byte) error {
+	s := struct {
+		ID string `json:"id"`
+	}{}
+
+	err := json.Unmarshal(data, &s)
+	if err != nil {
+		return err
+	}
+
+	id, err := types.IDFromString(s.ID)
+	if err != nil {
+		return err
+	}
+
+	l.ID = id
+	return nil
+}
+
 type MemberCollection []Member
 
 func (c *MemberCollection) MarshalJSON() ([]byte, error) {
@@ -65,3 +106,16 @@ func (c *MemberCollection) MarshalJSON() ([]byte, error) {
 
 	return json.Marshal(d)
 }
+
+func (c *MemberCollection) UnmarshalJSON(data []byte) error {
+	d := struct {
+		Members []Member `json:"members"`
+	}{}
+
+	if err := json.Unmarshal(data, &d); err != nil {
+		return err
+	}
+
+	*c = MemberCollection(d.Members)
+	return nil
+}
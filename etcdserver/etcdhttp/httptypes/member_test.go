@@ -93,6 +93,27 @@ func TestMemberUnmarshal(t *testing.T) {
 	}
 }
 
+func TestMemberCollectionUnmarshal(t *testing.T) {
+	body := []byte(`{"members":[{"id":"c","name":"node1","peerURLs":["http://127.0.0.1:2380"],"clientURLs":["http://127.0.0.1:2379"]}]}`)
+	want := MemberCollection{
+		Member{
+			ID:         "c",
+			Name:       "node1",
+			PeerURLs:   []string{"http://127.0.0.1:2380"},
+			ClientURLs: []string{"http://127.0.0.1:2379"},
+		},
+	}
+
+	var got MemberCollection
+	if err := json.Unmarshal(body, &got); err != nil {
+		t.Fatalf("Unmarshal returned unexpected err=%v", err)
+	}
+
+	if !reflect.DeepEqual(want, got) {
+		t.Fatalf("Failed to unmarshal MemberCollection: want=%#v, got=%#v", want, got)
+	}
+}
+
 func TestMemberCreateRequestUnmarshal(t *testing.T) {
 	body := []byte(`{"peerURLs": ["http://127.0.0.1:8081", "https://127.0.0.1:8080"]}`)
 	want := MemberCreateRequest{
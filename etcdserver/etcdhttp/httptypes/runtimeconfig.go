@@ -0,0 +1,37 @@
+// Copyright 2015 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package httptypes
+
+import "encoding/json"
+
+type RuntimeConfig struct {
+	MemberID               string  `json:"memberID"`
+	SnapCount              *uint64 `json:"snapCount,omitempty"`
+	SyncIntervalMs         *int64  `json:"syncIntervalMs,omitempty"`
+	SlowRequestThresholdMs *int64  `json:"slowRequestThresholdMs,omitempty"`
+	LogLevel               string  `json:"logLevel,omitempty"`
+}
+
+type RuntimeConfigCollection []RuntimeConfig
+
+func (c *RuntimeConfigCollection) MarshalJSON() ([]byte, error) {
+	d := struct {
+		Configs []RuntimeConfig `json:"configs"`
+	}{
+		Configs: []RuntimeConfig(*c),
+	}
+
+	return json.Marshal(d)
+}
@@ -0,0 +1,45 @@
+// Copyright 2015 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package httptypes
+
+import "encoding/json"
+
+// Watch is one of the watches of a multiplexed watch stream. The events
+// of the watch are tagged with its ID.
+type Watch struct {
+	ID          string   `json:"id"`
+	Key         string   `json:"key"`
+	Recursive   bool     `json:"recursive,omitempty"`
+	WaitIndex   uint64   `json:"waitIndex,omitempty"`
+	WaitActions []string `json:"waitActions,omitempty"`
+}
+
+type WatchRequest struct {
+	Watches []Watch `json:"watches"`
+}
+
+func (r *WatchRequest) UnmarshalJSON(data []byte) error {
+	s := struct {
+		Watches []Watch `json:"watches"`
+	}{}
+
+	err := json.Unmarshal(data, &s)
+	if err != nil {
+		return err
+	}
+
+	r.Watches = s.Watches
+	return nil
+}
@@ -21,26 +21,33 @@ import (
 	"strconv"
 
 	"github.com/coreos/etcd/etcdserver"
+	"github.com/coreos/etcd/etcdserver/etcdhttp/httptypes"
 	"github.com/coreos/etcd/rafthttp"
 )
 
 const (
 	peerMembersPrefix = "/members"
+	peerHashPath      = "/hash"
 )
 
 // NewPeerHandler generates an http.Handler to handle etcd peer (raft) requests.
-func NewPeerHandler(clusterInfo etcdserver.ClusterInfo, timer etcdserver.RaftTimer, raftHandler http.Handler) http.Handler {
+func NewPeerHandler(clusterInfo etcdserver.ClusterInfo, timer etcdserver.RaftTimer, hasher etcdserver.Hasher, raftHandler http.Handler) http.Handler {
 	mh := &peerMembersHandler{
 		clusterInfo: clusterInfo,
 		timer:       timer,
 	}
+	hh := &peerHashHandler{
+		clusterInfo: clusterInfo,
+		hasher:      hasher,
+	}
 
 	mux := http.NewServeMux()
 	mux.HandleFunc("/", http.NotFound)
 	mux.Handle(rafthttp.RaftPrefix, raftHandler)
 	mux.Handle(rafthttp.RaftPrefix+"/", raftHandler)
 	mux.Handle(peerMembersPrefix, mh)
-	mux.HandleFunc(versionPath, serveVersion)
+	mux.Handle(peerHashPath, hh)
+	mux.HandleFunc(versionPath, versionHandler(clusterInfo))
 	return mux
 }
 
@@ -66,3 +73,40 @@ func (h *peerMembersHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		log.Printf("etcdhttp: %v", err)
 	}
 }
+
+type peerHashHandler struct {
+	clusterInfo etcdserver.ClusterInfo
+	hasher      etcdserver.Hasher
+}
+
+// 返回本member在给定index计算的store hash，供leader比较
+func (h *peerHashHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if !allowMethod(w, r.Method, "GET") {
+		return
+	}
+	w.Header().Set("X-Etcd-Cluster-ID", h.clusterInfo.ID().String())
+	serveStoreHash(w, r, h.hasher)
+}
+
+// serveStoreHash writes the store hash at the index given by the index
+// query parameter, or the latest one if the parameter is absent.
+func serveStoreHash(w http.ResponseWriter, r *http.Request, hasher etcdserver.Hasher) {
+	var index uint64
+	if v := r.FormValue("index"); v != "" {
+		var err error
+		index, err = strconv.ParseUint(v, 10, 64)
+		if err != nil {
+			writeError(w, httptypes.NewHTTPError(http.StatusBadRequest, "invalid index"))
+			return
+		}
+	}
+	h, ok := hasher.StoreHash(index)
+	if !ok {
+		writeError(w, httptypes.NewHTTPError(http.StatusNotFound, "store hash not found"))
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(httptypes.HashResponse{Index: h.Index, Hash: h.Hash}); err != nil {
+		log.Printf("etcdhttp: %v", err)
+	}
+}
@@ -33,7 +33,7 @@ func TestNewPeerHandlerOnRaftPrefix(t *testing.T) {
 	h := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		w.Write([]byte("test data"))
 	})
-	ph := NewPeerHandler(&fakeCluster{}, &dummyRaftTimer{}, h)
+	ph := NewPeerHandler(&fakeCluster{}, &dummyRaftTimer{}, &fakeMaintainer{}, h)
 	srv := httptest.NewServer(ph)
 	defer srv.Close()
 
@@ -132,3 +132,38 @@ func TestServeMembersGet(t *testing.T) {
 		}
 	}
 }
+
+func TestServePeerHash(t *testing.T) {
+	hasher := &fakeMaintainer{
+		hashes: []etcdserver.StoreHash{{Index: 1, Hash: 10}, {Index: 2, Hash: 20}},
+	}
+	tests := []struct {
+		method string
+		query  string
+
+		wcode int
+		wbody string
+	}{
+		{"GET", "", http.StatusOK, `{"index":2,"hash":20}` + "\n"},
+		{"GET", "?index=1", http.StatusOK, `{"index":1,"hash":10}` + "\n"},
+		{"GET", "?index=3", http.StatusNotFound, ""},
+		{"GET", "?index=bad", http.StatusBadRequest, ""},
+		{"POST", "", http.StatusMethodNotAllowed, ""},
+	}
+	for i, tt := range tests {
+		h := &peerHashHandler{clusterInfo: &fakeCluster{id: 1}, hasher: hasher}
+		req, err := http.NewRequest(tt.method, testutil.MustNewURL(t, peerHashPath+tt.query).String(), nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		rw := httptest.NewRecorder()
+		h.ServeHTTP(rw, req)
+
+		if rw.Code != tt.wcode {
+			t.Errorf("#%d: code = %d, want %d", i, rw.Code, tt.wcode)
+		}
+		if tt.wbody != "" && rw.Body.String() != tt.wbody {
+			t.Errorf("#%d: body = %q, want %q", i, rw.Body.String(), tt.wbody)
+		}
+	}
+}
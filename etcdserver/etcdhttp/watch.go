@@ -0,0 +1,307 @@
+// Copyright 2015 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package etcdhttp
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"path"
+	"reflect"
+	"sort"
+
+	"github.com/coreos/etcd/Godeps/_workspace/src/golang.org/x/net/context"
+	etcdErr "github.com/coreos/etcd/error"
+	"github.com/coreos/etcd/etcdserver"
+	"github.com/coreos/etcd/etcdserver/etcdhttp/httptypes"
+	"github.com/coreos/etcd/etcdserver/etcdserverpb"
+	"github.com/coreos/etcd/etcdserver/security"
+	"github.com/coreos/etcd/store"
+)
+
+// maxWatchesPerStream is the max number of the watches of one watch
+// stream.
+const maxWatchesPerStream = 1000
+
+// watchHandler serves the multiplexed watch streams: a client registers
+// many watches with one request, and receives the events of all of them
+// on one connection, in the order they happen in the store.
+// 在一个连接上复用多个watch，按index顺序推送事件
+type watchHandler struct {
+	sec                   *security.Store
+	server                etcdserver.Server
+	clusterInfo           etcdserver.ClusterInfo
+	timer                 etcdserver.RaftTimer
+	clientCertAuthEnabled bool
+}
+
+func (h *watchHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if !allowMethod(w, r.Method, "POST") {
+		return
+	}
+	w.Header().Set("X-Etcd-Cluster-ID", h.clusterInfo.ID().String())
+
+	req := httptypes.WatchRequest{}
+	if ok := unmarshalRequest(r, &req, w); !ok {
+		return
+	}
+	if err := checkWatches(req.Watches); err != nil {
+		writeError(w, err)
+		return
+	}
+	for _, wt := range req.Watches {
+		if !hasKeyPrefixAccess(h.sec, r, path.Join("/", wt.Key), h.clientCertAuthEnabled) {
+			writeNoAuth(w)
+			return
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), defaultWatchTimeout)
+	defer cancel()
+	mw := &multiWatch{server: h.server, watches: req.Watches}
+	defer mw.remove()
+	for i := range mw.watches {
+		if err := mw.watch(ctx, i, mw.watches[i].WaitIndex); err != nil {
+			err = trimErrorPrefix(err, etcdserver.StoreKeysPrefix)
+			writeError(w, err)
+			return
+		}
+	}
+	mw.serve(ctx, w, h.timer)
+}
+
+func checkWatches(ws []httptypes.Watch) error {
+	if len(ws) == 0 {
+		return httptypes.NewHTTPError(http.StatusBadRequest, "No watch given")
+	}
+	if len(ws) > maxWatchesPerStream {
+		return httptypes.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("More than %d watches given", maxWatchesPerStream))
+	}
+	ids := make(map[string]bool)
+	for _, wt := range ws {
+		if wt.ID == "" {
+			return httptypes.NewHTTPError(http.StatusBadRequest, "Watch with no id")
+		}
+		if ids[wt.ID] {
+			return httptypes.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("Duplicate watch id %q", wt.ID))
+		}
+		ids[wt.ID] = true
+		for _, a := range wt.WaitActions {
+			if !watchActions[a] {
+				return httptypes.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("Invalid action %q in watch %q", a, wt.ID))
+			}
+		}
+	}
+	return nil
+}
+
+// watchEvent is an event of a multiplexed watch stream, tagged with the
+// id of its watch.
+type watchEvent struct {
+	WatchID string `json:"watchId"`
+	*store.Event
+
+	watch int
+}
+
+// watchError ends a watch of a multiplexed watch stream that cannot go
+// on.
+type watchError struct {
+	WatchID string `json:"watchId"`
+	*etcdErr.Error
+}
+
+// watchEvents sort the events by index. The events of the same index
+// keep their order.
+type watchEvents []watchEvent
+
+func (es watchEvents) Len() int           { return len(es) }
+func (es watchEvents) Less(i, j int) bool { return es[i].Index() < es[j].Index() }
+func (es watchEvents) Swap(i, j int)      { es[i], es[j] = es[j], es[i] }
+
+// multiWatch holds the stream watchers of the watches of a stream.
+type multiWatch struct {
+	server   etcdserver.Server
+	watches  []httptypes.Watch
+	watchers []store.Watcher
+	// pending are the events received but not written yet.
+	pending watchEvents
+}
+
+// watch starts the watcher of the i-th watch from the given index.
+func (mw *multiWatch) watch(ctx context.Context, i int, since uint64) error {
+	wt := mw.watches[i]
+	resp, err := mw.server.Do(ctx, etcdserverpb.Request{
+		Method:    "GET",
+		Path:      path.Join(etcdserver.StoreKeysPrefix, wt.Key),
+		Wait:      true,
+		Stream:    true,
+		Recursive: wt.Recursive,
+		Since:     since,
+		Actions:   wt.WaitActions,
+	})
+	if err != nil {
+		return err
+	}
+	if resp.Watcher == nil {
+		return fmt.Errorf("received response with no Watcher")
+	}
+	if i < len(mw.watchers) {
+		mw.watchers[i] = resp.Watcher
+	} else {
+		mw.watchers = append(mw.watchers, resp.Watcher)
+	}
+	return nil
+}
+
+func (mw *multiWatch) remove() {
+	for _, wa := range mw.watchers {
+		if wa != nil {
+			wa.Remove()
+		}
+	}
+}
+
+// receive moves the events waiting on the channels of the watchers to
+// the pending events, without blocking. It returns false if a channel is
+// closed.
+func (mw *multiWatch) receive() bool {
+	for i, wa := range mw.watchers {
+		if wa == nil {
+			continue
+		}
+		ech := wa.EventChan()
+		for done := false; !done; {
+			select {
+			case ev, ok := <-ech:
+				if !ok {
+					return false
+				}
+				mw.pending = append(mw.pending, watchEvent{WatchID: mw.watches[i].ID, Event: ev, watch: i})
+			default:
+				done = true
+			}
+		}
+	}
+	return true
+}
+
+// serve writes the events of the watchers to w until the client closes
+// the connection, ctx is done or no watcher is left.
+//
+// The store notifies the watchers of an event before the watchers of the
+// next one, so once an event is received, the events before it are on
+// the channels already. The received events are written only after the
+// channels are drained again, so they are written in order.
+func (mw *multiWatch) serve(ctx context.Context, w http.ResponseWriter, rt etcdserver.RaftTimer) {
+	var nch <-chan bool
+	if x, ok := w.(http.CloseNotifier); ok {
+		nch = x.CloseNotify()
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("X-Etcd-Index", fmt.Sprint(mw.watchers[0].StartIndex()))
+	w.Header().Set("X-Raft-Index", fmt.Sprint(rt.Index()))
+	w.Header().Set("X-Raft-Term", fmt.Sprint(rt.Term()))
+	w.WriteHeader(http.StatusOK)
+	w.(http.Flusher).Flush()
+
+	enc := json.NewEncoder(w)
+	for {
+		if len(mw.pending) == 0 {
+			cases := []reflect.SelectCase{
+				{Dir: reflect.SelectRecv, Chan: reflect.ValueOf(nch)},
+				{Dir: reflect.SelectRecv, Chan: reflect.ValueOf(ctx.Done())},
+			}
+			var idx []int
+			for i, wa := range mw.watchers {
+				if wa != nil {
+					cases = append(cases, reflect.SelectCase{Dir: reflect.SelectRecv, Chan: reflect.ValueOf(wa.EventChan())})
+					idx = append(idx, i)
+				}
+			}
+			if len(idx) == 0 {
+				return
+			}
+			chosen, v, ok := reflect.Select(cases)
+			if chosen < 2 || !ok {
+				// the client closed the connection, the watch timed
+				// out, or a watcher is closed
+				return
+			}
+			i := idx[chosen-2]
+			mw.pending = append(mw.pending, watchEvent{WatchID: mw.watches[i].ID, Event: v.Interface().(*store.Event), watch: i})
+		}
+
+		var barrier uint64
+		for _, e := range mw.pending {
+			if e.Index() > barrier {
+				barrier = e.Index()
+			}
+		}
+		if !mw.receive() {
+			return
+		}
+		sort.Stable(mw.pending)
+		n := 0
+		for n < len(mw.pending) && mw.pending[n].Index() <= barrier {
+			n++
+		}
+		for _, e := range mw.pending[:n] {
+			if err := mw.write(ctx, enc, e); err != nil {
+				log.Printf("error writing event: %v\n", err)
+				return
+			}
+		}
+		mw.pending = mw.pending[n:]
+		w.(http.Flusher).Flush()
+	}
+}
+
+// write writes the event e, and restarts or ends its watch if the
+// watcher gets no more events.
+func (mw *multiWatch) write(ctx context.Context, enc *json.Encoder, e watchEvent) error {
+	wa := mw.watchers[e.watch]
+	if wa == nil {
+		// the watch ended with an earlier event
+		return nil
+	}
+	e.Event = trimEventPrefix(e.Event, etcdserver.StoreKeysPrefix)
+	if err := enc.Encode(e); err != nil {
+		return err
+	}
+	switch {
+	case e.Action == store.Overflow:
+		// the watcher is removed after an overflow event, and the client
+		// re-syncs the watch with a new stream
+		wa.Remove()
+		mw.watchers[e.watch] = nil
+	case e.Index() <= wa.StartIndex():
+		// an event found in the history is the only event of its
+		// watcher; the watch goes on from the next index
+		wa.Remove()
+		mw.watchers[e.watch] = nil
+		err := mw.watch(ctx, e.watch, e.Index()+1)
+		if ee, ok := err.(*etcdErr.Error); ok {
+			// e.g. the history is cleared meanwhile; the watch ends
+			// with the error
+			trimErrorPrefix(ee, etcdserver.StoreKeysPrefix)
+			return enc.Encode(watchError{WatchID: e.WatchID, Error: ee})
+		}
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,156 @@
+// Copyright 2015 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package etcdhttp
+
+import (
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/coreos/etcd/Godeps/_workspace/src/github.com/prometheus/client_golang/prometheus"
+	"github.com/coreos/etcd/etcdserver"
+	"github.com/coreos/etcd/etcdserver/etcdhttp/httptypes"
+)
+
+// DefaultMaxWatchConns is the default max number of the concurrent watch
+// connections of a client listener.
+const DefaultMaxWatchConns = 10000
+
+var (
+	watchConns = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "etcdhttp_watch_connections",
+		Help: "The number of the watch connections being served.",
+	})
+	watchConnsRejected = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "etcdhttp_watch_connections_rejected_total",
+		Help: "The total number of the watch connections rejected for too many watch connections.",
+	})
+	watchConnsShed = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "etcdhttp_watch_connections_shed_total",
+		Help: "The total number of the watch connections rejected for too many file descriptors used.",
+	})
+)
+
+func init() {
+	prometheus.MustRegister(watchConns)
+	prometheus.MustRegister(watchConnsRejected)
+	prometheus.MustRegister(watchConnsShed)
+}
+
+// WatchShedder tells whether the new watches should be shed.
+type WatchShedder interface {
+	// ShedWatches reports whether the new watches should be rejected,
+	// e.g. for too many file descriptors used.
+	ShedWatches() bool
+}
+
+// watchLimitHandler limits the concurrent watch connections served by
+// next, in total and per remote IP, so that a misbehaving client cannot
+// exhaust the file descriptors of the member.
+// 限制并发的watch连接数，超出时返回429
+type watchLimitHandler struct {
+	next          http.Handler
+	shedder       WatchShedder
+	maxConns      int
+	maxConnsPerIP int
+
+	mu         sync.Mutex
+	conns      int
+	connsPerIP map[string]int
+}
+
+// NewWatchLimitHandler returns a handler that serves the requests with
+// next, and rejects a watch with 429 when maxConns watches, or
+// maxConnsPerIP watches from the same remote IP, are being served. A
+// limit of zero or less means no limit. It also rejects all the new
+// watches with 503 while the shedder, if not nil, sheds them.
+// A handler is made for each listener, so the limits are per listener.
+func NewWatchLimitHandler(next http.Handler, shedder WatchShedder, maxConns, maxConnsPerIP int) http.Handler {
+	return &watchLimitHandler{
+		next:          next,
+		shedder:       shedder,
+		maxConns:      maxConns,
+		maxConnsPerIP: maxConnsPerIP,
+		connsPerIP:    make(map[string]int),
+	}
+}
+
+func (h *watchLimitHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if !isWatchRequest(r) {
+		h.next.ServeHTTP(w, r)
+		return
+	}
+	if h.shedder != nil && h.shedder.ShedWatches() {
+		watchConnsShed.Inc()
+		writeError(w, etcdserver.ErrTooManyOpenFiles)
+		return
+	}
+	ip := remoteIP(r)
+	if !h.acquire(ip) {
+		watchConnsRejected.Inc()
+		herr := httptypes.NewHTTPError(http.StatusTooManyRequests, "Too many watch connections")
+		herr.WriteTo(w)
+		return
+	}
+	defer h.release(ip)
+	h.next.ServeHTTP(w, r)
+}
+
+func (h *watchLimitHandler) acquire(ip string) bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.maxConns > 0 && h.conns >= h.maxConns {
+		return false
+	}
+	if h.maxConnsPerIP > 0 && h.connsPerIP[ip] >= h.maxConnsPerIP {
+		return false
+	}
+	h.conns++
+	h.connsPerIP[ip]++
+	watchConns.Inc()
+	return true
+}
+
+func (h *watchLimitHandler) release(ip string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.conns--
+	if h.connsPerIP[ip]--; h.connsPerIP[ip] == 0 {
+		delete(h.connsPerIP, ip)
+	}
+	watchConns.Dec()
+}
+
+// isWatchRequest returns true if r holds its connection until an event
+// happens: a GET of the keys with wait, or a watch stream.
+func isWatchRequest(r *http.Request) bool {
+	if r.URL.Path == watchPath {
+		return r.Method == "POST"
+	}
+	if r.Method != "GET" || (r.URL.Path != keysPrefix && !strings.HasPrefix(r.URL.Path, keysPrefix+"/")) {
+		return false
+	}
+	wait, err := getBool(r.URL.Query(), "wait")
+	return err == nil && wait
+}
+
+func remoteIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
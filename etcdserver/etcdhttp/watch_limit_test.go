@@ -0,0 +1,155 @@
+// Copyright 2015 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package etcdhttp
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestIsWatchRequest(t *testing.T) {
+	tests := []struct {
+		method string
+		url    string
+		w      bool
+	}{
+		{"GET", "/v2/keys/foo?wait=true", true},
+		{"GET", "/v2/keys?wait=1&recursive=true", true},
+		{"POST", "/v2/watch", true},
+		{"GET", "/v2/keys/foo", false},
+		{"GET", "/v2/keys/foo?wait=false", false},
+		{"PUT", "/v2/keys/foo?wait=true", false},
+		{"GET", "/v2/keysfoo?wait=true", false},
+		{"GET", "/v2/watch", false},
+		{"GET", "/v2/members?wait=true", false},
+	}
+	for i, tt := range tests {
+		req, err := http.NewRequest(tt.method, tt.url, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if g := isWatchRequest(req); g != tt.w {
+			t.Errorf("#%d: isWatchRequest = %v, want %v", i, g, tt.w)
+		}
+	}
+}
+
+// Ensure that the watches beyond the limits are rejected with 429, while
+// the other requests are served, and that a finished watch frees its
+// slot.
+func TestWatchLimitHandler(t *testing.T) {
+	block := make(chan struct{})
+	started := make(chan struct{}, 10)
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if isWatchRequest(r) {
+			started <- struct{}{}
+			<-block
+		}
+	})
+	h := NewWatchLimitHandler(next, nil, 3, 2)
+
+	serve := func(method, url, addr string) int {
+		req, err := http.NewRequest(method, url, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.RemoteAddr = addr
+		rw := httptest.NewRecorder()
+		h.ServeHTTP(rw, req)
+		return rw.Code
+	}
+	wait := func() {
+		select {
+		case <-started:
+		case <-time.After(time.Second):
+			t.Fatalf("timed out waiting for the watch to start")
+		}
+	}
+
+	done := make(chan int, 10)
+	for _, addr := range []string{"10.0.0.1:1000", "10.0.0.1:1001", "10.0.0.2:1000"} {
+		go func(addr string) { done <- serve("GET", "/v2/keys/foo?wait=true", addr) }(addr)
+		wait()
+	}
+
+	tests := []struct {
+		method string
+		url    string
+		addr   string
+		wcode  int
+	}{
+		// the limit per IP
+		{"GET", "/v2/keys/foo?wait=true", "10.0.0.1:1002", http.StatusTooManyRequests},
+		// the limit of the listener
+		{"POST", "/v2/watch", "10.0.0.3:1000", http.StatusTooManyRequests},
+		// not a watch
+		{"GET", "/v2/keys/foo", "10.0.0.1:1003", http.StatusOK},
+	}
+	for i, tt := range tests {
+		if g := serve(tt.method, tt.url, tt.addr); g != tt.wcode {
+			t.Errorf("#%d: code = %d, want %d", i, g, tt.wcode)
+		}
+	}
+
+	close(block)
+	for i := 0; i < 3; i++ {
+		if g := <-done; g != http.StatusOK {
+			t.Errorf("code = %d, want %d", g, http.StatusOK)
+		}
+	}
+	go func() { done <- serve("GET", "/v2/keys/foo?wait=true", "10.0.0.1:1004") }()
+	wait()
+	if g := <-done; g != http.StatusOK {
+		t.Errorf("code = %d, want %d", g, http.StatusOK)
+	}
+}
+
+type fakeWatchShedder struct{ shed bool }
+
+func (s *fakeWatchShedder) ShedWatches() bool { return s.shed }
+
+// Ensure that the new watches are rejected with 503 while the shedder
+// sheds them, and that the other requests are served.
+func TestWatchLimitHandlerShed(t *testing.T) {
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+	shedder := &fakeWatchShedder{shed: true}
+	h := NewWatchLimitHandler(next, shedder, 0, 0)
+
+	tests := []struct {
+		shed   bool
+		method string
+		url    string
+		wcode  int
+	}{
+		{true, "GET", "/v2/keys/foo?wait=true", http.StatusServiceUnavailable},
+		{true, "POST", "/v2/watch", http.StatusServiceUnavailable},
+		{true, "GET", "/v2/keys/foo", http.StatusOK},
+		{false, "GET", "/v2/keys/foo?wait=true", http.StatusOK},
+	}
+	for i, tt := range tests {
+		shedder.shed = tt.shed
+		req, err := http.NewRequest(tt.method, tt.url, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		rw := httptest.NewRecorder()
+		h.ServeHTTP(rw, req)
+		if rw.Code != tt.wcode {
+			t.Errorf("#%d: code = %d, want %d", i, rw.Code, tt.wcode)
+		}
+	}
+}
@@ -0,0 +1,151 @@
+// Copyright 2015 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package etcdhttp
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/coreos/etcd/Godeps/_workspace/src/golang.org/x/net/context"
+	"github.com/coreos/etcd/etcdserver"
+	"github.com/coreos/etcd/etcdserver/etcdhttp/httptypes"
+	"github.com/coreos/etcd/store"
+)
+
+func TestWatchHandlerBadRequest(t *testing.T) {
+	tests := []struct {
+		method string
+		ctype  string
+		body   string
+		wcode  int
+	}{
+		{"GET", "application/json", `{"watches":[{"id":"a","key":"/foo"}]}`, http.StatusMethodNotAllowed},
+		{"POST", "text/plain", `{"watches":[{"id":"a","key":"/foo"}]}`, http.StatusUnsupportedMediaType},
+		{"POST", "application/json", `{"watches":`, http.StatusBadRequest},
+		{"POST", "application/json", `{"watches":[]}`, http.StatusBadRequest},
+		{"POST", "application/json", `{"watches":[{"key":"/foo"}]}`, http.StatusBadRequest},
+		{"POST", "application/json", `{"watches":[{"id":"a","key":"/foo"},{"id":"a","key":"/bar"}]}`, http.StatusBadRequest},
+		{"POST", "application/json", `{"watches":[{"id":"a","key":"/foo","waitActions":["get"]}]}`, http.StatusBadRequest},
+	}
+	for i, tt := range tests {
+		req, err := http.NewRequest(tt.method, watchPath, strings.NewReader(tt.body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set("Content-Type", tt.ctype)
+		h := &watchHandler{
+			server:      &resServer{},
+			clusterInfo: &fakeCluster{id: 1},
+			timer:       dummyRaftTimer{},
+		}
+		rw := httptest.NewRecorder()
+		h.ServeHTTP(rw, req)
+		if rw.Code != tt.wcode {
+			t.Errorf("#%d: code = %d, want %d", i, rw.Code, tt.wcode)
+		}
+	}
+}
+
+// Ensure that the events of the watches of a stream are written in the
+// order of their indexes, tagged with the ids of their watches, and that
+// the stream ends when no watch is left.
+func TestMultiWatchOrder(t *testing.T) {
+	newEvent := func(action, key string, index uint64) *store.Event {
+		return &store.Event{Action: action, Node: &store.NodeExtern{Key: "/1" + key, ModifiedIndex: index}}
+	}
+	wa := &dummyWatcher{echan: make(chan *store.Event, 3)}
+	wa.echan <- newEvent(store.Set, "/a", 2)
+	wa.echan <- newEvent(store.Set, "/a", 4)
+	wa.echan <- newEvent(store.Overflow, "/a", 5)
+	wb := &dummyWatcher{echan: make(chan *store.Event, 3)}
+	wb.echan <- newEvent(store.Set, "/b", 1)
+	wb.echan <- newEvent(store.Set, "/b", 3)
+	wb.echan <- newEvent(store.Overflow, "/b", 6)
+	mw := &multiWatch{
+		server:   &resServer{},
+		watches:  []httptypes.Watch{{ID: "a", Key: "/a"}, {ID: "b", Key: "/b"}},
+		watchers: []store.Watcher{wa, wb},
+	}
+	rw := &flushingRecorder{httptest.NewRecorder(), make(chan struct{}, 10)}
+
+	done := make(chan struct{})
+	go func() {
+		mw.serve(context.Background(), rw, dummyRaftTimer{})
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatalf("timed out waiting for done")
+	}
+
+	wids := []string{"b", "a", "b", "a", "a", "b"}
+	dec := json.NewDecoder(rw.Body)
+	for i, wid := range wids {
+		var e struct {
+			WatchID string `json:"watchId"`
+			store.Event
+		}
+		if err := dec.Decode(&e); err != nil {
+			t.Fatalf("#%d: %v", i, err)
+		}
+		if e.WatchID != wid || e.Index() != uint64(i+1) {
+			t.Errorf("#%d: event = %s %d, want %s %d", i, e.WatchID, e.Index(), wid, i+1)
+		}
+		if !strings.HasPrefix(e.Node.Key, "/"+wid) {
+			t.Errorf("#%d: key = %s, want the prefix trimmed", i, e.Node.Key)
+		}
+	}
+	if dec.More() {
+		t.Errorf("unexpected event after the last one")
+	}
+}
+
+// Ensure that a watch whose event is found in the history goes on from
+// the next index.
+func TestMultiWatchHistory(t *testing.T) {
+	wa := &dummyWatcher{echan: make(chan *store.Event, 1), sidx: 10}
+	wa.echan <- &store.Event{Action: store.Set, Node: &store.NodeExtern{Key: "/1/a", ModifiedIndex: 5}}
+	next := &dummyWatcher{echan: make(chan *store.Event, 1), sidx: 10}
+	next.echan <- &store.Event{Action: store.Overflow, Node: &store.NodeExtern{Key: "/1/a", ModifiedIndex: 11}}
+	mw := &multiWatch{
+		server:   &resServer{res: etcdserver.Response{Watcher: next}},
+		watches:  []httptypes.Watch{{ID: "a", Key: "/a"}},
+		watchers: []store.Watcher{wa},
+	}
+	rw := &flushingRecorder{httptest.NewRecorder(), make(chan struct{}, 10)}
+
+	done := make(chan struct{})
+	go func() {
+		mw.serve(context.Background(), rw, dummyRaftTimer{})
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatalf("timed out waiting for done")
+	}
+
+	if g, w := strings.Count(rw.Body.String(), "\n"), 2; g != w {
+		t.Fatalf("events = %d, want %d: %s", g, w, rw.Body.String())
+	}
+	if !strings.Contains(rw.Body.String(), `"action":"overflow"`) {
+		t.Errorf("body = %s, want the event of the new watcher", rw.Body.String())
+	}
+}
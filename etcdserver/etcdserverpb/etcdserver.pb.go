@@ -11,6 +11,7 @@
 	It has these top-level messages:
 		Request
 		Metadata
+		Compare
 */
 package etcdserverpb
 
@@ -27,24 +28,74 @@ import github_com_gogo_protobuf_proto "github.com/coreos/etcd/Godeps/_workspace/
 var _ = proto.Marshal
 var _ = math.Inf
 
+type CompareTarget int32
+
+const (
+	CompareValue         CompareTarget = 0
+	CompareCreatedIndex  CompareTarget = 1
+	CompareModifiedIndex CompareTarget = 2
+)
+
+var CompareTarget_name = map[int32]string{
+	0: "CompareValue",
+	1: "CompareCreatedIndex",
+	2: "CompareModifiedIndex",
+}
+var CompareTarget_value = map[string]int32{
+	"CompareValue":         0,
+	"CompareCreatedIndex":  1,
+	"CompareModifiedIndex": 2,
+}
+
+func (x CompareTarget) Enum() *CompareTarget {
+	p := new(CompareTarget)
+	*p = x
+	return p
+}
+func (x CompareTarget) String() string {
+	return proto.EnumName(CompareTarget_name, int32(x))
+}
+func (x *CompareTarget) UnmarshalJSON(data []byte) error {
+	value, err := proto.UnmarshalJSONEnum(CompareTarget_value, data, "CompareTarget")
+	if err != nil {
+		return err
+	}
+	*x = CompareTarget(value)
+	return nil
+}
+
 type Request struct {
-	ID               uint64 `protobuf:"varint,1,req" json:"ID"`
-	Method           string `protobuf:"bytes,2,req" json:"Method"`
-	Path             string `protobuf:"bytes,3,req" json:"Path"`
-	Val              string `protobuf:"bytes,4,req" json:"Val"`
-	Dir              bool   `protobuf:"varint,5,req" json:"Dir"`
-	PrevValue        string `protobuf:"bytes,6,req" json:"PrevValue"`
-	PrevIndex        uint64 `protobuf:"varint,7,req" json:"PrevIndex"`
-	PrevExist        *bool  `protobuf:"varint,8,req" json:"PrevExist,omitempty"`
-	Expiration       int64  `protobuf:"varint,9,req" json:"Expiration"`
-	Wait             bool   `protobuf:"varint,10,req" json:"Wait"`
-	Since            uint64 `protobuf:"varint,11,req" json:"Since"`
-	Recursive        bool   `protobuf:"varint,12,req" json:"Recursive"`
-	Sorted           bool   `protobuf:"varint,13,req" json:"Sorted"`
-	Quorum           bool   `protobuf:"varint,14,req" json:"Quorum"`
-	Time             int64  `protobuf:"varint,15,req" json:"Time"`
-	Stream           bool   `protobuf:"varint,16,req" json:"Stream"`
-	XXX_unrecognized []byte `json:"-"`
+	ID               uint64    `protobuf:"varint,1,req" json:"ID"`
+	Method           string    `protobuf:"bytes,2,req" json:"Method"`
+	Path             string    `protobuf:"bytes,3,req" json:"Path"`
+	Val              string    `protobuf:"bytes,4,req" json:"Val"`
+	Dir              bool      `protobuf:"varint,5,req" json:"Dir"`
+	PrevValue        string    `protobuf:"bytes,6,req" json:"PrevValue"`
+	PrevIndex        uint64    `protobuf:"varint,7,req" json:"PrevIndex"`
+	PrevExist        *bool     `protobuf:"varint,8,req" json:"PrevExist,omitempty"`
+	Expiration       int64     `protobuf:"varint,9,req" json:"Expiration"`
+	Wait             bool      `protobuf:"varint,10,req" json:"Wait"`
+	Since            uint64    `protobuf:"varint,11,req" json:"Since"`
+	Recursive        bool      `protobuf:"varint,12,req" json:"Recursive"`
+	Sorted           bool      `protobuf:"varint,13,req" json:"Sorted"`
+	Quorum           bool      `protobuf:"varint,14,req" json:"Quorum"`
+	Time             int64     `protobuf:"varint,15,req" json:"Time"`
+	Stream           bool      `protobuf:"varint,16,req" json:"Stream"`
+	Compares         []Compare `protobuf:"bytes,17,rep" json:"Compares"`
+	Success          []Request `protobuf:"bytes,18,rep" json:"Success"`
+	Failure          []Request `protobuf:"bytes,19,rep" json:"Failure"`
+	Limit            uint64    `protobuf:"varint,20,opt" json:"Limit"`
+	Continue         string    `protobuf:"bytes,21,opt" json:"Continue"`
+	Actions          []string  `protobuf:"bytes,22,rep" json:"Actions"`
+	Refresh          bool      `protobuf:"varint,23,opt" json:"Refresh"`
+	Delta            int64     `protobuf:"varint,24,opt" json:"Delta"`
+	MoveTo           string    `protobuf:"bytes,25,opt" json:"MoveTo"`
+	Paths            []string  `protobuf:"bytes,26,rep" json:"Paths"`
+	PrevMarker       string    `protobuf:"bytes,27,opt" json:"PrevMarker"`
+	Token            string    `protobuf:"bytes,28,opt" json:"Token"`
+	User             string    `protobuf:"bytes,29,opt" json:"User"`
+	KV               []byte    `protobuf:"bytes,30,opt" json:"KV,omitempty"`
+	XXX_unrecognized []byte    `json:"-"`
 }
 
 func (m *Request) Reset()         { *m = Request{} }
@@ -61,7 +112,20 @@ func (m *Metadata) Reset()         { *m = Metadata{} }
 func (m *Metadata) String() string { return proto.CompactTextString(m) }
 func (*Metadata) ProtoMessage()    {}
 
+type Compare struct {
+	Path             string        `protobuf:"bytes,1,req" json:"Path"`
+	Target           CompareTarget `protobuf:"varint,2,req,enum=etcdserverpb.CompareTarget" json:"Target"`
+	Value            string        `protobuf:"bytes,3,req" json:"Value"`
+	Index            uint64        `protobuf:"varint,4,req" json:"Index"`
+	XXX_unrecognized []byte        `json:"-"`
+}
+
+func (m *Compare) Reset()         { *m = Compare{} }
+func (m *Compare) String() string { return proto.CompactTextString(m) }
+func (*Compare) ProtoMessage()    {}
+
 func init() {
+	proto.RegisterEnum("etcdserverpb.CompareTarget", CompareTarget_name, CompareTarget_value)
 }
 func (m *Request) Unmarshal(data []byte) error {
 	l := len(data)
@@ -365,6 +429,304 @@ func (m *Request) Unmarshal(data []byte) error {
 				}
 			}
 			m.Stream = bool(v != 0)
+		case 17:
+			if wireType != 2 {
+				return fmt.Errorf("proto: wrong wireType = %d for field Compares", wireType)
+			}
+			var msglen int
+			for shift := uint(0); ; shift += 7 {
+				if index >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := data[index]
+				index++
+				msglen |= (int(b) & 0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+			postIndex := index + msglen
+			if postIndex > l {
+				return io.ErrUnexpectedEOF
+			}
+			m.Compares = append(m.Compares, Compare{})
+			if err := m.Compares[len(m.Compares)-1].Unmarshal(data[index:postIndex]); err != nil {
+				return err
+			}
+			index = postIndex
+		case 18:
+			if wireType != 2 {
+				return fmt.Errorf("proto: wrong wireType = %d for field Success", wireType)
+			}
+			var msglen int
+			for shift := uint(0); ; shift += 7 {
+				if index >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := data[index]
+				index++
+				msglen |= (int(b) & 0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+			postIndex := index + msglen
+			if postIndex > l {
+				return io.ErrUnexpectedEOF
+			}
+			m.Success = append(m.Success, Request{})
+			if err := m.Success[len(m.Success)-1].Unmarshal(data[index:postIndex]); err != nil {
+				return err
+			}
+			index = postIndex
+		case 19:
+			if wireType != 2 {
+				return fmt.Errorf("proto: wrong wireType = %d for field Failure", wireType)
+			}
+			var msglen int
+			for shift := uint(0); ; shift += 7 {
+				if index >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := data[index]
+				index++
+				msglen |= (int(b) & 0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+			postIndex := index + msglen
+			if postIndex > l {
+				return io.ErrUnexpectedEOF
+			}
+			m.Failure = append(m.Failure, Request{})
+			if err := m.Failure[len(m.Failure)-1].Unmarshal(data[index:postIndex]); err != nil {
+				return err
+			}
+			index = postIndex
+		case 20:
+			if wireType != 0 {
+				return fmt.Errorf("proto: wrong wireType = %d for field Limit", wireType)
+			}
+			for shift := uint(0); ; shift += 7 {
+				if index >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := data[index]
+				index++
+				m.Limit |= (uint64(b) & 0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+		case 21:
+			if wireType != 2 {
+				return fmt.Errorf("proto: wrong wireType = %d for field Continue", wireType)
+			}
+			var stringLen uint64
+			for shift := uint(0); ; shift += 7 {
+				if index >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := data[index]
+				index++
+				stringLen |= (uint64(b) & 0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+			postIndex := index + int(stringLen)
+			if postIndex > l {
+				return io.ErrUnexpectedEOF
+			}
+			m.Continue = string(data[index:postIndex])
+			index = postIndex
+		case 22:
+			if wireType != 2 {
+				return fmt.Errorf("proto: wrong wireType = %d for field Actions", wireType)
+			}
+			var stringLen uint64
+			for shift := uint(0); ; shift += 7 {
+				if index >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := data[index]
+				index++
+				stringLen |= (uint64(b) & 0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+			postIndex := index + int(stringLen)
+			if postIndex > l {
+				return io.ErrUnexpectedEOF
+			}
+			m.Actions = append(m.Actions, string(data[index:postIndex]))
+			index = postIndex
+		case 23:
+			if wireType != 0 {
+				return fmt.Errorf("proto: wrong wireType = %d for field Refresh", wireType)
+			}
+			var v int
+			for shift := uint(0); ; shift += 7 {
+				if index >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := data[index]
+				index++
+				v |= (int(b) & 0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+			m.Refresh = bool(v != 0)
+		case 24:
+			if wireType != 0 {
+				return fmt.Errorf("proto: wrong wireType = %d for field Delta", wireType)
+			}
+			for shift := uint(0); ; shift += 7 {
+				if index >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := data[index]
+				index++
+				m.Delta |= (int64(b) & 0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+		case 25:
+			if wireType != 2 {
+				return fmt.Errorf("proto: wrong wireType = %d for field MoveTo", wireType)
+			}
+			var stringLen uint64
+			for shift := uint(0); ; shift += 7 {
+				if index >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := data[index]
+				index++
+				stringLen |= (uint64(b) & 0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+			postIndex := index + int(stringLen)
+			if postIndex > l {
+				return io.ErrUnexpectedEOF
+			}
+			m.MoveTo = string(data[index:postIndex])
+			index = postIndex
+		case 26:
+			if wireType != 2 {
+				return fmt.Errorf("proto: wrong wireType = %d for field Paths", wireType)
+			}
+			var stringLen uint64
+			for shift := uint(0); ; shift += 7 {
+				if index >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := data[index]
+				index++
+				stringLen |= (uint64(b) & 0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+			postIndex := index + int(stringLen)
+			if postIndex > l {
+				return io.ErrUnexpectedEOF
+			}
+			m.Paths = append(m.Paths, string(data[index:postIndex]))
+			index = postIndex
+		case 27:
+			if wireType != 2 {
+				return fmt.Errorf("proto: wrong wireType = %d for field PrevMarker", wireType)
+			}
+			var stringLen uint64
+			for shift := uint(0); ; shift += 7 {
+				if index >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := data[index]
+				index++
+				stringLen |= (uint64(b) & 0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+			postIndex := index + int(stringLen)
+			if postIndex > l {
+				return io.ErrUnexpectedEOF
+			}
+			m.PrevMarker = string(data[index:postIndex])
+			index = postIndex
+		case 28:
+			if wireType != 2 {
+				return fmt.Errorf("proto: wrong wireType = %d for field Token", wireType)
+			}
+			var stringLen uint64
+			for shift := uint(0); ; shift += 7 {
+				if index >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := data[index]
+				index++
+				stringLen |= (uint64(b) & 0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+			postIndex := index + int(stringLen)
+			if postIndex > l {
+				return io.ErrUnexpectedEOF
+			}
+			m.Token = string(data[index:postIndex])
+			index = postIndex
+		case 29:
+			if wireType != 2 {
+				return fmt.Errorf("proto: wrong wireType = %d for field User", wireType)
+			}
+			var stringLen uint64
+			for shift := uint(0); ; shift += 7 {
+				if index >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := data[index]
+				index++
+				stringLen |= (uint64(b) & 0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+			postIndex := index + int(stringLen)
+			if postIndex > l {
+				return io.ErrUnexpectedEOF
+			}
+			m.User = string(data[index:postIndex])
+			index = postIndex
+		case 30:
+			if wireType != 2 {
+				return fmt.Errorf("proto: wrong wireType = %d for field KV", wireType)
+			}
+			var byteLen int
+			for shift := uint(0); ; shift += 7 {
+				if index >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := data[index]
+				index++
+				byteLen |= (int(b) & 0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+			postIndex := index + byteLen
+			if postIndex > l {
+				return io.ErrUnexpectedEOF
+			}
+			m.KV = append([]byte{}, data[index:postIndex]...)
+			index = postIndex
 		default:
 			var sizeOfWire int
 			for {
@@ -460,6 +822,122 @@ func (m *Metadata) Unmarshal(data []byte) error {
 	}
 	return nil
 }
+func (m *Compare) Unmarshal(data []byte) error {
+	l := len(data)
+	index := 0
+	for index < l {
+		var wire uint64
+		for shift := uint(0); ; shift += 7 {
+			if index >= l {
+				return io.ErrUnexpectedEOF
+			}
+			b := data[index]
+			index++
+			wire |= (uint64(b) & 0x7F) << shift
+			if b < 0x80 {
+				break
+			}
+		}
+		fieldNum := int32(wire >> 3)
+		wireType := int(wire & 0x7)
+		switch fieldNum {
+		case 1:
+			if wireType != 2 {
+				return fmt.Errorf("proto: wrong wireType = %d for field Path", wireType)
+			}
+			var stringLen uint64
+			for shift := uint(0); ; shift += 7 {
+				if index >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := data[index]
+				index++
+				stringLen |= (uint64(b) & 0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+			postIndex := index + int(stringLen)
+			if postIndex > l {
+				return io.ErrUnexpectedEOF
+			}
+			m.Path = string(data[index:postIndex])
+			index = postIndex
+		case 2:
+			if wireType != 0 {
+				return fmt.Errorf("proto: wrong wireType = %d for field Target", wireType)
+			}
+			for shift := uint(0); ; shift += 7 {
+				if index >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := data[index]
+				index++
+				m.Target |= (CompareTarget(b) & 0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+		case 3:
+			if wireType != 2 {
+				return fmt.Errorf("proto: wrong wireType = %d for field Value", wireType)
+			}
+			var stringLen uint64
+			for shift := uint(0); ; shift += 7 {
+				if index >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := data[index]
+				index++
+				stringLen |= (uint64(b) & 0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+			postIndex := index + int(stringLen)
+			if postIndex > l {
+				return io.ErrUnexpectedEOF
+			}
+			m.Value = string(data[index:postIndex])
+			index = postIndex
+		case 4:
+			if wireType != 0 {
+				return fmt.Errorf("proto: wrong wireType = %d for field Index", wireType)
+			}
+			for shift := uint(0); ; shift += 7 {
+				if index >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := data[index]
+				index++
+				m.Index |= (uint64(b) & 0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+		default:
+			var sizeOfWire int
+			for {
+				sizeOfWire++
+				wire >>= 7
+				if wire == 0 {
+					break
+				}
+			}
+			index -= sizeOfWire
+			skippy, err := github_com_gogo_protobuf_proto.Skip(data[index:])
+			if err != nil {
+				return err
+			}
+			if (index + skippy) > l {
+				return io.ErrUnexpectedEOF
+			}
+			m.XXX_unrecognized = append(m.XXX_unrecognized, data[index:index+skippy]...)
+			index += skippy
+		}
+	}
+	return nil
+}
 func (m *Request) Size() (n int) {
 	var l int
 	_ = l
@@ -485,6 +963,69 @@ func (m *Request) Size() (n int) {
 	n += 2
 	n += 1 + sovEtcdserver(uint64(m.Time))
 	n += 3
+	if len(m.Compares) > 0 {
+		for _, e := range m.Compares {
+			l = e.Size()
+			n += 2 + l + sovEtcdserver(uint64(l))
+		}
+	}
+	if len(m.Success) > 0 {
+		for _, e := range m.Success {
+			l = e.Size()
+			n += 2 + l + sovEtcdserver(uint64(l))
+		}
+	}
+	if len(m.Failure) > 0 {
+		for _, e := range m.Failure {
+			l = e.Size()
+			n += 2 + l + sovEtcdserver(uint64(l))
+		}
+	}
+	if m.Limit != 0 {
+		n += 2 + sovEtcdserver(uint64(m.Limit))
+	}
+	l = len(m.Continue)
+	if l > 0 {
+		n += 2 + l + sovEtcdserver(uint64(l))
+	}
+	if len(m.Actions) > 0 {
+		for _, s := range m.Actions {
+			l = len(s)
+			n += 2 + l + sovEtcdserver(uint64(l))
+		}
+	}
+	if m.Refresh {
+		n += 3
+	}
+	if m.Delta != 0 {
+		n += 2 + sovEtcdserver(uint64(m.Delta))
+	}
+	l = len(m.MoveTo)
+	if l > 0 {
+		n += 2 + l + sovEtcdserver(uint64(l))
+	}
+	if len(m.Paths) > 0 {
+		for _, s := range m.Paths {
+			l = len(s)
+			n += 2 + l + sovEtcdserver(uint64(l))
+		}
+	}
+	l = len(m.PrevMarker)
+	if l > 0 {
+		n += 2 + l + sovEtcdserver(uint64(l))
+	}
+	l = len(m.Token)
+	if l > 0 {
+		n += 2 + l + sovEtcdserver(uint64(l))
+	}
+	l = len(m.User)
+	if l > 0 {
+		n += 2 + l + sovEtcdserver(uint64(l))
+	}
+	if m.KV != nil {
+		l = len(m.KV)
+		n += 2 + l + sovEtcdserver(uint64(l))
+	}
 	if m.XXX_unrecognized != nil {
 		n += len(m.XXX_unrecognized)
 	}
@@ -502,6 +1043,21 @@ func (m *Metadata) Size() (n int) {
 	return n
 }
 
+func (m *Compare) Size() (n int) {
+	var l int
+	_ = l
+	l = len(m.Path)
+	n += 1 + l + sovEtcdserver(uint64(l))
+	n += 1 + sovEtcdserver(uint64(m.Target))
+	l = len(m.Value)
+	n += 1 + l + sovEtcdserver(uint64(l))
+	n += 1 + sovEtcdserver(uint64(m.Index))
+	if m.XXX_unrecognized != nil {
+		n += len(m.XXX_unrecognized)
+	}
+	return n
+}
+
 func sovEtcdserver(x uint64) (n int) {
 	for {
 		n++
@@ -621,6 +1177,152 @@ func (m *Request) MarshalTo(data []byte) (n int, err error) {
 		data[i] = 0
 	}
 	i++
+	if len(m.Compares) > 0 {
+		for _, msg := range m.Compares {
+			data[i] = 0x8a
+			i++
+			data[i] = 0x1
+			i++
+			i = encodeVarintEtcdserver(data, i, uint64(msg.Size()))
+			n, err := msg.MarshalTo(data[i:])
+			if err != nil {
+				return 0, err
+			}
+			i += n
+		}
+	}
+	if len(m.Success) > 0 {
+		for _, msg := range m.Success {
+			data[i] = 0x92
+			i++
+			data[i] = 0x1
+			i++
+			i = encodeVarintEtcdserver(data, i, uint64(msg.Size()))
+			n, err := msg.MarshalTo(data[i:])
+			if err != nil {
+				return 0, err
+			}
+			i += n
+		}
+	}
+	if len(m.Failure) > 0 {
+		for _, msg := range m.Failure {
+			data[i] = 0x9a
+			i++
+			data[i] = 0x1
+			i++
+			i = encodeVarintEtcdserver(data, i, uint64(msg.Size()))
+			n, err := msg.MarshalTo(data[i:])
+			if err != nil {
+				return 0, err
+			}
+			i += n
+		}
+	}
+	if m.Limit != 0 {
+		data[i] = 0xa0
+		i++
+		data[i] = 0x1
+		i++
+		i = encodeVarintEtcdserver(data, i, uint64(m.Limit))
+	}
+	if len(m.Continue) > 0 {
+		data[i] = 0xaa
+		i++
+		data[i] = 0x1
+		i++
+		i = encodeVarintEtcdserver(data, i, uint64(len(m.Continue)))
+		i += copy(data[i:], m.Continue)
+	}
+	if len(m.Actions) > 0 {
+		for _, s := range m.Actions {
+			data[i] = 0xb2
+			i++
+			data[i] = 0x1
+			i++
+			l = len(s)
+			for l >= 1<<7 {
+				data[i] = uint8(uint64(l)&0x7f | 0x80)
+				l >>= 7
+				i++
+			}
+			data[i] = uint8(l)
+			i++
+			i += copy(data[i:], s)
+		}
+	}
+	if m.Refresh {
+		data[i] = 0xb8
+		i++
+		data[i] = 0x1
+		i++
+		data[i] = 1
+		i++
+	}
+	if m.Delta != 0 {
+		data[i] = 0xc0
+		i++
+		data[i] = 0x1
+		i++
+		i = encodeVarintEtcdserver(data, i, uint64(m.Delta))
+	}
+	if len(m.MoveTo) > 0 {
+		data[i] = 0xca
+		i++
+		data[i] = 0x1
+		i++
+		i = encodeVarintEtcdserver(data, i, uint64(len(m.MoveTo)))
+		i += copy(data[i:], m.MoveTo)
+	}
+	if len(m.Paths) > 0 {
+		for _, s := range m.Paths {
+			data[i] = 0xd2
+			i++
+			data[i] = 0x1
+			i++
+			l = len(s)
+			for l >= 1<<7 {
+				data[i] = uint8(uint64(l)&0x7f | 0x80)
+				l >>= 7
+				i++
+			}
+			data[i] = uint8(l)
+			i++
+			i += copy(data[i:], s)
+		}
+	}
+	if len(m.PrevMarker) > 0 {
+		data[i] = 0xda
+		i++
+		data[i] = 0x1
+		i++
+		i = encodeVarintEtcdserver(data, i, uint64(len(m.PrevMarker)))
+		i += copy(data[i:], m.PrevMarker)
+	}
+	if len(m.Token) > 0 {
+		data[i] = 0xe2
+		i++
+		data[i] = 0x1
+		i++
+		i = encodeVarintEtcdserver(data, i, uint64(len(m.Token)))
+		i += copy(data[i:], m.Token)
+	}
+	if len(m.User) > 0 {
+		data[i] = 0xea
+		i++
+		data[i] = 0x1
+		i++
+		i = encodeVarintEtcdserver(data, i, uint64(len(m.User)))
+		i += copy(data[i:], m.User)
+	}
+	if m.KV != nil {
+		data[i] = 0xf2
+		i++
+		data[i] = 0x1
+		i++
+		i = encodeVarintEtcdserver(data, i, uint64(len(m.KV)))
+		i += copy(data[i:], m.KV)
+	}
 	if m.XXX_unrecognized != nil {
 		i += copy(data[i:], m.XXX_unrecognized)
 	}
@@ -654,6 +1356,41 @@ func (m *Metadata) MarshalTo(data []byte) (n int, err error) {
 	return i, nil
 }
 
+func (m *Compare) Marshal() (data []byte, err error) {
+	size := m.Size()
+	data = make([]byte, size)
+	n, err := m.MarshalTo(data)
+	if err != nil {
+		return nil, err
+	}
+	return data[:n], nil
+}
+
+func (m *Compare) MarshalTo(data []byte) (n int, err error) {
+	var i int
+	_ = i
+	var l int
+	_ = l
+	data[i] = 0xa
+	i++
+	i = encodeVarintEtcdserver(data, i, uint64(len(m.Path)))
+	i += copy(data[i:], m.Path)
+	data[i] = 0x10
+	i++
+	i = encodeVarintEtcdserver(data, i, uint64(m.Target))
+	data[i] = 0x1a
+	i++
+	i = encodeVarintEtcdserver(data, i, uint64(len(m.Value)))
+	i += copy(data[i:], m.Value)
+	data[i] = 0x20
+	i++
+	i = encodeVarintEtcdserver(data, i, uint64(m.Index))
+	if m.XXX_unrecognized != nil {
+		i += copy(data[i:], m.XXX_unrecognized)
+	}
+	return i, nil
+}
+
 func encodeFixed64Etcdserver(data []byte, offset int, v uint64) int {
 	data[offset] = uint8(v)
 	data[offset+1] = uint8(v >> 8)
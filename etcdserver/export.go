@@ -0,0 +1,99 @@
+// Copyright 2015 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package etcdserver
+
+import (
+	"encoding/json"
+	"sync/atomic"
+
+	"github.com/coreos/etcd/Godeps/_workspace/src/golang.org/x/net/context"
+	etcdErr "github.com/coreos/etcd/error"
+	pb "github.com/coreos/etcd/etcdserver/etcdserverpb"
+	"github.com/coreos/etcd/store"
+)
+
+// Export returns the subtree of the store at the given store path. The
+// export goes through consensus like a quorum get, so it holds the
+// subtree at a committed index.
+// 导出子树，经过raft，保证导出的是已提交的数据
+func (s *EtcdServer) Export(ctx context.Context, nodePath string) (*store.Export, error) {
+	resp, err := s.Do(ctx, pb.Request{Method: "EXPORT", Path: nodePath})
+	if err != nil {
+		return nil, err
+	}
+	return resp.Export, nil
+}
+
+// Import creates the nodes of the export at the given store path, which
+// must not exist. The export is proposed as a whole, so it is bounded by
+// the max request size, and each of its values by the max value size.
+// 导入子树，整个导入作为一次raft proposal
+func (s *EtcdServer) Import(ctx context.Context, nodePath string, ex *store.Export) (*store.Event, error) {
+	if err := ex.Validate(); err != nil {
+		return nil, ErrInvalidExport
+	}
+	if maxValue := atomic.LoadInt64(&s.maxValueBytes); maxValue > 0 && !isAdminPath(nodePath) && exportMaxValueBytes(ex.Node) > maxValue {
+		return nil, ErrValueTooLarge
+	}
+	data, err := json.Marshal(ex)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := s.Do(ctx, pb.Request{Method: "IMPORT", Path: nodePath, Val: string(data)})
+	if err != nil {
+		return nil, err
+	}
+	return resp.Event, nil
+}
+
+// applyImport imports the export carried by the IMPORT request.
+func (s *EtcdServer) applyImport(r pb.Request) Response {
+	var ex store.Export
+	if err := json.Unmarshal([]byte(r.Val), &ex); err != nil {
+		return Response{err: etcdErr.NewError(etcdErr.EcodeInvalidField, "invalid export", s.store.Index())}
+	}
+	ev, err := s.store.Import(r.Path, &ex)
+	return Response{Event: ev, err: err}
+}
+
+// exportMaxValueBytes returns the size of the largest value of the node
+// and the nodes under it.
+func exportMaxValueBytes(n *store.NodeExtern) int64 {
+	var max int64
+	if n.Value != nil {
+		max = int64(len(*n.Value))
+	}
+	for _, child := range n.Nodes {
+		if m := exportMaxValueBytes(child); m > max {
+			max = m
+		}
+	}
+	return max
+}
+
+// exportUsage returns the number of the nodes of the export, including
+// the exported node itself, and the total size of their values.
+func exportUsage(n *store.NodeExtern) (nodes, valueBytes int64) {
+	nodes = 1
+	if n.Value != nil {
+		valueBytes = int64(len(*n.Value))
+	}
+	for _, child := range n.Nodes {
+		cn, cb := exportUsage(child)
+		nodes += cn
+		valueBytes += cb
+	}
+	return nodes, valueBytes
+}
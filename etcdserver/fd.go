@@ -0,0 +1,120 @@
+// Copyright 2015 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package etcdserver
+
+import (
+	"log"
+	"sync/atomic"
+	"time"
+
+	"github.com/coreos/etcd/pkg/runtime"
+)
+
+const (
+	// DefaultFDWarnPercent is the default percent of the file descriptor
+	// limit above which the usage is logged.
+	DefaultFDWarnPercent = 80
+	// DefaultFDShedWatchPercent is the default percent of the file
+	// descriptor limit above which the new watches are rejected.
+	DefaultFDShedWatchPercent = 90
+
+	// monitorFDInterval is the interval at which the member checks its
+	// file descriptor usage.
+	monitorFDInterval = 5 * time.Second
+)
+
+// fdWarnPercent returns the percent of the file descriptor limit above
+// which the usage is logged, or zero if the log is disabled.
+func (c *ServerConfig) fdWarnPercent() int {
+	switch {
+	case c.FDWarnPercent == 0:
+		return DefaultFDWarnPercent
+	case c.FDWarnPercent < 0:
+		return 0
+	default:
+		return c.FDWarnPercent
+	}
+}
+
+// fdShedWatchPercent returns the percent of the file descriptor limit
+// above which the new watches are rejected, or zero if they never are.
+func (c *ServerConfig) fdShedWatchPercent() int {
+	switch {
+	case c.FDShedWatchPercent == 0:
+		return DefaultFDShedWatchPercent
+	case c.FDShedWatchPercent < 0:
+		return 0
+	default:
+		return c.FDShedWatchPercent
+	}
+}
+
+// overFDPercent reports whether used is at or above the given percent of
+// limit. A percent of zero is never reached.
+func overFDPercent(used, limit uint64, percent int) bool {
+	return percent > 0 && used*100 >= limit*uint64(percent)
+}
+
+// monitorFileDescriptor checks the file descriptor usage every
+// monitorFDInterval. Above warn percent of the limit, the usage is
+// logged; above shed percent, the new watches are rejected until the
+// usage goes down again. The watches are the cheapest to shed: the
+// clients retry them on another member, while the descriptors left keep
+// the raft transport dialing the peers.
+// fd使用率过高时拒绝新的watch连接，保留fd给raft transport
+func (s *EtcdServer) monitorFileDescriptor(warn, shed int) {
+	ticker := time.NewTicker(monitorFDInterval)
+	defer ticker.Stop()
+	for {
+		used, err := runtime.FDUsage()
+		if err != nil {
+			log.Printf("etcdserver: cannot monitor file descriptor usage (%v)", err)
+			return
+		}
+		fileDescriptorUsed.Set(float64(used))
+		limit, err := runtime.FDLimit()
+		if err != nil {
+			log.Printf("etcdserver: cannot monitor file descriptor usage (%v)", err)
+			return
+		}
+		fileDescriptorLimit.Set(float64(limit))
+		if overFDPercent(used, limit, warn) {
+			log.Printf("etcdserver: %d%% of the file descriptor limit is used [used = %d, limit = %d]", used*100/limit, used, limit)
+		}
+		s.setShedWatches(overFDPercent(used, limit, shed))
+		select {
+		case <-ticker.C:
+		case <-s.done:
+			return
+		}
+	}
+}
+
+func (s *EtcdServer) setShedWatches(shed bool) {
+	if shed == s.ShedWatches() {
+		return
+	}
+	if shed {
+		log.Printf("etcdserver: too many file descriptors used; rejecting the new watches")
+		atomic.StoreInt32(&s.shedWatches, 1)
+	} else {
+		log.Printf("etcdserver: file descriptor usage is back to normal; accepting the new watches again")
+		atomic.StoreInt32(&s.shedWatches, 0)
+	}
+}
+
+// ShedWatches reports whether the new watches should be rejected for too
+// many file descriptors used.
+func (s *EtcdServer) ShedWatches() bool { return atomic.LoadInt32(&s.shedWatches) == 1 }
@@ -0,0 +1,48 @@
+// Copyright 2015 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package etcdserver
+
+import "testing"
+
+func TestOverFDPercent(t *testing.T) {
+	tests := []struct {
+		used, limit uint64
+		percent     int
+		w           bool
+	}{
+		{899, 1000, 90, false},
+		{900, 1000, 90, true},
+		{1000, 1000, 90, true},
+		// disabled
+		{1000, 1000, 0, false},
+	}
+	for i, tt := range tests {
+		if g := overFDPercent(tt.used, tt.limit, tt.percent); g != tt.w {
+			t.Errorf("#%d: over = %t, want %t", i, g, tt.w)
+		}
+	}
+}
+
+func TestSetShedWatches(t *testing.T) {
+	s := &EtcdServer{}
+	s.setShedWatches(true)
+	if !s.ShedWatches() {
+		t.Errorf("ShedWatches = false, want true")
+	}
+	s.setShedWatches(false)
+	if s.ShedWatches() {
+		t.Errorf("ShedWatches = true, want false")
+	}
+}
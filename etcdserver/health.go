@@ -0,0 +1,130 @@
+// Copyright 2015 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package etcdserver
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/coreos/etcd/raft"
+)
+
+// CheckDiskWriteable returns an error if the member cannot write into its
+// member dir or its dedicated WAL dir, e.g. the disk is full or is
+// remounted read-only, or if it rejects the proposals for low disk space.
+// 检查数据目录是否可写
+func (s *EtcdServer) CheckDiskWriteable() error {
+	if s.isDiskLow() {
+		return ErrLowDiskSpace
+	}
+	dirs := []string{s.cfg.MemberDir()}
+	if s.cfg.DedicatedWALDir != "" {
+		dirs = append(dirs, s.cfg.DedicatedWALDir)
+	}
+	for _, dir := range dirs {
+		// a file of its own, so that the concurrent checks do not remove
+		// the files of each other
+		f, err := ioutil.TempFile(dir, ".touch")
+		if err != nil {
+			return err
+		}
+		f.Close()
+		if err := os.Remove(f.Name()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// purgeErrors keeps the errors of the failing purges of the old files by
+// the type of the files.
+type purgeErrors struct {
+	mu   sync.Mutex
+	errs map[string]error
+}
+
+// set records the result of a purge of the files of the type: the error
+// of a failed purge, or nil once a purge succeeds again.
+func (pe *purgeErrors) set(typ string, err error) {
+	pe.mu.Lock()
+	defer pe.mu.Unlock()
+	if err == nil {
+		log.Printf("etcdserver: purged %s files again", typ)
+		delete(pe.errs, typ)
+		return
+	}
+	log.Printf("etcdserver: failed to purge %s file: %v (retrying in %v)", typ, err, purgeFileInterval)
+	purgeFailed.WithLabelValues(typ).Inc()
+	if pe.errs == nil {
+		pe.errs = make(map[string]error)
+	}
+	pe.errs[typ] = err
+}
+
+func (pe *purgeErrors) get() error {
+	pe.mu.Lock()
+	defer pe.mu.Unlock()
+	if len(pe.errs) == 0 {
+		return nil
+	}
+	var msgs []string
+	for typ, err := range pe.errs {
+		msgs = append(msgs, fmt.Sprintf("%s: %v", typ, err))
+	}
+	sort.Strings(msgs)
+	return errors.New(strings.Join(msgs, "; "))
+}
+
+// CheckPurge returns an error if the member fails to purge its old snap
+// or wal files, e.g. for the permissions of the files. The purges are
+// retried, so the files keep piling up on the disk until they succeed.
+func (s *EtcdServer) CheckPurge() error { return s.purgeErrs.get() }
+
+// readyMaxEntriesBehind is how many committed entries a ready member may
+// not have applied yet.
+const readyMaxEntriesBehind = 1000
+
+// ReadinessChecker tells whether the member is ready to serve the
+// clients.
+type ReadinessChecker interface {
+	// CheckReady returns an error telling why the member is not ready:
+	// it is replaying its WAL, receiving or applying a snapshot, has no
+	// leader or lags behind the leader.
+	CheckReady() error
+}
+
+// 检查member是否已追上leader，可以对外提供服务
+func (s *EtcdServer) CheckReady() error {
+	if a := s.AppliedIndex(); a < s.replayIndex {
+		return fmt.Errorf("replaying the WAL (applied index %d, want %d)", a, s.replayIndex)
+	}
+	if atomic.LoadInt32(&s.applyingSnap) == 1 || s.r.transport.ReceivingSnapshot() {
+		return errors.New("receiving a snapshot")
+	}
+	if s.Lead() == raft.None {
+		return errors.New("no leader")
+	}
+	if err := s.CheckStaleness(readyMaxEntriesBehind, 0); err != nil {
+		return fmt.Errorf("more than %d entries behind the leader", readyMaxEntriesBehind)
+	}
+	return nil
+}
@@ -0,0 +1,95 @@
+// Copyright 2015 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package etcdserver
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+// snapTransporter tells whether a snapshot is being received.
+type snapTransporter struct {
+	nopTransporter
+	receiving bool
+}
+
+func (tr *snapTransporter) ReceivingSnapshot() bool { return tr.receiving }
+
+func TestCheckReady(t *testing.T) {
+	tests := []struct {
+		lead         uint64
+		committed    uint64
+		applied      uint64
+		replayIndex  uint64
+		applyingSnap int32
+		receiving    bool
+
+		werr string
+	}{
+		{1, 10, 10, 8, 0, false, ""},
+		{1, 10, 6, 8, 0, false, "replaying the WAL"},
+		{1, 10, 10, 8, 1, false, "receiving a snapshot"},
+		{1, 10, 10, 8, 0, true, "receiving a snapshot"},
+		{0, 10, 10, 8, 0, false, "no leader"},
+		{1, 10 + readyMaxEntriesBehind + 1, 10, 8, 0, false, "behind the leader"},
+	}
+	for i, tt := range tests {
+		s := &EtcdServer{
+			id:           1,
+			applyingSnap: tt.applyingSnap,
+			replayIndex:  tt.replayIndex,
+			r: raftNode{
+				lead:      tt.lead,
+				committed: tt.committed,
+				index:     tt.applied,
+				transport: &snapTransporter{receiving: tt.receiving},
+			},
+		}
+		err := s.CheckReady()
+		if tt.werr == "" {
+			if err != nil {
+				t.Errorf("#%d: err = %v, want nil", i, err)
+			}
+			continue
+		}
+		if err == nil || !strings.Contains(err.Error(), tt.werr) {
+			t.Errorf("#%d: err = %v, want %q", i, err, tt.werr)
+		}
+	}
+}
+
+func TestCheckPurge(t *testing.T) {
+	s := &EtcdServer{}
+	if err := s.CheckPurge(); err != nil {
+		t.Fatalf("err = %v, want nil", err)
+	}
+	s.purgeErrs.set("wal", errors.New("permission denied"))
+	s.purgeErrs.set("snap", errors.New("read-only file system"))
+	w := "snap: read-only file system; wal: permission denied"
+	if err := s.CheckPurge(); err == nil || err.Error() != w {
+		t.Fatalf("err = %v, want %q", err, w)
+	}
+	// a purge succeeds again
+	s.purgeErrs.set("snap", nil)
+	w = "wal: permission denied"
+	if err := s.CheckPurge(); err == nil || err.Error() != w {
+		t.Fatalf("err = %v, want %q", err, w)
+	}
+	s.purgeErrs.set("wal", nil)
+	if err := s.CheckPurge(); err != nil {
+		t.Fatalf("err = %v, want nil", err)
+	}
+}
@@ -0,0 +1,328 @@
+// Copyright 2015 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package etcdserver
+
+import (
+	"bytes"
+	"encoding/hex"
+	"path"
+
+	"github.com/coreos/etcd/Godeps/_workspace/src/golang.org/x/net/context"
+	"github.com/coreos/etcd/etcdserver/api/kvpb"
+	pb "github.com/coreos/etcd/etcdserver/etcdserverpb"
+	"github.com/coreos/etcd/store"
+)
+
+// The v3 KV API keeps its keys in the hidden directory StoreKVPrefix of
+// the store. A key is hex-encoded into the name of its node, so a key may
+// hold any byte, and the sorted nodes of the directory are in the byte
+// order of the keys.
+// v3 KV的key以hex编码后作为StoreKVPrefix目录下的节点名，保持key的字节序
+
+// Range gets the keys in the range of r. A linearizable range goes through
+// raft like a quorum GET; otherwise it is served from the local store.
+func (s *EtcdServer) Range(ctx context.Context, r *kvpb.RangeRequest) (*kvpb.RangeResponse, error) {
+	if r.Linearizable {
+		resp, err := s.Txn(ctx, &kvpb.TxnRequest{Success: []*kvpb.RequestUnion{{RequestRange: r}}})
+		if err != nil {
+			return nil, err
+		}
+		rr := resp.Responses[0].ResponseRange
+		rr.Header = resp.Header
+		return rr, nil
+	}
+	if s.Cluster.IsAlarmActive(AlarmCorrupt) {
+		return nil, ErrCorrupt
+	}
+	resp, err := s.kvRange(r)
+	if err != nil {
+		return nil, err
+	}
+	s.fillKVHeader(resp.Header)
+	return resp, nil
+}
+
+// Put puts the key of r into the store.
+func (s *EtcdServer) Put(ctx context.Context, r *kvpb.PutRequest) (*kvpb.PutResponse, error) {
+	resp, err := s.Txn(ctx, &kvpb.TxnRequest{Success: []*kvpb.RequestUnion{{RequestPut: r}}})
+	if err != nil {
+		return nil, err
+	}
+	pr := resp.Responses[0].ResponsePut
+	pr.Header = resp.Header
+	return pr, nil
+}
+
+// DeleteRange deletes the keys in the range of r from the store.
+func (s *EtcdServer) DeleteRange(ctx context.Context, r *kvpb.DeleteRangeRequest) (*kvpb.DeleteRangeResponse, error) {
+	resp, err := s.Txn(ctx, &kvpb.TxnRequest{Success: []*kvpb.RequestUnion{{RequestDeleteRange: r}}})
+	if err != nil {
+		return nil, err
+	}
+	dr := resp.Responses[0].ResponseDeleteRange
+	dr.Header = resp.Header
+	return dr, nil
+}
+
+// Txn applies the success requests of r if all its compares hold, and the
+// failure requests otherwise. Every KV write goes through raft as a
+// transaction.
+func (s *EtcdServer) Txn(ctx context.Context, r *kvpb.TxnRequest) (*kvpb.TxnResponse, error) {
+	data, err := r.Marshal()
+	if err != nil {
+		return nil, err
+	}
+	resp, err := s.Do(ctx, pb.Request{Method: "KV", Path: StoreKVPrefix, KV: data})
+	if err != nil {
+		return nil, err
+	}
+	s.fillKVHeader(resp.KV.Header)
+	return resp.KV, nil
+}
+
+// fillKVHeader sets the fields of the header that do not depend on the
+// store, which sets the index.
+func (s *EtcdServer) fillKVHeader(h *kvpb.ResponseHeader) {
+	h.ClusterId = uint64(s.Cluster.ID())
+	h.MemberId = uint64(s.id)
+	h.RaftTerm = s.Term()
+}
+
+// applyKV applies the v3 transaction of the KV request r. The writes of
+// the chosen branch are applied atomically by one store transaction, and
+// its ranges are read right after, before any other request is applied.
+// The requests of a branch must not overlap, so the order in which they
+// are applied does not matter.
+func (s *EtcdServer) applyKV(r pb.Request) Response {
+	var req kvpb.TxnRequest
+	if err := req.Unmarshal(r.KV); err != nil {
+		return Response{err: err}
+	}
+	cmps := make([]store.TxnCompare, len(req.Compare))
+	for i, c := range req.Compare {
+		cmps[i] = store.TxnCompare{Path: kvPath(c.Key), Value: string(c.Value), Index: c.Index}
+		switch c.Target {
+		case kvpb.Compare_VALUE:
+			cmps[i].Target = store.TxnCompareValue
+		case kvpb.Compare_CREATE_INDEX:
+			cmps[i].Target = store.TxnCompareCreatedIndex
+		case kvpb.Compare_MOD_INDEX:
+			cmps[i].Target = store.TxnCompareModifiedIndex
+		default:
+			// unknown targets never hold
+			cmps[i].Target = -1
+		}
+	}
+	success, sdeleted, err := s.kvOps(req.Success)
+	if err != nil {
+		return Response{err: err}
+	}
+	failure, fdeleted, err := s.kvOps(req.Failure)
+	if err != nil {
+		return Response{err: err}
+	}
+	tr, err := s.store.Txn(cmps, success, failure)
+	if err != nil {
+		return Response{err: err}
+	}
+
+	reqs, deleted := req.Failure, fdeleted
+	if tr.Succeeded {
+		reqs, deleted = req.Success, sdeleted
+	}
+	resp := &kvpb.TxnResponse{
+		Header:    &kvpb.ResponseHeader{Index: tr.EtcdIndex},
+		Succeeded: tr.Succeeded,
+		Responses: make([]*kvpb.ResponseUnion, len(reqs)),
+	}
+	for i, u := range reqs {
+		switch {
+		case u.RequestRange != nil:
+			rr, err := s.kvRange(u.RequestRange)
+			if err != nil {
+				return Response{err: err}
+			}
+			rr.Header = nil
+			resp.Responses[i] = &kvpb.ResponseUnion{ResponseRange: rr}
+		case u.RequestPut != nil:
+			resp.Responses[i] = &kvpb.ResponseUnion{ResponsePut: &kvpb.PutResponse{}}
+		case u.RequestDeleteRange != nil:
+			resp.Responses[i] = &kvpb.ResponseUnion{ResponseDeleteRange: &kvpb.DeleteRangeResponse{Deleted: deleted[i]}}
+		}
+	}
+	return Response{KV: resp}
+}
+
+// kvOps returns the store operations of the requests of one transaction
+// branch, and the number of keys each request deletes. A delete range is
+// expanded into the deletes of the keys in the range at apply time.
+func (s *EtcdServer) kvOps(reqs []*kvpb.RequestUnion) ([]store.TxnOp, []int64, error) {
+	var ops []store.TxnOp
+	deleted := make([]int64, len(reqs))
+	// writes holds the ranges written by the requests, and all the ranges
+	// read or written by them. A written range may not overlap another.
+	var writes, all []keyRange
+	for i, u := range reqs {
+		switch {
+		case u.RequestRange != nil && u.RequestPut == nil && u.RequestDeleteRange == nil:
+			all = append(all, newKeyRange(u.RequestRange.Key, u.RequestRange.RangeEnd))
+		case u.RequestPut != nil && u.RequestRange == nil && u.RequestDeleteRange == nil:
+			kr := newKeyRange(u.RequestPut.Key, nil)
+			writes, all = append(writes, kr), append(all, kr)
+			ops = append(ops, store.TxnOp{Action: store.Set, Path: kvPath(u.RequestPut.Key), Value: string(u.RequestPut.Value)})
+		case u.RequestDeleteRange != nil && u.RequestRange == nil && u.RequestPut == nil:
+			kr := newKeyRange(u.RequestDeleteRange.Key, u.RequestDeleteRange.RangeEnd)
+			writes, all = append(writes, kr), append(all, kr)
+			nodes, _, err := s.kvNodes(u.RequestDeleteRange.Key, u.RequestDeleteRange.RangeEnd)
+			if err != nil {
+				return nil, nil, err
+			}
+			for _, n := range nodes {
+				ops = append(ops, store.TxnOp{Action: store.Delete, Path: n.Key})
+			}
+			deleted[i] = int64(len(nodes))
+		default:
+			return nil, nil, ErrInvalidKVRequest
+		}
+	}
+	for _, w := range writes {
+		overlaps := 0
+		for _, kr := range all {
+			if w.overlaps(kr) {
+				overlaps++
+			}
+		}
+		// a write always overlaps itself
+		if overlaps > 1 {
+			return nil, nil, ErrInvalidKVRequest
+		}
+	}
+	return ops, deleted, nil
+}
+
+// kvRange reads the keys in the range of r from the store. The header of
+// the response only holds the store index.
+func (s *EtcdServer) kvRange(r *kvpb.RangeRequest) (*kvpb.RangeResponse, error) {
+	nodes, index, err := s.kvNodes(r.Key, r.RangeEnd)
+	if err != nil {
+		return nil, err
+	}
+	resp := &kvpb.RangeResponse{Header: &kvpb.ResponseHeader{Index: index}}
+	for _, n := range nodes {
+		if r.Limit > 0 && int64(len(resp.Kvs)) == r.Limit {
+			resp.More = true
+			break
+		}
+		kv, err := nodeToKV(n)
+		if err != nil {
+			return nil, err
+		}
+		resp.Kvs = append(resp.Kvs, kv)
+	}
+	return resp, nil
+}
+
+// kvNodes returns the sorted nodes of the keys in the range [key, end) at
+// the returned store index. If end is empty, the range only holds key.
+func (s *EtcdServer) kvNodes(key, end []byte) ([]*store.NodeExtern, uint64, error) {
+	if len(end) == 0 {
+		e, err := s.store.Get(kvPath(key), false, false)
+		if err != nil {
+			if isKeyNotFound(err) {
+				return nil, s.store.Index(), nil
+			}
+			return nil, 0, err
+		}
+		if e.Node.Dir {
+			return nil, e.EtcdIndex, nil
+		}
+		return []*store.NodeExtern{e.Node}, e.EtcdIndex, nil
+	}
+	e, err := s.store.Get(StoreKVPrefix, false, true)
+	if err != nil {
+		if isKeyNotFound(err) {
+			return nil, s.store.Index(), nil
+		}
+		return nil, 0, err
+	}
+	// the hex encoding keeps the order of the keys
+	start, stop := hex.EncodeToString(key), hex.EncodeToString(end)
+	var nodes []*store.NodeExtern
+	for _, n := range e.Node.Nodes {
+		name := path.Base(n.Key)
+		if n.Dir || name < start {
+			continue
+		}
+		if name >= stop {
+			break
+		}
+		nodes = append(nodes, n)
+	}
+	return nodes, e.EtcdIndex, nil
+}
+
+func kvPath(key []byte) string {
+	return path.Join(StoreKVPrefix, hex.EncodeToString(key))
+}
+
+func nodeToKV(n *store.NodeExtern) (*kvpb.KeyValue, error) {
+	key, err := hex.DecodeString(path.Base(n.Key))
+	if err != nil {
+		return nil, err
+	}
+	kv := &kvpb.KeyValue{Key: key, CreateIndex: n.CreatedIndex, ModIndex: n.ModifiedIndex}
+	if n.Value != nil {
+		kv.Value = []byte(*n.Value)
+	}
+	return kv, nil
+}
+
+// keyRange is the range of keys [start, end).
+type keyRange struct {
+	start, end []byte
+}
+
+// newKeyRange returns the range [key, end), or the range of key only if
+// end is empty.
+func newKeyRange(key, end []byte) keyRange {
+	if len(end) == 0 {
+		// the smallest key after key
+		end = append(append([]byte{}, key...), 0)
+	}
+	return keyRange{start: key, end: end}
+}
+
+func (kr keyRange) overlaps(o keyRange) bool {
+	return bytes.Compare(kr.start, o.end) < 0 && bytes.Compare(o.start, kr.end) < 0
+}
+
+// kvRequests returns the requests of both branches of the KV request r.
+func kvRequests(r pb.Request) []*kvpb.RequestUnion {
+	var req kvpb.TxnRequest
+	if err := req.Unmarshal(r.KV); err != nil {
+		return nil
+	}
+	return append(req.Success, req.Failure...)
+}
+
+// isKVWrite reports whether the KV request r may write the store.
+func isKVWrite(r pb.Request) bool {
+	for _, u := range kvRequests(r) {
+		if u.RequestPut != nil || u.RequestDeleteRange != nil {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,195 @@
+// Copyright 2015 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package etcdserver
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/coreos/etcd/etcdserver/api/kvpb"
+	pb "github.com/coreos/etcd/etcdserver/etcdserverpb"
+	"github.com/coreos/etcd/store"
+)
+
+func applyTestKV(t *testing.T, srv *EtcdServer, r *kvpb.TxnRequest) Response {
+	data, err := r.Marshal()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return srv.applyRequest(pb.Request{Method: "KV", Path: StoreKVPrefix, KV: data})
+}
+
+func kvPut(key, val string) *kvpb.RequestUnion {
+	return &kvpb.RequestUnion{RequestPut: &kvpb.PutRequest{Key: []byte(key), Value: []byte(val)}}
+}
+
+func rangeKeys(resp *kvpb.RangeResponse) []string {
+	var keys []string
+	for _, kv := range resp.Kvs {
+		keys = append(keys, string(kv.Key))
+	}
+	return keys
+}
+
+// TestApplyKVRange tests that a range returns the keys in the byte order
+// of the keys, and stops at the limit.
+func TestApplyKVRange(t *testing.T) {
+	srv := &EtcdServer{store: store.New(), Cluster: newTestCluster(nil)}
+	resp := applyTestKV(t, srv, &kvpb.TxnRequest{Success: []*kvpb.RequestUnion{
+		kvPut("b", "1"), kvPut("a", "2"), kvPut("a\x00", "3"), kvPut("\xff", "4"), kvPut("c/d", "5"),
+	}})
+	if resp.err != nil {
+		t.Fatal(resp.err)
+	}
+
+	tests := []struct {
+		r *kvpb.RangeRequest
+
+		wkeys []string
+		wmore bool
+	}{
+		{&kvpb.RangeRequest{Key: []byte("a")}, []string{"a"}, false},
+		{&kvpb.RangeRequest{Key: []byte("x")}, nil, false},
+		{&kvpb.RangeRequest{Key: []byte("a"), RangeEnd: []byte("c")}, []string{"a", "a\x00", "b"}, false},
+		{&kvpb.RangeRequest{Key: []byte("a"), RangeEnd: []byte("c"), Limit: 2}, []string{"a", "a\x00"}, true},
+		{&kvpb.RangeRequest{Key: []byte("a"), RangeEnd: []byte("c"), Limit: 3}, []string{"a", "a\x00", "b"}, false},
+		{&kvpb.RangeRequest{Key: []byte("\x00"), RangeEnd: []byte("\xff\xff")}, []string{"a", "a\x00", "b", "c/d", "\xff"}, false},
+		// an empty range
+		{&kvpb.RangeRequest{Key: []byte("c"), RangeEnd: []byte("a")}, nil, false},
+	}
+	for i, tt := range tests {
+		rr, err := srv.kvRange(tt.r)
+		if err != nil {
+			t.Fatalf("#%d: %v", i, err)
+		}
+		if g := rangeKeys(rr); !reflect.DeepEqual(g, tt.wkeys) {
+			t.Errorf("#%d: keys = %q, want %q", i, g, tt.wkeys)
+		}
+		if rr.More != tt.wmore {
+			t.Errorf("#%d: more = %v, want %v", i, rr.More, tt.wmore)
+		}
+	}
+}
+
+// TestApplyKVTxn tests that a KV transaction applies the branch chosen by
+// its compares, and returns one response per request of the branch.
+func TestApplyKVTxn(t *testing.T) {
+	srv := &EtcdServer{store: store.New(), Cluster: newTestCluster(nil)}
+	if resp := applyTestKV(t, srv, &kvpb.TxnRequest{Success: []*kvpb.RequestUnion{kvPut("a", "1"), kvPut("b", "2"), kvPut("z", "26")}}); resp.err != nil {
+		t.Fatal(resp.err)
+	}
+
+	resp := applyTestKV(t, srv, &kvpb.TxnRequest{
+		Compare: []*kvpb.Compare{{Target: kvpb.Compare_VALUE, Key: []byte("a"), Value: []byte("1")}},
+		Success: []*kvpb.RequestUnion{
+			{RequestDeleteRange: &kvpb.DeleteRangeRequest{Key: []byte("a"), RangeEnd: []byte("c")}},
+			kvPut("c", "3"),
+			{RequestRange: &kvpb.RangeRequest{Key: []byte("y"), RangeEnd: []byte("zz")}},
+		},
+		Failure: []*kvpb.RequestUnion{kvPut("x", "x")},
+	})
+	if resp.err != nil {
+		t.Fatal(resp.err)
+	}
+	if !resp.KV.Succeeded {
+		t.Fatalf("succeeded = false, want true")
+	}
+	if g := resp.KV.Responses[0].ResponseDeleteRange.Deleted; g != 2 {
+		t.Errorf("deleted = %d, want 2", g)
+	}
+	if resp.KV.Responses[1].ResponsePut == nil {
+		t.Errorf("response = %v, want a put response", resp.KV.Responses[1])
+	}
+	if g := rangeKeys(resp.KV.Responses[2].ResponseRange); !reflect.DeepEqual(g, []string{"z"}) {
+		t.Errorf("keys = %q, want [z]", g)
+	}
+	if g := srv.store.Index(); resp.KV.Header.Index != g {
+		t.Errorf("index = %d, want %d", resp.KV.Header.Index, g)
+	}
+
+	// the compare does not hold any more
+	resp = applyTestKV(t, srv, &kvpb.TxnRequest{
+		Compare: []*kvpb.Compare{{Target: kvpb.Compare_VALUE, Key: []byte("a"), Value: []byte("1")}},
+		Success: []*kvpb.RequestUnion{kvPut("y", "y")},
+		Failure: []*kvpb.RequestUnion{kvPut("x", "x")},
+	})
+	if resp.err != nil {
+		t.Fatal(resp.err)
+	}
+	if resp.KV.Succeeded {
+		t.Errorf("succeeded = true, want false")
+	}
+	rr, err := srv.kvRange(&kvpb.RangeRequest{Key: []byte("a"), RangeEnd: []byte("z")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if g, w := rangeKeys(rr), []string{"c", "x"}; !reflect.DeepEqual(g, w) {
+		t.Errorf("keys = %q, want %q", g, w)
+	}
+}
+
+func TestApplyKVInvalid(t *testing.T) {
+	srv := &EtcdServer{store: store.New(), Cluster: newTestCluster(nil)}
+	tests := []*kvpb.TxnRequest{
+		// no request in the union
+		{Success: []*kvpb.RequestUnion{{}}},
+		// two requests in the union
+		{Failure: []*kvpb.RequestUnion{{RequestPut: &kvpb.PutRequest{Key: []byte("a")}, RequestRange: &kvpb.RangeRequest{Key: []byte("a")}}}},
+		// the same key is put twice
+		{Success: []*kvpb.RequestUnion{kvPut("a", "1"), kvPut("a", "2")}},
+		// the key put is in the range deleted, even if it does not exist yet
+		{Success: []*kvpb.RequestUnion{kvPut("b", "1"), {RequestDeleteRange: &kvpb.DeleteRangeRequest{Key: []byte("a"), RangeEnd: []byte("c")}}}},
+		// the key put is in the range read
+		{Success: []*kvpb.RequestUnion{{RequestRange: &kvpb.RangeRequest{Key: []byte("a"), RangeEnd: []byte("c")}}, kvPut("a", "1")}},
+	}
+	for i, tt := range tests {
+		resp := applyTestKV(t, srv, tt)
+		if resp.err != ErrInvalidKVRequest {
+			t.Errorf("#%d: err = %v, want %v", i, resp.err, ErrInvalidKVRequest)
+		}
+	}
+	if g := srv.store.Index(); g != 0 {
+		t.Errorf("index = %d, want 0", g)
+	}
+
+	// the ranges read may overlap
+	resp := applyTestKV(t, srv, &kvpb.TxnRequest{Success: []*kvpb.RequestUnion{
+		{RequestRange: &kvpb.RangeRequest{Key: []byte("a"), RangeEnd: []byte("c")}},
+		{RequestRange: &kvpb.RangeRequest{Key: []byte("b")}},
+	}})
+	if resp.err != nil {
+		t.Errorf("err = %v, want nil", resp.err)
+	}
+}
+
+func TestNeedsQuotaKV(t *testing.T) {
+	tests := []struct {
+		r *kvpb.TxnRequest
+		w bool
+	}{
+		{&kvpb.TxnRequest{Success: []*kvpb.RequestUnion{{RequestRange: &kvpb.RangeRequest{Key: []byte("a")}}}}, false},
+		{&kvpb.TxnRequest{Success: []*kvpb.RequestUnion{{RequestDeleteRange: &kvpb.DeleteRangeRequest{Key: []byte("a")}}}}, false},
+		{&kvpb.TxnRequest{Failure: []*kvpb.RequestUnion{kvPut("a", "1")}}, true},
+	}
+	for i, tt := range tests {
+		data, err := tt.r.Marshal()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if g := needsQuota(pb.Request{Method: "KV", Path: StoreKVPrefix, KV: data}); g != tt.w {
+			t.Errorf("#%d: needsQuota = %v, want %v", i, g, tt.w)
+		}
+	}
+}
@@ -0,0 +1,63 @@
+// Copyright 2015 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package etcdserver
+
+import (
+	"log"
+	"os"
+	"sync/atomic"
+
+	"github.com/coreos/etcd/raft"
+)
+
+// raftLog is the logger of the raft nodes of the process. Its debug
+// messages are turned on and off by the log level of the runtime config.
+var raftLog = newLevelLogger()
+
+// levelLogger logs the debug messages only while debug is set, which may
+// be changed at any time.
+type levelLogger struct {
+	*raft.DefaultLogger
+	// debug is 1 to log the debug messages. It is accessed atomically.
+	debug int32
+}
+
+func newLevelLogger() *levelLogger {
+	l := &levelLogger{DefaultLogger: &raft.DefaultLogger{Logger: log.New(os.Stderr, "", log.LstdFlags)}}
+	l.DefaultLogger.EnableDebug()
+	return l
+}
+
+func (l *levelLogger) setDebug(debug bool) {
+	var v int32
+	if debug {
+		v = 1
+	}
+	if atomic.SwapInt32(&l.debug, v) != v {
+		log.Printf("etcdserver: raft debug logging enabled = %v", debug)
+	}
+}
+
+func (l *levelLogger) Debug(v ...interface{}) {
+	if atomic.LoadInt32(&l.debug) == 1 {
+		l.DefaultLogger.Debug(v...)
+	}
+}
+
+func (l *levelLogger) Debugf(format string, v ...interface{}) {
+	if atomic.LoadInt32(&l.debug) == 1 {
+		l.DefaultLogger.Debugf(format, v...)
+	}
+}
@@ -0,0 +1,102 @@
+// Copyright 2015 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package etcdserver
+
+import (
+	"log"
+
+	"github.com/coreos/etcd/Godeps/_workspace/src/golang.org/x/net/context"
+	"github.com/coreos/etcd/raft/raftpb"
+	"github.com/coreos/etcd/store"
+)
+
+// closedc is a closed channel, for the results that are ready at once.
+var closedc = func() chan struct{} {
+	c := make(chan struct{})
+	close(c)
+	return c
+}()
+
+// Maintainer runs the maintenance tasks of the local member.
+type Maintainer interface {
+	// Snapshot saves a snapshot of the applied entries and compacts the
+	// raft log without waiting for the snapshot count to be reached. It
+	// returns the index of the latest snapshot.
+	Snapshot(ctx context.Context) (uint64, error)
+	// Backup returns a snapshot of the applied entries, which can be
+	// restored from without the data dir of the member.
+	Backup(ctx context.Context) (raftpb.Snapshot, error)
+	// Export returns the subtree of the store at the store path.
+	Export(ctx context.Context, nodePath string) (*store.Export, error)
+	// Import creates the nodes of the export at the store path.
+	Import(ctx context.Context, nodePath string, ex *store.Export) (*store.Event, error)
+	// Hasher returns the store hashes computed by the hash checks.
+	Hasher
+}
+
+// snapshotResult is the reply of the run loop to a snapshot request.
+type snapshotResult struct {
+	index uint64
+	// done is closed when the snapshot is saved and the raft log is
+	// compacted.
+	done <-chan struct{}
+}
+
+// Snapshot snapshots the store at the applied index and compacts the raft
+// log at once, e.g. to shorten the WAL replay before a planned restart.
+// It returns the index of the snapshot, which is the last snapshot if no
+// entry has been applied since.
+// 立即创建snapshot并压缩raft log，减少重启时WAL的replay时间
+func (s *EtcdServer) Snapshot(ctx context.Context) (uint64, error) {
+	rc := make(chan snapshotResult, 1)
+	select {
+	case s.forceSnapc <- rc:
+	case <-ctx.Done():
+		return 0, parseCtxErr(ctx.Err())
+	case <-s.done:
+		return 0, ErrStopped
+	}
+	var r snapshotResult
+	select {
+	case r = <-rc:
+	case <-ctx.Done():
+		return 0, parseCtxErr(ctx.Err())
+	case <-s.done:
+		return 0, ErrStopped
+	}
+	select {
+	case <-r.done:
+		return r.index, nil
+	case <-ctx.Done():
+		return 0, parseCtxErr(ctx.Err())
+	case <-s.done:
+		return 0, ErrStopped
+	}
+}
+
+// Backup snapshots the store at the applied index and returns the latest
+// snapshot of the raft storage. The snapshot is at least as new as the
+// one just taken, since raft may have received a newer one meanwhile.
+// 在线备份：先创建snapshot，再返回raft storage中最新的snapshot
+func (s *EtcdServer) Backup(ctx context.Context) (raftpb.Snapshot, error) {
+	if _, err := s.Snapshot(ctx); err != nil {
+		return raftpb.Snapshot{}, err
+	}
+	snap, err := s.r.raftStorage.Snapshot()
+	if err != nil {
+		log.Panicf("etcdserver: get snapshot from raft storage error: %v", err)
+	}
+	return snap, nil
+}
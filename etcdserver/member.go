@@ -29,6 +29,10 @@ import (
 	"github.com/coreos/etcd/store"
 )
 
+// ZoneLabel is the label of the zone of a member, which the leader may
+// be preferred in.
+const ZoneLabel = "zone"
+
 // RaftAttributes represents the raft related attributes of an etcd member.
 type RaftAttributes struct {
 	// TODO(philips): ensure these are URLs
@@ -39,6 +43,14 @@ type RaftAttributes struct {
 type Attributes struct {
 	Name       string   `json:"name,omitempty"`
 	ClientURLs []string `json:"clientURLs,omitempty"`
+	// Version is the etcd version of the member. It is published when
+	// the member starts, so it is empty until then, and for the members
+	// older than it.
+	Version string `json:"version,omitempty"`
+	// Labels are the key/value pairs the operator describes the member
+	// with, e.g. its zone or rack. They are published with the other
+	// attributes when the member starts.
+	Labels map[string]string `json:"labels,omitempty"`
 }
 
 // Member表示raft的实例,它掌管着一个Node，并且为client提供服务 
@@ -88,7 +100,8 @@ func (m *Member) Clone() *Member {
 	mm := &Member{
 		ID: m.ID,
 		Attributes: Attributes{
-			Name: m.Name,
+			Name:    m.Name,
+			Version: m.Version,
 		},
 	}
 	if m.PeerURLs != nil {
@@ -99,9 +112,25 @@ func (m *Member) Clone() *Member {
 		mm.ClientURLs = make([]string, len(m.ClientURLs))
 		copy(mm.ClientURLs, m.ClientURLs)
 	}
+	if m.Labels != nil {
+		mm.Labels = make(map[string]string, len(m.Labels))
+		for k, v := range m.Labels {
+			mm.Labels[k] = v
+		}
+	}
 	return mm
 }
 
+// HasLabels reports whether the member has all the given labels.
+func (m *Member) HasLabels(labels map[string]string) bool {
+	for k, v := range labels {
+		if lv, ok := m.Labels[k]; !ok || lv != v {
+			return false
+		}
+	}
+	return true
+}
+
 func memberStoreKey(id types.ID) string {
 	return path.Join(storeMembersPrefix, id.String())
 }
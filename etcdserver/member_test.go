@@ -94,6 +94,7 @@ func TestMemberClone(t *testing.T) {
 		newTestMember(1, []string{"http://a"}, "abc", nil),
 		newTestMember(1, nil, "abc", []string{"http://b"}),
 		newTestMember(1, []string{"http://a"}, "abc", []string{"http://b"}),
+		{ID: 1, Attributes: Attributes{Name: "abc", Version: "2.1.0", Labels: map[string]string{"zone": "a"}}},
 	}
 	for i, tt := range tests {
 		nm := tt.Clone()
@@ -106,6 +107,26 @@ func TestMemberClone(t *testing.T) {
 	}
 }
 
+func TestMemberHasLabels(t *testing.T) {
+	m := &Member{Attributes: Attributes{Labels: map[string]string{"zone": "a", "rack": "r1"}}}
+	tests := []struct {
+		labels map[string]string
+		w      bool
+	}{
+		{nil, true},
+		{map[string]string{"zone": "a"}, true},
+		{map[string]string{"zone": "a", "rack": "r1"}, true},
+		{map[string]string{"zone": "b"}, false},
+		{map[string]string{"zone": "a", "role": "x"}, false},
+		{map[string]string{"zone": ""}, false},
+	}
+	for i, tt := range tests {
+		if g := m.HasLabels(tt.labels); g != tt.w {
+			t.Errorf("#%d: has labels = %t, want %t", i, g, tt.w)
+		}
+	}
+}
+
 func newTestMember(id uint64, peerURLs []string, name string, clientURLs []string) *Member {
 	return &Member{
 		ID:             types.ID(id),
@@ -15,11 +15,10 @@
 package etcdserver
 
 import (
-	"log"
 	"time"
 
 	"github.com/coreos/etcd/Godeps/_workspace/src/github.com/prometheus/client_golang/prometheus"
-	"github.com/coreos/etcd/pkg/runtime"
+	"github.com/coreos/etcd/store"
 )
 
 var (
@@ -38,38 +37,89 @@ var (
 		Name: "etcdserver_proposal_failed_total",
 		Help: "The total number of failed proposals.",
 	})
+	// This is number of proposals rejected because of too many proposals
+	// in flight.
+	proposeRejected = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "etcdserver_proposal_rejected_total",
+		Help: "The total number of proposals rejected for too many proposals in flight.",
+	})
+	// This is number of proposals slower than the slow request threshold,
+	// by the slowest phase of propose, commit and apply.
+	slowRequests = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "etcdserver_slow_requests_total",
+		Help: "The total number of requests slower than the slow request threshold.",
+	},
+		[]string{"phase"},
+	)
+	// This is number of proposals not applied because a request with the
+	// same token was applied before.
+	dedupedRequests = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "etcdserver_proposal_deduplicated_total",
+		Help: "The total number of proposals answered with the result of an earlier request with the same token.",
+	})
+	applyDurations = prometheus.NewSummary(prometheus.SummaryOpts{
+		Name: "etcdserver_apply_durations_microseconds",
+		Help: "The latency distributions of applying committed entries.",
+	})
+
+	// This is number of failed purges of the old snap and wal files, which
+	// are retried.
+	purgeFailed = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "etcdserver_purge_failed_total",
+		Help: "The total number of failed purges of the old snap and wal files.",
+	},
+		[]string{"type"},
+	)
 
 	fileDescriptorUsed = prometheus.NewGauge(prometheus.GaugeOpts{
 		Name: "file_descriptors_used",
 		Help: "The number of file descriptors used",
 	})
+	fileDescriptorLimit = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "file_descriptors_limit",
+		Help: "The limit of the file descriptors of the process.",
+	})
+
+	storeKeyBytes = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "etcdserver_store_key_bytes",
+		Help: "The approximate memory used by the keys of the store.",
+	})
+	storeValueBytes = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "etcdserver_store_value_bytes",
+		Help: "The approximate memory used by the values of the store.",
+	})
+	storeHistoryBytes = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "etcdserver_store_history_bytes",
+		Help: "The approximate memory used by the event history of the store.",
+	})
 )
 
 func init() {
 	prometheus.MustRegister(proposeDurations)
 	prometheus.MustRegister(proposePending)
 	prometheus.MustRegister(proposeFailed)
+	prometheus.MustRegister(proposeRejected)
+	prometheus.MustRegister(slowRequests)
+	prometheus.MustRegister(dedupedRequests)
+	prometheus.MustRegister(applyDurations)
+	prometheus.MustRegister(purgeFailed)
 	prometheus.MustRegister(fileDescriptorUsed)
+	prometheus.MustRegister(fileDescriptorLimit)
+	prometheus.MustRegister(storeKeyBytes)
+	prometheus.MustRegister(storeValueBytes)
+	prometheus.MustRegister(storeHistoryBytes)
 }
 
-func monitorFileDescriptor(done <-chan struct{}) {
+// monitorStoreMemory reports the memory used by the data of the store
+// until done is closed.
+func monitorStoreMemory(st store.Store, done <-chan struct{}) {
 	ticker := time.NewTicker(5 * time.Second)
 	defer ticker.Stop()
 	for {
-		used, err := runtime.FDUsage()
-		if err != nil {
-			log.Printf("etcdserver: cannot monitor file descriptor usage (%v)", err)
-			return
-		}
-		fileDescriptorUsed.Set(float64(used))
-		limit, err := runtime.FDLimit()
-		if err != nil {
-			log.Printf("etcdserver: cannot monitor file descriptor usage (%v)", err)
-			return
-		}
-		if used >= limit/5*4 {
-			log.Printf("etcdserver: 80%% of the file descriptor limit is used [used = %d, limit = %d]", used, limit)
-		}
+		u := st.MemoryUsage()
+		storeKeyBytes.Set(float64(u.KeyBytes))
+		storeValueBytes.Set(float64(u.ValueBytes))
+		storeHistoryBytes.Set(float64(u.HistoryBytes))
 		select {
 		case <-ticker.C:
 		case <-done:
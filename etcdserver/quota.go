@@ -0,0 +1,160 @@
+// Copyright 2015 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package etcdserver
+
+import (
+	"strings"
+	"sync/atomic"
+
+	pb "github.com/coreos/etcd/etcdserver/etcdserverpb"
+)
+
+// DefaultQuotaBackendBytes is the size the store may grow to before the
+// NOSPACE alarm is raised, if no quota is configured.
+const DefaultQuotaBackendBytes = 2 * 1024 * 1024 * 1024
+
+const (
+	// DefaultMaxValueBytes is the max size of a value written by a
+	// client by default.
+	DefaultMaxValueBytes = 1024 * 1024
+	// DefaultMaxRequestBytes is the max size of a client write by
+	// default. It leaves room for a value of the default max size.
+	DefaultMaxRequestBytes = 3 * 512 * 1024
+)
+
+// quotaBackendBytes returns the quota of the store size, or zero if the
+// quota is disabled.
+func (c *ServerConfig) quotaBackendBytes() int64 {
+	switch {
+	case c.QuotaBackendBytes == 0:
+		return DefaultQuotaBackendBytes
+	case c.QuotaBackendBytes < 0:
+		return 0
+	default:
+		return c.QuotaBackendBytes
+	}
+}
+
+// maxValueBytes returns the max size of a value, or zero if the size
+// is unlimited.
+func (c *ServerConfig) maxValueBytes() int64 {
+	switch {
+	case c.MaxValueBytes == 0:
+		return DefaultMaxValueBytes
+	case c.MaxValueBytes < 0:
+		return 0
+	default:
+		return c.MaxValueBytes
+	}
+}
+
+// maxRequestBytes returns the max size of a client write, or zero if
+// the size is unlimited.
+func (c *ServerConfig) maxRequestBytes() int64 {
+	switch {
+	case c.MaxRequestBytes == 0:
+		return DefaultMaxRequestBytes
+	case c.MaxRequestBytes < 0:
+		return 0
+	default:
+		return c.MaxRequestBytes
+	}
+}
+
+// checkRequestSize returns ErrValueTooLarge if a value of the request is
+// beyond the max value size, or ErrRequestTooLarge if the request is
+// beyond the max request size. It is checked before the request is
+// proposed, so that a large write never stalls the replication and the
+// snapshots of the other requests. The internal requests under the admin
+// prefix are never rejected.
+// 在propose之前拒绝过大的value和请求
+func (s *EtcdServer) checkRequestSize(r pb.Request) error {
+	if isAdminPath(r.Path) {
+		return nil
+	}
+	if maxValue := atomic.LoadInt64(&s.maxValueBytes); maxValue > 0 {
+		// the value of an import is the export, whose values are checked
+		// by Import
+		if r.Method != "IMPORT" && int64(len(r.Val)) > maxValue {
+			return ErrValueTooLarge
+		}
+		for _, ops := range [][]pb.Request{r.Success, r.Failure} {
+			for _, op := range ops {
+				if int64(len(op.Val)) > maxValue {
+					return ErrValueTooLarge
+				}
+			}
+		}
+		if r.Method == "KV" {
+			for _, u := range kvRequests(r) {
+				if u.RequestPut != nil && int64(len(u.RequestPut.Value)) > maxValue {
+					return ErrValueTooLarge
+				}
+			}
+		}
+	}
+	if maxRequest := atomic.LoadInt64(&s.maxRequestBytes); maxRequest > 0 && int64(r.Size()) > maxRequest {
+		return ErrRequestTooLarge
+	}
+	return nil
+}
+
+// checkQuota returns ErrNoSpace if the request may grow the store and
+// the NOSPACE alarm is active, or if the request would take the local
+// store beyond the quota. In the latter case the alarm is raised for
+// the whole cluster.
+// 超出quota时通过raft触发NOSPACE告警，整个集群拒绝写请求
+func (s *EtcdServer) checkQuota(r pb.Request) error {
+	if !needsQuota(r) {
+		return nil
+	}
+	if s.Cluster.IsAlarmActive(AlarmNoSpace) {
+		return ErrNoSpace
+	}
+	if quota := atomic.LoadInt64(&s.quota); quota == 0 || s.store.Size()+int64(r.Size()) <= quota {
+		return nil
+	}
+	go s.raiseAlarm(AlarmNoSpace, s.id)
+	return ErrNoSpace
+}
+
+// needsQuota reports whether the request may grow the store. Deletes
+// and the requests under the admin prefix, such as the alarms and the
+// member attributes, are always allowed.
+func needsQuota(r pb.Request) bool {
+	switch r.Method {
+	case "POST", "PUT", "INCR", "MOVE", "IMPORT":
+		return !isAdminPath(r.Path)
+	case "TXN":
+		for _, ops := range [][]pb.Request{r.Success, r.Failure} {
+			for _, op := range ops {
+				if op.Method == "PUT" && !isAdminPath(op.Path) {
+					return true
+				}
+			}
+		}
+	case "KV":
+		for _, u := range kvRequests(r) {
+			if u.RequestPut != nil {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func isAdminPath(p string) bool {
+	return p == StoreAdminPrefix || strings.HasPrefix(p, StoreAdminPrefix+"/")
+}
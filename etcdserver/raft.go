@@ -34,19 +34,20 @@ import (
 )
 
 const (
-	// Number of entries for slow follower to catch-up after compacting
-	// the raft storage entries.
-	// We expect the follower has a millisecond level latency with the leader.
-	// The max throughput is around 10K. Keep a 5K entries is enough for helping
-	// follower to catch up.
-	numberOfCatchUpEntries = 5000
-
+	// DefaultMaxSizePerMsg is the max size of a raft append message by
+	// default.
 	// The max throughput of etcd will not exceed 100MB/s (100K * 1KB value).
 	// Assuming the RTT is around 10ms, 1MB max size is large enough.
-	maxSizePerMsg = 1 * 1024 * 1024
+	DefaultMaxSizePerMsg = 1 * 1024 * 1024
+	// DefaultMaxInflightMsgs is the max number of the in-flight raft append
+	// messages to a follower by default.
 	// Never overflow the rafthttp buffer, which is 4096.
 	// TODO: a better const?
-	maxInflightMsgs = 4096 / 8
+	DefaultMaxInflightMsgs = 4096 / 8
+
+	// The latest entries up to the given size are cached in memory when the
+	// raft log is read back from the WAL.
+	walStorageCacheSize = 16 * 1024 * 1024
 )
 
 var (
@@ -62,19 +63,83 @@ func init() {
 	expvar.Publish("raft.status", expvar.Func(func() interface{} { return raftStatus() }))
 }
 
+// RaftTimer exposes the progress of the raft state machine of the member.
 type RaftTimer interface {
+	// Index returns the index of the last applied entry.
 	Index() uint64
+	// Term returns the term of the last applied entry.
 	Term() uint64
+	// CommittedIndex returns the index of the last entry known to be
+	// committed by the member.
+	CommittedIndex() uint64
+	// AppliedIndex returns the index of the last entry applied to the
+	// store. It is the same as Index.
+	AppliedIndex() uint64
+	// CurrentTerm returns the current raft term of the member, which may
+	// be newer than the term of the last applied entry.
+	CurrentTerm() uint64
 }
 
 // apply contains entries, snapshot be applied.
-// After applied all the items, the application needs
-// to send notification to done chan.
+// The raft routine does not wait for the application; it
+// notifies raftDone once it has saved the ready to disk.
 // 包含需要apply的entries和snap
 type apply struct {
 	entries  []raftpb.Entry
 	snapshot raftpb.Snapshot
-	done     chan struct{}
+	raftDone chan struct{}
+}
+
+// maxSizePerMsg returns the max size of a raft append message.
+func (c *ServerConfig) maxSizePerMsg() uint64 {
+	if c.MaxSizePerMsg == 0 {
+		return DefaultMaxSizePerMsg
+	}
+	return c.MaxSizePerMsg
+}
+
+// maxInflightMsgs returns the max number of the in-flight raft append
+// messages to a follower.
+func (c *ServerConfig) maxInflightMsgs() int {
+	if c.MaxInflightMsgs == 0 {
+		return DefaultMaxInflightMsgs
+	}
+	return c.MaxInflightMsgs
+}
+
+// outOfZoneElectionPriority is the raft election priority of the members
+// outside the preferred leader zone.
+const outOfZoneElectionPriority = 1
+
+// electionPriority returns the raft election priority of the member: the
+// lowest if it is outside the preferred leader zone, or 0, the highest,
+// otherwise.
+// 不在首选zone的member延迟发起选举，使leader倾向于首选zone
+func (c *ServerConfig) electionPriority() int {
+	if c.PreferredLeaderZone == "" || c.Labels[ZoneLabel] == c.PreferredLeaderZone {
+		return 0
+	}
+	return outOfZoneElectionPriority
+}
+
+// logStorage is the raft storage the raftNode appends the log to and
+// compacts by the snapshots: a raft.MemoryStorage, or a wal.Storage.
+type logStorage interface {
+	raft.Storage
+	SetHardState(st raftpb.HardState) error
+	ApplySnapshot(snap raftpb.Snapshot) error
+	CreateSnapshot(i uint64, cs *raftpb.ConfState, data []byte) (raftpb.Snapshot, error)
+	Compact(compactIndex uint64) error
+	Append(entries []raftpb.Entry) error
+}
+
+// newLogStorage returns a wal.Storage reading the raft log back from w if
+// cfg.WALStorage is set, or a raft.MemoryStorage holding all of it otherwise.
+func newLogStorage(cfg *ServerConfig, w *wal.WAL) logStorage {
+	if cfg.WALStorage {
+		return wal.NewStorage(w, walStorageCacheSize)
+	}
+	return raft.NewMemoryStorage()
 }
 
 // 对raft实例的封装
@@ -91,7 +156,7 @@ type raftNode struct {
 
 	// utility
 	ticker      <-chan time.Time
-	raftStorage *raft.MemoryStorage
+	raftStorage logStorage
 	storage     Storage
 	// transport specifies the transport to send and receive msgs to members.
 	// Sending messages MUST NOT block. It is okay to drop messages, since
@@ -99,11 +164,25 @@ type raftNode struct {
 	// If transport is nil, server will panic.
 	transport rafthttp.Transporter
 
+	// syncIntervalc receives the new intervals of the SyncTicker of s.
+	syncIntervalc chan time.Duration
+	// syncTicker is the SyncTicker made for the latest interval received.
+	syncTicker *time.Ticker
+
 	// Cache of the latest raft index and raft term the server has seen
 	// raft最近的index的缓存
 	index uint64
 	term  uint64
 	lead  uint64
+	// Cache of the commit index and the term of the latest hard state
+	// 最近的HardState中的commit index和term的缓存
+	committed   uint64
+	currentTerm uint64
+	// The latest commit index heard from the leader, and when the leader
+	// was last heard from, in unix nanoseconds.
+	// 从leader的心跳和append中得知的commit index，以及最近一次收到的时间
+	leadCommit  uint64
+	leadContact int64
 
 	stopped chan struct{}
 	done    chan struct{}
@@ -115,6 +194,7 @@ func (r *raftNode) run() {
 	r.done = make(chan struct{})
 
 	var syncC <-chan time.Time
+	var islead bool
 
 	defer r.stop()
 	for {
@@ -122,9 +202,13 @@ func (r *raftNode) run() {
 		case <-r.ticker:
 			r.Tick()
 		case rd := <-r.Ready():
+			waslead := islead
 			if rd.SoftState != nil {
 				atomic.StoreUint64(&r.lead, rd.SoftState.Lead)
-				if rd.RaftState == raft.StateLeader {
+				islead = rd.RaftState == raft.StateLeader
+				// only the leader proposes SYNC, the followers expire the
+				// TTL keys when they apply it.
+				if islead {
 					syncC = r.s.SyncTicker
 					// TODO: remove the nil checking
 					// current test utility does not provide the stats
@@ -136,10 +220,16 @@ func (r *raftNode) run() {
 				}
 			}
 
+			if !raft.IsEmptyHardState(rd.HardState) {
+				atomic.StoreUint64(&r.committed, rd.HardState.Commit)
+				atomic.StoreUint64(&r.currentTerm, rd.HardState.Term)
+			}
+
+			raftDone := make(chan struct{}, 1)
 			apply := apply{
 				entries:  rd.CommittedEntries,
 				snapshot: rd.Snapshot,
-				done:     make(chan struct{}),
+				raftDone: raftDone,
 			}
 
 			select {
@@ -147,6 +237,18 @@ func (r *raftNode) run() {
 			case <-r.stopped:
 				return
 			}
+
+			// The leader can write to its disk in parallel with replicating
+			// to the followers and them writing to their disks, see section
+			// 10.2.1 of the raft thesis. The followers must persist before
+			// they send, since their votes and append responses acknowledge
+			// what is on disk. So must a freshly elected leader, whose own
+			// vote is not on disk yet.
+			// leader先发送消息，再与follower并行落盘
+			sendFirst := waslead && islead
+			if sendFirst {
+				r.s.send(rd.Messages)
+			}
 			// 保存snapshot
 			if !raft.IsEmptySnap(rd.Snapshot) {
 				if err := r.storage.SaveSnap(rd.Snapshot); err != nil {
@@ -160,18 +262,44 @@ func (r *raftNode) run() {
 			}
 			r.raftStorage.Append(rd.Entries)
 			// 发送消息给远端peer
-			r.s.send(rd.Messages)
+			if !sendFirst {
+				r.s.send(rd.Messages)
+			}
 
-			<-apply.done
+			raftDone <- struct{}{}
 			r.Advance()
 		case <-syncC:
 			r.s.sync(defaultSyncTimeout)
+		case d := <-r.syncIntervalc:
+			if r.syncTicker != nil {
+				r.syncTicker.Stop()
+				r.syncTicker = nil
+			}
+			r.s.SyncTicker = nil
+			if d > 0 {
+				r.syncTicker = time.NewTicker(d)
+				r.s.SyncTicker = r.syncTicker.C
+			}
+			if islead {
+				syncC = r.s.SyncTicker
+			}
 		case <-r.stopped:
 			return
 		}
 	}
 }
 
+// setSyncInterval changes the interval of the SYNC proposals. Zero
+// disables them. It must be called from one goroutine only.
+func (r *raftNode) setSyncInterval(d time.Duration) {
+	// the interval not received yet is outdated
+	select {
+	case <-r.syncIntervalc:
+	default:
+	}
+	r.syncIntervalc <- d
+}
+
 func (r *raftNode) apply() chan apply {
 	return r.applyc
 }
@@ -198,7 +326,7 @@ func (r *raftNode) resumeSending() {
 
 // 启动状态机实例node,
 // ids为成员id
-func startNode(cfg *ServerConfig, ids []types.ID) (id types.ID, n raft.Node, s *raft.MemoryStorage, w *wal.WAL) {
+func startNode(cfg *ServerConfig, ids []types.ID) (id types.ID, n raft.Node, s logStorage, w *wal.WAL) {
 	var err error
 	member := cfg.Cluster.MemberByName(cfg.Name)
 	metadata := pbutil.MustMarshal(
@@ -210,7 +338,7 @@ func startNode(cfg *ServerConfig, ids []types.ID) (id types.ID, n raft.Node, s *
 	if err := os.MkdirAll(cfg.SnapDir(), privateDirMode); err != nil {
 		log.Fatalf("etcdserver create snapshot directory error: %v", err)
 	}
-	if w, err = wal.Create(cfg.WALDir(), metadata); err != nil {
+	if w, err = wal.CreateEncrypted(cfg.WALDir(), metadata, cfg.Encryption); err != nil {
 		log.Fatalf("etcdserver: create wal error: %v", err)
 	}
 	peers := make([]raft.Peer, len(ids))
@@ -223,14 +351,18 @@ func startNode(cfg *ServerConfig, ids []types.ID) (id types.ID, n raft.Node, s *
 	}
 	id = member.ID
 	log.Printf("etcdserver: start member %s in cluster %s", id, cfg.Cluster.ID())
-	s = raft.NewMemoryStorage()
+	s = newLogStorage(cfg, w)
 	c := &raft.Config{
 		ID:              uint64(id),
 		ElectionTick:    cfg.ElectionTicks,
 		HeartbeatTick:   1,
 		Storage:         s,
-		MaxSizePerMsg:   maxSizePerMsg,
-		MaxInflightMsgs: maxInflightMsgs,
+		MaxSizePerMsg:   cfg.maxSizePerMsg(),
+		MaxInflightMsgs: cfg.maxInflightMsgs(),
+		CheckQuorum:     true,
+		Priority:        cfg.electionPriority(),
+		PreVote:         preVoteEnabled(cfg),
+		Logger:          raftLog,
 	}
 	// 启动一个raft状态机实例Node
 	n = raft.StartNode(c, peers)
@@ -239,16 +371,16 @@ func startNode(cfg *ServerConfig, ids []types.ID) (id types.ID, n raft.Node, s *
 }
 
 // 重启node，
-func restartNode(cfg *ServerConfig, snapshot *raftpb.Snapshot) (types.ID, raft.Node, *raft.MemoryStorage, *wal.WAL) {
+func restartNode(cfg *ServerConfig, snapshot *raftpb.Snapshot) (types.ID, raft.Node, logStorage, *wal.WAL) {
 	var walsnap walpb.Snapshot
 	if snapshot != nil {
 		walsnap.Index, walsnap.Term = snapshot.Metadata.Index, snapshot.Metadata.Term
 	}
-	w, id, cid, st, ents := readWAL(cfg.WALDir(), walsnap)
+	w, id, cid, st, ents := readWAL(cfg, walsnap)
 	cfg.Cluster.SetID(cid)
 
 	log.Printf("etcdserver: restart member %s in cluster %s at commit index %d", id, cfg.Cluster.ID(), st.Commit)
-	s := raft.NewMemoryStorage()
+	s := newLogStorage(cfg, w)
 	if snapshot != nil {
 		s.ApplySnapshot(*snapshot)
 	}
@@ -259,20 +391,24 @@ func restartNode(cfg *ServerConfig, snapshot *raftpb.Snapshot) (types.ID, raft.N
 		ElectionTick:    cfg.ElectionTicks,
 		HeartbeatTick:   1,
 		Storage:         s,
-		MaxSizePerMsg:   maxSizePerMsg,
-		MaxInflightMsgs: maxInflightMsgs,
+		MaxSizePerMsg:   cfg.maxSizePerMsg(),
+		MaxInflightMsgs: cfg.maxInflightMsgs(),
+		CheckQuorum:     true,
+		Priority:        cfg.electionPriority(),
+		PreVote:         preVoteEnabled(cfg),
+		Logger:          raftLog,
 	}
 	n := raft.RestartNode(c)
 	raftStatus = n.Status
 	return id, n, s, w
 }
 
-func restartAsStandaloneNode(cfg *ServerConfig, snapshot *raftpb.Snapshot) (types.ID, raft.Node, *raft.MemoryStorage, *wal.WAL) {
+func restartAsStandaloneNode(cfg *ServerConfig, snapshot *raftpb.Snapshot) (types.ID, raft.Node, logStorage, *wal.WAL) {
 	var walsnap walpb.Snapshot
 	if snapshot != nil {
 		walsnap.Index, walsnap.Term = snapshot.Metadata.Index, snapshot.Metadata.Term
 	}
-	w, id, cid, st, ents := readWAL(cfg.WALDir(), walsnap)
+	w, id, cid, st, ents := readWAL(cfg, walsnap)
 	cfg.Cluster.SetID(cid)
 
 	// discard the previously uncommitted entries
@@ -298,7 +434,7 @@ func restartAsStandaloneNode(cfg *ServerConfig, snapshot *raftpb.Snapshot) (type
 	}
 
 	log.Printf("etcdserver: forcing restart of member %s in cluster %s at commit index %d", id, cfg.Cluster.ID(), st.Commit)
-	s := raft.NewMemoryStorage()
+	s := newLogStorage(cfg, w)
 	if snapshot != nil {
 		s.ApplySnapshot(*snapshot)
 	}
@@ -309,8 +445,12 @@ func restartAsStandaloneNode(cfg *ServerConfig, snapshot *raftpb.Snapshot) (type
 		ElectionTick:    cfg.ElectionTicks,
 		HeartbeatTick:   1,
 		Storage:         s,
-		MaxSizePerMsg:   maxSizePerMsg,
-		MaxInflightMsgs: maxInflightMsgs,
+		MaxSizePerMsg:   cfg.maxSizePerMsg(),
+		MaxInflightMsgs: cfg.maxInflightMsgs(),
+		CheckQuorum:     true,
+		Priority:        cfg.electionPriority(),
+		PreVote:         preVoteEnabled(cfg),
+		Logger:          raftLog,
 	}
 	n := raft.RestartNode(c)
 	raftStatus = n.Status
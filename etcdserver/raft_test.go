@@ -20,8 +20,11 @@ import (
 	"testing"
 
 	"github.com/coreos/etcd/pkg/pbutil"
+	"github.com/coreos/etcd/pkg/testutil"
 	"github.com/coreos/etcd/pkg/types"
+	"github.com/coreos/etcd/raft"
 	"github.com/coreos/etcd/raft/raftpb"
+	"github.com/coreos/etcd/store"
 )
 
 func TestGetIDs(t *testing.T) {
@@ -141,3 +144,123 @@ func TestCreateConfigChangeEnts(t *testing.T) {
 		}
 	}
 }
+
+// TestSendAndSaveOrder tests that an elected leader sends out its messages
+// before it saves the ready to the disk, while the followers and a freshly
+// elected leader save before they send.
+func TestSendAndSaveOrder(t *testing.T) {
+	follower := &raft.SoftState{Lead: 2, RaftState: raft.StateFollower}
+	leader := &raft.SoftState{Lead: 2, RaftState: raft.StateLeader}
+	msgs := []raftpb.Message{{From: 1, To: 2, Type: raftpb.MsgApp}}
+	tests := []struct {
+		rds      []raft.Ready
+		wactions []testutil.Action
+	}{
+		{
+			[]raft.Ready{{SoftState: follower, Messages: msgs}},
+			[]testutil.Action{{Name: "Save"}, {Name: "Send"}},
+		},
+		{
+			[]raft.Ready{{SoftState: leader, Messages: msgs}},
+			[]testutil.Action{{Name: "Save"}, {Name: "Send"}},
+		},
+		{
+			[]raft.Ready{{SoftState: leader}, {Messages: msgs}},
+			[]testutil.Action{{Name: "Save"}, {Name: "Send"}, {Name: "Save"}},
+		},
+		{
+			[]raft.Ready{{SoftState: leader}, {SoftState: follower, Messages: msgs}},
+			[]testutil.Action{{Name: "Save"}, {Name: "Save"}, {Name: "Send"}},
+		},
+	}
+	for i, tt := range tests {
+		n := newReadyNode()
+		r := &orderRecorder{}
+		cl := newCluster("abc")
+		cl.SetStore(store.New())
+		cl.SetTransport(&nopTransporter{})
+		s := &EtcdServer{
+			r: raftNode{
+				Node:        n,
+				transport:   r,
+				storage:     r,
+				raftStorage: raft.NewMemoryStorage(),
+			},
+			store:   &storeRecorder{},
+			Cluster: cl,
+		}
+		s.start()
+		for _, rd := range tt.rds {
+			n.readyc <- rd
+		}
+		// make goroutines move forward to handle the readies
+		testutil.ForceGosched()
+		s.Stop()
+
+		if g := r.Action(); !reflect.DeepEqual(g, tt.wactions) {
+			t.Errorf("#%d: action = %v, want %v", i, g, tt.wactions)
+		}
+	}
+}
+
+// orderRecorder records the sends and the saves of a raftNode in the
+// order they happen.
+type orderRecorder struct {
+	nopTransporter
+	testutil.Recorder
+}
+
+func (r *orderRecorder) Send(m []raftpb.Message) {
+	if len(m) != 0 {
+		r.Record(testutil.Action{Name: "Send"})
+	}
+}
+func (r *orderRecorder) Save(st raftpb.HardState, ents []raftpb.Entry) error {
+	r.Record(testutil.Action{Name: "Save"})
+	return nil
+}
+func (r *orderRecorder) SaveSnap(st raftpb.Snapshot) error { return nil }
+func (r *orderRecorder) Close() error                      { return nil }
+
+func TestRaftFlowControlConfig(t *testing.T) {
+	tests := []struct {
+		cfg ServerConfig
+
+		wsize     uint64
+		winflight int
+	}{
+		{ServerConfig{}, DefaultMaxSizePerMsg, DefaultMaxInflightMsgs},
+		{ServerConfig{MaxSizePerMsg: 4096, MaxInflightMsgs: 1024}, 4096, 1024},
+	}
+	for i, tt := range tests {
+		if g := tt.cfg.maxSizePerMsg(); g != tt.wsize {
+			t.Errorf("#%d: maxSizePerMsg = %d, want %d", i, g, tt.wsize)
+		}
+		if g := tt.cfg.maxInflightMsgs(); g != tt.winflight {
+			t.Errorf("#%d: maxInflightMsgs = %d, want %d", i, g, tt.winflight)
+		}
+	}
+}
+
+func TestElectionPriority(t *testing.T) {
+	tests := []struct {
+		zone   string
+		labels map[string]string
+
+		w int
+	}{
+		{"", nil, 0},
+		{"", map[string]string{ZoneLabel: "a"}, 0},
+		{"a", map[string]string{ZoneLabel: "a"}, 0},
+		{"a", map[string]string{ZoneLabel: "b"}, outOfZoneElectionPriority},
+		// a member without a zone is outside the preferred one
+		{"a", map[string]string{"rack": "r1"}, outOfZoneElectionPriority},
+		{"a", nil, outOfZoneElectionPriority},
+	}
+	for i, tt := range tests {
+		cfg := &ServerConfig{PreferredLeaderZone: tt.zone, Labels: tt.labels}
+		if g := cfg.electionPriority(); g != tt.w {
+			t.Errorf("#%d: priority = %d, want %d", i, g, tt.w)
+		}
+	}
+}
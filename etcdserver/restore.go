@@ -0,0 +1,152 @@
+// Copyright 2015 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package etcdserver
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path"
+
+	pb "github.com/coreos/etcd/etcdserver/etcdserverpb"
+	"github.com/coreos/etcd/pkg/pbutil"
+	"github.com/coreos/etcd/pkg/types"
+	"github.com/coreos/etcd/raft/raftpb"
+	"github.com/coreos/etcd/snap"
+	"github.com/coreos/etcd/store"
+	"github.com/coreos/etcd/wal"
+	"github.com/coreos/etcd/wal/walpb"
+)
+
+// VerifyRestore sanity-checks the config for restoring a new cluster from
+// a snapshot file.
+func (c *ServerConfig) VerifyRestore() error {
+	if err := c.VerifyBootstrap(); err != nil {
+		return err
+	}
+	if n := len(c.Cluster.Members()); n != 1 {
+		return fmt.Errorf("initial cluster has %d members, want 1 to restore from snapshot", n)
+	}
+	if c.DiscoveryURL != "" {
+		return fmt.Errorf("discovery URL should not be set when restoring from snapshot")
+	}
+	return nil
+}
+
+// restoreFromSnapshot initializes the data dir of a new single-member
+// cluster from the snapshot file given by cfg.RestoreSnapshot. The members
+// and the alarms in the snapshot are replaced by the local member, and the
+// cluster gets the ID of the initial cluster, so that the restored member
+// never talks to the members of the original cluster. It returns the
+// snapshot saved in the data dir, which the member restarts from.
+// 从snapshot文件恢复出一个新的单节点集群，重写member信息和cluster ID
+func restoreFromSnapshot(cfg *ServerConfig, st store.Store, ss *snap.Snapshotter) (*raftpb.Snapshot, error) {
+	snapshot, err := snap.Read(cfg.RestoreSnapshot)
+	if err != nil {
+		return nil, fmt.Errorf("cannot read snapshot %s: %v", cfg.RestoreSnapshot, err)
+	}
+	if err := st.Recovery(snapshot.Data); err != nil {
+		return nil, fmt.Errorf("cannot recover store from snapshot %s: %v", cfg.RestoreSnapshot, err)
+	}
+	log.Printf("etcdserver: recovered store from snapshot %s at index %d", cfg.RestoreSnapshot, snapshot.Metadata.Index)
+
+	m := cfg.Cluster.MemberByName(cfg.Name)
+	for _, p := range []string{storeMembersPrefix, storeRemovedMembersPrefix, storeAlarmsPrefix} {
+		if _, err := st.Delete(p, true, true); err != nil && !isKeyNotFound(err) {
+			log.Panicf("delete %s should never fail: %v", p, err)
+		}
+	}
+	mustSaveMemberToStore(st, m)
+
+	d, err := st.Save()
+	if err != nil {
+		log.Panicf("etcdserver: store save should never fail: %v", err)
+	}
+	newsnap := raftpb.Snapshot{
+		Data: d,
+		Metadata: raftpb.SnapshotMetadata{
+			ConfState: raftpb.ConfState{Nodes: []uint64{uint64(m.ID)}},
+			Index:     snapshot.Metadata.Index,
+			Term:      snapshot.Metadata.Term,
+		},
+	}
+	if err := initMemberDir(cfg, ss, newsnap, m.ID, cfg.Cluster.ID()); err != nil {
+		return nil, err
+	}
+	log.Printf("etcdserver: restored member %s in cluster %s from snapshot at index %d", m.ID, cfg.Cluster.ID(), newsnap.Metadata.Index)
+	return &newsnap, nil
+}
+
+// InitMemberDirFromSnapshot initializes the member dir under cfg.DataDir,
+// and the WAL in cfg.WALDir(), for the member id of the cluster cid, so
+// that the member restarts from the snapshot instead of an empty store,
+// and catches up with the cluster through the raft log. Only the dirs and
+// the encryption of cfg are used. It is used to start a promoted standby.
+// 用snapshot初始化member目录，用于standby提升为member
+func InitMemberDirFromSnapshot(cfg *ServerConfig, snapshot raftpb.Snapshot, id, cid types.ID) error {
+	if wal.Exist(cfg.WALDir()) {
+		return fmt.Errorf("member dir %s has been initialized already", cfg.MemberDir())
+	}
+	return initMemberDir(cfg, snap.NewEncrypted(cfg.SnapDir(), cfg.Encryption), snapshot, id, cid)
+}
+
+// initMemberDir saves the snapshot, and creates a WAL for the member id of
+// the cluster cid that starts at the snapshot.
+func initMemberDir(cfg *ServerConfig, ss *snap.Snapshotter, snapshot raftpb.Snapshot, id, cid types.ID) error {
+	if err := os.MkdirAll(cfg.SnapDir(), privateDirMode); err != nil {
+		return fmt.Errorf("cannot create snapshot dir %s: %v", cfg.SnapDir(), err)
+	}
+	if err := ss.SaveSnap(snapshot); err != nil {
+		return err
+	}
+
+	metadata := pbutil.MustMarshal(
+		&pb.Metadata{
+			NodeID:    uint64(id),
+			ClusterID: uint64(cid),
+		},
+	)
+	w, err := wal.CreateEncrypted(cfg.WALDir(), metadata, cfg.Encryption)
+	if err != nil {
+		return err
+	}
+	defer w.Close()
+	walsnap := walpb.Snapshot{Index: snapshot.Metadata.Index, Term: snapshot.Metadata.Term}
+	if err := w.SaveSnapshot(walsnap); err != nil {
+		return err
+	}
+	hs := raftpb.HardState{Term: snapshot.Metadata.Term, Commit: snapshot.Metadata.Index}
+	return w.Save(hs, nil)
+}
+
+// mustSaveMemberToStore saves the attributes of the member to the store.
+func mustSaveMemberToStore(st store.Store, m *Member) {
+	b, err := json.Marshal(m.RaftAttributes)
+	if err != nil {
+		log.Panicf("marshal raftAttributes should never fail: %v", err)
+	}
+	p := path.Join(memberStoreKey(m.ID), raftAttributesSuffix)
+	if _, err := st.Set(p, false, string(b), store.Permanent); err != nil {
+		log.Panicf("set raftAttributes should never fail: %v", err)
+	}
+	b, err = json.Marshal(m.Attributes)
+	if err != nil {
+		log.Panicf("marshal attributes should never fail: %v", err)
+	}
+	if _, err := st.Set(MemberAttributesStorePath(m.ID), false, string(b), store.Permanent); err != nil {
+		log.Panicf("set attributes should never fail: %v", err)
+	}
+}
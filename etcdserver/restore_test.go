@@ -0,0 +1,153 @@
+// Copyright 2015 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package etcdserver
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"reflect"
+	"testing"
+
+	"github.com/coreos/etcd/pkg/types"
+	"github.com/coreos/etcd/raft/raftpb"
+	"github.com/coreos/etcd/snap"
+	"github.com/coreos/etcd/store"
+	"github.com/coreos/etcd/wal/walpb"
+)
+
+func TestRestoreFromSnapshot(t *testing.T) {
+	dir, err := ioutil.TempDir(os.TempDir(), "etcdserver")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// the snapshot of a three-member cluster with an alarm raised
+	old := store.New(StoreAdminPrefix, StoreKeysPrefix)
+	for _, id := range []types.ID{1, 2, 3} {
+		mustSaveMemberToStore(old, &Member{ID: id, RaftAttributes: RaftAttributes{PeerURLs: []string{"http://10.0.0.1:2380"}}})
+	}
+	old.Create(removedMemberStoreKey(4), false, "", false, store.Permanent)
+	old.Set(alarmStorePath(AlarmNoSpace, 1), false, "", store.Permanent)
+	old.Set(path.Join(StoreKeysPrefix, "foo"), false, "bar", store.Permanent)
+	d, err := old.Save()
+	if err != nil {
+		t.Fatal(err)
+	}
+	fname := path.Join(dir, "backup.snap")
+	f, err := os.Create(fname)
+	if err != nil {
+		t.Fatal(err)
+	}
+	oldsnap := raftpb.Snapshot{
+		Data:     d,
+		Metadata: raftpb.SnapshotMetadata{ConfState: raftpb.ConfState{Nodes: []uint64{1, 2, 3}}, Index: 10, Term: 2},
+	}
+	if err = snap.Write(f, oldsnap); err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+
+	cl, err := NewClusterFromString("restored", "node1=http://127.0.0.1:2380")
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := cl.MemberByName("node1")
+	cfg := &ServerConfig{
+		Name:            "node1",
+		PeerURLs:        mustNewURLs(t, []string{"http://127.0.0.1:2380"}),
+		DataDir:         path.Join(dir, "data"),
+		Cluster:         cl,
+		RestoreSnapshot: fname,
+	}
+	if err = cfg.VerifyRestore(); err != nil {
+		t.Fatalf("unexpected VerifyRestore error: %v", err)
+	}
+	st := store.New(StoreAdminPrefix, StoreKeysPrefix)
+	ss := snap.New(cfg.SnapDir())
+	newsnap, err := restoreFromSnapshot(cfg, st, ss)
+	if err != nil {
+		t.Fatalf("unexpected restore error: %v", err)
+	}
+
+	if w := []types.ID{m.ID}; !reflect.DeepEqual(NewClusterFromStore("", st).MemberIDs(), w) {
+		t.Errorf("members = %v, want %v", NewClusterFromStore("", st).MemberIDs(), w)
+	}
+	if _, err = st.Get(storeRemovedMembersPrefix, false, false); !isKeyNotFound(err) {
+		t.Errorf("err = %v, want key not found", err)
+	}
+	if _, err = st.Get(storeAlarmsPrefix, false, false); !isKeyNotFound(err) {
+		t.Errorf("err = %v, want key not found", err)
+	}
+	ev, err := st.Get(path.Join(StoreKeysPrefix, "foo"), false, false)
+	if err != nil {
+		t.Fatalf("unexpected get error: %v", err)
+	}
+	if *ev.Node.Value != "bar" {
+		t.Errorf("value = %s, want bar", *ev.Node.Value)
+	}
+
+	wmeta := raftpb.SnapshotMetadata{ConfState: raftpb.ConfState{Nodes: []uint64{uint64(m.ID)}}, Index: 10, Term: 2}
+	if !reflect.DeepEqual(newsnap.Metadata, wmeta) {
+		t.Errorf("metadata = %+v, want %+v", newsnap.Metadata, wmeta)
+	}
+	saved, err := ss.Load()
+	if err != nil {
+		t.Fatalf("unexpected load error: %v", err)
+	}
+	if !reflect.DeepEqual(saved, newsnap) {
+		t.Errorf("saved snapshot = %+v, want %+v", saved.Metadata, newsnap.Metadata)
+	}
+	w, id, cid, hs, ents := readWAL(cfg, walpb.Snapshot{Index: 10, Term: 2})
+	w.Close()
+	if id != m.ID {
+		t.Errorf("id = %s, want %s", id, m.ID)
+	}
+	if cid != cl.ID() {
+		t.Errorf("cluster id = %s, want %s", cid, cl.ID())
+	}
+	if whs := (raftpb.HardState{Term: 2, Commit: 10}); !reflect.DeepEqual(hs, whs) {
+		t.Errorf("hardstate = %+v, want %+v", hs, whs)
+	}
+	if len(ents) != 0 {
+		t.Errorf("len(ents) = %d, want 0", len(ents))
+	}
+}
+
+func TestConfigVerifyRestoreFail(t *testing.T) {
+	tests := []struct {
+		cluster string
+		durl    string
+	}{
+		{"node1=http://127.0.0.1:2380,node2=http://127.0.0.1:2381", ""},
+		{"node1=http://127.0.0.1:2380", "http://discovery.example.com/token"},
+	}
+	for i, tt := range tests {
+		cl, err := NewClusterFromString("", tt.cluster)
+		if err != nil {
+			t.Fatal(err)
+		}
+		cfg := &ServerConfig{
+			Name:         "node1",
+			Cluster:      cl,
+			DiscoveryURL: tt.durl,
+			PeerURLs:     mustNewURLs(t, []string{"http://127.0.0.1:2380"}),
+		}
+		if err := cfg.VerifyRestore(); err == nil {
+			t.Errorf("#%d: err = nil, want not nil", i)
+		}
+	}
+}
@@ -0,0 +1,271 @@
+// Copyright 2015 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package etcdserver
+
+import (
+	"encoding/json"
+	"log"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/coreos/etcd/Godeps/_workspace/src/golang.org/x/net/context"
+	pb "github.com/coreos/etcd/etcdserver/etcdserverpb"
+	"github.com/coreos/etcd/pkg/types"
+	"github.com/coreos/etcd/store"
+)
+
+const (
+	// LogLevelInfo logs the messages of the member except the debug
+	// messages of raft.
+	LogLevelInfo = "info"
+	// LogLevelDebug logs the debug messages of raft as well.
+	LogLevelDebug = "debug"
+)
+
+var storeRuntimeConfigsPrefix = path.Join(StoreAdminPrefix, "runtime_configs")
+
+// RuntimeConfig holds the settings of a member that may be changed at
+// runtime, without restarting it. A nil or empty setting is left as given
+// by the flags.
+type RuntimeConfig struct {
+	MemberID types.ID `json:"-"`
+	// SnapCount is the number of the applied entries after which a
+	// snapshot is taken.
+	SnapCount *uint64 `json:"snapCount,omitempty"`
+	// SyncIntervalMs is the interval of the SYNC proposals of the leader,
+	// which expire the TTL keys. Zero disables them.
+	SyncIntervalMs *int64 `json:"syncIntervalMs,omitempty"`
+	// SlowRequestThresholdMs is the time a proposed request may take
+	// before it is logged as slow. Zero disables the log.
+	SlowRequestThresholdMs *int64 `json:"slowRequestThresholdMs,omitempty"`
+	// LogLevel is LogLevelInfo or LogLevelDebug.
+	LogLevel string `json:"logLevel,omitempty"`
+}
+
+// RuntimeConfiger lists and changes the runtime configs of the members.
+type RuntimeConfiger interface {
+	// RuntimeConfigs returns the runtime configs sorted by member ID.
+	RuntimeConfigs() []RuntimeConfig
+	// SetRuntimeConfig merges the settings of c into the runtime config
+	// of the member c.MemberID.
+	SetRuntimeConfig(ctx context.Context, c RuntimeConfig) error
+	// RemoveRuntimeConfig removes the runtime config of the member, so
+	// that it runs as given by its flags again.
+	RemoveRuntimeConfig(ctx context.Context, id types.ID) error
+}
+
+// runtimeConfigStorePath returns the store path of the runtime config of
+// the member. The runtime configs are kept in the store, so they go
+// through raft, are recovered from the snapshots and survive the
+// restarts.
+// 运行时配置保存在store里，通过raft同步，重启后依然有效
+func runtimeConfigStorePath(id types.ID) string {
+	return path.Join(storeRuntimeConfigsPrefix, id.String())
+}
+
+// merge returns c with the settings of o set over its own.
+func (c RuntimeConfig) merge(o RuntimeConfig) RuntimeConfig {
+	if o.SnapCount != nil {
+		c.SnapCount = o.SnapCount
+	}
+	if o.SyncIntervalMs != nil {
+		c.SyncIntervalMs = o.SyncIntervalMs
+	}
+	if o.SlowRequestThresholdMs != nil {
+		c.SlowRequestThresholdMs = o.SlowRequestThresholdMs
+	}
+	if o.LogLevel != "" {
+		c.LogLevel = o.LogLevel
+	}
+	return c
+}
+
+func (c RuntimeConfig) valid() bool {
+	if c.SnapCount != nil && *c.SnapCount == 0 {
+		return false
+	}
+	if c.SyncIntervalMs != nil && *c.SyncIntervalMs < 0 {
+		return false
+	}
+	if c.SlowRequestThresholdMs != nil && *c.SlowRequestThresholdMs < 0 {
+		return false
+	}
+	switch c.LogLevel {
+	case "", LogLevelInfo, LogLevelDebug:
+		return true
+	default:
+		return false
+	}
+}
+
+// runtimeConfigSet keeps the runtime configs of the members in memory.
+// The zero value is an empty set.
+type runtimeConfigSet struct {
+	mu      sync.RWMutex
+	configs map[types.ID]RuntimeConfig
+}
+
+// recover reloads the runtime configs from the store.
+func (cs *runtimeConfigSet) recover(st store.Store) {
+	configs := runtimeConfigsFromStore(st)
+	cs.mu.Lock()
+	defer cs.mu.Unlock()
+	cs.configs = configs
+}
+
+func (cs *runtimeConfigSet) get(id types.ID) RuntimeConfig {
+	cs.mu.RLock()
+	defer cs.mu.RUnlock()
+	c, ok := cs.configs[id]
+	if !ok {
+		c.MemberID = id
+	}
+	return c
+}
+
+func (cs *runtimeConfigSet) list() []RuntimeConfig {
+	cs.mu.RLock()
+	defer cs.mu.RUnlock()
+	var configs []RuntimeConfig
+	for _, c := range cs.configs {
+		configs = append(configs, c)
+	}
+	sort.Sort(runtimeConfigsByID(configs))
+	return configs
+}
+
+func runtimeConfigsFromStore(st store.Store) map[types.ID]RuntimeConfig {
+	configs := make(map[types.ID]RuntimeConfig)
+	e, err := st.Get(storeRuntimeConfigsPrefix, false, false)
+	if err != nil {
+		if isKeyNotFound(err) {
+			return configs
+		}
+		log.Panicf("get storeRuntimeConfigs should never fail: %v", err)
+	}
+	for _, n := range e.Node.Nodes {
+		id, err := types.IDFromString(path.Base(n.Key))
+		if err != nil {
+			log.Panicf("unexpected runtime config key %s: %v", n.Key, err)
+		}
+		c := RuntimeConfig{MemberID: id}
+		if err := json.Unmarshal([]byte(*n.Value), &c); err != nil {
+			log.Panicf("unmarshal runtime config %s should never fail: %v", n.Key, err)
+		}
+		configs[id] = c
+	}
+	return configs
+}
+
+type runtimeConfigsByID []RuntimeConfig
+
+func (a runtimeConfigsByID) Len() int           { return len(a) }
+func (a runtimeConfigsByID) Swap(i, j int)      { a[i], a[j] = a[j], a[i] }
+func (a runtimeConfigsByID) Less(i, j int) bool { return a[i].MemberID < a[j].MemberID }
+
+// RuntimeConfigs returns the runtime configs of the members.
+func (s *EtcdServer) RuntimeConfigs() []RuntimeConfig { return s.Cluster.RuntimeConfigs() }
+
+// SetRuntimeConfig merges the settings of c into the runtime config of
+// the member through consensus. It returns ErrIDNotFound if there is no
+// such member, or ErrInvalidRuntimeConfig if a setting is not valid.
+func (s *EtcdServer) SetRuntimeConfig(ctx context.Context, c RuntimeConfig) error {
+	if s.Cluster.Member(c.MemberID) == nil {
+		return ErrIDNotFound
+	}
+	if !c.valid() {
+		return ErrInvalidRuntimeConfig
+	}
+	c = s.Cluster.RuntimeConfig(c.MemberID).merge(c)
+	b, err := json.Marshal(c)
+	if err != nil {
+		log.Panicf("marshal runtime config should never fail: %v", err)
+	}
+	req := pb.Request{
+		Method: "PUT",
+		Path:   runtimeConfigStorePath(c.MemberID),
+		Val:    string(b),
+	}
+	if _, err := s.Do(ctx, req); err != nil {
+		return err
+	}
+	log.Printf("etcdserver: set runtime config of member %s to %s", c.MemberID, b)
+	return nil
+}
+
+// RemoveRuntimeConfig removes the runtime config of the member through
+// consensus. It returns nil if the member has no runtime config.
+func (s *EtcdServer) RemoveRuntimeConfig(ctx context.Context, id types.ID) error {
+	req := pb.Request{
+		Method: "DELETE",
+		Path:   runtimeConfigStorePath(id),
+	}
+	_, err := s.Do(ctx, req)
+	if err != nil && !isKeyNotFound(err) {
+		return err
+	}
+	log.Printf("etcdserver: removed runtime config of member %s", id)
+	return nil
+}
+
+// isRuntimeConfigRequest reports whether the request changes the runtime
+// configs of the members.
+func isRuntimeConfigRequest(r pb.Request) bool {
+	return r.Path == storeRuntimeConfigsPrefix || strings.HasPrefix(r.Path, storeRuntimeConfigsPrefix+"/")
+}
+
+// flagRuntimeConfig returns the runtime config given by the flags, which
+// the runtime config of the member overrides.
+func flagRuntimeConfig(cfg *ServerConfig) RuntimeConfig {
+	snapCount := cfg.SnapCount
+	syncMs := int64(cfg.syncInterval() / time.Millisecond)
+	slowMs := int64(cfg.SlowRequestThreshold / time.Millisecond)
+	return RuntimeConfig{
+		SnapCount:              &snapCount,
+		SyncIntervalMs:         &syncMs,
+		SlowRequestThresholdMs: &slowMs,
+		LogLevel:               LogLevelInfo,
+	}
+}
+
+// applyRuntimeConfig applies the runtime config of the local member over
+// the flags. It is called when the runtime configs change, and after a
+// snapshot is applied.
+// 应用本member的运行时配置，未设置的项使用启动参数
+func (s *EtcdServer) applyRuntimeConfig() {
+	c := s.flagConfig.merge(s.Cluster.RuntimeConfig(s.id))
+	if c.SnapCount != nil {
+		s.snapCount = *c.SnapCount
+		if s.snapCount == 0 {
+			s.snapCount = DefaultSnapCount
+		}
+	}
+	if c.SyncIntervalMs != nil {
+		if d := time.Duration(*c.SyncIntervalMs) * time.Millisecond; d != s.syncInterval {
+			s.syncInterval = d
+			s.r.setSyncInterval(d)
+		}
+	}
+	if c.SlowRequestThresholdMs != nil {
+		atomic.StoreInt64(&s.slowThreshold, int64(time.Duration(*c.SlowRequestThresholdMs)*time.Millisecond))
+	}
+	if c.LogLevel != "" {
+		raftLog.setDebug(c.LogLevel == LogLevelDebug)
+	}
+}
@@ -30,7 +30,8 @@ import (
 
 const (
 	// StorePermsPrefix is the internal prefix of the storage layer dedicated to storing user data.
-	StorePermsPrefix = "/2"
+	// It is under the admin prefix, so the keys API cannot reach it.
+	StorePermsPrefix = etcdserver.StoreAdminPrefix + "/security"
 )
 
 type doer interface {
@@ -40,7 +41,6 @@ type doer interface {
 type Store struct {
 	server  doer
 	timeout time.Duration
-	enabled bool
 }
 
 type User struct {
@@ -78,12 +78,10 @@ func mergeErr(s string, v ...interface{}) MergeError {
 }
 
 func NewStore(server doer, timeout time.Duration) *Store {
-	s := &Store{
+	return &Store{
 		server:  server,
 		timeout: timeout,
 	}
-	s.enabled = s.detectSecurity()
-	return s
 }
 
 func (s *Store) AllUsers() ([]string, error) {
@@ -230,30 +228,36 @@ func (s *Store) UpdateRole(role Role) (Role, error) {
 	return newRole, nil
 }
 
+// SecurityEnabled reports whether security is enabled, which is the case
+// when the root user exists. It is read from the store, so that all the
+// members agree on it.
 func (s *Store) SecurityEnabled() bool {
-	return s.enabled
+	return s.detectSecurity()
 }
 
+// EnableSecurity enables security by creating the root user.
 func (s *Store) EnableSecurity(rootUser User) error {
+	if rootUser.User != "root" {
+		return mergeErr("Trying to create root user not named root")
+	}
+	if s.SecurityEnabled() {
+		return mergeErr("Security is already enabled")
+	}
 	err := s.ensureSecurityDirectories()
 	if err != nil {
 		return err
 	}
-	if rootUser.User != "root" {
-		mergeErr("Trying to create root user not named root")
-	}
-	err = s.createUserInternal(rootUser)
-	if err == nil {
-		s.enabled = true
-	}
-	return err
+	return s.createUserInternal(rootUser)
 }
 
+// DisableSecurity disables security by removing the root user. The other
+// users and roles are kept, and take effect again once security is
+// enabled.
 func (s *Store) DisableSecurity() error {
-	err := s.DeleteUser("root")
-	if err == nil {
-		s.enabled = false
+	if !s.SecurityEnabled() {
+		return mergeErr("Security is already disabled")
 	}
+	_, err := s.deleteResource("/users/root")
 	return err
 }
 
@@ -392,6 +396,8 @@ func (rw rwPermission) Revoke(n rwPermission) (rwPermission, error) {
 	return out, nil
 }
 
+// HasAccess reports whether any of the read or write patterns matches
+// the given key. See keyMatch for the syntax of the patterns.
 func (rw rwPermission) HasAccess(key string, write bool) bool {
 	var list []string
 	if write {
@@ -400,10 +406,60 @@ func (rw rwPermission) HasAccess(key string, write bool) bool {
 		list = rw.Read
 	}
 	for _, pat := range list {
-		match, err := path.Match(pat, key)
-		if err == nil && match {
+		if keyMatch(pat, key) {
 			return true
 		}
 	}
 	return false
 }
+
+// keyMatch reports whether the pattern matches the whole key.
+// A `*` in the pattern matches any sequence of bytes, including `/`,
+// so `/foo*` grants the prefix `/foo` and everything under it. A `\`
+// escapes the byte after it. All the other bytes match themselves.
+// A `*` does not match the start of a hidden segment, one starting with
+// `_`: `/foo*` does not grant `/foo/_bar`, which must be named by the
+// pattern itself, as in `/foo/_bar*`. Hidden keys are then not readable
+// by guessing their names. This holds for writes too, as a write returns
+// the previous value of the key.
+// 权限按前缀匹配，`*`可以跨越多级目录，但不匹配隐藏节点
+func keyMatch(pattern, key string) bool {
+	return match(pattern, key, 0)
+}
+
+// match matches the pattern against key[pos:].
+func match(pattern, key string, pos int) bool {
+	for len(pattern) > 0 {
+		c := pattern[0]
+		pattern = pattern[1:]
+		switch c {
+		case '*':
+			for i := pos; i <= len(key); i++ {
+				if match(pattern, key, i) {
+					return true
+				}
+				if i < len(key) && isHiddenSegment(key, i) {
+					return false
+				}
+			}
+			return false
+		case '\\':
+			if len(pattern) == 0 {
+				return false
+			}
+			c = pattern[0]
+			pattern = pattern[1:]
+		}
+		if pos == len(key) || key[pos] != c {
+			return false
+		}
+		pos++
+	}
+	return pos == len(key)
+}
+
+// isHiddenSegment reports whether a hidden segment of the key starts at
+// the given position.
+func isHiddenSegment(key string, pos int) bool {
+	return key[pos] == '_' && pos > 0 && key[pos-1] == '/'
+}
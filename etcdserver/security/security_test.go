@@ -109,19 +109,19 @@ func TestMergeRole(t *testing.T) {
 		},
 		{
 			Role{Role: "foo"},
-			Role{Role: "foo", Grant: Permissions{KV: rwPermission{Read: []string{"/foodir"}, Write: []string{"/foodir"}}}},
+			Role{Role: "foo", Grant: &Permissions{KV: rwPermission{Read: []string{"/foodir"}, Write: []string{"/foodir"}}}},
 			Role{Role: "foo", Permissions: Permissions{KV: rwPermission{Read: []string{"/foodir"}, Write: []string{"/foodir"}}}},
 			false,
 		},
 		{
 			Role{Role: "foo", Permissions: Permissions{KV: rwPermission{Read: []string{"/foodir"}, Write: []string{"/foodir"}}}},
-			Role{Role: "foo", Revoke: Permissions{KV: rwPermission{Read: []string{"/foodir"}, Write: []string{"/foodir"}}}},
+			Role{Role: "foo", Revoke: &Permissions{KV: rwPermission{Read: []string{"/foodir"}, Write: []string{"/foodir"}}}},
 			Role{Role: "foo", Permissions: Permissions{KV: rwPermission{Read: []string{}, Write: []string{}}}},
 			false,
 		},
 		{
 			Role{Role: "foo", Permissions: Permissions{KV: rwPermission{Read: []string{"/bardir"}}}},
-			Role{Role: "foo", Revoke: Permissions{KV: rwPermission{Read: []string{"/foodir"}}}},
+			Role{Role: "foo", Revoke: &Permissions{KV: rwPermission{Read: []string{"/foodir"}}}},
 			Role{},
 			true,
 		},
@@ -139,6 +139,112 @@ func TestMergeRole(t *testing.T) {
 	}
 }
 
+func TestHasKeyAccess(t *testing.T) {
+	tests := []struct {
+		pattern string
+		key     string
+		w       bool
+	}{
+		{"/foo", "/foo", true},
+		{"/foo", "/foo/bar", false},
+		{"/foo", "/fo", false},
+		{"/foo*", "/foo", true},
+		{"/foo*", "/foobar", true},
+		{"/foo/*", "/foo/bar/baz", true},
+		{"/foo/*", "/foo", false},
+		{"/foo/*/bar", "/foo/x/y/bar", true},
+		{"/foo/*/bar", "/foo/x/bar/baz", false},
+		{"*", "/anything", true},
+		{`/foo\*`, "/foo*", true},
+		{`/foo\*`, "/foobar", false},
+		{`/foo\\`, `/foo\`, true},
+		// a hidden key is only readable where the pattern names it
+		{"/foo*", "/foo/_bar", false},
+		{"/foo/*", "/foo/_bar", false},
+		{"*", "/_foo", false},
+		{"/foo/*/baz", "/foo/_bar/baz", false},
+		{"/foo/_bar", "/foo/_bar", true},
+		{"/foo/_*", "/foo/_bar", true},
+		{"/foo/_bar*", "/foo/_bar/baz", true},
+		{"/foo/_bar*", "/foo/_bar/_baz", false},
+		{"/foo*", "/foo/bar_baz", true},
+	}
+	for i, tt := range tests {
+		r := Role{Role: "foo", Permissions: Permissions{KV: rwPermission{Read: []string{tt.pattern}}}}
+		if g := r.HasKeyAccess(tt.key, false); g != tt.w {
+			t.Errorf("#%d: access of %q to %q = %v, want %v", i, tt.pattern, tt.key, g, tt.w)
+		}
+		if r.HasKeyAccess(tt.key, true) {
+			t.Errorf("#%d: write access of %q to %q = true, want false", i, tt.pattern, tt.key)
+		}
+	}
+}
+
+func TestEnableDisableSecurity(t *testing.T) {
+	s := NewStore(&storeDoer{store.New(etcdserver.StoreAdminPrefix)}, time.Second)
+	if s.SecurityEnabled() {
+		t.Fatalf("security is enabled, want disabled")
+	}
+	if err := s.EnableSecurity(User{User: "foo", Password: "bar"}); err == nil {
+		t.Errorf("expected error enabling security with a non-root user")
+	}
+	if err := s.EnableSecurity(User{User: "root", Password: "bar"}); err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if !s.SecurityEnabled() {
+		t.Fatalf("security is disabled, want enabled")
+	}
+	root, err := s.GetUser("root")
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if !root.CheckPassword("bar") {
+		t.Errorf("root password is not set")
+	}
+	if err := s.EnableSecurity(User{User: "root", Password: "bar"}); err == nil {
+		t.Errorf("expected error enabling security twice")
+	}
+	if err := s.DeleteUser("root"); err == nil {
+		t.Errorf("expected error deleting root")
+	}
+
+	if err := s.DisableSecurity(); err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if s.SecurityEnabled() {
+		t.Errorf("security is enabled, want disabled")
+	}
+	if err := s.DisableSecurity(); err == nil {
+		t.Errorf("expected error disabling security twice")
+	}
+}
+
+// storeDoer applies the requests to a store like the server does.
+type storeDoer struct {
+	st store.Store
+}
+
+func (d *storeDoer) Do(_ context.Context, r etcdserverpb.Request) (etcdserver.Response, error) {
+	var ev *store.Event
+	var err error
+	switch r.Method {
+	case "GET":
+		ev, err = d.st.Get(r.Path, r.Recursive, r.Sorted)
+	case "PUT":
+		switch {
+		case r.PrevExist == nil:
+			ev, err = d.st.Set(r.Path, r.Dir, r.Val, store.Permanent)
+		case *r.PrevExist:
+			ev, err = d.st.Update(r.Path, r.Val, store.Permanent)
+		default:
+			ev, err = d.st.Create(r.Path, r.Dir, r.Val, false, store.Permanent)
+		}
+	case "DELETE":
+		ev, err = d.st.Delete(r.Path, r.Dir, r.Recursive)
+	}
+	return etcdserver.Response{Event: ev}, err
+}
+
 type testDoer struct {
 	get etcdserver.Response
 }
@@ -18,9 +18,11 @@ import (
 	"encoding/json"
 	"expvar"
 	"fmt"
+	"io"
 	"log"
 	"math/rand"
 	"net/http"
+	"os"
 	"path"
 	"regexp"
 	"sync/atomic"
@@ -28,6 +30,7 @@ import (
 
 	"github.com/coreos/etcd/Godeps/_workspace/src/golang.org/x/net/context"
 	"github.com/coreos/etcd/discovery"
+	"github.com/coreos/etcd/etcdserver/api/kvpb"
 	"github.com/coreos/etcd/etcdserver/etcdhttp/httptypes"
 	pb "github.com/coreos/etcd/etcdserver/etcdserverpb"
 	"github.com/coreos/etcd/etcdserver/stats"
@@ -35,6 +38,7 @@ import (
 	"github.com/coreos/etcd/pkg/idutil"
 	"github.com/coreos/etcd/pkg/pbutil"
 	"github.com/coreos/etcd/pkg/runtime"
+	"github.com/coreos/etcd/pkg/schedule"
 	"github.com/coreos/etcd/pkg/timeutil"
 	"github.com/coreos/etcd/pkg/types"
 	"github.com/coreos/etcd/pkg/wait"
@@ -58,8 +62,41 @@ const (
 
 	StoreAdminPrefix = "/0"
 	StoreKeysPrefix  = "/1"
+	// StoreKVPrefix is the directory of the keys of the v3 KV API.
+	StoreKVPrefix = "/2"
+
+	// StoreBackendV2 keeps only the latest value of every key.
+	StoreBackendV2 = "v2"
+	// StoreBackendMVCC keeps every revision of every key until it is
+	// compacted, so that reads at a revision and watches from an old
+	// index are served beyond the event history window.
+	StoreBackendMVCC = "mvcc"
+	// StoreBackendBolt keeps the keys in a boltdb file under the member
+	// directory instead of memory, so the data set may exceed the RAM.
+	StoreBackendBolt = "bolt"
 
 	purgeFileInterval = 30 * time.Second
+
+	// drainTimeout is the time Stop waits for the in-flight proposals to
+	// be applied.
+	drainTimeout      = 5 * time.Second
+	drainPollInterval = 10 * time.Millisecond
+
+	// DefaultMaxInflightProposals is the number of the client proposals
+	// that may wait to be applied at the same time by default.
+	DefaultMaxInflightProposals = 5000
+
+	// DefaultSyncInterval is the interval at which the leader proposes a
+	// SYNC request to expire the TTL keys by default.
+	DefaultSyncInterval = 500 * time.Millisecond
+
+	// DefaultSnapCatchUpEntries is the number of entries kept for a slow
+	// follower to catch-up after compacting the raft storage entries by
+	// default.
+	// We expect the follower has a millisecond level latency with the leader.
+	// The max throughput is around 10K. Keep a 5K entries is enough for helping
+	// follower to catch up.
+	DefaultSnapCatchUpEntries = 5000
 )
 
 var (
@@ -86,7 +123,28 @@ func init() {
 type Response struct {
 	Event   *store.Event
 	Watcher store.Watcher
-	err     error
+	Txn     *store.TxnResponse
+	// Gets holds the results of a multi-key get, in the order of the
+	// paths of the request.
+	Gets []GetResult
+	// Export holds the subtree of an EXPORT request.
+	Export *store.Export
+	// KV holds the result of a v3 KV transaction.
+	KV  *kvpb.TxnResponse
+	err error
+
+	// committed and applied are when the request starts and finishes
+	// to be applied. They are only used to trace the request, and are
+	// cleared before the response is returned by Do.
+	committed time.Time
+	applied   time.Time
+}
+
+// GetResult is the result of the get of one path of a multi-key get.
+// Either Event or Err is set.
+type GetResult struct {
+	Event *store.Event
+	Err   error
 }
 
 type Server interface {
@@ -119,12 +177,66 @@ type Server interface {
 	// UpdateMember attempts to update a existing member in the cluster. It will
 	// return ErrIDNotFound if the member ID does not exist.
 	UpdateMember(ctx context.Context, updateMemb Member) error
+
+	// TransferLeadership attempts to move the leadership of the cluster to
+	// the member with the given ID. It blocks until the transferee becomes
+	// leader, or returns ErrTimeoutLeaderTransfer if the given context is
+	// done first. It will return ErrIDNotFound if the member ID does not exist.
+	TransferLeadership(ctx context.Context, transferee types.ID) error
 }
 
 // EtcdServer is the production implementation of the Server interface
 type EtcdServer struct {
+	// inflight is the number of the client proposals waiting to be
+	// applied. It is accessed atomically, so it is kept first for the
+	// 64-bit alignment.
+	inflight int64
+	// maxInflight is the limit of inflight. Zero means no limit.
+	maxInflight int64
+	// slowThreshold is the time in nanoseconds a proposed request may
+	// take before it is logged as slow. Zero disables the log. It is
+	// accessed atomically, as the runtime config may change it.
+	slowThreshold int64
+	// stopping is set to 1 by Stop, after which no request is accepted.
+	// It is accessed atomically.
+	stopping int32
+	// applyingSnap is set to 1 while an incoming snapshot is applied. It
+	// is accessed atomically.
+	applyingSnap int32
+	// replayIndex is the commit index found in the WAL at the start, which
+	// the member has replayed once it has applied it.
+	replayIndex uint64
+	// hashes keeps the latest store hashes computed by the hash checks.
+	hashes storeHashes
+	// purgeErrs keeps the errors of the failing purges of the old files.
+	purgeErrs purgeErrors
+	// diskLow is set to 1 while the free disk space is below the minimum.
+	diskLow int32
+	// shedWatches is set to 1 while too many file descriptors are used,
+	// to reject the new watches.
+	shedWatches int32
+	// audit records the applied writes, if the audit log is enabled.
+	audit *auditLog
+	// compacted is set when a COMPACT request is applied, to snapshot
+	// after the apply. It is only accessed by the jobs of the apply
+	// scheduler.
+	compacted bool
+
 	cfg       *ServerConfig
 	snapCount uint64
+	// flagConfig is the runtime config given by the flags, which the
+	// runtime config of the member overrides.
+	flagConfig RuntimeConfig
+	// flagClusterConfig is the cluster config given by the flags, which
+	// the cluster config overrides.
+	flagClusterConfig ClusterConfig
+	// snapshotFormat is the format of the store in the snapshots given
+	// by the flags. It is used once the cluster version supports it.
+	snapshotFormat string
+	// syncInterval is the current interval of the SyncTicker.
+	syncInterval time.Duration
+	// catchUpEntries是压缩raft log时在snapshot之前保留的entry数
+	catchUpEntries uint64
 
 	r raftNode
 
@@ -138,12 +250,25 @@ type EtcdServer struct {
 	Cluster *Cluster
 
 	store store.Store
+	// quota is the size the store may grow to. Zero means no quota.
+	// It is accessed atomically, as the cluster config changes it.
+	quota int64
+	// maxValueBytes and maxRequestBytes are the max sizes of a value
+	// and of a client request. Zero means no limit. They are accessed
+	// atomically.
+	maxValueBytes   int64
+	maxRequestBytes int64
 
 	stats  *stats.ServerStats
 	lstats *stats.LeaderStats
 
+	// SyncTicker triggers the SYNC proposals while the member is the
+	// leader. It is nil if the SYNC is disabled.
 	SyncTicker <-chan time.Time
 
+	// forceSnapc receives the requests to snapshot immediately.
+	forceSnapc chan chan snapshotResult
+
 	reqIDGen *idutil.Generator
 }
 
@@ -152,10 +277,9 @@ type EtcdServer struct {
 // 根据serverConfig来创建一个EtcdServer,在Etcd的整个生命周期，配置都是静态的。
 // 启动Node
 func NewServer(cfg *ServerConfig) (*EtcdServer, error) {
-	st := store.New(StoreAdminPrefix, StoreKeysPrefix)
 	var w *wal.WAL
 	var n raft.Node
-	var s *raft.MemoryStorage
+	var s logStorage
 	var id types.ID
 
 	// Run the migrations.
@@ -163,14 +287,33 @@ func NewServer(cfg *ServerConfig) (*EtcdServer, error) {
 	if err != nil {
 		return nil, err
 	}
-	if err := upgradeDataDir(cfg.DataDir, cfg.Name, dataVer); err != nil {
+	if err := upgradeDataDir(cfg, dataVer); err != nil {
 		return nil, err
 	}
 
+	st, err := newStore(cfg.StoreBackend, cfg.StoreDir(), cfg.WatchHistorySize)
+	if err != nil {
+		return nil, err
+	}
 	haveWAL := wal.Exist(cfg.WALDir())
-	ss := snap.New(cfg.SnapDir())
+	ss := snap.NewEncrypted(cfg.SnapDir(), cfg.Encryption)
 
 	switch {
+	//从snapshot文件恢复出新的单节点cluster，且没有WAL
+	case !haveWAL && cfg.RestoreSnapshot != "":
+		if err := cfg.VerifyRestore(); err != nil {
+			return nil, err
+		}
+		snapshot, err := restoreFromSnapshot(cfg, st, ss)
+		if err != nil {
+			return nil, err
+		}
+		cfg.Cluster = NewClusterFromStore(cfg.Cluster.token, st)
+		if err := checkClusterVersion(cfg.Cluster.Version()); err != nil {
+			return nil, err
+		}
+		cfg.Print()
+		id, n, s, w = restartNode(cfg, snapshot)
 	//已经存在的cluster，且没有WAL
 	case !haveWAL && !cfg.NewCluster:
 		if err := cfg.VerifyJoinExisting(); err != nil {
@@ -222,9 +365,18 @@ func NewServer(cfg *ServerConfig) (*EtcdServer, error) {
 			return nil, fmt.Errorf("cannot write to member directory: %v", err)
 		}
 
+		if cfg.DedicatedWALDir != "" {
+			if err := fileutil.IsDirWriteable(cfg.DedicatedWALDir); err != nil {
+				return nil, fmt.Errorf("cannot write to WAL directory: %v", err)
+			}
+		}
+
 		if cfg.ShouldDiscover() {
 			log.Printf("etcdserver: discovery token ignored since a cluster has already been initialized. Valid log found at %q", cfg.WALDir())
 		}
+		if cfg.RestoreSnapshot != "" {
+			log.Printf("etcdserver: snapshot %s ignored since a cluster has already been initialized. Valid log found at %q", cfg.RestoreSnapshot, cfg.WALDir())
+		}
 		// 加载snapshot信息
 		snapshot, err := ss.Load()
 		if err != nil && err != snap.ErrNoSnapshot {
@@ -238,6 +390,9 @@ func NewServer(cfg *ServerConfig) (*EtcdServer, error) {
 			log.Printf("etcdserver: recovered store from snapshot at index %d", snapshot.Metadata.Index)
 		}
 		cfg.Cluster = NewClusterFromStore(cfg.Cluster.token, st)
+		if err := checkClusterVersion(cfg.Cluster.Version()); err != nil {
+			return nil, err
+		}
 		cfg.Print()
 		if snapshot != nil {
 			log.Printf("etcdserver: loaded cluster information from store: %s", cfg.Cluster)
@@ -251,6 +406,12 @@ func NewServer(cfg *ServerConfig) (*EtcdServer, error) {
 		return nil, fmt.Errorf("unsupported bootstrap config")
 	}
 
+	w.SetSyncWindow(cfg.WALSyncWindow)
+	hs, _, err := s.InitialState()
+	if err != nil {
+		return nil, err
+	}
+
 	sstats := &stats.ServerStats{
 		Name: cfg.Name,
 		ID:   id.String(),
@@ -258,32 +419,82 @@ func NewServer(cfg *ServerConfig) (*EtcdServer, error) {
 	// 设置自身node id为leaderid
 	lstats := stats.NewLeaderStats(id.String())
 
+	var audit *auditLog
+	if cfg.AuditLogPath != "" {
+		if audit, err = openAuditLog(cfg); err != nil {
+			return nil, err
+		}
+	}
+
 	srv := &EtcdServer{
 		cfg:       cfg,
 		snapCount: cfg.SnapCount,
 		errorc:    make(chan error, 1),
 		store:     st,
+		quota:     cfg.quotaBackendBytes(),
+
+		maxValueBytes:   cfg.maxValueBytes(),
+		maxRequestBytes: cfg.maxRequestBytes(),
+
+		catchUpEntries: cfg.SnapCatchUpEntries,
+		replayIndex:    hs.Commit,
+
+		maxInflight:   cfg.maxInflightProposals(),
+		slowThreshold: int64(cfg.SlowRequestThreshold),
+		flagConfig:    flagRuntimeConfig(cfg),
+
+		flagClusterConfig: flagClusterConfig(cfg),
+		snapshotFormat:    cfg.SnapshotFormat,
 		r: raftNode{
-			Node:        n,
-			ticker:      time.Tick(time.Duration(cfg.TickMs) * time.Millisecond),
-			raftStorage: s,
-			storage:     NewStorage(w, ss),
+			Node:          n,
+			ticker:        time.Tick(time.Duration(cfg.TickMs) * time.Millisecond),
+			syncIntervalc: make(chan time.Duration, 1),
+			raftStorage:   s,
+			storage:       NewStorage(w, ss),
 		},
 		id:         id,
-		attributes: Attributes{Name: cfg.Name, ClientURLs: cfg.ClientURLs.StringSlice()},
+		attributes: Attributes{Name: cfg.Name, ClientURLs: cfg.ClientURLs.StringSlice(), Version: version.Version, Labels: cfg.Labels},
 		Cluster:    cfg.Cluster,
 		stats:      sstats,
 		lstats:     lstats,
-		SyncTicker: time.Tick(500 * time.Millisecond),
 		reqIDGen:   idutil.NewGenerator(uint8(id), time.Now()),
+		audit:      audit,
+	}
+	if d := cfg.syncInterval(); d > 0 {
+		srv.SyncTicker = time.Tick(d)
+		srv.syncInterval = d
 	}
+	srv.applyRuntimeConfig()
+	srv.applyClusterConfig()
+	srv.applyClusterVersion()
 
-	tr := rafthttp.NewTransporter(cfg.Transport, id, cfg.Cluster.ID(), srv, srv.errorc, sstats, lstats)
+	tr := rafthttp.NewTransporter(cfg.Transport, id, cfg.Cluster.ID(), srv, srv.errorc, sstats, lstats, cfg.PeerCompression, cfg.PeerReadTimeout, cfg.SnapshotSendRate)
 	srv.r.transport = tr
 	srv.Cluster.SetTransport(tr)
 	return srv, nil
 }
 
+// newStore creates the key-value store of the given backend, which keeps
+// historySize events for watchers. The bolt backend keeps its file in dir.
+func newStore(backend, dir string, historySize int) (store.Store, error) {
+	if historySize <= 0 {
+		historySize = store.DefaultHistorySize
+	}
+	switch backend {
+	case "", StoreBackendV2:
+		return store.NewWithHistorySize(historySize, StoreAdminPrefix, StoreKeysPrefix), nil
+	case StoreBackendMVCC:
+		return store.NewMVCC(historySize, StoreAdminPrefix, StoreKeysPrefix), nil
+	case StoreBackendBolt:
+		if err := os.MkdirAll(dir, privateDirMode); err != nil {
+			return nil, fmt.Errorf("cannot create store dir %s: %v", dir, err)
+		}
+		return store.NewBolt(path.Join(dir, "db"), historySize, StoreAdminPrefix, StoreKeysPrefix)
+	default:
+		return nil, fmt.Errorf("unknown store backend %q", backend)
+	}
+}
+
 // Start prepares and starts server in a new goroutine. It is no longer safe to
 // modify a server's fields after it has been sent to Start.
 // It also starts a goroutine to publish its server information.
@@ -292,7 +503,12 @@ func (s *EtcdServer) Start() {
 	s.start()
 	go s.publish(defaultPublishRetryInterval)
 	go s.purgeFile()
-	go monitorFileDescriptor(s.done)
+	go s.monitorFileDescriptor(s.cfg.fdWarnPercent(), s.cfg.fdShedWatchPercent())
+	go monitorStoreMemory(s.store, s.done)
+	go s.monitorHashes(s.cfg.HashCheckInterval)
+	go s.monitorVersions()
+	go s.monitorCompaction(s.cfg.AutoCompactionRetention)
+	go s.monitorDisk(s.cfg.minFreeDiskBytes())
 }
 
 // start prepares and starts server in a new goroutine. It is no longer safe to
@@ -303,9 +519,14 @@ func (s *EtcdServer) start() {
 		log.Printf("etcdserver: set snapshot count to default %d", DefaultSnapCount)
 		s.snapCount = DefaultSnapCount
 	}
+	if s.catchUpEntries == 0 {
+		log.Printf("etcdserver: set snapshot catch-up entries to default %d", DefaultSnapCatchUpEntries)
+		s.catchUpEntries = DefaultSnapCatchUpEntries
+	}
 	s.w = wait.New()
 	s.done = make(chan struct{})
 	s.stop = make(chan struct{})
+	s.forceSnapc = make(chan chan snapshotResult)
 	s.stats.Initialize()
 	// TODO: if this is an empty log, writes all peer infos
 	// into the first entry
@@ -313,6 +534,9 @@ func (s *EtcdServer) start() {
 }
 
 // 定时清理超过MaxFile的snapshot和wal文件
+// purgeFile purges the old snap and wal files until the server stops. A
+// failed purge is retried, and reported by CheckPurge until a purge
+// succeeds again, instead of stopping the member.
 func (s *EtcdServer) purgeFile() {
 	var serrc, werrc <-chan error
 	if s.cfg.MaxSnapFiles > 0 {
@@ -321,13 +545,15 @@ func (s *EtcdServer) purgeFile() {
 	if s.cfg.MaxWALFiles > 0 {
 		werrc = fileutil.PurgeFile(s.cfg.WALDir(), "wal", s.cfg.MaxWALFiles, purgeFileInterval, s.done)
 	}
-	select {
-	case e := <-werrc:
-		log.Fatalf("etcdserver: failed to purge wal file %v", e)
-	case e := <-serrc:
-		log.Fatalf("etcdserver: failed to purge snap file %v", e)
-	case <-s.done:
-		return
+	for {
+		select {
+		case e := <-werrc:
+			s.purgeErrs.set("wal", e)
+		case e := <-serrc:
+			s.purgeErrs.set("snap", e)
+		case <-s.done:
+			return
+		}
 	}
 }
 
@@ -335,6 +561,10 @@ func (s *EtcdServer) ID() types.ID { return s.id }
 
 func (s *EtcdServer) RaftHandler() http.Handler { return s.r.transport.Handler() }
 
+// ClientCertAuthEnabled reports whether the client requests may be
+// authenticated by their client certificates.
+func (s *EtcdServer) ClientCertAuthEnabled() bool { return s.cfg.ClientCertAuthEnabled }
+
 /**
 * EtcdServer实现<link>rafthttp.Raft</link>接口
  */
@@ -347,6 +577,7 @@ func (s *EtcdServer) Process(ctx context.Context, m raftpb.Message) error {
 	if m.Type == raftpb.MsgApp {
 		s.stats.RecvAppendReq(types.ID(m.From).String(), m.Size())
 	}
+	s.observeLeader(m)
 	return s.r.Step(ctx, m)
 }
 
@@ -363,74 +594,43 @@ func (s *EtcdServer) run() {
 	if err != nil {
 		log.Panicf("etcdserver: get snapshot from raft storage error: %v", err)
 	}
-	confState := snap.Metadata.ConfState
-	snapi := snap.Metadata.Index
-	appliedi := snapi
+	ep := progress{
+		confState: snap.Metadata.ConfState,
+		snapi:     snap.Metadata.Index,
+		appliedi:  snap.Metadata.Index,
+	}
+	atomic.StoreUint64(&s.r.index, snap.Metadata.Index)
+	atomic.StoreUint64(&s.r.term, snap.Metadata.Term)
+	// the applies run one after another on the scheduler, off the raft
+	// routine, which goes on with the next ready meanwhile.
+	sched := schedule.NewFIFOScheduler()
 	// TODO: get rid of the raft initialization in etcd server
 	s.r.s = s
 	s.r.applyc = make(chan apply)
 	go s.r.run()
 	defer func() {
+		// stop the scheduler first, a running apply may be waiting for
+		// the raft routine to finish its disk writes.
+		sched.Stop()
 		s.r.stopped <- struct{}{}
 		<-s.r.done
+		if c, ok := s.store.(io.Closer); ok {
+			c.Close()
+		}
+		if s.audit != nil {
+			s.audit.close()
+		}
 		close(s.done)
 	}()
 
-	var shouldstop bool
 	for {
 		select {
 		// apply包含需要apply的entry和snapshot
-		case apply := <-s.r.apply():
-			// apply snapshot
-			if !raft.IsEmptySnap(apply.snapshot) {
-				if apply.snapshot.Metadata.Index <= appliedi {
-					log.Panicf("etcdserver: snapshot index [%d] should > appliedi[%d] + 1",
-						apply.snapshot.Metadata.Index, appliedi)
-				}
-
-				if err := s.store.Recovery(apply.snapshot.Data); err != nil {
-					log.Panicf("recovery store error: %v", err)
-				}
-
-				// Avoid snapshot recovery overwriting newer cluster and
-				// transport setting, which may block the communication.
-				if s.Cluster.index < apply.snapshot.Metadata.Index {
-					s.Cluster.Recover()
-				}
-
-				appliedi = apply.snapshot.Metadata.Index
-				snapi = appliedi
-				confState = apply.snapshot.Metadata.ConfState
-				log.Printf("etcdserver: recovered from incoming snapshot at index %d", snapi)
-			}
-
-			// apply entries
-			if len(apply.entries) != 0 {
-				firsti := apply.entries[0].Index
-				if firsti > appliedi+1 {
-					log.Panicf("etcdserver: first index of committed entry[%d] should <= appliedi[%d] + 1", firsti, appliedi)
-				}
-				var ents []raftpb.Entry
-				if appliedi+1-firsti < uint64(len(apply.entries)) {
-					ents = apply.entries[appliedi+1-firsti:]
-				}
-				// 将apply的entry存储到store里
-				if appliedi, shouldstop = s.apply(ents, &confState); shouldstop {
-					go s.stopWithDelay(10*100*time.Millisecond, fmt.Errorf("the member has been permanently removed from the cluster"))
-				}
-			}
-
-			// wait for the raft routine to finish the disk writes before triggering a
-			// snapshot. or applied index might be greater than the last index in raft
-			// storage, since the raft routine might be slower than apply routine.
-			apply.done <- struct{}{}
-
-			// trigger snapshot
-			if appliedi-snapi > s.snapCount {
-				log.Printf("etcdserver: start to snapshot (applied: %d, lastsnap: %d)", appliedi, snapi)
-				s.snapshot(appliedi, confState)
-				snapi = appliedi
-			}
+		case ap := <-s.r.apply():
+			sched.Schedule(func(context.Context) { s.applyAll(&ep, &ap) })
+		// 立即创建snapshot并压缩raft log，不等待snapCount
+		case rc := <-s.forceSnapc:
+			sched.Schedule(func(context.Context) { s.forceSnapshot(&ep, rc) })
 		case err := <-s.errorc:
 			log.Printf("etcdserver: %s", err)
 			log.Printf("etcdserver: the data-dir used by this member must be removed.")
@@ -441,9 +641,121 @@ func (s *EtcdServer) run() {
 	}
 }
 
+// progress is the state of the applies. It is only accessed by the jobs
+// of the apply scheduler.
+type progress struct {
+	confState raftpb.ConfState
+	snapi     uint64
+	appliedi  uint64
+}
+
+// applyAll applies the snapshot and the entries of the given apply in
+// order, and triggers a snapshot when enough entries have been applied.
+func (s *EtcdServer) applyAll(ep *progress, ap *apply) {
+	saved := false
+	if !raft.IsEmptySnap(ap.snapshot) {
+		// the incoming snapshot must be on disk before the store
+		// recovers from it.
+		<-ap.raftDone
+		saved = true
+		atomic.StoreInt32(&s.applyingSnap, 1)
+		s.applySnapshot(ep, ap)
+		atomic.StoreInt32(&s.applyingSnap, 0)
+	}
+	s.applyEntries(ep, ap)
+
+	// wait for the raft routine to finish the disk writes before triggering a
+	// snapshot. or applied index might be greater than the last index in raft
+	// storage, since the raft routine might be slower than apply routine.
+	if !saved {
+		<-ap.raftDone
+	}
+
+	// trigger snapshot
+	compacted := s.compacted
+	s.compacted = false
+	if ep.appliedi-ep.snapi > s.snapCount || compacted && ep.appliedi > ep.snapi {
+		log.Printf("etcdserver: start to snapshot (applied: %d, lastsnap: %d)", ep.appliedi, ep.snapi)
+		s.snapshot(ep.appliedi, ep.confState)
+		ep.snapi = ep.appliedi
+	}
+}
+
+func (s *EtcdServer) applySnapshot(ep *progress, ap *apply) {
+	if ap.snapshot.Metadata.Index <= ep.appliedi {
+		log.Panicf("etcdserver: snapshot index [%d] should > appliedi[%d] + 1",
+			ap.snapshot.Metadata.Index, ep.appliedi)
+	}
+
+	if err := s.store.Recovery(ap.snapshot.Data); err != nil {
+		log.Panicf("recovery store error: %v", err)
+	}
+
+	// Avoid snapshot recovery overwriting newer cluster and
+	// transport setting, which may block the communication.
+	if s.Cluster.index < ap.snapshot.Metadata.Index {
+		s.Cluster.Recover()
+	}
+	s.Cluster.RecoverAlarms()
+	s.Cluster.RecoverDirQuotas()
+	s.Cluster.RecoverRuntimeConfigs()
+	s.applyRuntimeConfig()
+	s.Cluster.RecoverClusterConfig()
+	s.applyClusterConfig()
+	s.Cluster.RecoverVersion()
+	s.applyClusterVersion()
+
+	ep.appliedi = ap.snapshot.Metadata.Index
+	ep.snapi = ep.appliedi
+	atomic.StoreUint64(&s.r.index, ap.snapshot.Metadata.Index)
+	atomic.StoreUint64(&s.r.term, ap.snapshot.Metadata.Term)
+	ep.confState = ap.snapshot.Metadata.ConfState
+	log.Printf("etcdserver: recovered from incoming snapshot at index %d", ep.snapi)
+}
+
+func (s *EtcdServer) applyEntries(ep *progress, ap *apply) {
+	if len(ap.entries) == 0 {
+		return
+	}
+	firsti := ap.entries[0].Index
+	if firsti > ep.appliedi+1 {
+		log.Panicf("etcdserver: first index of committed entry[%d] should <= appliedi[%d] + 1", firsti, ep.appliedi)
+	}
+	var ents []raftpb.Entry
+	if ep.appliedi+1-firsti < uint64(len(ap.entries)) {
+		ents = ap.entries[ep.appliedi+1-firsti:]
+	}
+	if len(ents) == 0 {
+		return
+	}
+	var shouldstop bool
+	// 将apply的entry存储到store里
+	if ep.appliedi, shouldstop = s.apply(ents, &ep.confState); shouldstop {
+		go s.stopWithDelay(10*100*time.Millisecond, fmt.Errorf("the member has been permanently removed from the cluster"))
+	}
+}
+
+// forceSnapshot snapshots at the applied index on demand and replies to rc.
+func (s *EtcdServer) forceSnapshot(ep *progress, rc chan snapshotResult) {
+	if ep.appliedi == ep.snapi {
+		rc <- snapshotResult{index: ep.snapi, done: closedc}
+		return
+	}
+	log.Printf("etcdserver: start to snapshot on demand (applied: %d, lastsnap: %d)", ep.appliedi, ep.snapi)
+	rc <- snapshotResult{index: ep.appliedi, done: s.snapshot(ep.appliedi, ep.confState)}
+	ep.snapi = ep.appliedi
+}
+
 // Stop stops the server gracefully, and shuts down the running goroutine.
+// It stops accepting requests, waits a bounded time for the in-flight
+// proposals to be applied, and hands the leadership over to a peer if
+// the member is the leader, before stopping raft.
 // Stop should be called after a Start(s), otherwise it will block forever.
 func (s *EtcdServer) Stop() {
+	if atomic.CompareAndSwapInt32(&s.stopping, 0, 1) {
+		s.drainProposals(drainTimeout)
+		s.transferLeadershipOnStop()
+	}
 	select {
 	case s.stop <- struct{}{}:
 	case <-s.done:
@@ -452,6 +764,76 @@ func (s *EtcdServer) Stop() {
 	<-s.done
 }
 
+func (s *EtcdServer) isStopping() bool { return atomic.LoadInt32(&s.stopping) == 1 }
+
+// drainProposals waits until the in-flight client proposals are applied,
+// the timeout expires, or the server is stopped.
+// 停止前等待未完成的proposal被apply，避免直接丢弃
+func (s *EtcdServer) drainProposals(timeout time.Duration) {
+	deadline := time.After(timeout)
+	for {
+		n := atomic.LoadInt64(&s.inflight)
+		if n <= 0 {
+			return
+		}
+		select {
+		case <-time.After(drainPollInterval):
+		case <-deadline:
+			log.Printf("etcdserver: stopping with %d proposals in flight", n)
+			return
+		case <-s.done:
+			return
+		}
+	}
+}
+
+// transferLeadershipOnStop hands the leadership over to the most
+// up-to-date peer if the member is the leader, so that the cluster does
+// not wait an election timeout for a new leader. It gives up after an
+// election timeout, e.g. if the peer is down.
+// leader停止前将leadership转移给日志最新的peer
+func (s *EtcdServer) transferLeadershipOnStop() {
+	select {
+	case <-s.done:
+		return
+	default:
+	}
+	s.handOverLeadership("on stop")
+}
+
+// handOverLeadership transfers the leadership to the most up-to-date peer
+// if the member is the leader. It gives up after an election timeout.
+func (s *EtcdServer) handOverLeadership(reason string) {
+	if s.Lead() == raft.None || s.Lead() != uint64(s.id) {
+		return
+	}
+	transferee, ok := s.mostUpToDatePeer()
+	if !ok {
+		return
+	}
+	timeout := time.Duration(s.cfg.ElectionTicks) * time.Duration(s.cfg.TickMs) * time.Millisecond
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	if err := s.TransferLeadership(ctx, transferee); err != nil {
+		log.Printf("etcdserver: failed to transfer leadership to %s %s: %v", transferee, reason, err)
+	}
+}
+
+// mostUpToDatePeer returns the peer with the highest match index in the
+// progress of the leader. It returns false if there is no such peer.
+func (s *EtcdServer) mostUpToDatePeer() (types.ID, bool) {
+	var id, match uint64
+	for pid, pr := range s.r.Status().Progress {
+		if pid == uint64(s.id) {
+			continue
+		}
+		if id == 0 || pr.Match > match || (pr.Match == match && pid < id) {
+			id, match = pid, pr.Match
+		}
+	}
+	return types.ID(id), id != 0
+}
+
 func (s *EtcdServer) stopWithDelay(d time.Duration, err error) {
 	time.Sleep(d)
 	select {
@@ -472,8 +854,13 @@ func (s *EtcdServer) StopNotify() <-chan struct{} { return s.done }
 // 执行client-->server的request,如果Method是POST，PUT，DELETE，Quorum的GET，
 // 那么在执行操作之前会进行一致性处理,每个request都会生成一个resq id
 func (s *EtcdServer) Do(ctx context.Context, r pb.Request) (Response, error) {
+	if s.isStopping() {
+		return Response{}, ErrStopped
+	}
 	r.ID = s.reqIDGen.Next()
-	if r.Method == "GET" && r.Quorum {
+	// a multi-key get is always a quorum read, so that all the paths
+	// are read at the same index
+	if r.Method == "GET" && (r.Quorum || len(r.Paths) > 0) {
 		r.Method = "QGET"
 	}
 	switch r.Method {
@@ -481,7 +868,30 @@ func (s *EtcdServer) Do(ctx context.Context, r pb.Request) (Response, error) {
 	例如：curl -L http://127.0.0.1:2379/v2/keys/mykey -XPUT -d value="this is awesome"
 	处理client的KV数据请求，需要经过一致性处理
 	*/
-	case "POST", "PUT", "DELETE", "QGET":
+	case "POST", "PUT", "DELETE", "QGET", "TXN", "INCR", "MOVE", "HASH", "COMPACT", "EXPORT", "IMPORT", "KV":
+		if needsConsistency(r) && s.Cluster.IsAlarmActive(AlarmCorrupt) {
+			return Response{}, ErrCorrupt
+		}
+		if err := s.checkCapability(r); err != nil {
+			return Response{}, err
+		}
+		if err := s.checkRequestSize(r); err != nil {
+			return Response{}, err
+		}
+		if err := s.checkQuota(r); err != nil {
+			return Response{}, err
+		}
+		// the internal requests, e.g. publishing the member attributes,
+		// are never rejected.
+		if !isAdminPath(r.Path) {
+			if s.isDiskLow() {
+				return Response{}, ErrLowDiskSpace
+			}
+			if err := s.startProposal(); err != nil {
+				return Response{}, err
+			}
+			defer s.finishProposal()
+		}
 		data, err := r.Marshal()
 		if err != nil {
 			return Response{}, err
@@ -492,7 +902,14 @@ func (s *EtcdServer) Do(ctx context.Context, r pb.Request) (Response, error) {
 		// TODO: benchmark the cost of time.Now()
 		// might be sampling?
 		start := time.Now()
-		s.r.Propose(ctx, data)
+		tr := requestTrace{start: start}
+		lead := s.Lead()
+		if err := s.r.Propose(ctx, data); err != nil {
+			proposeFailed.Inc()
+			s.w.Trigger(r.ID, nil) // GC wait
+			return Response{}, parseProposeErr(err)
+		}
+		tr.proposed = time.Now()
 		// propose挂起数加1
 		proposePending.Inc()
 		defer proposePending.Dec()
@@ -501,30 +918,44 @@ func (s *EtcdServer) Do(ctx context.Context, r pb.Request) (Response, error) {
 		case x := <-ch:
 			proposeDurations.Observe(float64(time.Since(start).Nanoseconds() / int64(time.Millisecond)))
 			resp := x.(Response)
+			tr.committed, tr.applied = resp.committed, resp.applied
+			s.warnIfSlow(r, tr, time.Now())
+			resp.committed, resp.applied = time.Time{}, time.Time{}
 			return resp, resp.err
 		case <-ctx.Done():
 			proposeFailed.Inc()
+			s.warnIfSlow(r, tr, time.Now())
 			s.w.Trigger(r.ID, nil) // GC wait
+			if ctx.Err() == context.DeadlineExceeded && s.Lead() != lead {
+				// the proposal is likely lost with the leader
+				return Response{}, ErrTimeoutDueToLeaderFail
+			}
 			return Response{}, parseCtxErr(ctx.Err())
 		case <-s.done:
 			return Response{}, ErrStopped
 		}
 	case "GET":
+		if needsConsistency(r) && s.Cluster.IsAlarmActive(AlarmCorrupt) {
+			return Response{}, ErrCorrupt
+		}
 		switch {
 		case r.Wait:
-			wc, err := s.store.Watch(r.Path, r.Recursive, r.Stream, r.Since)
+			wc, err := s.store.Watch(r.Path, r.Recursive, r.Stream, r.Since, r.Actions)
 			if err != nil {
 				return Response{}, err
 			}
 			return Response{Watcher: wc}, nil
 		default:
-			ev, err := s.store.Get(r.Path, r.Recursive, r.Sorted)
+			ev, err := s.get(r)
 			if err != nil {
 				return Response{}, err
 			}
 			return Response{Event: ev}, nil
 		}
 	case "HEAD":
+		if needsConsistency(r) && s.Cluster.IsAlarmActive(AlarmCorrupt) {
+			return Response{}, ErrCorrupt
+		}
 		ev, err := s.store.Get(r.Path, r.Recursive, r.Sorted)
 		if err != nil {
 			return Response{}, err
@@ -535,6 +966,55 @@ func (s *EtcdServer) Do(ctx context.Context, r pb.Request) (Response, error) {
 	}
 }
 
+// startProposal counts a client proposal in flight. It returns
+// ErrTooManyRequests if the limit of the in-flight proposals is exceeded,
+// or ErrStopped if the server is stopping. finishProposal must be called
+// when a started proposal is done.
+// 限制未完成的proposal数量，避免过载时propc和apply积压
+func (s *EtcdServer) startProposal() error {
+	n := atomic.AddInt64(&s.inflight, 1)
+	// checked after the proposal is counted, so that Stop either waits
+	// for it or it is rejected.
+	if s.isStopping() {
+		atomic.AddInt64(&s.inflight, -1)
+		return ErrStopped
+	}
+	if s.maxInflight > 0 && n > s.maxInflight {
+		atomic.AddInt64(&s.inflight, -1)
+		proposeRejected.Inc()
+		return ErrTooManyRequests
+	}
+	return nil
+}
+
+func (s *EtcdServer) finishProposal() { atomic.AddInt64(&s.inflight, -1) }
+
+// maxInflightProposals returns the limit of the in-flight client
+// proposals. Zero means no limit.
+func (c *ServerConfig) maxInflightProposals() int64 {
+	switch {
+	case c.MaxInflightProposals == 0:
+		return DefaultMaxInflightProposals
+	case c.MaxInflightProposals < 0:
+		return 0
+	default:
+		return int64(c.MaxInflightProposals)
+	}
+}
+
+// syncInterval returns the interval of the SYNC proposals. Zero means
+// the SYNC is disabled.
+func (c *ServerConfig) syncInterval() time.Duration {
+	switch {
+	case c.SyncInterval == 0:
+		return DefaultSyncInterval
+	case c.SyncInterval < 0:
+		return 0
+	default:
+		return c.SyncInterval
+	}
+}
+
 func (s *EtcdServer) SelfStats() []byte { return s.stats.JSON() }
 
 func (s *EtcdServer) LeaderStats() []byte {
@@ -582,18 +1062,105 @@ func (s *EtcdServer) UpdateMember(ctx context.Context, memb Member) error {
 	return s.configure(ctx, cc)
 }
 
+func (s *EtcdServer) TransferLeadership(ctx context.Context, transferee types.ID) error {
+	if s.Cluster.Member(transferee) == nil {
+		return ErrIDNotFound
+	}
+	lead := s.Lead()
+	if lead == raft.None {
+		return ErrNoLeader
+	}
+	if lead == uint64(transferee) {
+		return nil
+	}
+	// the transferee is told to campaign by MsgTimeoutNow
+	if !s.IsCapabilityEnabled(TimeoutNowCapability) {
+		return ErrNotCapable
+	}
+
+	log.Printf("etcdserver: %s starts leadership transfer from %s to %s", s.ID(), types.ID(lead), transferee)
+	s.r.TransferLeadership(ctx, lead, uint64(transferee))
+
+	// poll the leader every tick until the transferee takes over.
+	interval := time.Duration(s.cfg.TickMs) * time.Millisecond
+	for s.Lead() != uint64(transferee) {
+		select {
+		case <-time.After(interval):
+		case <-ctx.Done():
+			return ErrTimeoutLeaderTransfer
+		case <-s.done:
+			return ErrStopped
+		}
+	}
+	log.Printf("etcdserver: leadership transfer finished from %s to %s", types.ID(lead), transferee)
+	return nil
+}
+
 // Implement the RaftTimer interface
 func (s *EtcdServer) Index() uint64 { return atomic.LoadUint64(&s.r.index) }
 
 func (s *EtcdServer) Term() uint64 { return atomic.LoadUint64(&s.r.term) }
 
+// CommittedIndex returns the commit index of the latest hard state, or
+// the applied index if it is newer, e.g. before the first hard state is
+// received after a restart.
+func (s *EtcdServer) CommittedIndex() uint64 {
+	c, a := atomic.LoadUint64(&s.r.committed), s.AppliedIndex()
+	if c < a {
+		return a
+	}
+	return c
+}
+
+func (s *EtcdServer) AppliedIndex() uint64 { return s.Index() }
+
+// CurrentTerm returns the term of the latest hard state, or the term of
+// the last applied entry if it is newer.
+func (s *EtcdServer) CurrentTerm() uint64 {
+	t, at := atomic.LoadUint64(&s.r.currentTerm), s.Term()
+	if t < at {
+		return at
+	}
+	return t
+}
+
 // Only for testing purpose
 // TODO: add Raft server interface to expose raft related info:
-// Index, Term, Lead, Committed, Applied, LastIndex, etc.
+// Lead, LastIndex, etc.
 func (s *EtcdServer) Lead() uint64 { return atomic.LoadUint64(&s.r.lead) }
 
 func (s *EtcdServer) Leader() types.ID { return types.ID(s.Lead()) }
 
+// UnreachablePeers returns since when each unreachable peer has been
+// unreachable, keyed by the peer ID.
+func (s *EtcdServer) UnreachablePeers() map[types.ID]time.Time {
+	peers := make(map[types.ID]time.Time)
+	for _, id := range s.Cluster.MemberIDs() {
+		if id == s.id {
+			continue
+		}
+		if since := s.r.transport.UnreachableSince(id); !since.IsZero() {
+			peers[id] = since
+		}
+	}
+	return peers
+}
+
+// MatchIndexes returns the index of the last entry each member is known
+// to have replicated, keyed by the member ID. Only the leader tracks it,
+// so it returns nil on a follower.
+func (s *EtcdServer) MatchIndexes() map[types.ID]uint64 {
+	pr := s.r.Status().Progress
+	if pr == nil {
+		return nil
+	}
+	m := make(map[types.ID]uint64, len(pr))
+	for id, p := range pr {
+		m[types.ID(id)] = p.Match
+	}
+	return m
+}
+
 // configure sends a configuration change through consensus and
 // then waits for it to be applied to the server. It
 // will block until the change is performed or there is an error.
@@ -669,6 +1236,14 @@ func (s *EtcdServer) publish(retryInterval time.Duration) {
 		case ErrStopped:
 			log.Printf("etcdserver: aborting publish because server is stopped")
 			return
+		case ErrProposalDropped:
+			// 还没有leader,proposal会被立即丢弃,等待一个tick再重试。
+			log.Printf("etcdserver: publish error: %v", err)
+			select {
+			case <-time.After(time.Duration(s.cfg.TickMs) * time.Millisecond):
+			case <-s.done:
+				return
+			}
 		default:
 			log.Printf("etcdserver: publish error: %v", err)
 		}
@@ -692,13 +1267,53 @@ func (s *EtcdServer) apply(es []raftpb.Entry, confState *raftpb.ConfState) (uint
 	var applied uint64
 	var shouldstop bool
 	var err error
+	// the entries up to the consistent index are already in the store,
+	// when it is recovered from a state newer than the replayed log
+	consistentIndex := s.store.ConsistentIndex()
 	for i := range es {
 		e := es[i]
+		if e.Index <= consistentIndex {
+			// 已经应用过的entry，跳过以保证幂等
+			if e.Type == raftpb.EntryConfChange {
+				var cc raftpb.ConfChange
+				pbutil.MustUnmarshal(&cc, e.Data)
+				shouldstop = s.reapplyConfChange(cc, confState)
+			}
+			atomic.StoreUint64(&s.r.index, e.Index)
+			atomic.StoreUint64(&s.r.term, e.Term)
+			applied = e.Index
+			continue
+		}
+		s.store.SetConsistentIndex(e.Index)
+		start := time.Now()
 		switch e.Type {
 		case raftpb.EntryNormal:
 			var r pb.Request
 			pbutil.MustUnmarshal(&r, e.Data)
-			s.w.Trigger(r.ID, s.applyRequest(r))
+			resp := s.applyRequestOnce(r)
+			if s.audit != nil && isAuditRequest(r) {
+				s.audit.record(e.Index, r, resp)
+			}
+			if isAlarmRequest(r) {
+				s.Cluster.RecoverAlarms()
+			}
+			if isDirQuotaRequest(r) {
+				s.Cluster.RecoverDirQuotas()
+			}
+			if isRuntimeConfigRequest(r) {
+				s.Cluster.RecoverRuntimeConfigs()
+				s.applyRuntimeConfig()
+			}
+			if isClusterConfigRequest(r) {
+				s.Cluster.RecoverClusterConfig()
+				s.applyClusterConfig()
+			}
+			if isClusterVersionRequest(r) {
+				s.Cluster.RecoverVersion()
+				s.applyClusterVersion()
+			}
+			resp.committed, resp.applied = start, time.Now()
+			s.w.Trigger(r.ID, resp)
 		case raftpb.EntryConfChange:
 			var cc raftpb.ConfChange
 			pbutil.MustUnmarshal(&cc, e.Data)
@@ -707,6 +1322,7 @@ func (s *EtcdServer) apply(es []raftpb.Entry, confState *raftpb.ConfState) (uint
 		default:
 			log.Panicf("entry type should be either EntryNormal or EntryConfChange")
 		}
+		applyDurations.Observe(float64(time.Since(start).Nanoseconds() / int64(time.Microsecond)))
 		atomic.StoreUint64(&s.r.index, e.Index)
 		atomic.StoreUint64(&s.r.term, e.Term)
 		applied = e.Index
@@ -714,12 +1330,42 @@ func (s *EtcdServer) apply(es []raftpb.Entry, confState *raftpb.ConfState) (uint
 	return applied, shouldstop
 }
 
+// get gets the node of the GET request r, or a page of the nodes under it
+// if r has a limit or a key to continue from.
+func (s *EtcdServer) get(r pb.Request) (*store.Event, error) {
+	if r.Limit > 0 || r.Continue != "" {
+		return s.store.GetPage(r.Path, r.Recursive, int(r.Limit), r.Continue)
+	}
+	return s.store.Get(r.Path, r.Recursive, r.Sorted)
+}
+
+// getPaths gets every path of a multi-key get. The gets are applied
+// together, so they all see the store at the same index.
+func (s *EtcdServer) getPaths(r pb.Request) Response {
+	gets := make([]GetResult, len(r.Paths))
+	for i, p := range r.Paths {
+		gets[i].Event, gets[i].Err = s.store.Get(p, r.Recursive, r.Sorted)
+	}
+	return Response{Gets: gets}
+}
+
 // applyRequest interprets r as a call to store.X and returns a Response interpreted
 // from store.Event
 func (s *EtcdServer) applyRequest(r pb.Request) Response {
 	f := func(ev *store.Event, err error) Response {
 		return Response{Event: ev, err: err}
 	}
+	// The alarm goes through raft, so all the members reject the same
+	// requests.
+	if needsQuota(r) && s.Cluster.IsAlarmActive(AlarmNoSpace) {
+		return Response{err: ErrNoSpace}
+	}
+	if needsConsistency(r) && s.Cluster.IsAlarmActive(AlarmCorrupt) {
+		return Response{err: ErrCorrupt}
+	}
+	if err := s.checkDirQuotas(r); err != nil {
+		return Response{err: err}
+	}
 	expr := timeutil.UnixNanoToTime(r.Expiration)
 	switch r.Method {
 	case "POST":
@@ -727,6 +1373,8 @@ func (s *EtcdServer) applyRequest(r pb.Request) Response {
 	case "PUT":
 		exists, existsSet := pbutil.GetBool(r.PrevExist)
 		switch {
+		case r.Refresh:
+			return f(s.store.Refresh(r.Path, expr))
 		case existsSet:
 			if exists {
 				if r.PrevIndex == 0 && r.PrevValue == "" {
@@ -751,22 +1399,99 @@ func (s *EtcdServer) applyRequest(r pb.Request) Response {
 		}
 	case "DELETE":
 		switch {
+		case (r.Dir || r.Recursive) && (r.PrevIndex > 0 || r.PrevMarker != ""):
+			return f(s.store.CompareAndDeleteDir(r.Path, r.PrevMarker, r.PrevValue, r.PrevIndex, r.Recursive))
 		case r.PrevIndex > 0 || r.PrevValue != "":
 			return f(s.store.CompareAndDelete(r.Path, r.PrevValue, r.PrevIndex))
 		default:
 			return f(s.store.Delete(r.Path, r.Dir, r.Recursive))
 		}
 	case "QGET":
-		return f(s.store.Get(r.Path, r.Recursive, r.Sorted))
+		if len(r.Paths) > 0 {
+			return s.getPaths(r)
+		}
+		return f(s.get(r))
+	case "INCR":
+		return f(s.store.Incr(r.Path, r.Delta))
+	case "MOVE":
+		return f(s.store.Move(r.Path, r.MoveTo))
+	case "EXPORT":
+		ex, err := s.store.Export(r.Path)
+		return Response{Export: ex, err: err}
+	case "IMPORT":
+		return s.applyImport(r)
+	case "TXN":
+		return s.applyTxn(r)
+	case "KV":
+		return s.applyKV(r)
 	case "SYNC":
 		s.store.DeleteExpiredKeys(time.Unix(0, r.Time))
 		return Response{}
+	case "HASH":
+		s.hashes.add(StoreHash{Index: s.store.Index(), Hash: hashStore(s.store)})
+		return Response{}
+	case "COMPACT":
+		s.applyCompact(r.Since)
+		return Response{}
 	default:
 		// This should never be reached, but just in case:
 		return Response{err: ErrUnknownMethod}
 	}
 }
 
+// applyTxn applies the compares and operations carried by a TXN request
+// to the store as one transaction. Only PUT and DELETE requests are
+// allowed as operations.
+func (s *EtcdServer) applyTxn(r pb.Request) Response {
+	cmps := make([]store.TxnCompare, len(r.Compares))
+	for i, c := range r.Compares {
+		cmps[i] = store.TxnCompare{Path: c.Path, Value: c.Value, Index: c.Index}
+		switch c.Target {
+		case pb.CompareValue:
+			cmps[i].Target = store.TxnCompareValue
+		case pb.CompareCreatedIndex:
+			cmps[i].Target = store.TxnCompareCreatedIndex
+		case pb.CompareModifiedIndex:
+			cmps[i].Target = store.TxnCompareModifiedIndex
+		default:
+			// unknown targets never hold
+			cmps[i].Target = -1
+		}
+	}
+	success, err := txnOps(r.Success)
+	if err != nil {
+		return Response{err: err}
+	}
+	failure, err := txnOps(r.Failure)
+	if err != nil {
+		return Response{err: err}
+	}
+	resp, err := s.store.Txn(cmps, success, failure)
+	return Response{Txn: resp, err: err}
+}
+
+func txnOps(rs []pb.Request) ([]store.TxnOp, error) {
+	ops := make([]store.TxnOp, len(rs))
+	for i, r := range rs {
+		ops[i] = store.TxnOp{
+			Path:       r.Path,
+			Value:      r.Val,
+			Dir:        r.Dir,
+			Recursive:  r.Recursive,
+			ExpireTime: timeutil.UnixNanoToTime(r.Expiration),
+		}
+		switch r.Method {
+		case "PUT":
+			ops[i].Action = store.Set
+		case "DELETE":
+			ops[i].Action = store.Delete
+		default:
+			return nil, ErrUnknownMethod
+		}
+	}
+	return ops, nil
+}
+
 // applyConfChange applies a ConfChange to the server at the given index. It is only
 // invoked with a ConfChange that has already passed through Raft.
 func (s *EtcdServer) applyConfChange(cc raftpb.ConfChange, confState *raftpb.ConfState, index uint64) (bool, error) {
@@ -817,12 +1542,24 @@ func (s *EtcdServer) applyConfChange(cc raftpb.ConfChange, confState *raftpb.Con
 	return false, nil
 }
 
-// TODO: non-blocking snapshot
+// reapplyConfChange applies a conf change the cluster already has to raft
+// only. It returns true if the change removes the local member.
+func (s *EtcdServer) reapplyConfChange(cc raftpb.ConfChange, confState *raftpb.ConfState) bool {
+	*confState = *s.r.ApplyConfChange(cc)
+	return cc.Type == raftpb.ConfChangeRemoveNode && types.ID(cc.NodeID) == s.id
+}
+
 // 创建snapshot并保存
-func (s *EtcdServer) snapshot(snapi uint64, confState raftpb.ConfState) {
+// snapshot saves the snapshot and compacts the raft log asynchronously.
+// The returned channel is closed when both are done.
+func (s *EtcdServer) snapshot(snapi uint64, confState raftpb.ConfState) <-chan struct{} {
+	// Clone does not copy the store; the copy is made by SaveNoCopy
+	// without blocking the applies.
 	clone := s.store.Clone()
 
+	donec := make(chan struct{})
 	go func() {
+		defer close(donec)
 		d, err := clone.SaveNoCopy()
 		// TODO: current store will never fail to do a snapshot
 		// what should we do if the store might fail?
@@ -845,8 +1582,8 @@ func (s *EtcdServer) snapshot(snapi uint64, confState raftpb.ConfState) {
 
 		// keep some in memory log entries for slow followers.
 		compacti := uint64(1)
-		if snapi > numberOfCatchUpEntries {
-			compacti = snapi - numberOfCatchUpEntries
+		if snapi > s.catchUpEntries {
+			compacti = snapi - s.catchUpEntries
 		}
 		err = s.r.raftStorage.Compact(compacti)
 		if err != nil {
@@ -859,6 +1596,7 @@ func (s *EtcdServer) snapshot(snapi uint64, confState raftpb.ConfState) {
 		}
 		log.Printf("etcdserver: compacted raft log at %d", compacti)
 	}()
+	return donec
 }
 
 func (s *EtcdServer) PauseSending() { s.r.pauseSending() }
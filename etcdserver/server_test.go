@@ -17,6 +17,7 @@ package etcdserver
 import (
 	"encoding/json"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"log"
 	"net/http"
@@ -24,10 +25,14 @@ import (
 	"path"
 	"reflect"
 	"strconv"
+	"strings"
+	"sync/atomic"
 	"testing"
 	"time"
 
 	"github.com/coreos/etcd/Godeps/_workspace/src/golang.org/x/net/context"
+	etcdErr "github.com/coreos/etcd/error"
+	"github.com/coreos/etcd/etcdserver/api/kvpb"
 	pb "github.com/coreos/etcd/etcdserver/etcdserverpb"
 	"github.com/coreos/etcd/pkg/idutil"
 	"github.com/coreos/etcd/pkg/pbutil"
@@ -35,6 +40,7 @@ import (
 	"github.com/coreos/etcd/pkg/types"
 	"github.com/coreos/etcd/raft"
 	"github.com/coreos/etcd/raft/raftpb"
+	"github.com/coreos/etcd/rafthttp"
 	"github.com/coreos/etcd/store"
 )
 
@@ -62,6 +68,16 @@ func TestDoLocalAction(t *testing.T) {
 				},
 			},
 		},
+		{
+			pb.Request{Method: "GET", ID: 1, Recursive: true, Limit: 10, Continue: "/foo"},
+			Response{Event: &store.Event{}}, nil,
+			[]testutil.Action{
+				{
+					Name:   "GetPage",
+					Params: []interface{}{"", true, 10, "/foo"},
+				},
+			},
+		},
 		{
 			pb.Request{Method: "HEAD", ID: 1},
 			Response{Event: &store.Event{}}, nil,
@@ -81,6 +97,7 @@ func TestDoLocalAction(t *testing.T) {
 		st := &storeRecorder{}
 		srv := &EtcdServer{
 			store:    st,
+			Cluster:  &Cluster{},
 			reqIDGen: idutil.NewGenerator(0, time.Time{}),
 		}
 		resp, err := srv.Do(context.TODO(), tt.req)
@@ -134,6 +151,7 @@ func TestDoBadLocalAction(t *testing.T) {
 		st := &errStoreRecorder{err: storeErr}
 		srv := &EtcdServer{
 			store:    st,
+			Cluster:  &Cluster{},
 			reqIDGen: idutil.NewGenerator(0, time.Time{}),
 		}
 		resp, err := srv.Do(context.Background(), tt.req)
@@ -213,6 +231,17 @@ func TestApplyRequest(t *testing.T) {
 				},
 			},
 		},
+		// PUT with Refresh ==> Refresh
+		{
+			pb.Request{Method: "PUT", ID: 1, Refresh: true, PrevExist: pbutil.Boolp(true), Expiration: 1337},
+			Response{Event: &store.Event{}},
+			[]testutil.Action{
+				{
+					Name:   "Refresh",
+					Params: []interface{}{"", time.Unix(0, 1337)},
+				},
+			},
+		},
 		// PUT with PrevExist=true ==> Update
 		{
 			pb.Request{Method: "PUT", ID: 1, PrevExist: pbutil.Boolp(true)},
@@ -334,6 +363,28 @@ func TestApplyRequest(t *testing.T) {
 				},
 			},
 		},
+		// DELETE with Recursive and PrevIndex set ==> CompareAndDeleteDir
+		{
+			pb.Request{Method: "DELETE", ID: 1, Recursive: true, PrevIndex: 5},
+			Response{Event: &store.Event{}},
+			[]testutil.Action{
+				{
+					Name:   "CompareAndDeleteDir",
+					Params: []interface{}{"", "", "", uint64(5), true},
+				},
+			},
+		},
+		// DELETE with Dir, PrevMarker and PrevValue set ==> CompareAndDeleteDir
+		{
+			pb.Request{Method: "DELETE", ID: 1, Dir: true, PrevMarker: "owner", PrevValue: "bar"},
+			Response{Event: &store.Event{}},
+			[]testutil.Action{
+				{
+					Name:   "CompareAndDeleteDir",
+					Params: []interface{}{"", "owner", "bar", uint64(0), false},
+				},
+			},
+		},
 		// QGET ==> Get
 		{
 			pb.Request{Method: "QGET", ID: 1},
@@ -345,6 +396,65 @@ func TestApplyRequest(t *testing.T) {
 				},
 			},
 		},
+		// INCR ==> Incr
+		{
+			pb.Request{Method: "INCR", ID: 1, Path: "foo", Delta: -3},
+			Response{Event: &store.Event{}},
+			[]testutil.Action{
+				{
+					Name:   "Incr",
+					Params: []interface{}{"foo", int64(-3)},
+				},
+			},
+		},
+		// QGET with paths ==> Get of every path
+		{
+			pb.Request{Method: "QGET", ID: 1, Paths: []string{"foo", "bar"}},
+			Response{Gets: []GetResult{{Event: &store.Event{}}, {Event: &store.Event{}}}},
+			[]testutil.Action{
+				{
+					Name:   "Get",
+					Params: []interface{}{"foo", false, false},
+				},
+				{
+					Name:   "Get",
+					Params: []interface{}{"bar", false, false},
+				},
+			},
+		},
+		// MOVE ==> Move
+		{
+			pb.Request{Method: "MOVE", ID: 1, Path: "foo", MoveTo: "bar"},
+			Response{Event: &store.Event{}},
+			[]testutil.Action{
+				{
+					Name:   "Move",
+					Params: []interface{}{"foo", "bar"},
+				},
+			},
+		},
+		// EXPORT ==> Export
+		{
+			pb.Request{Method: "EXPORT", ID: 1, Path: "foo"},
+			Response{Export: &store.Export{}},
+			[]testutil.Action{
+				{
+					Name:   "Export",
+					Params: []interface{}{"foo"},
+				},
+			},
+		},
+		// IMPORT ==> Import
+		{
+			pb.Request{Method: "IMPORT", ID: 1, Path: "foo", Val: `{"version":1,"index":2,"node":{"key":"/bar","dir":true}}`},
+			Response{Event: &store.Event{}},
+			[]testutil.Action{
+				{
+					Name:   "Import",
+					Params: []interface{}{"foo", &store.Export{Version: 1, Index: 2, Node: &store.NodeExtern{Key: "/bar", Dir: true}}},
+				},
+			},
+		},
 		// SYNC ==> DeleteExpiredKeys
 		{
 			pb.Request{Method: "SYNC", ID: 1},
@@ -366,6 +476,43 @@ func TestApplyRequest(t *testing.T) {
 				},
 			},
 		},
+		// TXN ==> Txn
+		{
+			pb.Request{
+				Method: "TXN",
+				ID:     1,
+				Compares: []pb.Compare{
+					{Path: "foo", Target: pb.CompareValue, Value: "bar"},
+					{Path: "foo", Target: pb.CompareModifiedIndex, Index: 2},
+				},
+				Success: []pb.Request{{Method: "PUT", Path: "foo", Val: "baz", Expiration: 1337}},
+				Failure: []pb.Request{{Method: "DELETE", Path: "dir", Recursive: true}},
+			},
+			Response{Txn: &store.TxnResponse{}},
+			[]testutil.Action{
+				{
+					Name: "Txn",
+					Params: []interface{}{
+						[]store.TxnCompare{
+							{Path: "foo", Target: store.TxnCompareValue, Value: "bar"},
+							{Path: "foo", Target: store.TxnCompareModifiedIndex, Index: 2},
+						},
+						[]store.TxnOp{{Action: store.Set, Path: "foo", Value: "baz", ExpireTime: time.Unix(0, 1337)}},
+						[]store.TxnOp{{Action: store.Delete, Path: "dir", Recursive: true, ExpireTime: time.Time{}}},
+					},
+				},
+			},
+		},
+		// TXN with unsupported operation - error
+		{
+			pb.Request{
+				Method:  "TXN",
+				ID:      1,
+				Success: []pb.Request{{Method: "POST", Path: "foo"}},
+			},
+			Response{err: ErrUnknownMethod},
+			[]testutil.Action{},
+		},
 		// Unknown method - error
 		{
 			pb.Request{Method: "BADMETHOD", ID: 1},
@@ -376,7 +523,7 @@ func TestApplyRequest(t *testing.T) {
 
 	for i, tt := range tests {
 		st := &storeRecorder{}
-		srv := &EtcdServer{store: st}
+		srv := &EtcdServer{store: st, Cluster: &Cluster{}}
 		resp := srv.applyRequest(tt.req)
 
 		if !reflect.DeepEqual(resp, tt.wresp) {
@@ -389,6 +536,42 @@ func TestApplyRequest(t *testing.T) {
 	}
 }
 
+func TestNewStoreBackend(t *testing.T) {
+	dir, err := ioutil.TempDir(os.TempDir(), "etcdserver")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	tests := []struct {
+		backend string
+
+		wmvcc bool
+		werr  bool
+	}{
+		{"", false, false},
+		{StoreBackendV2, false, false},
+		{StoreBackendMVCC, true, false},
+		{StoreBackendBolt, false, false},
+		{"unknown", false, true},
+	}
+	for i, tt := range tests {
+		st, err := newStore(tt.backend, path.Join(dir, "store"), store.DefaultHistorySize)
+		if (err != nil) != tt.werr {
+			t.Errorf("#%d: err = %v, want error %v", i, err, tt.werr)
+		}
+		if err != nil {
+			continue
+		}
+		if _, ok := st.(store.MVCCStore); ok != tt.wmvcc {
+			t.Errorf("#%d: mvcc = %v, want %v", i, ok, tt.wmvcc)
+		}
+		if c, ok := st.(io.Closer); ok {
+			c.Close()
+		}
+	}
+}
+
 func TestApplyRequestOnAdminMemberAttributes(t *testing.T) {
 	cl := newTestCluster([]*Member{{ID: 1}})
 	srv := &EtcdServer{
@@ -512,6 +695,59 @@ func TestApplyConfChangeShouldStop(t *testing.T) {
 	}
 }
 
+// Ensure that the entries up to the consistent index of the store are not
+// applied again, except for the conf changes that raft needs.
+func TestApplySkipsConsistentIndex(t *testing.T) {
+	st := store.New()
+	cl := newCluster("")
+	cl.SetStore(st)
+	cl.SetTransport(&nopTransporter{})
+	cl.AddMember(&Member{ID: 1}, 1)
+	cl.AddMember(&Member{ID: 2}, 2)
+	n := &nodeRecorder{}
+	srv := &EtcdServer{
+		id: 1,
+		r: raftNode{
+			Node:      n,
+			transport: &nopTransporter{},
+		},
+		Cluster: cl,
+		store:   st,
+		w:       &waitRecorder{},
+	}
+	st.Set("/foo", false, "bar", store.Permanent)
+	st.SetConsistentIndex(3)
+	index := st.Index()
+
+	b, err := json.Marshal(&Member{ID: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	cc := raftpb.ConfChange{Type: raftpb.ConfChangeAddNode, NodeID: 2, Context: b}
+	ents := []raftpb.Entry{
+		{Index: 2, Type: raftpb.EntryConfChange, Data: pbutil.MustMarshal(&cc)},
+		{Index: 3, Data: pbutil.MustMarshal(&pb.Request{Method: "PUT", ID: 1, Path: "/foo", Val: "bar"})},
+		{Index: 4, Data: pbutil.MustMarshal(&pb.Request{Method: "PUT", ID: 2, Path: "/foo", Val: "baz"})},
+	}
+	applied, _ := srv.apply(ents, &raftpb.ConfState{})
+	if applied != 4 {
+		t.Errorf("applied = %d, want 4", applied)
+	}
+	if g := st.Index(); g != index+1 {
+		t.Errorf("store index = %d, want %d", g, index+1)
+	}
+	if g := st.ConsistentIndex(); g != 4 {
+		t.Errorf("consistent index = %d, want 4", g)
+	}
+	// the member is still added to raft
+	if g := n.Action(); len(g) != 1 || g[0].Name != "ApplyConfChange" {
+		t.Errorf("actions = %v, want ApplyConfChange", g)
+	}
+	if g := cl.Member(2); g == nil {
+		t.Errorf("member 2 is removed")
+	}
+}
+
 func TestDoProposal(t *testing.T) {
 	tests := []pb.Request{
 		pb.Request{Method: "POST", ID: 1},
@@ -529,6 +765,7 @@ func TestDoProposal(t *testing.T) {
 				transport:   &nopTransporter{},
 			},
 			store:    st,
+			Cluster:  &Cluster{},
 			reqIDGen: idutil.NewGenerator(0, time.Time{}),
 		}
 		srv.start()
@@ -547,53 +784,780 @@ func TestDoProposal(t *testing.T) {
 			t.Errorf("#%d: resp = %v, want %v", i, resp, wresp)
 		}
 	}
-}
+}
+
+func TestDoProposalCancelled(t *testing.T) {
+	wait := &waitRecorder{}
+	srv := &EtcdServer{
+		r:        raftNode{Node: &nodeRecorder{}},
+		w:        wait,
+		Cluster:  &Cluster{},
+		reqIDGen: idutil.NewGenerator(0, time.Time{}),
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err := srv.Do(ctx, pb.Request{Method: "PUT"})
+
+	if err != ErrCanceled {
+		t.Fatalf("err = %v, want %v", err, ErrCanceled)
+	}
+	w := []testutil.Action{{Name: "Register"}, {Name: "Trigger"}}
+	if !reflect.DeepEqual(wait.action, w) {
+		t.Errorf("wait.action = %+v, want %+v", wait.action, w)
+	}
+}
+
+// TestDoProposalTooManyRequests tests that the client proposals beyond the
+// in-flight limit are rejected, while the internal ones are not.
+func TestDoProposalTooManyRequests(t *testing.T) {
+	n := &nodeRecorder{}
+	srv := &EtcdServer{
+		inflight:    1,
+		maxInflight: 1,
+		r:           raftNode{Node: n},
+		w:           &waitRecorder{},
+		Cluster:     &Cluster{},
+		reqIDGen:    idutil.NewGenerator(0, time.Time{}),
+	}
+	_, err := srv.Do(context.Background(), pb.Request{Method: "PUT", Path: "/1/foo"})
+	if err != ErrTooManyRequests {
+		t.Fatalf("err = %v, want %v", err, ErrTooManyRequests)
+	}
+	if a := n.Action(); len(a) != 0 {
+		t.Errorf("action = %+v, want none", a)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err = srv.Do(ctx, pb.Request{Method: "PUT", Path: path.Join(storeMembersPrefix, "1", attributesSuffix)})
+	if err != ErrCanceled {
+		t.Fatalf("err = %v, want %v", err, ErrCanceled)
+	}
+	if srv.inflight != 1 {
+		t.Errorf("inflight = %d, want 1", srv.inflight)
+	}
+
+	srv.inflight = 0
+	_, err = srv.Do(ctx, pb.Request{Method: "PUT", Path: "/1/foo"})
+	if err != ErrCanceled {
+		t.Fatalf("err = %v, want %v", err, ErrCanceled)
+	}
+	if srv.inflight != 0 {
+		t.Errorf("inflight = %d, want 0", srv.inflight)
+	}
+}
+
+// TestDoLowDiskSpace tests that the proposals of the clients are rejected
+// while the free disk space is low, and the internal requests are not.
+func TestDoLowDiskSpace(t *testing.T) {
+	n := &nodeRecorder{}
+	srv := &EtcdServer{
+		diskLow:  1,
+		r:        raftNode{Node: n},
+		w:        &waitRecorder{},
+		Cluster:  &Cluster{},
+		reqIDGen: idutil.NewGenerator(0, time.Time{}),
+	}
+	_, err := srv.Do(context.Background(), pb.Request{Method: "PUT", Path: "/1/foo"})
+	if err != ErrLowDiskSpace {
+		t.Fatalf("err = %v, want %v", err, ErrLowDiskSpace)
+	}
+	if a := n.Action(); len(a) != 0 {
+		t.Errorf("action = %+v, want none", a)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err = srv.Do(ctx, pb.Request{Method: "PUT", Path: path.Join(storeMembersPrefix, "1", attributesSuffix)})
+	if err != ErrCanceled {
+		t.Fatalf("err = %v, want %v", err, ErrCanceled)
+	}
+}
+
+// TestDoStopping tests that no request is accepted once the server is
+// stopping.
+func TestDoStopping(t *testing.T) {
+	n := &nodeRecorder{}
+	srv := &EtcdServer{
+		stopping: 1,
+		r:        raftNode{Node: n},
+		w:        &waitRecorder{},
+		store:    &storeRecorder{},
+		Cluster:  &Cluster{},
+		reqIDGen: idutil.NewGenerator(0, time.Time{}),
+	}
+	for i, r := range []pb.Request{{Method: "PUT", Path: "/1/foo"}, {Method: "GET", Path: "/1/foo"}} {
+		if _, err := srv.Do(context.Background(), r); err != ErrStopped {
+			t.Errorf("#%d: err = %v, want %v", i, err, ErrStopped)
+		}
+	}
+	if a := n.Action(); len(a) != 0 {
+		t.Errorf("action = %+v, want none", a)
+	}
+	if err := srv.startProposal(); err != ErrStopped {
+		t.Errorf("err = %v, want %v", err, ErrStopped)
+	}
+	if srv.inflight != 0 {
+		t.Errorf("inflight = %d, want 0", srv.inflight)
+	}
+}
+
+func TestDrainProposals(t *testing.T) {
+	srv := &EtcdServer{inflight: 1}
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		srv.finishProposal()
+	}()
+	start := time.Now()
+	srv.drainProposals(time.Second)
+	if d := time.Since(start); d >= time.Second {
+		t.Errorf("drain took %v, want less than 1s", d)
+	}
+
+	// the proposal in flight is never applied
+	srv = &EtcdServer{inflight: 1}
+	start = time.Now()
+	srv.drainProposals(50 * time.Millisecond)
+	if d := time.Since(start); d < 50*time.Millisecond {
+		t.Errorf("drain took %v, want at least 50ms", d)
+	}
+}
+
+type nodeStatus struct {
+	nodeRecorder
+	status raft.Status
+}
+
+func (n *nodeStatus) Status() raft.Status { return n.status }
+
+// TestTransferLeadershipOnStop tests that the leader hands the leadership
+// over to the peer with the highest match index.
+func TestTransferLeadershipOnStop(t *testing.T) {
+	cl := newCluster("abc")
+	cl.SetStore(store.New())
+	cl.SetTransport(&nopTransporter{})
+	for i := uint64(1); i <= 3; i++ {
+		cl.AddMember(newTestMember(i, nil, "", nil), i)
+	}
+	cl.version = "2.1.0"
+	n := &nodeStatus{
+		status: raft.Status{Progress: map[uint64]raft.Progress{
+			1: {Match: 10},
+			2: {Match: 8},
+			3: {Match: 9},
+		}},
+	}
+	srv := &EtcdServer{
+		id:      1,
+		cfg:     &ServerConfig{TickMs: 1, ElectionTicks: 10},
+		r:       raftNode{Node: n, lead: 1},
+		Cluster: cl,
+	}
+	srv.transferLeadershipOnStop()
+
+	w := []testutil.Action{{Name: "TransferLeadership", Params: []interface{}{uint64(1), uint64(3)}}}
+	if g := n.Action(); !reflect.DeepEqual(g, w) {
+		t.Errorf("action = %+v, want %+v", g, w)
+	}
+
+	// followers do not transfer the leadership
+	n = &nodeStatus{status: n.status}
+	srv.r = raftNode{Node: n, lead: 2}
+	srv.transferLeadershipOnStop()
+	if g := n.Action(); len(g) != 0 {
+		t.Errorf("action = %+v, want none", g)
+	}
+}
+
+func TestDoProposalTimeout(t *testing.T) {
+	srv := &EtcdServer{
+		r:        raftNode{Node: &nodeRecorder{}},
+		w:        &waitRecorder{},
+		Cluster:  &Cluster{},
+		reqIDGen: idutil.NewGenerator(0, time.Time{}),
+	}
+	ctx, _ := context.WithTimeout(context.Background(), 0)
+	_, err := srv.Do(ctx, pb.Request{Method: "PUT"})
+	if err != ErrTimeout {
+		t.Fatalf("err = %v, want %v", err, ErrTimeout)
+	}
+}
+
+// TestDoProposalDropped tests that a proposal dropped by raft fails fast
+// with ErrProposalDropped, instead of waiting for the request timeout.
+func TestDoProposalDropped(t *testing.T) {
+	wait := &waitRecorder{}
+	srv := &EtcdServer{
+		r:        raftNode{Node: &nodeProposalDropper{}},
+		w:        wait,
+		Cluster:  &Cluster{},
+		reqIDGen: idutil.NewGenerator(0, time.Time{}),
+	}
+	_, err := srv.Do(context.Background(), pb.Request{Method: "PUT"})
+	if err != ErrProposalDropped {
+		t.Fatalf("err = %v, want %v", err, ErrProposalDropped)
+	}
+	w := []testutil.Action{{Name: "Register"}, {Name: "Trigger"}}
+	if !reflect.DeepEqual(wait.action, w) {
+		t.Errorf("wait.action = %+v, want %+v", wait.action, w)
+	}
+}
+
+// leaderLossNode loses the leader when a proposal is proposed.
+type leaderLossNode struct {
+	nodeRecorder
+	lose func()
+}
+
+func (n *leaderLossNode) Propose(ctx context.Context, data []byte) error {
+	n.lose()
+	return nil
+}
+
+func TestDoProposalTimeoutDueToLeaderFail(t *testing.T) {
+	srv := &EtcdServer{
+		r:        raftNode{lead: 1},
+		w:        &waitRecorder{},
+		Cluster:  &Cluster{},
+		reqIDGen: idutil.NewGenerator(0, time.Time{}),
+	}
+	srv.r.Node = &leaderLossNode{lose: func() { atomic.StoreUint64(&srv.r.lead, 0) }}
+	ctx, cancel := context.WithTimeout(context.Background(), 0)
+	defer cancel()
+	_, err := srv.Do(ctx, pb.Request{Method: "PUT"})
+	if err != ErrTimeoutDueToLeaderFail {
+		t.Fatalf("err = %v, want %v", err, ErrTimeoutDueToLeaderFail)
+	}
+}
+
+func TestDoProposalStopped(t *testing.T) {
+	srv := &EtcdServer{
+		r:        raftNode{Node: &nodeRecorder{}},
+		w:        &waitRecorder{},
+		Cluster:  &Cluster{},
+		reqIDGen: idutil.NewGenerator(0, time.Time{}),
+	}
+	srv.done = make(chan struct{})
+	close(srv.done)
+	_, err := srv.Do(context.Background(), pb.Request{Method: "PUT", ID: 1})
+	if err != ErrStopped {
+		t.Errorf("err = %v, want %v", err, ErrStopped)
+	}
+}
+
+// TestDoRequestTooLarge tests that the client writes beyond the max value
+// or request size are rejected before they are proposed, while the
+// internal ones are not.
+func TestDoRequestTooLarge(t *testing.T) {
+	large := strings.Repeat("a", 11)
+	kv, err := (&kvpb.TxnRequest{Success: []*kvpb.RequestUnion{kvPut("foo", large)}}).Marshal()
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		req  pb.Request
+		werr error
+	}{
+		{pb.Request{Method: "PUT", Path: "/1/foo", Val: large}, ErrValueTooLarge},
+		{pb.Request{Method: "POST", Path: "/1/foo", Val: large}, ErrValueTooLarge},
+		{pb.Request{Method: "TXN", Success: []pb.Request{{Method: "PUT", Path: "/1/foo", Val: large}}}, ErrValueTooLarge},
+		{pb.Request{Method: "TXN", Failure: []pb.Request{{Method: "PUT", Path: "/1/foo", Val: large}}}, ErrValueTooLarge},
+		{pb.Request{Method: "KV", Path: StoreKVPrefix, KV: kv}, ErrValueTooLarge},
+		{pb.Request{Method: "PUT", Path: "/1/" + strings.Repeat("a", 40)}, ErrRequestTooLarge},
+		{
+			pb.Request{Method: "TXN", Success: []pb.Request{
+				{Method: "PUT", Path: "/1/foo", Val: "a"},
+				{Method: "PUT", Path: "/1/bar", Val: "a"},
+				{Method: "PUT", Path: "/1/baz", Val: "a"},
+			}},
+			ErrRequestTooLarge,
+		},
+	}
+	for i, tt := range tests {
+		n := &nodeRecorder{}
+		srv := &EtcdServer{
+			maxValueBytes:   10,
+			maxRequestBytes: 40,
+			r:               raftNode{Node: n},
+			w:               &waitRecorder{},
+			Cluster:         &Cluster{version: "2.1.0"},
+			reqIDGen:        idutil.NewGenerator(0, time.Time{}),
+		}
+		if _, err := srv.Do(context.Background(), tt.req); err != tt.werr {
+			t.Errorf("#%d: err = %v, want %v", i, err, tt.werr)
+		}
+		if a := n.Action(); len(a) != 0 {
+			t.Errorf("#%d: action = %+v, want none", i, a)
+		}
+	}
+
+	n := &nodeRecorder{}
+	srv := &EtcdServer{
+		maxValueBytes:   10,
+		maxRequestBytes: 40,
+		r:               raftNode{Node: n},
+		w:               &waitRecorder{},
+		Cluster:         &Cluster{},
+		reqIDGen:        idutil.NewGenerator(0, time.Time{}),
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err = srv.Do(ctx, pb.Request{Method: "PUT", Path: path.Join(storeMembersPrefix, "1", attributesSuffix), Val: large})
+	if err != ErrCanceled {
+		t.Fatalf("err = %v, want %v", err, ErrCanceled)
+	}
+	if a := n.Action(); len(a) != 1 || a[0].Name != "Propose" {
+		t.Errorf("action = %+v, want a proposal", a)
+	}
+}
+
+// TestDoQuota tests that a write beyond the quota raises the NOSPACE alarm,
+// which rejects the writes except deletes until it is disarmed.
+func TestDoQuota(t *testing.T) {
+	st := store.New()
+	cl := newCluster("abc")
+	cl.SetStore(st)
+	srv := &EtcdServer{
+		id: 1,
+		r: raftNode{
+			Node:        newNodeCommitter(),
+			storage:     &storageRecorder{},
+			raftStorage: raft.NewMemoryStorage(),
+			transport:   &nopTransporter{},
+		},
+		store:    st,
+		Cluster:  cl,
+		quota:    100,
+		reqIDGen: idutil.NewGenerator(0, time.Time{}),
+	}
+	srv.start()
+	defer srv.Stop()
+
+	small := pb.Request{Method: "PUT", Path: "/foo", Val: "bar"}
+	large := pb.Request{Method: "PUT", Path: "/foo", Val: strings.Repeat("a", 100)}
+	if _, err := srv.Do(context.Background(), large); err != ErrNoSpace {
+		t.Fatalf("err = %v, want %v", err, ErrNoSpace)
+	}
+	for i := 0; !cl.IsAlarmActive(AlarmNoSpace); i++ {
+		if i == 100 {
+			t.Fatalf("NOSPACE alarm is not raised")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if w := []Alarm{{MemberID: 1, Type: AlarmNoSpace}}; !reflect.DeepEqual(srv.Alarms(), w) {
+		t.Errorf("alarms = %+v, want %+v", srv.Alarms(), w)
+	}
+	if _, err := srv.Do(context.Background(), small); err != ErrNoSpace {
+		t.Errorf("err = %v, want %v", err, ErrNoSpace)
+	}
+	if _, err := srv.Do(context.Background(), pb.Request{Method: "DELETE", Path: "/foo"}); !isKeyNotFound(err) {
+		t.Errorf("err = %v, want key not found", err)
+	}
+
+	if err := srv.DisarmAlarm(context.Background(), AlarmNoSpace); err != nil {
+		t.Fatalf("unexpected DisarmAlarm error: %v", err)
+	}
+	if len(srv.Alarms()) != 0 {
+		t.Errorf("alarms = %+v, want none", srv.Alarms())
+	}
+	if _, err := srv.Do(context.Background(), small); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if err := srv.DisarmAlarm(context.Background(), AlarmType("FOO")); err != ErrUnknownAlarm {
+		t.Errorf("err = %v, want %v", err, ErrUnknownAlarm)
+	}
+}
+
+// TestApplyRequestNoSpace tests that the NOSPACE alarm rejects the applied
+// writes except deletes and the writes under the admin prefix.
+func TestApplyRequestNoSpace(t *testing.T) {
+	st := store.New()
+	cl := newCluster("abc")
+	cl.SetStore(st)
+	st.Set(alarmStorePath(AlarmNoSpace, 1), false, "", store.Permanent)
+	cl.RecoverAlarms()
+	srv := &EtcdServer{store: st, Cluster: cl}
+
+	tests := []struct {
+		req  pb.Request
+		werr error
+	}{
+		{pb.Request{Method: "PUT", Path: "/1/foo"}, ErrNoSpace},
+		{pb.Request{Method: "POST", Path: "/1/foo"}, ErrNoSpace},
+		{pb.Request{Method: "TXN", Success: []pb.Request{{Method: "PUT", Path: "/1/foo"}}}, ErrNoSpace},
+		{pb.Request{Method: "PUT", Path: "/0/foo"}, nil},
+		{pb.Request{Method: "DELETE", Path: "/0/foo"}, nil},
+	}
+	for i, tt := range tests {
+		resp := srv.applyRequest(tt.req)
+		if resp.err != tt.werr {
+			t.Errorf("#%d: err = %v, want %v", i, resp.err, tt.werr)
+		}
+	}
+}
+
+// TestApplyRequestOnce tests that a request with the token of an applied
+// request gets the result of the applied one back, and is not applied.
+func TestApplyRequestOnce(t *testing.T) {
+	st := store.New()
+	cl := newCluster("abc")
+	cl.SetStore(st)
+	srv := &EtcdServer{store: st, Cluster: cl}
+
+	resp := srv.applyRequestOnce(pb.Request{Method: "POST", Path: "/foo", Val: "bar", Token: "a"})
+	if resp.err != nil {
+		t.Fatalf("unexpected error: %v", resp.err)
+	}
+	index := st.Index()
+	retry := srv.applyRequestOnce(pb.Request{Method: "POST", Path: "/foo", Val: "bar", Token: "a"})
+	if !reflect.DeepEqual(retry, resp) {
+		t.Errorf("retry = %+v, want %+v", retry, resp)
+	}
+	if g := st.Index(); g != index {
+		t.Errorf("index = %d, want %d", g, index)
+	}
+	ev, err := st.Get("/foo", true, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := len(ev.Node.Nodes); n != 1 {
+		t.Errorf("len(nodes) = %d, want 1", n)
+	}
+
+	// the errors of the store are results too
+	resp = srv.applyRequestOnce(pb.Request{Method: "DELETE", Path: "/bar", Token: "b"})
+	st.Set("/bar", false, "", store.Permanent)
+	retry = srv.applyRequestOnce(pb.Request{Method: "DELETE", Path: "/bar", Token: "b"})
+	if e, ok := retry.err.(*etcdErr.Error); !ok || e.ErrorCode != etcdErr.EcodeKeyNotFound {
+		t.Errorf("err = %v, want code %d", retry.err, etcdErr.EcodeKeyNotFound)
+	}
+
+	// a request rejected by the server may be retried
+	st.Set(alarmStorePath(AlarmNoSpace, 1), false, "", store.Permanent)
+	cl.RecoverAlarms()
+	resp = srv.applyRequestOnce(pb.Request{Method: "PUT", Path: "/baz", Token: "c"})
+	if resp.err != ErrNoSpace {
+		t.Errorf("err = %v, want %v", resp.err, ErrNoSpace)
+	}
+	if _, ok := st.RequestResult("c"); ok {
+		t.Errorf("the result of a rejected request is recorded")
+	}
+}
+
+// TestImportInvalid tests that the imports that are invalid, or have a
+// value beyond the max value size, are rejected before being proposed.
+func TestImportInvalid(t *testing.T) {
+	large := strings.Repeat("a", 11)
+	tests := []struct {
+		ex   store.Export
+		werr error
+	}{
+		{store.Export{Version: 2, Node: &store.NodeExtern{Key: "/foo", Dir: true}}, ErrInvalidExport},
+		{store.Export{Version: store.ExportVersion}, ErrInvalidExport},
+		{store.Export{Version: store.ExportVersion, Node: &store.NodeExtern{Key: "/foo", Value: &large}}, ErrValueTooLarge},
+		{
+			store.Export{Version: store.ExportVersion, Node: &store.NodeExtern{
+				Key: "/foo", Dir: true,
+				Nodes: store.NodeExterns{{Key: "/foo/bar", Value: &large}},
+			}},
+			ErrValueTooLarge,
+		},
+	}
+	for i, tt := range tests {
+		n := &nodeRecorder{}
+		srv := &EtcdServer{
+			maxValueBytes: 10,
+			r:             raftNode{Node: n},
+			w:             &waitRecorder{},
+			Cluster:       &Cluster{},
+			reqIDGen:      idutil.NewGenerator(0, time.Time{}),
+		}
+		if _, err := srv.Import(context.Background(), "/1/foo", &tt.ex); err != tt.werr {
+			t.Errorf("#%d: err = %v, want %v", i, err, tt.werr)
+		}
+		if a := n.Action(); len(a) != 0 {
+			t.Errorf("#%d: action = %+v, want none", i, a)
+		}
+	}
+}
+
+// TestApplyRequestDirQuota tests that the applied requests that would take
+// a directory beyond its quota are rejected.
+func TestApplyRequestDirQuota(t *testing.T) {
+	tests := []struct {
+		req   pb.Request
+		wcode int
+	}{
+		{pb.Request{Method: "PUT", Path: "/1/q/b", Val: "12345"}, 0},
+		{pb.Request{Method: "PUT", Path: "/1/q/b", Val: "123456"}, etcdErr.EcodeQuotaExceeded},
+		{pb.Request{Method: "PUT", Path: "/1/q/a", Val: "1234567890"}, 0},
+		{pb.Request{Method: "PUT", Path: "/1/q/a", Val: "12345678901"}, etcdErr.EcodeQuotaExceeded},
+		{pb.Request{Method: "PUT", Path: "/1/q/a", Val: "12345678901", PrevValue: "12345"}, etcdErr.EcodeQuotaExceeded},
+		{pb.Request{Method: "PUT", Path: "/1/q/x/y", Dir: true}, 0},
+		{pb.Request{Method: "PUT", Path: "/1/q/x/y/z", Dir: true}, etcdErr.EcodeQuotaExceeded},
+		{pb.Request{Method: "PUT", Path: "/1/q/a", Refresh: true}, 0},
+		{pb.Request{Method: "POST", Path: "/1/q", Val: "12345"}, 0},
+		{pb.Request{Method: "POST", Path: "/1/q", Val: "123456"}, etcdErr.EcodeQuotaExceeded},
+		{pb.Request{Method: "INCR", Path: "/1/q/n", Delta: 12345}, 0},
+		{pb.Request{Method: "INCR", Path: "/1/q/n", Delta: 123456}, etcdErr.EcodeQuotaExceeded},
+		{pb.Request{Method: "MOVE", Path: "/1/q/a", MoveTo: "/1/q/x/a"}, 0},
+		{pb.Request{Method: "MOVE", Path: "/1/other", MoveTo: "/1/q/other"}, etcdErr.EcodeQuotaExceeded},
+		{pb.Request{Method: "IMPORT", Path: "/1/q/x", Val: `{"version":1,"node":{"key":"/x","dir":true,"nodes":[{"key":"/x/y","value":"12345"}]}}`}, 0},
+		{pb.Request{Method: "IMPORT", Path: "/1/q/x", Val: `{"version":1,"node":{"key":"/x","dir":true,"nodes":[{"key":"/x/y","value":"123456"}]}}`}, etcdErr.EcodeQuotaExceeded},
+		{pb.Request{Method: "IMPORT", Path: "/1/q/x/y/z", Val: `{"version":1,"node":{"key":"/z","value":""}}`}, etcdErr.EcodeQuotaExceeded},
+		{pb.Request{Method: "TXN", Success: []pb.Request{{Method: "PUT", Path: "/1/q/b", Val: "123456"}}}, etcdErr.EcodeQuotaExceeded},
+		{pb.Request{Method: "TXN", Failure: []pb.Request{{Method: "PUT", Path: "/1/q/b", Val: "123456"}}}, etcdErr.EcodeQuotaExceeded},
+		{
+			pb.Request{Method: "TXN", Success: []pb.Request{
+				{Method: "DELETE", Path: "/1/q/a"},
+				{Method: "PUT", Path: "/1/q/b", Val: "123456"},
+			}},
+			0,
+		},
+		{pb.Request{Method: "PUT", Path: "/1/other", Val: "1234567890"}, 0},
+		{pb.Request{Method: "DELETE", Path: "/1/q/a"}, 0},
+	}
+	for i, tt := range tests {
+		st := store.New()
+		cl := newCluster("abc")
+		cl.SetStore(st)
+		st.Set(dirQuotaStorePath("/q"), false, `{"dir":"/q","maxKeys":3,"maxBytes":10}`, store.Permanent)
+		st.Set("/1/q/a", false, "12345", store.Permanent)
+		st.Set("/1/other", false, "123456", store.Permanent)
+		cl.RecoverDirQuotas()
+		srv := &EtcdServer{store: st, Cluster: cl}
+
+		resp := srv.applyRequest(tt.req)
+		if tt.wcode == 0 {
+			if resp.err != nil {
+				t.Errorf("#%d: unexpected error: %v", i, resp.err)
+			}
+			continue
+		}
+		if e, ok := resp.err.(*etcdErr.Error); !ok || e.ErrorCode != tt.wcode || e.Cause != "/1/q" {
+			t.Errorf("#%d: err = %v, want code %d on /1/q", i, resp.err, tt.wcode)
+		}
+	}
+}
+
+// TestDoDirQuota tests that the quotas of the directories are set and
+// removed through consensus.
+func TestDoDirQuota(t *testing.T) {
+	st := store.New()
+	cl := newCluster("abc")
+	cl.SetStore(st)
+	srv := &EtcdServer{
+		id: 1,
+		r: raftNode{
+			Node:        newNodeCommitter(),
+			storage:     &storageRecorder{},
+			raftStorage: raft.NewMemoryStorage(),
+			transport:   &nopTransporter{},
+		},
+		store:    st,
+		Cluster:  cl,
+		reqIDGen: idutil.NewGenerator(0, time.Time{}),
+	}
+	srv.start()
+	defer srv.Stop()
+
+	if err := srv.SetDirQuota(context.Background(), DirQuota{Dir: "foo/", MaxKeys: 1}); err != nil {
+		t.Fatalf("unexpected SetDirQuota error: %v", err)
+	}
+	if w := []DirQuota{{Dir: "/foo", MaxKeys: 1}}; !reflect.DeepEqual(srv.DirQuotas(), w) {
+		t.Errorf("quotas = %+v, want %+v", srv.DirQuotas(), w)
+	}
+	if _, err := srv.Do(context.Background(), pb.Request{Method: "PUT", Path: "/1/foo/a"}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, err := srv.Do(context.Background(), pb.Request{Method: "PUT", Path: "/1/foo/b"}); !isQuotaExceeded(err) {
+		t.Errorf("err = %v, want quota exceeded", err)
+	}
+	if err := srv.SetDirQuota(context.Background(), DirQuota{Dir: "/foo", MaxKeys: -1}); err != ErrInvalidDirQuota {
+		t.Errorf("err = %v, want %v", err, ErrInvalidDirQuota)
+	}
+
+	if err := srv.RemoveDirQuota(context.Background(), "/foo"); err != nil {
+		t.Fatalf("unexpected RemoveDirQuota error: %v", err)
+	}
+	if len(srv.DirQuotas()) != 0 {
+		t.Errorf("quotas = %+v, want none", srv.DirQuotas())
+	}
+	if _, err := srv.Do(context.Background(), pb.Request{Method: "PUT", Path: "/1/foo/b"}); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if err := srv.RemoveDirQuota(context.Background(), "/foo"); err != nil {
+		t.Errorf("unexpected RemoveDirQuota error: %v", err)
+	}
+}
+
+// TestDoRuntimeConfig tests that the runtime config of the local member is
+// applied over the flags once committed, and that the flags are applied
+// again once it is removed.
+func TestDoRuntimeConfig(t *testing.T) {
+	st := store.New()
+	cl := newTestCluster([]*Member{{ID: 1}, {ID: 2}})
+	cl.SetStore(st)
+	srv := &EtcdServer{
+		id:        1,
+		snapCount: 100,
+		r: raftNode{
+			Node:          newNodeCommitter(),
+			storage:       &storageRecorder{},
+			raftStorage:   raft.NewMemoryStorage(),
+			transport:     &nopTransporter{},
+			syncIntervalc: make(chan time.Duration, 1),
+		},
+		store:        st,
+		Cluster:      cl,
+		reqIDGen:     idutil.NewGenerator(0, time.Time{}),
+		flagConfig:   flagRuntimeConfig(&ServerConfig{SnapCount: 100, TickMs: 100, ElectionTicks: 10}),
+		syncInterval: DefaultSyncInterval,
+	}
+	srv.start()
+	defer srv.Stop()
+	defer raftLog.setDebug(false)
+
+	snapCount, syncMs, slowMs := uint64(5), int64(0), int64(20)
+	c := RuntimeConfig{MemberID: 1, SnapCount: &snapCount, SyncIntervalMs: &syncMs}
+	if err := srv.SetRuntimeConfig(context.Background(), c); err != nil {
+		t.Fatalf("unexpected SetRuntimeConfig error: %v", err)
+	}
+	// merged into the runtime config set before
+	c = RuntimeConfig{MemberID: 1, SlowRequestThresholdMs: &slowMs, LogLevel: LogLevelDebug}
+	if err := srv.SetRuntimeConfig(context.Background(), c); err != nil {
+		t.Fatalf("unexpected SetRuntimeConfig error: %v", err)
+	}
+	w := []RuntimeConfig{{MemberID: 1, SnapCount: &snapCount, SyncIntervalMs: &syncMs, SlowRequestThresholdMs: &slowMs, LogLevel: LogLevelDebug}}
+	if g := srv.RuntimeConfigs(); !reflect.DeepEqual(g, w) {
+		t.Errorf("configs = %+v, want %+v", g, w)
+	}
+	if srv.snapCount != 5 {
+		t.Errorf("snapCount = %d, want 5", srv.snapCount)
+	}
+	if srv.syncInterval != 0 {
+		t.Errorf("sync interval = %v, want 0", srv.syncInterval)
+	}
+	if g := time.Duration(atomic.LoadInt64(&srv.slowThreshold)); g != 20*time.Millisecond {
+		t.Errorf("slow threshold = %v, want %v", g, 20*time.Millisecond)
+	}
+	if atomic.LoadInt32(&raftLog.debug) != 1 {
+		t.Errorf("raft debug logging disabled, want enabled")
+	}
 
-func TestDoProposalCancelled(t *testing.T) {
-	wait := &waitRecorder{}
-	srv := &EtcdServer{
-		r:        raftNode{Node: &nodeRecorder{}},
-		w:        wait,
-		reqIDGen: idutil.NewGenerator(0, time.Time{}),
+	// the runtime config of another member is not applied locally
+	snapCount2 := uint64(7)
+	if err := srv.SetRuntimeConfig(context.Background(), RuntimeConfig{MemberID: 2, SnapCount: &snapCount2}); err != nil {
+		t.Fatalf("unexpected SetRuntimeConfig error: %v", err)
+	}
+	if srv.snapCount != 5 {
+		t.Errorf("snapCount = %d, want 5", srv.snapCount)
 	}
-	ctx, cancel := context.WithCancel(context.Background())
-	cancel()
-	_, err := srv.Do(ctx, pb.Request{Method: "PUT"})
 
-	if err != ErrCanceled {
-		t.Fatalf("err = %v, want %v", err, ErrCanceled)
+	zero := uint64(0)
+	tests := []RuntimeConfig{
+		{MemberID: 1, SnapCount: &zero},
+		{MemberID: 1, LogLevel: "verbose"},
 	}
-	w := []testutil.Action{{Name: "Register"}, {Name: "Trigger"}}
-	if !reflect.DeepEqual(wait.action, w) {
-		t.Errorf("wait.action = %+v, want %+v", wait.action, w)
+	for i, tt := range tests {
+		if err := srv.SetRuntimeConfig(context.Background(), tt); err != ErrInvalidRuntimeConfig {
+			t.Errorf("#%d: err = %v, want %v", i, err, ErrInvalidRuntimeConfig)
+		}
+	}
+	if err := srv.SetRuntimeConfig(context.Background(), RuntimeConfig{MemberID: 3, SnapCount: &snapCount}); err != ErrIDNotFound {
+		t.Errorf("err = %v, want %v", err, ErrIDNotFound)
 	}
-}
 
-func TestDoProposalTimeout(t *testing.T) {
-	srv := &EtcdServer{
-		r:        raftNode{Node: &nodeRecorder{}},
-		w:        &waitRecorder{},
-		reqIDGen: idutil.NewGenerator(0, time.Time{}),
+	if err := srv.RemoveRuntimeConfig(context.Background(), 1); err != nil {
+		t.Fatalf("unexpected RemoveRuntimeConfig error: %v", err)
 	}
-	ctx, _ := context.WithTimeout(context.Background(), 0)
-	_, err := srv.Do(ctx, pb.Request{Method: "PUT"})
-	if err != ErrTimeout {
-		t.Fatalf("err = %v, want %v", err, ErrTimeout)
+	if srv.snapCount != 100 {
+		t.Errorf("snapCount = %d, want 100", srv.snapCount)
+	}
+	if srv.syncInterval != DefaultSyncInterval {
+		t.Errorf("sync interval = %v, want %v", srv.syncInterval, DefaultSyncInterval)
+	}
+	if atomic.LoadInt32(&raftLog.debug) != 0 {
+		t.Errorf("raft debug logging enabled, want disabled")
+	}
+	if len(srv.RuntimeConfigs()) != 1 {
+		t.Errorf("configs = %+v, want the one of member 2 only", srv.RuntimeConfigs())
 	}
 }
 
-func TestDoProposalStopped(t *testing.T) {
+// TestUpdateClusterConfig tests that the cluster config is replicated
+// through the store and overrides the limits given by the flags.
+func TestUpdateClusterConfig(t *testing.T) {
+	st := store.New()
+	cl := newTestCluster([]*Member{{ID: 1}})
+	cl.SetStore(st)
+	cfg := &ServerConfig{QuotaBackendBytes: 1000, MaxValueBytes: -1}
 	srv := &EtcdServer{
-		r:        raftNode{Node: &nodeRecorder{}},
-		w:        &waitRecorder{},
-		reqIDGen: idutil.NewGenerator(0, time.Time{}),
+		id: 1,
+		r: raftNode{
+			Node:        newNodeCommitter(),
+			storage:     &storageRecorder{},
+			raftStorage: raft.NewMemoryStorage(),
+			transport:   &nopTransporter{},
+		},
+		store:             st,
+		Cluster:           cl,
+		reqIDGen:          idutil.NewGenerator(0, time.Time{}),
+		flagClusterConfig: flagClusterConfig(cfg),
 	}
-	srv.done = make(chan struct{})
-	close(srv.done)
-	_, err := srv.Do(context.Background(), pb.Request{Method: "PUT", ID: 1})
-	if err != ErrStopped {
-		t.Errorf("err = %v, want %v", err, ErrStopped)
+	srv.applyClusterConfig()
+	srv.start()
+	defer srv.Stop()
+
+	if srv.quota != 1000 || srv.maxValueBytes != 0 || srv.maxRequestBytes != DefaultMaxRequestBytes {
+		t.Fatalf("limits = %d %d %d, want 1000 0 %d", srv.quota, srv.maxValueBytes, srv.maxRequestBytes, DefaultMaxRequestBytes)
+	}
+
+	activeSize, quota, maxValue := 3, int64(-1), int64(10)
+	if err := srv.UpdateClusterConfig(context.Background(), ClusterConfig{ActiveSize: &activeSize, QuotaBackendBytes: &quota}); err != nil {
+		t.Fatalf("unexpected UpdateClusterConfig error: %v", err)
+	}
+	// merged into the cluster config set before
+	if err := srv.UpdateClusterConfig(context.Background(), ClusterConfig{MaxValueBytes: &maxValue}); err != nil {
+		t.Fatalf("unexpected UpdateClusterConfig error: %v", err)
+	}
+	w := ClusterConfig{ActiveSize: &activeSize, QuotaBackendBytes: &quota, MaxValueBytes: &maxValue}
+	if g := srv.ClusterConfig(); !reflect.DeepEqual(g, w) {
+		t.Errorf("config = %+v, want %+v", g, w)
+	}
+	if g := clusterConfigFromStore(st); !reflect.DeepEqual(g, w) {
+		t.Errorf("stored config = %+v, want %+v", g, w)
+	}
+	if q, v := atomic.LoadInt64(&srv.quota), atomic.LoadInt64(&srv.maxValueBytes); q != 0 || v != 10 {
+		t.Errorf("quota, max value bytes = %d, %d, want 0, 10", q, v)
+	}
+	if _, err := srv.Do(context.Background(), pb.Request{Method: "PUT", Path: "/foo", Val: "0123456789a"}); err != ErrValueTooLarge {
+		t.Errorf("err = %v, want %v", err, ErrValueTooLarge)
+	}
+
+	negative := -1
+	if err := srv.UpdateClusterConfig(context.Background(), ClusterConfig{ActiveSize: &negative}); err != ErrInvalidClusterConfig {
+		t.Errorf("err = %v, want %v", err, ErrInvalidClusterConfig)
+	}
+
+	if err := srv.ResetClusterConfig(context.Background()); err != nil {
+		t.Fatalf("unexpected ResetClusterConfig error: %v", err)
+	}
+	// reset twice
+	if err := srv.ResetClusterConfig(context.Background()); err != nil {
+		t.Fatalf("unexpected ResetClusterConfig error: %v", err)
 	}
+	if g := srv.ClusterConfig(); !reflect.DeepEqual(g, ClusterConfig{}) {
+		t.Errorf("config = %+v, want empty", g)
+	}
+	if q, v := atomic.LoadInt64(&srv.quota), atomic.LoadInt64(&srv.maxValueBytes); q != 1000 || v != 0 {
+		t.Errorf("quota, max value bytes = %d, %d, want 1000, 0", q, v)
+	}
+}
+
+func isQuotaExceeded(err error) bool {
+	e, ok := err.(*etcdErr.Error)
+	return ok && e.ErrorCode == etcdErr.EcodeQuotaExceeded
 }
 
 // TestSync tests sync 1. is nonblocking 2. proposes SYNC request.
@@ -732,6 +1696,154 @@ func TestSnapshot(t *testing.T) {
 	}
 }
 
+// TestSnapshotCatchUpEntries tests that the raft log is compacted to the
+// configured number of entries before the snapshot.
+func TestSnapshotCatchUpEntries(t *testing.T) {
+	s := raft.NewMemoryStorage()
+	for i := uint64(1); i <= 10; i++ {
+		s.Append([]raftpb.Entry{{Index: i, Term: 1}})
+	}
+	srv := &EtcdServer{
+		r: raftNode{
+			Node:        &nodeRecorder{},
+			raftStorage: s,
+			storage:     &storageRecorder{},
+		},
+		store:          &storeRecorder{},
+		catchUpEntries: 3,
+	}
+	<-srv.snapshot(8, raftpb.ConfState{Nodes: []uint64{1}})
+	if fi, _ := s.FirstIndex(); fi != 6 {
+		t.Errorf("firstIndex = %d, want 6", fi)
+	}
+}
+
+func TestRaftProgress(t *testing.T) {
+	tests := []struct {
+		r raftNode
+
+		wcommitted, wapplied, wterm uint64
+	}{
+		// no hard state received yet
+		{raftNode{index: 5, term: 2}, 5, 5, 2},
+		{raftNode{index: 5, term: 2, committed: 7, currentTerm: 3}, 7, 5, 3},
+	}
+	for i, tt := range tests {
+		srv := &EtcdServer{r: tt.r}
+		if g := srv.CommittedIndex(); g != tt.wcommitted {
+			t.Errorf("#%d: committed index = %d, want %d", i, g, tt.wcommitted)
+		}
+		if g := srv.AppliedIndex(); g != tt.wapplied {
+			t.Errorf("#%d: applied index = %d, want %d", i, g, tt.wapplied)
+		}
+		if g := srv.CurrentTerm(); g != tt.wterm {
+			t.Errorf("#%d: current term = %d, want %d", i, g, tt.wterm)
+		}
+	}
+}
+
+// TestSnapshotOnDemand tests that Snapshot saves a snapshot at the applied
+// index at once, and returns the last snapshot if nothing is applied since.
+func TestSnapshotOnDemand(t *testing.T) {
+	rs := raft.NewMemoryStorage()
+	p := &storageRecorder{}
+	cl := newCluster("abc")
+	cl.SetStore(store.New())
+	srv := &EtcdServer{
+		r: raftNode{
+			Node:        newNodeCommitter(),
+			raftStorage: rs,
+			storage:     p,
+			transport:   &nopTransporter{},
+		},
+		store:    cl.store,
+		Cluster:  cl,
+		reqIDGen: idutil.NewGenerator(0, time.Time{}),
+	}
+	srv.start()
+	defer srv.Stop()
+
+	if _, err := srv.Do(context.Background(), pb.Request{Method: "PUT", Path: "/foo", Val: "bar"}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	index, err := srv.Snapshot(context.Background())
+	if err != nil {
+		t.Fatalf("unexpected Snapshot error: %v", err)
+	}
+	if index != 1 {
+		t.Errorf("index = %d, want 1", index)
+	}
+	snap, err := rs.Snapshot()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if snap.Metadata.Index != index {
+		t.Errorf("snapshot index = %d, want %d", snap.Metadata.Index, index)
+	}
+	if n := countSaveSnap(p.Action()); n != 1 {
+		t.Errorf("SaveSnap count = %d, want 1", n)
+	}
+
+	// nothing is applied since the last snapshot
+	if index, err = srv.Snapshot(context.Background()); err != nil || index != 1 {
+		t.Errorf("index, err = %d, %v, want 1, nil", index, err)
+	}
+	if n := countSaveSnap(p.Action()); n != 1 {
+		t.Errorf("SaveSnap count = %d, want 1", n)
+	}
+}
+
+// TestBackup tests that Backup returns a snapshot of the applied entries.
+func TestBackup(t *testing.T) {
+	cl := newCluster("abc")
+	cl.SetStore(store.New())
+	srv := &EtcdServer{
+		r: raftNode{
+			Node:        newNodeCommitter(),
+			raftStorage: raft.NewMemoryStorage(),
+			storage:     &storageRecorder{},
+			transport:   &nopTransporter{},
+		},
+		store:    cl.store,
+		Cluster:  cl,
+		reqIDGen: idutil.NewGenerator(0, time.Time{}),
+	}
+	srv.start()
+	defer srv.Stop()
+
+	if _, err := srv.Do(context.Background(), pb.Request{Method: "PUT", Path: "/foo", Val: "bar"}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	snap, err := srv.Backup(context.Background())
+	if err != nil {
+		t.Fatalf("unexpected Backup error: %v", err)
+	}
+	if snap.Metadata.Index != 1 {
+		t.Errorf("index = %d, want 1", snap.Metadata.Index)
+	}
+	st := store.New()
+	if err := st.Recovery(snap.Data); err != nil {
+		t.Fatalf("recovery error: %v", err)
+	}
+	ev, err := st.Get("/foo", false, false)
+	if err != nil {
+		t.Fatalf("unexpected get error: %v", err)
+	}
+	if *ev.Node.Value != "bar" {
+		t.Errorf("value = %s, want bar", *ev.Node.Value)
+	}
+}
+
+func countSaveSnap(actions []testutil.Action) int {
+	n := 0
+	for _, a := range actions {
+		if a.Name == "SaveSnap" {
+			n++
+		}
+	}
+	return n
+}
+
 // Applied > SnapCount should trigger a SaveSnap event
 func TestTriggerSnap(t *testing.T) {
 	snapc := 10
@@ -746,6 +1858,7 @@ func TestTriggerSnap(t *testing.T) {
 			transport:   &nopTransporter{},
 		},
 		store:    st,
+		Cluster:  &Cluster{},
 		reqIDGen: idutil.NewGenerator(0, time.Time{}),
 	}
 	srv.start()
@@ -846,6 +1959,52 @@ func TestApplySnapshotAndCommittedEntries(t *testing.T) {
 	}
 }
 
+// TestApplyNotBlockRaft tests that the raft routine saves the next ready
+// while the entries of the previous one are still being applied, and that
+// the applies keep the order of the entries.
+func TestApplyNotBlockRaft(t *testing.T) {
+	n := newReadyNode()
+	st := &blockingStore{unblockc: make(chan struct{})}
+	p := &storageRecorder{}
+	cl := newCluster("abc")
+	cl.SetStore(store.New())
+	cl.SetTransport(&nopTransporter{})
+	s := &EtcdServer{
+		r: raftNode{
+			Node:        n,
+			storage:     p,
+			raftStorage: raft.NewMemoryStorage(),
+			transport:   &nopTransporter{},
+		},
+		store:   st,
+		Cluster: cl,
+	}
+
+	s.start()
+	for i := uint64(1); i <= 3; i++ {
+		req := &pb.Request{Method: "QGET", Path: fmt.Sprintf("/%d", i)}
+		n.readyc <- raft.Ready{CommittedEntries: []raftpb.Entry{{Index: i, Data: pbutil.MustMarshal(req)}}}
+	}
+	// make goroutines move forward to save the readies
+	testutil.ForceGosched()
+
+	wactions := []testutil.Action{{Name: "Save"}, {Name: "Save"}, {Name: "Save"}}
+	if g := p.Action(); !reflect.DeepEqual(g, wactions) {
+		t.Errorf("storage action = %v, want %v", g, wactions)
+	}
+	close(st.unblockc)
+	testutil.ForceGosched()
+	s.Stop()
+
+	var paths []string
+	for _, a := range st.Action() {
+		paths = append(paths, a.Params[0].(string))
+	}
+	if w := []string{"/1", "/2", "/3"}; !reflect.DeepEqual(paths, w) {
+		t.Errorf("applied paths = %v, want %v", paths, w)
+	}
+}
+
 // TestAddMember tests AddMember can propose and perform node addition.
 func TestAddMember(t *testing.T) {
 	n := newNodeConfChangeCommitterRecorder()
@@ -974,6 +2133,53 @@ func TestUpdateMember(t *testing.T) {
 	}
 }
 
+func TestTransferLeadership(t *testing.T) {
+	tests := []struct {
+		lead       uint64
+		transferee types.ID
+		cv         string
+
+		werr     error
+		wactions []testutil.Action
+	}{
+		// unknown member
+		{1, 3, "2.1.0", ErrIDNotFound, []testutil.Action{}},
+		// no leader
+		{raft.None, 2, "2.1.0", ErrNoLeader, []testutil.Action{}},
+		// transferee is already the leader
+		{2, 2, "2.1.0", nil, []testutil.Action{}},
+		// transferee does not take over in time
+		{
+			1, 2, "2.1.0",
+			ErrTimeoutLeaderTransfer,
+			[]testutil.Action{{Name: "TransferLeadership", Params: []interface{}{uint64(1), uint64(2)}}},
+		},
+		// the members of an older version cannot read MsgTimeoutNow
+		{1, 2, "", ErrNotCapable, []testutil.Action{}},
+		{1, 2, "2.0.0", ErrNotCapable, []testutil.Action{}},
+	}
+	for i, tt := range tests {
+		n := &nodeRecorder{}
+		cl := newTestCluster([]*Member{{ID: 1}, {ID: 2}})
+		cl.version = tt.cv
+		s := &EtcdServer{
+			cfg:     &ServerConfig{TickMs: 1},
+			r:       raftNode{Node: n, lead: tt.lead},
+			Cluster: cl,
+			done:    make(chan struct{}),
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+		err := s.TransferLeadership(ctx, tt.transferee)
+		cancel()
+		if err != tt.werr {
+			t.Errorf("#%d: err = %v, want %v", i, err, tt.werr)
+		}
+		if g := n.Action(); !reflect.DeepEqual(g, tt.wactions) {
+			t.Errorf("#%d: action = %v, want %v", i, g, tt.wactions)
+		}
+	}
+}
+
 // TODO: test server could stop itself when being removed
 
 func TestPublish(t *testing.T) {
@@ -1137,6 +2343,13 @@ func (s *storeRecorder) Get(path string, recursive, sorted bool) (*store.Event,
 	})
 	return &store.Event{}, nil
 }
+func (s *storeRecorder) GetPage(path string, recursive bool, limit int, after string) (*store.Event, error) {
+	s.Record(testutil.Action{
+		Name:   "GetPage",
+		Params: []interface{}{path, recursive, limit, after},
+	})
+	return &store.Event{}, nil
+}
 func (s *storeRecorder) Set(path string, dir bool, val string, expr time.Time) (*store.Event, error) {
 	s.Record(testutil.Action{
 		Name:   "Set",
@@ -1151,6 +2364,41 @@ func (s *storeRecorder) Update(path, val string, expr time.Time) (*store.Event,
 	})
 	return &store.Event{}, nil
 }
+func (s *storeRecorder) Incr(path string, delta int64) (*store.Event, error) {
+	s.Record(testutil.Action{
+		Name:   "Incr",
+		Params: []interface{}{path, delta},
+	})
+	return &store.Event{}, nil
+}
+func (s *storeRecorder) Move(path, newPath string) (*store.Event, error) {
+	s.Record(testutil.Action{
+		Name:   "Move",
+		Params: []interface{}{path, newPath},
+	})
+	return &store.Event{}, nil
+}
+func (s *storeRecorder) Export(path string) (*store.Export, error) {
+	s.Record(testutil.Action{
+		Name:   "Export",
+		Params: []interface{}{path},
+	})
+	return &store.Export{}, nil
+}
+func (s *storeRecorder) Import(path string, ex *store.Export) (*store.Event, error) {
+	s.Record(testutil.Action{
+		Name:   "Import",
+		Params: []interface{}{path, ex},
+	})
+	return &store.Event{}, nil
+}
+func (s *storeRecorder) Refresh(path string, expr time.Time) (*store.Event, error) {
+	s.Record(testutil.Action{
+		Name:   "Refresh",
+		Params: []interface{}{path, expr},
+	})
+	return &store.Event{}, nil
+}
 func (s *storeRecorder) Create(path string, dir bool, val string, uniq bool, exp time.Time) (*store.Event, error) {
 	s.Record(testutil.Action{
 		Name:   "Create",
@@ -1179,7 +2427,21 @@ func (s *storeRecorder) CompareAndDelete(path, prevVal string, prevIdx uint64) (
 	})
 	return &store.Event{}, nil
 }
-func (s *storeRecorder) Watch(_ string, _, _ bool, _ uint64) (store.Watcher, error) {
+func (s *storeRecorder) CompareAndDeleteDir(path, marker, prevVal string, prevIdx uint64, recursive bool) (*store.Event, error) {
+	s.Record(testutil.Action{
+		Name:   "CompareAndDeleteDir",
+		Params: []interface{}{path, marker, prevVal, prevIdx, recursive},
+	})
+	return &store.Event{}, nil
+}
+func (s *storeRecorder) Txn(cmps []store.TxnCompare, success, failure []store.TxnOp) (*store.TxnResponse, error) {
+	s.Record(testutil.Action{
+		Name:   "Txn",
+		Params: []interface{}{cmps, success, failure},
+	})
+	return &store.TxnResponse{}, nil
+}
+func (s *storeRecorder) Watch(_ string, _, _ bool, _ uint64, _ []string) (store.Watcher, error) {
 	s.Record(testutil.Action{Name: "Watch"})
 	return &nopWatcher{}, nil
 }
@@ -1202,7 +2464,20 @@ func (s *storeRecorder) Clone() store.Store {
 	return s
 }
 
-func (s *storeRecorder) JsonStats() []byte { return nil }
+func (s *storeRecorder) JsonStats() []byte            { return nil }
+func (s *storeRecorder) OldestWatchableIndex() uint64 { return 0 }
+func (s *storeRecorder) Size() int64                  { return 0 }
+
+func (s *storeRecorder) Usage(string) (int64, int64, error) { return 0, 0, nil }
+func (s *storeRecorder) MemoryUsage() store.MemoryUsage     { return store.MemoryUsage{} }
+func (s *storeRecorder) SetSnapshotFormat(string)           {}
+func (s *storeRecorder) ConsistentIndex() uint64            { return 0 }
+func (s *storeRecorder) SetConsistentIndex(uint64)          {}
+func (s *storeRecorder) RequestResult(string) (*store.RequestResult, bool) {
+	return nil, false
+}
+func (s *storeRecorder) RecordRequestResult(*store.RequestResult) {}
+
 func (s *storeRecorder) DeleteExpiredKeys(cutoff time.Time) {
 	s.Record(testutil.Action{
 		Name:   "DeleteExpiredKeys",
@@ -1210,6 +2485,13 @@ func (s *storeRecorder) DeleteExpiredKeys(cutoff time.Time) {
 	})
 }
 
+func (s *storeRecorder) CompactHistory(index uint64) {
+	s.Record(testutil.Action{
+		Name:   "CompactHistory",
+		Params: []interface{}{index},
+	})
+}
+
 type nopWatcher struct{}
 
 func (w *nopWatcher) EventChan() chan *store.Event { return nil }
@@ -1227,8 +2509,8 @@ func (s *errStoreRecorder) Get(path string, recursive, sorted bool) (*store.Even
 	s.storeRecorder.Get(path, recursive, sorted)
 	return nil, s.err
 }
-func (s *errStoreRecorder) Watch(path string, recursive, sorted bool, index uint64) (store.Watcher, error) {
-	s.storeRecorder.Watch(path, recursive, sorted, index)
+func (s *errStoreRecorder) Watch(path string, recursive, sorted bool, index uint64, actions []string) (store.Watcher, error) {
+	s.storeRecorder.Watch(path, recursive, sorted, index, actions)
 	return nil, s.err
 }
 
@@ -1253,6 +2535,17 @@ func (w *waitWithResponse) Register(id uint64) <-chan interface{} {
 }
 func (w *waitWithResponse) Trigger(id uint64, x interface{}) {}
 
+// blockingStore blocks the gets until unblockc is closed.
+type blockingStore struct {
+	storeRecorder
+	unblockc chan struct{}
+}
+
+func (s *blockingStore) Get(path string, recursive, sorted bool) (*store.Event, error) {
+	<-s.unblockc
+	return s.storeRecorder.Get(path, recursive, sorted)
+}
+
 type storageRecorder struct{ testutil.Recorder }
 
 func (p *storageRecorder) Save(st raftpb.HardState, ents []raftpb.Entry) error {
@@ -1286,6 +2579,10 @@ func (n *nodeRecorder) Step(ctx context.Context, msg raftpb.Message) error {
 	n.Record(testutil.Action{Name: "Step"})
 	return nil
 }
+func (n *nodeRecorder) ReadIndex(ctx context.Context, rctx []byte) error {
+	n.Record(testutil.Action{Name: "ReadIndex"})
+	return nil
+}
 func (n *nodeRecorder) Status() raft.Status      { return raft.Status{} }
 func (n *nodeRecorder) Ready() <-chan raft.Ready { return nil }
 func (n *nodeRecorder) Advance()                 {}
@@ -1302,6 +2599,12 @@ func (n *nodeRecorder) ReportUnreachable(id uint64) {}
 
 func (n *nodeRecorder) ReportSnapshot(id uint64, status raft.SnapshotStatus) {}
 
+func (n *nodeRecorder) TransferLeadership(ctx context.Context, lead, transferee uint64) {
+	n.Record(testutil.Action{Name: "TransferLeadership", Params: []interface{}{lead, transferee}})
+}
+
+func (n *nodeRecorder) Unquiesce() {}
+
 func (n *nodeRecorder) Compact(index uint64, nodes []uint64, d []byte) {
 	n.Record(testutil.Action{Name: "Compact"})
 }
@@ -1316,6 +2619,16 @@ func (n *nodeProposalBlockerRecorder) Propose(ctx context.Context, data []byte)
 	return nil
 }
 
+// nodeProposalDropper drops all the proposals, as raft does without a
+// leader.
+type nodeProposalDropper struct {
+	nodeRecorder
+}
+
+func (n *nodeProposalDropper) Propose(ctx context.Context, data []byte) error {
+	return raft.ErrProposalDropped
+}
+
 type nodeConfChangeCommitterRecorder struct {
 	nodeRecorder
 	readyc chan raft.Ready
@@ -1390,3 +2703,10 @@ func (s *nopTransporter) UpdatePeer(id types.ID, us []string) {}
 func (s *nopTransporter) Stop()                               {}
 func (s *nopTransporter) Pause()                              {}
 func (s *nopTransporter) Resume()                             {}
+func (s *nopTransporter) UnreachableSince(id types.ID) time.Time {
+	return time.Time{}
+}
+func (s *nopTransporter) PeerVersion(id types.ID) (rafthttp.PeerVersion, bool) {
+	return rafthttp.PeerVersion{}, false
+}
+func (s *nopTransporter) ReceivingSnapshot() bool { return false }
@@ -0,0 +1,76 @@
+// Copyright 2015 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package etcdserver
+
+import (
+	"sync/atomic"
+	"time"
+
+	"github.com/coreos/etcd/raft"
+	"github.com/coreos/etcd/raft/raftpb"
+)
+
+// StalenessChecker bounds how far the local store of the member may lag
+// behind the leader for a read to be served from it.
+type StalenessChecker interface {
+	// CheckStaleness returns ErrTooStale if the applied index of the member
+	// is more than maxEntries entries behind the commit index of the leader,
+	// or the member has not heard from the leader for more than maxLag.
+	// A zero bound is not checked. Without a leader to measure against,
+	// the member is too stale.
+	CheckStaleness(maxEntries uint64, maxLag time.Duration) error
+}
+
+// 检查本地store相对leader的落后程度，用于follower的有界过期读
+func (s *EtcdServer) CheckStaleness(maxEntries uint64, maxLag time.Duration) error {
+	lead := s.Lead()
+	if lead == raft.None {
+		return ErrTooStale
+	}
+	commit := s.CommittedIndex()
+	if lead != uint64(s.id) {
+		// the leader knows the latest commit index; a follower only knows
+		// what the leader told it last.
+		if c := atomic.LoadUint64(&s.r.leadCommit); c > commit {
+			commit = c
+		}
+		contact := time.Unix(0, atomic.LoadInt64(&s.r.leadContact))
+		if maxLag > 0 && time.Since(contact) > maxLag {
+			return ErrTooStale
+		}
+	}
+	if maxEntries > 0 && commit-s.AppliedIndex() > maxEntries {
+		return ErrTooStale
+	}
+	return nil
+}
+
+// observeLeader records the commit index carried by a heartbeat or an
+// append from the leader, and when it is received.
+func (s *EtcdServer) observeLeader(m raftpb.Message) {
+	if m.Type != raftpb.MsgHeartbeat && m.Type != raftpb.MsgApp {
+		return
+	}
+	if m.From != s.Lead() {
+		return
+	}
+	atomic.StoreInt64(&s.r.leadContact, time.Now().UnixNano())
+	for {
+		c := atomic.LoadUint64(&s.r.leadCommit)
+		if m.Commit <= c || atomic.CompareAndSwapUint64(&s.r.leadCommit, c, m.Commit) {
+			return
+		}
+	}
+}
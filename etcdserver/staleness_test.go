@@ -0,0 +1,87 @@
+// Copyright 2015 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package etcdserver
+
+import (
+	"testing"
+	"time"
+
+	"github.com/coreos/etcd/raft/raftpb"
+)
+
+func TestCheckStaleness(t *testing.T) {
+	now := time.Now()
+	tests := []struct {
+		lead        uint64
+		committed   uint64
+		applied     uint64
+		leadCommit  uint64
+		leadContact time.Time
+
+		maxEntries uint64
+		maxLag     time.Duration
+		werr       error
+	}{
+		// no leader
+		{0, 10, 10, 10, now, 5, 0, ErrTooStale},
+		// the leader is measured by its own commit index
+		{1, 10, 8, 0, time.Time{}, 5, time.Second, nil},
+		{1, 10, 4, 0, time.Time{}, 5, time.Second, ErrTooStale},
+		// the follower is measured by the commit index of the leader
+		{2, 10, 10, 12, now, 5, time.Second, nil},
+		{2, 10, 10, 16, now, 5, time.Second, ErrTooStale},
+		{2, 16, 10, 12, now, 5, time.Second, ErrTooStale},
+		{2, 10, 10, 10, now.Add(-2 * time.Second), 5, time.Second, ErrTooStale},
+		// zero bounds are not checked
+		{2, 10, 10, 100, now.Add(-2 * time.Second), 0, 0, nil},
+	}
+	for i, tt := range tests {
+		s := &EtcdServer{
+			id: 1,
+			r: raftNode{
+				lead:        tt.lead,
+				committed:   tt.committed,
+				index:       tt.applied,
+				leadCommit:  tt.leadCommit,
+				leadContact: tt.leadContact.UnixNano(),
+			},
+		}
+		if err := s.CheckStaleness(tt.maxEntries, tt.maxLag); err != tt.werr {
+			t.Errorf("#%d: err = %v, want %v", i, err, tt.werr)
+		}
+	}
+}
+
+func TestObserveLeader(t *testing.T) {
+	s := &EtcdServer{id: 1, r: raftNode{lead: 2}}
+	ms := []raftpb.Message{
+		{Type: raftpb.MsgHeartbeat, From: 2, Commit: 5},
+		// not from the leader
+		{Type: raftpb.MsgHeartbeat, From: 3, Commit: 9},
+		// not a heartbeat or an append
+		{Type: raftpb.MsgVote, From: 2, Commit: 9},
+		// the commit index never goes back
+		{Type: raftpb.MsgApp, From: 2, Commit: 3},
+	}
+	for _, m := range ms {
+		s.observeLeader(m)
+	}
+	if s.r.leadCommit != 5 {
+		t.Errorf("leadCommit = %d, want %d", s.r.leadCommit, 5)
+	}
+	if s.r.leadContact == 0 {
+		t.Errorf("leadContact is not recorded")
+	}
+}
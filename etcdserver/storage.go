@@ -15,6 +15,7 @@
 package etcdserver
 
 import (
+	"fmt"
 	"io"
 	"log"
 	"os"
@@ -75,16 +76,20 @@ func (st *storage) SaveSnap(snap raftpb.Snapshot) error {
 	return nil
 }
 
+// readWAL reads the WAL of the member from snap. If the last record is
+// torn, e.g. by a power loss, it is discarded only if cfg.RepairWAL is set,
+// and the member refuses to start otherwise.
 // 读取所有wal目录下的文件以snapshot.index开始的log数据，修复最后一个文件可能的unexpectedEOF error问题
-func readWAL(waldir string, snap walpb.Snapshot) (w *wal.WAL, id, cid types.ID, st raftpb.HardState, ents []raftpb.Entry) {
+func readWAL(cfg *ServerConfig, snap walpb.Snapshot) (w *wal.WAL, id, cid types.ID, st raftpb.HardState, ents []raftpb.Entry) {
 	var (
 		err       error
 		wmetadata []byte
 	)
 
+	waldir, p := cfg.WALDir(), cfg.Encryption
 	repaired := false
 	for {
-		if w, err = wal.Open(waldir, snap); err != nil {
+		if w, err = wal.OpenEncrypted(waldir, snap, p); err != nil {
 			log.Fatalf("etcdserver: open wal error: %v", err)
 		}
 		if wmetadata, st, ents, err = w.ReadAll(); err != nil {
@@ -93,7 +98,10 @@ func readWAL(waldir string, snap walpb.Snapshot) (w *wal.WAL, id, cid types.ID,
 			if repaired || err != io.ErrUnexpectedEOF {
 				log.Fatalf("etcdserver: read wal error (%v) and cannot be repaired", err)
 			}
-			if !wal.Repair(waldir) {
+			if !cfg.RepairWAL {
+				log.Fatalf("etcdserver: the last record of the WAL is torn (%v), restart the member with WAL repair enabled to discard it", err)
+			}
+			if !wal.RepairEncrypted(waldir, p) {
 				log.Fatalf("etcdserver: WAL error (%v) cannot be repaired", err)
 			} else {
 				log.Printf("etcdserver: repaired WAL error (%v)", err)
@@ -112,18 +120,19 @@ func readWAL(waldir string, snap walpb.Snapshot) (w *wal.WAL, id, cid types.ID,
 
 // upgradeWAL converts an older version of the etcdServer data to the newest version.
 // It must ensure that, after upgrading, the most recent version is present.
-func upgradeDataDir(baseDataDir string, name string, ver version.DataDirVersion) error {
+// The WAL is moved to the dedicated WAL dir if cfg has one.
+func upgradeDataDir(cfg *ServerConfig, ver version.DataDirVersion) error {
 	switch ver {
 	case version.DataDir0_4:
 		log.Print("etcdserver: converting v0.4 log to v2.0")
-		err := migrate.Migrate4To2(baseDataDir, name)
+		err := migrate.Migrate4To2(cfg.DataDir, cfg.Name)
 		if err != nil {
 			log.Fatalf("etcdserver: failed migrating data-dir: %v", err)
 			return err
 		}
 		fallthrough
 	case version.DataDir2_0:
-		err := makeMemberDir(baseDataDir)
+		err := makeMemberDir(cfg.DataDir, cfg.WALDir())
 		if err != nil {
 			return err
 		}
@@ -136,7 +145,9 @@ func upgradeDataDir(baseDataDir string, name string, ver version.DataDirVersion)
 	return nil
 }
 
-func makeMemberDir(dir string) error {
+// makeMemberDir moves the snap dir of the 2.0 data dir into the member
+// dir, and the wal dir to waldir.
+func makeMemberDir(dir, waldir string) error {
 	membdir := path.Join(dir, "member")
 	_, err := os.Stat(membdir)
 	switch {
@@ -148,11 +159,15 @@ func makeMemberDir(dir string) error {
 	if err := os.MkdirAll(membdir, 0700); err != nil {
 		return err
 	}
-	names := []string{"snap", "wal"}
-	for _, name := range names {
-		if err := os.Rename(path.Join(dir, name), path.Join(membdir, name)); err != nil {
-			return err
-		}
+	if err := os.Rename(path.Join(dir, "snap"), path.Join(membdir, "snap")); err != nil {
+		return err
+	}
+	if err := os.MkdirAll(path.Dir(waldir), 0700); err != nil {
+		return err
+	}
+	// the rename fails if waldir is on another file system
+	if err := os.Rename(path.Join(dir, "wal"), waldir); err != nil {
+		return fmt.Errorf("cannot move wal dir to %s: %v", waldir, err)
 	}
 	return nil
 }
@@ -0,0 +1,87 @@
+// Copyright 2015 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package etcdserver
+
+import (
+	"log"
+	"sync/atomic"
+	"time"
+
+	pb "github.com/coreos/etcd/etcdserver/etcdserverpb"
+)
+
+// DefaultSlowRequestThreshold is the time a proposed request may take
+// before it is logged as slow.
+const DefaultSlowRequestThreshold = 500 * time.Millisecond
+
+// requestTrace holds the times a proposed request goes through
+// propose→commit→apply.
+type requestTrace struct {
+	// start is when the request is received.
+	start time.Time
+	// proposed is when the proposal is handed to raft.
+	proposed time.Time
+	// committed is when the committed entry starts to be applied.
+	committed time.Time
+	// applied is when the entry has been applied.
+	applied time.Time
+}
+
+// phases returns how long the request took in each phase, given that it
+// ended at end. If the entry has not been applied, the rest of the time
+// is counted in the commit phase.
+func (t requestTrace) phases(end time.Time) (propose, commit, apply time.Duration) {
+	if t.proposed.IsZero() {
+		return end.Sub(t.start), 0, 0
+	}
+	propose = t.proposed.Sub(t.start)
+	if t.committed.IsZero() || t.applied.IsZero() {
+		return propose, end.Sub(t.proposed), 0
+	}
+	return propose, t.committed.Sub(t.proposed), t.applied.Sub(t.committed)
+}
+
+// slowestPhase returns the name of the longest of the given phases.
+func slowestPhase(propose, commit, apply time.Duration) string {
+	phase, max := "propose", propose
+	if commit > max {
+		phase, max = "commit", commit
+	}
+	if apply > max {
+		phase = "apply"
+	}
+	return phase
+}
+
+// warnIfSlow logs the request and counts it in the metrics if it took
+// longer than the slow request threshold. The slowest phase is logged
+// so that a stalled disk (propose, apply) can be told from a stalled
+// network or an unavailable quorum (commit).
+// 慢请求的日志，用来定位是磁盘还是网络导致的请求卡顿
+func (s *EtcdServer) warnIfSlow(r pb.Request, t requestTrace, end time.Time) {
+	threshold := time.Duration(atomic.LoadInt64(&s.slowThreshold))
+	if threshold <= 0 {
+		return
+	}
+	took := end.Sub(t.start)
+	if took < threshold {
+		return
+	}
+	propose, commit, apply := t.phases(end)
+	phase := slowestPhase(propose, commit, apply)
+	slowRequests.WithLabelValues(phase).Inc()
+	log.Printf("etcdserver: slow request %s %q took %v (propose %v, commit %v, apply %v), the %s phase is the slowest",
+		r.Method, r.Path, took, propose, commit, apply, phase)
+}
@@ -0,0 +1,62 @@
+// Copyright 2015 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package etcdserver
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRequestTracePhases(t *testing.T) {
+	start := time.Unix(0, 0)
+	at := func(ms int) time.Time { return start.Add(time.Duration(ms) * time.Millisecond) }
+	end := at(100)
+	tests := []struct {
+		tr requestTrace
+
+		wpropose, wcommit, wapply time.Duration
+		wphase                    string
+	}{
+		{
+			requestTrace{start: start},
+			100 * time.Millisecond, 0, 0,
+			"propose",
+		},
+		{
+			requestTrace{start: start, proposed: at(10)},
+			10 * time.Millisecond, 90 * time.Millisecond, 0,
+			"commit",
+		},
+		{
+			requestTrace{start: start, proposed: at(10), committed: at(20), applied: at(90)},
+			10 * time.Millisecond, 10 * time.Millisecond, 70 * time.Millisecond,
+			"apply",
+		},
+		{
+			requestTrace{start: start, proposed: at(60), committed: at(70), applied: at(80)},
+			60 * time.Millisecond, 10 * time.Millisecond, 10 * time.Millisecond,
+			"propose",
+		},
+	}
+	for i, tt := range tests {
+		propose, commit, apply := tt.tr.phases(end)
+		if propose != tt.wpropose || commit != tt.wcommit || apply != tt.wapply {
+			t.Errorf("#%d: phases = %v %v %v, want %v %v %v", i, propose, commit, apply, tt.wpropose, tt.wcommit, tt.wapply)
+		}
+		if g := slowestPhase(propose, commit, apply); g != tt.wphase {
+			t.Errorf("#%d: slowest phase = %s, want %s", i, g, tt.wphase)
+		}
+	}
+}
@@ -32,13 +32,16 @@ import (
 
 	"github.com/coreos/etcd/client"
 	"github.com/coreos/etcd/etcdserver"
+	"github.com/coreos/etcd/etcdserver/api"
 	"github.com/coreos/etcd/etcdserver/etcdhttp"
 	"github.com/coreos/etcd/pkg/testutil"
 	"github.com/coreos/etcd/pkg/transport"
 	"github.com/coreos/etcd/pkg/types"
 	"github.com/coreos/etcd/rafthttp"
+	"github.com/coreos/etcd/version"
 
 	"github.com/coreos/etcd/Godeps/_workspace/src/golang.org/x/net/context"
+	"github.com/coreos/etcd/Godeps/_workspace/src/google.golang.org/grpc"
 )
 
 const (
@@ -72,6 +75,213 @@ func testCluster(t *testing.T, size int) {
 	clusterMustProgress(t, c.Members)
 }
 
+// TestClusterOf3Compressed tests that a cluster works with the raft
+// streams between the members compressed.
+func TestClusterOf3Compressed(t *testing.T) {
+	defer afterTest(t)
+	c := NewCluster(t, 3)
+	for _, m := range c.Members {
+		m.PeerCompression = rafthttp.CompressionGzip
+	}
+	c.Launch(t)
+	defer c.Terminate(t)
+	clusterMustProgress(t, c.Members)
+}
+
+// TestClusterVersion tests that the leader sets the cluster version once
+// the members publish their versions, and that it is replicated to all
+// the members.
+func TestClusterVersion(t *testing.T) {
+	defer afterTest(t)
+	c := NewCluster(t, 3)
+	c.Launch(t)
+	defer c.Terminate(t)
+	clusterMustProgress(t, c.Members)
+
+	major, minor, err := version.MajorMinor(version.Version)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w := fmt.Sprintf("%d.%d.0", major, minor)
+	for i, m := range c.Members {
+		var g string
+		// the leader checks the versions every second
+		for j := 0; j < 250; j++ {
+			if g = m.s.Cluster.Version(); g == w {
+				break
+			}
+			time.Sleep(20 * time.Millisecond)
+		}
+		if g != w {
+			t.Errorf("#%d: cluster version = %q, want %q", i, g, w)
+		}
+	}
+}
+
+// TestClusterHealth tests that every member of a working cluster reports
+// itself healthy, with the same leader, and live and ready.
+func TestClusterHealth(t *testing.T) {
+	defer afterTest(t)
+	c := NewCluster(t, 3)
+	c.Launch(t)
+	defer c.Terminate(t)
+	clusterMustProgress(t, c.Members)
+
+	wlead := c.Members[0].s.Leader().String()
+	for i := range c.Members {
+		resp, err := http.Get(c.URL(i) + "/health")
+		if err != nil {
+			t.Fatalf("#%d: %v", i, err)
+		}
+		b, err := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err != nil {
+			t.Fatalf("#%d: %v", i, err)
+		}
+		if resp.StatusCode != http.StatusOK {
+			t.Errorf("#%d: code = %d, want %d: %s", i, resp.StatusCode, http.StatusOK, b)
+		}
+		if w := fmt.Sprintf(`"leader":"%s"`, wlead); !strings.Contains(string(b), w) {
+			t.Errorf("#%d: body = %s, want %s", i, b, w)
+		}
+		for _, p := range []string{"/health/live", "/health/ready"} {
+			resp, err := http.Get(c.URL(i) + p)
+			if err != nil {
+				t.Fatalf("#%d: %v", i, err)
+			}
+			resp.Body.Close()
+			if resp.StatusCode != http.StatusOK {
+				t.Errorf("#%d: %s code = %d, want %d", i, p, resp.StatusCode, http.StatusOK)
+			}
+		}
+	}
+}
+
+// TestMembersStatus tests that the leader lists every member reachable,
+// with a known lag, and itself as the leader.
+func TestMembersStatus(t *testing.T) {
+	defer afterTest(t)
+	c := NewCluster(t, 3)
+	c.Launch(t)
+	defer c.Terminate(t)
+	clusterMustProgress(t, c.Members)
+
+	lead := c.Members[0].s.Leader()
+	for i, m := range c.Members {
+		if m.s.ID() != lead {
+			continue
+		}
+		mapi := client.NewMembersAPI(mustNewHTTPClient(t, []string{c.URL(i)}))
+		ctx, cancel := context.WithTimeout(context.Background(), requestTimeout)
+		membs, err := mapi.List(ctx)
+		cancel()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(membs) != len(c.Members) {
+			t.Fatalf("len(members) = %d, want %d", len(membs), len(c.Members))
+		}
+		for _, mb := range membs {
+			st := mb.Status
+			if st == nil {
+				t.Fatalf("member %s: no status", mb.ID)
+			}
+			if st.IsLeader != (mb.ID == lead.String()) || !st.Reachable || st.Lag == nil {
+				t.Errorf("member %s: status = %+v", mb.ID, *st)
+			}
+		}
+	}
+}
+
+// TestWriteOnFollower tests that a plain client can write through a
+// follower: the follower forwards the write to the leader, so the client
+// does not need to find the leader itself.
+func TestWriteOnFollower(t *testing.T) {
+	defer afterTest(t)
+	c := NewCluster(t, 3)
+	c.Launch(t)
+	defer c.Terminate(t)
+	clusterMustProgress(t, c.Members)
+
+	lead := c.Members[0].s.Leader()
+	var lurl string
+	for i, m := range c.Members {
+		if m.s.ID() == lead {
+			lurl = c.URL(i)
+		}
+	}
+	for i, m := range c.Members {
+		if m.s.ID() == lead {
+			continue
+		}
+		v := fmt.Sprint(i)
+		req, err := http.NewRequest("PUT", c.URL(i)+"/v2/keys/follower", strings.NewReader("value="+v))
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("#%d: %v", i, err)
+		}
+		b, err := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err != nil {
+			t.Fatalf("#%d: %v", i, err)
+		}
+		if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusCreated {
+			t.Fatalf("#%d: code = %d, want 200 or 201: %s", i, resp.StatusCode, b)
+		}
+		if g := resp.Header.Get("X-Etcd-Forwarded-To"); g != lead.String() {
+			t.Errorf("#%d: X-Etcd-Forwarded-To = %q, want %q", i, g, lead.String())
+		}
+
+		// the write is committed by the leader as any other
+		resp, err = http.Get(lurl + "/v2/keys/follower?quorum=true")
+		if err != nil {
+			t.Fatalf("#%d: %v", i, err)
+		}
+		b, err = ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err != nil {
+			t.Fatalf("#%d: %v", i, err)
+		}
+		if w := fmt.Sprintf(`"value":"%s"`, v); !strings.Contains(string(b), w) {
+			t.Errorf("#%d: body = %s, want %s", i, b, w)
+		}
+
+		// a write forwarded by another member, which runs on the same
+		// host, is not forwarded again, but proposed over raft; the
+		// header naming no member is set by a client, and ignored
+		fwds := []struct {
+			by  string
+			wto string
+		}{
+			{lead.String(), ""},
+			{"1", lead.String()},
+		}
+		for j, fwd := range fwds {
+			req, err = http.NewRequest("PUT", c.URL(i)+"/v2/keys/forwarded", strings.NewReader("value="+v))
+			if err != nil {
+				t.Fatal(err)
+			}
+			req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+			req.Header.Set("X-Etcd-Forwarded-By", fwd.by)
+			resp, err = http.DefaultClient.Do(req)
+			if err != nil {
+				t.Fatalf("#%d.%d: %v", i, j, err)
+			}
+			resp.Body.Close()
+			if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusCreated {
+				t.Fatalf("#%d.%d: code = %d, want 200 or 201", i, j, resp.StatusCode)
+			}
+			if g := resp.Header.Get("X-Etcd-Forwarded-To"); g != fwd.wto {
+				t.Errorf("#%d.%d: X-Etcd-Forwarded-To = %q, want %q", i, j, g, fwd.wto)
+			}
+		}
+	}
+}
+
 func TestTLSClusterOf3(t *testing.T) {
 	defer afterTest(t)
 	c := NewTLSCluster(t, 3)
@@ -457,6 +667,16 @@ func (c *cluster) waitMembersMatch(t *testing.T, membs []client.Member) {
 	return
 }
 
+// waitVersion waits until the leader has decided the cluster version,
+// which enables the requests new in this version, e.g. the v3 KV requests.
+func (c *cluster) waitVersion() {
+	for _, m := range c.Members {
+		for m.s.Cluster.Version() == "" {
+			time.Sleep(tickDuration)
+		}
+	}
+}
+
 func (c *cluster) waitLeader(t *testing.T, membs []*member) {
 	possibleLead := make(map[uint64]bool)
 	var lead uint64
@@ -481,13 +701,15 @@ func (c *cluster) name(i int) string {
 	return fmt.Sprint("node", i)
 }
 
-// isMembersEqual checks whether two members equal except ID field.
-// The given wmembs should always set ID field to empty string.
+// isMembersEqual checks whether two members equal except ID and Status
+// fields. The given wmembs should always set ID field to empty string.
 func isMembersEqual(membs []client.Member, wmembs []client.Member) bool {
 	sort.Sort(SortableMemberSliceByPeerURLs(membs))
 	sort.Sort(SortableMemberSliceByPeerURLs(wmembs))
 	for i := range membs {
 		membs[i].ID = ""
+		membs[i].Version = ""
+		membs[i].Status = nil
 	}
 	return reflect.DeepEqual(membs, wmembs)
 }
@@ -527,6 +749,7 @@ type member struct {
 	raftHandler *testutil.PauseableHandler
 	s           *etcdserver.EtcdServer
 	hss         []*httptest.Server
+	grpcServer  *grpc.Server
 }
 
 // mustNewMember return an inited member with the given name. If usePeerTLS is
@@ -625,7 +848,7 @@ func (m *member) Launch() error {
 	m.s.SyncTicker = time.Tick(500 * time.Millisecond)
 	m.s.Start()
 
-	m.raftHandler = &testutil.PauseableHandler{Next: etcdhttp.NewPeerHandler(m.s.Cluster, m.s, m.s.RaftHandler())}
+	m.raftHandler = &testutil.PauseableHandler{Next: etcdhttp.NewPeerHandler(m.s.Cluster, m.s, m.s, m.s.RaftHandler())}
 
 	for _, ln := range m.PeerListeners {
 		hs := &httptest.Server{
@@ -643,10 +866,13 @@ func (m *member) Launch() error {
 		}
 		m.hss = append(m.hss, hs)
 	}
+	m.grpcServer = api.NewServer(m.s)
 	for _, ln := range m.ClientListeners {
+		grpcl, httpl := api.NewSplitListener(ln)
+		go m.grpcServer.Serve(grpcl)
 		hs := &httptest.Server{
-			Listener: ln,
-			Config:   &http.Server{Handler: etcdhttp.NewClientHandler(m.s)},
+			Listener: httpl,
+			Config:   &http.Server{Handler: etcdhttp.NewForwardHandler(etcdhttp.NewClientHandler(m.s), m.s, &http.Transport{})},
 		}
 		hs.Start()
 		m.hss = append(m.hss, hs)
@@ -692,6 +918,10 @@ func (m *member) Stop(t *testing.T) {
 		hs.Close()
 	}
 	m.hss = nil
+	if m.grpcServer != nil {
+		m.grpcServer.Stop()
+		m.grpcServer = nil
+	}
 }
 
 // Start starts the member using the preserved data dir.
@@ -716,6 +946,9 @@ func (m *member) Terminate(t *testing.T) {
 		hs.CloseClientConnections()
 		hs.Close()
 	}
+	if m.grpcServer != nil {
+		m.grpcServer.Stop()
+	}
 	if err := os.RemoveAll(m.ServerConfig.DataDir); err != nil {
 		t.Fatal(err)
 	}
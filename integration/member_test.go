@@ -63,6 +63,67 @@ func TestRestartMember(t *testing.T) {
 	clusterMustProgress(t, c.Members)
 }
 
+// TestRestartMemberWALStorage tests that the members reading the raft log
+// back from the WAL restart and catch up with the cluster.
+func TestRestartMemberWALStorage(t *testing.T) {
+	defer afterTest(t)
+	c := NewCluster(t, 3)
+	for _, m := range c.Members {
+		m.WALStorage = true
+	}
+	c.Launch(t)
+	defer c.Terminate(t)
+
+	for i := 0; i < 3; i++ {
+		c.Members[i].Stop(t)
+		membs := append([]*member{}, c.Members[:i]...)
+		membs = append(membs, c.Members[i+1:]...)
+		c.waitLeader(t, membs)
+		clusterMustProgress(t, membs)
+		err := c.Members[i].Restart(t)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	clusterMustProgress(t, c.Members)
+}
+
+// TestStopLeader tests that the leader hands the leadership over to a peer
+// when it is stopped, so that the cluster does not wait for an election.
+func TestStopLeader(t *testing.T) {
+	defer afterTest(t)
+	c := NewCluster(t, 3)
+	c.Launch(t)
+	defer c.Terminate(t)
+	c.waitLeader(t, c.Members)
+	clusterMustProgress(t, c.Members)
+
+	var lead *member
+	var others []*member
+	for _, m := range c.Members {
+		if m.s.Lead() == uint64(m.s.ID()) {
+			lead = m
+		} else {
+			others = append(others, m)
+		}
+	}
+	if lead == nil {
+		t.Fatalf("no leader found")
+	}
+	lead.Stop(t)
+
+	transferred := false
+	for _, m := range others {
+		if m.s.Lead() == uint64(m.s.ID()) {
+			transferred = true
+		}
+	}
+	if !transferred {
+		t.Errorf("the leadership is not transferred when the leader stops")
+	}
+	clusterMustProgress(t, others)
+}
+
 func TestLaunchDuplicateMemberShouldFail(t *testing.T) {
 	size := 3
 	c := NewCluster(t, size)
@@ -899,6 +899,52 @@ func TestV2WatchKeyInDir(t *testing.T) {
 	}
 }
 
+// Ensure that the events of the watches of a watch stream are received
+// in order on one connection, including the ones found in the history.
+func TestV2MultiWatch(t *testing.T) {
+	cl := NewCluster(t, 1)
+	cl.Launch(t)
+	defer cl.Terminate(t)
+
+	u := cl.URL(0)
+	tc := NewTestClient()
+
+	v := url.Values{}
+	v.Set("value", "XXX")
+	resp, _ := tc.PutForm(fmt.Sprintf("%s%s", u, "/v2/keys/foo"), v)
+	body := tc.ReadBodyJSON(resp)
+	index := body["node"].(map[string]interface{})["modifiedIndex"].(float64)
+
+	watches := fmt.Sprintf(`{"watches":[{"id":"a","key":"/foo","waitIndex":%d},{"id":"b","key":"/dir","recursive":true}]}`, uint64(index))
+	watchResp, err := tc.Post(fmt.Sprintf("%s%s", u, "/v2/watch"), "application/json", strings.NewReader(watches))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer watchResp.Body.Close()
+
+	for _, key := range []string{"/dir/x", "/foo", "/dir/y"} {
+		resp, _ := tc.PutForm(fmt.Sprintf("%s/v2/keys%s", u, key), v)
+		resp.Body.Close()
+	}
+
+	wevs := []map[string]interface{}{
+		{"watchId": "a", "node": map[string]interface{}{"key": "/foo", "modifiedIndex": index}},
+		{"watchId": "b", "node": map[string]interface{}{"key": "/dir/x"}},
+		{"watchId": "a", "node": map[string]interface{}{"key": "/foo"}},
+		{"watchId": "b", "node": map[string]interface{}{"key": "/dir/y"}},
+	}
+	dec := json.NewDecoder(watchResp.Body)
+	for i, w := range wevs {
+		var ev map[string]interface{}
+		if err := dec.Decode(&ev); err != nil {
+			t.Fatalf("#%d: %v", i, err)
+		}
+		if err := checkBody(ev, w); err != nil {
+			t.Errorf("#%d: %v", i, err)
+		}
+	}
+}
+
 func TestV2Head(t *testing.T) {
 	cl := NewCluster(t, 1)
 	cl.Launch(t)
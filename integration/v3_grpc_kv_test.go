@@ -0,0 +1,153 @@
+// Copyright 2015 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package integration
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/coreos/etcd/Godeps/_workspace/src/golang.org/x/net/context"
+	"github.com/coreos/etcd/Godeps/_workspace/src/google.golang.org/grpc"
+	"github.com/coreos/etcd/Godeps/_workspace/src/google.golang.org/grpc/codes"
+	"github.com/coreos/etcd/etcdserver/api/kvpb"
+)
+
+// TestV3PutRange tests that a key put through the gRPC API of one member
+// can be read from the gRPC API of every member.
+func TestV3PutRange(t *testing.T) {
+	defer afterTest(t)
+	c := NewCluster(t, 3)
+	c.Launch(t)
+	defer c.Terminate(t)
+	c.waitVersion()
+
+	kvc, conn := mustNewKVClient(t, c.Members[0])
+	defer conn.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), requestTimeout)
+	defer cancel()
+	presp, err := kvc.Put(ctx, &kvpb.PutRequest{Key: []byte("foo"), Value: []byte("bar")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if presp.Header == nil || presp.Header.MemberId != uint64(c.Members[0].s.ID()) {
+		t.Fatalf("header = %+v, want the member ID %s", presp.Header, c.Members[0].s.ID())
+	}
+
+	for i, m := range c.Members {
+		kvc, conn := mustNewKVClient(t, m)
+		resp, err := kvc.Range(ctx, &kvpb.RangeRequest{Key: []byte("foo"), Linearizable: true})
+		conn.Close()
+		if err != nil {
+			t.Fatalf("#%d: %v", i, err)
+		}
+		if len(resp.Kvs) != 1 || string(resp.Kvs[0].Value) != "bar" {
+			t.Errorf("#%d: kvs = %v, want foo=bar", i, resp.Kvs)
+		}
+		if resp.Header.Index < presp.Header.Index {
+			t.Errorf("#%d: index = %d, want >= %d", i, resp.Header.Index, presp.Header.Index)
+		}
+	}
+}
+
+// TestV3DeleteRange tests that a delete range deletes only the keys in
+// the range.
+func TestV3DeleteRange(t *testing.T) {
+	defer afterTest(t)
+	c := NewCluster(t, 1)
+	c.Launch(t)
+	defer c.Terminate(t)
+	c.waitVersion()
+
+	kvc, conn := mustNewKVClient(t, c.Members[0])
+	defer conn.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), requestTimeout)
+	defer cancel()
+	for _, k := range []string{"a", "b", "c", "d"} {
+		if _, err := kvc.Put(ctx, &kvpb.PutRequest{Key: []byte(k), Value: []byte(k)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	dresp, err := kvc.DeleteRange(ctx, &kvpb.DeleteRangeRequest{Key: []byte("b"), RangeEnd: []byte("d")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if dresp.Deleted != 2 {
+		t.Errorf("deleted = %d, want 2", dresp.Deleted)
+	}
+	resp, err := kvc.Range(ctx, &kvpb.RangeRequest{Key: []byte("a"), RangeEnd: []byte("z")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var keys []string
+	for _, kv := range resp.Kvs {
+		keys = append(keys, string(kv.Key))
+	}
+	if w := []string{"a", "d"}; !reflect.DeepEqual(keys, w) {
+		t.Errorf("keys = %v, want %v", keys, w)
+	}
+}
+
+// TestV3Txn tests that a transaction applies the branch chosen by its
+// compares, and that an invalid request is rejected with InvalidArgument.
+func TestV3Txn(t *testing.T) {
+	defer afterTest(t)
+	c := NewCluster(t, 1)
+	c.Launch(t)
+	defer c.Terminate(t)
+	c.waitVersion()
+
+	kvc, conn := mustNewKVClient(t, c.Members[0])
+	defer conn.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), requestTimeout)
+	defer cancel()
+
+	// create foo only if it does not exist
+	txn := &kvpb.TxnRequest{
+		Compare: []*kvpb.Compare{{Target: kvpb.Compare_CREATE_INDEX, Key: []byte("foo")}},
+		Success: []*kvpb.RequestUnion{{RequestPut: &kvpb.PutRequest{Key: []byte("foo"), Value: []byte("1")}}},
+		Failure: []*kvpb.RequestUnion{{RequestRange: &kvpb.RangeRequest{Key: []byte("foo")}}},
+	}
+	for i, wsucceeded := range []bool{true, false} {
+		resp, err := kvc.Txn(ctx, txn)
+		if err != nil {
+			t.Fatalf("#%d: %v", i, err)
+		}
+		if resp.Succeeded != wsucceeded {
+			t.Errorf("#%d: succeeded = %v, want %v", i, resp.Succeeded, wsucceeded)
+		}
+		if len(resp.Responses) != 1 {
+			t.Fatalf("#%d: len(responses) = %d, want 1", i, len(resp.Responses))
+		}
+		if !wsucceeded {
+			rr := resp.Responses[0].ResponseRange
+			if rr == nil || len(rr.Kvs) != 1 || string(rr.Kvs[0].Value) != "1" {
+				t.Errorf("#%d: range response = %v, want foo=1", i, rr)
+			}
+		}
+	}
+
+	_, err := kvc.Txn(ctx, &kvpb.TxnRequest{Success: []*kvpb.RequestUnion{{}}})
+	if grpc.Code(err) != codes.InvalidArgument {
+		t.Errorf("err = %v, want code %v", err, codes.InvalidArgument)
+	}
+}
+
+func mustNewKVClient(t *testing.T, m *member) (kvpb.KVClient, *grpc.ClientConn) {
+	conn, err := grpc.Dial(m.ClientURLs[0].Host, grpc.WithTimeout(requestTimeout))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return kvpb.NewKVClient(conn), conn
+}
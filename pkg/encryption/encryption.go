@@ -0,0 +1,104 @@
+// Copyright 2015 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package encryption provides the encryption of the data written to the
+// disk by wal and snap.
+package encryption
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"strings"
+)
+
+var (
+	ErrShortCiphertext = errors.New("encryption: ciphertext too short")
+)
+
+// Provider seals the data before it is written to the disk, and opens the
+// sealed data read from the disk.
+type Provider interface {
+	// Seal encrypts and authenticates plaintext.
+	Seal(plaintext []byte) ([]byte, error)
+	// Open authenticates and decrypts the output of Seal. It returns an
+	// error if ciphertext was modified or sealed with another key.
+	Open(ciphertext []byte) ([]byte, error)
+}
+
+// KeyFunc returns the key of the encryption, e.g. by unwrapping a data key
+// with a key management service.
+type KeyFunc func() ([]byte, error)
+
+type aesGCM struct {
+	aead cipher.AEAD
+}
+
+// NewAESGCM returns a Provider that seals the data with AES-GCM under
+// key, which must be 16, 24 or 32 bytes long. The sealed data is prefixed
+// with a random nonce.
+func NewAESGCM(key []byte) (Provider, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	return &aesGCM{aead: aead}, nil
+}
+
+// NewAESGCMFromKeyFunc returns an AES-GCM Provider with the key returned
+// by f.
+func NewAESGCMFromKeyFunc(f KeyFunc) (Provider, error) {
+	key, err := f()
+	if err != nil {
+		return nil, fmt.Errorf("encryption: cannot get key: %v", err)
+	}
+	return NewAESGCM(key)
+}
+
+// NewAESGCMFromFile returns an AES-GCM Provider with the key read from
+// the file at path. The file holds the key encoded in hex.
+func NewAESGCMFromFile(path string) (Provider, error) {
+	return NewAESGCMFromKeyFunc(func() ([]byte, error) {
+		b, err := ioutil.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		return hex.DecodeString(strings.TrimSpace(string(b)))
+	})
+}
+
+func (p *aesGCM) Seal(plaintext []byte) ([]byte, error) {
+	nonce := make([]byte, p.aead.NonceSize(), p.aead.NonceSize()+len(plaintext)+p.aead.Overhead())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, err
+	}
+	return p.aead.Seal(nonce, nonce, plaintext, nil), nil
+}
+
+func (p *aesGCM) Open(ciphertext []byte) ([]byte, error) {
+	n := p.aead.NonceSize()
+	if len(ciphertext) < n {
+		return nil, ErrShortCiphertext
+	}
+	return p.aead.Open(nil, ciphertext[:n], ciphertext[n:], nil)
+}
@@ -0,0 +1,106 @@
+// Copyright 2015 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package encryption
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+func TestAESGCM(t *testing.T) {
+	key := bytes.Repeat([]byte{1}, 32)
+	p, err := NewAESGCM(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data := []byte("some data")
+	c, err := p.Seal(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Contains(c, data) {
+		t.Errorf("ciphertext %x contains the plaintext", c)
+	}
+	g, err := p.Open(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(g, data) {
+		t.Errorf("data = %q, want %q", g, data)
+	}
+
+	// modified ciphertext
+	c[len(c)-1] ^= 1
+	if _, err = p.Open(c); err == nil {
+		t.Errorf("err = nil, want an authentication error")
+	}
+	if _, err = p.Open(c[:4]); err != ErrShortCiphertext {
+		t.Errorf("err = %v, want %v", err, ErrShortCiphertext)
+	}
+	// another key
+	c[len(c)-1] ^= 1
+	other, err := NewAESGCM(bytes.Repeat([]byte{2}, 32))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = other.Open(c); err == nil {
+		t.Errorf("err = nil, want an authentication error")
+	}
+}
+
+func TestNewAESGCMBadKey(t *testing.T) {
+	tests := []KeyFunc{
+		func() ([]byte, error) { return make([]byte, 10), nil },
+		func() ([]byte, error) { return nil, errors.New("kms unavailable") },
+	}
+	for i, tt := range tests {
+		if _, err := NewAESGCMFromKeyFunc(tt); err == nil {
+			t.Errorf("#%d: err = nil, want not nil", i)
+		}
+	}
+}
+
+func TestNewAESGCMFromFile(t *testing.T) {
+	f, err := ioutil.TempFile("", "encryptionkey")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(f.Name())
+	key := bytes.Repeat([]byte{3}, 16)
+	if _, err = f.WriteString(hex.EncodeToString(key) + "\n"); err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+
+	p, err := NewAESGCMFromFile(f.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	want, err := NewAESGCM(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c, err := p.Seal([]byte("data"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if g, err := want.Open(c); err != nil || string(g) != "data" {
+		t.Errorf("data = %q, err = %v, want %q and nil", g, err, "data")
+	}
+}
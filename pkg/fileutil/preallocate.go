@@ -0,0 +1,49 @@
+// Copyright 2015 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package fileutil
+
+import "os"
+
+// zeroFillChunk is the size of the zeros written at once by zeroFill.
+const zeroFillChunk = 1024 * 1024
+
+// Preallocate allocates the disk space of sizeInBytes bytes for f and
+// extends f to the size, so that the later writes within the size do not
+// update the file metadata, and a full disk is reported here rather than
+// by the later writes. The allocated bytes read as zeros. It uses
+// fallocate where it is available, and zero-fills f otherwise.
+// 预分配文件空间，优先使用fallocate，不支持时写0填充
+func Preallocate(f *os.File, sizeInBytes int64) error {
+	return preallocate(f, sizeInBytes)
+}
+
+// zeroFill extends f to sizeInBytes bytes by writing zeros after its end.
+// It does not change the offset of f.
+func zeroFill(f *os.File, sizeInBytes int64) error {
+	fi, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	zeros := make([]byte, zeroFillChunk)
+	for off := fi.Size(); off < sizeInBytes; off += int64(len(zeros)) {
+		if n := sizeInBytes - off; n < int64(len(zeros)) {
+			zeros = zeros[:n]
+		}
+		if _, err := f.WriteAt(zeros, off); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,32 @@
+// Copyright 2015 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// +build linux
+
+package fileutil
+
+import (
+	"os"
+	"syscall"
+)
+
+func preallocate(f *os.File, sizeInBytes int64) error {
+	// mode 0 extends the size of the file
+	err := syscall.Fallocate(int(f.Fd()), 0, 0, sizeInBytes)
+	if errno, ok := err.(syscall.Errno); ok && errno == syscall.ENOTSUP {
+		// the file system does not support fallocate
+		return zeroFill(f, sizeInBytes)
+	}
+	return err
+}
@@ -0,0 +1,63 @@
+// Copyright 2015 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package fileutil
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+func TestPreallocate(t *testing.T) {
+	for i, f := range []func(*os.File, int64) error{Preallocate, zeroFill} {
+		p, err := ioutil.TempFile("", "preallocateTest")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer os.Remove(p.Name())
+
+		if _, err = p.Write([]byte("data")); err != nil {
+			t.Fatal(err)
+		}
+		size := int64(zeroFillChunk + 64)
+		if err = f(p, size); err != nil {
+			t.Fatalf("#%d: err = %v, want nil", i, err)
+		}
+		fi, err := p.Stat()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if fi.Size() != size {
+			t.Errorf("#%d: size = %d, want %d", i, fi.Size(), size)
+		}
+		// the offset of the file is kept
+		if off, _ := p.Seek(0, os.SEEK_CUR); off != 4 {
+			t.Errorf("#%d: offset = %d, want 4", i, off)
+		}
+		b, err := ioutil.ReadFile(p.Name())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(b[:4]) != "data" {
+			t.Errorf("#%d: head = %q, want %q", i, b[:4], "data")
+		}
+		for j := 4; j < len(b); j++ {
+			if b[j] != 0 {
+				t.Fatalf("#%d: byte %d = %d, want 0", i, j, b[j])
+			}
+		}
+		p.Close()
+	}
+}
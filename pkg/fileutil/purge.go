@@ -24,48 +24,25 @@ import (
 )
 
 //定时清理文件
+// PurgeFile removes the oldest files with the suffix in the directory every
+// interval until stop is closed, keeping the latest max ones. A locked
+// file, e.g. a WAL file in use, and the files after it are kept. The error
+// of a failed purge is sent on the returned channel and the purge is
+// retried at the next interval; a nil error is sent once a purge succeeds
+// again.
 func PurgeFile(dirname string, suffix string, max uint, interval time.Duration, stop <-chan struct{}) <-chan error {
 	errC := make(chan error, 1)
 	go func() {
+		failed := false
 		for {
-			fnames, err := ReadDir(dirname)
-			if err != nil {
-				errC <- err
-				return
-			}
-			newfnames := make([]string, 0)
-			for _, fname := range fnames {
-				if strings.HasSuffix(fname, suffix) {
-					newfnames = append(newfnames, fname)
-				}
-			}
-			sort.Strings(newfnames)
-			for len(newfnames) > int(max) {
-				f := path.Join(dirname, newfnames[0])
-				l, err := NewLock(f)
-				if err != nil {
-					errC <- err
+			err := purgeFile(dirname, suffix, max)
+			if err != nil || failed {
+				select {
+				case errC <- err:
+				case <-stop:
 					return
 				}
-				err = l.TryLock()
-				if err != nil {
-					break
-				}
-				err = os.Remove(f)
-				if err != nil {
-					errC <- err
-					return
-				}
-				err = l.Unlock()
-				if err != nil {
-					log.Printf("filePurge: unlock %s error %v", l.Name(), err)
-				}
-				err = l.Destroy()
-				if err != nil {
-					log.Printf("filePurge: destroy lock %s error %v", l.Name(), err)
-				}
-				log.Printf("filePurge: successfully removed file %s", f)
-				newfnames = newfnames[1:]
+				failed = err != nil
 			}
 			select {
 			case <-time.After(interval):
@@ -76,3 +53,49 @@ func PurgeFile(dirname string, suffix string, max uint, interval time.Duration,
 	}()
 	return errC
 }
+
+// purgeFile removes the oldest files with the suffix in the directory,
+// keeping the latest max ones, up to the first locked one.
+func purgeFile(dirname string, suffix string, max uint) error {
+	fnames, err := ReadDir(dirname)
+	if err != nil {
+		return err
+	}
+	newfnames := make([]string, 0)
+	for _, fname := range fnames {
+		if strings.HasSuffix(fname, suffix) {
+			newfnames = append(newfnames, fname)
+		}
+	}
+	sort.Strings(newfnames)
+	for len(newfnames) > int(max) {
+		f := path.Join(dirname, newfnames[0])
+		l, err := NewLock(f)
+		if err != nil {
+			return err
+		}
+		err = l.TryLock()
+		if err != nil {
+			l.Destroy()
+			break
+		}
+		err = os.Remove(f)
+		if err != nil {
+			// release the file, which is retried at the next purge
+			l.Unlock()
+			l.Destroy()
+			return err
+		}
+		err = l.Unlock()
+		if err != nil {
+			log.Printf("filePurge: unlock %s error %v", l.Name(), err)
+		}
+		err = l.Destroy()
+		if err != nil {
+			log.Printf("filePurge: destroy lock %s error %v", l.Name(), err)
+		}
+		log.Printf("filePurge: successfully removed file %s", f)
+		newfnames = newfnames[1:]
+	}
+	return nil
+}
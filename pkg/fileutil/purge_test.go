@@ -130,3 +130,61 @@ func TestPurgeFileHoldingLock(t *testing.T) {
 
 	close(stop)
 }
+
+// TestPurgeFileRetry tests that a failed purge is reported and retried,
+// and that its recovery is reported.
+func TestPurgeFileRetry(t *testing.T) {
+	dir, err := ioutil.TempDir("", "purgefile")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	// reading the missing dir fails until it is created
+	pdir := path.Join(dir, "missing")
+
+	stop := make(chan struct{})
+	defer close(stop)
+	errch := PurgeFile(pdir, "test", 3, time.Millisecond, stop)
+	select {
+	case err := <-errch:
+		if err == nil {
+			t.Fatalf("purge error = nil, want error")
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("no purge error")
+	}
+
+	// the dir appears with its files at once
+	tmpdir := path.Join(dir, "tmp")
+	if err := os.Mkdir(tmpdir, 0700); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 5; i++ {
+		if _, err := os.Create(path.Join(tmpdir, fmt.Sprintf("%d.test", i))); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.Rename(tmpdir, pdir); err != nil {
+		t.Fatal(err)
+	}
+	// the errors of the retries may be sent before the recovery
+	for {
+		select {
+		case err := <-errch:
+			if err != nil {
+				continue
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("no purge recovery")
+		}
+		break
+	}
+	fnames, err := ReadDir(pdir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	wnames := []string{"2.test", "3.test", "4.test"}
+	if !reflect.DeepEqual(fnames, wnames) {
+		t.Errorf("filenames = %v, want %v", fnames, wnames)
+	}
+}
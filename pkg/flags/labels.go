@@ -0,0 +1,55 @@
+// Copyright 2015 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package flags
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// LabelsValue is a set of labels formatted like:
+// zone=us-east-1a,rack=r1,role=voter
+type LabelsValue map[string]string
+
+// Set parses the comma-separated key=value labels. The keys must not be
+// empty nor repeated; the values may be empty.
+func (lv *LabelsValue) Set(s string) error {
+	labels := make(map[string]string)
+	if s != "" {
+		for _, l := range strings.Split(s, ",") {
+			kv := strings.SplitN(l, "=", 2)
+			if len(kv) != 2 || kv[0] == "" {
+				return fmt.Errorf("invalid label %q, want key=value", l)
+			}
+			if _, ok := labels[kv[0]]; ok {
+				return fmt.Errorf("label %q given twice", kv[0])
+			}
+			labels[kv[0]] = kv[1]
+		}
+	}
+	*lv = LabelsValue(labels)
+	return nil
+}
+
+// String returns the labels sorted by key.
+func (lv *LabelsValue) String() string {
+	var all []string
+	for k, v := range *lv {
+		all = append(all, k+"="+v)
+	}
+	sort.Strings(all)
+	return strings.Join(all, ",")
+}
@@ -0,0 +1,54 @@
+// Copyright 2015 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package flags
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestLabelsSet(t *testing.T) {
+	tests := []struct {
+		in string
+
+		w    LabelsValue
+		wstr string
+		pass bool
+	}{
+		{"", LabelsValue{}, "", true},
+		{"zone=us-east-1a", LabelsValue{"zone": "us-east-1a"}, "zone=us-east-1a", true},
+		{"zone=a,rack=r1,role=", LabelsValue{"zone": "a", "rack": "r1", "role": ""}, "rack=r1,role=,zone=a", true},
+		{"url=http://a?b=c", LabelsValue{"url": "http://a?b=c"}, "url=http://a?b=c", true},
+
+		{"zone", nil, "", false},
+		{"=a", nil, "", false},
+		{"zone=a,", nil, "", false},
+		{"zone=a,zone=b", nil, "", false},
+	}
+	for i, tt := range tests {
+		var lv LabelsValue
+		err := lv.Set(tt.in)
+		if tt.pass != (err == nil) {
+			t.Errorf("#%d: want pass=%t, but got err=%v", i, tt.pass, err)
+			continue
+		}
+		if !reflect.DeepEqual(lv, tt.w) {
+			t.Errorf("#%d: labels = %v, want %v", i, lv, tt.w)
+		}
+		if g := lv.String(); g != tt.wstr {
+			t.Errorf("#%d: string = %q, want %q", i, g, tt.wstr)
+		}
+	}
+}
@@ -0,0 +1,100 @@
+// Copyright 2015 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ioutil
+
+import (
+	"fmt"
+	"os"
+)
+
+// RotatingFile is an append-only file that is rotated once it would grow
+// beyond a max size: the file is renamed to path.1, the older rotated
+// files are shifted to path.2 and up, and the files beyond the kept
+// number are removed.
+// 只追加写入的文件，超过大小上限时轮转
+type RotatingFile struct {
+	path     string
+	maxBytes int64
+	maxFiles int
+
+	f    *os.File
+	size int64
+}
+
+// OpenRotatingFile opens the file at path for appending, creating it if
+// needed. A maxBytes of zero or less means the file is never rotated;
+// maxFiles is the number of the rotated files kept.
+func OpenRotatingFile(path string, maxBytes int64, maxFiles int) (*RotatingFile, error) {
+	rf := &RotatingFile{path: path, maxBytes: maxBytes, maxFiles: maxFiles}
+	if err := rf.open(); err != nil {
+		return nil, err
+	}
+	return rf, nil
+}
+
+func (rf *RotatingFile) open() error {
+	f, err := os.OpenFile(rf.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return err
+	}
+	fi, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+	rf.f, rf.size = f, fi.Size()
+	return nil
+}
+
+// Write appends p to the file, rotating it first if p would take it
+// beyond the max size. A p larger than the max size is written to a
+// file of its own.
+func (rf *RotatingFile) Write(p []byte) (int, error) {
+	if rf.maxBytes > 0 && rf.size > 0 && rf.size+int64(len(p)) > rf.maxBytes {
+		if err := rf.rotate(); err != nil {
+			return 0, err
+		}
+	}
+	n, err := rf.f.Write(p)
+	rf.size += int64(n)
+	return n, err
+}
+
+func (rf *RotatingFile) rotate() error {
+	if err := rf.f.Close(); err != nil {
+		return err
+	}
+	if err := os.Remove(rf.rotatedPath(rf.maxFiles)); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	for i := rf.maxFiles - 1; i >= 0; i-- {
+		if err := os.Rename(rf.rotatedPath(i), rf.rotatedPath(i+1)); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return rf.open()
+}
+
+// rotatedPath returns the path of the i-th rotated file; the 0th is the
+// file written to.
+func (rf *RotatingFile) rotatedPath(i int) string {
+	if i == 0 {
+		return rf.path
+	}
+	return fmt.Sprintf("%s.%d", rf.path, i)
+}
+
+// Close closes the file.
+func (rf *RotatingFile) Close() error { return rf.f.Close() }
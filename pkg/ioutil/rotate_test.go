@@ -0,0 +1,86 @@
+// Copyright 2015 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ioutil
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+)
+
+func TestRotatingFile(t *testing.T) {
+	dir, err := ioutil.TempDir(os.TempDir(), "rotate")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	p := path.Join(dir, "log")
+
+	rf, err := OpenRotatingFile(p, 4, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, s := range []string{"a", "bb", "c", "dd", "eeeee", "f"} {
+		if _, err := rf.Write([]byte(s)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := rf.Close(); err != nil {
+		t.Fatal(err)
+	}
+	// the oldest rotated file, "abbc", is removed
+	wfiles := map[string]string{
+		"log":   "f",
+		"log.1": "eeeee",
+		"log.2": "dd",
+	}
+	names, err := ioutil.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(names) != len(wfiles) {
+		t.Errorf("len(files) = %d, want %d", len(names), len(wfiles))
+	}
+	for name, w := range wfiles {
+		b, err := ioutil.ReadFile(path.Join(dir, name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(b) != w {
+			t.Errorf("%s = %q, want %q", name, b, w)
+		}
+	}
+
+	// a reopened file is appended to, and rotated by its size
+	rf, err = OpenRotatingFile(p, 4, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rf.Close()
+	if _, err := rf.Write([]byte("ggg")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := rf.Write([]byte("h")); err != nil {
+		t.Fatal(err)
+	}
+	b, err := ioutil.ReadFile(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(b) != "h" {
+		t.Errorf("log = %q, want %q", b, "h")
+	}
+}
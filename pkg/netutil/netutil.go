@@ -35,6 +35,10 @@ var (
 func ResolveTCPAddrs(urls ...[]url.URL) error {
 	for _, us := range urls {
 		for i, u := range us {
+			// the host of a unix socket URL is the path of the socket
+			if u.Scheme == "unix" || u.Scheme == "unixs" {
+				continue
+			}
 			host, _, err := net.SplitHostPort(u.Host)
 			if err != nil {
 				log.Printf("netutil: Could not parse url %s during tcp resolving.", u.Host)
@@ -0,0 +1,141 @@
+// Copyright 2015 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package schedule runs jobs one at a time, in the order they are
+// scheduled, on a goroutine of its own.
+package schedule
+
+import (
+	"sync"
+
+	"github.com/coreos/etcd/Godeps/_workspace/src/golang.org/x/net/context"
+)
+
+type Job func(context.Context)
+
+type Scheduler interface {
+	// Schedule asks the scheduler to run the job. It does not block.
+	Schedule(j Job)
+	// Pending returns the number of the jobs waiting to run.
+	Pending() int
+	// Finished returns the number of the jobs that have run.
+	Finished() int
+	// WaitFinish blocks until at least n jobs have run.
+	WaitFinish(n int)
+	// Stop cancels the context of the running job and waits for it to
+	// return. The pending jobs are dropped.
+	Stop()
+}
+
+type fifo struct {
+	mu         sync.Mutex
+	finishCond *sync.Cond
+
+	resume   chan struct{}
+	pendings []Job
+	finished int
+
+	ctx    context.Context
+	cancel context.CancelFunc
+	donec  chan struct{}
+}
+
+// NewFIFOScheduler returns a Scheduler that runs the jobs in the order
+// they are scheduled.
+func NewFIFOScheduler() Scheduler {
+	f := &fifo{
+		resume: make(chan struct{}, 1),
+		donec:  make(chan struct{}),
+	}
+	f.finishCond = sync.NewCond(&f.mu)
+	f.ctx, f.cancel = context.WithCancel(context.Background())
+	go f.run()
+	return f
+}
+
+func (f *fifo) Schedule(j Job) {
+	f.mu.Lock()
+	f.pendings = append(f.pendings, j)
+	f.mu.Unlock()
+	select {
+	case f.resume <- struct{}{}:
+	default:
+	}
+}
+
+func (f *fifo) Pending() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return len(f.pendings)
+}
+
+func (f *fifo) Finished() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.finished
+}
+
+func (f *fifo) WaitFinish(n int) {
+	f.mu.Lock()
+	for f.finished < n && f.ctx.Err() == nil {
+		f.finishCond.Wait()
+	}
+	f.mu.Unlock()
+}
+
+func (f *fifo) Stop() {
+	f.cancel()
+	<-f.donec
+}
+
+func (f *fifo) run() {
+	defer func() {
+		close(f.donec)
+		// wake up the waiters, there is nothing more to wait for
+		f.mu.Lock()
+		f.finishCond.Broadcast()
+		f.mu.Unlock()
+	}()
+	for {
+		f.mu.Lock()
+		var j Job
+		if len(f.pendings) != 0 {
+			j = f.pendings[0]
+			f.pendings[0] = nil
+			f.pendings = f.pendings[1:]
+		}
+		f.mu.Unlock()
+
+		if j == nil {
+			select {
+			case <-f.resume:
+				continue
+			case <-f.ctx.Done():
+				return
+			}
+		}
+		select {
+		case <-f.ctx.Done():
+			return
+		default:
+		}
+
+		j(f.ctx)
+
+		f.mu.Lock()
+		f.finished++
+		f.finishCond.Broadcast()
+		f.mu.Unlock()
+	}
+}
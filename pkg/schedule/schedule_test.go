@@ -0,0 +1,84 @@
+// Copyright 2015 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package schedule
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/coreos/etcd/Godeps/_workspace/src/golang.org/x/net/context"
+)
+
+func TestFIFOSchedule(t *testing.T) {
+	s := NewFIFOScheduler()
+	defer s.Stop()
+
+	next := 0
+	jobs := make([]Job, 100)
+	for i := range jobs {
+		i := i
+		jobs[i] = func(ctx context.Context) {
+			if next != i {
+				t.Fatalf("job#%d: got %d, want %d", i, next, i)
+			}
+			next = i + 1
+		}
+	}
+	for _, j := range jobs {
+		s.Schedule(j)
+	}
+
+	s.WaitFinish(100)
+	if s.Finished() != 100 {
+		t.Errorf("finished = %d, want %d", s.Finished(), 100)
+	}
+	if s.Pending() != 0 {
+		t.Errorf("pending = %d, want %d", s.Pending(), 0)
+	}
+	if next != 100 {
+		t.Errorf("next = %d, want %d", next, 100)
+	}
+}
+
+func TestFIFOStop(t *testing.T) {
+	s := NewFIFOScheduler()
+
+	startc := make(chan struct{})
+	canceled := make(chan struct{})
+	s.Schedule(func(ctx context.Context) {
+		close(startc)
+		<-ctx.Done()
+		close(canceled)
+	})
+	var ran []int
+	s.Schedule(func(ctx context.Context) { ran = append(ran, 1) })
+
+	<-startc
+	if g := s.Pending(); g != 1 {
+		t.Errorf("pending = %d, want %d", g, 1)
+	}
+	s.Stop()
+	select {
+	case <-canceled:
+	case <-time.After(time.Second):
+		t.Fatalf("the running job is not canceled")
+	}
+	if !reflect.DeepEqual(ran, []int(nil)) {
+		t.Errorf("ran = %v, want none", ran)
+	}
+	// WaitFinish returns on a stopped scheduler
+	s.WaitFinish(2)
+}
@@ -22,7 +22,11 @@ import (
 
 // NewKeepAliveListener returns a listener that listens on the given address.
 // http://tldp.org/HOWTO/TCP-Keepalive-HOWTO/overview.html
+// The connections to a unix domain socket are not kept alive.
 func NewKeepAliveListener(addr string, scheme string, info TLSInfo) (net.Listener, error) {
+	if scheme == "unix" || scheme == "unixs" {
+		return NewListener(addr, scheme, info)
+	}
 	l, err := net.Listen("tcp", addr)
 	if err != nil {
 		return nil, err
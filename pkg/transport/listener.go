@@ -25,13 +25,23 @@ import (
 	"time"
 )
 
+// NewListener returns a listener that listens on the given address. The
+// address of the unix and unixs schemes is the path of a unix domain socket.
 func NewListener(addr string, scheme string, info TLSInfo) (net.Listener, error) {
-	l, err := net.Listen("tcp", addr)
+	var (
+		l   net.Listener
+		err error
+	)
+	if scheme == "unix" || scheme == "unixs" {
+		l, err = NewUnixListener(addr)
+	} else {
+		l, err = net.Listen("tcp", addr)
+	}
 	if err != nil {
 		return nil, err
 	}
 	// 采用secure模式
-	if scheme == "https" {
+	if scheme == "https" || scheme == "unixs" {
 		if info.Empty() {
 			return nil, fmt.Errorf("cannot listen on TLS for %s: KeyFile and CertFile are not presented", scheme+"://"+addr)
 		}
@@ -46,7 +56,19 @@ func NewListener(addr string, scheme string, info TLSInfo) (net.Listener, error)
 	return l, nil
 }
 
+// NewTransport returns a transport created using the given TLS info. It
+// sends the requests to the unix and unixs URLs over the unix domain
+// socket named by their host.
 func NewTransport(info TLSInfo) (*http.Transport, error) {
+	t, err := newTransport(info)
+	if err != nil {
+		return nil, err
+	}
+	registerUnixProtocols(t)
+	return t, nil
+}
+
+func newTransport(info TLSInfo) (*http.Transport, error) {
 	cfg, err := info.ClientConfig()
 	if err != nil {
 		return nil, err
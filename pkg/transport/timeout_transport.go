@@ -25,7 +25,7 @@ import (
 // it will return timeout error.
 // 设置30秒的keepAlive
 func NewTimeoutTransport(info TLSInfo, dialtimeoutd, rdtimeoutd, wtimeoutd time.Duration) (*http.Transport, error) {
-	tr, err := NewTransport(info)
+	tr, err := newTransport(info)
 	if err != nil {
 		return nil, err
 	}
@@ -40,5 +40,6 @@ func NewTimeoutTransport(info TLSInfo, dialtimeoutd, rdtimeoutd, wtimeoutd time.
 		rdtimeoutd: rdtimeoutd,
 		wtimeoutd:  wtimeoutd,
 	}).Dial
+	registerUnixProtocols(tr)
 	return tr, nil
 }
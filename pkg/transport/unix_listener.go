@@ -0,0 +1,76 @@
+// Copyright 2015 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package transport
+
+import (
+	"net"
+	"net/http"
+	"os"
+	"strings"
+)
+
+type unixListener struct{ net.Listener }
+
+// NewUnixListener returns a listener on the unix domain socket at the given
+// path. The socket file left by a previous listener is removed first, and
+// the file is removed when the listener is closed.
+func NewUnixListener(addr string) (net.Listener, error) {
+	if err := os.RemoveAll(addr); err != nil {
+		return nil, err
+	}
+	l, err := net.Listen("unix", addr)
+	if err != nil {
+		return nil, err
+	}
+	return &unixListener{l}, nil
+}
+
+func (ul *unixListener) Close() error {
+	if err := os.RemoveAll(ul.Addr().String()); err != nil {
+		return err
+	}
+	return ul.Listener.Close()
+}
+
+// registerUnixProtocols makes t send the requests to the unix and unixs
+// URLs through a transport which dials the socket named by their host the
+// way t dials.
+func registerUnixProtocols(t *http.Transport) {
+	dial := t.Dial
+	if dial == nil {
+		dial = net.Dial
+	}
+	ut := &unixTransport{&http.Transport{
+		Dial: func(_, addr string) (net.Conn, error) {
+			return dial("unix", addr)
+		},
+		TLSHandshakeTimeout: t.TLSHandshakeTimeout,
+		TLSClientConfig:     t.TLSClientConfig,
+		MaxIdleConnsPerHost: t.MaxIdleConnsPerHost,
+	}}
+	t.RegisterProtocol("unix", ut)
+	t.RegisterProtocol("unixs", ut)
+}
+
+type unixTransport struct{ *http.Transport }
+
+func (ut *unixTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	// unix is sent as http, and unixs as https.
+	r := *req
+	u := *req.URL
+	u.Scheme = strings.Replace(u.Scheme, "unix", "http", 1)
+	r.URL = &u
+	return ut.Transport.RoundTrip(&r)
+}
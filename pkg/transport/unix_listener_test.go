@@ -0,0 +1,72 @@
+// Copyright 2015 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package transport
+
+import (
+	"io/ioutil"
+	"net/http"
+	"os"
+	"testing"
+)
+
+// TestUnixListenerTransport tests that the transport sends the requests to
+// a unix URL to the listener on the socket named by its host.
+func TestUnixListenerTransport(t *testing.T) {
+	dir, err := ioutil.TempDir("", "etcd-test-unix-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the host of the unix URL is the socket path relative to the working directory
+	if err = os.Chdir(dir); err != nil {
+		t.Fatal(err)
+	}
+	defer os.Chdir(wd)
+
+	ln, err := NewListener("localhost:2379", "unix", TLSInfo{})
+	if err != nil {
+		t.Fatalf("unexpected NewListener error: %v", err)
+	}
+	go http.Serve(ln, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("hello"))
+	}))
+
+	tr, err := NewTimeoutTransport(TLSInfo{}, 0, 0, 0)
+	if err != nil {
+		t.Fatalf("unexpected NewTimeoutTransport error: %v", err)
+	}
+	req, err := http.NewRequest("GET", "unix://localhost:2379/", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := tr.RoundTrip(req)
+	if err != nil {
+		t.Fatalf("unexpected RoundTrip error: %v", err)
+	}
+	b, err := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil || string(b) != "hello" {
+		t.Errorf("body = %q, %v, want %q", b, err, "hello")
+	}
+
+	ln.Close()
+	if _, err = os.Stat("localhost:2379"); !os.IsNotExist(err) {
+		t.Errorf("stat err = %v, want the socket file removed", err)
+	}
+}
@@ -46,6 +46,11 @@ func TestNewURLs(t *testing.T) {
 				"http://127.0.0.2:2379",
 			}),
 		},
+		// unix domain sockets
+		{
+			[]string{"unix://localhost:2379", "unixs://localhost:2380"},
+			testutil.MustNewURLs(t, []string{"unix://localhost:2379", "unixs://localhost:2380"}),
+		},
 	}
 	for i, tt := range tests {
 		urls, _ := NewURLs(tt.strs)
@@ -157,6 +162,7 @@ func TestNewURLsFail(t *testing.T) {
 		{"mailto://127.0.0.1:2379"},
 		// not conform to host:port
 		{"http://127.0.0.1"},
+		{"unix://localhost"},
 		// contain a path
 		{"http://127.0.0.1:2379/path"},
 	}
@@ -27,11 +27,12 @@ const (
 	// state before being reconsidered for proxied requests
 	endpointFailureWait = 5 * time.Second
 
-	// how often the proxy will attempt to refresh its set of endpoints
-	refreshEndpoints = 30 * time.Second
+	// DefaultRefreshInterval is how often the proxy attempts to refresh
+	// its set of endpoints by default.
+	DefaultRefreshInterval = 30 * time.Second
 )
 
-func newDirector(urlsFunc GetProxyURLs) *director {
+func newDirector(urlsFunc GetProxyURLs, refreshInterval time.Duration) *director {
 	d := &director{
 		uf: urlsFunc,
 	}
@@ -40,7 +41,7 @@ func newDirector(urlsFunc GetProxyURLs) *director {
 	go func() {
 		for {
 			select {
-			case <-time.After(refreshEndpoints):
+			case <-time.After(refreshInterval):
 				d.refresh()
 			}
 		}
@@ -16,6 +16,7 @@ package proxy
 
 import (
 	"net/http"
+	"time"
 )
 
 // GetProxyURLs is a function which should return the current set of URLs to
@@ -26,11 +27,11 @@ type GetProxyURLs func() []string
 
 // NewHandler creates a new HTTP handler, listening on the given transport,
 // which will proxy requests to an etcd cluster.
-// The handler will periodically update its view of the cluster.
+// The handler will update its view of the cluster every refreshInterval.
 //作为client请求代理，将client的请求转向cluster
-func NewHandler(t *http.Transport, urlsFunc GetProxyURLs) http.Handler {
+func NewHandler(t *http.Transport, urlsFunc GetProxyURLs, refreshInterval time.Duration) http.Handler {
 	return &reverseProxy{
-		director:  newDirector(urlsFunc),
+		director:  newDirector(urlsFunc, refreshInterval),
 		transport: t,
 	}
 }
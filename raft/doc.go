@@ -22,6 +22,13 @@ using raft.StartNode or start a Node from some initial state using raft.RestartN
 	storage := raft.NewMemoryStorage()
 	n := raft.StartNode(0x01, []raft.Peer{{ID: 0x02}, {ID: 0x03}}, 3, 1, storage)
 
+A Node runs a goroutine of its own. An application that drives raft from its
+own event loop, e.g. to host many raft groups, can use a RawNode from
+raft.NewRawNode instead. It has the methods of Node without the channels:
+Ready returns the updates directly, HasReady tells whether there are any, and
+Advance takes the Ready that has been handled. A RawNode is not safe for
+concurrent use.
+
 Now that you are holding onto a Node you have a few responsibilities:
 
 First, you must read from the Node.Ready() channel and process the updates
@@ -100,6 +107,17 @@ If the proposal is committed, data will appear in committed entries with type
 raftpb.EntryNormal. There is no guarantee that a proposed command will be
 committed; you may have to re-propose after a timeout.
 
+To serve a linearizable read without writing to the log, call:
+
+	n.ReadIndex(ctx, rctx)
+
+with a unique rctx. A ReadState carrying rctx will appear in Ready.ReadStates;
+the read can be served once the applied index reaches ReadState.Index. With
+the default Config.ReadOnlyOption, ReadOnlySafe, the leader confirms its
+leadership with a round of heartbeats. With ReadOnlyLeaseBased it answers at
+once, relying on CheckQuorum and on the clocks of the members running at
+about the same rate.
+
 To add or remove node in a cluster, build ConfChange struct 'cc' and call:
 
 	n.ProposeConfChange(ctx, cc)
@@ -111,6 +129,14 @@ raftpb.EntryConfChange will be returned. You must apply it to node through:
 	cc.Unmarshal(data)
 	n.ApplyConfChange(cc)
 
+A ConfChange of type raftpb.ConfChangeAddWitness adds a witness: a member
+which stores the log and votes, counting in the quorum, but keeps no state
+machine, e.g. to give a cluster spanning two datacenters a cheap third vote.
+A witness never campaigns, and the snapshots sent to it carry no data. The
+application running a witness should keep applying the configuration
+changes, and only those, from the committed entries. The witnesses are
+listed in ConfState.Witnesses.
+
 Note: An ID represents a unique node in a cluster for all time. A
 given ID MUST be used only once even if the old node has been removed.
 This means that for example IP addresses make poor node IDs since they
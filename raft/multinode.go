@@ -1,10 +1,16 @@
 package raft
 
 import (
+	"errors"
+
 	"github.com/coreos/etcd/Godeps/_workspace/src/golang.org/x/net/context"
 	pb "github.com/coreos/etcd/raft/raftpb"
 )
 
+// ErrGroupExists is returned by MultiNode.CreateGroup for a group that
+// has been created.
+var ErrGroupExists = errors.New("raft: group exists")
+
 // MultiNode represents a node that is participating in multiple consensus groups.
 // A MultiNode is more efficient than a collection of Nodes.
 // The methods of this interface correspond to the methods of Node and are described
@@ -14,7 +20,7 @@ type MultiNode interface {
 	// on each particpating node with the same group ID; it may create groups on demand as it
 	// receives messages. If the given storage contains existing log entries the list of peers
 	// may be empty. The Config.ID field will be ignored and replaced by the ID passed
-	// to StartMultiNode.
+	// to StartMultiNode. It returns ErrGroupExists if the group has been created.
 	CreateGroup(group uint64, c *Config, peers []Peer) error
 	// RemoveGroup removes a group from the MultiNode.
 	RemoveGroup(group uint64) error
@@ -28,7 +34,8 @@ type MultiNode interface {
 	ProposeConfChange(ctx context.Context, group uint64, cc pb.ConfChange) error
 	// ApplyConfChange applies a config change to the local node.
 	ApplyConfChange(group uint64, cc pb.ConfChange) *pb.ConfState
-	// Step advances the state machine using the given message.
+	// Step advances the state machine using the given message. The messages to
+	// a group that does not exist, e.g. one that has been removed, are dropped.
 	Step(ctx context.Context, group uint64, msg pb.Message) error
 	// Ready returns a channel that returns the current point-in-time state of any ready
 	// groups. Only groups with something to report will appear in the map.
@@ -43,6 +50,12 @@ type MultiNode interface {
 	ReportUnreachable(id, groupID uint64)
 	// ReportSnapshot reports the stutus of the sent snapshot.
 	ReportSnapshot(id, groupID uint64, status SnapshotStatus)
+	// TransferLeadership attempts to transfer the leadership of the given group
+	// to the given transferee.
+	TransferLeadership(ctx context.Context, group, lead, transferee uint64)
+	// Unquiesce wakes the given group up if it has been quiesced, see
+	// Config.Quiesce.
+	Unquiesce(group uint64)
 	// Stop performs any necessary termination of the MultiNode.
 	Stop()
 }
@@ -80,7 +93,7 @@ type groupCreation struct {
 	// TODO(bdarnell): do we really need the done channel here? It's
 	// unlike the rest of this package, but we need the group creation
 	// to be complete before any Propose or other calls.
-	done chan struct{}
+	done chan error
 }
 
 type groupRemoval struct {
@@ -121,47 +134,16 @@ func newMultiNode(id uint64) multiNode {
 	}
 }
 
+// groupState is a group driven by the run loop of the multiNode.
 type groupState struct {
-	id         uint64
-	raft       *raft
-	prevSoftSt *SoftState
-	prevHardSt pb.HardState
-	prevSnapi  uint64
+	id uint64
+	*RawNode
 }
 
 func (g *groupState) newReady() Ready {
 	return newReady(g.raft, g.prevSoftSt, g.prevHardSt)
 }
 
-func (g *groupState) commitReady(rd Ready) {
-	if rd.SoftState != nil {
-		g.prevSoftSt = rd.SoftState
-	}
-	if !IsEmptyHardState(rd.HardState) {
-		g.prevHardSt = rd.HardState
-	}
-	if g.prevHardSt.Commit != 0 {
-		// In most cases, prevHardSt and rd.HardState will be the same
-		// because when there are new entries to apply we just sent a
-		// HardState with an updated Commit value. However, on initial
-		// startup the two are different because we don't send a HardState
-		// until something changes, but we do send any un-applied but
-		// committed entries (and previously-committed entries may be
-		// incorporated into the snapshot, even if rd.CommittedEntries is
-		// empty). Therefore we mark all committed entries as applied
-		// whether they were included in rd.HardState or not.
-		g.raft.raftLog.appliedTo(g.prevHardSt.Commit)
-	}
-	if len(rd.Entries) > 0 {
-		e := rd.Entries[len(rd.Entries)-1]
-		g.raft.raftLog.stableTo(e.Index, e.Term)
-	}
-	if !IsEmptySnap(rd.Snapshot) {
-		g.prevSnapi = rd.Snapshot.Metadata.Index
-		g.raft.raftLog.stableSnapTo(g.prevSnapi)
-	}
-}
-
 func (mn *multiNode) run() {
 	groups := map[uint64]*groupState{}
 	rds := map[uint64]Ready{}
@@ -178,42 +160,32 @@ func (mn *multiNode) run() {
 		var group *groupState
 		select {
 		case gc := <-mn.groupc:
-			gc.config.ID = mn.id
-			r := newRaft(gc.config)
-			group = &groupState{
-				id:   gc.id,
-				raft: r,
+			if _, ok := groups[gc.id]; ok {
+				gc.done <- ErrGroupExists
+				break
 			}
-			groups[gc.id] = group
+			gc.config.ID = mn.id
 			lastIndex, err := gc.config.Storage.LastIndex()
 			if err != nil {
-				panic(err) // TODO(bdarnell)
+				gc.done <- err
+				break
 			}
-			// If the log is empty, this is a new group (like StartNode); otherwise it's
-			// restoring an existing group (like RestartNode).
+			// If the log is not empty, this is restoring an existing group (like
+			// RestartNode), and the peers are restored from the storage.
 			// TODO(bdarnell): rethink group initialization and whether the application needs
 			// to be able to tell us when it expects the group to exist.
-			if lastIndex == 0 {
-				r.becomeFollower(1, None)
-				ents := make([]pb.Entry, len(gc.peers))
-				for i, peer := range gc.peers {
-					cc := pb.ConfChange{Type: pb.ConfChangeAddNode, NodeID: peer.ID, Context: peer.Context}
-					data, err := cc.Marshal()
-					if err != nil {
-						panic("unexpected marshal error")
-					}
-					ents[i] = pb.Entry{Type: pb.EntryConfChange, Term: 1, Index: uint64(i + 1), Data: data}
-				}
-				r.raftLog.append(ents...)
-				r.raftLog.committed = uint64(len(ents))
-				for _, peer := range gc.peers {
-					r.addNode(peer.ID)
-				}
+			peers := gc.peers
+			if lastIndex != 0 {
+				peers = nil
+			}
+			rn, err := NewRawNode(gc.config, peers)
+			if err != nil {
+				gc.done <- err
+				break
 			}
-			// Set the initial hard and soft states after performing all initialization.
-			group.prevSoftSt = r.softState()
-			group.prevHardSt = r.HardState
-			close(gc.done)
+			group = &groupState{id: gc.id, RawNode: rn}
+			groups[gc.id] = group
+			gc.done <- nil
 
 		case gr := <-mn.rmgroupc:
 			delete(groups, gr.id)
@@ -225,38 +197,34 @@ func (mn *multiNode) run() {
 			// has a leader; we can't do that since we have one propc for many groups.
 			// We'll have to buffer somewhere on a group-by-group basis, or just let
 			// raft.Step drop any such proposals on the floor.
+			g, ok := groups[mm.group]
+			if !ok {
+				break
+			}
+			group = g
 			mm.msg.From = mn.id
-			group = groups[mm.group]
 			group.raft.Step(mm.msg)
 
 		case mm := <-mn.recvc:
-			group = groups[mm.group]
+			// the messages to an unknown group are dropped, as if they
+			// were lost on the network.
+			g, ok := groups[mm.group]
+			if !ok {
+				break
+			}
+			group = g
 			if _, ok := group.raft.prs[mm.msg.From]; ok || !IsResponseMsg(mm.msg) {
 				group.raft.Step(mm.msg)
 			}
 
 		case mcc := <-mn.confc:
-			group = groups[mcc.group]
-			if mcc.msg.NodeID == None {
-				group.raft.resetPendingConf()
-				select {
-				case mcc.ch <- pb.ConfState{Nodes: group.raft.nodes()}:
-				case <-mn.done:
-				}
-				break
-			}
-			switch mcc.msg.Type {
-			case pb.ConfChangeAddNode:
-				group.raft.addNode(mcc.msg.NodeID)
-			case pb.ConfChangeRemoveNode:
-				group.raft.removeNode(mcc.msg.NodeID)
-			case pb.ConfChangeUpdateNode:
-				group.raft.resetPendingConf()
-			default:
-				panic("unexpected conf type")
+			var cs pb.ConfState
+			if g, ok := groups[mcc.group]; ok {
+				group = g
+				cs = *group.ApplyConfChange(mcc.msg)
 			}
 			select {
-			case mcc.ch <- pb.ConfState{Nodes: group.raft.nodes()}:
+			case mcc.ch <- cs:
 			case <-mn.done:
 			}
 
@@ -265,6 +233,10 @@ func (mn *multiNode) run() {
 			// we should have a priority queue of groups based on their next
 			// time-based event.
 			for _, g := range groups {
+				// the quiesced groups have nothing to do on a tick.
+				if g.raft.quiesced {
+					continue
+				}
 				g.raft.tick()
 				rd := g.newReady()
 				if rd.containsUpdates() {
@@ -276,6 +248,7 @@ func (mn *multiNode) run() {
 			// Clear outgoing messages as soon as we've passed them to the application.
 			for g := range rds {
 				groups[g].raft.msgs = nil
+				groups[g].raft.readStates = nil
 			}
 			rds = map[uint64]Ready{}
 			advancec = mn.advancec
@@ -286,7 +259,7 @@ func (mn *multiNode) run() {
 				if !ok {
 					continue
 				}
-				group.commitReady(rd)
+				group.Advance(rd)
 
 				// We've been accumulating new entries in rds which may now be obsolete.
 				// Drop the old Ready object and create a new one if needed.
@@ -299,7 +272,11 @@ func (mn *multiNode) run() {
 			advancec = nil
 
 		case ms := <-mn.status:
-			ms.ch <- getStatus(groups[ms.group].raft)
+			var st Status
+			if g, ok := groups[ms.group]; ok {
+				st = g.Status()
+			}
+			ms.ch <- st
 
 		case <-mn.stop:
 			close(mn.done)
@@ -320,12 +297,16 @@ func (mn *multiNode) CreateGroup(id uint64, config *Config, peers []Peer) error
 		id:     id,
 		config: config,
 		peers:  peers,
-		done:   make(chan struct{}),
+		done:   make(chan error, 1),
 	}
-	mn.groupc <- gc
 	select {
-	case <-gc.done:
-		return nil
+	case mn.groupc <- gc:
+	case <-mn.done:
+		return ErrStopped
+	}
+	select {
+	case err := <-gc.done:
+		return err
 	case <-mn.done:
 		return ErrStopped
 	}
@@ -336,7 +317,11 @@ func (mn *multiNode) RemoveGroup(id uint64) error {
 		id:   id,
 		done: make(chan struct{}),
 	}
-	mn.rmgroupc <- gr
+	select {
+	case mn.rmgroupc <- gr:
+	case <-mn.done:
+		return ErrStopped
+	}
 	select {
 	case <-gr.done:
 		return nil
@@ -448,8 +433,12 @@ func (mn *multiNode) Status(group uint64) Status {
 		group: group,
 		ch:    make(chan Status),
 	}
-	mn.status <- ms
-	return <-ms.ch
+	select {
+	case mn.status <- ms:
+		return <-ms.ch
+	case <-mn.done:
+		return Status{}
+	}
 }
 
 func (mn *multiNode) ReportUnreachable(id, groupID uint64) {
@@ -473,3 +462,25 @@ func (mn *multiNode) ReportSnapshot(id, groupID uint64, status SnapshotStatus) {
 	case <-mn.done:
 	}
 }
+
+func (mn *multiNode) TransferLeadership(ctx context.Context, group, lead, transferee uint64) {
+	select {
+	// manually set 'from' and 'to', so that leader can voluntarily transfers its leadership
+	case mn.recvc <- multiMessage{
+		group: group,
+		msg:   pb.Message{Type: pb.MsgTransferLeader, From: transferee, To: lead},
+	}:
+	case <-mn.done:
+	case <-ctx.Done():
+	}
+}
+
+func (mn *multiNode) Unquiesce(group uint64) {
+	select {
+	case mn.recvc <- multiMessage{
+		group: group,
+		msg:   pb.Message{Type: pb.MsgUnquiesce},
+	}:
+	case <-mn.done:
+	}
+}
@@ -42,7 +42,8 @@ func TestMultiNodeStep(t *testing.T) {
 				t.Errorf("%d: cannot receive %s on propc chan", msgt, msgn)
 			}
 		} else {
-			if msgt == raftpb.MsgBeat || msgt == raftpb.MsgHup || msgt == raftpb.MsgUnreachable || msgt == raftpb.MsgSnapStatus {
+			if msgt == raftpb.MsgBeat || msgt == raftpb.MsgHup || msgt == raftpb.MsgUnreachable ||
+				msgt == raftpb.MsgSnapStatus || msgt == raftpb.MsgCheckQuorum || msgt == raftpb.MsgUnquiesce {
 				select {
 				case <-mn.recvc:
 					t.Errorf("%d: step should ignore %s", msgt, msgn)
@@ -392,3 +393,92 @@ func TestMultiNodeAdvance(t *testing.T) {
 		t.Errorf("expect Ready after Advance, but there is no Ready available")
 	}
 }
+
+// TestMultiNodeCreateGroupExists ensures that a group cannot be created twice.
+func TestMultiNodeCreateGroupExists(t *testing.T) {
+	mn := StartMultiNode(1)
+	defer mn.Stop()
+	if err := mn.CreateGroup(1, newTestConfig(1, nil, 10, 1, NewMemoryStorage()), []Peer{{ID: 1}}); err != nil {
+		t.Fatal(err)
+	}
+	if err := mn.CreateGroup(1, newTestConfig(1, nil, 10, 1, NewMemoryStorage()), []Peer{{ID: 1}}); err != ErrGroupExists {
+		t.Errorf("err = %v, want %v", err, ErrGroupExists)
+	}
+}
+
+// TestMultiNodeUnknownGroup ensures that the calls for a group that does not
+// exist, e.g. the messages to a removed group, do not affect the MultiNode.
+func TestMultiNodeUnknownGroup(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	mn := StartMultiNode(1)
+	defer mn.Stop()
+	if err := mn.CreateGroup(1, newTestConfig(1, nil, 10, 1, NewMemoryStorage()), []Peer{{ID: 1}}); err != nil {
+		t.Fatal(err)
+	}
+	if err := mn.RemoveGroup(1); err != nil {
+		t.Fatal(err)
+	}
+	mn.Campaign(ctx, 1)
+	mn.Propose(ctx, 1, []byte("foo"))
+	mn.Step(ctx, 2, raftpb.Message{Type: raftpb.MsgApp, From: 2, To: 1, Term: 1})
+	mn.Tick()
+	if cs := mn.ApplyConfChange(1, raftpb.ConfChange{Type: raftpb.ConfChangeAddNode, NodeID: 2}); len(cs.Nodes) != 0 {
+		t.Errorf("nodes = %v, want none", cs.Nodes)
+	}
+	if st := mn.Status(1); st.ID != 0 {
+		t.Errorf("status id = %x, want 0", st.ID)
+	}
+	select {
+	case rd := <-mn.Ready():
+		t.Errorf("unexpected Ready: %+v", rd)
+	case <-time.After(time.Millisecond):
+	}
+}
+
+// TestMultiNodeManyGroups ensures that the groups of a MultiNode elect their
+// leaders and commit their proposals independently, with their readies
+// batched together.
+func TestMultiNodeManyGroups(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	const n = 100
+	mn := StartMultiNode(1)
+	defer mn.Stop()
+	storages := make(map[uint64]*MemoryStorage)
+	for g := uint64(1); g <= n; g++ {
+		storages[g] = NewMemoryStorage()
+		if err := mn.CreateGroup(g, newTestConfig(1, nil, 10, 1, storages[g]), []Peer{{ID: 1}}); err != nil {
+			t.Fatal(err)
+		}
+		mn.Campaign(ctx, g)
+	}
+
+	proposed := make(map[uint64]bool)
+	committed := make(map[uint64]bool)
+	for len(committed) < n {
+		var rds map[uint64]Ready
+		select {
+		case rds = <-mn.Ready():
+		case <-time.After(time.Second):
+			t.Fatalf("committed %d groups, want %d", len(committed), n)
+		}
+		for g, rd := range rds {
+			storages[g].Append(rd.Entries)
+			for _, e := range rd.CommittedEntries {
+				if string(e.Data) == "foo" {
+					committed[g] = true
+				}
+			}
+		}
+		mn.Advance(rds)
+		for g, rd := range rds {
+			if rd.SoftState != nil && rd.SoftState.RaftState == StateLeader && !proposed[g] {
+				mn.Propose(ctx, g, []byte("foo"))
+				proposed[g] = true
+			}
+		}
+	}
+}
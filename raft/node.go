@@ -67,6 +67,12 @@ type Ready struct {
 	// Messages are sent.
 	Entries []pb.Entry
 
+	// ReadStates can be used for node to serve linearizable read requests locally
+	// when its applied index is greater than the index in ReadState.
+	// Note that the readState will be returned when raft receives msgReadIndex.
+	// The returned is only valid for the request that requested to read.
+	ReadStates []ReadState
+
 	// Snapshot specifies the snapshot to be saved to stable storage.
 	Snapshot pb.Snapshot
 
@@ -99,7 +105,7 @@ func IsEmptySnap(sp pb.Snapshot) bool {
 func (rd Ready) containsUpdates() bool {
 	return rd.SoftState != nil || !IsEmptyHardState(rd.HardState) ||
 		!IsEmptySnap(rd.Snapshot) || len(rd.Entries) > 0 ||
-		len(rd.CommittedEntries) > 0 || len(rd.Messages) > 0
+		len(rd.CommittedEntries) > 0 || len(rd.Messages) > 0 || len(rd.ReadStates) != 0
 }
 
 // Node represents a node in a raft cluster.
@@ -111,15 +117,24 @@ type Node interface {
 	// Campaign causes the Node to transition to candidate state and start campaigning to become leader.
 	//竞选，转变为candidate状态，开始竞选leader
 	Campaign(ctx context.Context) error
-	// Propose proposes that data be appended to the log.
+	// Propose proposes that data be appended to the log. It returns
+	// ErrProposalDropped if there is no leader, the leadership is being
+	// transferred or the leader has too many uncommitted entries.
 	Propose(ctx context.Context, data []byte) error
 	// ProposeConfChange proposes config change.
-	// At most one ConfChange can be in the process of going through consensus.
+	// At most one ConfChange can be in the process of going through consensus;
+	// ErrProposalDropped is returned while another one is pending.
 	// Application needs to call ApplyConfChange when applying EntryConfChange type entry.
 	ProposeConfChange(ctx context.Context, cc pb.ConfChange) error
 	// Step advances the state machine using the given message. ctx.Err() will be returned, if any.
 	//Step 推进状态机的执行
 	Step(ctx context.Context, msg pb.Message) error
+	// ReadIndex requests a read state. The read state will be set in the ready.
+	// Read state has a read index. Once the application advances further than the read
+	// index, any linearizable read requests issued before the read request can be
+	// processed safely. The read state will have the same rctx attached.
+	// How the leader confirms its leadership depends on Config.ReadOnlyOption.
+	ReadIndex(ctx context.Context, rctx []byte) error
 	// Ready returns a channel that returns the current point-in-time state
 	// Users of the Node must call Advance after applying the state returned by Ready
 	// 返回当前时间点的状态的channel
@@ -138,6 +153,14 @@ type Node interface {
 	ReportUnreachable(id uint64)
 	// ReportSnapshot reports the stutus of the sent snapshot.
 	ReportSnapshot(id uint64, status SnapshotStatus)
+	// TransferLeadership attempts to transfer leadership to the given transferee.
+	// lead is the ID of the current leader as known by the caller; the request
+	// is forwarded to it if the local node is a follower.
+	TransferLeadership(ctx context.Context, lead, transferee uint64)
+	// Unquiesce wakes the Node up if it has been quiesced, see Config.Quiesce.
+	// The application calls it when it suspects the leader of a quiesced
+	// follower, so that the follower resumes its election timer.
+	Unquiesce()
 	// Stop performs any necessary termination of the Node
 	Stop()
 }
@@ -154,6 +177,16 @@ type Peer struct {
 //会添加ConfChangeAddNode entry到log中。初始状态设置为follower
 func StartNode(c *Config, peers []Peer) Node {
 	r := newRaft(c)
+	bootstrap(r, peers)
+
+	n := newNode()
+	go n.run(r)
+	return &n
+}
+
+// bootstrap makes r a follower at term 1 with a committed
+// ConfChangeAddNode entry for each given peer in its log.
+func bootstrap(r *raft, peers []Peer) {
 	// become the follower at term 1 and apply initial configuration
 	// entires of term 1
 	r.becomeFollower(1, None)
@@ -183,10 +216,6 @@ func StartNode(c *Config, peers []Peer) Node {
 	for _, peer := range peers {
 		r.addNode(peer.ID)
 	}
-
-	n := newNode()
-	go n.run(r)
-	return &n
 }
 
 // RestartNode is similar to StartNode but does not take a list of peers.
@@ -202,10 +231,17 @@ func RestartNode(c *Config) Node {
 	return &n
 }
 
+// msgWithResult is a proposal together with the channel on which the
+// result of stepping it is sent back, if the proposer waits for it.
+type msgWithResult struct {
+	m      pb.Message
+	result chan error
+}
+
 // node is the canonical implementation of the Node interface
 type node struct {
 	// client-->server的propose消息 channel
-	propc chan pb.Message
+	propc chan msgWithResult
 	// 接收消息的channel
 	recvc chan pb.Message
 	// 配置变更的channel
@@ -223,7 +259,7 @@ type node struct {
 
 func newNode() node {
 	return node{
-		propc:      make(chan pb.Message),
+		propc:      make(chan msgWithResult),
 		recvc:      make(chan pb.Message),
 		confc:      make(chan pb.ConfChange),
 		confstatec: make(chan pb.ConfState),
@@ -251,7 +287,6 @@ func (n *node) Stop() {
 //真正启动node的函数,for循环处理node的channel中的各类消息
 // 初始化leader为None
 func (n *node) run(r *raft) {
-	var propc chan pb.Message
 	var readyc chan Ready
 	var advancec chan struct{}
 	var prevLastUnstablei, prevLastUnstablet uint64
@@ -279,14 +314,12 @@ func (n *node) run(r *raft) {
 			if r.hasLeader() {
 				// 首次启动，以raft的leader为leader
 				if lead == None {
-					raftLogger.Infof("raft.node: %x elected leader %x at term %d", r.id, r.lead, r.Term)
+					r.logger.Infof("raft.node: %x elected leader %x at term %d", r.id, r.lead, r.Term)
 				} else { // 变更leader
-					raftLogger.Infof("raft.node: %x changed leader from %x to %x at term %d", r.id, lead, r.lead, r.Term)
+					r.logger.Infof("raft.node: %x changed leader from %x to %x at term %d", r.id, lead, r.lead, r.Term)
 				}
-				propc = n.propc
 			} else {
-				raftLogger.Infof("raft.node: %x lost leader %x at term %d", r.id, lead, r.Term)
-				propc = nil
+				r.logger.Infof("raft.node: %x lost leader %x at term %d", r.id, lead, r.Term)
 			}
 			lead = r.lead
 		}
@@ -294,11 +327,17 @@ func (n *node) run(r *raft) {
 		select {
 		// TODO: maybe buffer the config propose if there exists one (the way
 		// described in raft dissertation)
-		// Currently it is dropped in Step silently.
+		// Currently it is dropped in Step, which returns ErrProposalDropped.
+		// The proposals are taken without a leader too, so that they fail
+		// fast instead of blocking until the election.
 		// 处理client-->server的propos channel中的消息
-		case m := <-propc:
+		case pm := <-n.propc:
+			m := pm.m
 			m.From = r.id
-			r.Step(m)
+			err := r.Step(m)
+			if pm.result != nil {
+				pm.result <- err
+			}
 		case m := <-n.recvc:
 			// filter out response message from unknown From.
 			if _, ok := r.prs[m.From]; ok || !IsResponseMsg(m) {
@@ -309,7 +348,7 @@ func (n *node) run(r *raft) {
 			if cc.NodeID == None {
 				r.resetPendingConf()
 				select {
-				case n.confstatec <- pb.ConfState{Nodes: r.nodes()}:
+				case n.confstatec <- r.confState():
 				case <-n.done:
 				}
 				break
@@ -317,6 +356,8 @@ func (n *node) run(r *raft) {
 			switch cc.Type {
 			case pb.ConfChangeAddNode:
 				r.addNode(cc.NodeID)
+			case pb.ConfChangeAddWitness:
+				r.addWitness(cc.NodeID)
 			case pb.ConfChangeRemoveNode:
 				// block incoming proposal when local node is
 				// removed
@@ -330,7 +371,7 @@ func (n *node) run(r *raft) {
 				panic("unexpected conf type")
 			}
 			select {
-			case n.confstatec <- pb.ConfState{Nodes: r.nodes()}:
+			case n.confstatec <- r.confState():
 			case <-n.done:
 			}
 		case <-n.tickc:
@@ -350,7 +391,9 @@ func (n *node) run(r *raft) {
 			if !IsEmptySnap(rd.Snapshot) {
 				prevSnapi = rd.Snapshot.Metadata.Index
 			}
+			r.reduceUncommittedSize(rd.CommittedEntries)
 			r.msgs = nil
+			r.readStates = nil
 			advancec = n.advancec
 		case <-advancec:
 			if prevHardSt.Commit != 0 {
@@ -384,7 +427,7 @@ func (n *node) Campaign(ctx context.Context) error { return n.step(ctx, pb.Messa
 
 // client-->server的propose表示一次request,Propose 会将data存入到node的channel中
 func (n *node) Propose(ctx context.Context, data []byte) error {
-	return n.step(ctx, pb.Message{Type: pb.MsgProp, Entries: []pb.Entry{{Data: data}}})
+	return n.stepWait(ctx, pb.Message{Type: pb.MsgProp, Entries: []pb.Entry{{Data: data}}})
 }
 
 func (n *node) Step(ctx context.Context, m pb.Message) error {
@@ -401,20 +444,51 @@ func (n *node) ProposeConfChange(ctx context.Context, cc pb.ConfChange) error {
 	if err != nil {
 		return err
 	}
-	return n.Step(ctx, pb.Message{Type: pb.MsgProp, Entries: []pb.Entry{{Type: pb.EntryConfChange, Data: data}}})
+	return n.stepWait(ctx, pb.Message{Type: pb.MsgProp, Entries: []pb.Entry{{Type: pb.EntryConfChange, Data: data}}})
 }
 
 // Step advances the state machine using msgs. The ctx.Err() will be returned,
 // if any.
 func (n *node) step(ctx context.Context, m pb.Message) error {
-	ch := n.recvc
-	if m.Type == pb.MsgProp {
-		ch = n.propc
+	return n.stepWithWaitOption(ctx, m, false)
+}
+
+// stepWait is like step, but for a proposal it also waits until raft has
+// stepped it and returns the error, e.g. ErrProposalDropped, if any.
+func (n *node) stepWait(ctx context.Context, m pb.Message) error {
+	return n.stepWithWaitOption(ctx, m, true)
+}
+
+func (n *node) stepWithWaitOption(ctx context.Context, m pb.Message, wait bool) error {
+	if m.Type != pb.MsgProp {
+		// 将消息写入到raftNode的recvc channel中
+		select {
+		case n.recvc <- m:
+			return nil
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-n.done:
+			return ErrStopped
+		}
+	}
+	pm := msgWithResult{m: m}
+	if wait {
+		pm.result = make(chan error, 1)
 	}
-	// 将消息写入到raftNode的recvc channel或者props channel中
+	// 将消息写入到raftNode的props channel中
 	select {
-	case ch <- m:
-		return nil
+	case n.propc <- pm:
+		if !wait {
+			return nil
+		}
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-n.done:
+		return ErrStopped
+	}
+	select {
+	case err := <-pm.result:
+		return err
 	case <-ctx.Done():
 		return ctx.Err()
 	case <-n.done:
@@ -422,6 +496,10 @@ func (n *node) step(ctx context.Context, m pb.Message) error {
 	}
 }
 
+func (n *node) ReadIndex(ctx context.Context, rctx []byte) error {
+	return n.step(ctx, pb.Message{Type: pb.MsgReadIndex, Entries: []pb.Entry{{Data: rctx}}})
+}
+
 func (n *node) Ready() <-chan Ready { return n.readyc }
 
 func (n *node) Advance() {
@@ -446,8 +524,12 @@ func (n *node) ApplyConfChange(cc pb.ConfChange) *pb.ConfState {
 
 func (n *node) Status() Status {
 	c := make(chan Status)
-	n.status <- c
-	return <-c
+	select {
+	case n.status <- c:
+		return <-c
+	case <-n.done:
+		return Status{}
+	}
 }
 
 func (n *node) ReportUnreachable(id uint64) {
@@ -466,6 +548,22 @@ func (n *node) ReportSnapshot(id uint64, status SnapshotStatus) {
 	}
 }
 
+func (n *node) TransferLeadership(ctx context.Context, lead, transferee uint64) {
+	select {
+	// manually set 'from' and 'to', so that leader can voluntarily transfers its leadership
+	case n.recvc <- pb.Message{Type: pb.MsgTransferLeader, From: transferee, To: lead}:
+	case <-n.done:
+	case <-ctx.Done():
+	}
+}
+
+func (n *node) Unquiesce() {
+	select {
+	case n.recvc <- pb.Message{Type: pb.MsgUnquiesce}:
+	case <-n.done:
+	}
+}
+
 func newReady(r *raft, prevSoftSt *SoftState, prevHardSt pb.HardState) Ready {
 	rd := Ready{
 		Entries:          r.raftLog.unstableEntries(),
@@ -481,5 +579,11 @@ func newReady(r *raft, prevSoftSt *SoftState, prevHardSt pb.HardState) Ready {
 	if r.raftLog.unstable.snapshot != nil {
 		rd.Snapshot = *r.raftLog.unstable.snapshot
 	}
+	if len(r.readStates) != 0 {
+		rd.ReadStates = r.readStates
+	}
+	if r.coalesceMsgs {
+		rd.Messages = coalesceMsgs(rd.Messages, r.maxMsgSize)
+	}
 	return rd
 }
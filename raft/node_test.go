@@ -20,7 +20,6 @@ import (
 	"time"
 
 	"github.com/coreos/etcd/Godeps/_workspace/src/golang.org/x/net/context"
-	"github.com/coreos/etcd/pkg/testutil"
 	"github.com/coreos/etcd/raft/raftpb"
 )
 
@@ -29,7 +28,7 @@ import (
 func TestNodeStep(t *testing.T) {
 	for i, msgn := range raftpb.MessageType_name {
 		n := &node{
-			propc: make(chan raftpb.Message, 1),
+			propc: make(chan msgWithResult, 1),
 			recvc: make(chan raftpb.Message, 1),
 		}
 		msgt := raftpb.MessageType(i)
@@ -42,7 +41,8 @@ func TestNodeStep(t *testing.T) {
 				t.Errorf("%d: cannot receive %s on propc chan", msgt, msgn)
 			}
 		} else {
-			if msgt == raftpb.MsgBeat || msgt == raftpb.MsgHup || msgt == raftpb.MsgUnreachable || msgt == raftpb.MsgSnapStatus {
+			if msgt == raftpb.MsgBeat || msgt == raftpb.MsgHup || msgt == raftpb.MsgUnreachable ||
+				msgt == raftpb.MsgSnapStatus || msgt == raftpb.MsgCheckQuorum || msgt == raftpb.MsgUnquiesce {
 				select {
 				case <-n.recvc:
 					t.Errorf("%d: step should ignore %s", msgt, msgn)
@@ -63,7 +63,7 @@ func TestNodeStep(t *testing.T) {
 func TestNodeStepUnblock(t *testing.T) {
 	// a node without buffer to block step
 	n := &node{
-		propc: make(chan raftpb.Message),
+		propc: make(chan msgWithResult),
 		done:  make(chan struct{}),
 	}
 
@@ -142,6 +142,44 @@ func TestNodePropose(t *testing.T) {
 	}
 }
 
+// TestNodeProposeDropped ensures that node.Propose returns ErrProposalDropped
+// once the leader has too many uncommitted entries.
+func TestNodeProposeDropped(t *testing.T) {
+	msgs := []raftpb.Message{}
+	appendStep := func(r *raft, m raftpb.Message) {
+		msgs = append(msgs, m)
+	}
+
+	n := newNode()
+	s := NewMemoryStorage()
+	c := newTestConfig(1, []uint64{1}, 10, 1, s)
+	c.MaxUncommittedEntriesSize = uint64(len("somedata"))
+	r := newRaft(c)
+	go n.run(r)
+	defer n.Stop()
+	n.Campaign(context.TODO())
+	for {
+		rd := <-n.Ready()
+		s.Append(rd.Entries)
+		// change the step function to appendStep until this raft becomes leader
+		if rd.SoftState.Lead == r.id {
+			r.step = appendStep
+			n.Advance()
+			break
+		}
+		n.Advance()
+	}
+	if err := n.Propose(context.TODO(), []byte("somedata")); err != nil {
+		t.Fatalf("err = %v, want nil", err)
+	}
+	if err := n.Propose(context.TODO(), []byte("somedata")); err != ErrProposalDropped {
+		t.Errorf("err = %v, want %v", err, ErrProposalDropped)
+	}
+	if len(msgs) != 1 {
+		t.Errorf("len(msgs) = %d, want %d", len(msgs), 1)
+	}
+}
+
 // TestNodeProposeConfig ensures that node.ProposeConfChange sends the given configuration proposal
 // to the underlying raft.
 func TestNodeProposeConfig(t *testing.T) {
@@ -185,36 +223,23 @@ func TestNodeProposeConfig(t *testing.T) {
 	}
 }
 
-// TestBlockProposal ensures that node will block proposal when it does not
-// know who is the current leader; node will accept proposal when it knows
-// who is the current leader.
-func TestBlockProposal(t *testing.T) {
+// TestProposalWithoutLeader ensures that node drops a proposal with
+// ErrProposalDropped when it does not know who is the current leader,
+// instead of blocking it; node will accept proposal when it knows who is
+// the current leader.
+func TestProposalWithoutLeader(t *testing.T) {
 	n := newNode()
 	r := newTestRaft(1, []uint64{1}, 10, 1, NewMemoryStorage())
 	go n.run(r)
 	defer n.Stop()
 
-	errc := make(chan error, 1)
-	go func() {
-		errc <- n.Propose(context.TODO(), []byte("somedata"))
-	}()
-
-	testutil.ForceGosched()
-	select {
-	case err := <-errc:
-		t.Errorf("err = %v, want blocking", err)
-	default:
+	if err := n.Propose(context.TODO(), []byte("somedata")); err != ErrProposalDropped {
+		t.Errorf("err = %v, want %v", err, ErrProposalDropped)
 	}
 
 	n.Campaign(context.TODO())
-	testutil.ForceGosched()
-	select {
-	case err := <-errc:
-		if err != nil {
-			t.Errorf("err = %v, want %v", err, nil)
-		}
-	default:
-		t.Errorf("blocking proposal, want unblocking")
+	if err := n.Propose(context.TODO(), []byte("somedata")); err != nil {
+		t.Errorf("err = %v, want %v", err, nil)
 	}
 }
 
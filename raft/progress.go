@@ -69,6 +69,16 @@ type Progress struct {
 
 	PendingSnapshot uint64
 
+	// RecentActive is true if the progress is recently active. Receiving any messages
+	// from the corresponding follower indicates the progress is active.
+	// RecentActive can be reset to false after an election timeout.
+	RecentActive bool
+
+	// IsWitness is true if the follower is a witness: it stores the log and
+	// votes, counting in the quorum, but it keeps no state machine, so it
+	// never gets the data of a snapshot and never becomes the leader.
+	IsWitness bool
+
 	// inflights is a sliding window for the inflight messages.
 	// When inflights is full, no more message should be sent.
 	// When sends out a message, the index of the last entry should
@@ -79,6 +89,16 @@ type Progress struct {
 
 	// inflight消息的滑动窗口
 	ins *inflights
+
+	// catchUpSize is the size of the entries sent to the follower in the
+	// current tick while it is catching up. throttled is true if the leader
+	// has stopped the replication to the follower until the next tick,
+	// since catchUpSize has reached Config.MaxCatchUpSizePerTick.
+	// catchUpTicks is the number of the leader ticks left during which the
+	// follower is paced as a newly added member, even if it is replicated.
+	catchUpSize  uint64
+	throttled    bool
+	catchUpTicks int
 }
 
 func (pr *Progress) resetState(state ProgressStateType) {
@@ -172,6 +192,17 @@ func (pr *Progress) isPaused() bool {
 	}
 }
 
+// IsPaused returns whether the leader has stopped sending replication
+// messages to the follower: a probed follower is waiting for a response, a
+// replicated one has a full inflights window, and a snapshotted one is
+// receiving a snapshot. A follower which is probed or newly added may also be
+// throttled on Config.MaxCatchUpSizePerTick until the next tick.
+func (pr *Progress) IsPaused() bool { return pr.isPaused() || pr.throttled }
+
+// InflightCount returns the number of the replication messages sent to the
+// follower that have not been acknowledged yet.
+func (pr *Progress) InflightCount() int { return pr.ins.count }
+
 func (pr *Progress) snapshotFailure() { pr.PendingSnapshot = 0 }
 
 // maybeSnapshotAbort unsets pendingSnapshot if Match is equal or higher than
@@ -251,6 +282,13 @@ func (in *inflights) freeTo(to uint64) {
 	in.start = idx
 }
 
+// clone returns a copy of the inflights which shares no state with it.
+func (in *inflights) clone() *inflights {
+	ins := *in
+	ins.buffer = append([]uint64(nil), in.buffer...)
+	return &ins
+}
+
 // 释放滑动窗口中的第一个inflight
 func (in *inflights) freeFirstOne() { in.freeTo(in.buffer[in.start]) }
 
@@ -15,6 +15,7 @@
 package raft
 
 import (
+	"bytes"
 	"errors"
 	"fmt"
 	"math"
@@ -29,13 +30,28 @@ import (
 const None uint64 = 0
 const noLimit = math.MaxUint64
 
+// MaxElectionPriority is the highest election priority of a node.
+const MaxElectionPriority = 100
+
+// catchUpElectionTimeouts is the number of election timeouts after the
+// addition of a member during which the replication to it is paced on
+// Config.MaxCatchUpSizePerTick.
+const catchUpElectionTimeouts = 3
+
 var errNoLeader = errors.New("no leader")
 
+// ErrProposalDropped is returned when a proposal is dropped, e.g. because
+// there is no leader, a conf change is pending or the uncommitted log of the
+// leader has grown over Config.MaxUncommittedEntriesSize, so that the
+// proposer can fail fast instead of waiting for the proposal to be committed.
+var ErrProposalDropped = errors.New("raft proposal dropped")
+
 // Possible values for StateType.
 const (
 	StateFollower StateType = iota
 	StateCandidate
 	StateLeader
+	StatePreCandidate
 )
 
 // StateType represents the role of a node in a cluster.
@@ -45,12 +61,29 @@ var stmap = [...]string{
 	"StateFollower",
 	"StateCandidate",
 	"StateLeader",
+	"StatePreCandidate",
 }
 
 func (st StateType) String() string {
 	return stmap[uint64(st)]
 }
 
+// ReadOnlyOption specifies how the leader confirms its leadership before
+// serving a read only request.
+type ReadOnlyOption int
+
+const (
+	// ReadOnlySafe guarantees the linearizability of the read only request by
+	// communicating with the quorum. It is the default and suggested option.
+	ReadOnlySafe ReadOnlyOption = iota
+	// ReadOnlyLeaseBased ensures linearizability of the read only request by
+	// relying on the leader lease. It can be affected by clock drift.
+	// If the clock drift is unbounded, leader might keep the lease longer than it
+	// should (clock can move backward/pause without any bound). ReadIndex is not safe
+	// in that case.
+	ReadOnlyLeaseBased
+)
+
 // Config contains the parameters to start a raft.
 type Config struct {
 	// ID is the identity of the local raft. ID cannot be 0.
@@ -97,6 +130,78 @@ type Config struct {
 	// buffer over TCP/UDP. Setting MaxInflightMsgs to avoid overflowing that sending buffer.
 	// TODO (xiangli): feedback to application to limit the proposal rate?
 	MaxInflightMsgs int
+	// MaxUncommittedEntriesSize limits the aggregate byte size of the payloads
+	// of the uncommitted entries appended to the leader's log. Once the limit
+	// is exceeded, the leader drops new proposals with ErrProposalDropped
+	// until some of the entries are committed. A proposal is always accepted
+	// when there is nothing uncommitted, so a single large entry can still be
+	// proposed. Note: 0 for no limit.
+	MaxUncommittedEntriesSize uint64
+	// MaxCatchUpSizePerTick limits the aggregate byte size of the entries
+	// sent per tick to a follower which is catching up, i.e. whose match
+	// index is behind the commit index while the leader is probing it, or
+	// while it has been added within the last few election timeouts. A
+	// follower which lags behind in the normal replication is never
+	// throttled. Once the limit is reached, the replication to the follower waits for the next
+	// tick, so that the catch-up does not saturate the network and
+	// destabilize the cluster. The snapshot is sent by the application, which
+	// should pace it by itself. Note: 0 for no limit.
+	MaxCatchUpSizePerTick uint64
+
+	// CoalesceMsgs coalesces the messages of a Ready per follower: the
+	// MsgApps which continue each other are merged into one, up to
+	// MaxSizePerMsg, carrying the latest commit index, and a heartbeat to a
+	// follower which gets a MsgApp in the same Ready is dropped, since the
+	// MsgApp carries the commit index as well. It reduces the number of
+	// messages when the proposals come in faster than the Ready is handled.
+	CoalesceMsgs bool
+
+	// PreVote enables the Pre-Vote algorithm described in raft thesis section
+	// 9.6. This prevents disruption when a node that has been partitioned away
+	// rejoins the cluster: before increasing its term and starting a real
+	// election, the node first asks the others whether it could win one.
+	PreVote bool
+
+	// Priority is the election priority of the node, from 1, the lowest, to
+	// MaxElectionPriority, the highest. A node of a lower priority waits
+	// proportionally longer before campaigning, up to about another election
+	// timeout for the lowest, so that, all else being equal, the preferred
+	// members, e.g. those in the primary datacenter, win the elections. It
+	// does not delay a campaign for a leader transfer.
+	// Note: 0 for MaxElectionPriority.
+	Priority int
+
+	// CheckQuorum specifies if the leader should check quorum activity. Leader
+	// steps down when quorum is not active for an electionTimeout.
+	CheckQuorum bool
+
+	// Quiesce allows an idle leader to quiesce the raft group: once all the
+	// entries are committed and every follower has them, the leader sends a
+	// last MsgQuiesce instead of a heartbeat and stops sending heartbeats, and
+	// the followers stop their election timers. Any proposal or message wakes
+	// the group up. It saves the heartbeats of the mostly idle groups when a
+	// node hosts many of them.
+	// A quiesced follower cannot notice the failure of its leader, so the
+	// application must wake it up with Unquiesce when it suspects the leader,
+	// e.g. when the transport reports it unreachable.
+	Quiesce bool
+
+	// ReadOnlyOption specifies how the read only request is processed.
+	//
+	// ReadOnlySafe guarantees the linearizability of the read only request by
+	// communicating with the quorum. It is the default and suggested option.
+	//
+	// ReadOnlyLeaseBased ensures linearizability of the read only request by
+	// relying on the leader lease: the leader answers at once, without a round
+	// of heartbeats, as long as CheckQuorum has not stepped it down. It assumes
+	// the clocks of the members run at about the same rate, and it requires
+	// CheckQuorum to be true.
+	ReadOnlyOption ReadOnlyOption
+
+	// Logger is the logger used for raft log. For multinode which can host
+	// multiple raft group, each raft group can have its own logger.
+	// If it is nil, the package logger set by SetLogger is used.
+	Logger Logger
 }
 
 func (c *Config) validate() error {
@@ -120,6 +225,14 @@ func (c *Config) validate() error {
 		return errors.New("max inflight messages must be greater than 0")
 	}
 
+	if c.Priority < 0 || c.Priority > MaxElectionPriority {
+		return fmt.Errorf("priority must be in [0, %d]", MaxElectionPriority)
+	}
+
+	if c.ReadOnlyOption == ReadOnlyLeaseBased && !c.CheckQuorum {
+		return errors.New("CheckQuorum must be enabled when ReadOnlyOption is ReadOnlyLeaseBased")
+	}
+
 	return nil
 }
 
@@ -133,31 +246,60 @@ type raft struct {
 
 	maxInflight int
 	maxMsgSize  uint64
+	// uncommittedSize是leader上未提交的entry的payload总大小
+	maxUncommittedSize uint64
+	uncommittedSize    uint64
+	coalesceMsgs       bool
+	// maxCatchUpSize是每个tick向追赶中的follower发送的entry总大小上限
+	maxCatchUpSize uint64
 	// Progress表示follower的进展，progress的个数表示follower的数量。
 	prs map[uint64]*Progress
 
-	//表示raft的三种角色
+	//表示raft的四种角色
 	state StateType
 	//表示follower的投票key:follower id,value:是否投票
 	votes map[uint64]bool
 	// msgs保存所有需要发送的消息
 	msgs []pb.Message
 
+	// readStates保存已经确认可以读取的read index
+	readStates []ReadState
+	readOnly   *readOnly
+
 	// Leader的ID
 	lead uint64
 
+	preVote     bool
+	checkQuorum bool
+
+	quiesce bool
+	// quiesced表示raft group处于静默状态，leader不发送heartbeat，follower不进行选举计时
+	quiesced bool
+
+	// leadTransferee is id of the leader transfer target when its value is not zero.
+	// Follow the procedure defined in raft thesis 3.10.
+	leadTransferee uint64
+	// number of ticks since the leader started to transfer its leadership.
+	// The transfer is aborted if it does not finish within an election timeout.
+	transferElapsed int
+
 	// New configuration is ignored if there exists unapplied configuration.
 	// 是否挂起新的配置
 	pendingConf bool
 
 	//上一次消息传输过后流逝的时间
 	elapsed          int // number of ticks since the last msg
+	electionElapsed  int // number of ticks since the leader last checked the quorum
 	heartbeatTimeout int
 	electionTimeout  int
-	rand             *rand.Rand
-	tick             func()
+	// electionDelay是低优先级的节点在选举超时后额外等待的tick数
+	electionDelay int
+	rand          *rand.Rand
+	tick          func()
 	//表示raft状态转变过程中的消息处理函数
 	step stepFunc
+
+	logger Logger
 }
 
 //根据配置信息新建一个raft
@@ -165,7 +307,10 @@ func newRaft(c *Config) *raft {
 	if err := c.validate(); err != nil {
 		panic(err.Error())
 	}
-	raftlog := newLog(c.Storage)
+	if c.Logger == nil {
+		c.Logger = raftLogger
+	}
+	raftlog := newLog(c.Storage, c.Logger)
 	hs, cs, err := c.Storage.InitialState()
 	if err != nil {
 		panic(err) // TODO(bdarnell)
@@ -187,11 +332,20 @@ func newRaft(c *Config) *raft {
 		// 4MB for now and hard code it
 		// TODO(xiang): add a config arguement into newRaft after we add
 		// the max inflight message field.
-		maxMsgSize:       c.MaxSizePerMsg,
-		maxInflight:      c.MaxInflightMsgs,
-		prs:              make(map[uint64]*Progress),
-		electionTimeout:  c.ElectionTick,
-		heartbeatTimeout: c.HeartbeatTick,
+		maxMsgSize:         c.MaxSizePerMsg,
+		maxInflight:        c.MaxInflightMsgs,
+		maxUncommittedSize: c.MaxUncommittedEntriesSize,
+		coalesceMsgs:       c.CoalesceMsgs,
+		maxCatchUpSize:     c.MaxCatchUpSizePerTick,
+		prs:                make(map[uint64]*Progress),
+		preVote:            c.PreVote,
+		checkQuorum:        c.CheckQuorum,
+		quiesce:            c.Quiesce,
+		readOnly:           newReadOnly(c.ReadOnlyOption),
+		electionTimeout:    c.ElectionTick,
+		heartbeatTimeout:   c.HeartbeatTick,
+		electionDelay:      electionDelay(c.ElectionTick, c.Priority),
+		logger:             c.Logger,
 	}
 	r.rand = rand.New(rand.NewSource(int64(c.ID)))
 	for _, p := range peers {
@@ -210,7 +364,7 @@ func newRaft(c *Config) *raft {
 		nodesStrs = append(nodesStrs, fmt.Sprintf("%x", n))
 	}
 
-	raftLogger.Infof("raft: newRaft %x [peers: [%s], term: %d, commit: %d, applied: %d, lastindex: %d, lastterm: %d]",
+	r.logger.Infof("raft: newRaft %x [peers: [%s], term: %d, commit: %d, applied: %d, lastindex: %d, lastterm: %d]",
 		r.id, strings.Join(nodesStrs, ","), r.Term, r.raftLog.committed, r.raftLog.applied, r.raftLog.lastIndex(), r.raftLog.lastTerm())
 	return r
 }
@@ -231,13 +385,34 @@ func (r *raft) nodes() []uint64 {
 	return nodes
 }
 
+// witnesses returns the ids of the witnesses among the nodes.
+func (r *raft) witnesses() []uint64 {
+	var witnesses []uint64
+	for k, pr := range r.prs {
+		if pr.IsWitness {
+			witnesses = append(witnesses, k)
+		}
+	}
+	sort.Sort(uint64Slice(witnesses))
+	return witnesses
+}
+
+func (r *raft) confState() pb.ConfState {
+	return pb.ConfState{Nodes: r.nodes(), Witnesses: r.witnesses()}
+}
+
 // send persists state to stable storage and then sends to its mailbox.
 func (r *raft) send(m pb.Message) {
-	m.From = r.id
+	// forwarded messages (e.g. MsgTransferLeader) keep their original sender.
+	if m.From == None {
+		m.From = r.id
+	}
 	// do not attach term to MsgProp
 	// proposals are a way to forward to the leader and
 	// should be treated as local message.
-	if m.Type != pb.MsgProp {
+	// pre-vote requests and responses carry the term set by the caller,
+	// which may differ from the local term.
+	if m.Type != pb.MsgProp && m.Type != pb.MsgPreVote && m.Type != pb.MsgPreVoteResp {
 		m.Term = r.Term
 	}
 	r.msgs = append(r.msgs, m)
@@ -260,17 +435,35 @@ func (r *raft) sendAppend(to uint64) {
 		if IsEmptySnap(snapshot) {
 			panic("need non-empty snapshot")
 		}
+		// witness没有状态机，只发送snapshot的元数据
+		if pr.IsWitness {
+			snapshot.Data = nil
+		}
 		m.Snapshot = snapshot
 		sindex, sterm := snapshot.Metadata.Index, snapshot.Metadata.Term
-		raftLogger.Infof("raft: %x [firstindex: %d, commit: %d] sent snapshot[index: %d, term: %d] to %x [%s]",
+		r.logger.Infof("raft: %x [firstindex: %d, commit: %d] sent snapshot[index: %d, term: %d] to %x [%s]",
 			r.id, r.raftLog.firstIndex(), r.Commit, sindex, sterm, to, pr)
 		pr.becomeSnapshot(sindex)
-		raftLogger.Infof("raft: %x paused sending replication messages to %x [%s]", r.id, to, pr)
+		r.logger.Infof("raft: %x paused sending replication messages to %x [%s]", r.id, to, pr)
 	} else {
+		maxSize := r.maxMsgSize
+		catchingUp := r.maxCatchUpSize > 0 && pr.Match < r.raftLog.committed &&
+			(pr.State != ProgressStateReplicate || pr.catchUpTicks > 0)
+		if catchingUp {
+			// 追赶中的follower在当前tick已用完额度，等待下一个tick再发送
+			if pr.catchUpSize >= r.maxCatchUpSize {
+				pr.throttled = true
+				return
+			}
+			maxSize = min(maxSize, r.maxCatchUpSize-pr.catchUpSize)
+		}
 		m.Type = pb.MsgApp
 		m.Index = pr.Next - 1
 		m.LogTerm = r.raftLog.term(pr.Next - 1)
-		m.Entries = r.raftLog.entries(pr.Next, r.maxMsgSize)
+		m.Entries = r.raftLog.entries(pr.Next, maxSize)
+		if catchingUp {
+			pr.catchUpSize += entsSize(m.Entries)
+		}
 		m.Commit = r.raftLog.committed
 		if n := len(m.Entries); n != 0 {
 			switch pr.State {
@@ -282,7 +475,7 @@ func (r *raft) sendAppend(to uint64) {
 			case ProgressStateProbe:
 				pr.pause()
 			default:
-				raftLogger.Panicf("raft: %x is sending append in unhandled state %s", r.id, pr.State)
+				r.logger.Panicf("raft: %x is sending append in unhandled state %s", r.id, pr.State)
 			}
 		}
 	}
@@ -290,7 +483,7 @@ func (r *raft) sendAppend(to uint64) {
 }
 
 // sendHeartbeat sends an empty MsgApp to follower i
-func (r *raft) sendHeartbeat(to uint64) {
+func (r *raft) sendHeartbeat(to uint64, ctx []byte) {
 	// Attach the commit as min(to.matched, r.committed).
 	// When the leader sends out heartbeat message,
 	// the receiver(follower) might not be matched with the leader
@@ -299,9 +492,10 @@ func (r *raft) sendHeartbeat(to uint64) {
 	// an unmatched index.
 	commit := min(r.prs[to].Match, r.raftLog.committed)
 	m := pb.Message{
-		To:     to,
-		Type:   pb.MsgHeartbeat,
-		Commit: commit,
+		To:      to,
+		Type:    pb.MsgHeartbeat,
+		Commit:  commit,
+		Context: ctx,
 	}
 	r.send(m)
 }
@@ -319,12 +513,23 @@ func (r *raft) bcastAppend() {
 }
 
 // bcastHeartbeat sends RRPC, without entries to all the peers.
+// The heartbeats carry the context of the last pending read only request,
+// so that their acknowledgements also confirm it.
 func (r *raft) bcastHeartbeat() {
+	lastCtx := r.readOnly.lastPendingRequestCtx()
+	if len(lastCtx) == 0 {
+		r.bcastHeartbeatWithCtx(nil)
+	} else {
+		r.bcastHeartbeatWithCtx([]byte(lastCtx))
+	}
+}
+
+func (r *raft) bcastHeartbeatWithCtx(ctx []byte) {
 	for i := range r.prs {
 		if i == r.id {
 			continue
 		}
-		r.sendHeartbeat(i)
+		r.sendHeartbeat(i, ctx)
 		r.prs[i].resume()
 	}
 }
@@ -347,14 +552,19 @@ func (r *raft) reset(term uint64) {
 	}
 	r.lead = None
 	r.elapsed = 0
+	r.electionElapsed = 0
+	r.abortLeaderTransfer()
 	r.votes = make(map[uint64]bool)
-	for i := range r.prs {
-		r.prs[i] = &Progress{Next: r.raftLog.lastIndex() + 1, ins: newInflights(r.maxInflight)}
+	for i, pr := range r.prs {
+		r.prs[i] = &Progress{Next: r.raftLog.lastIndex() + 1, IsWitness: pr.IsWitness, catchUpTicks: pr.catchUpTicks, ins: newInflights(r.maxInflight)}
 		if i == r.id {
 			r.prs[i].Match = r.raftLog.lastIndex()
 		}
 	}
 	r.pendingConf = false
+	r.quiesced = false
+	r.uncommittedSize = 0
+	r.readOnly = newReadOnly(r.readOnly.option)
 }
 
 func (r *raft) appendEntry(es ...pb.Entry) {
@@ -374,6 +584,10 @@ func (r *raft) tickElection() {
 		r.elapsed = 0
 		return
 	}
+	// the quiesced follower waits for the leader or the application to wake it up.
+	if r.quiesced {
+		return
+	}
 	r.elapsed++
 	if r.isElectionTimeout() {
 		r.elapsed = 0
@@ -383,20 +597,68 @@ func (r *raft) tickElection() {
 
 // tickHeartbeat is run by leaders to send a MsgBeat after r.heartbeatTimeout.
 func (r *raft) tickHeartbeat() {
+	// the quiesced leader neither sends heartbeats nor checks the quorum,
+	// since the followers do not respond.
+	if r.quiesced {
+		return
+	}
 	r.elapsed++
+	r.electionElapsed++
+
+	if r.electionElapsed >= r.electionTimeout {
+		r.electionElapsed = 0
+		if r.checkQuorum {
+			r.Step(pb.Message{From: r.id, Type: pb.MsgCheckQuorum})
+		}
+	}
+
+	// the leader may have stepped down on the quorum check.
+	if r.state != StateLeader {
+		return
+	}
+
+	if r.leadTransferee != None {
+		r.transferElapsed++
+		if r.transferElapsed >= r.electionTimeout {
+			r.logger.Infof("raft: %x [term %d] abort transferring leadership to %x after %d ticks",
+				r.id, r.Term, r.leadTransferee, r.transferElapsed)
+			r.abortLeaderTransfer()
+		}
+	}
+
+	if r.maxCatchUpSize > 0 {
+		r.resumeCatchUp()
+	}
+
 	if r.elapsed >= r.heartbeatTimeout {
 		r.elapsed = 0
 		r.Step(pb.Message{From: r.id, Type: pb.MsgBeat})
 	}
 }
 
+// resumeCatchUp renews the catch-up budget of every follower for the new
+// tick, and resumes the replication to the followers which have been
+// throttled on it.
+func (r *raft) resumeCatchUp() {
+	for id, pr := range r.prs {
+		pr.catchUpSize = 0
+		if pr.catchUpTicks > 0 {
+			pr.catchUpTicks--
+		}
+		if pr.throttled {
+			pr.throttled = false
+			r.sendAppend(id)
+		}
+	}
+}
+
 func (r *raft) becomeFollower(term uint64, lead uint64) {
 	r.step = stepFollower
 	r.reset(term)
 	r.tick = r.tickElection
 	r.lead = lead
 	r.state = StateFollower
-	raftLogger.Infof("raft: %x became follower at term %d", r.id, r.Term)
+	r.logger.Infof("raft: %x became follower at term %d", r.id, r.Term)
 }
 
 func (r *raft) becomeCandidate() {
@@ -409,7 +671,23 @@ func (r *raft) becomeCandidate() {
 	r.tick = r.tickElection
 	r.Vote = r.id
 	r.state = StateCandidate
-	raftLogger.Infof("raft: %x became candidate at term %d", r.id, r.Term)
+	r.logger.Infof("raft: %x became candidate at term %d", r.id, r.Term)
+}
+
+func (r *raft) becomePreCandidate() {
+	// TODO(xiangli) remove the panic when the raft implementation is stable
+	if r.state == StateLeader {
+		panic("invalid transition [leader -> pre-candidate]")
+	}
+	// Becoming a pre-candidate changes our step functions and state,
+	// but doesn't change anything else. In particular it does not increase
+	// r.Term or change r.Vote.
+	r.step = stepCandidate
+	r.votes = make(map[uint64]bool)
+	r.tick = r.tickElection
+	r.lead = None
+	r.state = StatePreCandidate
+	r.logger.Infof("raft: %x became pre-candidate at term %d", r.id, r.Term)
 }
 
 func (r *raft) becomeLeader() {
@@ -432,35 +710,71 @@ func (r *raft) becomeLeader() {
 		r.pendingConf = true
 	}
 	r.appendEntry(pb.Entry{Data: nil})
-	raftLogger.Infof("raft: %x became leader at term %d", r.id, r.Term)
+	r.logger.Infof("raft: %x became leader at term %d", r.id, r.Term)
 }
 
+// CampaignType represents the type of campaigning.
+type CampaignType string
+
+const (
+	// campaignPreElection represents the first phase of a normal election when
+	// Config.PreVote is true.
+	campaignPreElection CampaignType = "CampaignPreElection"
+	// campaignElection represents a normal (time-based) election (the second phase
+	// of the election when Config.PreVote is true).
+	campaignElection CampaignType = "CampaignElection"
+	// campaignTransfer represents the type of leader transfer. The transferee
+	// starts it on MsgTimeoutNow, and its vote requests are granted even by
+	// the members which have heard from the current leader recently.
+	campaignTransfer CampaignType = "CampaignTransfer"
+)
+
 // 竞选leader，设置自身角色为candidate并为自己投票，向所有其它follower发送投票消息
 //当投票数等于N/2+1（N为server个数）时，升级为leader。
-func (r *raft) campaign() {
-	r.becomeCandidate()
+func (r *raft) campaign(t CampaignType) {
+	var term uint64
+	var voteMsg pb.MessageType
+	if t == campaignPreElection {
+		r.becomePreCandidate()
+		voteMsg = pb.MsgPreVote
+		// PreVote RPCs are sent for the next term before we've incremented r.Term.
+		term = r.Term + 1
+	} else {
+		r.becomeCandidate()
+		voteMsg = pb.MsgVote
+		term = r.Term
+	}
+	var ctx []byte
+	if t == campaignTransfer {
+		ctx = []byte(t)
+	}
 	// 如果只有一个node，自己给自己投票，占大多数票，自己变为leader
-	if r.q() == r.poll(r.id, true) {
-		r.becomeLeader()
+	if r.q() == r.poll(r.id, voteRespMsgType(voteMsg), true) {
+		// We won the pre-vote (or the election) with our own vote only.
+		if t == campaignPreElection {
+			r.campaign(campaignElection)
+		} else {
+			r.becomeLeader()
+		}
 		return
 	}
 	for i := range r.prs {
 		if i == r.id {
 			continue
 		}
-		raftLogger.Infof("raft: %x [logterm: %d, index: %d] sent vote request to %x at term %d",
-			r.id, r.raftLog.lastTerm(), r.raftLog.lastIndex(), i, r.Term)
-		r.send(pb.Message{To: i, Type: pb.MsgVote, Index: r.raftLog.lastIndex(), LogTerm: r.raftLog.lastTerm()})
+		r.logger.Infof("raft: %x [logterm: %d, index: %d] sent %s request to %x at term %d",
+			r.id, r.raftLog.lastTerm(), r.raftLog.lastIndex(), voteMsg, i, r.Term)
+		r.send(pb.Message{Term: term, To: i, Type: voteMsg, Index: r.raftLog.lastIndex(), LogTerm: r.raftLog.lastTerm(), Context: ctx})
 	}
 }
 
 //赞成票，id为follower的id，
 //v--->true：赞成票，false:反对票
-func (r *raft) poll(id uint64, v bool) (granted int) {
+func (r *raft) poll(id uint64, t pb.MessageType, v bool) (granted int) {
 	if v {
-		raftLogger.Infof("raft: %x received vote from %x at term %d", r.id, id, r.Term)
+		r.logger.Infof("raft: %x received %s from %x at term %d", r.id, t, id, r.Term)
 	} else {
-		raftLogger.Infof("raft: %x received vote rejection from %x at term %d", r.id, id, r.Term)
+		r.logger.Infof("raft: %x received %s rejection from %x at term %d", r.id, t, id, r.Term)
 	}
 	if _, ok := r.votes[id]; !ok {
 		r.votes[id] = v
@@ -477,37 +791,131 @@ func (r *raft) poll(id uint64, v bool) (granted int) {
 func (r *raft) Step(m pb.Message) error {
 	// 开启一轮新的选举
 	if m.Type == pb.MsgHup {
-		raftLogger.Infof("raft: %x is starting a new election at term %d", r.id, r.Term)
-		r.campaign()
+		if pr := r.prs[r.id]; pr != nil && pr.IsWitness {
+			r.logger.Infof("raft: %x is a witness and ignored MsgHup at term %d", r.id, r.Term)
+			return nil
+		}
+		r.logger.Infof("raft: %x is starting a new election at term %d", r.id, r.Term)
+		if r.preVote {
+			r.campaign(campaignPreElection)
+		} else {
+			r.campaign(campaignElection)
+		}
 		r.Commit = r.raftLog.committed
 		return nil
 	}
+	if m.Type == pb.MsgUnquiesce {
+		r.unquiesce()
+		return nil
+	}
 
 	switch {
 	case m.Term == 0:
 		// local message
 	// 处理来自term比自己大的消息,重置自己的term为m.term
 	case m.Term > r.Term:
+		if m.Type == pb.MsgVote || m.Type == pb.MsgPreVote {
+			force := bytes.Equal(m.Context, []byte(campaignTransfer))
+			if !force && r.inLease() {
+				// If a server receives a RequestVote request within the minimum election timeout
+				// of hearing from a current leader, it does not update its term or grant its vote
+				// (raft thesis 4.2.3). The leader transfer is the exception, since the current
+				// leader asked for it.
+				r.logger.Infof("raft: %x [logterm: %d, index: %d, vote: %x] ignored %s from %x [logterm: %d, index: %d] at term %d: lease is not expired (remaining ticks: %d)",
+					r.id, r.raftLog.lastTerm(), r.raftLog.lastIndex(), r.Vote, m.Type, m.From, m.LogTerm, m.Index, r.Term, r.electionTimeout-r.leaseElapsed())
+				return nil
+			}
+		}
 		lead := m.From
 		// 如果是投票消息，先设置leader为None，在选举的时候会选出leader
-		if m.Type == pb.MsgVote {
+		if m.Type == pb.MsgVote || m.Type == pb.MsgPreVote {
 			lead = None
 		}
-		raftLogger.Infof("raft: %x [term: %d] received a %s message with higher term from %x [term: %d]",
-			r.id, r.Term, m.Type, m.From, m.Term)
-		r.becomeFollower(m.Term, lead)
+		switch {
+		case m.Type == pb.MsgPreVote:
+			// Never change our term in response to a PreVote
+		case m.Type == pb.MsgPreVoteResp && !m.Reject:
+			// We send pre-vote requests with a term in our future. If the
+			// pre-vote is granted, we will increment our term when we get a
+			// quorum. If it is not, the term comes from the node that
+			// rejected our vote so we should become a follower at the new
+			// term.
+		default:
+			r.logger.Infof("raft: %x [term: %d] received a %s message with higher term from %x [term: %d]",
+				r.id, r.Term, m.Type, m.From, m.Term)
+			r.becomeFollower(m.Term, lead)
+		}
 	// 拒绝来自term比自己小的消息,直接返回nil
 	case m.Term < r.Term:
+		if m.Type == pb.MsgPreVote {
+			// Before Pre-Vote enable, there may have candidate with higher term,
+			// but less log. After update to Pre-Vote, the cluster may deadlock if
+			// we drop messages with a lower term.
+			r.logger.Infof("raft: %x [logterm: %d, index: %d, vote: %x] rejected %s from %x [logterm: %d, index: %d] at term %d",
+				r.id, r.raftLog.lastTerm(), r.raftLog.lastIndex(), r.Vote, m.Type, m.From, m.LogTerm, m.Index, r.Term)
+			r.send(pb.Message{To: m.From, Term: r.Term, Type: pb.MsgPreVoteResp, Reject: true})
+			return nil
+		}
 		// ignore
-		raftLogger.Infof("raft: %x [term: %d] ignored a %s message with lower term from %x [term: %d]",
+		r.logger.Infof("raft: %x [term: %d] ignored a %s message with lower term from %x [term: %d]",
 			r.id, r.Term, m.Type, m.From, m.Term)
 		return nil
 	}
+	if m.Type == pb.MsgProp && r.dropProposal(m) {
+		return ErrProposalDropped
+	}
+	// The leader refuses the proposal here rather than in stepLeader, so that
+	// the proposer learns that it has been dropped.
+	if m.Type == pb.MsgProp && r.state == StateLeader && !r.increaseUncommittedSize(m.Entries) {
+		r.logger.Debugf("raft: %x appending new entries to log would exceed uncommitted entry size limit; dropping proposal", r.id)
+		return ErrProposalDropped
+	}
+	// any message other than MsgQuiesce wakes the quiesced group up.
+	if r.quiesced && m.Type != pb.MsgQuiesce {
+		r.wake()
+	}
 	r.step(r, m)
 	r.Commit = r.raftLog.committed
 	return nil
 }
 
+// dropProposal reports whether the proposal m is dropped: when there is
+// no leader to forward it to, when the leadership is being transferred,
+// or when it carries a conf change while another one is pending, or more
+// than one conf change.
+func (r *raft) dropProposal(m pb.Message) bool {
+	switch r.state {
+	case StateLeader:
+		if r.leadTransferee != None {
+			r.logger.Infof("raft: %x [term %d] transfer leadership to %x is in progress; dropping proposal",
+				r.id, r.Term, r.leadTransferee)
+			return true
+		}
+		// at most one conf change can be in the process of going through
+		// consensus
+		n := 0
+		for _, e := range m.Entries {
+			if e.Type == pb.EntryConfChange {
+				n++
+			}
+		}
+		if (r.pendingConf && n > 0) || n > 1 {
+			r.logger.Infof("raft: %x [term %d] conf change is pending; dropping proposal", r.id, r.Term)
+			return true
+		}
+		return false
+	case StateFollower:
+		if r.lead == None {
+			r.logger.Infof("raft: %x no leader at term %d; dropping proposal", r.id, r.Term)
+			return true
+		}
+		return false
+	default:
+		r.logger.Infof("raft: %x no leader at term %d; dropping proposal", r.id, r.Term)
+		return true
+	}
+}
+
 type stepFunc func(r *raft, m pb.Message)
 
 func stepLeader(r *raft, m pb.Message) {
@@ -515,27 +923,45 @@ func stepLeader(r *raft, m pb.Message) {
 
 	switch m.Type {
 	case pb.MsgBeat:
+		if r.quiesce && r.isIdle() {
+			r.bcastQuiesce()
+			return
+		}
 		r.bcastHeartbeat()
+	case pb.MsgCheckQuorum:
+		if !r.checkQuorumActive() {
+			r.logger.Warningf("raft: %x stepped down to follower since quorum is not active", r.id)
+			r.becomeFollower(r.Term, None)
+		}
 	case pb.MsgProp:
 		if len(m.Entries) == 0 {
-			raftLogger.Panicf("raft: %x stepped empty MsgProp", r.id)
+			r.logger.Panicf("raft: %x stepped empty MsgProp", r.id)
 		}
-		for i, e := range m.Entries {
+		// the proposals dropped by dropProposal never get here
+		for _, e := range m.Entries {
 			if e.Type == pb.EntryConfChange {
-				if r.pendingConf {
-					m.Entries[i] = pb.Entry{Type: pb.EntryNormal}
-				}
 				r.pendingConf = true
 			}
 		}
 		r.appendEntry(m.Entries...)
 		r.bcastAppend()
 	case pb.MsgAppResp:
+		pr.RecentActive = true
+
 		if m.Reject {
-			raftLogger.Infof("raft: %x received msgApp rejection(lastindex: %d) from %x for index %d",
-				r.id, m.RejectHint, m.From, m.Index)
-			if pr.maybeDecrTo(m.Index, m.RejectHint) {
-				raftLogger.Infof("raft: %x decreased progress of %x to [%s]", r.id, m.From, pr)
+			r.logger.Infof("raft: %x received msgApp rejection(hint: %d, hint term: %d) from %x for index %d",
+				r.id, m.RejectHint, m.LogTerm, m.From, m.Index)
+			// The follower has no entry after RejectHint that matches the log
+			// of the leader, and the entry at RejectHint has term LogTerm. All
+			// the entries of the leader in (LogTerm, m.Index] conflict with it
+			// as well, so the next probe can skip them in one go. A follower
+			// which sends no hint term falls back to RejectHint.
+			nextProbeIdx := m.RejectHint
+			if m.LogTerm > 0 {
+				nextProbeIdx = r.raftLog.findConflictByTerm(m.RejectHint, m.LogTerm)
+			}
+			if pr.maybeDecrTo(m.Index, nextProbeIdx) {
+				r.logger.Infof("raft: %x decreased progress of %x to [%s]", r.id, m.From, pr)
 				if pr.State == ProgressStateReplicate {
 					pr.becomeProbe()
 				}
@@ -548,7 +974,7 @@ func stepLeader(r *raft, m pb.Message) {
 				case pr.State == ProgressStateProbe:
 					pr.becomeReplicate()
 				case pr.State == ProgressStateSnapshot && pr.maybeSnapshotAbort():
-					raftLogger.Infof("raft: %x snapshot aborted, resumed sending replication messages to %x [%s]", r.id, m.From, pr)
+					r.logger.Infof("raft: %x snapshot aborted, resumed sending replication messages to %x [%s]", r.id, m.From, pr)
 					pr.becomeProbe()
 				case pr.State == ProgressStateReplicate:
 					pr.ins.freeTo(m.Index)
@@ -561,9 +987,16 @@ func stepLeader(r *raft, m pb.Message) {
 					// an update before, send it now.
 					r.sendAppend(m.From)
 				}
+				// Transfer leadership is in progress.
+				if m.From == r.leadTransferee && pr.Match == r.raftLog.lastIndex() {
+					r.logger.Infof("raft: %x sent MsgTimeoutNow to %x after received MsgAppResp", r.id, m.From)
+					r.sendTimeoutNow(m.From)
+				}
 			}
 		}
 	case pb.MsgHeartbeatResp:
+		pr.RecentActive = true
+
 		// free one slot for the full inflights window to allow progress.
 		if pr.State == ProgressStateReplicate && pr.ins.full() {
 			pr.ins.freeFirstOne()
@@ -571,21 +1004,51 @@ func stepLeader(r *raft, m pb.Message) {
 		if pr.Match < r.raftLog.lastIndex() {
 			r.sendAppend(m.From)
 		}
-	case pb.MsgVote:
-		raftLogger.Infof("raft: %x [logterm: %d, index: %d, vote: %x] rejected vote from %x [logterm: %d, index: %d] at term %d",
-			r.id, r.raftLog.lastTerm(), r.raftLog.lastIndex(), r.Vote, m.From, m.LogTerm, m.Index, r.Term)
-		r.send(pb.Message{To: m.From, Type: pb.MsgVoteResp, Reject: true})
+
+		if r.readOnly.option != ReadOnlySafe || len(m.Context) == 0 {
+			return
+		}
+		if r.readOnly.recvAck(m) < r.q() {
+			return
+		}
+		for _, rs := range r.readOnly.advance(m) {
+			r.respondReadIndex(rs.req, rs.index)
+		}
+	case pb.MsgReadIndex:
+		if len(r.prs) == 1 {
+			r.respondReadIndex(m, r.raftLog.committed)
+			return
+		}
+		// The leader does not know the commit index of the previous terms
+		// until it has committed an entry of its own term.
+		if r.raftLog.term(r.raftLog.committed) != r.Term {
+			r.logger.Infof("raft: %x has not committed an entry in term %d; dropping read index request", r.id, r.Term)
+			return
+		}
+		switch r.readOnly.option {
+		case ReadOnlySafe:
+			r.readOnly.addRequest(r.raftLog.committed, m)
+			r.bcastHeartbeatWithCtx(m.Entries[0].Data)
+		case ReadOnlyLeaseBased:
+			// CheckQuorum steps the leader down once it loses the quorum, so
+			// within an election timeout it is still the only leader.
+			r.respondReadIndex(m, r.raftLog.committed)
+		}
+	case pb.MsgVote, pb.MsgPreVote:
+		r.logger.Infof("raft: %x [logterm: %d, index: %d, vote: %x] rejected %s from %x [logterm: %d, index: %d] at term %d",
+			r.id, r.raftLog.lastTerm(), r.raftLog.lastIndex(), r.Vote, m.Type, m.From, m.LogTerm, m.Index, r.Term)
+		r.send(pb.Message{To: m.From, Term: r.Term, Type: voteRespMsgType(m.Type), Reject: true})
 	case pb.MsgSnapStatus:
 		if pr.State != ProgressStateSnapshot {
 			return
 		}
 		if !m.Reject {
 			pr.becomeProbe()
-			raftLogger.Infof("raft: %x snapshot succeeded, resumed sending replication messages to %x [%s]", r.id, m.From, pr)
+			r.logger.Infof("raft: %x snapshot succeeded, resumed sending replication messages to %x [%s]", r.id, m.From, pr)
 		} else {
 			pr.snapshotFailure()
 			pr.becomeProbe()
-			raftLogger.Infof("raft: %x snapshot failed, resumed sending replication messages to %x [%s]", r.id, m.From, pr)
+			r.logger.Infof("raft: %x snapshot failed, resumed sending replication messages to %x [%s]", r.id, m.From, pr)
 		}
 		// If snapshot finish, wait for the msgAppResp from the remote node before sending
 		// out the next msgApp.
@@ -597,35 +1060,91 @@ func stepLeader(r *raft, m pb.Message) {
 		if pr.State == ProgressStateReplicate {
 			pr.becomeProbe()
 		}
-		raftLogger.Infof("raft: %x failed to send message to %x because it is unreachable [%s]", r.id, m.From, pr)
+		r.logger.Infof("raft: %x failed to send message to %x because it is unreachable [%s]", r.id, m.From, pr)
+	case pb.MsgTransferLeader:
+		leadTransferee := m.From
+		lastLeadTransferee := r.leadTransferee
+		if lastLeadTransferee != None {
+			if lastLeadTransferee == leadTransferee {
+				r.logger.Infof("raft: %x [term %d] transfer leadership to %x is in progress, ignores request to same node %x",
+					r.id, r.Term, leadTransferee, leadTransferee)
+				return
+			}
+			r.abortLeaderTransfer()
+			r.logger.Infof("raft: %x [term %d] abort previous transferring leadership to %x", r.id, r.Term, lastLeadTransferee)
+		}
+		if leadTransferee == r.id {
+			r.logger.Infof("raft: %x is already leader. Ignored transferring leadership to self", r.id)
+			return
+		}
+		if pr == nil {
+			r.logger.Infof("raft: %x [term %d] ignored transferring leadership to unknown node %x", r.id, r.Term, leadTransferee)
+			return
+		}
+		if pr.IsWitness {
+			r.logger.Infof("raft: %x [term %d] ignored transferring leadership to witness %x", r.id, r.Term, leadTransferee)
+			return
+		}
+		// Transfer leadership to third party.
+		r.logger.Infof("raft: %x [term %d] starts to transfer leadership to %x", r.id, r.Term, leadTransferee)
+		// Transfer leadership should be finished in one electionTimeout, so reset r.transferElapsed.
+		r.transferElapsed = 0
+		r.leadTransferee = leadTransferee
+		if pr.Match == r.raftLog.lastIndex() {
+			r.sendTimeoutNow(leadTransferee)
+			r.logger.Infof("raft: %x sends MsgTimeoutNow to %x immediately as %x already has up-to-date log", r.id, leadTransferee, leadTransferee)
+		} else {
+			r.sendAppend(leadTransferee)
+		}
 	}
 }
 
+// stepCandidate is shared by StateCandidate and StatePreCandidate; the difference is
+// whether they respond to MsgVoteResp or MsgPreVoteResp.
 func stepCandidate(r *raft, m pb.Message) {
+	// Only handle vote responses corresponding to our candidacy (while in
+	// StateCandidate, we may get stale MsgPreVoteResp messages in this term from
+	// our pre-candidate state).
+	var myVoteRespType pb.MessageType
+	if r.state == StatePreCandidate {
+		myVoteRespType = pb.MsgPreVoteResp
+	} else {
+		myVoteRespType = pb.MsgVoteResp
+	}
 	switch m.Type {
 	case pb.MsgProp:
-		raftLogger.Infof("raft: %x no leader at term %d; dropping proposal", r.id, r.Term)
+		r.logger.Infof("raft: %x no leader at term %d; dropping proposal", r.id, r.Term)
 		return
 	case pb.MsgApp:
 		r.becomeFollower(r.Term, m.From)
 		r.handleAppendEntries(m)
-	case pb.MsgHeartbeat:
+	case pb.MsgHeartbeat, pb.MsgQuiesce:
 		r.becomeFollower(r.Term, m.From)
 		r.handleHeartbeat(m)
 	case pb.MsgSnap:
 		r.becomeFollower(m.Term, m.From)
 		r.handleSnapshot(m)
-	case pb.MsgVote:
-		raftLogger.Infof("raft: %x [logterm: %d, index: %d, vote: %x] rejected vote from %x [logterm: %d, index: %d] at term %x",
-			r.id, r.raftLog.lastTerm(), r.raftLog.lastIndex(), r.Vote, m.From, m.LogTerm, m.Index, r.Term)
-		r.send(pb.Message{To: m.From, Type: pb.MsgVoteResp, Reject: true})
-	case pb.MsgVoteResp:
-		gr := r.poll(m.From, !m.Reject)
-		raftLogger.Infof("raft: %x [q:%d] has received %d votes and %d vote rejections", r.id, r.q(), gr, len(r.votes)-gr)
+	case pb.MsgVote, pb.MsgPreVote:
+		r.logger.Infof("raft: %x [logterm: %d, index: %d, vote: %x] rejected %s from %x [logterm: %d, index: %d] at term %d",
+			r.id, r.raftLog.lastTerm(), r.raftLog.lastIndex(), r.Vote, m.Type, m.From, m.LogTerm, m.Index, r.Term)
+		r.send(pb.Message{To: m.From, Term: r.Term, Type: voteRespMsgType(m.Type), Reject: true})
+	case pb.MsgTransferLeader:
+		r.logger.Infof("raft: %x no leader at term %d; dropping leader transfer msg", r.id, r.Term)
+	case pb.MsgReadIndex:
+		r.logger.Infof("raft: %x no leader at term %d; dropping read index msg", r.id, r.Term)
+	case pb.MsgTimeoutNow:
+		r.logger.Infof("raft: %x [term %d state candidate] ignored MsgTimeoutNow from %x", r.id, r.Term, m.From)
+	case myVoteRespType:
+		gr := r.poll(m.From, m.Type, !m.Reject)
+		r.logger.Infof("raft: %x [q:%d] has received %d %s votes and %d vote rejections", r.id, r.q(), gr, m.Type, len(r.votes)-gr)
 		switch r.q() {
 		case gr:
-			r.becomeLeader()
-			r.bcastAppend()
+			if r.state == StatePreCandidate {
+				r.campaign(campaignElection)
+			} else {
+				r.becomeLeader()
+				r.bcastAppend()
+			}
 		case len(r.votes) - gr:
 			r.becomeFollower(r.Term, None)
 		}
@@ -636,7 +1155,7 @@ func stepFollower(r *raft, m pb.Message) {
 	switch m.Type {
 	case pb.MsgProp:
 		if r.lead == None {
-			raftLogger.Infof("raft: %x no leader at term %d; dropping proposal", r.id, r.Term)
+			r.logger.Infof("raft: %x no leader at term %d; dropping proposal", r.id, r.Term)
 			return
 		}
 		m.To = r.lead
@@ -649,22 +1168,236 @@ func stepFollower(r *raft, m pb.Message) {
 		r.elapsed = 0
 		r.lead = m.From
 		r.handleHeartbeat(m)
+	case pb.MsgQuiesce:
+		// MsgQuiesce is the last heartbeat of the idle leader. It is not
+		// responded, since a response would wake the leader up again.
+		r.elapsed = 0
+		r.lead = m.From
+		r.raftLog.commitTo(m.Commit)
+		r.quiesced = true
 	case pb.MsgSnap:
 		r.elapsed = 0
 		r.handleSnapshot(m)
 	case pb.MsgVote:
 		if (r.Vote == None || r.Vote == m.From) && r.raftLog.isUpToDate(m.Index, m.LogTerm) {
 			r.elapsed = 0
-			raftLogger.Infof("raft: %x [logterm: %d, index: %d, vote: %x] voted for %x [logterm: %d, index: %d] at term %d",
+			r.logger.Infof("raft: %x [logterm: %d, index: %d, vote: %x] voted for %x [logterm: %d, index: %d] at term %d",
 				r.id, r.raftLog.lastTerm(), r.raftLog.lastIndex(), r.Vote, m.From, m.LogTerm, m.Index, r.Term)
 			r.Vote = m.From
 			r.send(pb.Message{To: m.From, Type: pb.MsgVoteResp})
 		} else {
-			raftLogger.Infof("raft: %x [logterm: %d, index: %d, vote: %x] rejected vote from %x [logterm: %d, index: %d] at term %d",
+			r.logger.Infof("raft: %x [logterm: %d, index: %d, vote: %x] rejected vote from %x [logterm: %d, index: %d] at term %d",
 				r.id, r.raftLog.lastTerm(), r.raftLog.lastIndex(), r.Vote, m.From, m.LogTerm, m.Index, r.Term)
 			r.send(pb.Message{To: m.From, Type: pb.MsgVoteResp, Reject: true})
 		}
+	case pb.MsgPreVote:
+		// A pre-vote is granted without recording the vote or resetting the
+		// election timer, since it does not bind the node to the candidate.
+		if (m.Term > r.Term || r.Vote == None || r.Vote == m.From) && r.raftLog.isUpToDate(m.Index, m.LogTerm) {
+			r.logger.Infof("raft: %x [logterm: %d, index: %d, vote: %x] cast %s for %x [logterm: %d, index: %d] at term %d",
+				r.id, r.raftLog.lastTerm(), r.raftLog.lastIndex(), r.Vote, m.Type, m.From, m.LogTerm, m.Index, r.Term)
+			// The response carries the term of the request so that it is not
+			// ignored by the pre-candidate, which has not increased its term yet.
+			r.send(pb.Message{To: m.From, Term: m.Term, Type: pb.MsgPreVoteResp})
+		} else {
+			r.logger.Infof("raft: %x [logterm: %d, index: %d, vote: %x] rejected %s from %x [logterm: %d, index: %d] at term %d",
+				r.id, r.raftLog.lastTerm(), r.raftLog.lastIndex(), r.Vote, m.Type, m.From, m.LogTerm, m.Index, r.Term)
+			r.send(pb.Message{To: m.From, Term: r.Term, Type: pb.MsgPreVoteResp, Reject: true})
+		}
+	case pb.MsgTransferLeader:
+		if r.lead == None {
+			r.logger.Infof("raft: %x no leader at term %d; dropping leader transfer msg", r.id, r.Term)
+			return
+		}
+		m.To = r.lead
+		r.send(m)
+	case pb.MsgReadIndex:
+		if r.lead == None {
+			r.logger.Infof("raft: %x no leader at term %d; dropping read index msg", r.id, r.Term)
+			return
+		}
+		m.To = r.lead
+		r.send(m)
+	case pb.MsgReadIndexResp:
+		if len(m.Entries) != 1 {
+			r.logger.Errorf("raft: %x invalid format of MsgReadIndexResp from %x, entries count: %d", r.id, m.From, len(m.Entries))
+			return
+		}
+		r.readStates = append(r.readStates, ReadState{Index: m.Index, RequestCtx: m.Entries[0].Data})
+	case pb.MsgTimeoutNow:
+		if !r.promotable() {
+			r.logger.Infof("raft: %x [term %d] ignored MsgTimeoutNow from %x since it is not promotable", r.id, r.Term, m.From)
+			return
+		}
+		// start a new election immediately without waiting for the election timeout.
+		// Leadership transfers never use pre-vote even if r.preVote is true;
+		// we know we are not recovering from a partition so there is no need
+		// for the extra round trip.
+		r.logger.Infof("raft: %x [term %d] received MsgTimeoutNow from %x and starts an election to get leadership.", r.id, r.Term, m.From)
+		r.campaign(campaignTransfer)
+	}
+}
+
+// checkQuorumActive returns true if the quorum is active from
+// the view of the local raft state machine. Otherwise, it returns
+// false.
+// checkQuorumActive also resets all RecentActive to false.
+func (r *raft) checkQuorumActive() bool {
+	var act int
+
+	for id := range r.prs {
+		if id == r.id { // self is always active
+			act++
+			continue
+		}
+
+		if r.prs[id].RecentActive {
+			act++
+		}
+
+		r.prs[id].RecentActive = false
+	}
+
+	return act >= r.q()
+}
+
+// inLease returns true if the node has heard from a live leader within the
+// election timeout, or is the leader itself. It only applies when
+// CheckQuorum is enabled, since only then the leader steps down once it loses
+// the quorum. A quiesced follower does not count its ticks, so it is never in
+// lease.
+func (r *raft) inLease() bool {
+	if !r.checkQuorum || r.lead == None || r.quiesced {
+		return false
+	}
+	return r.leaseElapsed() < r.electionTimeout
+}
+
+// leaseElapsed returns the number of ticks since the leader last showed
+// up: followers count them in elapsed, and the leader, which checks the
+// quorum every election timeout, in electionElapsed.
+func (r *raft) leaseElapsed() int {
+	if r.state == StateLeader {
+		return r.electionElapsed
+	}
+	return r.elapsed
+}
+
+// isIdle returns true if the leader has nothing left to replicate: all the
+// entries are committed and every follower has them.
+func (r *raft) isIdle() bool {
+	if r.leadTransferee != None || r.readOnly.lastPendingRequestCtx() != "" {
+		return false
+	}
+	li := r.raftLog.lastIndex()
+	if r.raftLog.committed != li {
+		return false
+	}
+	for id, pr := range r.prs {
+		if id != r.id && pr.Match != li {
+			return false
+		}
+	}
+	return true
+}
+
+// bcastQuiesce sends MsgQuiesce to all the followers and quiesces the leader.
+func (r *raft) bcastQuiesce() {
+	for id := range r.prs {
+		if id == r.id {
+			continue
+		}
+		r.send(pb.Message{To: id, Type: pb.MsgQuiesce, Commit: min(r.prs[id].Match, r.raftLog.committed)})
 	}
+	r.quiesced = true
+}
+
+// wake resumes the timers of the quiesced node. The timers start over, so
+// that the follower gives the leader a full election timeout to show up.
+func (r *raft) wake() {
+	r.quiesced = false
+	r.elapsed = 0
+	r.electionElapsed = 0
+}
+
+// unquiesce wakes the quiesced node up on the request of the application,
+// and wakes the other members up as well: the leader resumes its heartbeats,
+// and the follower pokes its leader, which resumes them.
+func (r *raft) unquiesce() {
+	if !r.quiesced {
+		return
+	}
+	r.wake()
+	switch r.state {
+	case StateLeader:
+		r.bcastHeartbeat()
+	case StateFollower:
+		if r.lead != None {
+			r.send(pb.Message{To: r.lead, Type: pb.MsgHeartbeatResp})
+		}
+	}
+}
+
+// increaseUncommittedSize computes the size of the proposed entries and
+// determines whether they would push the leader over its limit of
+// uncommitted entries. If not, it records the size and returns true.
+func (r *raft) increaseUncommittedSize(ents []pb.Entry) bool {
+	s := payloadsSize(ents)
+	if r.uncommittedSize > 0 && r.maxUncommittedSize > 0 && r.uncommittedSize+s > r.maxUncommittedSize {
+		return false
+	}
+	r.uncommittedSize += s
+	return true
+}
+
+// reduceUncommittedSize accounts for the newly committed entries by
+// decreasing the uncommitted entry size.
+func (r *raft) reduceUncommittedSize(ents []pb.Entry) {
+	if r.uncommittedSize == 0 {
+		// Fast-path for followers, who do not track or enforce the limit.
+		return
+	}
+	s := payloadsSize(ents)
+	if s > r.uncommittedSize {
+		// The committed entries may include the entries appended before this
+		// node became the leader, which were never counted.
+		r.uncommittedSize = 0
+	} else {
+		r.uncommittedSize -= s
+	}
+}
+
+// voteRespMsgType maps vote and prevote message types to their corresponding
+// responses.
+func voteRespMsgType(t pb.MessageType) pb.MessageType {
+	switch t {
+	case pb.MsgVote:
+		return pb.MsgVoteResp
+	case pb.MsgPreVote:
+		return pb.MsgPreVoteResp
+	default:
+		panic(fmt.Sprintf("not a vote message: %s", t))
+	}
+}
+
+// respondReadIndex hands the read index of the request m to the node that
+// asked for it: the local node gets a ReadState, a follower a MsgReadIndexResp.
+func (r *raft) respondReadIndex(m pb.Message, index uint64) {
+	if m.From == None || m.From == r.id {
+		r.readStates = append(r.readStates, ReadState{Index: index, RequestCtx: m.Entries[0].Data})
+		return
+	}
+	r.send(pb.Message{To: m.From, Type: pb.MsgReadIndexResp, Index: index, Entries: m.Entries})
+}
+
+// sendTimeoutNow tells the given follower to start an election immediately.
+func (r *raft) sendTimeoutNow(to uint64) {
+	r.send(pb.Message{To: to, Type: pb.MsgTimeoutNow})
+}
+
+func (r *raft) abortLeaderTransfer() {
+	r.leadTransferee = None
+	r.transferElapsed = 0
 }
 
 func (r *raft) handleAppendEntries(m pb.Message) {
@@ -676,26 +1409,32 @@ func (r *raft) handleAppendEntries(m pb.Message) {
 	if mlastIndex, ok := r.raftLog.maybeAppend(m.Index, m.LogTerm, m.Commit, m.Entries...); ok {
 		r.send(pb.Message{To: m.From, Type: pb.MsgAppResp, Index: mlastIndex})
 	} else {
-		raftLogger.Infof("raft: %x [logterm: %d, index: %d] rejected msgApp [logterm: %d, index: %d] from %x",
+		r.logger.Infof("raft: %x [logterm: %d, index: %d] rejected msgApp [logterm: %d, index: %d] from %x",
 			r.id, r.raftLog.term(m.Index), m.Index, m.LogTerm, m.Index, m.From)
-		r.send(pb.Message{To: m.From, Type: pb.MsgAppResp, Index: m.Index, Reject: true, RejectHint: r.raftLog.lastIndex()})
+		// Hint the leader with the largest index that may still match its
+		// log: none of the entries after it has a term <= m.LogTerm, so none
+		// of them can match the entry of the leader at m.Index or before it.
+		hintIndex := min(m.Index, r.raftLog.lastIndex())
+		hintIndex = r.raftLog.findConflictByTerm(hintIndex, m.LogTerm)
+		r.send(pb.Message{To: m.From, Type: pb.MsgAppResp, Index: m.Index, Reject: true,
+			RejectHint: hintIndex, LogTerm: r.raftLog.term(hintIndex)})
 	}
 }
 
 //发送heartbeat的response message
 func (r *raft) handleHeartbeat(m pb.Message) {
 	r.raftLog.commitTo(m.Commit)
-	r.send(pb.Message{To: m.From, Type: pb.MsgHeartbeatResp})
+	r.send(pb.Message{To: m.From, Type: pb.MsgHeartbeatResp, Context: m.Context})
 }
 
 func (r *raft) handleSnapshot(m pb.Message) {
 	sindex, sterm := m.Snapshot.Metadata.Index, m.Snapshot.Metadata.Term
 	if r.restore(m.Snapshot) {
-		raftLogger.Infof("raft: %x [commit: %d] restored snapshot [index: %d, term: %d]",
+		r.logger.Infof("raft: %x [commit: %d] restored snapshot [index: %d, term: %d]",
 			r.id, r.Commit, sindex, sterm)
 		r.send(pb.Message{To: m.From, Type: pb.MsgAppResp, Index: r.raftLog.lastIndex()})
 	} else {
-		raftLogger.Infof("raft: %x [commit: %d] ignored snapshot [index: %d, term: %d]",
+		r.logger.Infof("raft: %x [commit: %d] ignored snapshot [index: %d, term: %d]",
 			r.id, r.Commit, sindex, sterm)
 		r.send(pb.Message{To: m.From, Type: pb.MsgAppResp, Index: r.raftLog.committed})
 	}
@@ -708,13 +1447,13 @@ func (r *raft) restore(s pb.Snapshot) bool {
 		return false
 	}
 	if r.raftLog.matchTerm(s.Metadata.Index, s.Metadata.Term) {
-		raftLogger.Infof("raft: %x [commit: %d, lastindex: %d, lastterm: %d] fast-forwarded commit to snapshot [index: %d, term: %d]",
+		r.logger.Infof("raft: %x [commit: %d, lastindex: %d, lastterm: %d] fast-forwarded commit to snapshot [index: %d, term: %d]",
 			r.id, r.Commit, r.raftLog.lastIndex(), r.raftLog.lastTerm(), s.Metadata.Index, s.Metadata.Term)
 		r.raftLog.commitTo(s.Metadata.Index)
 		return false
 	}
 
-	raftLogger.Infof("raft: %x [commit: %d, lastindex: %d, lastterm: %d] starts to restore snapshot [index: %d, term: %d]",
+	r.logger.Infof("raft: %x [commit: %d, lastindex: %d, lastterm: %d] starts to restore snapshot [index: %d, term: %d]",
 		r.id, r.Commit, r.raftLog.lastIndex(), r.raftLog.lastTerm(), s.Metadata.Index, s.Metadata.Term)
 
 	r.raftLog.restore(s)
@@ -727,7 +1466,12 @@ func (r *raft) restore(s pb.Snapshot) bool {
 			match = 0
 		}
 		r.setProgress(n, match, next)
-		raftLogger.Infof("raft: %x restored progress of %x [%s]", r.id, n, r.prs[n])
+		r.logger.Infof("raft: %x restored progress of %x [%s]", r.id, n, r.prs[n])
+	}
+	for _, n := range s.Metadata.ConfState.Witnesses {
+		if pr, ok := r.prs[n]; ok {
+			pr.IsWitness = true
+		}
 	}
 	return true
 }
@@ -737,13 +1481,17 @@ func (r *raft) needSnapshot(i uint64) bool {
 }
 
 // promotable indicates whether state machine can be promoted to leader,
-// which is true when its own id is in progress list.
+// which is true when its own id is in progress list and it is not a witness.
 func (r *raft) promotable() bool {
-	_, ok := r.prs[r.id]
-	return ok
+	pr, ok := r.prs[r.id]
+	return ok && !pr.IsWitness
 }
 
-func (r *raft) addNode(id uint64) {
+func (r *raft) addNode(id uint64) { r.addNodeOrWitness(id, false) }
+
+func (r *raft) addWitness(id uint64) { r.addNodeOrWitness(id, true) }
+
+func (r *raft) addNodeOrWitness(id uint64, isWitness bool) {
 	if _, ok := r.prs[id]; ok {
 		// Ignore any redundant addNode calls (which can happen because the
 		// initial bootstrapping entries are applied twice).
@@ -751,12 +1499,24 @@ func (r *raft) addNode(id uint64) {
 	}
 	// node刚启动时，match为0,next为lastIndex+ 1
 	r.setProgress(id, 0, r.raftLog.lastIndex()+1)
+	r.prs[id].IsWitness = isWitness
+	// When a node is first added, it is marked as recently active, or
+	// CheckQuorum may step the leader down before the new node has had a
+	// chance to communicate with it.
+	r.prs[id].RecentActive = true
+	// 新加入的成员在几个选举超时内按MaxCatchUpSizePerTick限速追赶
+	r.prs[id].catchUpTicks = catchUpElectionTimeouts * r.electionTimeout
 	r.pendingConf = false
 }
 
 func (r *raft) removeNode(id uint64) {
 	r.delProgress(id)
 	r.pendingConf = false
+
+	// If the removed node is the leadTransferee, then abort the leadership transferring.
+	if r.state == StateLeader && r.leadTransferee == id {
+		r.abortLeaderTransfer()
+	}
 }
 
 func (r *raft) resetPendingConf() { r.pendingConf = false }
@@ -771,7 +1531,7 @@ func (r *raft) delProgress(id uint64) {
 
 func (r *raft) loadState(state pb.HardState) {
 	if state.Commit < r.raftLog.committed || state.Commit > r.raftLog.lastIndex() {
-		raftLogger.Panicf("raft: %x state.commit %d is out of range [%d, %d]", r.id, state.Commit, r.raftLog.committed, r.raftLog.lastIndex())
+		r.logger.Panicf("raft: %x state.commit %d is out of range [%d, %d]", r.id, state.Commit, r.raftLog.committed, r.raftLog.lastIndex())
 	}
 	r.raftLog.committed = state.Commit
 	r.Term = state.Term
@@ -779,11 +1539,21 @@ func (r *raft) loadState(state pb.HardState) {
 	r.Commit = state.Commit
 }
 
+// electionDelay returns the ticks a node of the given priority waits after
+// the election timeout before campaigning, in proportion to how much lower
+// its priority is than MaxElectionPriority.
+func electionDelay(electionTimeout, priority int) int {
+	if priority == 0 {
+		return 0
+	}
+	return electionTimeout * (MaxElectionPriority - priority) / MaxElectionPriority
+}
+
 // isElectionTimeout returns true if r.elapsed is greater than the
-// randomized election timeout in (electiontimeout, 2 * electiontimeout - 1).
-// Otherwise, it returns false.
+// randomized election timeout in (electiontimeout, 2 * electiontimeout - 1),
+// delayed by the election priority of r. Otherwise, it returns false.
 func (r *raft) isElectionTimeout() bool {
-	d := r.elapsed - r.electionTimeout
+	d := r.elapsed - r.electionTimeout - r.electionDelay
 	if d < 0 {
 		return false
 	}
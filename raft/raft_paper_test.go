@@ -606,17 +606,18 @@ func TestFollowerCheckMsgApp(t *testing.T) {
 		windex      uint64
 		wreject     bool
 		wrejectHint uint64
+		wlogterm    uint64
 	}{
 		// match with committed entries
-		{0, 0, 1, false, 0},
-		{ents[0].Term, ents[0].Index, 1, false, 0},
+		{0, 0, 1, false, 0, 0},
+		{ents[0].Term, ents[0].Index, 1, false, 0, 0},
 		// match with uncommitted entries
-		{ents[1].Term, ents[1].Index, 2, false, 0},
+		{ents[1].Term, ents[1].Index, 2, false, 0, 0},
 
-		// unmatch with existing entry
-		{ents[0].Term, ents[1].Index, ents[1].Index, true, 2},
+		// unmatch with existing entry; the entry of term 2 cannot match
+		{ents[0].Term, ents[1].Index, ents[1].Index, true, 1, 1},
 		// unexisting entry
-		{ents[1].Term + 1, ents[1].Index + 1, ents[1].Index + 1, true, 2},
+		{ents[1].Term + 1, ents[1].Index + 1, ents[1].Index + 1, true, 2, 2},
 	}
 	for i, tt := range tests {
 		storage := NewMemoryStorage()
@@ -629,7 +630,7 @@ func TestFollowerCheckMsgApp(t *testing.T) {
 
 		msgs := r.readMessages()
 		wmsgs := []pb.Message{
-			{From: 1, To: 2, Type: pb.MsgAppResp, Term: 2, Index: tt.windex, Reject: tt.wreject, RejectHint: tt.wrejectHint},
+			{From: 1, To: 2, Type: pb.MsgAppResp, Term: 2, Index: tt.windex, Reject: tt.wreject, RejectHint: tt.wrejectHint, LogTerm: tt.wlogterm},
 		}
 		if !reflect.DeepEqual(msgs, wmsgs) {
 			t.Errorf("#%d: msgs = %+v, want %+v", i, msgs, wmsgs)
@@ -132,3 +132,37 @@ func TestSnapshotAbort(t *testing.T) {
 		t.Fatalf("Next = %d, want 12", sm.prs[2].Next)
 	}
 }
+
+// TestSendingSnapshotToWitness tests that the witnesses restored from a
+// snapshot get the snapshots without their data.
+func TestSendingSnapshotToWitness(t *testing.T) {
+	snap := pb.Snapshot{
+		Data: []byte("data"),
+		Metadata: pb.SnapshotMetadata{
+			Index:     11,
+			Term:      11,
+			ConfState: pb.ConfState{Nodes: []uint64{1, 2}, Witnesses: []uint64{2}},
+		},
+	}
+	sm := newTestRaft(1, []uint64{1}, 10, 1, NewMemoryStorage())
+	sm.restore(snap)
+	if !sm.prs[2].IsWitness {
+		t.Fatalf("IsWitness = false, want true")
+	}
+
+	sm.becomeCandidate()
+	sm.becomeLeader()
+	sm.readMessages()
+	sm.prs[2].Next = sm.raftLog.firstIndex()
+	sm.Step(pb.Message{From: 2, To: 1, Type: pb.MsgAppResp, Index: sm.prs[2].Next - 1, Reject: true})
+	msgs := sm.readMessages()
+	if len(msgs) != 1 || msgs[0].Type != pb.MsgSnap {
+		t.Fatalf("msgs = %+v, want one MsgSnap", msgs)
+	}
+	if msgs[0].Snapshot.Data != nil {
+		t.Errorf("snapshot data = %q, want nil", msgs[0].Snapshot.Data)
+	}
+	if msgs[0].Snapshot.Metadata.Index != 11 {
+		t.Errorf("snapshot index = %d, want 11", msgs[0].Snapshot.Metadata.Index)
+	}
+}
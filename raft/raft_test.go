@@ -17,9 +17,11 @@ package raft
 import (
 	"bytes"
 	"fmt"
+	"log"
 	"math"
 	"math/rand"
 	"reflect"
+	"strings"
 	"testing"
 
 	pb "github.com/coreos/etcd/raft/raftpb"
@@ -317,6 +319,103 @@ func TestLeaderElection(t *testing.T) {
 	}
 }
 
+func TestLeaderElectionPreVote(t *testing.T) {
+	cfg := preVoteConfig
+	tests := []struct {
+		*network
+		state   StateType
+		expTerm uint64
+	}{
+		{newNetworkWithConfig(cfg, nil, nil, nil), StateLeader, 1},
+		{newNetworkWithConfig(cfg, nil, nil, nopStepper), StateLeader, 1},
+		{newNetworkWithConfig(cfg, nil, nopStepper, nopStepper), StatePreCandidate, 0},
+		{newNetworkWithConfig(cfg, nil, nopStepper, nopStepper, nil), StatePreCandidate, 0},
+		{newNetworkWithConfig(cfg, nil, nopStepper, nopStepper, nil, nil), StateLeader, 1},
+
+		// three logs further along than 0, but in the same term so rejections
+		// are returned instead of the votes being ignored.
+		{newNetworkWithConfig(cfg, nil, entsWithConfig(cfg, 1), entsWithConfig(cfg, 1), entsWithConfig(cfg, 1, 1), nil), StateFollower, 0},
+
+		// logs converge
+		{newNetworkWithConfig(cfg, entsWithConfig(cfg, 1), nil, entsWithConfig(cfg, 2), entsWithConfig(cfg, 1), nil), StateLeader, 1},
+	}
+
+	for i, tt := range tests {
+		tt.send(pb.Message{From: 1, To: 1, Type: pb.MsgHup})
+		sm := tt.network.peers[1].(*raft)
+		if sm.state != tt.state {
+			t.Errorf("#%d: state = %s, want %s", i, sm.state, tt.state)
+		}
+		if g := sm.Term; g != tt.expTerm {
+			t.Errorf("#%d: term = %d, want %d", i, g, tt.expTerm)
+		}
+	}
+}
+
+// TestPreVoteDisruptionPrevented verifies that a node that rejoins the cluster
+// after a partition does not bump the term or depose a stable leader when
+// pre-vote is enabled.
+func TestPreVoteDisruptionPrevented(t *testing.T) {
+	nt := newNetworkWithConfig(preVoteConfig, nil, nil, nil)
+	nt.send(pb.Message{From: 1, To: 1, Type: pb.MsgHup})
+
+	nt.isolate(3)
+	nt.send(pb.Message{From: 1, To: 1, Type: pb.MsgProp, Entries: []pb.Entry{{Data: []byte("somedata")}}})
+
+	// node 3 keeps campaigning while it is partitioned away.
+	for i := 0; i < 3; i++ {
+		nt.send(pb.Message{From: 3, To: 3, Type: pb.MsgHup})
+	}
+	sm3 := nt.peers[3].(*raft)
+	if sm3.state != StatePreCandidate {
+		t.Fatalf("state = %s, want %s", sm3.state, StatePreCandidate)
+	}
+	if sm3.Term != 1 {
+		t.Fatalf("term = %d, want %d", sm3.Term, 1)
+	}
+
+	nt.recover()
+	// node 3 fails to win the pre-vote since its log is behind.
+	nt.send(pb.Message{From: 3, To: 3, Type: pb.MsgHup})
+
+	sm1 := nt.peers[1].(*raft)
+	if sm1.state != StateLeader {
+		t.Errorf("state = %s, want %s", sm1.state, StateLeader)
+	}
+	if sm1.Term != 1 {
+		t.Errorf("term = %d, want %d", sm1.Term, 1)
+	}
+
+	// the leader brings node 3 back as a follower.
+	nt.send(pb.Message{From: 1, To: 1, Type: pb.MsgBeat})
+	if sm3.state != StateFollower {
+		t.Errorf("state = %s, want %s", sm3.state, StateFollower)
+	}
+	if sm3.lead != 1 {
+		t.Errorf("lead = %d, want %d", sm3.lead, 1)
+	}
+}
+
+// TestPreVoteGrantDoesNotRecordVote verifies that granting a pre-vote neither
+// changes the term nor binds the vote of the follower.
+func TestPreVoteGrantDoesNotRecordVote(t *testing.T) {
+	r := newTestRaft(1, []uint64{1, 2}, 10, 1, NewMemoryStorage())
+	r.becomeFollower(1, None)
+
+	r.Step(pb.Message{From: 2, To: 1, Term: 2, Type: pb.MsgPreVote})
+	msgs := r.readMessages()
+	wmsgs := []pb.Message{{From: 1, To: 2, Term: 2, Type: pb.MsgPreVoteResp}}
+	if !reflect.DeepEqual(msgs, wmsgs) {
+		t.Errorf("msgs = %+v, want %+v", msgs, wmsgs)
+	}
+	if r.Term != 1 {
+		t.Errorf("term = %d, want %d", r.Term, 1)
+	}
+	if r.Vote != None {
+		t.Errorf("vote = %x, want %x", r.Vote, None)
+	}
+}
+
 func TestLogReplication(t *testing.T) {
 	tests := []struct {
 		*network
@@ -492,7 +591,7 @@ func TestDuelingCandidates(t *testing.T) {
 	}{
 		{a, StateFollower, 2, wlog},
 		{b, StateFollower, 2, wlog},
-		{c, StateFollower, 2, newLog(NewMemoryStorage())},
+		{c, StateFollower, 2, newLog(NewMemoryStorage(), raftLogger)},
 	}
 
 	for i, tt := range tests {
@@ -638,7 +737,7 @@ func TestProposal(t *testing.T) {
 		send(pb.Message{From: 1, To: 1, Type: pb.MsgHup})
 		send(pb.Message{From: 1, To: 1, Type: pb.MsgProp, Entries: []pb.Entry{{Data: data}}})
 
-		wantLog := newLog(NewMemoryStorage())
+		wantLog := newLog(NewMemoryStorage(), raftLogger)
 		if tt.success {
 			wantLog = &raftLog{
 				storage: &MemoryStorage{
@@ -779,6 +878,40 @@ func TestIsElectionTimeout(t *testing.T) {
 	}
 }
 
+// TestElectionPriority ensures that a node of a lower priority waits longer
+// after the election timeout before campaigning.
+func TestElectionPriority(t *testing.T) {
+	tests := []struct {
+		priority int
+		elapse   int
+		wtimeout bool
+	}{
+		{0, 20, true},
+		{MaxElectionPriority, 20, true},
+		{MaxElectionPriority, 10, false},
+		{50, 15, false},
+		{50, 25, true},
+		{1, 19, false},
+		{1, 29, true},
+	}
+
+	for i, tt := range tests {
+		cfg := newTestConfig(1, []uint64{1}, 10, 1, NewMemoryStorage())
+		cfg.Priority = tt.priority
+		sm := newRaft(cfg)
+		sm.elapsed = tt.elapse
+		if g := sm.isElectionTimeout(); g != tt.wtimeout {
+			t.Errorf("#%d: timeout = %v, want %v", i, g, tt.wtimeout)
+		}
+	}
+
+	cfg := newTestConfig(1, []uint64{1}, 10, 1, NewMemoryStorage())
+	cfg.Priority = MaxElectionPriority + 1
+	if err := cfg.validate(); err == nil {
+		t.Errorf("err = nil, want an error for priority %d", cfg.Priority)
+	}
+}
+
 // ensure that the Step function ignores the message from old term and does not pass it to the
 // acutal stepX function.
 func TestStepIgnoreOldTermMsg(t *testing.T) {
@@ -1049,7 +1182,8 @@ func TestRecvMsgVote(t *testing.T) {
 		sm.HardState = pb.HardState{Vote: tt.voteFor}
 		sm.raftLog = &raftLog{
 			storage:  &MemoryStorage{ents: []pb.Entry{{}, {Index: 1, Term: 2}, {Index: 2, Term: 2}}},
-			unstable: unstable{offset: 3},
+			unstable: unstable{offset: 3, logger: raftLogger},
+			logger:   raftLogger,
 		}
 
 		sm.Step(pb.Message{Type: pb.MsgVote, From: 2, Index: tt.i, LogTerm: tt.term})
@@ -1197,7 +1331,8 @@ func TestLeaderAppResp(t *testing.T) {
 		sm := newTestRaft(1, []uint64{1, 2, 3}, 10, 1, NewMemoryStorage())
 		sm.raftLog = &raftLog{
 			storage:  &MemoryStorage{ents: []pb.Entry{{}, {Index: 1, Term: 0}, {Index: 2, Term: 1}}},
-			unstable: unstable{offset: 3},
+			unstable: unstable{offset: 3, logger: raftLogger},
+			logger:   raftLogger,
 		}
 		sm.becomeCandidate()
 		sm.becomeLeader()
@@ -1302,7 +1437,11 @@ func TestRecvMsgBeat(t *testing.T) {
 
 	for i, tt := range tests {
 		sm := newTestRaft(1, []uint64{1, 2, 3}, 10, 1, NewMemoryStorage())
-		sm.raftLog = &raftLog{storage: &MemoryStorage{ents: []pb.Entry{{}, {Index: 1, Term: 0}, {Index: 2, Term: 1}}}}
+		sm.raftLog = &raftLog{
+			storage:  &MemoryStorage{ents: []pb.Entry{{}, {Index: 1, Term: 0}, {Index: 2, Term: 1}}},
+			unstable: unstable{logger: raftLogger},
+			logger:   raftLogger,
+		}
 		sm.Term = 1
 		sm.state = tt.state
 		switch tt.state {
@@ -1618,8 +1757,8 @@ func TestStepConfig(t *testing.T) {
 }
 
 // TestStepIgnoreConfig tests that if raft step the second msgProp in
-// EntryConfChange type when the first one is uncommitted, the node will set
-// the proposal to noop and keep its original state.
+// EntryConfChange type when the first one is uncommitted, the node will drop
+// the proposal with ErrProposalDropped and keep its original state.
 func TestStepIgnoreConfig(t *testing.T) {
 	// a raft that cannot make progress
 	r := newTestRaft(1, []uint64{1, 2}, 10, 1, NewMemoryStorage())
@@ -1628,16 +1767,69 @@ func TestStepIgnoreConfig(t *testing.T) {
 	r.Step(pb.Message{From: 1, To: 1, Type: pb.MsgProp, Entries: []pb.Entry{{Type: pb.EntryConfChange}}})
 	index := r.raftLog.lastIndex()
 	pendingConf := r.pendingConf
-	r.Step(pb.Message{From: 1, To: 1, Type: pb.MsgProp, Entries: []pb.Entry{{Type: pb.EntryConfChange}}})
-	wents := []pb.Entry{{Type: pb.EntryNormal, Term: 1, Index: 3, Data: nil}}
-	if ents := r.raftLog.entries(index+1, noLimit); !reflect.DeepEqual(ents, wents) {
-		t.Errorf("ents = %+v, want %+v", ents, wents)
+	err := r.Step(pb.Message{From: 1, To: 1, Type: pb.MsgProp, Entries: []pb.Entry{{Type: pb.EntryConfChange}}})
+	if err != ErrProposalDropped {
+		t.Errorf("err = %v, want %v", err, ErrProposalDropped)
+	}
+	if ents := r.raftLog.entries(index+1, noLimit); len(ents) != 0 {
+		t.Errorf("ents = %+v, want none", ents)
 	}
 	if r.pendingConf != pendingConf {
 		t.Errorf("pendingConf = %v, want %v", r.pendingConf, pendingConf)
 	}
 }
 
+// TestStepDropMultipleConfig tests that the leader drops a proposal that
+// carries more than one conf change, as at most one can be pending.
+func TestStepDropMultipleConfig(t *testing.T) {
+	r := newTestRaft(1, []uint64{1, 2}, 10, 1, NewMemoryStorage())
+	r.becomeCandidate()
+	r.becomeLeader()
+	index := r.raftLog.lastIndex()
+	err := r.Step(pb.Message{From: 1, To: 1, Type: pb.MsgProp, Entries: []pb.Entry{{Type: pb.EntryConfChange}, {Type: pb.EntryConfChange}}})
+	if err != ErrProposalDropped {
+		t.Errorf("err = %v, want %v", err, ErrProposalDropped)
+	}
+	if ents := r.raftLog.entries(index+1, noLimit); len(ents) != 0 {
+		t.Errorf("ents = %+v, want none", ents)
+	}
+	if r.pendingConf {
+		t.Errorf("pendingConf = true, want false")
+	}
+}
+
+// TestStepDropProposal tests that raft drops a proposal with
+// ErrProposalDropped when it has no leader to forward it to, or when it is
+// the leader transferring its leadership.
+func TestStepDropProposal(t *testing.T) {
+	follower := newTestRaft(1, []uint64{1, 2}, 10, 1, NewMemoryStorage())
+	candidate := newTestRaft(1, []uint64{1, 2}, 10, 1, NewMemoryStorage())
+	candidate.becomeCandidate()
+	transferring := newTestRaft(1, []uint64{1, 2}, 10, 1, NewMemoryStorage())
+	transferring.becomeCandidate()
+	transferring.becomeLeader()
+	transferring.leadTransferee = 2
+	leader := newTestRaft(1, []uint64{1, 2}, 10, 1, NewMemoryStorage())
+	leader.becomeCandidate()
+	leader.becomeLeader()
+
+	tests := []struct {
+		r    *raft
+		werr error
+	}{
+		{follower, ErrProposalDropped},
+		{candidate, ErrProposalDropped},
+		{transferring, ErrProposalDropped},
+		{leader, nil},
+	}
+	for i, tt := range tests {
+		err := tt.r.Step(pb.Message{From: 1, To: 1, Type: pb.MsgProp, Entries: []pb.Entry{{Data: []byte("somedata")}}})
+		if err != tt.werr {
+			t.Errorf("#%d: err = %v, want %v", i, err, tt.werr)
+		}
+	}
+}
+
 // TestRecoverPendingConfig tests that new leader recovers its pendingConf flag
 // based on uncommitted entries.
 func TestRecoverPendingConfig(t *testing.T) {
@@ -1691,6 +1883,54 @@ func TestAddNode(t *testing.T) {
 	}
 }
 
+// TestAddWitness tests that addWitness adds a node which stays a witness
+// through the state changes of the raft.
+func TestAddWitness(t *testing.T) {
+	r := newTestRaft(1, []uint64{1}, 10, 1, NewMemoryStorage())
+	r.pendingConf = true
+	r.addWitness(2)
+	if r.pendingConf != false {
+		t.Errorf("pendingConf = %v, want false", r.pendingConf)
+	}
+	r.becomeCandidate()
+	r.becomeLeader()
+	wcs := pb.ConfState{Nodes: []uint64{1, 2}, Witnesses: []uint64{2}}
+	if cs := r.confState(); !reflect.DeepEqual(cs, wcs) {
+		t.Errorf("confState = %+v, want %+v", cs, wcs)
+	}
+}
+
+// TestWitness tests that a witness counts in the commit quorum, but it never
+// campaigns and the leadership is never transferred to it.
+func TestWitness(t *testing.T) {
+	nt := newNetwork(nil, nil, nil)
+	for _, p := range nt.peers {
+		p.(*raft).prs[3].IsWitness = true
+	}
+	a, c := nt.peers[1].(*raft), nt.peers[3].(*raft)
+
+	nt.send(pb.Message{From: 3, To: 3, Type: pb.MsgHup})
+	if c.state != StateFollower {
+		t.Errorf("witness state = %s, want %s", c.state, StateFollower)
+	}
+
+	nt.send(pb.Message{From: 1, To: 1, Type: pb.MsgHup})
+	nt.isolate(2)
+	nt.send(pb.Message{From: 1, To: 1, Type: pb.MsgProp, Entries: []pb.Entry{{Data: []byte("somedata")}}})
+	if a.raftLog.committed != 2 {
+		t.Errorf("committed = %d, want 2", a.raftLog.committed)
+	}
+
+	nt.send(pb.Message{From: 3, To: 1, Type: pb.MsgTransferLeader})
+	if a.leadTransferee != None || a.state != StateLeader {
+		t.Errorf("leadTransferee = %x, state = %s, want %x, %s", a.leadTransferee, a.state, None, StateLeader)
+	}
+	c.Step(pb.Message{From: 1, To: 3, Term: a.Term, Type: pb.MsgTimeoutNow})
+	if c.state != StateFollower {
+		t.Errorf("witness state = %s, want %s", c.state, StateFollower)
+	}
+}
+
 // TestRemoveNode tests that removeNode could update pendingConf, nodes and
 // and removed list correctly.
 func TestRemoveNode(t *testing.T) {
@@ -1747,12 +1987,821 @@ func TestRaftNodes(t *testing.T) {
 	}
 }
 
+func TestLeaderStepdownWhenQuorumActive(t *testing.T) {
+	sm := newTestRaft(1, []uint64{1, 2, 3}, 5, 1, NewMemoryStorage())
+	sm.checkQuorum = true
+
+	sm.becomeCandidate()
+	sm.becomeLeader()
+
+	for i := 0; i < sm.electionTimeout+1; i++ {
+		sm.Step(pb.Message{From: 2, Type: pb.MsgHeartbeatResp, Term: sm.Term})
+		sm.tick()
+	}
+
+	if sm.state != StateLeader {
+		t.Errorf("state = %v, want %v", sm.state, StateLeader)
+	}
+}
+
+func TestLeaderStepdownWhenQuorumLost(t *testing.T) {
+	sm := newTestRaft(1, []uint64{1, 2, 3}, 5, 1, NewMemoryStorage())
+	sm.checkQuorum = true
+
+	sm.becomeCandidate()
+	sm.becomeLeader()
+
+	for i := 0; i < sm.electionTimeout+1; i++ {
+		sm.tick()
+	}
+
+	if sm.state != StateFollower {
+		t.Errorf("state = %v, want %v", sm.state, StateFollower)
+	}
+	if sm.lead != None {
+		t.Errorf("lead = %x, want %x", sm.lead, None)
+	}
+}
+
+// TestLeaderStepdownWhenNodeAdded tests that a newly added node counts as
+// active, so the leader does not step down on CheckQuorum before the node
+// has had a chance to respond.
+func TestLeaderStepdownWhenNodeAdded(t *testing.T) {
+	sm := newTestRaft(1, []uint64{1}, 5, 1, NewMemoryStorage())
+	sm.checkQuorum = true
+
+	sm.becomeCandidate()
+	sm.becomeLeader()
+	sm.addNode(2)
+
+	for i := 0; i < sm.electionTimeout; i++ {
+		sm.tick()
+	}
+
+	if sm.state != StateLeader {
+		t.Errorf("state = %v, want %v", sm.state, StateLeader)
+	}
+}
+
+func TestLeaderSupersedingWithCheckQuorum(t *testing.T) {
+	a := newTestRaft(1, []uint64{1, 2, 3}, 10, 1, NewMemoryStorage())
+	b := newTestRaft(2, []uint64{1, 2, 3}, 10, 1, NewMemoryStorage())
+	c := newTestRaft(3, []uint64{1, 2, 3}, 10, 1, NewMemoryStorage())
+
+	a.checkQuorum = true
+	b.checkQuorum = true
+	c.checkQuorum = true
+
+	nt := newNetwork(a, b, c)
+	nt.send(pb.Message{From: 1, To: 1, Type: pb.MsgHup})
+	if a.state != StateLeader {
+		t.Fatalf("state = %s, want %s", a.state, StateLeader)
+	}
+
+	// the leader keeps its leadership as long as the followers respond.
+	for i := 0; i < 2*a.electionTimeout; i++ {
+		a.tick()
+		nt.send(a.readMessages()...)
+	}
+	if a.state != StateLeader {
+		t.Fatalf("state = %s, want %s", a.state, StateLeader)
+	}
+
+	// the fenced leader steps down once it loses the quorum.
+	nt.isolate(1)
+	for i := 0; i < 2*a.electionTimeout; i++ {
+		a.tick()
+		nt.send(a.readMessages()...)
+	}
+	if a.state != StateFollower {
+		t.Errorf("state = %s, want %s", a.state, StateFollower)
+	}
+}
+
+// TestVoteIgnoredInLease ensures that with CheckQuorum the members which
+// have heard from a live leader ignore the vote requests of a disruptive
+// member, but not those of a leader transferee.
+func TestVoteIgnoredInLease(t *testing.T) {
+	for i, forced := range []bool{false, true} {
+		a := newTestRaft(1, []uint64{1, 2, 3}, 10, 1, NewMemoryStorage())
+		b := newTestRaft(2, []uint64{1, 2, 3}, 10, 1, NewMemoryStorage())
+		c := newTestRaft(3, []uint64{1, 2, 3}, 10, 1, NewMemoryStorage())
+		a.checkQuorum = true
+		b.checkQuorum = true
+		c.checkQuorum = true
+
+		nt := newNetwork(a, b, c)
+		nt.send(pb.Message{From: 1, To: 1, Type: pb.MsgHup})
+		if a.state != StateLeader {
+			t.Fatalf("#%d: state = %s, want %s", i, a.state, StateLeader)
+		}
+
+		if forced {
+			nt.send(pb.Message{From: 3, To: 1, Type: pb.MsgTransferLeader})
+		} else {
+			nt.send(pb.Message{From: 3, To: 3, Type: pb.MsgHup})
+		}
+
+		wlead, wstate := uint64(1), StateCandidate
+		if forced {
+			wlead, wstate = 3, StateLeader
+		}
+		if a.lead != wlead || b.lead != wlead {
+			t.Errorf("#%d: lead = %x, %x, want %x", i, a.lead, b.lead, wlead)
+		}
+		if c.state != wstate {
+			t.Errorf("#%d: state = %s, want %s", i, c.state, wstate)
+		}
+	}
+}
+
+// TestVoteGrantedAfterLease ensures that the members grant the votes again
+// once they have not heard from the leader for an election timeout.
+func TestVoteGrantedAfterLease(t *testing.T) {
+	a := newTestRaft(1, []uint64{1, 2, 3}, 10, 1, NewMemoryStorage())
+	b := newTestRaft(2, []uint64{1, 2, 3}, 10, 1, NewMemoryStorage())
+	c := newTestRaft(3, []uint64{1, 2, 3}, 10, 1, NewMemoryStorage())
+	a.checkQuorum = true
+	b.checkQuorum = true
+	c.checkQuorum = true
+
+	nt := newNetwork(a, b, c)
+	nt.send(pb.Message{From: 1, To: 1, Type: pb.MsgHup})
+
+	nt.isolate(1)
+	for i := 0; i < b.electionTimeout; i++ {
+		b.tick()
+	}
+	nt.send(pb.Message{From: 3, To: 3, Type: pb.MsgHup})
+	if c.state != StateLeader {
+		t.Errorf("state = %s, want %s", c.state, StateLeader)
+	}
+}
+
+func TestReadOnlyOptionSafe(t *testing.T) {
+	a := newTestRaft(1, []uint64{1, 2, 3}, 10, 1, NewMemoryStorage())
+	b := newTestRaft(2, []uint64{1, 2, 3}, 10, 1, NewMemoryStorage())
+	c := newTestRaft(3, []uint64{1, 2, 3}, 10, 1, NewMemoryStorage())
+
+	nt := newNetwork(a, b, c)
+	nt.send(pb.Message{From: 1, To: 1, Type: pb.MsgHup})
+	if a.state != StateLeader {
+		t.Fatalf("state = %s, want %s", a.state, StateLeader)
+	}
+
+	tests := []struct {
+		sm        *raft
+		proposals int
+		wri       uint64
+		wctx      []byte
+	}{
+		{a, 10, 11, []byte("ctx1")},
+		{b, 10, 21, []byte("ctx2")},
+		{c, 10, 31, []byte("ctx3")},
+		{a, 10, 41, []byte("ctx4")},
+		{b, 10, 51, []byte("ctx5")},
+		{c, 10, 61, []byte("ctx6")},
+	}
+	for i, tt := range tests {
+		for j := 0; j < tt.proposals; j++ {
+			nt.send(pb.Message{From: 1, To: 1, Type: pb.MsgProp, Entries: []pb.Entry{{}}})
+		}
+
+		nt.send(pb.Message{From: tt.sm.id, To: tt.sm.id, Type: pb.MsgReadIndex, Entries: []pb.Entry{{Data: tt.wctx}}})
+		if len(tt.sm.readStates) != 1 {
+			t.Fatalf("#%d: len(readStates) = %d, want 1", i, len(tt.sm.readStates))
+		}
+		rs := tt.sm.readStates[0]
+		if rs.Index != tt.wri {
+			t.Errorf("#%d: readIndex = %d, want %d", i, rs.Index, tt.wri)
+		}
+		if !bytes.Equal(rs.RequestCtx, tt.wctx) {
+			t.Errorf("#%d: requestCtx = %v, want %v", i, rs.RequestCtx, tt.wctx)
+		}
+		tt.sm.readStates = nil
+	}
+}
+
+func TestReadOnlyOptionLease(t *testing.T) {
+	a := newTestRaft(1, []uint64{1, 2, 3}, 10, 1, NewMemoryStorage())
+	b := newTestRaft(2, []uint64{1, 2, 3}, 10, 1, NewMemoryStorage())
+	c := newTestRaft(3, []uint64{1, 2, 3}, 10, 1, NewMemoryStorage())
+	for _, r := range []*raft{a, b, c} {
+		r.readOnly.option = ReadOnlyLeaseBased
+		r.checkQuorum = true
+	}
+
+	nt := newNetwork(a, b, c)
+	nt.send(pb.Message{From: 1, To: 1, Type: pb.MsgHup})
+	if a.state != StateLeader {
+		t.Fatalf("state = %s, want %s", a.state, StateLeader)
+	}
+
+	tests := []struct {
+		sm        *raft
+		proposals int
+		wri       uint64
+		wctx      []byte
+	}{
+		{a, 10, 11, []byte("ctx1")},
+		{b, 10, 21, []byte("ctx2")},
+		{c, 10, 31, []byte("ctx3")},
+	}
+	for i, tt := range tests {
+		for j := 0; j < tt.proposals; j++ {
+			nt.send(pb.Message{From: 1, To: 1, Type: pb.MsgProp, Entries: []pb.Entry{{}}})
+		}
+
+		nt.send(pb.Message{From: tt.sm.id, To: tt.sm.id, Type: pb.MsgReadIndex, Entries: []pb.Entry{{Data: tt.wctx}}})
+		if len(tt.sm.readStates) != 1 {
+			t.Fatalf("#%d: len(readStates) = %d, want 1", i, len(tt.sm.readStates))
+		}
+		rs := tt.sm.readStates[0]
+		if rs.Index != tt.wri {
+			t.Errorf("#%d: readIndex = %d, want %d", i, rs.Index, tt.wri)
+		}
+		if !bytes.Equal(rs.RequestCtx, tt.wctx) {
+			t.Errorf("#%d: requestCtx = %v, want %v", i, rs.RequestCtx, tt.wctx)
+		}
+		tt.sm.readStates = nil
+	}
+
+	// the leader answers a local read within its lease without any round trip.
+	a.Step(pb.Message{From: 1, To: 1, Type: pb.MsgReadIndex, Entries: []pb.Entry{{Data: []byte("ctx")}}})
+	if msgs := a.readMessages(); len(msgs) != 0 {
+		t.Errorf("msgs = %+v, want none", msgs)
+	}
+	if len(a.readStates) != 1 || a.readStates[0].Index != 31 {
+		t.Errorf("readStates = %+v, want index 31", a.readStates)
+	}
+}
+
+// TestReadIndexBeforeCommitInTerm ensures that the new leader does not serve
+// read requests until it has committed an entry of its own term.
+func TestReadIndexBeforeCommitInTerm(t *testing.T) {
+	for i, option := range []ReadOnlyOption{ReadOnlySafe, ReadOnlyLeaseBased} {
+		r := newTestRaft(1, []uint64{1, 2, 3}, 10, 1, NewMemoryStorage())
+		r.readOnly.option = option
+		r.checkQuorum = true
+		r.becomeCandidate()
+		r.becomeLeader()
+		r.readMessages()
+
+		r.Step(pb.Message{From: 1, To: 1, Type: pb.MsgReadIndex, Entries: []pb.Entry{{Data: []byte("ctx")}}})
+		if len(r.readStates) != 0 {
+			t.Errorf("#%d: readStates = %+v, want none", i, r.readStates)
+		}
+		if msgs := r.readMessages(); len(msgs) != 0 {
+			t.Errorf("#%d: msgs = %+v, want none", i, msgs)
+		}
+	}
+}
+
+func newQuiesceNetwork() (*network, []*raft) {
+	rs := make([]*raft, 3)
+	peers := make([]Interface, 3)
+	for i := range rs {
+		rs[i] = newTestRaft(uint64(i+1), []uint64{1, 2, 3}, 10, 1, NewMemoryStorage())
+		rs[i].quiesce = true
+		peers[i] = rs[i]
+	}
+	nt := newNetwork(peers...)
+	nt.send(pb.Message{From: 1, To: 1, Type: pb.MsgHup})
+	return nt, rs
+}
+
+// TestQuiesceIdleGroup ensures that the idle leader quiesces the group, that
+// the quiesced members stay silent, and that a proposal wakes them up.
+func TestQuiesceIdleGroup(t *testing.T) {
+	nt, rs := newQuiesceNetwork()
+	a := rs[0]
+	if a.state != StateLeader {
+		t.Fatalf("state = %s, want %s", a.state, StateLeader)
+	}
+
+	// the first heartbeat timeout quiesces the idle group.
+	a.tick()
+	msgs := a.readMessages()
+	if len(msgs) != 2 {
+		t.Fatalf("len(msgs) = %d, want 2", len(msgs))
+	}
+	for _, m := range msgs {
+		if m.Type != pb.MsgQuiesce {
+			t.Errorf("type = %s, want %s", m.Type, pb.MsgQuiesce)
+		}
+	}
+	nt.send(msgs...)
+	for i, r := range rs {
+		if !r.quiesced {
+			t.Errorf("#%d: quiesced = false, want true", i)
+		}
+	}
+
+	// nobody sends anything or campaigns while quiesced.
+	for i := 0; i < 2*a.electionTimeout; i++ {
+		for _, r := range rs {
+			r.tick()
+		}
+	}
+	for i, r := range rs {
+		if msgs := r.readMessages(); len(msgs) != 0 {
+			t.Errorf("#%d: msgs = %+v, want none", i, msgs)
+		}
+	}
+	if a.state != StateLeader {
+		t.Errorf("state = %s, want %s", a.state, StateLeader)
+	}
+
+	nt.send(pb.Message{From: 1, To: 1, Type: pb.MsgProp, Entries: []pb.Entry{{Data: []byte("somedata")}}})
+	for i, r := range rs {
+		if r.quiesced {
+			t.Errorf("#%d: quiesced = true, want false", i)
+		}
+		if r.raftLog.committed != 2 {
+			t.Errorf("#%d: committed = %d, want 2", i, r.raftLog.committed)
+		}
+	}
+}
+
+// TestQuiesceNotIdle ensures that the leader keeps sending heartbeats while
+// a follower is behind.
+func TestQuiesceNotIdle(t *testing.T) {
+	nt, rs := newQuiesceNetwork()
+	a := rs[0]
+	nt.isolate(3)
+	nt.send(pb.Message{From: 1, To: 1, Type: pb.MsgProp, Entries: []pb.Entry{{}}})
+
+	a.tick()
+	for _, m := range a.readMessages() {
+		if m.Type != pb.MsgHeartbeat {
+			t.Errorf("type = %s, want %s", m.Type, pb.MsgHeartbeat)
+		}
+	}
+	if a.quiesced {
+		t.Errorf("quiesced = true, want false")
+	}
+}
+
+// TestUnquiesceFollower ensures that a quiesced follower woken up by the
+// application pokes its leader, and campaigns if the leader is gone.
+func TestUnquiesceFollower(t *testing.T) {
+	nt, rs := newQuiesceNetwork()
+	a, b := rs[0], rs[1]
+	a.tick()
+	nt.send(a.readMessages()...)
+
+	b.Step(pb.Message{From: 2, To: 2, Type: pb.MsgUnquiesce})
+	if b.quiesced {
+		t.Fatalf("quiesced = true, want false")
+	}
+	msgs := b.readMessages()
+	wmsgs := []pb.Message{{From: 2, To: 1, Term: b.Term, Type: pb.MsgHeartbeatResp}}
+	if !reflect.DeepEqual(msgs, wmsgs) {
+		t.Fatalf("msgs = %+v, want %+v", msgs, wmsgs)
+	}
+	nt.send(msgs...)
+	if a.quiesced {
+		t.Errorf("leader quiesced = true, want false")
+	}
+
+	// the woken follower campaigns once the leader is gone.
+	nt.isolate(1)
+	for i := 0; i < 2*b.electionTimeout; i++ {
+		b.tick()
+	}
+	if b.state != StateCandidate {
+		t.Errorf("state = %s, want %s", b.state, StateCandidate)
+	}
+}
+
+// TestUncommittedEntryLimit ensures that the leader drops the proposals once
+// its uncommitted entries exceed Config.MaxUncommittedEntriesSize.
+func TestUncommittedEntryLimit(t *testing.T) {
+	const maxEntries = 16
+	testEntry := pb.Entry{Data: []byte("testdata")}
+	maxEntrySize := maxEntries * payloadsSize([]pb.Entry{testEntry})
+
+	cfg := newTestConfig(1, []uint64{1, 2, 3}, 5, 1, NewMemoryStorage())
+	cfg.MaxUncommittedEntriesSize = maxEntrySize
+	r := newRaft(cfg)
+	r.becomeCandidate()
+	r.becomeLeader()
+	if r.uncommittedSize != 0 {
+		t.Fatalf("uncommittedSize = %d, want 0", r.uncommittedSize)
+	}
+
+	// Send proposals to r up to the limit.
+	propMsg := pb.Message{From: 1, To: 1, Type: pb.MsgProp, Entries: []pb.Entry{testEntry}}
+	propEnts := make([]pb.Entry, maxEntries)
+	for i := 0; i < maxEntries; i++ {
+		if err := r.Step(propMsg); err != nil {
+			t.Fatalf("#%d: err = %v, want nil", i, err)
+		}
+		propEnts[i] = testEntry
+	}
+
+	// The proposal over the limit is dropped.
+	if err := r.Step(propMsg); err != ErrProposalDropped {
+		t.Fatalf("err = %v, want %v", err, ErrProposalDropped)
+	}
+
+	// Committing the entries makes room again.
+	r.reduceUncommittedSize(propEnts)
+	if r.uncommittedSize != 0 {
+		t.Fatalf("uncommittedSize = %d, want 0", r.uncommittedSize)
+	}
+
+	// A single large proposal is accepted even though it goes over the limit,
+	// since nothing was uncommitted before it.
+	propEnts = make([]pb.Entry, 2*maxEntries)
+	for i := range propEnts {
+		propEnts[i] = testEntry
+	}
+	propMsgLarge := pb.Message{From: 1, To: 1, Type: pb.MsgProp, Entries: propEnts}
+	if err := r.Step(propMsgLarge); err != nil {
+		t.Fatalf("err = %v, want nil", err)
+	}
+	if err := r.Step(propMsg); err != ErrProposalDropped {
+		t.Fatalf("err = %v, want %v", err, ErrProposalDropped)
+	}
+
+	r.reduceUncommittedSize(propEnts)
+	if r.uncommittedSize != 0 {
+		t.Errorf("uncommittedSize = %d, want 0", r.uncommittedSize)
+	}
+}
+
+// TestCatchUpThrottle ensures that the leader sends at most
+// Config.MaxCatchUpSizePerTick of entries per tick to a newly added follower
+// which is catching up, and resumes the replication on the next tick.
+func TestCatchUpThrottle(t *testing.T) {
+	storage := NewMemoryStorage()
+	ents := make([]pb.Entry, 10)
+	for i := range ents {
+		ents[i] = pb.Entry{Index: uint64(i + 1), Term: 1, Data: []byte("testdata")}
+	}
+	storage.Append(ents)
+	storage.SetHardState(pb.HardState{Term: 1, Commit: 10})
+	entSize := uint64(ents[0].Size())
+
+	cfg := newTestConfig(1, []uint64{1, 2}, 10, 1, storage)
+	cfg.MaxSizePerMsg = entSize
+	cfg.MaxCatchUpSizePerTick = 3 * entSize
+	r := newRaft(cfg)
+	r.becomeCandidate()
+	r.becomeLeader()
+	r.readMessages()
+
+	// the newly added follower has nothing and gets one entry per message.
+	r.removeNode(2)
+	r.addNode(2)
+	pr := r.prs[2]
+	pr.becomeReplicate()
+	for i := 0; i < 5; i++ {
+		r.sendAppend(2)
+	}
+	if n := len(r.readMessages()); n != 3 {
+		t.Fatalf("len(msgs) = %d, want 3", n)
+	}
+	if !pr.IsPaused() {
+		t.Errorf("paused = false, want true")
+	}
+
+	// the next tick renews the budget and resumes the replication.
+	r.tick()
+	var apps []pb.Message
+	for _, m := range r.readMessages() {
+		if m.Type == pb.MsgApp {
+			apps = append(apps, m)
+		}
+	}
+	if len(apps) != 1 || apps[0].Index != 3 {
+		t.Fatalf("apps = %+v, want one append after index 3", apps)
+	}
+	if pr.IsPaused() {
+		t.Errorf("paused = true, want false")
+	}
+
+	// a follower which has all the committed entries is not throttled.
+	pr.catchUpSize = cfg.MaxCatchUpSizePerTick
+	pr.Match = 10
+	pr.becomeReplicate()
+	r.sendAppend(2)
+	if msgs := r.readMessages(); len(msgs) != 1 || len(msgs[0].Entries) != 1 {
+		t.Errorf("msgs = %+v, want one append of the last entry", msgs)
+	}
+}
+
+// TestCatchUpThrottleLaggingFollower ensures that the leader does not
+// throttle a follower which lags behind in the normal replication, and
+// stops throttling a newly added one after a few election timeouts.
+func TestCatchUpThrottleLaggingFollower(t *testing.T) {
+	storage := NewMemoryStorage()
+	ents := make([]pb.Entry, 10)
+	for i := range ents {
+		ents[i] = pb.Entry{Index: uint64(i + 1), Term: 1, Data: []byte("testdata")}
+	}
+	storage.Append(ents)
+	storage.SetHardState(pb.HardState{Term: 1, Commit: 10})
+	entSize := uint64(ents[0].Size())
+
+	cfg := newTestConfig(1, []uint64{1, 2, 3}, 10, 1, storage)
+	cfg.MaxSizePerMsg = entSize
+	cfg.MaxCatchUpSizePerTick = 3 * entSize
+	r := newRaft(cfg)
+	r.becomeCandidate()
+	r.becomeLeader()
+	r.readMessages()
+
+	// the lagging follower gets all the entries in the replication.
+	pr := r.prs[2]
+	pr.becomeReplicate()
+	for i := 0; i < 5; i++ {
+		r.sendAppend(2)
+	}
+	if n := len(r.readMessages()); n != 5 {
+		t.Fatalf("len(msgs) = %d, want 5", n)
+	}
+	if pr.IsPaused() {
+		t.Errorf("paused = true, want false")
+	}
+
+	// the newly added follower is throttled until a few election timeouts
+	// have passed.
+	r.removeNode(3)
+	r.addNode(3)
+	for i := 0; i < catchUpElectionTimeouts*r.electionTimeout; i++ {
+		r.tick()
+	}
+	r.readMessages()
+	pr = r.prs[3]
+	pr.becomeReplicate()
+	for i := 0; i < 5; i++ {
+		r.sendAppend(3)
+	}
+	if n := len(r.readMessages()); n != 5 {
+		t.Fatalf("len(msgs) = %d, want 5", n)
+	}
+}
+
+// TestFastLogRejection ensures that the leader uses the hint of the rejected
+// MsgAppResp to skip the conflicting entries in one round trip.
+func TestFastLogRejection(t *testing.T) {
+	tests := []struct {
+		rejectHint uint64
+		logTerm    uint64
+		wnext      uint64
+	}{
+		// the follower has the entries of term 3 up to index 7: all the
+		// entries of the leader after the last one of term 2 conflict.
+		{6, 3, 4},
+		// the follower has the entries of term 2 up to index 7.
+		{6, 2, 4},
+		// the follower has only the first entry.
+		{1, 1, 2},
+		// the follower sends no hint term but its last index.
+		{7, 0, 6},
+	}
+	for i, tt := range tests {
+		storage := NewMemoryStorage()
+		// the leader log: terms 1, 2, 2, 4, 4, 4 and the empty entry of term 6.
+		storage.Append([]pb.Entry{{Index: 1, Term: 1}, {Index: 2, Term: 2}, {Index: 3, Term: 2},
+			{Index: 4, Term: 4}, {Index: 5, Term: 4}, {Index: 6, Term: 4}})
+		r := newTestRaft(1, []uint64{1, 2}, 10, 1, storage)
+		r.loadState(pb.HardState{Term: 5})
+		r.becomeCandidate()
+		r.becomeLeader()
+		r.readMessages()
+
+		// the leader probes the follower at index 6.
+		r.Step(pb.Message{From: 2, To: 1, Type: pb.MsgAppResp, Term: r.Term, Index: 6, Reject: true,
+			RejectHint: tt.rejectHint, LogTerm: tt.logTerm})
+		if g := r.prs[2].Next; g != tt.wnext {
+			t.Errorf("#%d: next = %d, want %d", i, g, tt.wnext)
+		}
+		msgs := r.readMessages()
+		if len(msgs) != 1 || msgs[0].Type != pb.MsgApp || msgs[0].Index != tt.wnext-1 {
+			t.Errorf("#%d: msgs = %+v, want a MsgApp at index %d", i, msgs, tt.wnext-1)
+		}
+	}
+}
+
+func TestLeaderTransferToUpToDateNode(t *testing.T) {
+	nt := newNetwork(nil, nil, nil)
+	nt.send(pb.Message{From: 1, To: 1, Type: pb.MsgHup})
+
+	lead := nt.peers[1].(*raft)
+	if lead.lead != 1 {
+		t.Fatalf("after election leader is %x, want 1", lead.lead)
+	}
+
+	// Transfer leadership to 2.
+	nt.send(pb.Message{From: 2, To: 1, Type: pb.MsgTransferLeader})
+	checkLeaderTransferState(t, lead, StateFollower, 2)
+
+	// After some log replication, transfer leadership back to 1.
+	nt.send(pb.Message{From: 1, To: 1, Type: pb.MsgProp, Entries: []pb.Entry{{}}})
+	nt.send(pb.Message{From: 1, To: 2, Type: pb.MsgTransferLeader})
+	checkLeaderTransferState(t, lead, StateLeader, 1)
+}
+
+func TestLeaderTransferToSlowFollower(t *testing.T) {
+	nt := newNetwork(nil, nil, nil)
+	nt.send(pb.Message{From: 1, To: 1, Type: pb.MsgHup})
+
+	nt.isolate(3)
+	nt.send(pb.Message{From: 1, To: 1, Type: pb.MsgProp, Entries: []pb.Entry{{}}})
+
+	nt.recover()
+	lead := nt.peers[1].(*raft)
+	if lead.prs[3].Match != 1 {
+		t.Fatalf("node 1 has match %x for node 3, want %x", lead.prs[3].Match, 1)
+	}
+
+	// Transfer leadership to 3 when node 3 is lack of log.
+	nt.send(pb.Message{From: 3, To: 1, Type: pb.MsgTransferLeader})
+	checkLeaderTransferState(t, lead, StateFollower, 3)
+}
+
+func TestLeaderTransferToSelf(t *testing.T) {
+	nt := newNetwork(nil, nil, nil)
+	nt.send(pb.Message{From: 1, To: 1, Type: pb.MsgHup})
+
+	lead := nt.peers[1].(*raft)
+
+	// Transfer leadership to self, there will be noop.
+	nt.send(pb.Message{From: 1, To: 1, Type: pb.MsgTransferLeader})
+	checkLeaderTransferState(t, lead, StateLeader, 1)
+}
+
+func TestLeaderTransferToNonExistingNode(t *testing.T) {
+	nt := newNetwork(nil, nil, nil)
+	nt.send(pb.Message{From: 1, To: 1, Type: pb.MsgHup})
+
+	lead := nt.peers[1].(*raft)
+	// Transfer leadership to non-existing node, there will be noop.
+	nt.send(pb.Message{From: 4, To: 1, Type: pb.MsgTransferLeader})
+	checkLeaderTransferState(t, lead, StateLeader, 1)
+}
+
+func TestLeaderTransferTimeout(t *testing.T) {
+	nt := newNetwork(nil, nil, nil)
+	nt.send(pb.Message{From: 1, To: 1, Type: pb.MsgHup})
+
+	nt.isolate(3)
+
+	lead := nt.peers[1].(*raft)
+
+	// Transfer leadership to isolated node, wait for timeout.
+	nt.send(pb.Message{From: 3, To: 1, Type: pb.MsgTransferLeader})
+	if lead.leadTransferee != 3 {
+		t.Fatalf("wait transferring, leadTransferee = %v, want %v", lead.leadTransferee, 3)
+	}
+	for i := 0; i < lead.heartbeatTimeout; i++ {
+		lead.tick()
+	}
+	if lead.leadTransferee != 3 {
+		t.Fatalf("wait transferring, leadTransferee = %v, want %v", lead.leadTransferee, 3)
+	}
+
+	for i := 0; i < lead.electionTimeout-lead.heartbeatTimeout; i++ {
+		lead.tick()
+	}
+
+	checkLeaderTransferState(t, lead, StateLeader, 1)
+}
+
+func TestLeaderTransferIgnoreProposal(t *testing.T) {
+	nt := newNetwork(nil, nil, nil)
+	nt.send(pb.Message{From: 1, To: 1, Type: pb.MsgHup})
+
+	nt.isolate(3)
+
+	lead := nt.peers[1].(*raft)
+
+	// Transfer leadership to isolated node to let transfer pending, then send proposal.
+	nt.send(pb.Message{From: 3, To: 1, Type: pb.MsgTransferLeader})
+	if lead.leadTransferee != 3 {
+		t.Fatalf("wait transferring, leadTransferee = %v, want %v", lead.leadTransferee, 3)
+	}
+
+	nt.send(pb.Message{From: 1, To: 1, Type: pb.MsgProp, Entries: []pb.Entry{{}}})
+	if lead.prs[1].Match != 1 {
+		t.Fatalf("node 1 has match %x, want %x", lead.prs[1].Match, 1)
+	}
+}
+
+func TestLeaderTransferReceiveHigherTermVote(t *testing.T) {
+	nt := newNetwork(nil, nil, nil)
+	nt.send(pb.Message{From: 1, To: 1, Type: pb.MsgHup})
+
+	nt.isolate(3)
+
+	lead := nt.peers[1].(*raft)
+
+	// Transfer leadership to isolated node to let transfer pending.
+	nt.send(pb.Message{From: 3, To: 1, Type: pb.MsgTransferLeader})
+	if lead.leadTransferee != 3 {
+		t.Fatalf("wait transferring, leadTransferee = %v, want %v", lead.leadTransferee, 3)
+	}
+
+	nt.send(pb.Message{From: 2, To: 2, Type: pb.MsgHup})
+
+	checkLeaderTransferState(t, lead, StateFollower, 2)
+}
+
+func TestLeaderTransferRemoveNode(t *testing.T) {
+	nt := newNetwork(nil, nil, nil)
+	nt.send(pb.Message{From: 1, To: 1, Type: pb.MsgHup})
+
+	nt.ignore(pb.MsgTimeoutNow)
+
+	lead := nt.peers[1].(*raft)
+
+	// The leadTransferee is removed when leadship transferring.
+	nt.send(pb.Message{From: 3, To: 1, Type: pb.MsgTransferLeader})
+	if lead.leadTransferee != 3 {
+		t.Fatalf("wait transferring, leadTransferee = %v, want %v", lead.leadTransferee, 3)
+	}
+
+	lead.removeNode(3)
+
+	checkLeaderTransferState(t, lead, StateLeader, 1)
+}
+
+func TestLeaderTransferSecondTransferToAnotherNode(t *testing.T) {
+	nt := newNetwork(nil, nil, nil)
+	nt.send(pb.Message{From: 1, To: 1, Type: pb.MsgHup})
+
+	nt.isolate(3)
+
+	lead := nt.peers[1].(*raft)
+
+	nt.send(pb.Message{From: 3, To: 1, Type: pb.MsgTransferLeader})
+	if lead.leadTransferee != 3 {
+		t.Fatalf("wait transferring, leadTransferee = %v, want %v", lead.leadTransferee, 3)
+	}
+
+	// Transfer leadership to another node.
+	nt.send(pb.Message{From: 2, To: 1, Type: pb.MsgTransferLeader})
+
+	checkLeaderTransferState(t, lead, StateFollower, 2)
+}
+
+func TestLeaderTransferByFollower(t *testing.T) {
+	nt := newNetwork(nil, nil, nil)
+	nt.send(pb.Message{From: 1, To: 1, Type: pb.MsgHup})
+
+	lead := nt.peers[1].(*raft)
+
+	// The follower forwards the request to the leader.
+	nt.send(pb.Message{From: 3, To: 2, Type: pb.MsgTransferLeader})
+
+	checkLeaderTransferState(t, lead, StateFollower, 3)
+}
+
+// TestConfigLogger tests that raft and its log write to the logger of the
+// config instead of the package logger.
+func TestConfigLogger(t *testing.T) {
+	var buf bytes.Buffer
+	c := newTestConfig(1, []uint64{1, 2}, 10, 1, NewMemoryStorage())
+	c.Logger = &DefaultLogger{Logger: log.New(&buf, "", 0)}
+	r := newRaft(c)
+
+	r.becomeCandidate()
+	if !strings.Contains(buf.String(), "became candidate at term 1") {
+		t.Errorf("log = %q, want the candidate transition", buf.String())
+	}
+	buf.Reset()
+	r.raftLog.restore(pb.Snapshot{Metadata: pb.SnapshotMetadata{Index: 5, Term: 1}})
+	if !strings.Contains(buf.String(), "starts to restore snapshot") {
+		t.Errorf("log = %q, want the snapshot restore", buf.String())
+	}
+}
+
+func checkLeaderTransferState(t *testing.T, r *raft, state StateType, lead uint64) {
+	if r.state != state || r.lead != lead {
+		t.Fatalf("after transferring, node has state %v lead %v, want state %v lead %v", r.state, r.lead, state, lead)
+	}
+	if r.leadTransferee != None {
+		t.Fatalf("after transferring, node has leadTransferee %v, want leadTransferee %v", r.leadTransferee, None)
+	}
+}
+
 func ents(terms ...uint64) *raft {
+	return entsWithConfig(nil, terms...)
+}
+
+func entsWithConfig(configFunc func(*Config), terms ...uint64) *raft {
 	storage := NewMemoryStorage()
 	for i, term := range terms {
 		storage.Append([]pb.Entry{{Index: uint64(i + 1), Term: term}})
 	}
-	sm := newTestRaft(1, []uint64{}, 5, 1, storage)
+	cfg := newTestConfig(1, []uint64{}, 5, 1, storage)
+	if configFunc != nil {
+		configFunc(cfg)
+	}
+	sm := newRaft(cfg)
 	sm.reset(0)
 	return sm
 }
@@ -1769,6 +2818,12 @@ type network struct {
 // A *stateMachine will get its k, id.
 // When using stateMachine, the address list is always [1, n].
 func newNetwork(peers ...Interface) *network {
+	return newNetworkWithConfig(nil, peers...)
+}
+
+// newNetworkWithConfig is like newNetwork but calls the given func to
+// modify the configuration of any state machines it creates.
+func newNetworkWithConfig(configFunc func(*Config), peers ...Interface) *network {
 	size := len(peers)
 	peerAddrs := idsBySize(size)
 
@@ -1780,7 +2835,11 @@ func newNetwork(peers ...Interface) *network {
 		switch v := p.(type) {
 		case nil:
 			nstorage[id] = NewMemoryStorage()
-			sm := newTestRaft(id, peerAddrs, 10, 1, nstorage[id])
+			cfg := newTestConfig(id, peerAddrs, 10, 1, nstorage[id])
+			if configFunc != nil {
+				configFunc(cfg)
+			}
+			sm := newRaft(cfg)
 			npeers[id] = sm
 		case *raft:
 			v.id = id
@@ -1893,6 +2952,10 @@ func newTestConfig(id uint64, peers []uint64, election, heartbeat int, storage S
 	}
 }
 
+func preVoteConfig(c *Config) {
+	c.PreVote = true
+}
+
 func newTestRaft(id uint64, peers []uint64, election, heartbeat int, storage Storage) *raft {
 	return newRaft(newTestConfig(id, peers, election, heartbeat, storage))
 }
@@ -68,18 +68,27 @@ func (x *EntryType) UnmarshalJSON(data []byte) error {
 type MessageType int32
 
 const (
-	MsgHup           MessageType = 0
-	MsgBeat          MessageType = 1
-	MsgProp          MessageType = 2
-	MsgApp           MessageType = 3
-	MsgAppResp       MessageType = 4
-	MsgVote          MessageType = 5
-	MsgVoteResp      MessageType = 6
-	MsgSnap          MessageType = 7
-	MsgHeartbeat     MessageType = 8
-	MsgHeartbeatResp MessageType = 9
-	MsgUnreachable   MessageType = 10
-	MsgSnapStatus    MessageType = 11
+	MsgHup            MessageType = 0
+	MsgBeat           MessageType = 1
+	MsgProp           MessageType = 2
+	MsgApp            MessageType = 3
+	MsgAppResp        MessageType = 4
+	MsgVote           MessageType = 5
+	MsgVoteResp       MessageType = 6
+	MsgSnap           MessageType = 7
+	MsgHeartbeat      MessageType = 8
+	MsgHeartbeatResp  MessageType = 9
+	MsgUnreachable    MessageType = 10
+	MsgSnapStatus     MessageType = 11
+	MsgTransferLeader MessageType = 12
+	MsgTimeoutNow     MessageType = 13
+	MsgPreVote        MessageType = 14
+	MsgPreVoteResp    MessageType = 15
+	MsgCheckQuorum    MessageType = 16
+	MsgReadIndex      MessageType = 17
+	MsgReadIndexResp  MessageType = 18
+	MsgQuiesce        MessageType = 19
+	MsgUnquiesce      MessageType = 20
 )
 
 var MessageType_name = map[int32]string{
@@ -95,20 +104,38 @@ var MessageType_name = map[int32]string{
 	9:  "MsgHeartbeatResp",
 	10: "MsgUnreachable",
 	11: "MsgSnapStatus",
+	12: "MsgTransferLeader",
+	13: "MsgTimeoutNow",
+	14: "MsgPreVote",
+	15: "MsgPreVoteResp",
+	16: "MsgCheckQuorum",
+	17: "MsgReadIndex",
+	18: "MsgReadIndexResp",
+	19: "MsgQuiesce",
+	20: "MsgUnquiesce",
 }
 var MessageType_value = map[string]int32{
-	"MsgHup":           0,
-	"MsgBeat":          1,
-	"MsgProp":          2,
-	"MsgApp":           3,
-	"MsgAppResp":       4,
-	"MsgVote":          5,
-	"MsgVoteResp":      6,
-	"MsgSnap":          7,
-	"MsgHeartbeat":     8,
-	"MsgHeartbeatResp": 9,
-	"MsgUnreachable":   10,
-	"MsgSnapStatus":    11,
+	"MsgHup":            0,
+	"MsgBeat":           1,
+	"MsgProp":           2,
+	"MsgApp":            3,
+	"MsgAppResp":        4,
+	"MsgVote":           5,
+	"MsgVoteResp":       6,
+	"MsgSnap":           7,
+	"MsgHeartbeat":      8,
+	"MsgHeartbeatResp":  9,
+	"MsgUnreachable":    10,
+	"MsgSnapStatus":     11,
+	"MsgTransferLeader": 12,
+	"MsgTimeoutNow":     13,
+	"MsgPreVote":        14,
+	"MsgPreVoteResp":    15,
+	"MsgCheckQuorum":    16,
+	"MsgReadIndex":      17,
+	"MsgReadIndexResp":  18,
+	"MsgQuiesce":        19,
+	"MsgUnquiesce":      20,
 }
 
 func (x MessageType) Enum() *MessageType {
@@ -134,17 +161,20 @@ const (
 	ConfChangeAddNode    ConfChangeType = 0
 	ConfChangeRemoveNode ConfChangeType = 1
 	ConfChangeUpdateNode ConfChangeType = 2
+	ConfChangeAddWitness ConfChangeType = 3
 )
 
 var ConfChangeType_name = map[int32]string{
 	0: "ConfChangeAddNode",
 	1: "ConfChangeRemoveNode",
 	2: "ConfChangeUpdateNode",
+	3: "ConfChangeAddWitness",
 }
 var ConfChangeType_value = map[string]int32{
 	"ConfChangeAddNode":    0,
 	"ConfChangeRemoveNode": 1,
 	"ConfChangeUpdateNode": 2,
+	"ConfChangeAddWitness": 3,
 }
 
 func (x ConfChangeType) Enum() *ConfChangeType {
@@ -209,6 +239,7 @@ type Message struct {
 	Snapshot         Snapshot    `protobuf:"bytes,9,req,name=snapshot" json:"snapshot"`
 	Reject           bool        `protobuf:"varint,10,req,name=reject" json:"reject"`
 	RejectHint       uint64      `protobuf:"varint,11,req,name=rejectHint" json:"rejectHint"`
+	Context          []byte      `protobuf:"bytes,12,opt,name=context" json:"context"`
 	XXX_unrecognized []byte      `json:"-"`
 }
 
@@ -229,6 +260,7 @@ func (*HardState) ProtoMessage()    {}
 
 type ConfState struct {
 	Nodes            []uint64 `protobuf:"varint,1,rep,name=nodes" json:"nodes"`
+	Witnesses        []uint64 `protobuf:"varint,2,rep,name=witnesses" json:"witnesses"`
 	XXX_unrecognized []byte   `json:"-"`
 }
 
@@ -749,6 +781,28 @@ func (m *Message) Unmarshal(data []byte) error {
 					break
 				}
 			}
+		case 12:
+			if wireType != 2 {
+				return fmt.Errorf("proto: wrong wireType = %d for field Context", wireType)
+			}
+			var byteLen int
+			for shift := uint(0); ; shift += 7 {
+				if index >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := data[index]
+				index++
+				byteLen |= (int(b) & 0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+			postIndex := index + byteLen
+			if postIndex > l {
+				return io.ErrUnexpectedEOF
+			}
+			m.Context = append([]byte{}, data[index:postIndex]...)
+			index = postIndex
 		default:
 			var sizeOfWire int
 			for {
@@ -895,6 +949,23 @@ func (m *ConfState) Unmarshal(data []byte) error {
 				}
 			}
 			m.Nodes = append(m.Nodes, v)
+		case 2:
+			if wireType != 0 {
+				return fmt.Errorf("proto: wrong wireType = %d for field Witnesses", wireType)
+			}
+			var v uint64
+			for shift := uint(0); ; shift += 7 {
+				if index >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := data[index]
+				index++
+				v |= (uint64(b) & 0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+			m.Witnesses = append(m.Witnesses, v)
 		default:
 			var sizeOfWire int
 			for {
@@ -1091,6 +1162,10 @@ func (m *Message) Size() (n int) {
 	n += 1 + l + sovRaft(uint64(l))
 	n += 2
 	n += 1 + sovRaft(uint64(m.RejectHint))
+	if m.Context != nil {
+		l = len(m.Context)
+		n += 1 + l + sovRaft(uint64(l))
+	}
 	if m.XXX_unrecognized != nil {
 		n += len(m.XXX_unrecognized)
 	}
@@ -1117,6 +1192,11 @@ func (m *ConfState) Size() (n int) {
 			n += 1 + sovRaft(uint64(e))
 		}
 	}
+	if len(m.Witnesses) > 0 {
+		for _, e := range m.Witnesses {
+			n += 1 + sovRaft(uint64(e))
+		}
+	}
 	if m.XXX_unrecognized != nil {
 		n += len(m.XXX_unrecognized)
 	}
@@ -1325,6 +1405,12 @@ func (m *Message) MarshalTo(data []byte) (n int, err error) {
 	data[i] = 0x58
 	i++
 	i = encodeVarintRaft(data, i, uint64(m.RejectHint))
+	if m.Context != nil {
+		data[i] = 0x62
+		i++
+		i = encodeVarintRaft(data, i, uint64(len(m.Context)))
+		i += copy(data[i:], m.Context)
+	}
 	if m.XXX_unrecognized != nil {
 		i += copy(data[i:], m.XXX_unrecognized)
 	}
@@ -1383,6 +1469,13 @@ func (m *ConfState) MarshalTo(data []byte) (n int, err error) {
 			i = encodeVarintRaft(data, i, uint64(num))
 		}
 	}
+	if len(m.Witnesses) > 0 {
+		for _, num := range m.Witnesses {
+			data[i] = 0x10
+			i++
+			i = encodeVarintRaft(data, i, uint64(num))
+		}
+	}
 	if m.XXX_unrecognized != nil {
 		i += copy(data[i:], m.XXX_unrecognized)
 	}
@@ -2,7 +2,6 @@ package rafttest
 
 import (
 	"testing"
-	"time"
 
 	"github.com/coreos/etcd/Godeps/_workspace/src/golang.org/x/net/context"
 	"github.com/coreos/etcd/raft"
@@ -19,11 +18,11 @@ func BenchmarkProposal3Nodes(b *testing.B) {
 		nodes = append(nodes, n)
 	}
 	// get ready and warm up
-	time.Sleep(50 * time.Millisecond)
+	l := waitLeader(nodes)
 
 	b.ResetTimer()
 	for i := 0; i < b.N; i++ {
-		nodes[0].Propose(context.TODO(), []byte("somedata"))
+		nodes[l].Propose(context.TODO(), []byte("somedata"))
 	}
 
 	for _, n := range nodes {
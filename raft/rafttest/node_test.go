@@ -19,10 +19,10 @@ func TestBasicProgress(t *testing.T) {
 		nodes = append(nodes, n)
 	}
 
-	time.Sleep(10 * time.Millisecond)
+	l := waitLeader(nodes)
 
 	for i := 0; i < 10000; i++ {
-		nodes[0].Propose(context.TODO(), []byte("somedata"))
+		nodes[l].Propose(context.TODO(), []byte("somedata"))
 	}
 
 	time.Sleep(500 * time.Millisecond)
@@ -45,23 +45,24 @@ func TestRestart(t *testing.T) {
 		nodes = append(nodes, n)
 	}
 
-	time.Sleep(50 * time.Millisecond)
+	l := waitLeader(nodes)
+	k1, k2 := (l+1)%len(nodes), (l+2)%len(nodes)
 	for i := 0; i < 300; i++ {
-		nodes[0].Propose(context.TODO(), []byte("somedata"))
+		nodes[l].Propose(context.TODO(), []byte("somedata"))
 	}
-	nodes[1].stop()
+	nodes[k1].stop()
 	for i := 0; i < 300; i++ {
-		nodes[0].Propose(context.TODO(), []byte("somedata"))
+		nodes[l].Propose(context.TODO(), []byte("somedata"))
 	}
-	nodes[2].stop()
+	nodes[k2].stop()
 	for i := 0; i < 300; i++ {
-		nodes[0].Propose(context.TODO(), []byte("somedata"))
+		nodes[l].Propose(context.TODO(), []byte("somedata"))
 	}
-	nodes[2].restart()
+	nodes[k2].restart()
 	for i := 0; i < 300; i++ {
-		nodes[0].Propose(context.TODO(), []byte("somedata"))
+		nodes[l].Propose(context.TODO(), []byte("somedata"))
 	}
-	nodes[1].restart()
+	nodes[k1].restart()
 
 	// give some time for nodes to catch up with the raft leader
 	time.Sleep(500 * time.Millisecond)
@@ -84,23 +85,24 @@ func TestPause(t *testing.T) {
 		nodes = append(nodes, n)
 	}
 
-	time.Sleep(50 * time.Millisecond)
+	l := waitLeader(nodes)
+	k1, k2 := (l+1)%len(nodes), (l+2)%len(nodes)
 	for i := 0; i < 300; i++ {
-		nodes[0].Propose(context.TODO(), []byte("somedata"))
+		nodes[l].Propose(context.TODO(), []byte("somedata"))
 	}
-	nodes[1].pause()
+	nodes[k1].pause()
 	for i := 0; i < 300; i++ {
-		nodes[0].Propose(context.TODO(), []byte("somedata"))
+		nodes[l].Propose(context.TODO(), []byte("somedata"))
 	}
-	nodes[2].pause()
+	nodes[k2].pause()
 	for i := 0; i < 300; i++ {
-		nodes[0].Propose(context.TODO(), []byte("somedata"))
+		nodes[l].Propose(context.TODO(), []byte("somedata"))
 	}
-	nodes[2].resume()
+	nodes[k2].resume()
 	for i := 0; i < 300; i++ {
-		nodes[0].Propose(context.TODO(), []byte("somedata"))
+		nodes[l].Propose(context.TODO(), []byte("somedata"))
 	}
-	nodes[1].resume()
+	nodes[k1].resume()
 
 	// give some time for nodes to catch up with the raft leader
 	time.Sleep(300 * time.Millisecond)
@@ -111,3 +113,23 @@ func TestPause(t *testing.T) {
 		}
 	}
 }
+
+// waitLeader waits until all the nodes agree on a leader, and returns the
+// index of the leader in ns. The proposals are dropped without a leader.
+func waitLeader(ns []*node) int {
+	for {
+		leads := make(map[uint64]struct{})
+		lindex := -1
+		for i, n := range ns {
+			lead := n.Status().Lead
+			leads[lead] = struct{}{}
+			if n.id == lead {
+				lindex = i
+			}
+		}
+		if len(leads) == 1 && lindex != -1 {
+			return lindex
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
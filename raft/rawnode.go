@@ -0,0 +1,215 @@
+// Copyright 2015 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package raft
+
+import (
+	"errors"
+
+	pb "github.com/coreos/etcd/raft/raftpb"
+)
+
+var (
+	// ErrStepLocalMsg is returned when stepping a local raft message.
+	ErrStepLocalMsg = errors.New("raft: cannot step raft local message")
+	// ErrStepPeerNotFound is returned when stepping a response message
+	// from a peer that is not found in the progress of the raft.
+	ErrStepPeerNotFound = errors.New("raft: cannot step as peer not found")
+)
+
+// RawNode is a thread-unsafe Node.
+// The methods of this struct correspond to the methods of Node and are
+// described more fully there. Unlike Node, RawNode runs no goroutine:
+// the application calls Ready and Advance from its own loop, e.g. to
+// drive many raft groups from a few goroutines.
+// 不启动goroutine的Node，由应用在自己的事件循环中同步调用
+type RawNode struct {
+	raft       *raft
+	prevSoftSt *SoftState
+	prevHardSt pb.HardState
+}
+
+// NewRawNode returns a new RawNode given configuration and a list of raft
+// peers. If the storage of the config is empty, the peers are bootstrapped
+// as StartNode does; otherwise they must be empty, and the state is
+// restored from the storage as RestartNode does.
+func NewRawNode(c *Config, peers []Peer) (*RawNode, error) {
+	if err := c.validate(); err != nil {
+		return nil, err
+	}
+	r := newRaft(c)
+	lastIndex, err := c.Storage.LastIndex()
+	if err != nil {
+		return nil, err
+	}
+	if lastIndex == 0 {
+		bootstrap(r, peers)
+	} else if len(peers) > 0 {
+		return nil, errors.New("cannot bootstrap peers over an existing log")
+	}
+	return &RawNode{
+		raft:       r,
+		prevSoftSt: r.softState(),
+		prevHardSt: r.HardState,
+	}, nil
+}
+
+// Tick advances the internal logical clock by a single tick.
+func (rn *RawNode) Tick() { rn.raft.tick() }
+
+// Campaign causes this RawNode to transition to candidate state.
+func (rn *RawNode) Campaign() error {
+	return rn.raft.Step(pb.Message{Type: pb.MsgHup})
+}
+
+// Propose proposes data be appended to the raft log. ErrProposalDropped
+// is returned if there is no leader or the leader has too many uncommitted
+// entries.
+func (rn *RawNode) Propose(data []byte) error {
+	return rn.raft.Step(pb.Message{
+		Type:    pb.MsgProp,
+		From:    rn.raft.id,
+		Entries: []pb.Entry{{Data: data}},
+	})
+}
+
+// ProposeConfChange proposes a config change.
+func (rn *RawNode) ProposeConfChange(cc pb.ConfChange) error {
+	data, err := cc.Marshal()
+	if err != nil {
+		return err
+	}
+	return rn.raft.Step(pb.Message{
+		Type:    pb.MsgProp,
+		From:    rn.raft.id,
+		Entries: []pb.Entry{{Type: pb.EntryConfChange, Data: data}},
+	})
+}
+
+// ApplyConfChange applies a config change to the local node.
+func (rn *RawNode) ApplyConfChange(cc pb.ConfChange) *pb.ConfState {
+	if cc.NodeID == None {
+		rn.raft.resetPendingConf()
+		cs := rn.raft.confState()
+		return &cs
+	}
+	switch cc.Type {
+	case pb.ConfChangeAddNode:
+		rn.raft.addNode(cc.NodeID)
+	case pb.ConfChangeAddWitness:
+		rn.raft.addWitness(cc.NodeID)
+	case pb.ConfChangeRemoveNode:
+		rn.raft.removeNode(cc.NodeID)
+	case pb.ConfChangeUpdateNode:
+		rn.raft.resetPendingConf()
+	default:
+		panic("unexpected conf type")
+	}
+	cs := rn.raft.confState()
+	return &cs
+}
+
+// Step advances the state machine using the given message.
+func (rn *RawNode) Step(m pb.Message) error {
+	// ignore unexpected local messages receiving over network
+	if IsLocalMsg(m) {
+		return ErrStepLocalMsg
+	}
+	// filter out response message from unknown From.
+	if _, ok := rn.raft.prs[m.From]; ok || !IsResponseMsg(m) {
+		return rn.raft.Step(m)
+	}
+	return ErrStepPeerNotFound
+}
+
+// ReadIndex requests a read state. The read state will be set in Ready.
+// rctx is attached to the read state so that the application can match it
+// with the read request.
+func (rn *RawNode) ReadIndex(rctx []byte) {
+	rn.raft.Step(pb.Message{Type: pb.MsgReadIndex, Entries: []pb.Entry{{Data: rctx}}})
+}
+
+// Ready returns the current point-in-time state of this RawNode.
+// The application must call Advance with it once it has been handled.
+func (rn *RawNode) Ready() Ready {
+	rd := newReady(rn.raft, rn.prevSoftSt, rn.prevHardSt)
+	rn.raft.msgs = nil
+	rn.raft.readStates = nil
+	return rd
+}
+
+// HasReady tells whether Ready would return any updates; it is cheaper
+// than calling Ready.
+func (rn *RawNode) HasReady() bool {
+	r := rn.raft
+	if !r.softState().equal(rn.prevSoftSt) {
+		return true
+	}
+	if !IsEmptyHardState(r.HardState) && !isHardStateEqual(r.HardState, rn.prevHardSt) {
+		return true
+	}
+	if r.raftLog.unstable.snapshot != nil {
+		return true
+	}
+	return len(r.msgs) > 0 || len(r.raftLog.unstableEntries()) > 0 ||
+		len(r.raftLog.nextEnts()) > 0 || len(r.readStates) != 0
+}
+
+// Advance notifies the RawNode that the application has applied and saved
+// progress in the last Ready results.
+func (rn *RawNode) Advance(rd Ready) {
+	if rd.SoftState != nil {
+		rn.prevSoftSt = rd.SoftState
+	}
+	if !IsEmptyHardState(rd.HardState) {
+		rn.prevHardSt = rd.HardState
+	}
+	if rn.prevHardSt.Commit != 0 {
+		rn.raft.raftLog.appliedTo(rn.prevHardSt.Commit)
+	}
+	rn.raft.reduceUncommittedSize(rd.CommittedEntries)
+	if len(rd.Entries) > 0 {
+		e := rd.Entries[len(rd.Entries)-1]
+		rn.raft.raftLog.stableTo(e.Index, e.Term)
+	}
+	if !IsEmptySnap(rd.Snapshot) {
+		rn.raft.raftLog.stableSnapTo(rd.Snapshot.Metadata.Index)
+	}
+}
+
+// Status returns the current status of the given group.
+func (rn *RawNode) Status() Status { return getStatus(rn.raft) }
+
+// ReportUnreachable reports the given node is not reachable for the last send.
+func (rn *RawNode) ReportUnreachable(id uint64) {
+	rn.raft.Step(pb.Message{Type: pb.MsgUnreachable, From: id})
+}
+
+// ReportSnapshot reports the status of the sent snapshot.
+func (rn *RawNode) ReportSnapshot(id uint64, status SnapshotStatus) {
+	rej := status == SnapshotFailure
+	rn.raft.Step(pb.Message{Type: pb.MsgSnapStatus, From: id, Reject: rej})
+}
+
+// Unquiesce wakes the RawNode up if it has been quiesced, see Config.Quiesce.
+func (rn *RawNode) Unquiesce() {
+	rn.raft.Step(pb.Message{Type: pb.MsgUnquiesce})
+}
+
+// TransferLeadership attempts to transfer leadership to the given transferee.
+// lead is the ID of the current leader as known by the caller.
+func (rn *RawNode) TransferLeadership(lead, transferee uint64) {
+	// manually set 'from' and 'to', so that leader can voluntarily transfers its leadership
+	rn.raft.Step(pb.Message{Type: pb.MsgTransferLeader, From: transferee, To: lead})
+}
@@ -0,0 +1,111 @@
+// Copyright 2015 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package raft
+
+import pb "github.com/coreos/etcd/raft/raftpb"
+
+// ReadState provides state for read only query.
+// It is the caller's responsibility to call ReadIndex first before getting
+// this state from Ready. RequestCtx is the context passed to ReadIndex,
+// so the application can match the state with its pending request.
+// The application may serve the read once its applied index reaches Index.
+type ReadState struct {
+	Index      uint64
+	RequestCtx []byte
+}
+
+type readIndexStatus struct {
+	req   pb.Message
+	index uint64
+	acks  map[uint64]struct{}
+}
+
+// readOnly tracks the read only requests waiting for a quorum of
+// heartbeat acknowledgements on the leader.
+type readOnly struct {
+	option           ReadOnlyOption
+	pendingReadIndex map[string]*readIndexStatus
+	readIndexQueue   []string
+}
+
+func newReadOnly(option ReadOnlyOption) *readOnly {
+	return &readOnly{
+		option:           option,
+		pendingReadIndex: make(map[string]*readIndexStatus),
+	}
+}
+
+// addRequest adds a read only request into the queue. index is the commit
+// index of the leader when the request was received.
+func (ro *readOnly) addRequest(index uint64, m pb.Message) {
+	ctx := string(m.Entries[0].Data)
+	if _, ok := ro.pendingReadIndex[ctx]; ok {
+		return
+	}
+	ro.pendingReadIndex[ctx] = &readIndexStatus{index: index, req: m, acks: make(map[uint64]struct{})}
+	ro.readIndexQueue = append(ro.readIndexQueue, ctx)
+}
+
+// recvAck records the heartbeat acknowledgement carrying the context of a
+// read only request, and returns the number of acks the request has.
+func (ro *readOnly) recvAck(m pb.Message) int {
+	rs, ok := ro.pendingReadIndex[string(m.Context)]
+	if !ok {
+		return 0
+	}
+	rs.acks[m.From] = struct{}{}
+	// add one to include the ack from the local node.
+	return len(rs.acks) + 1
+}
+
+// advance dequeues the request acknowledged by m and all the requests
+// queued before it, since a heartbeat round also confirms the earlier ones.
+func (ro *readOnly) advance(m pb.Message) []*readIndexStatus {
+	ctx := string(m.Context)
+	var (
+		i     int
+		found bool
+	)
+	for _, okctx := range ro.readIndexQueue {
+		i++
+		if _, ok := ro.pendingReadIndex[okctx]; !ok {
+			panic("cannot find corresponding read state from pending map")
+		}
+		if okctx == ctx {
+			found = true
+			break
+		}
+	}
+	if !found {
+		return nil
+	}
+
+	rss := make([]*readIndexStatus, 0, i)
+	for _, okctx := range ro.readIndexQueue[:i] {
+		rss = append(rss, ro.pendingReadIndex[okctx])
+		delete(ro.pendingReadIndex, okctx)
+	}
+	ro.readIndexQueue = ro.readIndexQueue[i:]
+	return rss
+}
+
+// lastPendingRequestCtx returns the context of the last pending read only
+// request, or an empty string if there is none.
+func (ro *readOnly) lastPendingRequestCtx() string {
+	if len(ro.readIndexQueue) == 0 {
+		return ""
+	}
+	return ro.readIndexQueue[len(ro.readIndexQueue)-1]
+}
@@ -20,14 +20,18 @@ import (
 	pb "github.com/coreos/etcd/raft/raftpb"
 )
 
-//
+// Status contains information about the state of a raft node.
 type Status struct {
 	ID uint64
 
 	pb.HardState
 	SoftState
 
-	Applied  uint64
+	Applied uint64
+	// Progress is the progress of every member in the view of the leader:
+	// its Match and Next indexes, its State, whether replication to it is
+	// paused (IsPaused) and how many replication messages to it are in
+	// flight (InflightCount). It is only set on the leader.
 	Progress map[uint64]Progress
 }
 
@@ -42,7 +46,10 @@ func getStatus(r *raft) Status {
 	if s.RaftState == StateLeader {
 		s.Progress = make(map[uint64]Progress)
 		for id, p := range r.prs {
-			s.Progress[id] = *p
+			pr := *p
+			// the copy must not share the inflights with the raft state machine.
+			pr.ins = p.ins.clone()
+			s.Progress[id] = pr
 		}
 	}
 
@@ -58,7 +65,8 @@ func (s Status) MarshalJSON() ([]byte, error) {
 		j += "}}"
 	} else {
 		for k, v := range s.Progress {
-			subj := fmt.Sprintf(`"%x":{"match":%d,"next":%d,"state":%q},`, k, v.Match, v.Next, v.State)
+			subj := fmt.Sprintf(`"%x":{"match":%d,"next":%d,"state":%q,"paused":%t,"inflights":%d},`,
+				k, v.Match, v.Next, v.State, v.IsPaused(), v.InflightCount())
 			j += subj
 		}
 		// remove the trailing ","
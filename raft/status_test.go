@@ -0,0 +1,88 @@
+// Copyright 2015 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package raft
+
+import (
+	"strings"
+	"testing"
+
+	pb "github.com/coreos/etcd/raft/raftpb"
+)
+
+// TestStatusProgress ensures that the status of the leader reports the
+// progress of each follower, and that it is a copy independent from the
+// raft state machine.
+func TestStatusProgress(t *testing.T) {
+	r := newTestRaft(1, []uint64{1, 2, 3}, 10, 1, NewMemoryStorage())
+	r.becomeCandidate()
+	r.becomeLeader()
+	// 2 replicates with one message in flight; 3 is still probed.
+	r.prs[2].becomeReplicate()
+	r.Step(pb.Message{From: 1, To: 1, Type: pb.MsgProp, Entries: []pb.Entry{{Data: []byte("somedata")}}})
+	r.readMessages()
+
+	s := getStatus(r)
+	tests := []struct {
+		id         uint64
+		wmatch     uint64
+		wnext      uint64
+		wstate     ProgressStateType
+		wpaused    bool
+		winflights int
+	}{
+		{2, 0, 3, ProgressStateReplicate, false, 1},
+		{3, 0, 1, ProgressStateProbe, true, 0},
+	}
+	for i, tt := range tests {
+		pr, ok := s.Progress[tt.id]
+		if !ok {
+			t.Fatalf("#%d: no progress of %x", i, tt.id)
+		}
+		if pr.Match != tt.wmatch || pr.Next != tt.wnext {
+			t.Errorf("#%d: match, next = %d, %d, want %d, %d", i, pr.Match, pr.Next, tt.wmatch, tt.wnext)
+		}
+		if pr.State != tt.wstate {
+			t.Errorf("#%d: state = %s, want %s", i, pr.State, tt.wstate)
+		}
+		if g := pr.IsPaused(); g != tt.wpaused {
+			t.Errorf("#%d: paused = %v, want %v", i, g, tt.wpaused)
+		}
+		if g := pr.InflightCount(); g != tt.winflights {
+			t.Errorf("#%d: inflights = %d, want %d", i, g, tt.winflights)
+		}
+	}
+
+	// the acknowledgement changes the raft state machine but not the status.
+	r.Step(pb.Message{From: 2, To: 1, Type: pb.MsgAppResp, Term: r.Term, Index: 2})
+	if g := r.prs[2].InflightCount(); g != 0 {
+		t.Errorf("inflights = %d, want 0", g)
+	}
+	if pr := s.Progress[2]; pr.InflightCount() != 1 {
+		t.Errorf("status inflights = %d, want 1", pr.InflightCount())
+	}
+
+	w := `"2":{"match":0,"next":3,"state":"ProgressStateReplicate","paused":false,"inflights":1}`
+	if g := s.String(); !strings.Contains(g, w) {
+		t.Errorf("status = %s, want it to contain %s", g, w)
+	}
+}
+
+// TestStatusFollower ensures that only the leader reports the progress.
+func TestStatusFollower(t *testing.T) {
+	r := newTestRaft(1, []uint64{1, 2, 3}, 10, 1, NewMemoryStorage())
+	if s := getStatus(r); s.Progress != nil {
+		t.Errorf("progress = %+v, want nil", s.Progress)
+	}
+}
@@ -39,6 +39,15 @@ func min(a, b uint64) uint64 {
 	return a
 }
 
+// payloadsSize is the aggregate size of the payloads of the given entries.
+func payloadsSize(ents []pb.Entry) uint64 {
+	var s uint64
+	for _, e := range ents {
+		s += uint64(len(e.Data))
+	}
+	return s
+}
+
 func max(a, b uint64) uint64 {
 	if a > b {
 		return a
@@ -46,14 +55,15 @@ func max(a, b uint64) uint64 {
 	return b
 }
 /**
-* 本地消息：MsgHup，MsgBeat，MsgUnreachable，MsgSnapStatus
+* 本地消息：MsgHup，MsgBeat，MsgUnreachable，MsgSnapStatus，MsgCheckQuorum，MsgUnquiesce
 */
 func IsLocalMsg(m pb.Message) bool {
-	return m.Type == pb.MsgHup || m.Type == pb.MsgBeat || m.Type == pb.MsgUnreachable || m.Type == pb.MsgSnapStatus
+	return m.Type == pb.MsgHup || m.Type == pb.MsgBeat || m.Type == pb.MsgUnreachable ||
+		m.Type == pb.MsgSnapStatus || m.Type == pb.MsgCheckQuorum || m.Type == pb.MsgUnquiesce
 }
 
 func IsResponseMsg(m pb.Message) bool {
-	return m.Type == pb.MsgAppResp || m.Type == pb.MsgVoteResp || m.Type == pb.MsgHeartbeatResp || m.Type == pb.MsgUnreachable
+	return m.Type == pb.MsgAppResp || m.Type == pb.MsgVoteResp || m.Type == pb.MsgHeartbeatResp || m.Type == pb.MsgUnreachable || m.Type == pb.MsgPreVoteResp
 }
 
 // EntryFormatter can be implemented by the application to provide human-readable formatting
@@ -111,3 +121,71 @@ func limitSize(ents []pb.Entry, maxSize uint64) []pb.Entry {
 	}
 	return ents[:limit]
 }
+
+func entsSize(ents []pb.Entry) uint64 {
+	var size uint64
+	for _, e := range ents {
+		size += uint64(e.Size())
+	}
+	return size
+}
+
+// coalesceMsgs reduces the messages of a Ready to at most one MsgApp per
+// follower where it can: a MsgApp that continues the previous MsgApp to the
+// same follower is merged into it, as long as the merged entries fit in
+// maxSize, and the merged message carries the latest commit index. A
+// heartbeat to a follower which gets a MsgApp anyway is dropped, since the
+// MsgApp carries the commit index as well; the heartbeats confirming a read
+// only request are kept. The order of the messages to each follower is kept.
+func coalesceMsgs(msgs []pb.Message, maxSize uint64) []pb.Message {
+	if len(msgs) < 2 {
+		return msgs
+	}
+	hasApp := make(map[uint64]bool)
+	for _, m := range msgs {
+		if m.Type == pb.MsgApp {
+			hasApp[m.To] = true
+		}
+	}
+
+	out := make([]pb.Message, 0, len(msgs))
+	// last maps a follower to the position of the last message to it in out.
+	last := make(map[uint64]int)
+	for _, m := range msgs {
+		if m.Type == pb.MsgHeartbeat && len(m.Context) == 0 && hasApp[m.To] {
+			continue
+		}
+		if m.Type == pb.MsgApp {
+			if i, ok := last[m.To]; ok && canMergeApp(out[i], m, maxSize) {
+				prev := &out[i]
+				ents := make([]pb.Entry, 0, len(prev.Entries)+len(m.Entries))
+				ents = append(ents, prev.Entries...)
+				prev.Entries = append(ents, m.Entries...)
+				prev.Commit = max(prev.Commit, m.Commit)
+				continue
+			}
+		}
+		last[m.To] = len(out)
+		out = append(out, m)
+	}
+	return out
+}
+
+// canMergeApp returns true if the entries of the MsgApp m directly follow
+// those of the MsgApp prev, and all of them fit in maxSize.
+func canMergeApp(prev, m pb.Message, maxSize uint64) bool {
+	if prev.Type != pb.MsgApp || prev.Term != m.Term {
+		return false
+	}
+	if m.Index != prev.Index+uint64(len(prev.Entries)) {
+		return false
+	}
+	logTerm := prev.LogTerm
+	if n := len(prev.Entries); n > 0 {
+		logTerm = prev.Entries[n-1].Term
+	}
+	if m.LogTerm != logTerm {
+		return false
+	}
+	return entsSize(prev.Entries)+entsSize(m.Entries) <= maxSize
+}
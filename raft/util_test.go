@@ -70,3 +70,71 @@ func TestLimitSize(t *testing.T) {
 		}
 	}
 }
+
+func TestCoalesceMsgs(t *testing.T) {
+	e1, e2, e3 := pb.Entry{Index: 1, Term: 1}, pb.Entry{Index: 2, Term: 1}, pb.Entry{Index: 3, Term: 1}
+	// app returns a MsgApp of the given entries which follow the given index.
+	app := func(to, index, commit uint64, ents ...pb.Entry) pb.Message {
+		var logTerm uint64
+		if index > 0 {
+			logTerm = 1
+		}
+		return pb.Message{To: to, Type: pb.MsgApp, Term: 1, LogTerm: logTerm, Index: index, Commit: commit, Entries: ents}
+	}
+	hb := pb.Message{To: 2, Type: pb.MsgHeartbeat, Term: 1}
+	hbCtx := pb.Message{To: 2, Type: pb.MsgHeartbeat, Term: 1, Context: []byte("ctx")}
+	snap := pb.Message{To: 2, Type: pb.MsgSnap, Term: 1}
+
+	tests := []struct {
+		msgs    []pb.Message
+		maxSize uint64
+		wmsgs   []pb.Message
+	}{
+		// the contiguous appends are merged with the latest commit.
+		{
+			[]pb.Message{app(2, 0, 0, e1), app(2, 1, 1, e2), app(2, 2, 2)},
+			noLimit,
+			[]pb.Message{app(2, 0, 2, e1, e2)},
+		},
+		// the appends to the other followers are kept apart.
+		{
+			[]pb.Message{app(2, 0, 0, e1), app(3, 0, 0, e1), app(2, 1, 0, e2), app(3, 1, 0, e2)},
+			noLimit,
+			[]pb.Message{app(2, 0, 0, e1, e2), app(3, 0, 0, e1, e2)},
+		},
+		// a gap is not merged.
+		{
+			[]pb.Message{app(2, 0, 0, e1), app(2, 2, 0, e3)},
+			noLimit,
+			[]pb.Message{app(2, 0, 0, e1), app(2, 2, 0, e3)},
+		},
+		// no append is merged over another message to the same follower.
+		{
+			[]pb.Message{app(2, 0, 0, e1), snap, app(2, 1, 0, e2)},
+			noLimit,
+			[]pb.Message{app(2, 0, 0, e1), snap, app(2, 1, 0, e2)},
+		},
+		// the merged entries must fit in maxSize.
+		{
+			[]pb.Message{app(2, 0, 0, e1), app(2, 1, 0, e2)},
+			uint64(e1.Size()),
+			[]pb.Message{app(2, 0, 0, e1), app(2, 1, 0, e2)},
+		},
+		// the heartbeat is dropped for the append, unless it confirms a read.
+		{
+			[]pb.Message{hb, app(2, 0, 0, e1)},
+			noLimit,
+			[]pb.Message{app(2, 0, 0, e1)},
+		},
+		{
+			[]pb.Message{hbCtx, app(2, 0, 0, e1)},
+			noLimit,
+			[]pb.Message{hbCtx, app(2, 0, 0, e1)},
+		},
+	}
+	for i, tt := range tests {
+		if g := coalesceMsgs(tt.msgs, tt.maxSize); !reflect.DeepEqual(g, tt.wmsgs) {
+			t.Errorf("#%d: msgs = %+v, want %+v", i, g, tt.wmsgs)
+		}
+	}
+}
@@ -0,0 +1,105 @@
+// Copyright 2015 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package rafthttp
+
+import (
+	"compress/gzip"
+	"io"
+	"net/http"
+
+	"github.com/coreos/etcd/pkg/types"
+)
+
+const (
+	// CompressionNone sends the peer streams as they are.
+	CompressionNone = "none"
+	// CompressionGzip compresses the peer streams with gzip, trading CPU
+	// for the bandwidth of the links, e.g. when replicating large values
+	// across a WAN.
+	CompressionGzip = "gzip"
+
+	// the stream reader announces the encodings it can decode in
+	// acceptEncodingHeader, and the stream writer tells the one it picked
+	// in contentEncodingHeader. The peers of an older version set neither,
+	// so they keep talking uncompressed.
+	acceptEncodingHeader  = "X-Raft-Accept-Encoding"
+	contentEncodingHeader = "X-Raft-Content-Encoding"
+)
+
+// gzipStreamWriter compresses the messages written into a stream. Flush
+// pushes the messages written so far through to the remote, so that the
+// stream writer can flush after every message as it does uncompressed.
+type gzipStreamWriter struct {
+	gz     *gzip.Writer
+	cw     *countingWriter
+	f      http.Flusher
+	remote string
+
+	// written is the number of the bytes written since the last Flush,
+	// before the compression; cw counts them after.
+	written int64
+}
+
+func newGzipStreamWriter(w io.Writer, f http.Flusher, remote types.ID) *gzipStreamWriter {
+	cw := &countingWriter{w: w}
+	// gzip.NewWriterLevel never fails with a valid level
+	gz, _ := gzip.NewWriterLevel(cw, gzip.BestSpeed)
+	return &gzipStreamWriter{gz: gz, cw: cw, f: f, remote: remote.String()}
+}
+
+func (w *gzipStreamWriter) Write(p []byte) (int, error) {
+	n, err := w.gz.Write(p)
+	w.written += int64(n)
+	return n, err
+}
+
+func (w *gzipStreamWriter) Flush() {
+	if err := w.gz.Flush(); err != nil {
+		// the error comes back on the next write into the stream
+		return
+	}
+	w.f.Flush()
+	reportCompression(w.remote, w.written, w.cw.n)
+	w.written, w.cw.n = 0, 0
+}
+
+// countingWriter counts the bytes written through it.
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (w *countingWriter) Write(p []byte) (int, error) {
+	n, err := w.w.Write(p)
+	w.n += int64(n)
+	return n, err
+}
+
+// gzipReadCloser decompresses a stream, and closes the underlying stream
+// on Close.
+type gzipReadCloser struct {
+	*gzip.Reader
+	rc io.ReadCloser
+}
+
+func newGzipReadCloser(rc io.ReadCloser) (io.ReadCloser, error) {
+	gz, err := gzip.NewReader(rc)
+	if err != nil {
+		return nil, err
+	}
+	return &gzipReadCloser{Reader: gz, rc: rc}, nil
+}
+
+func (r *gzipReadCloser) Close() error { return r.rc.Close() }
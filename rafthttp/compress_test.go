@@ -0,0 +1,61 @@
+// Copyright 2015 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package rafthttp
+
+import (
+	"bytes"
+	"io"
+	"testing"
+
+	"github.com/coreos/etcd/pkg/types"
+)
+
+type nopFlusher struct{}
+
+func (nopFlusher) Flush() {}
+
+// TestGzipStream tests that each message flushed into a gzip stream can be
+// read from the other end without waiting for the following ones.
+func TestGzipStream(t *testing.T) {
+	msgs := [][]byte{[]byte("some data"), bytes.Repeat([]byte("a"), 64*1024)}
+	pr, pw := io.Pipe()
+	w := newGzipStreamWriter(pw, nopFlusher{}, types.ID(1))
+	// the reader reads each message before the next one is written
+	readc := make(chan struct{})
+	go func() {
+		w.Flush()
+		for _, msg := range msgs {
+			w.Write(msg)
+			w.Flush()
+			<-readc
+		}
+	}()
+	rc, err := newGzipReadCloser(pr)
+	if err != nil {
+		t.Fatalf("unexpected newGzipReadCloser error: %v", err)
+	}
+	defer rc.Close()
+
+	for i, msg := range msgs {
+		buf := make([]byte, len(msg))
+		if _, err := io.ReadFull(rc, buf); err != nil {
+			t.Fatalf("#%d: unexpected read error: %v", i, err)
+		}
+		if !bytes.Equal(buf, msg) {
+			t.Errorf("#%d: data = %q, want %q", i, buf, msg)
+		}
+		readc <- struct{}{}
+	}
+}
@@ -15,29 +15,43 @@
 package rafthttp
 
 import (
+	"io/ioutil"
 	"net/http"
 	"net/http/httptest"
+	"os"
 	"reflect"
 	"testing"
 	"time"
 
 	"github.com/coreos/etcd/Godeps/_workspace/src/golang.org/x/net/context"
 	"github.com/coreos/etcd/etcdserver/stats"
+	ptransport "github.com/coreos/etcd/pkg/transport"
 	"github.com/coreos/etcd/pkg/types"
 	"github.com/coreos/etcd/raft"
 	"github.com/coreos/etcd/raft/raftpb"
+	"github.com/coreos/etcd/version"
 )
 
 func TestSendMessage(t *testing.T) {
+	testSendMessage(t, CompressionNone)
+}
+
+// TestSendMessageCompressed tests that messages go through the streams
+// compressed in gzip.
+func TestSendMessageCompressed(t *testing.T) {
+	testSendMessage(t, CompressionGzip)
+}
+
+func testSendMessage(t *testing.T, compression string) {
 	// member 1
-	tr := NewTransporter(&http.Transport{}, types.ID(1), types.ID(1), &fakeRaft{}, nil, newServerStats(), stats.NewLeaderStats("1"))
+	tr := NewTransporter(&http.Transport{}, types.ID(1), types.ID(1), &fakeRaft{}, nil, newServerStats(), stats.NewLeaderStats("1"), compression, 0, 0)
 	srv := httptest.NewServer(tr.Handler())
 	defer srv.Close()
 
 	// member 2
 	recvc := make(chan raftpb.Message, 1)
 	p := &fakeRaft{recvc: recvc}
-	tr2 := NewTransporter(&http.Transport{}, types.ID(2), types.ID(1), p, nil, newServerStats(), stats.NewLeaderStats("2"))
+	tr2 := NewTransporter(&http.Transport{}, types.ID(2), types.ID(1), p, nil, newServerStats(), stats.NewLeaderStats("2"), compression, 0, 0)
 	srv2 := httptest.NewServer(tr2.Handler())
 	defer srv2.Close()
 
@@ -71,18 +85,122 @@ func TestSendMessage(t *testing.T) {
 	}
 }
 
+// TestSendMessageUnix tests that the members talk over unix domain sockets.
+func TestSendMessageUnix(t *testing.T) {
+	dir, err := ioutil.TempDir("", "rafthttp-test-unix-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the hosts of the unix URLs are the socket paths relative to the
+	// working directory
+	if err = os.Chdir(dir); err != nil {
+		t.Fatal(err)
+	}
+	defer os.Chdir(wd)
+
+	rt, err := ptransport.NewTransport(ptransport.TLSInfo{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// member 1
+	tr := NewTransporter(rt, types.ID(1), types.ID(1), &fakeRaft{}, nil, newServerStats(), stats.NewLeaderStats("1"), CompressionNone, 0, 0)
+	srv := newUnixServer(t, "localhost:1", tr.Handler())
+	defer srv.Close()
+
+	// member 2
+	recvc := make(chan raftpb.Message, 1)
+	tr2 := NewTransporter(rt, types.ID(2), types.ID(1), &fakeRaft{recvc: recvc}, nil, newServerStats(), stats.NewLeaderStats("2"), CompressionNone, 0, 0)
+	srv2 := newUnixServer(t, "localhost:2", tr2.Handler())
+	defer srv2.Close()
+
+	tr.AddPeer(types.ID(2), []string{"unix://localhost:2"})
+	defer tr.Stop()
+	tr2.AddPeer(types.ID(1), []string{"unix://localhost:1"})
+	defer tr2.Stop()
+	if !waitStreamWorking(tr.(*transport).Get(types.ID(2)).(*peer)) {
+		t.Fatalf("stream from 1 to 2 is not in work as expected")
+	}
+
+	for i, tt := range []raftpb.Message{
+		{Type: raftpb.MsgHeartbeat, From: 1, To: 2, Term: 1, Commit: 3},
+		{Type: raftpb.MsgSnap, From: 1, To: 2, Term: 1, Snapshot: raftpb.Snapshot{Metadata: raftpb.SnapshotMetadata{Index: 1000, Term: 1}, Data: []byte("some data")}},
+	} {
+		tr.Send([]raftpb.Message{tt})
+		select {
+		case msg := <-recvc:
+			if !reflect.DeepEqual(msg, tt) {
+				t.Errorf("#%d: msg = %+v, want %+v", i, msg, tt)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("#%d: failed to receive message", i)
+		}
+	}
+}
+
+func newUnixServer(t *testing.T, addr string, h http.Handler) *httptest.Server {
+	l, err := ptransport.NewUnixListener(addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := &httptest.Server{Listener: l, Config: &http.Server{Handler: h}}
+	srv.Start()
+	return srv
+}
+
+// TestPeerVersion tests that the members learn the versions of each other
+// in the stream handshake.
+func TestPeerVersion(t *testing.T) {
+	// member 1
+	tr := NewTransporter(&http.Transport{}, types.ID(1), types.ID(1), &fakeRaft{}, nil, newServerStats(), stats.NewLeaderStats("1"), CompressionNone, 0, 0)
+	srv := httptest.NewServer(tr.Handler())
+	defer srv.Close()
+
+	// member 2
+	tr2 := NewTransporter(&http.Transport{}, types.ID(2), types.ID(1), &fakeRaft{}, nil, newServerStats(), stats.NewLeaderStats("2"), CompressionNone, 0, 0)
+	srv2 := httptest.NewServer(tr2.Handler())
+	defer srv2.Close()
+
+	tr.AddPeer(types.ID(2), []string{srv2.URL})
+	defer tr.Stop()
+	if _, ok := tr.PeerVersion(types.ID(2)); ok {
+		t.Fatalf("version of 2 known before the handshake")
+	}
+	tr2.AddPeer(types.ID(1), []string{srv.URL})
+	defer tr2.Stop()
+	if !waitStreamWorking(tr2.(*transport).Get(types.ID(1)).(*peer)) {
+		t.Fatalf("stream from 2 to 1 is not in work as expected")
+	}
+
+	// member 1 dials member 2 for the streams from 2 to 1
+	v, ok := tr.PeerVersion(types.ID(2))
+	if !ok {
+		t.Fatalf("version of 2 unknown after the handshake")
+	}
+	if w := localVersion(); !reflect.DeepEqual(v, w) {
+		t.Errorf("version = %+v, want %+v", v, w)
+	}
+	if v, ok = tr.PeerVersion(types.ID(1)); !ok || v.Server != version.Version {
+		t.Errorf("local version = %+v, %t, want %s, true", v, ok, version.Version)
+	}
+}
+
 // TestSendMessageWhenStreamIsBroken tests that message can be sent to the
 // remote in a limited time when all underlying connections are broken.
 func TestSendMessageWhenStreamIsBroken(t *testing.T) {
 	// member 1
-	tr := NewTransporter(&http.Transport{}, types.ID(1), types.ID(1), &fakeRaft{}, nil, newServerStats(), stats.NewLeaderStats("1"))
+	tr := NewTransporter(&http.Transport{}, types.ID(1), types.ID(1), &fakeRaft{}, nil, newServerStats(), stats.NewLeaderStats("1"), CompressionNone, 0, 0)
 	srv := httptest.NewServer(tr.Handler())
 	defer srv.Close()
 
 	// member 2
 	recvc := make(chan raftpb.Message, 1)
 	p := &fakeRaft{recvc: recvc}
-	tr2 := NewTransporter(&http.Transport{}, types.ID(2), types.ID(1), p, nil, newServerStats(), stats.NewLeaderStats("2"))
+	tr2 := NewTransporter(&http.Transport{}, types.ID(2), types.ID(1), p, nil, newServerStats(), stats.NewLeaderStats("2"), CompressionNone, 0, 0)
 	srv2 := httptest.NewServer(tr2.Handler())
 	defer srv2.Close()
 
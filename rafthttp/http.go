@@ -15,10 +15,14 @@
 package rafthttp
 
 import (
+	"io"
 	"io/ioutil"
 	"log"
 	"net/http"
 	"path"
+	"strconv"
+	"sync"
+	"sync/atomic"
 
 	"github.com/coreos/etcd/Godeps/_workspace/src/golang.org/x/net/context"
 	pioutil "github.com/coreos/etcd/pkg/ioutil"
@@ -31,13 +35,16 @@ const (
 )
 
 var (
-	RaftPrefix       = "/raft"
-	RaftStreamPrefix = path.Join(RaftPrefix, "stream")
+	RaftPrefix         = "/raft"
+	RaftStreamPrefix   = path.Join(RaftPrefix, "stream")
+	RaftSnapshotPrefix = path.Join(RaftPrefix, "snapshot")
+	RaftProbingPrefix  = path.Join(RaftPrefix, "probing")
 )
 
-func NewHandler(r Raft, cid types.ID) http.Handler {
+func NewHandler(r Raft, id, cid types.ID) http.Handler {
 	return &handler{
 		r:   r,
+		id:  id,
 		cid: cid,
 	}
 }
@@ -46,20 +53,36 @@ type peerGetter interface {
 	Get(id types.ID) Peer
 }
 
-func newStreamHandler(peerGetter peerGetter, id, cid types.ID) http.Handler {
+func newStreamHandler(peerGetter peerGetter, id, cid types.ID, compression string) http.Handler {
 	return &streamHandler{
-		peerGetter: peerGetter,
-		id:         id,
-		cid:        cid,
+		peerGetter:  peerGetter,
+		id:          id,
+		cid:         cid,
+		compression: compression,
 	}
 }
 
+func newSnapshotHandler(r Raft, id, cid types.ID, recvs *int32) http.Handler {
+	return &snapshotHandler{
+		r:        r,
+		id:       id,
+		cid:      cid,
+		recvs:    recvs,
+		partials: make(map[types.ID]*partialSnapshot),
+	}
+}
+
+func newProbingHandler(cid types.ID) http.Handler {
+	return &probingHandler{cid: cid}
+}
+
 type writerToResponse interface {
 	WriteTo(w http.ResponseWriter)
 }
 
 type handler struct {
 	r   Raft
+	id  types.ID
 	cid types.ID
 }
 
@@ -78,7 +101,7 @@ func (h *handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	// 不处理来自不同cluster的post请求
 	if gcid != wcid {
 		log.Printf("rafthttp: request ignored due to cluster ID mismatch got %s want %s", gcid, wcid)
-		http.Error(w, "clusterID mismatch", http.StatusPreconditionFailed)
+		writeMismatch(w, &MismatchError{Field: MismatchClusterID, Got: gcid, Want: wcid})
 		return
 	}
 
@@ -97,6 +120,13 @@ func (h *handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "error unmarshaling raft message", http.StatusBadRequest)
 		return
 	}
+	// 不处理发往其他member的消息
+	if gto, wto := types.ID(m.To).String(), h.id.String(); gto != wto {
+		log.Printf("rafthttp: request from %s ignored due to member ID mismatch got %s want %s", types.ID(m.From), gto, wto)
+		writeMismatch(w, &MismatchError{Field: MismatchMemberID, Got: gto, Want: wto})
+		return
+	}
+	reportReceived(m)
 	// 处理post request
 	if err := h.r.Process(context.TODO(), m); err != nil {
 		switch v := err.(type) {
@@ -117,6 +147,9 @@ type streamHandler struct {
 	peerGetter peerGetter
 	id         types.ID
 	cid        types.ID
+	// compression is the encoding of the streams sent to the peers which
+	// can decode it.
+	compression string
 }
 
 // 只处理HTTP Get请求
@@ -160,32 +193,219 @@ func (h *streamHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	wcid := h.cid.String()
 	if gcid := r.Header.Get("X-Etcd-Cluster-ID"); gcid != wcid {
 		log.Printf("rafthttp: streaming request ignored due to cluster ID mismatch got %s want %s", gcid, wcid)
-		http.Error(w, "clusterID mismatch", http.StatusPreconditionFailed)
+		writeMismatch(w, &MismatchError{Field: MismatchClusterID, Got: gcid, Want: wcid})
 		return
 	}
 
 	wto := h.id.String()
 	if gto := r.Header.Get("X-Raft-To"); gto != wto {
-		log.Printf("rafthttp: streaming request ignored due to ID mismatch got %s want %s", gto, wto)
-		http.Error(w, "to field mismatch", http.StatusPreconditionFailed)
+		log.Printf("rafthttp: streaming request ignored due to member ID mismatch got %s want %s", gto, wto)
+		writeMismatch(w, &MismatchError{Field: MismatchMemberID, Got: gto, Want: wto})
 		return
 	}
 
+	var (
+		wr      io.Writer = w
+		flusher           = w.(http.Flusher)
+	)
+	setVersionHeader(w.Header())
+	compress := h.compression == CompressionGzip && r.Header.Get(acceptEncodingHeader) == CompressionGzip
+	if compress {
+		w.Header().Set(contentEncodingHeader, CompressionGzip)
+	}
 	w.WriteHeader(http.StatusOK)
-	w.(http.Flusher).Flush()
+	flusher.Flush()
+	if compress {
+		gw := newGzipStreamWriter(w, flusher, from)
+		// send the gzip header at once, which the reader waits for
+		gw.Flush()
+		wr, flusher = gw, gw
+	}
 
 	c := newCloseNotifier()
 	conn := &outgoingConn{
 		t:       t,
 		termStr: r.Header.Get("X-Raft-Term"),
-		Writer:  w,
-		Flusher: w.(http.Flusher),
+		Writer:  wr,
+		Flusher: flusher,
 		Closer:  c,
 	}
 	p.attachOutgoingConn(conn)
 	<-c.closeNotify()
 }
 
+// partialSnapshot is the snapshot data received from a member so far.
+type partialSnapshot struct {
+	term  uint64
+	index uint64
+	size  uint64
+	data  []byte
+}
+
+func (p *partialSnapshot) matches(term, index, size uint64) bool {
+	return p.term == term && p.index == index && p.size == size
+}
+
+// snapshotHandler receives the snapshots streamed by snapshotSender.
+// It keeps the data received from each member, so an interrupted
+// transfer can be resumed from where it stopped.
+type snapshotHandler struct {
+	r   Raft
+	id  types.ID
+	cid types.ID
+	// recvs counts the snapshots being received, until raft takes them.
+	recvs *int32
+
+	mu       sync.Mutex
+	partials map[types.ID]*partialSnapshot
+}
+
+// 处理GET请求返回已经接收的snapshot数据的offset，处理POST请求接收snapshot数据
+func (h *snapshotHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" && r.Method != "POST" {
+		w.Header().Set("Allow", "GET, POST")
+		http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	wcid := h.cid.String()
+	w.Header().Set("X-Etcd-Cluster-ID", wcid)
+
+	if gcid := r.Header.Get("X-Etcd-Cluster-ID"); gcid != wcid {
+		log.Printf("rafthttp: snapshot request ignored due to cluster ID mismatch got %s want %s", gcid, wcid)
+		writeMismatch(w, &MismatchError{Field: MismatchClusterID, Got: gcid, Want: wcid})
+		return
+	}
+	// X-Raft-To is not sent by the members of older versions.
+	wto := h.id.String()
+	if gto := r.Header.Get("X-Raft-To"); gto != "" && gto != wto {
+		log.Printf("rafthttp: snapshot request ignored due to member ID mismatch got %s want %s", gto, wto)
+		writeMismatch(w, &MismatchError{Field: MismatchMemberID, Got: gto, Want: wto})
+		return
+	}
+
+	from, err := types.IDFromString(r.Header.Get("X-Raft-From"))
+	if err != nil {
+		log.Printf("rafthttp: failed to parse from %s into ID", r.Header.Get("X-Raft-From"))
+		http.Error(w, "invalid from", http.StatusBadRequest)
+		return
+	}
+	var term, index, size uint64
+	for _, f := range []struct {
+		name string
+		v    *uint64
+	}{
+		{"X-Raft-Snapshot-Term", &term},
+		{"X-Raft-Snapshot-Index", &index},
+		{"X-Raft-Snapshot-Size", &size},
+	} {
+		if *f.v, err = strconv.ParseUint(r.Header.Get(f.name), 10, 64); err != nil {
+			log.Printf("rafthttp: failed to parse %s %q", f.name, r.Header.Get(f.name))
+			http.Error(w, "invalid "+f.name, http.StatusBadRequest)
+			return
+		}
+	}
+
+	if r.Method == "GET" {
+		h.mu.Lock()
+		p, ok := h.partials[from]
+		if !ok || !p.matches(term, index, size) {
+			p = &partialSnapshot{term: term, index: index, size: size}
+			h.partials[from] = p
+		}
+		offset := len(p.data)
+		h.mu.Unlock()
+
+		w.Header().Set("X-Raft-Snapshot-Offset", strconv.Itoa(offset))
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	offset, err := strconv.ParseUint(r.Header.Get("X-Raft-Snapshot-Offset"), 10, 64)
+	if err != nil {
+		http.Error(w, "invalid X-Raft-Snapshot-Offset", http.StatusBadRequest)
+		return
+	}
+	h.mu.Lock()
+	p, ok := h.partials[from]
+	h.mu.Unlock()
+	if !ok || !p.matches(term, index, size) || offset != uint64(len(p.data)) {
+		http.Error(w, "snapshot offset mismatch", http.StatusConflict)
+		return
+	}
+
+	b, err := readChunkFrom(r.Body, ConnReadLimitByte)
+	if err != nil {
+		log.Println("rafthttp: error reading raft message:", err)
+		http.Error(w, "error reading raft message", http.StatusBadRequest)
+		return
+	}
+	var m raftpb.Message
+	if err := m.Unmarshal(b); err != nil {
+		log.Println("rafthttp: error unmarshaling raft message:", err)
+		http.Error(w, "error unmarshaling raft message", http.StatusBadRequest)
+		return
+	}
+	if m.Type != raftpb.MsgSnap || m.Snapshot.Metadata.Term != term || m.Snapshot.Metadata.Index != index {
+		log.Printf("rafthttp: unexpected snapshot message %s [index: %d, term: %d]", m.Type, m.Snapshot.Metadata.Index, m.Snapshot.Metadata.Term)
+		http.Error(w, "unexpected snapshot message", http.StatusBadRequest)
+		return
+	}
+	if gto := types.ID(m.To).String(); gto != wto {
+		log.Printf("rafthttp: snapshot message from %s ignored due to member ID mismatch got %s want %s", from, gto, wto)
+		writeMismatch(w, &MismatchError{Field: MismatchMemberID, Got: gto, Want: wto})
+		return
+	}
+
+	atomic.AddInt32(h.recvs, 1)
+	defer atomic.AddInt32(h.recvs, -1)
+	// The data is appended as it arrives, so it is kept for resumption
+	// if the connection breaks.
+	for {
+		chunk, err := readChunkFrom(r.Body, snapChunkSize)
+		if err != nil {
+			log.Printf("rafthttp: error reading snapshot data from %s: %v", from, err)
+			http.Error(w, "error reading snapshot data", http.StatusBadRequest)
+			return
+		}
+		if len(chunk) == 0 {
+			break
+		}
+		h.mu.Lock()
+		if h.partials[from] != p || uint64(len(p.data)+len(chunk)) > p.size {
+			h.mu.Unlock()
+			http.Error(w, "snapshot data mismatch", http.StatusConflict)
+			return
+		}
+		p.data = append(p.data, chunk...)
+		h.mu.Unlock()
+	}
+
+	h.mu.Lock()
+	if h.partials[from] != p || uint64(len(p.data)) != p.size {
+		h.mu.Unlock()
+		http.Error(w, "incomplete snapshot data", http.StatusBadRequest)
+		return
+	}
+	delete(h.partials, from)
+	h.mu.Unlock()
+
+	if p.size != 0 {
+		m.Snapshot.Data = p.data
+	}
+	if err := h.r.Process(context.TODO(), m); err != nil {
+		switch v := err.(type) {
+		case writerToResponse:
+			v.WriteTo(w)
+		default:
+			log.Printf("rafthttp: error processing raft message: %v", err)
+			http.Error(w, "error processing raft message", http.StatusInternalServerError)
+		}
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
 type closeNotifier struct {
 	done chan struct{}
 }
@@ -202,3 +422,25 @@ func (n *closeNotifier) Close() error {
 }
 
 func (n *closeNotifier) closeNotify() <-chan struct{} { return n.done }
+
+// probingHandler answers the probes that measure the round-trip time
+// between the members.
+type probingHandler struct {
+	cid types.ID
+}
+
+func (h *probingHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		w.Header().Set("Allow", "GET")
+		http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	wcid := h.cid.String()
+	w.Header().Set("X-Etcd-Cluster-ID", wcid)
+	if gcid := r.Header.Get("X-Etcd-Cluster-ID"); gcid != wcid {
+		writeMismatch(w, &MismatchError{Field: MismatchClusterID, Got: gcid, Want: wcid})
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -18,6 +18,7 @@ import (
 	"bytes"
 	"errors"
 	"io"
+	"io/ioutil"
 	"net/http"
 	"net/http/httptest"
 	"strings"
@@ -94,6 +95,16 @@ func TestServeRaftPrefix(t *testing.T) {
 			"1",
 			http.StatusPreconditionFailed,
 		},
+		{
+			// good request, wrong member ID
+			"POST",
+			bytes.NewReader(
+				pbutil.MustMarshal(&raftpb.Message{To: 2}),
+			),
+			&fakeRaft{},
+			"0",
+			http.StatusPreconditionFailed,
+		},
 		{
 			// good request, Processor failure
 			"POST",
@@ -146,7 +157,7 @@ func TestServeRaftPrefix(t *testing.T) {
 		}
 		req.Header.Set("X-Etcd-Cluster-ID", tt.clusterID)
 		rw := httptest.NewRecorder()
-		h := NewHandler(tt.p, types.ID(0))
+		h := NewHandler(tt.p, types.ID(0), types.ID(0))
 		h.ServeHTTP(rw, req)
 		if rw.Code != tt.wcode {
 			t.Errorf("#%d: got code=%d, want %d", i, rw.Code, tt.wcode)
@@ -185,7 +196,7 @@ func TestServeRaftStreamPrefix(t *testing.T) {
 
 		peer := newFakePeer()
 		peerGetter := &fakePeerGetter{peers: map[types.ID]Peer{types.ID(1): peer}}
-		h := newStreamHandler(peerGetter, types.ID(2), types.ID(1))
+		h := newStreamHandler(peerGetter, types.ID(2), types.ID(1), CompressionNone)
 
 		rw := httptest.NewRecorder()
 		go h.ServeHTTP(rw, req)
@@ -289,12 +300,18 @@ func TestServeRaftStreamPrefixBad(t *testing.T) {
 		req.Header.Set("X-Raft-To", tt.remote)
 		rw := httptest.NewRecorder()
 		peerGetter := &fakePeerGetter{peers: map[types.ID]Peer{types.ID(1): newFakePeer()}}
-		h := newStreamHandler(peerGetter, types.ID(1), types.ID(1))
+		h := newStreamHandler(peerGetter, types.ID(1), types.ID(1), CompressionNone)
 		h.ServeHTTP(rw, req)
 
 		if rw.Code != tt.wcode {
 			t.Errorf("#%d: code = %d, want %d", i, rw.Code, tt.wcode)
 		}
+		if tt.wcode == http.StatusPreconditionFailed {
+			resp := &http.Response{Header: rw.HeaderMap, Body: ioutil.NopCloser(rw.Body)}
+			if me := readMismatch(resp); me == nil {
+				t.Errorf("#%d: body = %q, want mismatch error", i, rw.Body.String())
+			}
+		}
 	}
 }
 
@@ -346,4 +363,6 @@ func newFakePeer() *fakePeer {
 func (pr *fakePeer) Send(m raftpb.Message)                 { pr.msgs = append(pr.msgs, m) }
 func (pr *fakePeer) Update(urls types.URLs)                { pr.urls = urls }
 func (pr *fakePeer) attachOutgoingConn(conn *outgoingConn) { pr.connc <- conn }
+func (pr *fakePeer) unreachableSince() time.Time           { return time.Time{} }
+func (pr *fakePeer) peerVersion() (PeerVersion, bool)      { return PeerVersion{}, false }
 func (pr *fakePeer) Stop()                                 {}
@@ -37,17 +37,88 @@ var (
 	},
 		[]string{"channel", "remoteID", "msgType"},
 	)
+
+	peerRoundTripTime = prometheus.NewSummaryVec(
+		prometheus.SummaryOpts{
+			Name: "rafthttp_peer_round_trip_time_microseconds",
+			Help: "The round-trip time distributions between the member and its peers.",
+		},
+		[]string{"remoteID"},
+	)
+
+	msgSentBytes = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "rafthttp_message_sent_bytes_total",
+		Help: "The total number of bytes of the messages sent to each peer.",
+	},
+		[]string{"remoteID"},
+	)
+
+	msgReceivedBytes = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "rafthttp_message_received_bytes_total",
+		Help: "The total number of bytes of the messages received from each peer.",
+	},
+		[]string{"remoteID"},
+	)
+
+	msgDropped = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "rafthttp_message_dropped_total",
+		Help: "The total number of messages dropped because the buffers are full, by direction (send or receive).",
+	},
+		[]string{"direction", "remoteID", "msgType"},
+	)
+
+	msgRejected = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "rafthttp_message_rejected_total",
+		Help: "The total number of requests rejected because the cluster ID or the destination member ID does not match.",
+	},
+		[]string{"reason"},
+	)
+
+	streamActive = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "rafthttp_stream_active",
+		Help: "Whether the stream of each type, outgoing or incoming, to each peer is working (1) or not (0).",
+	},
+		[]string{"direction", "remoteID", "streamType"},
+	)
+
+	// The compression ratio of the streams to a peer is
+	// rafthttp_stream_compressed_bytes_total over
+	// rafthttp_stream_uncompressed_bytes_total.
+	streamUncompressedBytes = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "rafthttp_stream_uncompressed_bytes_total",
+		Help: "The total number of bytes sent on the compressed streams before the compression.",
+	},
+		[]string{"remoteID"},
+	)
+
+	streamCompressedBytes = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "rafthttp_stream_compressed_bytes_total",
+		Help: "The total number of bytes sent on the compressed streams after the compression.",
+	},
+		[]string{"remoteID"},
+	)
 )
 
 func init() {
 	prometheus.MustRegister(msgSentDuration)
 	prometheus.MustRegister(msgSentFailed)
+	prometheus.MustRegister(peerRoundTripTime)
+	prometheus.MustRegister(msgSentBytes)
+	prometheus.MustRegister(msgReceivedBytes)
+	prometheus.MustRegister(msgDropped)
+	prometheus.MustRegister(msgRejected)
+	prometheus.MustRegister(streamActive)
+	prometheus.MustRegister(streamUncompressedBytes)
+	prometheus.MustRegister(streamCompressedBytes)
 }
 
+// reportSentDuration reports a message sent successfully.
 func reportSentDuration(channel string, m raftpb.Message, duration time.Duration) {
 	typ := m.Type.String()
 	if isLinkHeartbeatMessage(m) {
 		typ = "MsgLinkHeartbeat"
+	} else {
+		msgSentBytes.WithLabelValues(types.ID(m.To).String()).Add(float64(m.Size()))
 	}
 	msgSentDuration.WithLabelValues(channel, types.ID(m.To).String(), typ).Observe(float64(duration.Nanoseconds() / int64(time.Microsecond)))
 }
@@ -59,3 +130,38 @@ func reportSentFailure(channel string, m raftpb.Message) {
 	}
 	msgSentFailed.WithLabelValues(channel, types.ID(m.To).String(), typ).Inc()
 }
+
+func reportReceived(m raftpb.Message) {
+	msgReceivedBytes.WithLabelValues(types.ID(m.From).String()).Add(float64(m.Size()))
+}
+
+// reportDropped reports a message dropped in the given direction, "send" or
+// "receive", to or from the remote.
+func reportDropped(direction string, remote types.ID, m raftpb.Message) {
+	msgDropped.WithLabelValues(direction, remote.String(), m.Type.String()).Inc()
+}
+
+// reportRejected reports a request rejected due to the mismatch of the
+// given field, MismatchClusterID or MismatchMemberID.
+func reportRejected(field string) {
+	msgRejected.WithLabelValues(field).Inc()
+}
+
+// reportStreamActive reports whether the stream of type t in the given
+// direction, "outgoing" or "incoming", to the remote is working.
+func reportStreamActive(direction string, remote types.ID, t streamType, active bool) {
+	v := 0.0
+	if active {
+		v = 1
+	}
+	streamActive.WithLabelValues(direction, remote.String(), string(t)).Set(v)
+}
+
+func reportRoundTripTime(remote types.ID, d time.Duration) {
+	peerRoundTripTime.WithLabelValues(remote.String()).Observe(float64(d.Nanoseconds() / int64(time.Microsecond)))
+}
+
+func reportCompression(remote string, uncompressed, compressed int64) {
+	streamUncompressedBytes.WithLabelValues(remote).Add(float64(uncompressed))
+	streamCompressedBytes.WithLabelValues(remote).Add(float64(compressed))
+}
@@ -0,0 +1,69 @@
+// Copyright 2015 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package rafthttp
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+)
+
+const (
+	MismatchClusterID = "clusterID"
+	MismatchMemberID  = "memberID"
+)
+
+// MismatchError is the body of the http.StatusPreconditionFailed response
+// to a request whose cluster ID or destination member ID does not match
+// the receiving member. It is usually caused by a peer URL that points to
+// another member or another cluster.
+type MismatchError struct {
+	// Field is MismatchClusterID or MismatchMemberID.
+	Field string `json:"field"`
+	// Got is the ID in the request, and Want is the ID of the receiver.
+	Got  string `json:"got"`
+	Want string `json:"want"`
+}
+
+func (e *MismatchError) Error() string {
+	return fmt.Sprintf("%s mismatch (got %s, want %s)", e.Field, e.Got, e.Want)
+}
+
+// writeMismatch rejects the request with the given mismatch error.
+func writeMismatch(w http.ResponseWriter, e *MismatchError) {
+	reportRejected(e.Field)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusPreconditionFailed)
+	if err := json.NewEncoder(w).Encode(e); err != nil {
+		log.Printf("rafthttp: failed to write mismatch error: %v", err)
+	}
+}
+
+// readMismatch decodes the mismatch error in the body of the response.
+// It returns nil if the body is not a mismatch error, which is the case
+// for the members that do not send it.
+func readMismatch(resp *http.Response) *MismatchError {
+	if mt, _, err := mime.ParseMediaType(resp.Header.Get("Content-Type")); err != nil || mt != "application/json" {
+		return nil
+	}
+	var e MismatchError
+	if err := json.NewDecoder(io.LimitReader(resp.Body, ConnReadLimitByte)).Decode(&e); err != nil || e.Field == "" {
+		return nil
+	}
+	return &e
+}
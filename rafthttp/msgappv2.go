@@ -30,6 +30,11 @@ const (
 	msgTypeLinkHeartbeat uint8 = 0
 	msgTypeAppEntries    uint8 = 1
 	msgTypeApp           uint8 = 2
+
+	// msgAppV2BufSize is the size of the buffers reused to encode and
+	// decode the entries and messages; the larger ones get a buffer of
+	// their own.
+	msgAppV2BufSize = 1024 * 1024
 )
 
 // msgappv2 stream sends three types of message: linkHeartbeatMessage,
@@ -56,55 +61,77 @@ const (
 // Data format of MsgApp:
 // | offset | bytes | description |
 // +--------+-------+-------------+
-// | 0      | 1     | \x01        |
+// | 0      | 1     | \x02        |
 // | 1      | 8     | length of encoded message |
 // | 9      | n     | encoded message |
+//
+// The encoder and the decoder reuse their buffers across the messages, so
+// that the hot path of the replication, a stream of AppEntries, neither goes
+// through reflection nor allocates for the framing.
 type msgAppV2Encoder struct {
 	w  io.Writer
 	fs *stats.FollowerStats
 
-	term  uint64
-	index uint64
+	term      uint64
+	index     uint64
+	buf       []byte
+	uint64buf []byte
+	uint8buf  []byte
+}
+
+func newMsgAppV2Encoder(w io.Writer, fs *stats.FollowerStats) *msgAppV2Encoder {
+	return &msgAppV2Encoder{
+		w:         w,
+		fs:        fs,
+		buf:       make([]byte, msgAppV2BufSize),
+		uint64buf: make([]byte, 8),
+		uint8buf:  make([]byte, 1),
+	}
 }
 
 func (enc *msgAppV2Encoder) encode(m raftpb.Message) error {
 	start := time.Now()
 	switch {
 	case isLinkHeartbeatMessage(m):
-		return binary.Write(enc.w, binary.BigEndian, msgTypeLinkHeartbeat)
+		enc.uint8buf[0] = msgTypeLinkHeartbeat
+		_, err := enc.w.Write(enc.uint8buf)
+		return err
 	case enc.index == m.Index && enc.term == m.LogTerm && m.LogTerm == m.Term:
-		if err := binary.Write(enc.w, binary.BigEndian, msgTypeAppEntries); err != nil {
+		enc.uint8buf[0] = msgTypeAppEntries
+		if _, err := enc.w.Write(enc.uint8buf); err != nil {
 			return err
 		}
 		// write length of entries
 		l := len(m.Entries)
-		if err := binary.Write(enc.w, binary.BigEndian, uint64(l)); err != nil {
+		if err := enc.writeUint64(uint64(l)); err != nil {
 			return err
 		}
 		for i := 0; i < l; i++ {
 			size := m.Entries[i].Size()
-			if err := binary.Write(enc.w, binary.BigEndian, uint64(size)); err != nil {
+			if err := enc.writeUint64(uint64(size)); err != nil {
 				return err
 			}
-			if _, err := enc.w.Write(pbutil.MustMarshal(&m.Entries[i])); err != nil {
+			if err := enc.writeMarshaled(&m.Entries[i], size); err != nil {
 				return err
 			}
 			enc.index++
 		}
 		// write commit index
-		if err := binary.Write(enc.w, binary.BigEndian, m.Commit); err != nil {
+		if err := enc.writeUint64(m.Commit); err != nil {
 			return err
 		}
 	default:
-		if err := binary.Write(enc.w, binary.BigEndian, msgTypeApp); err != nil {
+		enc.uint8buf[0] = msgTypeApp
+		if _, err := enc.w.Write(enc.uint8buf); err != nil {
 			return err
 		}
 		// write size of message
-		if err := binary.Write(enc.w, binary.BigEndian, uint64(m.Size())); err != nil {
+		size := m.Size()
+		if err := enc.writeUint64(uint64(size)); err != nil {
 			return err
 		}
 		// write message
-		if _, err := enc.w.Write(pbutil.MustMarshal(&m)); err != nil {
+		if err := enc.writeMarshaled(&m, size); err != nil {
 			return err
 		}
 
@@ -118,22 +145,60 @@ func (enc *msgAppV2Encoder) encode(m raftpb.Message) error {
 	return nil
 }
 
+func (enc *msgAppV2Encoder) writeUint64(n uint64) error {
+	binary.BigEndian.PutUint64(enc.uint64buf, n)
+	_, err := enc.w.Write(enc.uint64buf)
+	return err
+}
+
+// marshalerTo is a protobuf type which can marshal into a given buffer.
+type marshalerTo interface {
+	pbutil.Marshaler
+	MarshalTo(data []byte) (int, error)
+}
+
+// writeMarshaled writes v, whose marshaled size is size, through the reused
+// buffer if it fits.
+func (enc *msgAppV2Encoder) writeMarshaled(v marshalerTo, size int) error {
+	if size > len(enc.buf) {
+		_, err := enc.w.Write(pbutil.MustMarshal(v))
+		return err
+	}
+	if _, err := v.MarshalTo(enc.buf); err != nil {
+		return err
+	}
+	_, err := enc.w.Write(enc.buf[:size])
+	return err
+}
+
 type msgAppV2Decoder struct {
 	r             io.Reader
 	local, remote types.ID
 
-	term  uint64
-	index uint64
+	term      uint64
+	index     uint64
+	buf       []byte
+	uint64buf []byte
+	uint8buf  []byte
+}
+
+func newMsgAppV2Decoder(r io.Reader, local, remote types.ID) *msgAppV2Decoder {
+	return &msgAppV2Decoder{
+		r:         r,
+		local:     local,
+		remote:    remote,
+		buf:       make([]byte, msgAppV2BufSize),
+		uint64buf: make([]byte, 8),
+		uint8buf:  make([]byte, 1),
+	}
 }
 
 func (dec *msgAppV2Decoder) decode() (raftpb.Message, error) {
-	var (
-		m   raftpb.Message
-		typ uint8
-	)
-	if err := binary.Read(dec.r, binary.BigEndian, &typ); err != nil {
+	var m raftpb.Message
+	if _, err := io.ReadFull(dec.r, dec.uint8buf); err != nil {
 		return m, err
 	}
+	typ := dec.uint8buf[0]
 	switch typ {
 	case msgTypeLinkHeartbeat:
 		return linkHeartbeatMessage, nil
@@ -148,34 +213,36 @@ func (dec *msgAppV2Decoder) decode() (raftpb.Message, error) {
 		}
 
 		// decode entries
-		var l uint64
-		if err := binary.Read(dec.r, binary.BigEndian, &l); err != nil {
+		l, err := dec.readUint64()
+		if err != nil {
 			return m, err
 		}
 		m.Entries = make([]raftpb.Entry, int(l))
 		for i := 0; i < int(l); i++ {
-			var size uint64
-			if err := binary.Read(dec.r, binary.BigEndian, &size); err != nil {
+			size, err := dec.readUint64()
+			if err != nil {
 				return m, err
 			}
-			buf := make([]byte, int(size))
-			if _, err := io.ReadFull(dec.r, buf); err != nil {
+			buf, err := dec.read(int(size))
+			if err != nil {
 				return m, err
 			}
 			dec.index++
+			// Unmarshal copies the entry data out of buf, so buf can be
+			// reused.
 			pbutil.MustUnmarshal(&m.Entries[i], buf)
 		}
 		// decode commit index
-		if err := binary.Read(dec.r, binary.BigEndian, &m.Commit); err != nil {
+		if m.Commit, err = dec.readUint64(); err != nil {
 			return m, err
 		}
 	case msgTypeApp:
-		var size uint64
-		if err := binary.Read(dec.r, binary.BigEndian, &size); err != nil {
+		size, err := dec.readUint64()
+		if err != nil {
 			return m, err
 		}
-		buf := make([]byte, int(size))
-		if _, err := io.ReadFull(dec.r, buf); err != nil {
+		buf, err := dec.read(int(size))
+		if err != nil {
 			return m, err
 		}
 		pbutil.MustUnmarshal(&m, buf)
@@ -190,3 +257,21 @@ func (dec *msgAppV2Decoder) decode() (raftpb.Message, error) {
 	}
 	return m, nil
 }
+
+func (dec *msgAppV2Decoder) readUint64() (uint64, error) {
+	if _, err := io.ReadFull(dec.r, dec.uint64buf); err != nil {
+		return 0, err
+	}
+	return binary.BigEndian.Uint64(dec.uint64buf), nil
+}
+
+// read reads the next size bytes into the reused buffer if they fit.
+func (dec *msgAppV2Decoder) read(size int) ([]byte, error) {
+	buf := dec.buf
+	if size > len(buf) {
+		buf = make([]byte, size)
+	}
+	buf = buf[:size]
+	_, err := io.ReadFull(dec.r, buf)
+	return buf, err
+}
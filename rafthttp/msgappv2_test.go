@@ -25,6 +25,7 @@ import (
 )
 
 func TestMsgAppV2(t *testing.T) {
+	largeData := bytes.Repeat([]byte("a"), msgAppV2BufSize+1)
 	tests := []raftpb.Message{
 		linkHeartbeatMessage,
 		{
@@ -101,10 +102,34 @@ func TestMsgAppV2(t *testing.T) {
 			Entries: nil,
 		},
 		linkHeartbeatMessage,
+		// consecutive MsgApp with an entry larger than the reused buffer
+		{
+			Type:    raftpb.MsgApp,
+			From:    1,
+			To:      2,
+			Term:    3,
+			LogTerm: 3,
+			Index:   7,
+			Entries: []raftpb.Entry{
+				{Term: 3, Index: 8, Data: largeData},
+			},
+		},
+		// MsgApp larger than the reused buffer
+		{
+			Type:    raftpb.MsgApp,
+			From:    1,
+			To:      2,
+			Term:    4,
+			LogTerm: 3,
+			Index:   8,
+			Entries: []raftpb.Entry{
+				{Term: 4, Index: 9, Data: largeData},
+			},
+		},
 	}
 	b := &bytes.Buffer{}
-	enc := &msgAppV2Encoder{w: b, fs: &stats.FollowerStats{}}
-	dec := &msgAppV2Decoder{r: b, local: types.ID(2), remote: types.ID(1)}
+	enc := newMsgAppV2Encoder(b, &stats.FollowerStats{})
+	dec := newMsgAppV2Decoder(b, types.ID(2), types.ID(1))
 
 	for i, tt := range tests {
 		if err := enc.encode(tt); err != nil {
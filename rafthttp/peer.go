@@ -27,6 +27,10 @@ import (
 )
 
 const (
+	// DialTimeout, ConnReadTimeout and ConnWriteTimeout are the default
+	// timeouts of the connections to the peers. A congested network may
+	// need them raised, e.g. when the peers keep being reported
+	// unreachable.
 	DialTimeout = time.Second
 	// ConnRead/WriteTimeout is the i/o timeout set on each connection rafthttp pkg creates.
 	// A 5 seconds timeout is good enough for recycling bad connections. Or we have to wait for
@@ -51,6 +55,7 @@ const (
 	streamAppV2 = "streamMsgAppV2"
 	streamMsg   = "streamMsg"
 	pipelineMsg = "pipeline"
+	snapshotMsg = "snapshot"
 )
 
 var (
@@ -59,6 +64,7 @@ var (
 		streamAppV2: streamBufSize,
 		streamMsg:   streamBufSize,
 		pipelineMsg: pipelineBufSize,
+		snapshotMsg: snapshotBufSize,
 	}
 )
 
@@ -78,6 +84,12 @@ type Peer interface {
 	// Stop performs any necessary finalization and terminates the peer
 	// elegantly.
 	Stop()
+	// unreachableSince returns since when the remote has been unreachable,
+	// or the zero time if it is reachable.
+	unreachableSince() time.Time
+	// peerVersion returns the version the remote told in the last stream
+	// handshake, and false if there has been none.
+	peerVersion() (PeerVersion, bool)
 }
 
 // peer is the representative of a remote raft node. Local raft node sends
@@ -91,6 +103,8 @@ type Peer interface {
 // to the remote follower node.
 // A pipeline is a series of http clients that send http requests to the remote.
 // It is only used when the stream has not been established.
+// MsgSnap is sent by the snapshot sender, which streams the snapshot data
+// over its own connection.
 type peer struct {
 	// id of the remote raft peer node
 	id types.ID
@@ -99,6 +113,9 @@ type peer struct {
 	msgAppWriter *streamWriter
 	writer       *streamWriter
 	pipeline     *pipeline
+	snapSender   *snapshotSender
+	prober       *prober
+	status       *peerStatus
 
 	sendc    chan raftpb.Message
 	recvc    chan raftpb.Message
@@ -113,14 +130,18 @@ type peer struct {
 	done  chan struct{}
 }
 
-func startPeer(tr http.RoundTripper, urls types.URLs, local, to, cid types.ID, r Raft, fs *stats.FollowerStats, errorc chan error) *peer {
+func startPeer(tr http.RoundTripper, urls types.URLs, local, to, cid types.ID, r Raft, fs *stats.FollowerStats, errorc chan error, readTimeout time.Duration, snapLimiter *rateLimiter) *peer {
 	picker := newURLPicker(urls)
+	pipeline := newPipeline(tr, picker, to, cid, fs, r, errorc)
 	p := &peer{
 		id:           to,
 		r:            r,
-		msgAppWriter: startStreamWriter(to, fs, r),
-		writer:       startStreamWriter(to, fs, r),
-		pipeline:     newPipeline(tr, picker, to, cid, fs, r, errorc),
+		msgAppWriter: startStreamWriter(to, fs, r, readTimeout),
+		writer:       startStreamWriter(to, fs, r, readTimeout),
+		pipeline:     pipeline,
+		snapSender:   startSnapshotSender(tr, picker, local, to, cid, r, errorc, snapLimiter, pipeline.msgc),
+		prober:       startProber(tr, picker, to, cid),
+		status:       newPeerStatus(to),
 		sendc:        make(chan raftpb.Message),
 		recvc:        make(chan raftpb.Message, recvBufSize),
 		propc:        make(chan raftpb.Message, maxPendingProposals),
@@ -149,8 +170,8 @@ func startPeer(tr http.RoundTripper, urls types.URLs, local, to, cid types.ID, r
 
 	go func() {
 		var paused bool
-		msgAppReader := startStreamReader(tr, picker, streamTypeMsgAppV2, local, to, cid, p.recvc, p.propc)
-		reader := startStreamReader(tr, picker, streamTypeMessage, local, to, cid, p.recvc, p.propc)
+		msgAppReader := startStreamReader(tr, picker, []streamType{streamTypeMsgAppV2, streamTypeMsgApp}, local, to, cid, p.status, p.recvc, p.propc)
+		reader := startStreamReader(tr, picker, []streamType{streamTypeMessage}, local, to, cid, p.status, p.recvc, p.propc)
 		for {
 			select {
 			// 处理发送给远端peer的消息
@@ -168,6 +189,7 @@ func startPeer(tr http.RoundTripper, urls types.URLs, local, to, cid types.ID, r
 					}
 					log.Printf("peer: dropping %s to %s since %s with %d-size buffer is blocked",
 						m.Type, p.id, name, bufSizeMap[name])
+					reportDropped("send", p.id, m)
 				}
 			// 处理接收远端peer的消息
 			case mm := <-p.recvc:
@@ -187,7 +209,10 @@ func startPeer(tr http.RoundTripper, urls types.URLs, local, to, cid types.ID, r
 				cancel()
 				p.msgAppWriter.stop()
 				p.writer.stop()
+				// the snapshot sender may fall back to the pipeline
+				p.snapSender.stop()
 				p.pipeline.stop()
+				p.prober.stop()
 				msgAppReader.stop()
 				reader.stop()
 				close(p.done)
@@ -250,15 +275,22 @@ func (p *peer) Stop() {
 	<-p.done
 }
 
+func (p *peer) unreachableSince() time.Time { return p.status.unreachableSince() }
+
+func (p *peer) peerVersion() (PeerVersion, bool) { return p.status.peerVersion() }
+
 // pick picks a chan for sending the given message. The picked chan and the picked chan
 // string name are returned.
 func (p *peer) pick(m raftpb.Message) (writec chan<- raftpb.Message, picked string) {
 	var ok bool
 	// Considering MsgSnap may have a big size, e.g., 1G, and will block
-	// stream for a long time, only use one of the N pipelines to send MsgSnap.
+	// stream for a long time, send it over the dedicated snapshot connection.
 	if isMsgSnap(m) {
-		return p.pipeline.msgc, pipelineMsg
-	} else if writec, ok = p.msgAppWriter.writec(); ok && canUseMsgAppStream(m) {
+		return p.snapSender.msgc, snapshotMsg
+	} else if writec, ok = p.msgAppWriter.writec(); ok && canUseMsgAppStream(p.msgAppWriter.streamType(), m) {
+		if p.msgAppWriter.streamType() == streamTypeMsgAppV2 {
+			return writec, streamAppV2
+		}
 		return writec, streamApp
 	} else if writec, ok = p.writer.writec(); ok {
 		return writec, streamMsg
@@ -0,0 +1,117 @@
+// Copyright 2015 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package rafthttp
+
+import (
+	"log"
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/coreos/etcd/pkg/types"
+)
+
+const (
+	// streamRetryMinWait and streamRetryMaxWait bound the wait before
+	// dialing a peer stream again. The wait doubles on each failed dial, so
+	// that a member that is down or recovering is not hammered, and goes
+	// back to the minimum once a dial works.
+	streamRetryMinWait = 100 * time.Millisecond
+	streamRetryMaxWait = 5 * time.Second
+)
+
+// backoff computes the exponentially growing waits between the retries.
+// Each wait is jittered between its half and itself, so that the members
+// retrying the same peer spread out.
+type backoff struct {
+	min, max time.Duration
+	cur      time.Duration
+}
+
+func newBackoff(min, max time.Duration) *backoff {
+	return &backoff{min: min, max: max, cur: min}
+}
+
+// next returns the wait before the next retry.
+func (b *backoff) next() time.Duration {
+	d := b.cur/2 + time.Duration(rand.Int63n(int64(b.cur/2)+1))
+	if b.cur *= 2; b.cur > b.max {
+		b.cur = b.max
+	}
+	return d
+}
+
+func (b *backoff) reset() { b.cur = b.min }
+
+// peerStatus tracks since when a peer has been unreachable, from the first
+// failure to connect to it until a connection to it works again. The
+// transitions are logged, instead of each failure.
+type peerStatus struct {
+	id types.ID
+
+	mu    sync.Mutex
+	since time.Time // zero while the peer is reachable
+	// version is what the peer told of itself in the last stream
+	// handshake.
+	version *PeerVersion
+}
+
+func newPeerStatus(id types.ID) *peerStatus { return &peerStatus{id: id} }
+
+func (s *peerStatus) activate() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !s.since.IsZero() {
+		log.Printf("rafthttp: peer %s became reachable after %v", s.id, time.Since(s.since))
+		s.since = time.Time{}
+	}
+}
+
+func (s *peerStatus) deactivate(err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.since.IsZero() {
+		log.Printf("rafthttp: peer %s became unreachable: %v", s.id, err)
+		s.since = time.Now()
+	}
+}
+
+// unreachableSince returns since when the peer has been unreachable, or the
+// zero time if it is reachable.
+func (s *peerStatus) unreachableSince() time.Time {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.since
+}
+
+func (s *peerStatus) setVersion(v PeerVersion) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.version == nil || s.version.Server != v.Server {
+		log.Printf("rafthttp: peer %s runs version %q with the stream types %v", s.id, v.Server, v.StreamTypes)
+	}
+	s.version = &v
+}
+
+// peerVersion returns the version of the peer, and false if it has not
+// been learned yet.
+func (s *peerStatus) peerVersion() (PeerVersion, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.version == nil {
+		return PeerVersion{}, false
+	}
+	return *s.version, true
+}
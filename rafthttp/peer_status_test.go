@@ -0,0 +1,66 @@
+// Copyright 2015 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package rafthttp
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/coreos/etcd/pkg/types"
+)
+
+func TestBackoff(t *testing.T) {
+	b := newBackoff(100*time.Millisecond, time.Second)
+	// the waits before jitter
+	wmax := []time.Duration{
+		100 * time.Millisecond,
+		200 * time.Millisecond,
+		400 * time.Millisecond,
+		800 * time.Millisecond,
+		time.Second,
+		time.Second,
+	}
+	for i, w := range wmax {
+		if d := b.next(); d < w/2 || d > w {
+			t.Errorf("#%d: wait = %v, want in [%v, %v]", i, d, w/2, w)
+		}
+	}
+	b.reset()
+	if d := b.next(); d < 50*time.Millisecond || d > 100*time.Millisecond {
+		t.Errorf("wait after reset = %v, want in [50ms, 100ms]", d)
+	}
+}
+
+func TestPeerStatus(t *testing.T) {
+	s := newPeerStatus(types.ID(1))
+	if since := s.unreachableSince(); !since.IsZero() {
+		t.Fatalf("unreachableSince = %v, want zero", since)
+	}
+	s.deactivate(errors.New("dial error"))
+	since := s.unreachableSince()
+	if since.IsZero() {
+		t.Fatalf("unreachableSince = zero, want not zero")
+	}
+	// the later failures keep the time of the first one
+	s.deactivate(errors.New("dial error"))
+	if g := s.unreachableSince(); g != since {
+		t.Errorf("unreachableSince = %v, want %v", g, since)
+	}
+	s.activate()
+	if g := s.unreachableSince(); !g.IsZero() {
+		t.Errorf("unreachableSince = %v, want zero", g)
+	}
+}
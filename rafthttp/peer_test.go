@@ -30,7 +30,7 @@ func TestPeerPick(t *testing.T) {
 		{
 			true, true,
 			raftpb.Message{Type: raftpb.MsgSnap},
-			pipelineMsg,
+			snapshotMsg,
 		},
 		{
 			true, true,
@@ -65,7 +65,7 @@ func TestPeerPick(t *testing.T) {
 		{
 			false, false,
 			raftpb.Message{Type: raftpb.MsgSnap},
-			pipelineMsg,
+			snapshotMsg,
 		},
 		{
 			false, false,
@@ -78,6 +78,35 @@ func TestPeerPick(t *testing.T) {
 			msgAppWriter: &streamWriter{working: tt.msgappWorking},
 			writer:       &streamWriter{working: tt.messageWorking},
 			pipeline:     &pipeline{},
+			snapSender:   &snapshotSender{},
+		}
+		_, picked := peer.pick(tt.m)
+		if picked != tt.wpicked {
+			t.Errorf("#%d: picked = %v, want %v", i, picked, tt.wpicked)
+		}
+	}
+}
+
+// TestPeerPickMsgAppStream tests that the MsgApps of an older term than
+// their entries are sent on the msgappv2 stream, but not on the msgapp
+// stream of v2.0, which can only encode the MsgApps of its term.
+func TestPeerPickMsgAppStream(t *testing.T) {
+	tests := []struct {
+		t       streamType
+		m       raftpb.Message
+		wpicked string
+	}{
+		{streamTypeMsgAppV2, raftpb.Message{Type: raftpb.MsgApp, Term: 1, LogTerm: 1}, streamAppV2},
+		{streamTypeMsgAppV2, raftpb.Message{Type: raftpb.MsgApp, Term: 2, LogTerm: 1}, streamAppV2},
+		{streamTypeMsgAppV2, raftpb.Message{Type: raftpb.MsgProp}, streamMsg},
+		{streamTypeMsgApp, raftpb.Message{Type: raftpb.MsgApp, Term: 1, LogTerm: 1}, streamApp},
+		{streamTypeMsgApp, raftpb.Message{Type: raftpb.MsgApp, Term: 2, LogTerm: 1}, streamMsg},
+	}
+	for i, tt := range tests {
+		peer := &peer{
+			msgAppWriter: &streamWriter{working: true, t: tt.t},
+			writer:       &streamWriter{working: true},
+			pipeline:     &pipeline{},
 		}
 		_, picked := peer.pick(tt.m)
 		if picked != tt.wpicked {
@@ -144,10 +144,15 @@ func (p *pipeline) post(data []byte) error {
 		p.picker.unreachable(u)
 		return err
 	}
-	resp.Body.Close()
+	defer resp.Body.Close()
 
 	switch resp.StatusCode {
 	case http.StatusPreconditionFailed:
+		// the url points to another member of the cluster
+		if me := readMismatch(resp); me != nil && me.Field == MismatchMemberID {
+			p.picker.unreachable(u)
+			return fmt.Errorf("member at %s rejected the message: %v", u.String(), me)
+		}
 		err := fmt.Errorf("conflicting cluster ID with the target cluster (%s != %s)", resp.Header.Get("X-Etcd-Cluster-ID"), p.cid)
 		select {
 		case p.errorc <- err:
@@ -18,10 +18,13 @@ import (
 	"errors"
 	"io/ioutil"
 	"net/http"
+	"net/http/httptest"
+	"strings"
 	"sync"
 	"testing"
 
 	"github.com/coreos/etcd/etcdserver/stats"
+	"github.com/coreos/etcd/pkg/pbutil"
 	"github.com/coreos/etcd/pkg/testutil"
 	"github.com/coreos/etcd/pkg/types"
 	"github.com/coreos/etcd/raft/raftpb"
@@ -181,6 +184,28 @@ func TestPipelinePostErrorc(t *testing.T) {
 	}
 }
 
+// TestPipelinePostMemberMismatch tests that the rejection of a message
+// sent to the wrong member fails the post without stopping the member.
+func TestPipelinePostMemberMismatch(t *testing.T) {
+	srv := httptest.NewServer(NewHandler(&fakeRaft{}, types.ID(3), types.ID(1)))
+	defer srv.Close()
+
+	picker := mustNewURLPicker(t, []string{srv.URL})
+	errorc := make(chan error, 1)
+	p := newPipeline(&http.Transport{}, picker, types.ID(2), types.ID(1), nil, &fakeRaft{}, errorc)
+	err := p.post(pbutil.MustMarshal(&raftpb.Message{Type: raftpb.MsgApp, From: 1, To: 2}))
+	p.stop()
+
+	if err == nil || !strings.Contains(err.Error(), MismatchMemberID) {
+		t.Errorf("err = %v, want member ID mismatch", err)
+	}
+	select {
+	case err := <-errorc:
+		t.Errorf("unexpected error %v on errorc", err)
+	default:
+	}
+}
+
 type roundTripperBlocker struct {
 	c chan struct{}
 }
@@ -0,0 +1,97 @@
+// Copyright 2015 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package rafthttp
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/coreos/etcd/pkg/types"
+)
+
+// proberInterval is the interval between two probes to the remote.
+const proberInterval = 5 * time.Second
+
+// prober measures the round-trip time to the remote by sending it a
+// probe periodically. The probes are answered by the probing handler.
+// The probes that fail are ignored, since the remote may be an older
+// member that cannot answer them.
+type prober struct {
+	id  types.ID
+	cid types.ID
+
+	tr     http.RoundTripper
+	picker *urlPicker
+
+	stopc chan struct{}
+	done  chan struct{}
+}
+
+func startProber(tr http.RoundTripper, picker *urlPicker, id, cid types.ID) *prober {
+	p := &prober{
+		id:     id,
+		cid:    cid,
+		tr:     tr,
+		picker: picker,
+		stopc:  make(chan struct{}),
+		done:   make(chan struct{}),
+	}
+	go p.run()
+	return p
+}
+
+func (p *prober) stop() {
+	close(p.stopc)
+	<-p.done
+}
+
+func (p *prober) run() {
+	defer close(p.done)
+	ticker := time.NewTicker(proberInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			if d, ok := p.probe(); ok {
+				reportRoundTripTime(p.id, d)
+			}
+		case <-p.stopc:
+			return
+		}
+	}
+}
+
+// probe sends a probe to the remote and returns the round-trip time.
+func (p *prober) probe() (time.Duration, bool) {
+	u := p.picker.pick()
+	uu := u
+	uu.Path = RaftProbingPrefix
+	req, err := http.NewRequest("GET", uu.String(), nil)
+	if err != nil {
+		return 0, false
+	}
+	req.Header.Set("X-Etcd-Cluster-ID", p.cid.String())
+
+	start := time.Now()
+	resp, err := roundTripUntilStop(p.tr, req, p.stopc)
+	if err != nil {
+		return 0, false
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusNoContent {
+		return 0, false
+	}
+	return time.Since(start), true
+}
@@ -0,0 +1,65 @@
+// Copyright 2015 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package rafthttp
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/coreos/etcd/pkg/testutil"
+	"github.com/coreos/etcd/pkg/types"
+)
+
+func TestProberProbe(t *testing.T) {
+	tests := []struct {
+		h   http.Handler
+		wok bool
+	}{
+		{newProbingHandler(types.ID(1)), true},
+		// cluster ID mismatch
+		{newProbingHandler(types.ID(2)), false},
+		// the remote does not support probing
+		{http.NotFoundHandler(), false},
+	}
+	for i, tt := range tests {
+		srv := httptest.NewServer(tt.h)
+		p := &prober{
+			id:     types.ID(2),
+			cid:    types.ID(1),
+			tr:     &http.Transport{},
+			picker: newURLPicker(testutil.MustNewURLs(t, []string{srv.URL})),
+			stopc:  make(chan struct{}),
+		}
+		d, ok := p.probe()
+		if ok != tt.wok {
+			t.Errorf("#%d: ok = %v, want %v", i, ok, tt.wok)
+		}
+		if ok && d <= 0 {
+			t.Errorf("#%d: round-trip time = %v, want > 0", i, d)
+		}
+		srv.Close()
+	}
+}
+
+func TestProbingHandlerMethod(t *testing.T) {
+	req, _ := http.NewRequest("POST", "http://localhost:7001"+RaftProbingPrefix, nil)
+	req.Header.Set("X-Etcd-Cluster-ID", "1")
+	rw := httptest.NewRecorder()
+	newProbingHandler(types.ID(1)).ServeHTTP(rw, req)
+	if rw.Code != http.StatusMethodNotAllowed {
+		t.Errorf("code = %d, want %d", rw.Code, http.StatusMethodNotAllowed)
+	}
+}
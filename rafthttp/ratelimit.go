@@ -0,0 +1,80 @@
+// Copyright 2015 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package rafthttp
+
+import (
+	"errors"
+	"io"
+	"sync"
+	"time"
+)
+
+var errRateLimiterStopped = errors.New("rafthttp: stopped waiting for the rate limiter")
+
+// rateLimiter limits the rate of the bytes passing through it. It is
+// shared by the snapshot senders to all the peers, so sending snapshots
+// does not starve the heartbeats and the appends on the same link.
+type rateLimiter struct {
+	// rate is the number of bytes allowed per second; zero is unlimited.
+	rate int64
+
+	mu sync.Mutex
+	// next is the time at which the bytes allowed so far have been sent.
+	next time.Time
+}
+
+func newRateLimiter(rate int64) *rateLimiter {
+	return &rateLimiter{rate: rate}
+}
+
+// wait blocks until n more bytes are allowed, or stopc is closed. A nil
+// rateLimiter allows any rate.
+func (l *rateLimiter) wait(n int, stopc <-chan struct{}) error {
+	if l == nil || l.rate <= 0 {
+		return nil
+	}
+	l.mu.Lock()
+	now := time.Now()
+	if l.next.Before(now) {
+		l.next = now
+	}
+	d := l.next.Sub(now)
+	l.next = l.next.Add(time.Duration(int64(n) * int64(time.Second) / l.rate))
+	l.mu.Unlock()
+
+	if d <= 0 {
+		return nil
+	}
+	select {
+	case <-time.After(d):
+		return nil
+	case <-stopc:
+		return errRateLimiterStopped
+	}
+}
+
+// rateLimitedWriter writes to w at the rate l allows.
+type rateLimitedWriter struct {
+	w     io.Writer
+	l     *rateLimiter
+	stopc <-chan struct{}
+}
+
+func (w *rateLimitedWriter) Write(p []byte) (int, error) {
+	if err := w.l.wait(len(p), w.stopc); err != nil {
+		return 0, err
+	}
+	return w.w.Write(p)
+}
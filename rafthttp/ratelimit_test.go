@@ -0,0 +1,75 @@
+// Copyright 2015 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package rafthttp
+
+import (
+	"bytes"
+	"testing"
+	"time"
+)
+
+func TestRateLimitedWriter(t *testing.T) {
+	// 10 writes of 10KB at 200KB/s take 450ms, as the first write is not
+	// delayed.
+	l := newRateLimiter(200 * 1024)
+	var buf bytes.Buffer
+	w := &rateLimitedWriter{w: &buf, l: l}
+	start := time.Now()
+	for i := 0; i < 10; i++ {
+		if _, err := w.Write(make([]byte, 10*1024)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if d := time.Since(start); d < 400*time.Millisecond {
+		t.Errorf("writes took %v, want at least %v", d, 400*time.Millisecond)
+	}
+	if buf.Len() != 100*1024 {
+		t.Errorf("len = %d, want %d", buf.Len(), 100*1024)
+	}
+}
+
+func TestRateLimiterUnlimited(t *testing.T) {
+	for i, l := range []*rateLimiter{nil, newRateLimiter(0)} {
+		start := time.Now()
+		for j := 0; j < 10; j++ {
+			if err := l.wait(1024*1024, nil); err != nil {
+				t.Fatalf("#%d: err = %v, want nil", i, err)
+			}
+		}
+		if d := time.Since(start); d > 100*time.Millisecond {
+			t.Errorf("#%d: waits took %v, want no wait", i, d)
+		}
+	}
+}
+
+func TestRateLimiterStop(t *testing.T) {
+	l := newRateLimiter(1024)
+	stopc := make(chan struct{})
+	// the first wait passes at once, and the second one has to wait 10s
+	if err := l.wait(10*1024, stopc); err != nil {
+		t.Fatal(err)
+	}
+	errc := make(chan error, 1)
+	go func() { errc <- l.wait(1024, stopc) }()
+	close(stopc)
+	select {
+	case err := <-errc:
+		if err != errRateLimiterStopped {
+			t.Errorf("err = %v, want %v", err, errRateLimiterStopped)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("failed to stop waiting")
+	}
+}
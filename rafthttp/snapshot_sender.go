@@ -0,0 +1,288 @@
+// Copyright 2015 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package rafthttp
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/coreos/etcd/pkg/types"
+	"github.com/coreos/etcd/raft"
+	"github.com/coreos/etcd/raft/raftpb"
+)
+
+const (
+	// snapChunkSize is the size of the chunks the snapshot data is
+	// streamed in.
+	snapChunkSize = 1024 * 1024
+	// snapMaxRetries is the number of times a failed snapshot send is
+	// resumed before the failure is reported to raft.
+	snapMaxRetries = 3
+	// snapRetryInterval is the time to wait before resuming a failed
+	// snapshot send.
+	snapRetryInterval = 100 * time.Millisecond
+	// snapshotBufSize is the size of snapshot sender buffer. Raft sends
+	// at most one snapshot to a follower at a time.
+	snapshotBufSize = 1
+)
+
+var errSnapshotNotSupported = errors.New("rafthttp: the remote does not support snapshot streaming")
+
+// snapshotSender sends MsgSnap to the remote over a dedicated HTTP
+// connection. The message is sent first without the snapshot data, and
+// the data follows in chunks, so the snapshot is never marshaled into
+// one buffer. If the connection breaks, the send is resumed from the
+// offset the remote has received.
+//
+// The protocol is:
+//  1. GET RaftSnapshotPrefix asks the remote for the offset of the
+//     snapshot it has received so far.
+//  2. POST RaftSnapshotPrefix streams the message and the data from that
+//     offset. The remote processes the message once the data is complete.
+type snapshotSender struct {
+	id   types.ID
+	from types.ID
+	cid  types.ID
+
+	tr     http.RoundTripper
+	picker *urlPicker
+	r      Raft
+	errorc chan error
+	// limiter limits the rate the snapshot data is sent at.
+	limiter *rateLimiter
+	// fallbackc sends the message through the pipeline if the remote
+	// does not support snapshot streaming.
+	fallbackc chan<- raftpb.Message
+
+	msgc  chan raftpb.Message
+	stopc chan struct{}
+	done  chan struct{}
+}
+
+func startSnapshotSender(tr http.RoundTripper, picker *urlPicker, from, id, cid types.ID, r Raft, errorc chan error, limiter *rateLimiter, fallbackc chan<- raftpb.Message) *snapshotSender {
+	s := &snapshotSender{
+		id:        id,
+		from:      from,
+		cid:       cid,
+		tr:        tr,
+		picker:    picker,
+		r:         r,
+		errorc:    errorc,
+		limiter:   limiter,
+		fallbackc: fallbackc,
+		msgc:      make(chan raftpb.Message, snapshotBufSize),
+		stopc:     make(chan struct{}),
+		done:      make(chan struct{}),
+	}
+	go s.run()
+	return s
+}
+
+func (s *snapshotSender) stop() {
+	close(s.stopc)
+	<-s.done
+}
+
+func (s *snapshotSender) run() {
+	defer close(s.done)
+	for {
+		select {
+		case m := <-s.msgc:
+			s.handle(m)
+		case <-s.stopc:
+			return
+		}
+	}
+}
+
+func (s *snapshotSender) handle(m raftpb.Message) {
+	start := time.Now()
+	var err error
+	for i := 0; i <= snapMaxRetries; i++ {
+		if i > 0 {
+			log.Printf("snapshot: resuming sending snapshot [index: %d] to %s: %v", m.Snapshot.Metadata.Index, s.id, err)
+			select {
+			case <-time.After(snapRetryInterval):
+			case <-s.stopc:
+				s.r.ReportSnapshot(m.To, raft.SnapshotFailure)
+				return
+			}
+		}
+		if err = s.send(m); err == nil || err == errSnapshotNotSupported {
+			break
+		}
+	}
+
+	switch err {
+	case nil:
+		reportSentDuration(snapshotMsg, m, time.Since(start))
+		s.r.ReportSnapshot(m.To, raft.SnapshotFinish)
+	case errSnapshotNotSupported:
+		select {
+		case s.fallbackc <- m:
+		default:
+			reportSentFailure(snapshotMsg, m)
+			s.r.ReportUnreachable(m.To)
+			s.r.ReportSnapshot(m.To, raft.SnapshotFailure)
+		}
+	default:
+		log.Printf("snapshot: error sending snapshot [index: %d] to %s: %v", m.Snapshot.Metadata.Index, s.id, err)
+		reportSentFailure(snapshotMsg, m)
+		s.r.ReportUnreachable(m.To)
+		s.r.ReportSnapshot(m.To, raft.SnapshotFailure)
+	}
+}
+
+// send sends the snapshot from the offset the remote has received.
+func (s *snapshotSender) send(m raftpb.Message) error {
+	u := s.picker.pick()
+	offset, err := s.offset(u, m)
+	if err != nil {
+		return err
+	}
+	if offset > uint64(len(m.Snapshot.Data)) {
+		offset = 0
+	}
+
+	pr, pw := io.Pipe()
+	go func() {
+		w := &rateLimitedWriter{w: pw, l: s.limiter, stopc: s.stopc}
+		pw.CloseWithError(writeSnapshotTo(w, m, offset))
+	}()
+	defer pr.Close()
+
+	req, err := s.newRequest(u, "POST", pr, m)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/octet-stream")
+	req.Header.Set("X-Raft-Snapshot-Offset", strconv.FormatUint(offset, 10))
+	resp, err := s.roundTrip(req)
+	if err != nil {
+		s.picker.unreachable(u)
+		return err
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusNoContent:
+		return nil
+	case http.StatusPreconditionFailed, http.StatusForbidden:
+		return s.reportError(u, resp)
+	default:
+		return fmt.Errorf("unexpected http status %s while sending snapshot to %q", http.StatusText(resp.StatusCode), req.URL.String())
+	}
+}
+
+// offset asks the remote how much of the snapshot data it has received.
+func (s *snapshotSender) offset(u url.URL, m raftpb.Message) (uint64, error) {
+	req, err := s.newRequest(u, "GET", nil, m)
+	if err != nil {
+		return 0, err
+	}
+	resp, err := s.roundTrip(req)
+	if err != nil {
+		s.picker.unreachable(u)
+		return 0, err
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+		return strconv.ParseUint(resp.Header.Get("X-Raft-Snapshot-Offset"), 10, 64)
+	case http.StatusNotFound, http.StatusMethodNotAllowed:
+		return 0, errSnapshotNotSupported
+	case http.StatusPreconditionFailed, http.StatusForbidden:
+		return 0, s.reportError(u, resp)
+	default:
+		return 0, fmt.Errorf("unexpected http status %s while getting snapshot offset from %q", http.StatusText(resp.StatusCode), req.URL.String())
+	}
+}
+
+func (s *snapshotSender) newRequest(u url.URL, method string, body io.Reader, m raftpb.Message) (*http.Request, error) {
+	uu := u
+	uu.Path = RaftSnapshotPrefix
+	req, err := http.NewRequest(method, uu.String(), body)
+	if err != nil {
+		s.picker.unreachable(u)
+		return nil, err
+	}
+	req.Header.Set("X-Etcd-Cluster-ID", s.cid.String())
+	req.Header.Set("X-Raft-From", s.from.String())
+	req.Header.Set("X-Raft-To", s.id.String())
+	req.Header.Set("X-Raft-Snapshot-Term", strconv.FormatUint(m.Snapshot.Metadata.Term, 10))
+	req.Header.Set("X-Raft-Snapshot-Index", strconv.FormatUint(m.Snapshot.Metadata.Index, 10))
+	req.Header.Set("X-Raft-Snapshot-Size", strconv.Itoa(len(m.Snapshot.Data)))
+	return req, nil
+}
+
+// roundTrip sends the request and cancels it if the sender is stopped.
+func (s *snapshotSender) roundTrip(req *http.Request) (*http.Response, error) {
+	return roundTripUntilStop(s.tr, req, s.stopc)
+}
+
+// reportError reports the rejection of the request which requires the
+// member to stop to errorc, and returns it as the error of the request.
+func (s *snapshotSender) reportError(u url.URL, resp *http.Response) error {
+	var err error
+	switch resp.StatusCode {
+	case http.StatusPreconditionFailed:
+		// the url points to another member of the cluster
+		if me := readMismatch(resp); me != nil && me.Field == MismatchMemberID {
+			s.picker.unreachable(u)
+			return fmt.Errorf("member at %s rejected the snapshot: %v", u.String(), me)
+		}
+		err = fmt.Errorf("conflicting cluster ID with the target cluster (%s != %s)", resp.Header.Get("X-Etcd-Cluster-ID"), s.cid)
+	case http.StatusForbidden:
+		err = fmt.Errorf("the member has been permanently removed from the cluster")
+	}
+	select {
+	case s.errorc <- err:
+	default:
+	}
+	return err
+}
+
+// writeSnapshotTo writes the message without the snapshot data, followed
+// by the snapshot data from the given offset in chunks. A zero size chunk
+// ends the data.
+func writeSnapshotTo(w io.Writer, m raftpb.Message, offset uint64) error {
+	data := m.Snapshot.Data
+	m.Snapshot.Data = nil
+	b, err := m.Marshal()
+	if err != nil {
+		return err
+	}
+	if err := writeChunkTo(w, b); err != nil {
+		return err
+	}
+	for data = data[offset:]; len(data) > 0; {
+		n := snapChunkSize
+		if n > len(data) {
+			n = len(data)
+		}
+		if err := writeChunkTo(w, data[:n]); err != nil {
+			return err
+		}
+		data = data[n:]
+	}
+	return writeChunkTo(w, nil)
+}
@@ -0,0 +1,201 @@
+// Copyright 2015 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package rafthttp
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/coreos/etcd/pkg/testutil"
+	"github.com/coreos/etcd/pkg/types"
+	"github.com/coreos/etcd/raft"
+	"github.com/coreos/etcd/raft/raftpb"
+)
+
+// TestSnapshotSenderResume tests that snapshotSender resumes a broken
+// transfer from the offset the remote has received.
+func TestSnapshotSenderResume(t *testing.T) {
+	recvc := make(chan raftpb.Message, 1)
+	srv := httptest.NewServer(newSnapshotHandler(&fakeRaft{recvc: recvc}, types.ID(2), types.ID(1), new(int32)))
+	defer srv.Close()
+
+	// the first POST breaks after one and a half chunks
+	tr := &brokenRoundTripper{tr: &http.Transport{}, limit: snapChunkSize * 3 / 2}
+	r := &snapshotRaftRecorder{}
+	s := startSnapshotSender(tr, newURLPicker(testutil.MustNewURLs(t, []string{srv.URL})), types.ID(1), types.ID(2), types.ID(1), r, nil, nil, nil)
+	defer s.stop()
+
+	data := make([]byte, 3*snapChunkSize+10)
+	for i := range data {
+		data[i] = byte(i)
+	}
+	m := raftpb.Message{Type: raftpb.MsgSnap, From: 1, To: 2, Term: 1, Snapshot: raftpb.Snapshot{Metadata: raftpb.SnapshotMetadata{Index: 1000, Term: 1}, Data: data}}
+	s.msgc <- m
+
+	select {
+	case g := <-recvc:
+		if !reflect.DeepEqual(g, m) {
+			t.Errorf("received message differs from the sent one")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("failed to receive snapshot")
+	}
+	if w := []raft.SnapshotStatus{raft.SnapshotFinish}; !reflect.DeepEqual(r.waitStatuses(), w) {
+		t.Errorf("statuses = %v, want %v", r.statuses(), w)
+	}
+	if w := []string{"0", "1048576"}; !reflect.DeepEqual(tr.offsets, w) {
+		t.Errorf("offsets = %v, want %v", tr.offsets, w)
+	}
+}
+
+// TestSnapshotSenderFallback tests that snapshotSender sends MsgSnap
+// through the fallback channel if the remote does not support
+// snapshot streaming.
+func TestSnapshotSenderFallback(t *testing.T) {
+	fallbackc := make(chan raftpb.Message, 1)
+	r := &snapshotRaftRecorder{}
+	s := startSnapshotSender(newRespRoundTripper(http.StatusNotFound, nil), newURLPicker(testutil.MustNewURLs(t, []string{"http://localhost:7001"})), types.ID(1), types.ID(2), types.ID(1), r, nil, nil, fallbackc)
+	defer s.stop()
+
+	m := raftpb.Message{Type: raftpb.MsgSnap, From: 1, To: 2, Snapshot: raftpb.Snapshot{Metadata: raftpb.SnapshotMetadata{Index: 1000, Term: 1}}}
+	s.msgc <- m
+	select {
+	case g := <-fallbackc:
+		if !reflect.DeepEqual(g, m) {
+			t.Errorf("message = %+v, want %+v", g, m)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("failed to fall back")
+	}
+	if len(r.statuses()) != 0 {
+		t.Errorf("statuses = %v, want none", r.statuses())
+	}
+}
+
+// TestSnapshotSenderFailed tests that snapshotSender reports the failure
+// to raft after the retries fail.
+func TestSnapshotSenderFailed(t *testing.T) {
+	r := &snapshotRaftRecorder{}
+	s := startSnapshotSender(newRespRoundTripper(0, errors.New("blah")), newURLPicker(testutil.MustNewURLs(t, []string{"http://localhost:7001"})), types.ID(1), types.ID(2), types.ID(1), r, nil, nil, nil)
+	defer s.stop()
+
+	s.msgc <- raftpb.Message{Type: raftpb.MsgSnap, From: 1, To: 2}
+	if w := []raft.SnapshotStatus{raft.SnapshotFailure}; !reflect.DeepEqual(r.waitStatuses(), w) {
+		t.Errorf("statuses = %v, want %v", r.statuses(), w)
+	}
+}
+
+func TestSnapshotHandlerBad(t *testing.T) {
+	tests := []struct {
+		method string
+		header map[string]string
+
+		wcode int
+	}{
+		{"PUT", nil, http.StatusMethodNotAllowed},
+		{"GET", map[string]string{"X-Etcd-Cluster-ID": "2"}, http.StatusPreconditionFailed},
+		{"GET", map[string]string{"X-Raft-To": "3"}, http.StatusPreconditionFailed},
+		{"GET", map[string]string{"X-Raft-From": "xyz"}, http.StatusBadRequest},
+		{"GET", map[string]string{"X-Raft-Snapshot-Size": "bad"}, http.StatusBadRequest},
+		// no data has been received at the offset
+		{"POST", map[string]string{"X-Raft-Snapshot-Offset": "10"}, http.StatusConflict},
+	}
+	for i, tt := range tests {
+		req, _ := http.NewRequest(tt.method, "http://localhost:7001"+RaftSnapshotPrefix, &bytes.Buffer{})
+		req.Header.Set("X-Etcd-Cluster-ID", "1")
+		req.Header.Set("X-Raft-From", "2")
+		req.Header.Set("X-Raft-Snapshot-Term", "1")
+		req.Header.Set("X-Raft-Snapshot-Index", "1000")
+		req.Header.Set("X-Raft-Snapshot-Size", "10")
+		for k, v := range tt.header {
+			req.Header.Set(k, v)
+		}
+		rw := httptest.NewRecorder()
+		newSnapshotHandler(&fakeRaft{}, types.ID(1), types.ID(1), new(int32)).ServeHTTP(rw, req)
+		if rw.Code != tt.wcode {
+			t.Errorf("#%d: code = %d, want %d", i, rw.Code, tt.wcode)
+		}
+	}
+}
+
+// brokenRoundTripper breaks the body of the first POST request after
+// limit bytes, and records the offset of every POST request.
+type brokenRoundTripper struct {
+	tr      http.RoundTripper
+	limit   int
+	broken  bool
+	offsets []string
+}
+
+func (t *brokenRoundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.Method == "POST" {
+		t.offsets = append(t.offsets, req.Header.Get("X-Raft-Snapshot-Offset"))
+		if !t.broken {
+			t.broken = true
+			req.Body = &brokenReader{ReadCloser: req.Body, n: t.limit}
+		}
+	}
+	return t.tr.RoundTrip(req)
+}
+
+type brokenReader struct {
+	io.ReadCloser
+	n int
+}
+
+func (r *brokenReader) Read(p []byte) (int, error) {
+	if r.n <= 0 {
+		return 0, errors.New("broken")
+	}
+	if len(p) > r.n {
+		p = p[:r.n]
+	}
+	n, err := r.ReadCloser.Read(p)
+	r.n -= n
+	return n, err
+}
+
+type snapshotRaftRecorder struct {
+	fakeRaft
+	mu sync.Mutex
+	ss []raft.SnapshotStatus
+}
+
+func (r *snapshotRaftRecorder) ReportSnapshot(id uint64, status raft.SnapshotStatus) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.ss = append(r.ss, status)
+}
+
+func (r *snapshotRaftRecorder) statuses() []raft.SnapshotStatus {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.ss
+}
+
+// waitStatuses waits for a reported status for at most one second.
+func (r *snapshotRaftRecorder) waitStatuses() []raft.SnapshotStatus {
+	for i := 0; i < 100 && len(r.statuses()) == 0; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	return r.statuses()
+}
@@ -79,10 +79,15 @@ type streamWriter struct {
 	id types.ID
 	fs *stats.FollowerStats
 	r  Raft
+	// heartbeatInterval is the interval of the linkHeartbeatMessages,
+	// which keep the stream from timing out on the remote.
+	heartbeatInterval time.Duration
 
-	mu      sync.Mutex // guard field working and closer
+	mu      sync.Mutex // guard field working, closer and t
 	closer  io.Closer
 	working bool
+	// t is the type of the attached stream
+	t streamType
 
 	msgc  chan raftpb.Message
 	connc chan *outgoingConn
@@ -90,15 +95,18 @@ type streamWriter struct {
 	done  chan struct{}
 }
 
-func startStreamWriter(id types.ID, fs *stats.FollowerStats, r Raft) *streamWriter {
+// startStreamWriter starts a streamWriter to the remote whose connections
+// time out on no read in readTimeout.
+func startStreamWriter(id types.ID, fs *stats.FollowerStats, r Raft, readTimeout time.Duration) *streamWriter {
 	w := &streamWriter{
-		id:    id,
-		fs:    fs,
-		r:     r,
-		msgc:  make(chan raftpb.Message, streamBufSize),
-		connc: make(chan *outgoingConn),
-		stopc: make(chan struct{}),
-		done:  make(chan struct{}),
+		id:                id,
+		fs:                fs,
+		r:                 r,
+		heartbeatInterval: readTimeout / 3,
+		msgc:              make(chan raftpb.Message, streamBufSize),
+		connc:             make(chan *outgoingConn),
+		stopc:             make(chan struct{}),
+		done:              make(chan struct{}),
 	}
 	go w.run()
 	return w
@@ -111,7 +119,7 @@ func (cw *streamWriter) run() {
 	var msgAppTerm uint64
 	var enc encoder
 	var flusher http.Flusher
-	tickc := time.Tick(ConnReadTimeout / 3)
+	tickc := time.Tick(cw.heartbeatInterval)
 
 	for {
 		select {
@@ -162,7 +170,7 @@ func (cw *streamWriter) run() {
 				}
 				enc = &msgAppEncoder{w: conn.Writer, fs: cw.fs}
 			case streamTypeMsgAppV2:
-				enc = &msgAppV2Encoder{w: conn.Writer, fs: cw.fs}
+				enc = newMsgAppV2Encoder(conn.Writer, cw.fs)
 			case streamTypeMessage:
 				enc = &messageEncoder{w: conn.Writer}
 			default:
@@ -172,6 +180,8 @@ func (cw *streamWriter) run() {
 			cw.mu.Lock()
 			cw.closer = conn.Closer
 			cw.working = true
+			cw.t = conn.t
+			reportStreamActive("outgoing", cw.id, cw.t, true)
 			cw.mu.Unlock()
 			heartbeatc, msgc = tickc, cw.msgc
 		case <-cw.stopc:
@@ -188,6 +198,14 @@ func (cw *streamWriter) writec() (chan<- raftpb.Message, bool) {
 	return cw.msgc, cw.working
 }
 
+// streamType returns the type of the attached stream, which is the type the
+// remote has dialed.
+func (cw *streamWriter) streamType() streamType {
+	cw.mu.Lock()
+	defer cw.mu.Unlock()
+	return cw.t
+}
+
 func (cw *streamWriter) resetCloser() {
 	cw.mu.Lock()
 	defer cw.mu.Unlock()
@@ -195,6 +213,7 @@ func (cw *streamWriter) resetCloser() {
 		return
 	}
 	cw.closer.Close()
+	reportStreamActive("outgoing", cw.id, cw.t, false)
 	if len(cw.msgc) > 0 {
 		cw.r.ReportUnreachable(uint64(cw.id))
 	}
@@ -219,30 +238,36 @@ func (cw *streamWriter) stop() {
 // streamReader is a long-running go-routine that dials to the remote stream
 // endponit and reads messages from the response body returned.
 type streamReader struct {
-	tr       http.RoundTripper
-	picker   *urlPicker
-	t        streamType
+	tr     http.RoundTripper
+	picker *urlPicker
+	// typs are the types of the stream the reader may dial, in the order
+	// of preference. The type of each dial is picked from the intersection
+	// of the types served by both members.
+	typs     []streamType
 	from, to types.ID
 	cid      types.ID
+	status   *peerStatus
 	recvc    chan<- raftpb.Message
 	propc    chan<- raftpb.Message
 
-	mu         sync.Mutex
+	mu sync.Mutex // guard field t, msgAppTerm and closer
+	// t is the type of the last dialed stream
+	t          streamType
 	msgAppTerm uint64
-	req        *http.Request
 	closer     io.Closer
 	stopc      chan struct{}
 	done       chan struct{}
 }
 
-func startStreamReader(tr http.RoundTripper, picker *urlPicker, t streamType, from, to, cid types.ID, recvc chan<- raftpb.Message, propc chan<- raftpb.Message) *streamReader {
+func startStreamReader(tr http.RoundTripper, picker *urlPicker, typs []streamType, from, to, cid types.ID, status *peerStatus, recvc chan<- raftpb.Message, propc chan<- raftpb.Message) *streamReader {
 	r := &streamReader{
 		tr:     tr,
 		picker: picker,
-		t:      t,
+		typs:   typs,
 		from:   from,
 		to:     to,
 		cid:    cid,
+		status: status,
 		recvc:  recvc,
 		propc:  propc,
 		stopc:  make(chan struct{}),
@@ -253,20 +278,23 @@ func startStreamReader(tr http.RoundTripper, picker *urlPicker, t streamType, fr
 }
 
 func (cr *streamReader) run() {
+	b := newBackoff(streamRetryMinWait, streamRetryMaxWait)
 	for {
 		rc, err := cr.dial()
 		if err != nil {
-			log.Printf("rafthttp: roundtripping error: %v", err)
+			cr.status.deactivate(err)
 		} else {
+			cr.status.activate()
+			b.reset()
 			err := cr.decodeLoop(rc)
 			if err != io.EOF && !isClosedConnectionError(err) {
-				log.Printf("rafthttp: failed to read message on stream %s due to %v", cr.t, err)
+				log.Printf("rafthttp: failed to read message on stream %s due to %v", cr.streamType(), err)
 			}
 		}
 		select {
-		// Wait 100ms to create a new stream, so it doesn't bring too much
-		// overhead when retry.
-		case <-time.After(100 * time.Millisecond):
+		// Wait to create a new stream, longer after each failed dial, so it
+		// doesn't bring too much overhead when retry.
+		case <-time.After(b.next()):
 		case <-cr.stopc:
 			close(cr.done)
 			return
@@ -281,13 +309,14 @@ func (cr *streamReader) decodeLoop(rc io.ReadCloser) error {
 	case streamTypeMsgApp:
 		dec = &msgAppDecoder{r: rc, local: cr.from, remote: cr.to, term: cr.msgAppTerm}
 	case streamTypeMsgAppV2:
-		dec = &msgAppV2Decoder{r: rc, local: cr.from, remote: cr.to}
+		dec = newMsgAppV2Decoder(rc, cr.from, cr.to)
 	case streamTypeMessage:
 		dec = &messageDecoder{r: rc}
 	default:
 		log.Panicf("rafthttp: unhandled stream type %s", cr.t)
 	}
 	cr.closer = rc
+	reportStreamActive("incoming", cr.to, cr.t, true)
 	cr.mu.Unlock()
 
 	for {
@@ -301,6 +330,7 @@ func (cr *streamReader) decodeLoop(rc io.ReadCloser) error {
 		case isLinkHeartbeatMessage(m):
 			// do nothing for linkHeartbeatMessage
 		default:
+			reportReceived(m)
 			recvc := cr.recvc
 			if m.Type == raftpb.MsgProp {
 				recvc = cr.propc
@@ -310,6 +340,7 @@ func (cr *streamReader) decodeLoop(rc io.ReadCloser) error {
 			default:
 				log.Printf("rafthttp: dropping %s from %x because receive buffer is blocked",
 					m.Type, m.From)
+				reportDropped("receive", cr.to, m)
 			}
 		}
 	}
@@ -322,14 +353,16 @@ func (cr *streamReader) updateMsgAppTerm(term uint64) {
 		return
 	}
 	cr.msgAppTerm = term
-	cr.resetCloser()
+	// only the msgapp stream of v2.0 is bound to a term
+	if cr.t == streamTypeMsgApp {
+		cr.resetCloser()
+	}
 }
 
 // TODO: always cancel in-flight dial and decode
 func (cr *streamReader) stop() {
 	close(cr.stopc)
 	cr.mu.Lock()
-	cr.cancelRequest()
 	cr.resetCloser()
 	cr.mu.Unlock()
 	<-cr.done
@@ -341,14 +374,28 @@ func (cr *streamReader) isWorking() bool {
 	return cr.closer != nil
 }
 
+func (cr *streamReader) streamType() streamType {
+	cr.mu.Lock()
+	defer cr.mu.Unlock()
+	return cr.t
+}
+
 func (cr *streamReader) dial() (io.ReadCloser, error) {
+	// the peer version is learned from the last handshake on any stream
+	// to the peer, so an older peer is dialed for the types it serves.
+	pv, _ := cr.status.peerVersion()
+	t, err := pickStreamType(cr.typs, pv)
+	if err != nil {
+		return nil, err
+	}
 	u := cr.picker.pick()
 	cr.mu.Lock()
+	cr.t = t
 	term := cr.msgAppTerm
 	cr.mu.Unlock()
 
 	uu := u
-	uu.Path = path.Join(cr.t.endpoint(), cr.from.String())
+	uu.Path = path.Join(t.endpoint(), cr.from.String())
 	req, err := http.NewRequest("GET", uu.String(), nil)
 	if err != nil {
 		cr.picker.unreachable(u)
@@ -356,39 +403,53 @@ func (cr *streamReader) dial() (io.ReadCloser, error) {
 	}
 	req.Header.Set("X-Etcd-Cluster-ID", cr.cid.String())
 	req.Header.Set("X-Raft-To", cr.to.String())
-	if cr.t == streamTypeMsgApp {
+	if t == streamTypeMsgApp {
 		req.Header.Set("X-Raft-Term", strconv.FormatUint(term, 10))
 	}
-	cr.mu.Lock()
-	cr.req = req
-	cr.mu.Unlock()
+	req.Header.Set(acceptEncodingHeader, CompressionGzip)
+	// the dial is canceled when the reader stops
+	req.Cancel = cr.stopc
 	resp, err := cr.tr.RoundTrip(req)
 	if err != nil {
 		cr.picker.unreachable(u)
 		return nil, fmt.Errorf("error roundtripping to %s: %v", req.URL, err)
 	}
 	if resp.StatusCode != http.StatusOK {
-		resp.Body.Close()
+		defer resp.Body.Close()
+		if me := readMismatch(resp); resp.StatusCode == http.StatusPreconditionFailed && me != nil {
+			cr.picker.unreachable(u)
+			return nil, fmt.Errorf("member at %s rejected the stream: %v", u.String(), me)
+		}
 		return nil, fmt.Errorf("unhandled http status %d", resp.StatusCode)
 	}
-	return resp.Body, nil
-}
-
-func (cr *streamReader) cancelRequest() {
-	if canceller, ok := cr.tr.(*http.Transport); ok {
-		canceller.CancelRequest(cr.req)
+	cr.status.setVersion(versionFromHeader(resp.Header))
+	if resp.Header.Get(contentEncodingHeader) == CompressionGzip {
+		rc, err := newGzipReadCloser(resp.Body)
+		if err != nil {
+			resp.Body.Close()
+			return nil, fmt.Errorf("failed to read the gzip header of the stream from %s: %v", req.URL, err)
+		}
+		return rc, nil
 	}
+	return resp.Body, nil
 }
 
 func (cr *streamReader) resetCloser() {
 	if cr.closer != nil {
 		cr.closer.Close()
+		reportStreamActive("incoming", cr.to, cr.t, false)
 	}
 	cr.closer = nil
 }
 
-func canUseMsgAppStream(m raftpb.Message) bool {
-	return m.Type == raftpb.MsgApp && m.Term == m.LogTerm
+// canUseMsgAppStream returns whether the message can be sent on the msgapp
+// stream of the given type. The msgapp stream of v2.0 can only encode the
+// MsgApps of the term of the stream, while msgappv2 encodes any MsgApp.
+func canUseMsgAppStream(t streamType, m raftpb.Message) bool {
+	if m.Type != raftpb.MsgApp {
+		return false
+	}
+	return t == streamTypeMsgAppV2 || m.Term == m.LogTerm
 }
 
 func isClosedConnectionError(err error) bool {
@@ -19,7 +19,7 @@ import (
 // to streamWriter. After that, streamWriter can use it to send messages
 // continuously, and closes it when stopped.
 func TestStreamWriterAttachOutgoingConn(t *testing.T) {
-	sw := startStreamWriter(types.ID(1), &stats.FollowerStats{}, &fakeRaft{})
+	sw := startStreamWriter(types.ID(1), &stats.FollowerStats{}, &fakeRaft{}, ConnReadTimeout)
 	// the expected initial state of streamWrite is not working
 	if _, ok := sw.writec(); ok != false {
 		t.Errorf("initial working status = %v, want false", ok)
@@ -65,7 +65,7 @@ func TestStreamWriterAttachOutgoingConn(t *testing.T) {
 // TestStreamWriterAttachBadOutgoingConn tests that streamWriter with bad
 // outgoingConn will close the outgoingConn and fall back to non-working status.
 func TestStreamWriterAttachBadOutgoingConn(t *testing.T) {
-	sw := startStreamWriter(types.ID(1), &stats.FollowerStats{}, &fakeRaft{})
+	sw := startStreamWriter(types.ID(1), &stats.FollowerStats{}, &fakeRaft{}, ConnReadTimeout)
 	defer sw.stop()
 	wfc := &fakeWriteFlushCloser{err: errors.New("blah")}
 	sw.attach(&outgoingConn{t: streamTypeMessage, Writer: wfc, Flusher: wfc, Closer: wfc})
@@ -87,10 +87,11 @@ func TestStreamReaderDialRequest(t *testing.T) {
 		sr := &streamReader{
 			tr:         tr,
 			picker:     mustNewURLPicker(t, []string{"http://localhost:2380"}),
-			t:          tt,
+			typs:       []streamType{tt},
 			from:       types.ID(1),
 			to:         types.ID(2),
 			cid:        types.ID(1),
+			status:     newPeerStatus(types.ID(2)),
 			msgAppTerm: 1,
 		}
 		sr.dial()
@@ -115,6 +116,75 @@ func TestStreamReaderDialRequest(t *testing.T) {
 	}
 }
 
+// TestStreamReaderDialStreamType tests that the reader dials the preferred
+// stream type which is served by the peer, as told in the last handshake.
+func TestStreamReaderDialStreamType(t *testing.T) {
+	msgAppTypes := []streamType{streamTypeMsgAppV2, streamTypeMsgApp}
+	tests := []struct {
+		typs  []streamType
+		pv    *PeerVersion
+		wt    streamType
+		wterm string
+	}{
+		// the types of the peer are unknown before the first handshake
+		{msgAppTypes, nil, streamTypeMsgAppV2, ""},
+		// the peer is older than the handshake
+		{msgAppTypes, &PeerVersion{}, streamTypeMsgAppV2, ""},
+		{msgAppTypes, &PeerVersion{Server: "2.3.0", StreamTypes: []string{"msgappv2", "message", "msgapp"}}, streamTypeMsgAppV2, ""},
+		{msgAppTypes, &PeerVersion{Server: "2.0.0", StreamTypes: []string{"message", "msgapp"}}, streamTypeMsgApp, "1"},
+		{[]streamType{streamTypeMessage}, &PeerVersion{Server: "2.0.0", StreamTypes: []string{"message", "msgapp"}}, streamTypeMessage, ""},
+	}
+	for i, tt := range tests {
+		tr := &roundTripperRecorder{}
+		status := newPeerStatus(types.ID(2))
+		if tt.pv != nil {
+			status.setVersion(*tt.pv)
+		}
+		sr := &streamReader{
+			tr:         tr,
+			picker:     mustNewURLPicker(t, []string{"http://localhost:2380"}),
+			typs:       tt.typs,
+			from:       types.ID(1),
+			to:         types.ID(2),
+			cid:        types.ID(1),
+			status:     status,
+			msgAppTerm: 1,
+		}
+		sr.dial()
+
+		req := tr.Request()
+		if w := "http://localhost:2380" + tt.wt.endpoint() + "/1"; req.URL.String() != w {
+			t.Errorf("#%d: url = %s, want %s", i, req.URL.String(), w)
+		}
+		if g := req.Header.Get("X-Raft-Term"); g != tt.wterm {
+			t.Errorf("#%d: header X-Raft-Term = %q, want %q", i, g, tt.wterm)
+		}
+		if g := sr.streamType(); g != tt.wt {
+			t.Errorf("#%d: stream type = %s, want %s", i, g, tt.wt)
+		}
+	}
+
+	// no stream is dialed if the peer serves none of the types
+	tr := &roundTripperRecorder{}
+	status := newPeerStatus(types.ID(2))
+	status.setVersion(PeerVersion{Server: "2.0.0", StreamTypes: []string{"message"}})
+	sr := &streamReader{
+		tr:     tr,
+		picker: mustNewURLPicker(t, []string{"http://localhost:2380"}),
+		typs:   msgAppTypes,
+		from:   types.ID(1),
+		to:     types.ID(2),
+		cid:    types.ID(1),
+		status: status,
+	}
+	if _, err := sr.dial(); err == nil {
+		t.Errorf("err = nil, want error")
+	}
+	if req := tr.Request(); req != nil {
+		t.Errorf("request = %+v, want none", req)
+	}
+}
+
 // TestStreamReaderDialResult tests the result of the dial func call meets the
 // HTTP response received.
 func TestStreamReaderDialResult(t *testing.T) {
@@ -134,10 +204,11 @@ func TestStreamReaderDialResult(t *testing.T) {
 		sr := &streamReader{
 			tr:     tr,
 			picker: mustNewURLPicker(t, []string{"http://localhost:2380"}),
-			t:      streamTypeMessage,
+			typs:   []streamType{streamTypeMessage},
 			from:   types.ID(1),
 			to:     types.ID(2),
 			cid:    types.ID(1),
+			status: newPeerStatus(types.ID(2)),
 		}
 
 		_, err := sr.dial()
@@ -198,12 +269,12 @@ func TestStream(t *testing.T) {
 		srv := httptest.NewServer(h)
 		defer srv.Close()
 
-		sw := startStreamWriter(types.ID(1), &stats.FollowerStats{}, &fakeRaft{})
+		sw := startStreamWriter(types.ID(1), &stats.FollowerStats{}, &fakeRaft{}, ConnReadTimeout)
 		defer sw.stop()
 		h.sw = sw
 
 		picker := mustNewURLPicker(t, []string{srv.URL})
-		sr := startStreamReader(&http.Transport{}, picker, tt.t, types.ID(1), types.ID(2), types.ID(1), recvc, propc)
+		sr := startStreamReader(&http.Transport{}, picker, []streamType{tt.t}, types.ID(1), types.ID(2), types.ID(1), newPeerStatus(types.ID(2)), recvc, propc)
 		defer sr.stop()
 		if tt.t == streamTypeMsgApp {
 			sr.updateMsgAppTerm(tt.term)
@@ -18,6 +18,8 @@ import (
 	"log"
 	"net/http"
 	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/coreos/etcd/Godeps/_workspace/src/golang.org/x/net/context"
 	"github.com/coreos/etcd/etcdserver/stats"
@@ -60,6 +62,16 @@ type Transporter interface {
 	UpdatePeer(id types.ID, urls []string)
 	// Stop closes the connections and stops the transporter.
 	Stop()
+	// UnreachableSince returns since when the peer with the given id has
+	// been unreachable, or the zero time if it is reachable or unknown.
+	UnreachableSince(id types.ID) time.Time
+	// PeerVersion returns the version the peer with the given id told in
+	// the stream handshake, and false if it has not been learned yet. The
+	// version of the local member is always known.
+	PeerVersion(id types.ID) (PeerVersion, bool)
+	// ReceivingSnapshot returns true if a snapshot is being received from
+	// a peer.
+	ReceivingSnapshot() bool
 }
 
 type transport struct {
@@ -70,12 +82,35 @@ type transport struct {
 	serverStats  *stats.ServerStats
 	leaderStats  *stats.LeaderStats
 
+	// compression is the encoding of the streams sent to the peers,
+	// CompressionNone or CompressionGzip.
+	compression string
+	// readTimeout is the read timeout of the connections to the peers.
+	readTimeout time.Duration
+	// snapLimiter limits the rate of the snapshots sent to all the peers.
+	snapLimiter *rateLimiter
+	// snapRecvs is the number of the snapshots being received. It is
+	// accessed atomically.
+	snapRecvs int32
+
 	mu     sync.RWMutex      // protect the peer map
 	peers  map[types.ID]Peer // remote peers
 	errorc chan error
 }
 
-func NewTransporter(rt http.RoundTripper, id, cid types.ID, r Raft, errorc chan error, ss *stats.ServerStats, ls *stats.LeaderStats) Transporter {
+// NewTransporter creates a Transporter. The streams sent to the peers are
+// compressed in the given compression if the peers can decode it; an empty
+// compression is CompressionNone. readTimeout is the read timeout which rt,
+// and the listeners of the peers, are set up with; zero is ConnReadTimeout.
+// snapshotRate limits the bytes per second of the snapshots sent to all the
+// peers; zero is unlimited.
+func NewTransporter(rt http.RoundTripper, id, cid types.ID, r Raft, errorc chan error, ss *stats.ServerStats, ls *stats.LeaderStats, compression string, readTimeout time.Duration, snapshotRate int64) Transporter {
+	if compression == "" {
+		compression = CompressionNone
+	}
+	if readTimeout == 0 {
+		readTimeout = ConnReadTimeout
+	}
 	return &transport{
 		roundTripper: rt,
 		id:           id,
@@ -83,17 +118,23 @@ func NewTransporter(rt http.RoundTripper, id, cid types.ID, r Raft, errorc chan
 		raft:         r,
 		serverStats:  ss,
 		leaderStats:  ls,
+		compression:  compression,
+		readTimeout:  readTimeout,
+		snapLimiter:  newRateLimiter(snapshotRate),
 		peers:        make(map[types.ID]Peer),
 		errorc:       errorc,
 	}
 }
 
 func (t *transport) Handler() http.Handler {
-	pipelineHandler := NewHandler(t.raft, t.clusterID)
-	streamHandler := newStreamHandler(t, t.id, t.clusterID)
+	pipelineHandler := NewHandler(t.raft, t.id, t.clusterID)
+	streamHandler := newStreamHandler(t, t.id, t.clusterID, t.compression)
+	snapHandler := newSnapshotHandler(t.raft, t.id, t.clusterID, &t.snapRecvs)
 	mux := http.NewServeMux()
 	mux.Handle(RaftPrefix, pipelineHandler)
 	mux.Handle(RaftStreamPrefix+"/", streamHandler)
+	mux.Handle(RaftSnapshotPrefix, snapHandler)
+	mux.Handle(RaftProbingPrefix, newProbingHandler(t.clusterID))
 	return mux
 }
 
@@ -125,6 +166,29 @@ func (t *transport) Send(msgs []raftpb.Message) {
 	}
 }
 
+func (t *transport) UnreachableSince(id types.ID) time.Time {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	if p, ok := t.peers[id]; ok {
+		return p.unreachableSince()
+	}
+	return time.Time{}
+}
+
+func (t *transport) PeerVersion(id types.ID) (PeerVersion, bool) {
+	if id == t.id {
+		return localVersion(), true
+	}
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	if p, ok := t.peers[id]; ok {
+		return p.peerVersion()
+	}
+	return PeerVersion{}, false
+}
+
+func (t *transport) ReceivingSnapshot() bool { return atomic.LoadInt32(&t.snapRecvs) > 0 }
+
 func (t *transport) Stop() {
 	for _, p := range t.peers {
 		p.Stop()
@@ -150,7 +214,7 @@ func (t *transport) AddPeer(id types.ID, us []string) {
 		log.Panicf("newURLs %+v should never fail: %+v", us, err)
 	}
 	fs := t.leaderStats.Follower(id.String())
-	t.peers[id] = startPeer(t.roundTripper, urls, t.id, id, t.clusterID, t.raft, fs, t.errorc)
+	t.peers[id] = startPeer(t.roundTripper, urls, t.id, id, t.clusterID, t.raft, fs, t.errorc, t.readTimeout, t.snapLimiter)
 }
 
 func (t *transport) RemovePeer(id types.ID) {
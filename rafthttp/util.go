@@ -16,7 +16,9 @@ package rafthttp
 
 import (
 	"encoding/binary"
+	"fmt"
 	"io"
+	"net/http"
 
 	"github.com/coreos/etcd/raft/raftpb"
 )
@@ -45,3 +47,36 @@ func readEntryFrom(r io.Reader, ent *raftpb.Entry) error {
 	}
 	return ent.Unmarshal(buf)
 }
+
+func writeChunkTo(w io.Writer, b []byte) error {
+	if err := binary.Write(w, binary.BigEndian, uint64(len(b))); err != nil {
+		return err
+	}
+	_, err := w.Write(b)
+	return err
+}
+
+// readChunkFrom reads a chunk that is not larger than max bytes.
+func readChunkFrom(r io.Reader, max uint64) ([]byte, error) {
+	var l uint64
+	if err := binary.Read(r, binary.BigEndian, &l); err != nil {
+		return nil, err
+	}
+	if l > max {
+		return nil, fmt.Errorf("chunk size %d exceeds the limit %d", l, max)
+	}
+	buf := make([]byte, int(l))
+	if _, err := io.ReadFull(r, buf); err != nil {
+		return nil, err
+	}
+	return buf, nil
+}
+
+// roundTripUntilStop sends the request and cancels it if stopc is closed
+// before the response arrives.
+func roundTripUntilStop(tr http.RoundTripper, req *http.Request, stopc <-chan struct{}) (*http.Response, error) {
+	// Unlike CancelRequest of http.Transport, req.Cancel also reaches the
+	// transports registered for the unix socket URLs.
+	req.Cancel = stopc
+	return tr.RoundTrip(req)
+}
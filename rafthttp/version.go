@@ -0,0 +1,92 @@
+// Copyright 2015 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package rafthttp
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/coreos/etcd/version"
+)
+
+const (
+	// A member answers a stream request with its server version and the
+	// types of the streams it serves, which the dialing member records.
+	serverVersionHeader = "X-Server-Version"
+	streamTypesHeader   = "X-Raft-Stream-Types"
+)
+
+// supportedStreamTypes are the types of the streams served by this member.
+var supportedStreamTypes = []streamType{streamTypeMsgAppV2, streamTypeMessage, streamTypeMsgApp}
+
+// PeerVersion is what a peer tells of itself in the stream handshake. The
+// features of the wire that older members do not know should be gated on
+// it in a cluster under a rolling upgrade.
+type PeerVersion struct {
+	// Server is the etcd version of the peer. It is empty if the peer is
+	// older than the handshake.
+	Server string
+	// StreamTypes are the types of the streams the peer serves, e.g.
+	// "msgappv2" and "message". It is empty if the peer is older than the
+	// handshake.
+	StreamTypes []string
+}
+
+func localVersion() PeerVersion {
+	v := PeerVersion{Server: version.Version}
+	for _, t := range supportedStreamTypes {
+		v.StreamTypes = append(v.StreamTypes, string(t))
+	}
+	return v
+}
+
+func setVersionHeader(h http.Header) {
+	v := localVersion()
+	h.Set(serverVersionHeader, v.Server)
+	h.Set(streamTypesHeader, strings.Join(v.StreamTypes, ","))
+}
+
+func versionFromHeader(h http.Header) PeerVersion {
+	v := PeerVersion{Server: h.Get(serverVersionHeader)}
+	if ts := h.Get(streamTypesHeader); ts != "" {
+		v.StreamTypes = strings.Split(ts, ",")
+	}
+	return v
+}
+
+// pickStreamType returns the first of the given stream types, in the order
+// of preference, which is in the intersection of the types served by this
+// member and by the peer. The peer is assumed to serve all of them until
+// it has told its stream types in a handshake.
+// 取本地和对端都支持的stream类型，优先使用msgappv2
+func pickStreamType(ts []streamType, peer PeerVersion) (streamType, error) {
+	local := localVersion()
+	for _, t := range ts {
+		if servesStreamType(local, t) && (len(peer.StreamTypes) == 0 || servesStreamType(peer, t)) {
+			return t, nil
+		}
+	}
+	return "", fmt.Errorf("no stream of the types %v is served by both members (peer serves %v)", ts, peer.StreamTypes)
+}
+
+func servesStreamType(v PeerVersion, t streamType) bool {
+	for _, st := range v.StreamTypes {
+		if st == string(t) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,60 @@
+// Copyright 2015 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package rafthttp
+
+import (
+	"net/http"
+	"reflect"
+	"testing"
+)
+
+func TestVersionHeader(t *testing.T) {
+	h := make(http.Header)
+	setVersionHeader(h)
+	if g, w := versionFromHeader(h), localVersion(); !reflect.DeepEqual(g, w) {
+		t.Errorf("version = %+v, want %+v", g, w)
+	}
+	// a peer older than the handshake sets no header
+	if g := versionFromHeader(make(http.Header)); !reflect.DeepEqual(g, PeerVersion{}) {
+		t.Errorf("version = %+v, want empty", g)
+	}
+}
+
+func TestPickStreamType(t *testing.T) {
+	msgAppTypes := []streamType{streamTypeMsgAppV2, streamTypeMsgApp}
+	tests := []struct {
+		ts   []streamType
+		peer []string
+		wt   streamType
+		werr bool
+	}{
+		{msgAppTypes, nil, streamTypeMsgAppV2, false},
+		{msgAppTypes, []string{"msgappv2", "message", "msgapp"}, streamTypeMsgAppV2, false},
+		{msgAppTypes, []string{"message", "msgapp"}, streamTypeMsgApp, false},
+		{msgAppTypes, []string{"message"}, "", true},
+		{[]streamType{streamTypeMessage}, []string{"message", "msgapp"}, streamTypeMessage, false},
+		// a type the local member does not serve is never picked
+		{[]streamType{"msgappv3", streamTypeMsgAppV2}, []string{"msgappv3", "msgappv2"}, streamTypeMsgAppV2, false},
+	}
+	for i, tt := range tests {
+		g, err := pickStreamType(tt.ts, PeerVersion{StreamTypes: tt.peer})
+		if (err != nil) != tt.werr {
+			t.Errorf("#%d: err = %v, want error %v", i, err, tt.werr)
+		}
+		if g != tt.wt {
+			t.Errorf("#%d: type = %q, want %q", i, g, tt.wt)
+		}
+	}
+}
@@ -5,9 +5,10 @@
 #
 
 PREFIX="github.com/coreos/etcd/Godeps/_workspace/src"
-DIRS="./wal/walpb ./etcdserver/etcdserverpb ./snap/snappb ./raft/raftpb ./migrate/etcd4pb"
+DIRS="./wal/walpb ./etcdserver/etcdserverpb ./etcdserver/api/kvpb ./snap/snappb ./raft/raftpb ./migrate/etcd4pb"
 
-SHA="bc946d07d1016848dfd2507f90f0859c9471681e"
+# 64f27bf is the first revision with the grpc plugin.
+SHA="64f27bf06efee53589314a6e5a4af34cdd85adf6"
 
 if ! protoc --version > /dev/null; then
 	echo "could not find protoc, is it installed + in PATH?"
@@ -28,8 +29,10 @@ export PATH="${GOBIN}:${PATH}"
 
 for dir in ${DIRS}; do
 	pushd ${dir}
-		protoc --gogo_out=. -I=.:${GOPATH}/src/github.com/gogo/protobuf/protobuf:${GOPATH}/src *.proto
-		sed -i".bak" -e "s|github.com/gogo/protobuf/proto|${PREFIX}/github.com/gogo/protobuf/proto|" *.go
+		protoc --gogo_out=plugins=grpc:. -I=.:${GOPATH}/src/github.com/gogo/protobuf/protobuf:${GOPATH}/src *.proto
+		sed -i".bak" -e "s|github.com/gogo/protobuf/proto|${PREFIX}/github.com/gogo/protobuf/proto|" \
+			-e "s|\"golang.org/x/net/context\"|\"${PREFIX}/golang.org/x/net/context\"|" \
+			-e "s|\"google.golang.org/grpc\"|\"${PREFIX}/google.golang.org/grpc\"|" *.go
 		rm -f *.bak
 	popd
 done
@@ -18,6 +18,7 @@ import (
 	"errors"
 	"fmt"
 	"hash/crc32"
+	"io"
 	"io/ioutil"
 	"log"
 	"os"
@@ -26,6 +27,7 @@ import (
 	"strings"
 	"time"
 
+	"github.com/coreos/etcd/pkg/encryption"
 	"github.com/coreos/etcd/pkg/pbutil"
 	"github.com/coreos/etcd/raft"
 	"github.com/coreos/etcd/raft/raftpb"
@@ -40,12 +42,15 @@ var (
 	ErrNoSnapshot    = errors.New("snap: no available snapshot")
 	ErrEmptySnapshot = errors.New("snap: empty snapshot")
 	ErrCRCMismatch   = errors.New("snap: crc mismatch")
+	ErrDecrypt       = errors.New("snap: cannot decrypt snapshot")
 	crcTable         = crc32.MakeTable(crc32.Castagnoli)
 )
 
 type Snapshotter struct {
 	// snap地址
 	dir string
+	// provider seals the snapshot files if not nil
+	provider encryption.Provider
 }
 
 func New(dir string) *Snapshotter {
@@ -54,6 +59,16 @@ func New(dir string) *Snapshotter {
 	}
 }
 
+// NewEncrypted returns a Snapshotter whose snapshot files are sealed by the
+// given provider. The files cannot be read by Read.
+// 创建加密snapshot文件的Snapshotter
+func NewEncrypted(dir string, p encryption.Provider) *Snapshotter {
+	return &Snapshotter{
+		dir:      dir,
+		provider: p,
+	}
+}
+
 func (s *Snapshotter) SaveSnap(snapshot raftpb.Snapshot) error {
 	if raft.IsEmptySnap(snapshot) {
 		return nil
@@ -65,13 +80,15 @@ func (s *Snapshotter) save(snapshot *raftpb.Snapshot) error {
 	start := time.Now()
 	// snapshot文件名
 	fname := fmt.Sprintf("%016x-%016x%s", snapshot.Metadata.Term, snapshot.Metadata.Index, snapSuffix)
-	b := pbutil.MustMarshal(snapshot)
-	crc := crc32.Update(0, crcTable, b)
-	snap := snappb.Snapshot{Crc: crc, Data: b}
-	d, err := snap.Marshal()
+	d, err := marshal(snapshot)
 	if err != nil {
 		return err
 	}
+	if s.provider != nil {
+		if d, err = s.provider.Seal(d); err != nil {
+			return err
+		}
+	}
 	err = ioutil.WriteFile(path.Join(s.dir, fname), d, 0666)
 	if err != nil {
 		saveDurations.Observe(float64(time.Since(start).Nanoseconds() / int64(time.Microsecond)))
@@ -79,6 +96,25 @@ func (s *Snapshotter) save(snapshot *raftpb.Snapshot) error {
 	return err
 }
 
+// Write writes the snapshot to w in the format of a snapshot file, so that
+// the output can be loaded by Read.
+func Write(w io.Writer, snapshot raftpb.Snapshot) error {
+	d, err := marshal(&snapshot)
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(d)
+	return err
+}
+
+// marshal encodes the snapshot with its checksum.
+func marshal(snapshot *raftpb.Snapshot) ([]byte, error) {
+	b := pbutil.MustMarshal(snapshot)
+	crc := crc32.Update(0, crcTable, b)
+	snap := snappb.Snapshot{Crc: crc, Data: b}
+	return snap.Marshal()
+}
+
 // 加载最新的snapshot文件
 func (s *Snapshotter) Load() (*raftpb.Snapshot, error) {
 	names, err := s.snapNames()
@@ -87,7 +123,7 @@ func (s *Snapshotter) Load() (*raftpb.Snapshot, error) {
 	}
 	var snap *raftpb.Snapshot
 	for _, name := range names {
-		if snap, err = loadSnap(s.dir, name); err == nil {
+		if snap, err = s.loadSnap(name); err == nil {
 			break
 		}
 	}
@@ -98,15 +134,39 @@ func (s *Snapshotter) Load() (*raftpb.Snapshot, error) {
 }
 
 // 依据本地snapshot地址读取snapshot文件信息
-func loadSnap(dir, name string) (*raftpb.Snapshot, error) {
-	fpath := path.Join(dir, name)
-	snap, err := Read(fpath)
-	if err != nil {
+func (s *Snapshotter) loadSnap(name string) (*raftpb.Snapshot, error) {
+	fpath := path.Join(s.dir, name)
+	snap, err := s.read(fpath)
+	// a snapshot sealed with another key is not broken
+	if err != nil && err != ErrDecrypt {
 		renameBroken(fpath)
 	}
 	return snap, err
 }
 
+// read reads the snapshot file written by the Snapshotter, opening it with
+// the provider if it is sealed.
+func (s *Snapshotter) read(snapname string) (*raftpb.Snapshot, error) {
+	if s.provider == nil {
+		return Read(snapname)
+	}
+	b, err := ioutil.ReadFile(snapname)
+	if err != nil {
+		log.Printf("snap: snapshotter cannot read file %v: %v", snapname, err)
+		return nil, err
+	}
+	if b, err = s.provider.Open(b); err != nil {
+		log.Printf("snap: snapshotter cannot decrypt file %v: %v", snapname, err)
+		return nil, ErrDecrypt
+	}
+	snap, err := Decode(b)
+	if err != nil {
+		log.Printf("snap: corrupted snapshot file %v: %v", snapname, err)
+		return nil, err
+	}
+	return snap, nil
+}
+
 // Read reads the snapshot named by snapname and returns the snapshot.
 func Read(snapname string) (*raftpb.Snapshot, error) {
 	b, err := ioutil.ReadFile(snapname)
@@ -115,26 +175,33 @@ func Read(snapname string) (*raftpb.Snapshot, error) {
 		return nil, err
 	}
 
-	var serializedSnap snappb.Snapshot
-	if err = serializedSnap.Unmarshal(b); err != nil {
+	snap, err := Decode(b)
+	if err != nil {
 		log.Printf("snap: corrupted snapshot file %v: %v", snapname, err)
 		return nil, err
 	}
+	return snap, nil
+}
+
+// Decode decodes the content of a snapshot file, such as the output of
+// Write, and verifies its checksum.
+func Decode(b []byte) (*raftpb.Snapshot, error) {
+	var serializedSnap snappb.Snapshot
+	if err := serializedSnap.Unmarshal(b); err != nil {
+		return nil, err
+	}
 
 	if len(serializedSnap.Data) == 0 || serializedSnap.Crc == 0 {
-		log.Printf("snap: unexpected empty snapshot")
 		return nil, ErrEmptySnapshot
 	}
 
 	crc := crc32.Update(0, crcTable, serializedSnap.Data)
 	if crc != serializedSnap.Crc {
-		log.Printf("snap: corrupted snapshot file %v: crc mismatch", snapname)
 		return nil, ErrCRCMismatch
 	}
 
 	var snap raftpb.Snapshot
-	if err = snap.Unmarshal(serializedSnap.Data); err != nil {
-		log.Printf("snap: corrupted snapshot file %v: %v", snapname, err)
+	if err := snap.Unmarshal(serializedSnap.Data); err != nil {
 		return nil, err
 	}
 	return &snap, nil
@@ -15,6 +15,7 @@
 package snap
 
 import (
+	"bytes"
 	"fmt"
 	"hash/crc32"
 	"io/ioutil"
@@ -23,6 +24,7 @@ import (
 	"reflect"
 	"testing"
 
+	"github.com/coreos/etcd/pkg/encryption"
 	"github.com/coreos/etcd/raft/raftpb"
 )
 
@@ -59,6 +61,69 @@ func TestSaveAndLoad(t *testing.T) {
 	}
 }
 
+func TestSaveAndLoadEncrypted(t *testing.T) {
+	dir := path.Join(os.TempDir(), "snapshot")
+	err := os.Mkdir(dir, 0700)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	p, err := encryption.NewAESGCM(bytes.Repeat([]byte{1}, 32))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ss := NewEncrypted(dir, p)
+	if err = ss.save(testSnap); err != nil {
+		t.Fatal(err)
+	}
+
+	g, err := ss.Load()
+	if err != nil {
+		t.Errorf("err = %v, want nil", err)
+	}
+	if !reflect.DeepEqual(g, testSnap) {
+		t.Errorf("snap = %#v, want %#v", g, testSnap)
+	}
+
+	// the file cannot be read with another key, and is kept
+	other, err := encryption.NewAESGCM(bytes.Repeat([]byte{2}, 32))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = NewEncrypted(dir, other).Load(); err != ErrNoSnapshot {
+		t.Errorf("err = %v, want %v", err, ErrNoSnapshot)
+	}
+	if g, err = ss.Load(); err != nil || !reflect.DeepEqual(g, testSnap) {
+		t.Errorf("snap = %#v, err = %v, want %#v and nil", g, err, testSnap)
+	}
+}
+
+func TestWriteAndRead(t *testing.T) {
+	dir := path.Join(os.TempDir(), "snapshot")
+	err := os.Mkdir(dir, 0700)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	fname := path.Join(dir, "backup.snap")
+	f, err := os.Create(fname)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = Write(f, *testSnap); err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+
+	g, err := Read(fname)
+	if err != nil {
+		t.Errorf("err = %v, want nil", err)
+	}
+	if !reflect.DeepEqual(g, testSnap) {
+		t.Errorf("snap = %#v, want %#v", g, testSnap)
+	}
+}
+
 func TestBadCRC(t *testing.T) {
 	dir := path.Join(os.TempDir(), "snapshot")
 	err := os.Mkdir(dir, 0700)
@@ -0,0 +1,100 @@
+// Copyright 2015 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package standby
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"time"
+)
+
+// Status is the state of a standby.
+type Status struct {
+	ClusterID string `json:"clusterID"`
+	// Index and Term are the raft index and term of the latest snapshot.
+	Index  uint64    `json:"index"`
+	Term   uint64    `json:"term"`
+	Synced time.Time `json:"synced"`
+	// MemberID is set once the standby is promoted.
+	MemberID string `json:"memberID,omitempty"`
+}
+
+// Handler serves the status of the standby, and the promotion of the
+// standby into the cluster.
+func (s *Standby) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc(statusPath, s.serveStatus)
+	mux.HandleFunc(promotePath, s.servePromote)
+	return mux
+}
+
+func (s *Standby) serveStatus(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		w.Header().Set("Allow", "GET")
+		http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	s.mu.Lock()
+	var st Status
+	if s.snapshot != nil {
+		st = Status{
+			ClusterID: s.clusterID.String(),
+			Index:     s.snapshot.Metadata.Index,
+			Term:      s.snapshot.Metadata.Term,
+			Synced:    s.synced,
+		}
+	}
+	if s.promoted != 0 {
+		st.MemberID = s.promoted.String()
+	}
+	s.mu.Unlock()
+	writeJSON(w, http.StatusOK, st)
+}
+
+// 将standby提升为cluster的member
+func (s *Standby) servePromote(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		w.Header().Set("Allow", "POST")
+		http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	id, err := s.promote(r.Header)
+	switch err {
+	case nil:
+		writeJSON(w, http.StatusOK, struct {
+			MemberID string `json:"memberID"`
+		}{id.String()})
+		// the member starts on the listeners of the standby, so the
+		// response is sent before the standby stops.
+		if f, ok := w.(http.Flusher); ok {
+			f.Flush()
+		}
+		s.promotec <- id
+	case ErrNotSynced, ErrPromoted:
+		http.Error(w, err.Error(), http.StatusConflict)
+	default:
+		log.Printf("standby: error promoting: %v", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+func writeJSON(w http.ResponseWriter, code int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.Printf("standby: %v", err)
+	}
+}
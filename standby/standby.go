@@ -0,0 +1,322 @@
+// Copyright 2015 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package standby implements a hot-standby node, which keeps a recent copy
+// of the store of an etcd cluster without being a member of it, and can be
+// promoted into the cluster when a member dies.
+package standby
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/coreos/etcd/etcdserver"
+	"github.com/coreos/etcd/etcdserver/etcdhttp/httptypes"
+	"github.com/coreos/etcd/pkg/encryption"
+	"github.com/coreos/etcd/pkg/types"
+	"github.com/coreos/etcd/raft/raftpb"
+	"github.com/coreos/etcd/snap"
+)
+
+const (
+	// DefaultSyncInterval is how often the standby pulls a snapshot from
+	// the cluster by default.
+	DefaultSyncInterval = 30 * time.Second
+
+	statusPath  = "/v2/standby"
+	promotePath = "/v2/standby/promote"
+
+	clusterConfigPath = "/v2/config/cluster"
+
+	// requestTimeout is the timeout of a request to the cluster.
+	requestTimeout = 10 * time.Second
+)
+
+var (
+	ErrNotSynced = errors.New("standby: no snapshot has been synced from the cluster")
+	ErrPromoted  = errors.New("standby: promoted already")
+)
+
+// GetClientURLs returns the client urls of the members of the cluster.
+type GetClientURLs func() []string
+
+type Config struct {
+	// PeerURLs are the peer urls the standby advertises to the cluster
+	// once promoted.
+	PeerURLs []string
+	// DataDir is the data dir the member dir is created in on promotion.
+	DataDir string
+	// WALDir is the dir the WAL is created in on promotion if it is not
+	// under the member dir.
+	WALDir string
+	// Transport is used to talk to the client urls of the cluster.
+	Transport *http.Transport
+	// ClientURLs returns the client urls of the cluster.
+	ClientURLs GetClientURLs
+	// SyncInterval is how often the standby pulls a snapshot.
+	SyncInterval time.Duration
+	// Encryption seals the member dir created on promotion if not nil.
+	Encryption encryption.Provider
+}
+
+// Standby pulls the latest snapshot of the cluster through the backup API
+// periodically. It replicates the store but is not a raft member, so it
+// adds neither load to the consensus nor votes to the quorum.
+// 热备节点：定期从cluster拉取snapshot，不参与raft，需要时可提升为member
+type Standby struct {
+	cfg Config
+
+	mu        sync.Mutex
+	snapshot  *raftpb.Snapshot
+	clusterID types.ID
+	synced    time.Time
+	// promoted is the ID of the member the standby is promoted to.
+	promoted types.ID
+
+	promotec chan types.ID
+	stopc    chan struct{}
+	donec    chan struct{}
+}
+
+func New(cfg Config) *Standby {
+	if cfg.SyncInterval == 0 {
+		cfg.SyncInterval = DefaultSyncInterval
+	}
+	return &Standby{
+		cfg:      cfg,
+		promotec: make(chan types.ID, 1),
+		stopc:    make(chan struct{}),
+		donec:    make(chan struct{}),
+	}
+}
+
+// Run pulls a snapshot every SyncInterval until Stop is called. After a
+// sync, the standby promotes itself if the cluster has fewer members than
+// the active size of the cluster config.
+func (s *Standby) Run() {
+	defer close(s.donec)
+	for {
+		if err := s.sync(); err != nil {
+			log.Printf("standby: %v", err)
+		} else {
+			s.maybePromote()
+		}
+		select {
+		case <-time.After(s.cfg.SyncInterval):
+		case <-s.stopc:
+			return
+		}
+	}
+}
+
+// Stop stops the sync loop started by Run.
+func (s *Standby) Stop() {
+	close(s.stopc)
+	<-s.donec
+}
+
+// Promoted returns a channel that receives the ID of the member once the
+// standby is promoted. The member dir has been initialized then, so the
+// member can be started from the data dir.
+func (s *Standby) Promoted() <-chan types.ID { return s.promotec }
+
+// sync pulls the latest snapshot from one of the members.
+func (s *Standby) sync() error {
+	cc := &http.Client{Transport: s.cfg.Transport, Timeout: requestTimeout}
+	for _, u := range s.cfg.ClientURLs() {
+		resp, err := cc.Get(u + "/v2/backup")
+		if err != nil {
+			log.Printf("standby: could not get backup from %s: %v", u, err)
+			continue
+		}
+		b, err := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err != nil {
+			log.Printf("standby: could not read backup from %s: %v", u, err)
+			continue
+		}
+		if resp.StatusCode != http.StatusOK {
+			log.Printf("standby: could not get backup from %s: %s", u, resp.Status)
+			continue
+		}
+		cid, err := types.IDFromString(resp.Header.Get("X-Etcd-Cluster-ID"))
+		if err != nil {
+			log.Printf("standby: could not parse the cluster ID from %s: %v", u, err)
+			continue
+		}
+		snapshot, err := snap.Decode(b)
+		if err != nil {
+			log.Printf("standby: could not decode backup from %s: %v", u, err)
+			continue
+		}
+
+		s.mu.Lock()
+		if s.clusterID != 0 && s.clusterID != cid {
+			s.mu.Unlock()
+			return fmt.Errorf("cluster ID mismatch: got %s from %s, want %s", cid, u, s.clusterID)
+		}
+		s.snapshot, s.clusterID, s.synced = snapshot, cid, time.Now()
+		s.mu.Unlock()
+		log.Printf("standby: synced snapshot at index %d from %s", snapshot.Metadata.Index, u)
+		return nil
+	}
+	return fmt.Errorf("could not sync snapshot from the cluster")
+}
+
+// maybePromote promotes the standby if the cluster has fewer members than
+// the active size of the cluster config. Several standbys may promote
+// themselves at the same time, so the cluster may grow beyond the active
+// size. The standby has no credentials, so the promotion fails if the
+// security is enabled.
+// 集群member数少于activeSize时，standby自动提升为member
+func (s *Standby) maybePromote() {
+	s.mu.Lock()
+	promoted := s.promoted != 0
+	s.mu.Unlock()
+	if promoted {
+		return
+	}
+	below, err := s.belowActiveSize()
+	if err != nil {
+		log.Printf("standby: %v", err)
+		return
+	}
+	if !below {
+		return
+	}
+	id, err := s.promote(nil)
+	if err != nil {
+		log.Printf("standby: error promoting: %v", err)
+		return
+	}
+	s.promotec <- id
+}
+
+// belowActiveSize reports whether the cluster has fewer members than the
+// active size of the cluster config.
+func (s *Standby) belowActiveSize() (bool, error) {
+	cc := &http.Client{Transport: s.cfg.Transport, Timeout: requestTimeout}
+	for _, u := range s.cfg.ClientURLs() {
+		var c etcdserver.ClusterConfig
+		if err := getJSON(cc, u+clusterConfigPath, &c); err != nil {
+			log.Printf("standby: could not get the cluster config from %s: %v", u, err)
+			continue
+		}
+		if c.ActiveSize == nil || *c.ActiveSize == 0 {
+			return false, nil
+		}
+		var ms httptypes.MemberCollection
+		if err := getJSON(cc, u+"/v2/members", &ms); err != nil {
+			log.Printf("standby: could not get the members from %s: %v", u, err)
+			continue
+		}
+		return len(ms) < *c.ActiveSize, nil
+	}
+	return false, fmt.Errorf("could not get the cluster config from the cluster")
+}
+
+func getJSON(cc *http.Client, u string, v interface{}) error {
+	resp, err := cc.Get(u)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	return json.NewDecoder(resp.Body).Decode(v)
+}
+
+// promote adds the standby to the cluster through the members API of one of
+// the members, and initializes the member dir from the latest snapshot.
+// The header is forwarded to the members API, e.g. for authentication.
+// 通过/v2/members把自己加入cluster，再用最新的snapshot初始化member目录
+func (s *Standby) promote(header http.Header) (types.ID, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.promoted != 0 {
+		return 0, ErrPromoted
+	}
+	if s.snapshot == nil {
+		return 0, ErrNotSynced
+	}
+
+	m, err := s.addMember(header)
+	if err != nil {
+		return 0, err
+	}
+	id, err := types.IDFromString(m.ID)
+	if err != nil {
+		return 0, fmt.Errorf("standby: could not parse member ID %q: %v", m.ID, err)
+	}
+	mcfg := &etcdserver.ServerConfig{DataDir: s.cfg.DataDir, DedicatedWALDir: s.cfg.WALDir, Encryption: s.cfg.Encryption}
+	if err := etcdserver.InitMemberDirFromSnapshot(mcfg, *s.snapshot, id, s.clusterID); err != nil {
+		return 0, err
+	}
+	s.promoted = id
+	log.Printf("standby: promoted to member %s in cluster %s from snapshot at index %d", id, s.clusterID, s.snapshot.Metadata.Index)
+	return id, nil
+}
+
+func (s *Standby) addMember(header http.Header) (*httptypes.Member, error) {
+	b, err := json.Marshal(struct {
+		PeerURLs []string `json:"peerURLs"`
+	}{s.cfg.PeerURLs})
+	if err != nil {
+		return nil, err
+	}
+	cc := &http.Client{Transport: s.cfg.Transport, Timeout: requestTimeout}
+	for _, u := range s.cfg.ClientURLs() {
+		req, err := http.NewRequest("POST", u+"/v2/members", bytes.NewReader(b))
+		if err != nil {
+			return nil, err
+		}
+		if auth := header.Get("Authorization"); auth != "" {
+			req.Header.Set("Authorization", auth)
+		}
+		req.Header.Set("Content-Type", "application/json")
+		resp, err := cc.Do(req)
+		if err != nil {
+			log.Printf("standby: could not add member through %s: %v", u, err)
+			continue
+		}
+		body, err := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err != nil {
+			log.Printf("standby: could not read the body of the member response: %v", err)
+			continue
+		}
+		if resp.StatusCode != http.StatusCreated {
+			// the cluster rejects the member, e.g. because its peer urls
+			// exist or the request is not authorized.
+			return nil, fmt.Errorf("standby: could not add member: %s: %s", resp.Status, bytes.TrimSpace(body))
+		}
+		if cid := resp.Header.Get("X-Etcd-Cluster-ID"); cid != s.clusterID.String() {
+			return nil, fmt.Errorf("standby: member added to cluster %s, want %s", cid, s.clusterID)
+		}
+		var m httptypes.Member
+		if err := json.Unmarshal(body, &m); err != nil {
+			return nil, fmt.Errorf("standby: could not unmarshal the member response: %v", err)
+		}
+		return &m, nil
+	}
+	return nil, fmt.Errorf("standby: could not add member through the cluster")
+}
@@ -0,0 +1,220 @@
+// Copyright 2015 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package standby
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"reflect"
+	"testing"
+
+	"github.com/coreos/etcd/pkg/types"
+	"github.com/coreos/etcd/raft/raftpb"
+	"github.com/coreos/etcd/snap"
+	"github.com/coreos/etcd/wal"
+)
+
+var testSnap = raftpb.Snapshot{
+	Data: []byte("some snapshot"),
+	Metadata: raftpb.SnapshotMetadata{
+		ConfState: raftpb.ConfState{Nodes: []uint64{1}},
+		Index:     10,
+		Term:      2,
+	},
+}
+
+// fakeCluster serves the backup, members and cluster config API of a
+// cluster of one member.
+type fakeCluster struct {
+	auth   string
+	config string
+}
+
+func (c *fakeCluster) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("X-Etcd-Cluster-ID", "10")
+	switch {
+	case r.Method == "GET" && r.URL.Path == "/v2/backup":
+		snap.Write(w, testSnap)
+	case r.Method == "GET" && r.URL.Path == "/v2/members":
+		w.Write([]byte(`{"members":[{"id":"1","peerURLs":["http://127.0.0.1:12380"]}]}`))
+	case r.Method == "GET" && r.URL.Path == clusterConfigPath && c.config != "":
+		w.Write([]byte(c.config))
+	case r.Method == "POST" && r.URL.Path == "/v2/members":
+		c.auth = r.Header.Get("Authorization")
+		w.WriteHeader(http.StatusCreated)
+		w.Write([]byte(`{"id":"2","peerURLs":["http://127.0.0.1:2380"]}`))
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func TestPromote(t *testing.T) {
+	dir, err := ioutil.TempDir(os.TempDir(), "standby")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	c := &fakeCluster{}
+	ts := httptest.NewServer(c)
+	defer ts.Close()
+
+	s := New(Config{
+		PeerURLs:   []string{"http://127.0.0.1:2380"},
+		DataDir:    dir,
+		Transport:  &http.Transport{},
+		ClientURLs: func() []string { return []string{ts.URL} },
+	})
+	h := s.Handler()
+
+	// not synced yet
+	rw := httptest.NewRecorder()
+	h.ServeHTTP(rw, mustNewRequest(t, "POST", promotePath))
+	if rw.Code != http.StatusConflict {
+		t.Errorf("code = %d, want %d", rw.Code, http.StatusConflict)
+	}
+
+	if err = s.sync(); err != nil {
+		t.Fatalf("unexpected sync error: %v", err)
+	}
+	rw = httptest.NewRecorder()
+	h.ServeHTTP(rw, mustNewRequest(t, "GET", statusPath))
+	var st Status
+	if err = json.NewDecoder(rw.Body).Decode(&st); err != nil {
+		t.Fatal(err)
+	}
+	if st.ClusterID != "10" || st.Index != 10 || st.Term != 2 || st.MemberID != "" {
+		t.Errorf("status = %+v, want cluster 10 at index 10 and term 2", st)
+	}
+
+	req := mustNewRequest(t, "POST", promotePath)
+	req.SetBasicAuth("root", "rootpw")
+	rw = httptest.NewRecorder()
+	h.ServeHTTP(rw, req)
+	if rw.Code != http.StatusOK {
+		t.Fatalf("code = %d, want %d", rw.Code, http.StatusOK)
+	}
+	select {
+	case id := <-s.Promoted():
+		if id != 2 {
+			t.Errorf("id = %s, want 2", id)
+		}
+	default:
+		t.Fatalf("standby is not promoted")
+	}
+	if c.auth != req.Header.Get("Authorization") {
+		t.Errorf("authorization = %q, want %q", c.auth, req.Header.Get("Authorization"))
+	}
+	g, err := snap.New(path.Join(dir, "member", "snap")).Load()
+	if err != nil {
+		t.Fatalf("unexpected load error: %v", err)
+	}
+	if !reflect.DeepEqual(*g, testSnap) {
+		t.Errorf("snapshot = %+v, want %+v", *g, testSnap)
+	}
+	if !wal.Exist(path.Join(dir, "member", "wal")) {
+		t.Errorf("wal does not exist")
+	}
+
+	// promoted already
+	rw = httptest.NewRecorder()
+	h.ServeHTTP(rw, mustNewRequest(t, "POST", promotePath))
+	if rw.Code != http.StatusConflict {
+		t.Errorf("code = %d, want %d", rw.Code, http.StatusConflict)
+	}
+	rw = httptest.NewRecorder()
+	h.ServeHTTP(rw, mustNewRequest(t, "GET", statusPath))
+	if err = json.NewDecoder(rw.Body).Decode(&st); err != nil {
+		t.Fatal(err)
+	}
+	if st.MemberID != types.ID(2).String() {
+		t.Errorf("member ID = %s, want 2", st.MemberID)
+	}
+}
+
+func TestMaybePromote(t *testing.T) {
+	tests := []struct {
+		config    string
+		wpromoted bool
+	}{
+		{"", false},
+		{`{}`, false},
+		{`{"activeSize":0}`, false},
+		{`{"activeSize":1}`, false},
+		{`{"activeSize":2}`, true},
+	}
+	for i, tt := range tests {
+		dir, err := ioutil.TempDir(os.TempDir(), "standby")
+		if err != nil {
+			t.Fatal(err)
+		}
+		ts := httptest.NewServer(&fakeCluster{config: tt.config})
+		s := New(Config{
+			PeerURLs:   []string{"http://127.0.0.1:2380"},
+			DataDir:    dir,
+			Transport:  &http.Transport{},
+			ClientURLs: func() []string { return []string{ts.URL} },
+		})
+		if err = s.sync(); err != nil {
+			t.Fatalf("#%d: unexpected sync error: %v", i, err)
+		}
+		s.maybePromote()
+		select {
+		case id := <-s.Promoted():
+			if !tt.wpromoted {
+				t.Errorf("#%d: promoted to %s, want not promoted", i, id)
+			}
+		default:
+			if tt.wpromoted {
+				t.Errorf("#%d: not promoted, want promoted", i)
+			}
+		}
+		if tt.wpromoted && !wal.Exist(path.Join(dir, "member", "wal")) {
+			t.Errorf("#%d: wal does not exist", i)
+		}
+		// promoted at most once
+		s.maybePromote()
+		select {
+		case id := <-s.Promoted():
+			t.Errorf("#%d: promoted again to %s", i, id)
+		default:
+		}
+		ts.Close()
+		os.RemoveAll(dir)
+	}
+}
+
+func TestSyncFail(t *testing.T) {
+	ts := httptest.NewServer(http.NotFoundHandler())
+	defer ts.Close()
+	s := New(Config{
+		Transport:  &http.Transport{},
+		ClientURLs: func() []string { return []string{ts.URL} },
+	})
+	if err := s.sync(); err == nil {
+		t.Errorf("err = nil, want not nil")
+	}
+}
+
+func mustNewRequest(t *testing.T, method, p string) *http.Request {
+	req, err := http.NewRequest(method, "http://localhost"+p, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return req
+}
@@ -0,0 +1,1740 @@
+// Copyright 2015 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package store
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/coreos/etcd/Godeps/_workspace/src/github.com/boltdb/bolt"
+	"github.com/coreos/etcd/Godeps/_workspace/src/github.com/jonboulle/clockwork"
+	etcdErr "github.com/coreos/etcd/error"
+	"github.com/coreos/etcd/pkg/types"
+)
+
+// boltInitialMmapSize is the initial size of the memory map of the bolt
+// file. Bolt cannot grow the map while a read transaction is open, so a
+// write would wait for a snapshot being saved from a clone if the file
+// outgrows the map.
+const boltInitialMmapSize = 1024 * 1024 * 1024
+
+var (
+	nodesBucketName = []byte("nodes")
+	ttlBucketName   = []byte("ttl")
+
+	errBoltReadOnly = errors.New("store: the clone of a bolt store is read only")
+	errBoltReleased = errors.New("store: the clone of a bolt store has been saved and released")
+)
+
+// boltNode is the persisted form of a node.
+type boltNode struct {
+	Dir           bool      `json:"dir,omitempty"`
+	Value         string    `json:"value,omitempty"`
+	CreatedIndex  uint64    `json:"createdIndex"`
+	ModifiedIndex uint64    `json:"modifiedIndex"`
+	ExpireTime    time.Time `json:"expireTime"`
+}
+
+// node returns an in-memory node of the given path holding the content
+// of the boltNode. The node is only used to build the external
+// representation, so it is not attached to any store.
+func (bn *boltNode) node(nodePath string, parent *node) *node {
+	var n *node
+	if bn.Dir {
+		n = newDir(nil, nodePath, bn.CreatedIndex, parent, bn.ExpireTime)
+	} else {
+		n = newKV(nil, nodePath, bn.Value, bn.CreatedIndex, parent, bn.ExpireTime)
+	}
+	n.ModifiedIndex = bn.ModifiedIndex
+	return n
+}
+
+func (bn *boltNode) isPermanent() bool { return bn.ExpireTime.IsZero() }
+
+// boltKey encodes a node path as a bolt key. The separator is replaced
+// with a zero byte, which sorts before every other byte, so that a
+// directory is immediately followed by all the nodes under it.
+func boltKey(nodePath string) []byte {
+	return []byte(strings.Replace(nodePath, "/", "\x00", -1))
+}
+
+func boltPath(key []byte) string {
+	return strings.Replace(string(key), "\x00", "/", -1)
+}
+
+// boltChildrenPrefix returns the key prefix of the nodes under the directory.
+func boltChildrenPrefix(dirPath string) []byte {
+	if dirPath == "/" {
+		return []byte{0}
+	}
+	return append(boltKey(dirPath), 0)
+}
+
+// boltTTLKey returns the key of the node in the ttl bucket, which sorts
+// the nodes by expiration time. Times out of the range of UnixNano are
+// clamped to it.
+func boltTTLKey(expireTime time.Time, key []byte) []byte {
+	var nsec uint64
+	switch {
+	case expireTime.Before(time.Unix(0, 0)):
+		nsec = 0
+	case expireTime.After(time.Unix(0, math.MaxInt64)):
+		nsec = math.MaxInt64
+	default:
+		nsec = uint64(expireTime.UnixNano())
+	}
+	b := make([]byte, 8, 8+len(key))
+	binary.BigEndian.PutUint64(b, nsec)
+	return append(b, key...)
+}
+
+// boltStore is a Store that keeps its nodes in a boltdb file instead of
+// memory, so the size of the data is not limited by RAM. Cloning it for
+// a snapshot takes a read transaction instead of copying the whole tree.
+// The watchers and stats are kept in memory as in the v2 store.
+//
+// The file is a working set: its content is reset when the store is
+// opened, and the durable state of the server is still kept by the WAL
+// and the snapshots.
+type boltStore struct {
+	db *bolt.DB
+	// snapshot is the read transaction a clone reads from.
+	// A clone cannot be written.
+	snapshot *bolt.Tx
+	released bool
+
+	CurrentIndex   uint64
+	CurrentVersion int
+	WatcherHub     *watcherHub
+	Stats          *Stats
+	worldLock      sync.RWMutex // stop the world lock
+	clock          clockwork.Clock
+	readonlySet    types.Set
+
+	// pending holds the notifications of the current write transaction.
+	// They are sent after the transaction commits.
+	pending []func()
+	// expireBudget is the max number of the keys deleted by a call to
+	// DeleteExpiredKeys.
+	expireBudget int
+	// snapshotFormat is the format of the saved states.
+	snapshotFormat string
+
+	// RaftIndex is the consistent index, the raft index of the last entry
+	// applied to the store.
+	RaftIndex uint64
+	// RequestResults are the last results of the requests applied with
+	// a token.
+	RequestResults *requestResults
+}
+
+// NewBolt creates a store that keeps its nodes in the boltdb file at the
+// given path. The given namespaces will be created as initial directories
+// in the returned store.
+func NewBolt(dbPath string, historySize int, namespaces ...string) (Store, error) {
+	s, err := newBoltStore(dbPath, namespaces...)
+	if err != nil {
+		return nil, err
+	}
+	s.WatcherHub = newWatchHub(historySize)
+	s.clock = clockwork.NewRealClock()
+	return s, nil
+}
+
+func newBoltStore(dbPath string, namespaces ...string) (*boltStore, error) {
+	db, err := bolt.Open(dbPath, 0600, &bolt.Options{Timeout: time.Second, InitialMmapSize: boltInitialMmapSize})
+	if err != nil {
+		return nil, err
+	}
+	s := &boltStore{
+		db:             db,
+		CurrentVersion: defaultVersion,
+		WatcherHub:     newWatchHub(DefaultHistorySize),
+		Stats:          newStats(),
+		RequestResults: newRequestResults(),
+		readonlySet:    types.NewUnsafeSet(append(namespaces, "/")...),
+		expireBudget:   defaultExpireBudget,
+	}
+	err = db.Update(func(tx *bolt.Tx) error {
+		if err := resetBoltBuckets(tx); err != nil {
+			return err
+		}
+		for _, namespace := range namespaces {
+			if err := putBoltNode(tx, path.Clean(path.Join("/", namespace)), &boltNode{Dir: true}); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	return s, nil
+}
+
+// Close closes the underlying boltdb file.
+func (s *boltStore) Close() error {
+	if s.snapshot != nil {
+		return nil
+	}
+	return s.db.Close()
+}
+
+func resetBoltBuckets(tx *bolt.Tx) error {
+	for _, name := range [][]byte{nodesBucketName, ttlBucketName} {
+		if tx.Bucket(name) != nil {
+			if err := tx.DeleteBucket(name); err != nil {
+				return err
+			}
+		}
+		if _, err := tx.CreateBucket(name); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func getBoltNode(tx *bolt.Tx, nodePath string) *boltNode {
+	v := tx.Bucket(nodesBucketName).Get(boltKey(nodePath))
+	if v == nil {
+		return nil
+	}
+	bn := &boltNode{}
+	if err := json.Unmarshal(v, bn); err != nil {
+		panic(fmt.Sprintf("store: cannot decode node %s: %v", nodePath, err))
+	}
+	return bn
+}
+
+// ancestorsExpireTime returns the earliest expire time of the directories
+// above the node at the given path, or Permanent if none of them expires.
+func ancestorsExpireTime(tx *bolt.Tx, nodePath string) time.Time {
+	expireTime := Permanent
+	for p := nodePath; p != "/"; {
+		p = path.Dir(p)
+		if bn := getBoltNode(tx, p); bn != nil {
+			expireTime = earlierExpireTime(expireTime, bn.ExpireTime)
+		}
+	}
+	return expireTime
+}
+
+// putBoltNode writes the node and keeps the ttl bucket in sync with its
+// expiration time.
+func putBoltNode(tx *bolt.Tx, nodePath string, bn *boltNode) error {
+	key := boltKey(nodePath)
+	ttl := tx.Bucket(ttlBucketName)
+	if old := getBoltNode(tx, nodePath); old != nil && !old.isPermanent() {
+		if err := ttl.Delete(boltTTLKey(old.ExpireTime, key)); err != nil {
+			return err
+		}
+	}
+	data, err := json.Marshal(bn)
+	if err != nil {
+		return err
+	}
+	if err := tx.Bucket(nodesBucketName).Put(key, data); err != nil {
+		return err
+	}
+	if !bn.isPermanent() {
+		return ttl.Put(boltTTLKey(bn.ExpireTime, key), nil)
+	}
+	return nil
+}
+
+func deleteBoltNode(tx *bolt.Tx, nodePath string, bn *boltNode) error {
+	key := boltKey(nodePath)
+	if !bn.isPermanent() {
+		if err := tx.Bucket(ttlBucketName).Delete(boltTTLKey(bn.ExpireTime, key)); err != nil {
+			return err
+		}
+	}
+	return tx.Bucket(nodesBucketName).Delete(key)
+}
+
+// update runs f in a read-write transaction. If f fails, the transaction
+// is rolled back together with the index. Otherwise the notifications
+// queued by f are sent after the transaction commits.
+// The caller must hold the world lock.
+func (s *boltStore) update(f func(tx *bolt.Tx) error) error {
+	if s.snapshot != nil {
+		return errBoltReadOnly
+	}
+	index := s.CurrentIndex
+	s.pending = nil
+	if err := s.db.Update(f); err != nil {
+		s.CurrentIndex = index
+		s.pending = nil
+		return err
+	}
+	for _, notify := range s.pending {
+		notify()
+	}
+	s.pending = nil
+	return nil
+}
+
+func (s *boltStore) view(f func(tx *bolt.Tx) error) error {
+	if s.released {
+		return errBoltReleased
+	}
+	if s.snapshot != nil {
+		return f(s.snapshot)
+	}
+	return s.db.View(f)
+}
+
+// readView runs f in a read transaction with the index of the store at
+// the transaction. The world lock is only held to begin the transaction,
+// so that a large read neither blocks the writes nor is blocked by them:
+// boltdb keeps the pages the transaction reads until it is rolled back.
+// A clone has no writes, and its reads stay under the world lock as the
+// snapshot transaction is released by SaveNoCopy.
+// 只在开始读事务时持有读锁，读事务看到的是开始时的数据
+func (s *boltStore) readView(f func(tx *bolt.Tx, index uint64) error) error {
+	s.worldLock.RLock()
+	if s.released || s.snapshot != nil {
+		defer s.worldLock.RUnlock()
+		index := s.CurrentIndex
+		return s.view(func(tx *bolt.Tx) error { return f(tx, index) })
+	}
+	tx, err := s.db.Begin(false)
+	index := s.CurrentIndex
+	s.worldLock.RUnlock()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	return f(tx, index)
+}
+
+// queueNotify queues the notification of the event to the watchers.
+func (s *boltStore) queueNotify(e *Event) {
+	s.pending = append(s.pending, func() { s.WatcherHub.notify(e) })
+}
+
+// queueNotifyDeleted queues the notification of the watchers of a node
+// deleted by the event.
+func (s *boltStore) queueNotifyDeleted(e *Event, nodePath string) {
+	s.pending = append(s.pending, func() { s.WatcherHub.notifyWatchers(e, nodePath, true) })
+}
+
+func (s *boltStore) queueNotifyMove(e *Event, moved []string) {
+	s.pending = append(s.pending, func() { s.WatcherHub.notifyMove(e, moved) })
+}
+
+func (s *boltStore) queueStats(field int) {
+	s.pending = append(s.pending, func() { s.Stats.Inc(field) })
+}
+
+// Version retrieves current version of the store.
+func (s *boltStore) Version() int {
+	return s.CurrentVersion
+}
+
+// Retrieves current of the store
+func (s *boltStore) Index() uint64 {
+	s.worldLock.RLock()
+	defer s.worldLock.RUnlock()
+	return s.CurrentIndex
+}
+
+// Size returns the size of the boltdb file in use.
+func (s *boltStore) Size() int64 {
+	s.worldLock.RLock()
+	defer s.worldLock.RUnlock()
+
+	var size int64
+	s.view(func(tx *bolt.Tx) error {
+		size = tx.Size()
+		return nil
+	})
+	return size
+}
+
+// MemoryUsage returns the memory used by the event history. The nodes
+// are kept in the boltdb file, and their size is given by Size instead.
+func (s *boltStore) MemoryUsage() MemoryUsage {
+	return MemoryUsage{HistoryBytes: s.WatcherHub.EventHistory.historyBytes()}
+}
+
+// Usage returns the number of the nodes under the directory and the
+// total size of their values.
+func (s *boltStore) Usage(nodePath string) (int64, int64, error) {
+	s.worldLock.RLock()
+	defer s.worldLock.RUnlock()
+
+	nodePath = path.Clean(path.Join("/", nodePath))
+	var nodes, valueBytes int64
+	err := s.view(func(tx *bolt.Tx) error {
+		bn, err := s.internalGet(tx, nodePath)
+		if err != nil {
+			return err
+		}
+		if !bn.Dir {
+			return etcdErr.NewError(etcdErr.EcodeNotDir, nodePath, s.CurrentIndex)
+		}
+		prefix := boltChildrenPrefix(nodePath)
+		c := tx.Bucket(nodesBucketName).Cursor()
+		for k, v := c.Seek(prefix); k != nil && bytes.HasPrefix(k, prefix); k, v = c.Next() {
+			child := &boltNode{}
+			if err := json.Unmarshal(v, child); err != nil {
+				panic(fmt.Sprintf("store: cannot decode node %s: %v", boltPath(k), err))
+			}
+			nodes++
+			valueBytes += int64(len(child.Value))
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, 0, err
+	}
+	return nodes, valueBytes, nil
+}
+
+func (s *boltStore) OldestWatchableIndex() uint64 {
+	s.worldLock.RLock()
+	defer s.worldLock.RUnlock()
+	return s.WatcherHub.EventHistory.oldestIndex()
+}
+
+func (s *boltStore) CompactHistory(index uint64) {
+	s.worldLock.Lock()
+	defer s.worldLock.Unlock()
+	s.WatcherHub.EventHistory.compact(index)
+}
+
+// internalGet gets the node of the given nodePath, walking through all
+// the directories on the path like the v2 store.
+func (s *boltStore) internalGet(tx *bolt.Tx, nodePath string) (*boltNode, *etcdErr.Error) {
+	nodePath = path.Clean(path.Join("/", nodePath))
+	if nodePath == "/" {
+		return &boltNode{Dir: true}, nil
+	}
+
+	curr := "/"
+	for _, name := range strings.Split(nodePath, "/")[1:] {
+		p := path.Join(curr, name)
+		bn := getBoltNode(tx, p)
+		if bn == nil {
+			return nil, etcdErr.NewError(etcdErr.EcodeKeyNotFound, p, s.CurrentIndex)
+		}
+		if p == nodePath {
+			return bn, nil
+		}
+		if !bn.Dir {
+			return nil, etcdErr.NewError(etcdErr.EcodeNotDir, p, s.CurrentIndex)
+		}
+		curr = p
+	}
+	return nil, etcdErr.NewError(etcdErr.EcodeKeyNotFound, nodePath, s.CurrentIndex)
+}
+
+// loadNode returns an in-memory node of the given path. If the node is
+// a directory, the nodes under it are loaded as well, all of them if
+// recursive is true and only its children otherwise.
+func (s *boltStore) loadNode(tx *bolt.Tx, nodePath string, bn *boltNode, recursive bool) *node {
+	n := bn.node(nodePath, nil)
+	if !n.IsDir() {
+		return n
+	}
+
+	dirs := map[string]*node{nodePath: n}
+	prefix := boltChildrenPrefix(nodePath)
+	c := tx.Bucket(nodesBucketName).Cursor()
+	for k, v := c.Seek(prefix); k != nil && bytes.HasPrefix(k, prefix); {
+		p := boltPath(k)
+		parent, ok := dirs[path.Dir(p)]
+		if !ok {
+			k, v = c.Next()
+			continue
+		}
+		child := &boltNode{}
+		if err := json.Unmarshal(v, child); err != nil {
+			panic(fmt.Sprintf("store: cannot decode node %s: %v", p, err))
+		}
+		cn := child.node(p, parent)
+		parent.Children[path.Base(p)] = cn
+		if cn.IsDir() {
+			if recursive {
+				dirs[p] = cn
+			} else {
+				// skip the nodes under the child directory
+				k, v = c.Seek(append(boltKey(p), 1))
+				continue
+			}
+		}
+		k, v = c.Next()
+	}
+	return n
+}
+
+// Get returns a get event.
+// If recursive is true, it will return all the content under the node path.
+// If sorted is true, it will sort the content by keys.
+func (s *boltStore) Get(nodePath string, recursive, sorted bool) (*Event, error) {
+
+	nodePath = path.Clean(path.Join("/", nodePath))
+
+	var e *Event
+	err := s.readView(func(tx *bolt.Tx, index uint64) error {
+		bn, err := s.internalGet(tx, nodePath)
+		if err != nil {
+			return err
+		}
+		e = newEvent(Get, nodePath, bn.ModifiedIndex, bn.CreatedIndex)
+		e.EtcdIndex = index
+		e.Node.loadInternalNode(s.loadNode(tx, nodePath, bn, recursive), recursive, sorted, s.clock)
+		e.Node.inheritExpiration(ancestorsExpireTime(tx, nodePath), s.clock)
+		return nil
+	})
+
+	if err != nil {
+		s.Stats.Inc(GetFail)
+		return nil, err
+	}
+
+	s.Stats.Inc(GetSuccess)
+
+	return e, nil
+}
+
+// GetPage returns a get event with a page of the content under the node path.
+func (s *boltStore) GetPage(nodePath string, recursive bool, limit int, after string) (*Event, error) {
+
+	nodePath = path.Clean(path.Join("/", nodePath))
+
+	var e *Event
+	err := s.readView(func(tx *bolt.Tx, index uint64) error {
+		bn, err := s.internalGet(tx, nodePath)
+		if err != nil {
+			return err
+		}
+		e = newEvent(Get, nodePath, bn.ModifiedIndex, bn.CreatedIndex)
+		e.EtcdIndex = index
+		e.Continue = e.Node.loadInternalNodePage(s.loadNode(tx, nodePath, bn, recursive), recursive, limit, after, s.clock)
+		e.Node.inheritExpiration(ancestorsExpireTime(tx, nodePath), s.clock)
+		return nil
+	})
+
+	if err != nil {
+		s.Stats.Inc(GetFail)
+		return nil, err
+	}
+
+	s.Stats.Inc(GetSuccess)
+
+	return e, nil
+}
+
+// Create creates the node at nodePath. Create will help to create intermediate directories with no ttl.
+// If the node has already existed, create will fail.
+// If any node on the path is a file, create will fail.
+func (s *boltStore) Create(nodePath string, dir bool, value string, unique bool, expireTime time.Time) (*Event, error) {
+	s.worldLock.Lock()
+	defer s.worldLock.Unlock()
+
+	var e *Event
+	err := s.update(func(tx *bolt.Tx) error {
+		var err error
+		e, err = s.internalCreate(tx, nodePath, dir, value, unique, false, expireTime, Create)
+		if err != nil {
+			return err
+		}
+		e.EtcdIndex = s.CurrentIndex
+		s.queueNotify(e)
+		return nil
+	})
+
+	if err != nil {
+		s.Stats.Inc(CreateFail)
+		return nil, err
+	}
+
+	s.Stats.Inc(CreateSuccess)
+	return e, nil
+}
+
+// Set creates or replace the node at nodePath.
+func (s *boltStore) Set(nodePath string, dir bool, value string, expireTime time.Time) (*Event, error) {
+	s.worldLock.Lock()
+	defer s.worldLock.Unlock()
+
+	var e *Event
+	err := s.update(func(tx *bolt.Tx) error {
+		// Get prevNode value
+		prev, getErr := s.internalGet(tx, nodePath)
+		if getErr != nil && getErr.ErrorCode != etcdErr.EcodeKeyNotFound {
+			return getErr
+		}
+
+		// Set new value
+		var err error
+		e, err = s.internalCreate(tx, nodePath, dir, value, false, true, expireTime, Set)
+		if err != nil {
+			return err
+		}
+		e.EtcdIndex = s.CurrentIndex
+
+		// Put prevNode into event
+		if getErr == nil {
+			prevPath := path.Clean(path.Join("/", nodePath))
+			p := newEvent(Get, prevPath, prev.ModifiedIndex, prev.CreatedIndex)
+			p.Node.loadInternalNode(s.loadNode(tx, prevPath, prev, false), false, false, s.clock)
+			e.PrevNode = p.Node
+		}
+
+		s.queueNotify(e)
+		return nil
+	})
+
+	if err != nil {
+		s.Stats.Inc(SetFail)
+		return nil, err
+	}
+
+	s.Stats.Inc(SetSuccess)
+	return e, nil
+}
+
+func (s *boltStore) Incr(nodePath string, delta int64) (*Event, error) {
+	s.worldLock.Lock()
+	defer s.worldLock.Unlock()
+
+	nodePath = path.Clean(path.Join("/", nodePath))
+	// we do not allow the user to change "/"
+	if s.readonlySet.Contains(nodePath) {
+		return nil, etcdErr.NewError(etcdErr.EcodeRootROnly, "/", s.CurrentIndex)
+	}
+
+	var e *Event
+	err := s.update(func(tx *bolt.Tx) error {
+		bn, err := s.internalGet(tx, nodePath)
+		if err != nil {
+			if err.ErrorCode != etcdErr.EcodeKeyNotFound {
+				return err
+			}
+			// a missing node counts as zero
+			var cerr error
+			e, cerr = s.internalCreate(tx, nodePath, false, strconv.FormatInt(delta, 10), false, false, Permanent, Incr)
+			if cerr != nil {
+				return cerr
+			}
+			e.EtcdIndex = s.CurrentIndex
+			s.queueNotify(e)
+			return nil
+		}
+
+		if bn.Dir { // can only incr file
+			return etcdErr.NewError(etcdErr.EcodeNotFile, nodePath, s.CurrentIndex)
+		}
+		value, nerr := addInt(bn.Value, delta)
+		if nerr != nil {
+			return etcdErr.NewError(etcdErr.EcodeValueNaN, nodePath, s.CurrentIndex)
+		}
+
+		// update etcd index
+		s.CurrentIndex++
+
+		e = newEvent(Incr, nodePath, s.CurrentIndex, bn.CreatedIndex)
+		e.EtcdIndex = s.CurrentIndex
+		e.PrevNode = bn.node(nodePath, nil).Repr(false, false, s.clock)
+		eNode := e.Node
+
+		bn.Value = value
+		bn.ModifiedIndex = s.CurrentIndex
+		if err := putBoltNode(tx, nodePath, bn); err != nil {
+			return err
+		}
+
+		eNode.Value = &value
+		eNode.Expiration, eNode.TTL = bn.node(nodePath, nil).expirationAndTTL(s.clock)
+
+		s.queueNotify(e)
+		return nil
+	})
+
+	if err != nil {
+		s.Stats.Inc(IncrFail)
+		return nil, err
+	}
+
+	s.Stats.Inc(IncrSuccess)
+	return e, nil
+}
+
+func (s *boltStore) Move(nodePath, newPath string) (*Event, error) {
+	s.worldLock.Lock()
+	defer s.worldLock.Unlock()
+
+	nodePath = path.Clean(path.Join("/", nodePath))
+	newPath = path.Clean(path.Join("/", newPath))
+	// we do not allow the user to change "/"
+	if s.readonlySet.Contains(nodePath) || s.readonlySet.Contains(newPath) {
+		return nil, etcdErr.NewError(etcdErr.EcodeRootROnly, "/", s.CurrentIndex)
+	}
+	if strings.HasPrefix(newPath, nodePath+"/") {
+		s.Stats.Inc(MoveFail)
+		return nil, etcdErr.NewError(etcdErr.EcodeInvalidField, "cannot move "+nodePath+" under itself", s.CurrentIndex)
+	}
+
+	var e *Event
+	err := s.update(func(tx *bolt.Tx) error {
+		bn, err := s.internalGet(tx, nodePath)
+		if err != nil { // if the node does not exist, return error
+			return err
+		}
+		if getBoltNode(tx, newPath) != nil {
+			return etcdErr.NewError(etcdErr.EcodeNodeExist, newPath, s.CurrentIndex)
+		}
+
+		nextIndex := s.CurrentIndex + 1
+		dirName, _ := path.Split(newPath)
+		if err := s.mkdirs(tx, dirName, nextIndex); err != nil {
+			return err
+		}
+
+		e = newEvent(Move, newPath, nextIndex, bn.CreatedIndex)
+		e.EtcdIndex = nextIndex
+		e.PrevNode = bn.node(nodePath, nil).Repr(false, false, s.clock)
+		eNode := e.Node
+
+		// move the node and the nodes under it
+		moved := []string{nodePath}
+		if bn.Dir {
+			prefix := boltChildrenPrefix(nodePath)
+			c := tx.Bucket(nodesBucketName).Cursor()
+			for k, _ := c.Seek(prefix); k != nil && bytes.HasPrefix(k, prefix); k, _ = c.Next() {
+				moved = append(moved, boltPath(k))
+			}
+		}
+		for _, p := range moved {
+			mn := getBoltNode(tx, p)
+			if err := deleteBoltNode(tx, p, mn); err != nil {
+				return err
+			}
+			if err := putBoltNode(tx, newPath+strings.TrimPrefix(p, nodePath), mn); err != nil {
+				return err
+			}
+		}
+
+		if bn.Dir {
+			eNode.Dir = true
+		} else {
+			// copy the value for safety
+			valueCopy := bn.Value
+			eNode.Value = &valueCopy
+		}
+		eNode.Expiration, eNode.TTL = bn.node(newPath, nil).expirationAndTTL(s.clock)
+
+		s.CurrentIndex = nextIndex
+
+		s.queueNotifyMove(e, moved)
+		s.queueNotify(e)
+		return nil
+	})
+
+	if err != nil {
+		s.Stats.Inc(MoveFail)
+		return nil, err
+	}
+
+	s.Stats.Inc(MoveSuccess)
+	return e, nil
+}
+
+func (s *boltStore) Export(nodePath string) (*Export, error) {
+
+	nodePath = path.Clean(path.Join("/", nodePath))
+
+	var ex *Export
+	err := s.readView(func(tx *bolt.Tx, index uint64) error {
+		bn, err := s.internalGet(tx, nodePath)
+		if err != nil {
+			return err
+		}
+		n := s.loadNode(tx, nodePath, bn, true)
+		ex = &Export{Version: ExportVersion, Index: index, Node: exportNode(n, s.clock)}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return ex, nil
+}
+
+func (s *boltStore) Import(nodePath string, ex *Export) (*Event, error) {
+	s.worldLock.Lock()
+	defer s.worldLock.Unlock()
+
+	nodePath = path.Clean(path.Join("/", nodePath))
+	// we do not allow the user to change "/"
+	if s.readonlySet.Contains(nodePath) {
+		return nil, etcdErr.NewError(etcdErr.EcodeRootROnly, "/", s.CurrentIndex)
+	}
+	if err := ex.Validate(); err != nil {
+		s.Stats.Inc(ImportFail)
+		return nil, etcdErr.NewError(etcdErr.EcodeInvalidField, err.Error(), s.CurrentIndex)
+	}
+
+	var e *Event
+	err := s.update(func(tx *bolt.Tx) error {
+		if getBoltNode(tx, nodePath) != nil {
+			return etcdErr.NewError(etcdErr.EcodeNodeExist, nodePath, s.CurrentIndex)
+		}
+
+		nextIndex := s.CurrentIndex + 1
+		dirName, _ := path.Split(nodePath)
+		if err := s.mkdirs(tx, dirName, nextIndex); err != nil {
+			return err
+		}
+
+		// write the imported nodes, all of them at the index of the import
+		err := walkExport(ex.Node, nodePath, func(p string, en *NodeExtern) error {
+			bn := &boltNode{
+				Dir:           en.Dir,
+				CreatedIndex:  nextIndex,
+				ModifiedIndex: nextIndex,
+				ExpireTime:    exportExpireTime(en),
+			}
+			if !en.Dir {
+				bn.Value = *en.Value
+			}
+			return putBoltNode(tx, p, bn)
+		})
+		if err != nil {
+			return err
+		}
+
+		e = newEvent(Import, nodePath, nextIndex, nextIndex)
+		e.EtcdIndex = nextIndex
+		eNode := e.Node
+		bn := getBoltNode(tx, nodePath)
+		if bn.Dir {
+			eNode.Dir = true
+		} else {
+			// copy the value for safety
+			valueCopy := bn.Value
+			eNode.Value = &valueCopy
+		}
+		eNode.Expiration, eNode.TTL = bn.node(nodePath, nil).expirationAndTTL(s.clock)
+
+		s.CurrentIndex = nextIndex
+
+		s.queueNotify(e)
+		return nil
+	})
+
+	if err != nil {
+		s.Stats.Inc(ImportFail)
+		return nil, err
+	}
+
+	s.Stats.Inc(ImportSuccess)
+	return e, nil
+}
+
+func (s *boltStore) CompareAndSwap(nodePath string, prevValue string, prevIndex uint64,
+	value string, expireTime time.Time) (*Event, error) {
+
+	s.worldLock.Lock()
+	defer s.worldLock.Unlock()
+
+	nodePath = path.Clean(path.Join("/", nodePath))
+	// we do not allow the user to change "/"
+	if s.readonlySet.Contains(nodePath) {
+		return nil, etcdErr.NewError(etcdErr.EcodeRootROnly, "/", s.CurrentIndex)
+	}
+
+	var e *Event
+	err := s.update(func(tx *bolt.Tx) error {
+		bn, err := s.internalGet(tx, nodePath)
+		if err != nil {
+			return err
+		}
+
+		if bn.Dir { // can only compare and swap file
+			return etcdErr.NewError(etcdErr.EcodeNotFile, nodePath, s.CurrentIndex)
+		}
+
+		// If both of the prevValue and prevIndex are given, we will test both of them.
+		// Command will be executed, only if both of the tests are successful.
+		n := bn.node(nodePath, nil)
+		if ok, which := n.Compare(prevValue, prevIndex); !ok {
+			cause := getCompareFailCause(n, which, prevValue, prevIndex)
+			return etcdErr.NewError(etcdErr.EcodeTestFailed, cause, s.CurrentIndex)
+		}
+
+		// update etcd index
+		s.CurrentIndex++
+
+		e = newEvent(CompareAndSwap, nodePath, s.CurrentIndex, bn.CreatedIndex)
+		e.EtcdIndex = s.CurrentIndex
+		e.PrevNode = n.Repr(false, false, s.clock)
+		eNode := e.Node
+
+		// if test succeed, write the value
+		bn.Value = value
+		bn.ModifiedIndex = s.CurrentIndex
+		bn.ExpireTime = expireTime
+		if err := putBoltNode(tx, nodePath, bn); err != nil {
+			return err
+		}
+
+		// copy the value for safety
+		valueCopy := value
+		eNode.Value = &valueCopy
+		eNode.Expiration, eNode.TTL = bn.node(nodePath, nil).expirationAndTTL(s.clock)
+
+		s.queueNotify(e)
+		return nil
+	})
+
+	if err != nil {
+		s.Stats.Inc(CompareAndSwapFail)
+		return nil, err
+	}
+
+	s.Stats.Inc(CompareAndSwapSuccess)
+	return e, nil
+}
+
+// Delete deletes the node at the given path.
+// If the node is a directory, recursive must be true to delete it.
+func (s *boltStore) Delete(nodePath string, dir, recursive bool) (*Event, error) {
+	s.worldLock.Lock()
+	defer s.worldLock.Unlock()
+
+	var e *Event
+	err := s.update(func(tx *bolt.Tx) error {
+		var err error
+		e, err = s.internalDelete(tx, nodePath, dir, recursive)
+		return err
+	})
+
+	if err != nil {
+		s.Stats.Inc(DeleteFail)
+		return nil, err
+	}
+
+	s.Stats.Inc(DeleteSuccess)
+	return e, nil
+}
+
+func (s *boltStore) internalDelete(tx *bolt.Tx, nodePath string, dir, recursive bool) (*Event, error) {
+	nodePath = path.Clean(path.Join("/", nodePath))
+	// we do not allow the user to change "/"
+	if s.readonlySet.Contains(nodePath) {
+		return nil, etcdErr.NewError(etcdErr.EcodeRootROnly, "/", s.CurrentIndex)
+	}
+
+	// recursive implies dir
+	if recursive == true {
+		dir = true
+	}
+
+	bn, err := s.internalGet(tx, nodePath)
+	if err != nil { // if the node does not exist, return error
+		return nil, err
+	}
+
+	nextIndex := s.CurrentIndex + 1
+	e := newEvent(Delete, nodePath, nextIndex, bn.CreatedIndex)
+	e.EtcdIndex = nextIndex
+	e.PrevNode = bn.node(nodePath, nil).Repr(false, false, s.clock)
+
+	if bn.Dir {
+		e.Node.Dir = true
+	}
+
+	if err := s.remove(tx, nodePath, bn, dir, recursive, e); err != nil {
+		return nil, err
+	}
+
+	// update etcd index
+	s.CurrentIndex++
+
+	s.queueNotify(e)
+
+	return e, nil
+}
+
+// remove removes the node and all the nodes under it, and queues the
+// notification of the watchers of every removed node.
+func (s *boltStore) remove(tx *bolt.Tx, nodePath string, bn *boltNode, dir, recursive bool, e *Event) error {
+	var under []string
+	if bn.Dir {
+		if !dir {
+			// cannot delete a directory without recursive set to true
+			return etcdErr.NewError(etcdErr.EcodeNotFile, nodePath, s.CurrentIndex)
+		}
+
+		prefix := boltChildrenPrefix(nodePath)
+		c := tx.Bucket(nodesBucketName).Cursor()
+		for k, _ := c.Seek(prefix); k != nil && bytes.HasPrefix(k, prefix); k, _ = c.Next() {
+			under = append(under, boltPath(k))
+		}
+
+		if len(under) != 0 && !recursive {
+			// cannot delete a directory if it is not empty and the operation
+			// is not recursive
+			return etcdErr.NewError(etcdErr.EcodeDirNotEmpty, nodePath, s.CurrentIndex)
+		}
+	}
+
+	// delete the nodes under it, deepest first, and then itself
+	for i := len(under) - 1; i >= 0; i-- {
+		if err := deleteBoltNode(tx, under[i], getBoltNode(tx, under[i])); err != nil {
+			return err
+		}
+		s.queueNotifyDeleted(e, under[i])
+	}
+	if err := deleteBoltNode(tx, nodePath, bn); err != nil {
+		return err
+	}
+	s.queueNotifyDeleted(e, nodePath)
+	return nil
+}
+
+func (s *boltStore) CompareAndDelete(nodePath string, prevValue string, prevIndex uint64) (*Event, error) {
+	nodePath = path.Clean(path.Join("/", nodePath))
+
+	s.worldLock.Lock()
+	defer s.worldLock.Unlock()
+
+	var e *Event
+	err := s.update(func(tx *bolt.Tx) error {
+		bn, err := s.internalGet(tx, nodePath)
+		if err != nil { // if the node does not exist, return error
+			return err
+		}
+
+		if bn.Dir { // can only compare and delete file
+			return etcdErr.NewError(etcdErr.EcodeNotFile, nodePath, s.CurrentIndex)
+		}
+
+		// If both of the prevValue and prevIndex are given, we will test both of them.
+		// Command will be executed, only if both of the tests are successful.
+		n := bn.node(nodePath, nil)
+		if ok, which := n.Compare(prevValue, prevIndex); !ok {
+			cause := getCompareFailCause(n, which, prevValue, prevIndex)
+			return etcdErr.NewError(etcdErr.EcodeTestFailed, cause, s.CurrentIndex)
+		}
+
+		// update etcd index
+		s.CurrentIndex++
+
+		e = newEvent(CompareAndDelete, nodePath, s.CurrentIndex, bn.CreatedIndex)
+		e.EtcdIndex = s.CurrentIndex
+		e.PrevNode = n.Repr(false, false, s.clock)
+
+		if err := s.remove(tx, nodePath, bn, false, false, e); err != nil {
+			return err
+		}
+
+		s.queueNotify(e)
+		return nil
+	})
+
+	if err != nil {
+		s.Stats.Inc(CompareAndDeleteFail)
+		return nil, err
+	}
+
+	s.Stats.Inc(CompareAndDeleteSuccess)
+	return e, nil
+}
+
+func (s *boltStore) CompareAndDeleteDir(nodePath, marker, prevValue string, prevIndex uint64, recursive bool) (*Event, error) {
+	nodePath = path.Clean(path.Join("/", nodePath))
+
+	s.worldLock.Lock()
+	defer s.worldLock.Unlock()
+
+	var e *Event
+	err := s.update(func(tx *bolt.Tx) error {
+		// we do not allow the user to change "/"
+		if s.readonlySet.Contains(nodePath) {
+			return etcdErr.NewError(etcdErr.EcodeRootROnly, "/", s.CurrentIndex)
+		}
+
+		bn, err := s.internalGet(tx, nodePath)
+		if err != nil { // if the node does not exist, return error
+			return err
+		}
+		if !bn.Dir {
+			return etcdErr.NewError(etcdErr.EcodeNotDir, nodePath, s.CurrentIndex)
+		}
+
+		var markerExists bool
+		var markerValue string
+		if marker != "" {
+			markerPath, err := markerPath(nodePath, marker, s.CurrentIndex)
+			if err != nil {
+				return err
+			}
+			// a missing marker fails the compare like a different value
+			if m := getBoltNode(tx, markerPath); m != nil && !m.Dir {
+				markerExists, markerValue = true, m.Value
+			}
+		}
+		if cause, ok := compareDir(bn.ModifiedIndex, markerExists, markerValue, prevValue, prevIndex, marker); !ok {
+			return etcdErr.NewError(etcdErr.EcodeTestFailed, cause, s.CurrentIndex)
+		}
+
+		e = newEvent(CompareAndDelete, nodePath, s.CurrentIndex+1, bn.CreatedIndex)
+		e.EtcdIndex = s.CurrentIndex + 1
+		e.PrevNode = bn.node(nodePath, nil).Repr(false, false, s.clock)
+		e.Node.Dir = true
+
+		if err := s.remove(tx, nodePath, bn, true, recursive, e); err != nil {
+			return err
+		}
+
+		// update etcd index
+		s.CurrentIndex++
+
+		s.queueNotify(e)
+		return nil
+	})
+
+	if err != nil {
+		s.Stats.Inc(CompareAndDeleteFail)
+		return nil, err
+	}
+
+	s.Stats.Inc(CompareAndDeleteSuccess)
+	return e, nil
+}
+
+func (s *boltStore) Watch(key string, recursive, stream bool, sinceIndex uint64, actions []string) (Watcher, error) {
+	s.worldLock.RLock()
+	defer s.worldLock.RUnlock()
+
+	key = path.Clean(path.Join("/", key))
+	if sinceIndex == 0 {
+		sinceIndex = s.CurrentIndex + 1
+	}
+	// WatcherHub does not know about the current index, so we need to pass it in
+	w, err := s.WatcherHub.watch(key, recursive, stream, sinceIndex, s.CurrentIndex, actions)
+	if err != nil {
+		return nil, err
+	}
+
+	return w, nil
+}
+
+// Update updates the value/ttl of the node.
+// If the node is a file, the value and the ttl can be updated.
+// If the node is a directory, only the ttl can be updated.
+func (s *boltStore) Update(nodePath string, newValue string, expireTime time.Time) (*Event, error) {
+	s.worldLock.Lock()
+	defer s.worldLock.Unlock()
+
+	nodePath = path.Clean(path.Join("/", nodePath))
+	// we do not allow the user to change "/"
+	if s.readonlySet.Contains(nodePath) {
+		return nil, etcdErr.NewError(etcdErr.EcodeRootROnly, "/", s.CurrentIndex)
+	}
+
+	var e *Event
+	err := s.update(func(tx *bolt.Tx) error {
+		currIndex, nextIndex := s.CurrentIndex, s.CurrentIndex+1
+
+		bn, err := s.internalGet(tx, nodePath)
+		if err != nil { // if the node does not exist, return error
+			return err
+		}
+
+		e = newEvent(Update, nodePath, nextIndex, bn.CreatedIndex)
+		e.EtcdIndex = nextIndex
+		e.PrevNode = bn.node(nodePath, nil).Repr(false, false, s.clock)
+		eNode := e.Node
+
+		if bn.Dir && len(newValue) != 0 {
+			// if the node is a directory, we cannot update value to non-empty
+			return etcdErr.NewError(etcdErr.EcodeNotFile, nodePath, currIndex)
+		}
+
+		if bn.Dir {
+			eNode.Dir = true
+		} else {
+			bn.Value = newValue
+			bn.ModifiedIndex = nextIndex
+			// copy the value for safety
+			newValueCopy := newValue
+			eNode.Value = &newValueCopy
+		}
+
+		// update ttl
+		bn.ExpireTime = expireTime
+		if err := putBoltNode(tx, nodePath, bn); err != nil {
+			return err
+		}
+
+		eNode.Expiration, eNode.TTL = bn.node(nodePath, nil).expirationAndTTL(s.clock)
+
+		s.queueNotify(e)
+
+		s.CurrentIndex = nextIndex
+		return nil
+	})
+
+	if err != nil {
+		s.Stats.Inc(UpdateFail)
+		return nil, err
+	}
+
+	s.Stats.Inc(UpdateSuccess)
+	return e, nil
+}
+
+func (s *boltStore) Refresh(nodePath string, expireTime time.Time) (*Event, error) {
+	s.worldLock.Lock()
+	defer s.worldLock.Unlock()
+
+	nodePath = path.Clean(path.Join("/", nodePath))
+	// we do not allow the user to change "/"
+	if s.readonlySet.Contains(nodePath) {
+		return nil, etcdErr.NewError(etcdErr.EcodeRootROnly, "/", s.CurrentIndex)
+	}
+
+	var e *Event
+	err := s.update(func(tx *bolt.Tx) error {
+		bn, err := s.internalGet(tx, nodePath)
+		if err != nil { // if the node does not exist, return error
+			return err
+		}
+
+		e = &Event{
+			Action:    Update,
+			PrevNode:  bn.node(nodePath, nil).Repr(false, false, s.clock),
+			EtcdIndex: s.CurrentIndex,
+		}
+
+		bn.ExpireTime = expireTime
+		if err := putBoltNode(tx, nodePath, bn); err != nil {
+			return err
+		}
+
+		e.Node = bn.node(nodePath, nil).Repr(false, false, s.clock)
+		return nil
+	})
+
+	if err != nil {
+		s.Stats.Inc(UpdateFail)
+		return nil, err
+	}
+
+	s.Stats.Inc(UpdateSuccess)
+	return e, nil
+}
+
+func (s *boltStore) internalCreate(tx *bolt.Tx, nodePath string, dir bool, value string, unique, replace bool,
+	expireTime time.Time, action string) (*Event, error) {
+
+	currIndex, nextIndex := s.CurrentIndex, s.CurrentIndex+1
+
+	if unique { // append unique item under the node path
+		nodePath += "/" + strconv.FormatUint(nextIndex, 10)
+	}
+
+	nodePath = path.Clean(path.Join("/", nodePath))
+
+	// we do not allow the user to change "/"
+	if s.readonlySet.Contains(nodePath) {
+		return nil, etcdErr.NewError(etcdErr.EcodeRootROnly, "/", currIndex)
+	}
+
+	// Assume expire times that are way in the past are
+	// This can occur when the time is serialized to JS
+	if expireTime.Before(minExpireTime) {
+		expireTime = Permanent
+	}
+
+	dirName, _ := path.Split(nodePath)
+
+	// walk through the nodePath and create the missing dirs
+	if err := s.mkdirs(tx, dirName, nextIndex); err != nil {
+		return nil, err
+	}
+
+	e := newEvent(action, nodePath, nextIndex, nextIndex)
+	eNode := e.Node
+
+	// force will try to replace a existing file
+	if bn := getBoltNode(tx, nodePath); bn != nil {
+		if !replace {
+			return nil, etcdErr.NewError(etcdErr.EcodeNodeExist, nodePath, currIndex)
+		}
+		if bn.Dir {
+			return nil, etcdErr.NewError(etcdErr.EcodeNotFile, nodePath, currIndex)
+		}
+		e.PrevNode = bn.node(nodePath, nil).Repr(false, false, s.clock)
+		if err := deleteBoltNode(tx, nodePath, bn); err != nil {
+			return nil, err
+		}
+	}
+
+	bn := &boltNode{
+		Dir:           dir,
+		CreatedIndex:  nextIndex,
+		ModifiedIndex: nextIndex,
+		ExpireTime:    expireTime,
+	}
+	if !dir { // create file
+		// copy the value for safety
+		valueCopy := value
+		eNode.Value = &valueCopy
+		bn.Value = value
+	} else { // create directory
+		eNode.Dir = true
+	}
+
+	if err := putBoltNode(tx, nodePath, bn); err != nil {
+		return nil, err
+	}
+
+	// node with TTL
+	if !bn.isPermanent() {
+		eNode.Expiration, eNode.TTL = bn.node(nodePath, nil).expirationAndTTL(s.clock)
+	}
+
+	s.CurrentIndex = nextIndex
+
+	return e, nil
+}
+
+// mkdirs creates the missing directories on the path with the given index.
+func (s *boltStore) mkdirs(tx *bolt.Tx, dirName string, index uint64) error {
+	curr := "/"
+	for _, name := range strings.Split(path.Clean(dirName), "/")[1:] {
+		if len(name) == 0 {
+			break
+		}
+		curr = path.Join(curr, name)
+		bn := getBoltNode(tx, curr)
+		if bn == nil {
+			bn = &boltNode{Dir: true, CreatedIndex: index, ModifiedIndex: index}
+			if err := putBoltNode(tx, curr, bn); err != nil {
+				return err
+			}
+			continue
+		}
+		if !bn.Dir {
+			return etcdErr.NewError(etcdErr.EcodeNotDir, curr, s.CurrentIndex)
+		}
+	}
+	return nil
+}
+
+// Txn applies the success operations if all the compares hold, and the
+// failure operations otherwise, in one bolt transaction. If any of the
+// operations fails, the transaction is rolled back.
+func (s *boltStore) Txn(cmps []TxnCompare, success, failure []TxnOp) (*TxnResponse, error) {
+	s.worldLock.Lock()
+	defer s.worldLock.Unlock()
+
+	var resp *TxnResponse
+	err := s.update(func(tx *bolt.Tx) error {
+		succeeded := true
+		for _, c := range cmps {
+			var value string
+			var createdIndex, modifiedIndex uint64
+			if bn, err := s.internalGet(tx, c.Path); err == nil {
+				value, createdIndex, modifiedIndex = bn.Value, bn.CreatedIndex, bn.ModifiedIndex
+			}
+			if !c.holds(value, createdIndex, modifiedIndex) {
+				succeeded = false
+				break
+			}
+		}
+		ops := failure
+		if succeeded {
+			ops = success
+		}
+
+		if err := checkTxnPaths(ops, s.readonlySet, s.CurrentIndex); err != nil {
+			return err
+		}
+
+		resp = &TxnResponse{Succeeded: succeeded}
+		for _, op := range ops {
+			var e *Event
+			var err error
+			switch op.Action {
+			case Set:
+				e, err = s.internalCreate(tx, op.Path, op.Dir, op.Value, false, true, op.ExpireTime, Set)
+				if err == nil {
+					e.EtcdIndex = s.CurrentIndex
+					s.queueNotify(e)
+					s.queueStats(SetSuccess)
+				}
+			case Delete:
+				e, err = s.internalDelete(tx, op.Path, op.Dir, op.Recursive)
+				if err == nil {
+					s.queueStats(DeleteSuccess)
+				}
+			}
+			if err != nil {
+				return err
+			}
+			resp.Events = append(resp.Events, e)
+		}
+		resp.EtcdIndex = s.CurrentIndex
+		return nil
+	})
+
+	if err != nil {
+		s.Stats.Inc(TxnFail)
+		return nil, err
+	}
+
+	s.Stats.Inc(TxnSuccess)
+	return resp, nil
+}
+
+// DeleteExpiredKeys deletes the keys that expire at or before cutoff, in
+// the order of their expire time, up to the expire budget.
+func (s *boltStore) DeleteExpiredKeys(cutoff time.Time) {
+	s.worldLock.Lock()
+	defer s.worldLock.Unlock()
+
+	s.update(func(tx *bolt.Tx) error {
+		for deleted := 0; deleted < s.expireBudget; deleted++ {
+			k, _ := tx.Bucket(ttlBucketName).Cursor().First()
+			if k == nil {
+				return nil
+			}
+			nodePath := boltPath(k[8:])
+			bn := getBoltNode(tx, nodePath)
+			if bn.ExpireTime.After(cutoff) {
+				return nil
+			}
+
+			s.CurrentIndex++
+			e := newEvent(Expire, nodePath, s.CurrentIndex, bn.CreatedIndex)
+			e.EtcdIndex = s.CurrentIndex
+			e.PrevNode = bn.node(nodePath, nil).Repr(false, false, s.clock)
+
+			if err := s.remove(tx, nodePath, bn, true, true, e); err != nil {
+				return err
+			}
+
+			s.queueStats(ExpireCount)
+			s.queueNotify(e)
+		}
+		return nil
+	})
+}
+
+// Save saves the static state of the store system in the same formats
+// as the v2 store, so the state can be recovered by either of them.
+func (s *boltStore) Save() ([]byte, error) {
+	return s.Clone().SaveNoCopy()
+}
+
+// SaveNoCopy saves the state of the store. When it is called on a clone,
+// it releases the read transaction of the clone, and the clone cannot be
+// used afterwards.
+func (s *boltStore) SaveNoCopy() ([]byte, error) {
+	var b []byte
+	err := s.view(func(tx *bolt.Tx) error {
+		if s.snapshotFormat == SnapshotFormatGob {
+			var err error
+			b, err = s.encodeGobState(tx)
+			return err
+		}
+		var buf bytes.Buffer
+		if err := s.writeState(&buf, tx); err != nil {
+			return err
+		}
+		b = buf.Bytes()
+		return nil
+	})
+	if s.snapshot != nil {
+		s.snapshot.Rollback()
+		s.released = true
+	}
+	if err != nil {
+		return nil, err
+	}
+	return b, nil
+}
+
+// encodeGobState encodes the state in the gob format. The nodes are read
+// in the order of their keys, in which a directory sorts before the
+// nodes under it.
+func (s *boltStore) encodeGobState(tx *bolt.Tx) ([]byte, error) {
+	h, err := newGobHistory(s.WatcherHub.EventHistory)
+	if err != nil {
+		return nil, err
+	}
+	rrs, err := json.Marshal(s.RequestResults)
+	if err != nil {
+		return nil, err
+	}
+	st := &gobState{
+		CurrentIndex:   s.CurrentIndex,
+		CurrentVersion: s.CurrentVersion,
+		RaftIndex:      s.RaftIndex,
+		Nodes:          []gobNode{{Path: "/", Dir: true}},
+		History:        h,
+		Stats:          s.Stats,
+		RequestResults: rrs,
+	}
+	c := tx.Bucket(nodesBucketName).Cursor()
+	for k, v := c.First(); k != nil; k, v = c.Next() {
+		bn := &boltNode{}
+		if err := json.Unmarshal(v, bn); err != nil {
+			return nil, err
+		}
+		st.Nodes = append(st.Nodes, gobNode{
+			Path:          boltPath(k),
+			Dir:           bn.Dir,
+			Value:         bn.Value,
+			CreatedIndex:  bn.CreatedIndex,
+			ModifiedIndex: bn.ModifiedIndex,
+			ExpireTime:    bn.ExpireTime,
+		})
+	}
+	return encodeGobState(st)
+}
+
+// boltNodeJSON has the fields of a node in the format of the v2 store,
+// except the children.
+type boltNodeJSON struct {
+	Path          string
+	CreatedIndex  uint64
+	ModifiedIndex uint64
+	ExpireTime    time.Time
+	Value         string
+}
+
+// writeState writes the state in the JSON format of the v2 store. The
+// nodes are streamed from the bolt transaction in depth-first order
+// instead of being loaded into memory first.
+func (s *boltStore) writeState(buf *bytes.Buffer, tx *bolt.Tx) error {
+	writeNode := func(nodePath string, bn *boltNode) error {
+		b, err := json.Marshal(boltNodeJSON{
+			Path:          nodePath,
+			CreatedIndex:  bn.CreatedIndex,
+			ModifiedIndex: bn.ModifiedIndex,
+			ExpireTime:    bn.ExpireTime,
+			Value:         bn.Value,
+		})
+		if err != nil {
+			return err
+		}
+		buf.Write(b[:len(b)-1])
+		if bn.Dir {
+			buf.WriteString(`,"Children":{`)
+		} else {
+			buf.WriteString(`,"Children":null}`)
+		}
+		return nil
+	}
+
+	buf.WriteString(`{"Root":`)
+	if err := writeNode("/", &boltNode{Dir: true}); err != nil {
+		return err
+	}
+	// the open directories and whether a child has been written in them
+	dirs := []string{"/"}
+	written := []bool{false}
+	c := tx.Bucket(nodesBucketName).Cursor()
+	for k, v := c.First(); k != nil; k, v = c.Next() {
+		nodePath := boltPath(k)
+		for path.Dir(nodePath) != dirs[len(dirs)-1] {
+			buf.WriteString("}}")
+			dirs, written = dirs[:len(dirs)-1], written[:len(written)-1]
+		}
+		if written[len(written)-1] {
+			buf.WriteByte(',')
+		}
+		written[len(written)-1] = true
+
+		bn := &boltNode{}
+		if err := json.Unmarshal(v, bn); err != nil {
+			return err
+		}
+		name, err := json.Marshal(path.Base(nodePath))
+		if err != nil {
+			return err
+		}
+		buf.Write(name)
+		buf.WriteByte(':')
+		if err := writeNode(nodePath, bn); err != nil {
+			return err
+		}
+		if bn.Dir {
+			dirs, written = append(dirs, nodePath), append(written, false)
+		}
+	}
+	for range dirs {
+		buf.WriteString("}}")
+	}
+
+	for _, f := range []struct {
+		name string
+		v    interface{}
+	}{
+		{"WatcherHub", s.WatcherHub},
+		{"CurrentIndex", s.CurrentIndex},
+		{"Stats", s.Stats},
+		{"CurrentVersion", s.CurrentVersion},
+		{"RaftIndex", s.RaftIndex},
+		{"RequestResults", s.RequestResults},
+	} {
+		b, err := json.Marshal(f.v)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(buf, ",%q:", f.name)
+		buf.Write(b)
+	}
+	buf.WriteByte('}')
+	return nil
+}
+
+// Clone returns a read only view of the store at this point. It holds a
+// bolt read transaction until SaveNoCopy is called on it.
+func (s *boltStore) Clone() Store {
+	s.worldLock.Lock()
+	defer s.worldLock.Unlock()
+
+	tx, err := s.db.Begin(false)
+	if err != nil {
+		panic(fmt.Sprintf("store: cannot begin bolt read transaction: %v", err))
+	}
+	return &boltStore{
+		db:             s.db,
+		snapshot:       tx,
+		CurrentIndex:   s.CurrentIndex,
+		CurrentVersion: s.CurrentVersion,
+		RaftIndex:      s.RaftIndex,
+		RequestResults: s.RequestResults.clone(),
+		WatcherHub:     s.WatcherHub.clone(),
+		Stats:          s.Stats.clone(),
+		clock:          s.clock,
+		readonlySet:    s.readonlySet,
+		snapshotFormat: s.snapshotFormat,
+	}
+}
+
+func (s *boltStore) SetSnapshotFormat(format string) {
+	s.worldLock.Lock()
+	defer s.worldLock.Unlock()
+	s.snapshotFormat = format
+}
+
+func (s *boltStore) ConsistentIndex() uint64 {
+	s.worldLock.RLock()
+	defer s.worldLock.RUnlock()
+	return s.RaftIndex
+}
+
+func (s *boltStore) SetConsistentIndex(index uint64) {
+	s.worldLock.Lock()
+	defer s.worldLock.Unlock()
+	s.RaftIndex = index
+}
+
+func (s *boltStore) RequestResult(token string) (*RequestResult, bool) {
+	s.worldLock.RLock()
+	defer s.worldLock.RUnlock()
+	r, ok := s.RequestResults.get(token)
+	if !ok {
+		return nil, false
+	}
+	return r.clone(), true
+}
+
+func (s *boltStore) RecordRequestResult(r *RequestResult) {
+	s.worldLock.Lock()
+	defer s.worldLock.Unlock()
+	s.RequestResults.add(r.clone())
+}
+
+// Recovery recovers the store system from a static state saved by
+// either the v2 store or the bolt store.
+func (s *boltStore) Recovery(state []byte) error {
+	s.worldLock.Lock()
+	defer s.worldLock.Unlock()
+
+	if s.snapshot != nil {
+		return errBoltReadOnly
+	}
+
+	st := newStore()
+	if _, err := st.decodeState(state, st); err != nil {
+		return err
+	}
+
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		if err := resetBoltBuckets(tx); err != nil {
+			return err
+		}
+		var put func(n *node) error
+		put = func(n *node) error {
+			for _, child := range n.Children {
+				bn := &boltNode{
+					Dir:           child.IsDir(),
+					Value:         child.Value,
+					CreatedIndex:  child.CreatedIndex,
+					ModifiedIndex: child.ModifiedIndex,
+					ExpireTime:    child.ExpireTime,
+				}
+				if err := putBoltNode(tx, child.Path, bn); err != nil {
+					return err
+				}
+				if err := put(child); err != nil {
+					return err
+				}
+			}
+			return nil
+		}
+		return put(st.Root)
+	})
+	if err != nil {
+		return err
+	}
+
+	s.CurrentIndex = st.CurrentIndex
+	s.CurrentVersion = st.CurrentVersion
+	s.RaftIndex = st.RaftIndex
+	s.RequestResults = st.RequestResults
+	historySize := s.WatcherHub.EventHistory.Queue.Capacity
+	s.WatcherHub.EventHistory = st.WatcherHub.EventHistory
+	s.WatcherHub.EventHistory.resize(historySize)
+	s.Stats = st.Stats
+	return nil
+}
+
+func (s *boltStore) JsonStats() []byte {
+	s.Stats.Watchers = uint64(s.WatcherHub.count)
+	s.Stats.setMemoryUsage(s.MemoryUsage())
+	return s.Stats.toJson()
+}
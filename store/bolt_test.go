@@ -0,0 +1,365 @@
+// Copyright 2015 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package store
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+	"time"
+
+	"github.com/coreos/etcd/Godeps/_workspace/src/github.com/jonboulle/clockwork"
+	"github.com/coreos/etcd/Godeps/_workspace/src/github.com/stretchr/testify/assert"
+	etcdErr "github.com/coreos/etcd/error"
+)
+
+func newTestBoltStore(t *testing.T, namespaces ...string) (*boltStore, func()) {
+	dir, err := ioutil.TempDir(os.TempDir(), "boltstore")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, err := newBoltStore(path.Join(dir, "db"), namespaces...)
+	if err != nil {
+		os.RemoveAll(dir)
+		t.Fatal(err)
+	}
+	s.clock = newFakeClock()
+	return s, func() {
+		s.Close()
+		os.RemoveAll(dir)
+	}
+}
+
+// Ensure that the bolt store behaves like the v2 store on basic operations.
+func TestBoltStoreBasic(t *testing.T) {
+	s, cleanup := newTestBoltStore(t)
+	defer cleanup()
+
+	e, err := s.Create("/foo/bar", false, "baz", false, Permanent)
+	assert.Nil(t, err, "")
+	assert.Equal(t, e.Action, "create", "")
+	assert.Equal(t, e.Node.ModifiedIndex, uint64(1), "")
+
+	_, err = s.Create("/foo/bar", false, "baz", false, Permanent)
+	assert.Equal(t, err.(*etcdErr.Error).ErrorCode, etcdErr.EcodeNodeExist, "")
+	_, err = s.Create("/foo/bar/baz", false, "X", false, Permanent)
+	assert.Equal(t, err.(*etcdErr.Error).ErrorCode, etcdErr.EcodeNotDir, "")
+
+	e, err = s.Set("/foo/bar", false, "qux", Permanent)
+	assert.Nil(t, err, "")
+	assert.Equal(t, *e.PrevNode.Value, "baz", "")
+	assert.Equal(t, e.Node.CreatedIndex, uint64(2), "")
+
+	e, err = s.Update("/foo/bar", "quux", Permanent)
+	assert.Nil(t, err, "")
+	assert.Equal(t, *e.PrevNode.Value, "qux", "")
+
+	_, err = s.CompareAndSwap("/foo/bar", "qux", 0, "X", Permanent)
+	assert.Equal(t, err.(*etcdErr.Error).ErrorCode, etcdErr.EcodeTestFailed, "")
+	e, err = s.CompareAndSwap("/foo/bar", "quux", 3, "X", Permanent)
+	assert.Nil(t, err, "")
+	assert.Equal(t, e.Node.ModifiedIndex, uint64(4), "")
+
+	s.Create("/foo/_hidden", false, "*", false, Permanent)
+	s.Create("/foo/baz/bat", false, "Y", false, Permanent)
+	e, err = s.Get("/foo", false, true)
+	assert.Nil(t, err, "")
+	assert.Equal(t, e.EtcdIndex, uint64(6), "")
+	assert.Equal(t, len(e.Node.Nodes), 2, "")
+	assert.Equal(t, *e.Node.Nodes[0].Value, "X", "")
+	assert.Equal(t, e.Node.Nodes[1].Key, "/foo/baz", "")
+	assert.Nil(t, e.Node.Nodes[1].Nodes, "")
+
+	e, err = s.Get("/foo", true, true)
+	assert.Nil(t, err, "")
+	assert.Equal(t, len(e.Node.Nodes[1].Nodes), 1, "")
+
+	e, err = s.CompareAndDelete("/foo/bar", "X", 0)
+	assert.Nil(t, err, "")
+	_, err = s.Get("/foo/bar", false, false)
+	assert.Equal(t, err.(*etcdErr.Error).ErrorCode, etcdErr.EcodeKeyNotFound, "")
+
+	u, err := s.Create("/queue", true, "", true, Permanent)
+	assert.Nil(t, err, "")
+	assert.Equal(t, u.Node.Key, "/queue/8", "")
+}
+
+// Ensure that the bolt store deletes all the nodes under a directory and
+// notifies their watchers.
+func TestBoltStoreGetPage(t *testing.T) {
+	s, cleanup := newTestBoltStore(t)
+	defer cleanup()
+	testStoreGetPage(t, s)
+}
+
+func TestBoltStoreIncr(t *testing.T) {
+	s, cleanup := newTestBoltStore(t)
+	defer cleanup()
+	testStoreIncr(t, s)
+}
+
+func TestBoltStoreUsage(t *testing.T) {
+	s, cleanup := newTestBoltStore(t)
+	defer cleanup()
+	testStoreUsage(t, s)
+}
+
+func TestBoltStoreMove(t *testing.T) {
+	s, cleanup := newTestBoltStore(t)
+	defer cleanup()
+	testStoreMove(t, s, s.clock.(clockwork.FakeClock))
+}
+
+func TestBoltStoreExportImport(t *testing.T) {
+	s, cleanup := newTestBoltStore(t)
+	defer cleanup()
+	testStoreExportImport(t, s, s.clock.(clockwork.FakeClock))
+}
+
+func TestBoltStoreRefresh(t *testing.T) {
+	s, cleanup := newTestBoltStore(t)
+	defer cleanup()
+	testStoreRefresh(t, s, s.clock.(clockwork.FakeClock))
+}
+
+func TestBoltStoreDeleteRecursive(t *testing.T) {
+	s, cleanup := newTestBoltStore(t)
+	defer cleanup()
+
+	s.Create("/foo/bar/baz", false, "X", false, Permanent)
+	s.Create("/foo!", false, "Y", false, Permanent)
+	w, _ := s.Watch("/foo/bar/baz", false, false, 0, nil)
+
+	_, err := s.Delete("/foo", false, false)
+	assert.Equal(t, err.(*etcdErr.Error).ErrorCode, etcdErr.EcodeNotFile, "")
+	_, err = s.Delete("/foo", true, false)
+	assert.Equal(t, err.(*etcdErr.Error).ErrorCode, etcdErr.EcodeDirNotEmpty, "")
+
+	e, err := s.Delete("/foo", false, true)
+	assert.Nil(t, err, "")
+	assert.True(t, e.Node.Dir, "")
+	e = nbselect(w.EventChan())
+	assert.Equal(t, e.Action, "delete", "")
+	assert.Equal(t, e.Node.Key, "/foo", "")
+
+	_, err = s.Get("/foo/bar/baz", false, false)
+	assert.Equal(t, err.(*etcdErr.Error).ErrorCode, etcdErr.EcodeKeyNotFound, "")
+	e, err = s.Get("/foo!", false, false)
+	assert.Nil(t, err, "")
+	assert.Equal(t, *e.Node.Value, "Y", "")
+}
+
+func TestBoltStoreExpireBudget(t *testing.T) {
+	s, cleanup := newTestBoltStore(t)
+	defer cleanup()
+	s.expireBudget = 2
+	testStoreExpireBudget(t, s, s.clock.(clockwork.FakeClock))
+}
+
+func TestBoltStoreCompareAndDeleteDir(t *testing.T) {
+	s, cleanup := newTestBoltStore(t)
+	defer cleanup()
+	testStoreCompareAndDeleteDir(t, s)
+}
+
+func TestBoltStoreDirTTLInheritance(t *testing.T) {
+	s, cleanup := newTestBoltStore(t)
+	defer cleanup()
+	testStoreDirTTLInheritance(t, s, s.clock.(clockwork.FakeClock))
+}
+
+func TestBoltStoreConcurrentReads(t *testing.T) {
+	s, cleanup := newTestBoltStore(t)
+	defer cleanup()
+	testStoreConcurrentReads(t, s)
+}
+
+// Ensure that the bolt store deletes the expired keys.
+func TestBoltStoreDeleteExpiredKeys(t *testing.T) {
+	s, cleanup := newTestBoltStore(t)
+	defer cleanup()
+
+	fc := s.clock.(clockwork.FakeClock)
+	s.Create("/foo", false, "bar", false, fc.Now().Add(500*time.Millisecond))
+	s.Create("/dir/baz", false, "X", false, Permanent)
+	s.Update("/dir", "", fc.Now().Add(time.Second))
+	w, _ := s.Watch("/dir/baz", false, false, 0, nil)
+
+	s.DeleteExpiredKeys(fc.Now().Add(600 * time.Millisecond))
+	_, err := s.Get("/foo", false, false)
+	assert.Equal(t, err.(*etcdErr.Error).ErrorCode, etcdErr.EcodeKeyNotFound, "")
+	_, err = s.Get("/dir/baz", false, false)
+	assert.Nil(t, err, "")
+
+	s.DeleteExpiredKeys(fc.Now().Add(2 * time.Second))
+	_, err = s.Get("/dir/baz", false, false)
+	assert.Equal(t, err.(*etcdErr.Error).ErrorCode, etcdErr.EcodeKeyNotFound, "")
+	e := nbselect(w.EventChan())
+	assert.Equal(t, e.Action, "expire", "")
+	assert.Equal(t, e.Node.Key, "/dir", "")
+	assert.Equal(t, s.Stats.ExpireCount, uint64(2), "")
+}
+
+// Ensure that the bolt store rolls back a transaction if any of its
+// operations fails.
+func TestBoltStoreTxnRollback(t *testing.T) {
+	s, cleanup := newTestBoltStore(t)
+	defer cleanup()
+
+	s.Create("/foo", false, "bar", false, Permanent)
+	s.Create("/dir/x", false, "X", false, Permanent)
+
+	cmps := []TxnCompare{{Path: "/foo", Target: TxnCompareValue, Value: "bar"}}
+	ops := []TxnOp{
+		{Action: Set, Path: "/foo", Value: "baz"},
+		{Action: Delete, Path: "/dir"},
+	}
+	_, err := s.Txn(cmps, ops, nil)
+	assert.Equal(t, err.(*etcdErr.Error).ErrorCode, etcdErr.EcodeNotFile, "")
+	e, _ := s.Get("/foo", false, false)
+	assert.Equal(t, *e.Node.Value, "bar", "")
+	assert.Equal(t, s.Index(), uint64(2), "")
+
+	ops[1].Recursive = true
+	resp, err := s.Txn(cmps, ops, nil)
+	assert.Nil(t, err, "")
+	assert.True(t, resp.Succeeded, "")
+	assert.Equal(t, len(resp.Events), 2, "")
+	assert.Equal(t, resp.EtcdIndex, uint64(4), "")
+	e, _ = s.Get("/foo", false, false)
+	assert.Equal(t, *e.Node.Value, "baz", "")
+}
+
+func TestBoltStoreConsistentIndex(t *testing.T) {
+	s, cleanup := newTestBoltStore(t)
+	defer cleanup()
+	s2, cleanup2 := newTestBoltStore(t)
+	defer cleanup2()
+	testStoreConsistentIndex(t, s, s2)
+}
+
+func TestBoltStoreRequestResults(t *testing.T) {
+	s, cleanup := newTestBoltStore(t)
+	defer cleanup()
+	s2, cleanup2 := newTestBoltStore(t)
+	defer cleanup2()
+	testStoreRequestResults(t, s, s2)
+}
+
+// Ensure that the state saved in gob by the bolt store is the one of
+// the v2 store, and can be recovered by the bolt store.
+func TestBoltStoreGobSaveAndRecovery(t *testing.T) {
+	s, cleanup := newTestBoltStore(t, "/0", "/1")
+	defer cleanup()
+
+	s.Create("/foo/bar", false, "baz", false, Permanent)
+	s.Create("/foo/dir", true, "", false, Permanent)
+	s.Create("/foo/ttl", false, "t", false, s.clock.Now().Add(time.Hour))
+	s.Create("/foo!", false, "z", false, Permanent)
+	jb, err := s.Save()
+	assert.Nil(t, err, "")
+	s.SetSnapshotFormat(SnapshotFormatGob)
+	gb, err := s.Save()
+	assert.Nil(t, err, "")
+	assert.True(t, isGobState(gb), "")
+
+	v2 := newStore()
+	assert.Nil(t, v2.Recovery(gb), "")
+	b, err := v2.Save()
+	assert.Nil(t, err, "")
+	assert.Equal(t, string(b), string(jb), "")
+
+	s2, cleanup2 := newTestBoltStore(t)
+	defer cleanup2()
+	assert.Nil(t, s2.Recovery(gb), "")
+	b, err = s2.Save()
+	assert.Nil(t, err, "")
+	assert.Equal(t, string(b), string(jb), "")
+}
+
+// Ensure that the state saved by the bolt store can be recovered by
+// the v2 store and the other way around.
+func TestBoltStoreSaveAndRecovery(t *testing.T) {
+	s, cleanup := newTestBoltStore(t, "/0", "/1")
+	defer cleanup()
+
+	s.Create("/foo/bar", false, "baz", false, Permanent)
+	s.Create("/foo/x\"y", false, "q", false, Permanent)
+	s.Create("/foo/dir", true, "", false, Permanent)
+	s.Create("/foo/ttl", false, "t", false, s.clock.Now().Add(time.Hour))
+	s.Create("/zoo", false, "z", false, Permanent)
+	b, err := s.Save()
+	assert.Nil(t, err, "")
+
+	v2 := newStore()
+	v2.clock = s.clock
+	assert.Nil(t, v2.Recovery(b), "")
+	assert.Equal(t, v2.CurrentIndex, uint64(5), "")
+	e, err := v2.Get("/foo", true, true)
+	assert.Nil(t, err, "")
+	assert.Equal(t, len(e.Node.Nodes), 4, "")
+	e, err = v2.Get("/foo/x\"y", false, false)
+	assert.Nil(t, err, "")
+	assert.Equal(t, *e.Node.Value, "q", "")
+	_, err = v2.Get("/1", false, false)
+	assert.Nil(t, err, "")
+
+	v2.Set("/foo/bar", false, "qux", Permanent)
+	b, err = v2.Save()
+	assert.Nil(t, err, "")
+
+	s2, cleanup2 := newTestBoltStore(t)
+	defer cleanup2()
+	assert.Nil(t, s2.Recovery(b), "")
+	assert.Equal(t, s2.Index(), uint64(6), "")
+	e, err = s2.Get("/foo/bar", false, false)
+	assert.Nil(t, err, "")
+	assert.Equal(t, *e.Node.Value, "qux", "")
+	e, err = s2.Get("/zoo", false, false)
+	assert.Nil(t, err, "")
+	assert.Equal(t, *e.Node.Value, "z", "")
+
+	s2.DeleteExpiredKeys(s.clock.Now().Add(2 * time.Hour))
+	_, err = s2.Get("/foo/ttl", false, false)
+	assert.Equal(t, err.(*etcdErr.Error).ErrorCode, etcdErr.EcodeKeyNotFound, "")
+}
+
+// Ensure that the clone of the bolt store is a read only view of the
+// store at the time it is taken.
+func TestBoltStoreClone(t *testing.T) {
+	s, cleanup := newTestBoltStore(t)
+	defer cleanup()
+
+	s.Create("/foo", false, "bar", false, Permanent)
+	c := s.Clone()
+	s.Set("/foo", false, "baz", Permanent)
+
+	e, err := c.Get("/foo", false, false)
+	assert.Nil(t, err, "")
+	assert.Equal(t, *e.Node.Value, "bar", "")
+	_, err = c.Set("/foo", false, "qux", Permanent)
+	assert.Equal(t, err, errBoltReadOnly, "")
+
+	b, err := c.SaveNoCopy()
+	assert.Nil(t, err, "")
+	_, err = c.Get("/foo", false, false)
+	assert.Equal(t, err, errBoltReleased, "")
+
+	v2 := newStore()
+	assert.Nil(t, v2.Recovery(b), "")
+	e, _ = v2.Get("/foo", false, false)
+	assert.Equal(t, *e.Node.Value, "bar", "")
+}
@@ -14,6 +14,11 @@
 
 package store
 
+import (
+	"path"
+	"strings"
+)
+
 const (
 	Get              = "get"
 	Create           = "create"
@@ -23,6 +28,13 @@ const (
 	CompareAndSwap   = "compareAndSwap"
 	CompareAndDelete = "compareAndDelete"
 	Expire           = "expire"
+	Incr             = "incr"
+	Move             = "move"
+	Import           = "import"
+	// Overflow is the last event of a watcher that cannot keep up with
+	// the events. The watcher is removed, and a new watch should re-sync
+	// from the ResyncIndex of the event.
+	Overflow = "overflow"
 )
 
 type Event struct {
@@ -30,6 +42,12 @@ type Event struct {
 	Node      *NodeExtern `json:"node,omitempty"`
 	PrevNode  *NodeExtern `json:"prevNode,omitempty"`
 	EtcdIndex uint64      `json:"-"`
+	// Continue is the key a paginated get continues from, if more
+	// nodes are left.
+	Continue string `json:"continue,omitempty"`
+	// ResyncIndex is the index of the first event an overflowed watcher
+	// missed.
+	ResyncIndex uint64 `json:"resyncIndex,omitempty"`
 }
 
 func newEvent(action string, key string, modifiedIndex, createdIndex uint64) *Event {
@@ -50,13 +68,54 @@ func (e *Event) IsCreated() bool {
 		return true
 	}
 
-	if e.Action == Set && e.PrevNode == nil {
+	if (e.Action == Set || e.Action == Incr) && e.PrevNode == nil {
 		return true
 	}
 
 	return false
 }
 
+// eventBytes returns the approximate size of the event in memory: the
+// size of its action and of the keys and values of its nodes.
+func eventBytes(e *Event) int64 {
+	n := int64(len(e.Action))
+	for _, ne := range []*NodeExtern{e.Node, e.PrevNode} {
+		if ne == nil {
+			continue
+		}
+		n += int64(len(ne.Key))
+		if ne.Value != nil {
+			n += int64(len(*ne.Value))
+		}
+	}
+	return n
+}
+
+// affects returns true if the event changes the key, or a node under
+// the key if recursive. A move changes both its old and new keys.
+func (e *Event) affects(key string, recursive bool) bool {
+	if keyMatches(e.Node.Key, key, recursive) {
+		return true
+	}
+	return e.Action == Move && keyMatches(e.PrevNode.Key, key, recursive)
+}
+
+// keyMatches returns true if k is the key, or is under the key if recursive.
+func keyMatches(k, key string, recursive bool) bool {
+	if k == key {
+		return true
+	}
+	if !recursive {
+		return false
+	}
+	// add tailing slash
+	key = path.Clean(key)
+	if key[len(key)-1] != '/' {
+		key = key + "/"
+	}
+	return strings.HasPrefix(k, key)
+}
+
 func (e *Event) Index() uint64 {
 	return e.Node.ModifiedIndex
 }
@@ -67,5 +126,8 @@ func (e *Event) Clone() *Event {
 		EtcdIndex: e.EtcdIndex,
 		Node:      e.Node.Clone(),
 		PrevNode:  e.PrevNode.Clone(),
+		Continue:  e.Continue,
+
+		ResyncIndex: e.ResyncIndex,
 	}
 }
@@ -15,9 +15,6 @@
 package store
 
 import (
-	"fmt"
-	"path"
-	"strings"
 	"sync"
 
 	etcdErr "github.com/coreos/etcd/error"
@@ -28,6 +25,9 @@ type EventHistory struct {
 	StartIndex uint64
 	LastIndex  uint64
 	rwl        sync.RWMutex
+	// bytes is the total size of the events in the queue, as given by
+	// eventBytes.
+	bytes int64
 }
 
 func newEventHistory(capacity int) *EventHistory {
@@ -44,7 +44,11 @@ func (eh *EventHistory) addEvent(e *Event) *Event {
 	eh.rwl.Lock()
 	defer eh.rwl.Unlock()
 
+	if eh.Queue.Size == eh.Queue.Capacity {
+		eh.bytes -= eventBytes(eh.Queue.Events[eh.Queue.Back])
+	}
 	eh.Queue.insert(e)
+	eh.bytes += eventBytes(e)
 
 	eh.LastIndex = e.Index()
 
@@ -55,16 +59,13 @@ func (eh *EventHistory) addEvent(e *Event) *Event {
 
 // scan enumerates events from the index history and stops at the first point
 // where the key matches.
-func (eh *EventHistory) scan(key string, recursive bool, index uint64) (*Event, *etcdErr.Error) {
+func (eh *EventHistory) scan(key string, recursive bool, index uint64, actions []string) (*Event, *etcdErr.Error) {
 	eh.rwl.RLock()
 	defer eh.rwl.RUnlock()
 
 	// index should be after the event history's StartIndex
 	if index < eh.StartIndex {
-		return nil,
-			etcdErr.NewError(etcdErr.EcodeEventIndexCleared,
-				fmt.Sprintf("the requested history has been cleared [%v/%v]",
-					eh.StartIndex, index), 0)
+		return nil, etcdErr.NewCompactedError(eh.StartIndex-1, index, 0)
 	}
 
 	// the index should come before the size of the queue minus the duplicate count
@@ -78,19 +79,7 @@ func (eh *EventHistory) scan(key string, recursive bool, index uint64) (*Event,
 	for {
 		e := eh.Queue.Events[i]
 
-		ok := (e.Node.Key == key)
-
-		if recursive {
-			// add tailing slash
-			key := path.Clean(key)
-			if key[len(key)-1] != '/' {
-				key = key + "/"
-			}
-
-			ok = ok || strings.HasPrefix(e.Node.Key, key)
-		}
-
-		if ok {
+		if e.affects(key, recursive) && matchAction(actions, e.Action) {
 			return e, nil
 		}
 
@@ -102,6 +91,78 @@ func (eh *EventHistory) scan(key string, recursive bool, index uint64) (*Event,
 	}
 }
 
+// oldestIndex returns the smallest index a watcher can start from.
+func (eh *EventHistory) oldestIndex() uint64 {
+	eh.rwl.RLock()
+	defer eh.rwl.RUnlock()
+
+	if eh.StartIndex == 0 { // no event has happened yet
+		return 1
+	}
+	return eh.StartIndex
+}
+
+// historyBytes returns the approximate size of the events kept by the
+// history.
+func (eh *EventHistory) historyBytes() int64 {
+	eh.rwl.RLock()
+	defer eh.rwl.RUnlock()
+	return eh.bytes
+}
+
+// resize changes the capacity of the history. If the history holds more
+// events than the new capacity, only the latest ones are kept.
+func (eh *EventHistory) resize(capacity int) {
+	eh.rwl.Lock()
+	defer eh.rwl.Unlock()
+
+	if capacity == eh.Queue.Capacity {
+		// the queue may have been decoded by a recovery
+		eh.bytes = eh.Queue.bytes()
+		return
+	}
+
+	q := eventQueue{
+		Capacity: capacity,
+		Events:   make([]*Event, capacity),
+	}
+	skip := 0
+	if eh.Queue.Size > capacity {
+		skip = eh.Queue.Size - capacity
+	}
+	for i := skip; i < eh.Queue.Size; i++ {
+		q.insert(eh.Queue.Events[(eh.Queue.Front+i)%eh.Queue.Capacity])
+	}
+	eh.Queue = q
+	eh.bytes = q.bytes()
+
+	if q.Size != 0 {
+		eh.StartIndex = q.Events[q.Front].Index()
+	}
+}
+
+// compact drops the events at or before index from the history.
+func (eh *EventHistory) compact(index uint64) {
+	eh.rwl.Lock()
+	defer eh.rwl.Unlock()
+
+	q := &eh.Queue
+	for q.Size > 0 && q.Events[q.Front].Index() <= index {
+		eh.bytes -= eventBytes(q.Events[q.Front])
+		q.Events[q.Front] = nil
+		q.Front = (q.Front + 1) % q.Capacity
+		q.Size--
+	}
+
+	switch {
+	case q.Size != 0:
+		eh.StartIndex = q.Events[q.Front].Index()
+	case eh.LastIndex != 0:
+		// no event left; only the future ones can be watched
+		eh.StartIndex = eh.LastIndex + 1
+	}
+}
+
 // clone will be protected by a stop-world lock
 // do not need to obtain internal lock
 func (eh *EventHistory) clone() *EventHistory {
@@ -121,6 +182,7 @@ func (eh *EventHistory) clone() *EventHistory {
 		StartIndex: eh.StartIndex,
 		Queue:      clonedQueue,
 		LastIndex:  eh.LastIndex,
+		bytes:      eh.bytes,
 	}
 
 }
@@ -32,3 +32,12 @@ func (eq *eventQueue) insert(e *Event) {
 		eq.Size++
 	}
 }
+
+// bytes returns the total size of the events in the queue.
+func (eq *eventQueue) bytes() int64 {
+	var n int64
+	for i := 0; i < eq.Size; i++ {
+		n += eventBytes(eq.Events[(eq.Front+i)%eq.Capacity])
+	}
+	return n
+}
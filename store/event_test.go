@@ -16,6 +16,8 @@ package store
 
 import (
 	"testing"
+
+	etcdErr "github.com/coreos/etcd/error"
 )
 
 // TestEventQueue tests a queue with capacity = 100
@@ -55,24 +57,24 @@ func TestScanHistory(t *testing.T) {
 	eh.addEvent(newEvent(Create, "/foo/bar/bar", 4, 4))
 	eh.addEvent(newEvent(Create, "/foo/foo/foo", 5, 5))
 
-	e, err := eh.scan("/foo", false, 1)
+	e, err := eh.scan("/foo", false, 1, nil)
 	if err != nil || e.Index() != 1 {
 		t.Fatalf("scan error [/foo] [1] %v", e.Index)
 	}
 
-	e, err = eh.scan("/foo/bar", false, 1)
+	e, err = eh.scan("/foo/bar", false, 1, nil)
 
 	if err != nil || e.Index() != 2 {
 		t.Fatalf("scan error [/foo/bar] [2] %v", e.Index)
 	}
 
-	e, err = eh.scan("/foo/bar", true, 3)
+	e, err = eh.scan("/foo/bar", true, 3, nil)
 
 	if err != nil || e.Index() != 4 {
 		t.Fatalf("scan error [/foo/bar/bar] [4] %v", e.Index)
 	}
 
-	e, err = eh.scan("/foo/bar", true, 6)
+	e, err = eh.scan("/foo/bar", true, 6, nil)
 
 	if e != nil {
 		t.Fatalf("bad index shoud reuturn nil")
@@ -90,7 +92,7 @@ func TestFullEventQueue(t *testing.T) {
 	for i := 0; i < 1000; i++ {
 		ce := newEvent(Create, "/foo", uint64(i), uint64(i))
 		eh.addEvent(ce)
-		e, err := eh.scan("/foo", true, uint64(i-1))
+		e, err := eh.scan("/foo", true, uint64(i-1), nil)
 		if i > 0 {
 			if e == nil || err != nil {
 				t.Fatalf("scan error [/foo] [%v] %v", i-1, i)
@@ -99,6 +101,105 @@ func TestFullEventQueue(t *testing.T) {
 	}
 }
 
+// TestScanCompactedHistory tests that scanning from an index before the
+// history reports the index the history has been compacted at.
+func TestScanCompactedHistory(t *testing.T) {
+	eh := newEventHistory(10)
+	if eh.oldestIndex() != 1 {
+		t.Fatalf("oldestIndex = %d, want 1", eh.oldestIndex())
+	}
+
+	for i := 1; i <= 20; i++ {
+		eh.addEvent(newEvent(Create, "/foo", uint64(i), uint64(i)))
+	}
+	if eh.oldestIndex() != 11 {
+		t.Fatalf("oldestIndex = %d, want 11", eh.oldestIndex())
+	}
+
+	_, err := eh.scan("/foo", false, 5, nil)
+	if err == nil || err.ErrorCode != etcdErr.EcodeEventIndexCleared {
+		t.Fatalf("err = %v, want EcodeEventIndexCleared", err)
+	}
+	if err.CompactIndex != 10 {
+		t.Fatalf("CompactIndex = %d, want 10", err.CompactIndex)
+	}
+}
+
+// TestResizeEventHistory tests that resizing the history keeps the
+// latest events.
+func TestResizeEventHistory(t *testing.T) {
+	eh := newEventHistory(10)
+	for i := 1; i <= 15; i++ {
+		eh.addEvent(newEvent(Create, "/foo", uint64(i), uint64(i)))
+	}
+
+	eh.resize(20)
+	if eh.Queue.Size != 10 || eh.oldestIndex() != 6 {
+		t.Fatalf("size = %d, oldestIndex = %d, want 10, 6", eh.Queue.Size, eh.oldestIndex())
+	}
+	for i := 16; i <= 25; i++ {
+		eh.addEvent(newEvent(Create, "/foo", uint64(i), uint64(i)))
+	}
+	if eh.oldestIndex() != 6 {
+		t.Fatalf("oldestIndex = %d, want 6", eh.oldestIndex())
+	}
+
+	eh.resize(5)
+	if eh.Queue.Size != 5 || eh.oldestIndex() != 21 {
+		t.Fatalf("size = %d, oldestIndex = %d, want 5, 21", eh.Queue.Size, eh.oldestIndex())
+	}
+	e, err := eh.scan("/foo", false, 23, nil)
+	if err != nil || e.Index() != 23 {
+		t.Fatalf("scan error [/foo] [23] %v", err)
+	}
+}
+
+// TestCompactEventHistory tests that compacting the history drops the
+// events at or before the index, and that the events added afterwards are
+// kept.
+func TestCompactEventHistory(t *testing.T) {
+	eh := newEventHistory(10)
+	eh.compact(5)
+	if eh.oldestIndex() != 1 {
+		t.Fatalf("oldestIndex = %d, want 1", eh.oldestIndex())
+	}
+	for i := 1; i <= 8; i++ {
+		eh.addEvent(newEvent(Create, "/foo", uint64(i), uint64(i)))
+	}
+
+	eh.compact(5)
+	if eh.Queue.Size != 3 || eh.oldestIndex() != 6 {
+		t.Fatalf("size = %d, oldestIndex = %d, want 3, 6", eh.Queue.Size, eh.oldestIndex())
+	}
+	if eh.historyBytes() != eh.Queue.bytes() {
+		t.Errorf("historyBytes = %d, want %d", eh.historyBytes(), eh.Queue.bytes())
+	}
+	_, err := eh.scan("/foo", false, 5, nil)
+	if err == nil || err.ErrorCode != etcdErr.EcodeEventIndexCleared || err.CompactIndex != 5 {
+		t.Fatalf("err = %v, want EcodeEventIndexCleared at 5", err)
+	}
+	e, err := eh.scan("/foo", false, 7, nil)
+	if err != nil || e.Index() != 7 {
+		t.Fatalf("scan error [/foo] [7] %v", err)
+	}
+
+	// compacting all the events leaves only the future ones to watch
+	eh.compact(20)
+	if eh.Queue.Size != 0 || eh.oldestIndex() != 9 {
+		t.Fatalf("size = %d, oldestIndex = %d, want 0, 9", eh.Queue.Size, eh.oldestIndex())
+	}
+	for i := 9; i <= 20; i++ {
+		eh.addEvent(newEvent(Create, "/foo", uint64(i), uint64(i)))
+	}
+	if eh.Queue.Size != 10 || eh.oldestIndex() != 11 {
+		t.Fatalf("size = %d, oldestIndex = %d, want 10, 11", eh.Queue.Size, eh.oldestIndex())
+	}
+	e, err = eh.scan("/foo", false, 15, nil)
+	if err != nil || e.Index() != 15 {
+		t.Fatalf("scan error [/foo] [15] %v", err)
+	}
+}
+
 func TestCloneEvent(t *testing.T) {
 	e1 := &Event{
 		Action:    Create,
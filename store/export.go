@@ -0,0 +1,123 @@
+// Copyright 2015 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package store
+
+import (
+	"fmt"
+	"path"
+	"sort"
+	"time"
+
+	"github.com/coreos/etcd/Godeps/_workspace/src/github.com/jonboulle/clockwork"
+)
+
+// ExportVersion is the version of the format of the exports.
+const ExportVersion = 1
+
+// Export is a subtree of the store in a portable form. It holds all the
+// nodes of the subtree, the hidden ones included, with their values,
+// indexes and expire times. The keys are the ones at the export; an
+// import moves them under the path it imports at.
+// 导出的子树，包括隐藏节点，保留了节点的值、index和过期时间
+type Export struct {
+	Version int `json:"version"`
+	// Index is the etcd index the subtree is exported at.
+	Index uint64      `json:"index"`
+	Node  *NodeExtern `json:"node"`
+}
+
+// exportNode returns the external representation of the node and all the
+// nodes under it, sorted, including the hidden ones.
+func exportNode(n *node, clock clockwork.Clock) *NodeExtern {
+	en := n.Repr(false, false, clock)
+	if !n.IsDir() {
+		return en
+	}
+	for _, child := range n.Children {
+		en.Nodes = append(en.Nodes, exportNode(child, clock))
+	}
+	sort.Sort(en.Nodes)
+	return en
+}
+
+// Validate checks that the export is in the current version, and that its
+// nodes form a tree: a file has a value and no children, and the key of a
+// node is the key of a child of its parent.
+func (ex *Export) Validate() error {
+	if ex.Version != ExportVersion {
+		return fmt.Errorf("unsupported export version %d", ex.Version)
+	}
+	if ex.Node == nil {
+		return fmt.Errorf("no node is exported")
+	}
+	return validateExportNode(ex.Node)
+}
+
+func validateExportNode(en *NodeExtern) error {
+	if !en.Dir {
+		if en.Value == nil {
+			return fmt.Errorf("file %q has no value", en.Key)
+		}
+		if len(en.Nodes) != 0 {
+			return fmt.Errorf("file %q has children", en.Key)
+		}
+		return nil
+	}
+	if en.Value != nil {
+		return fmt.Errorf("directory %q has a value", en.Key)
+	}
+	names := make(map[string]bool, len(en.Nodes))
+	for _, child := range en.Nodes {
+		if child == nil {
+			return fmt.Errorf("directory %q has a null child", en.Key)
+		}
+		name := path.Base(child.Key)
+		if child.Key != path.Join(en.Key, name) || name == "/" {
+			return fmt.Errorf("invalid key %q under %q", child.Key, en.Key)
+		}
+		if names[name] {
+			return fmt.Errorf("duplicate key %q", child.Key)
+		}
+		names[name] = true
+		if err := validateExportNode(child); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// exportExpireTime returns the expire time of the exported node. The
+// expire times that are way in the past are taken as permanent, as the
+// ones given to a create.
+func exportExpireTime(en *NodeExtern) time.Time {
+	if en.Expiration == nil || en.Expiration.Before(minExpireTime) {
+		return Permanent
+	}
+	return *en.Expiration
+}
+
+// walkExport calls f with the nodes of the export in depth-first order,
+// parents first, and their paths under the given path.
+func walkExport(en *NodeExtern, nodePath string, f func(nodePath string, en *NodeExtern) error) error {
+	if err := f(nodePath, en); err != nil {
+		return err
+	}
+	for _, child := range en.Nodes {
+		if err := walkExport(child, path.Join(nodePath, path.Base(child.Key)), f); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,58 @@
+// Copyright 2015 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package store
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestExportValidate(t *testing.T) {
+	tests := []struct {
+		export string
+		wok    bool
+	}{
+		{`{"version":1,"node":{"key":"/foo","value":"bar"}}`, true},
+		{`{"version":1,"node":{"key":"/foo","dir":true}}`, true},
+		{`{"version":1,"node":{"key":"/","dir":true,"nodes":[{"key":"/a","dir":true,"nodes":[{"key":"/a/.b","value":""}]}]}}`, true},
+		// version
+		{`{"node":{"key":"/foo","value":"bar"}}`, false},
+		{`{"version":2,"node":{"key":"/foo","value":"bar"}}`, false},
+		// no node
+		{`{"version":1}`, false},
+		{`{"version":1,"node":{"key":"/","dir":true,"nodes":[null]}}`, false},
+		// a file without a value, or with children
+		{`{"version":1,"node":{"key":"/foo"}}`, false},
+		{`{"version":1,"node":{"key":"/foo","value":"","nodes":[{"key":"/foo/a","value":""}]}}`, false},
+		// a directory with a value
+		{`{"version":1,"node":{"key":"/foo","dir":true,"value":""}}`, false},
+		// the keys of the children
+		{`{"version":1,"node":{"key":"/foo","dir":true,"nodes":[{"key":"/bar","value":""}]}}`, false},
+		{`{"version":1,"node":{"key":"/foo","dir":true,"nodes":[{"key":"/foo/a/b","value":""}]}}`, false},
+		{`{"version":1,"node":{"key":"/foo","dir":true,"nodes":[{"key":"/foo/..","value":""}]}}`, false},
+		{`{"version":1,"node":{"key":"/foo","dir":true,"nodes":[{"key":"/foo/a/","value":""}]}}`, false},
+		{`{"version":1,"node":{"key":"/","dir":true,"nodes":[{"key":"/","value":""}]}}`, false},
+		{`{"version":1,"node":{"key":"/foo","dir":true,"nodes":[{"key":"/foo/a","value":""},{"key":"/foo/a","dir":true}]}}`, false},
+	}
+	for i, tt := range tests {
+		var ex Export
+		if err := json.Unmarshal([]byte(tt.export), &ex); err != nil {
+			t.Fatalf("#%d: unexpected unmarshal error: %v", i, err)
+		}
+		if err := ex.Validate(); (err == nil) != tt.wok {
+			t.Errorf("#%d: err = %v, want ok %v", i, err, tt.wok)
+		}
+	}
+}
@@ -0,0 +1,297 @@
+// Copyright 2015 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package store
+
+import (
+	"bytes"
+	"encoding/gob"
+	"encoding/json"
+	"fmt"
+	"path"
+	"time"
+)
+
+// The formats of the states saved by the stores. The JSON format is the
+// one of the v2 store. The gob format is smaller and faster to decode
+// for large trees. Recovery accepts either of them, whatever the format
+// the store saves in.
+// 快照的编码格式，恢复时自动识别
+const (
+	SnapshotFormatJSON = "json"
+	SnapshotFormatGob  = "gob"
+)
+
+// gobStateMagic starts the states saved in gob, followed by the version
+// of gobState. A JSON state starts with '{' instead.
+var gobStateMagic = []byte("\x00etcd-store-gob")
+
+const gobStateVersion = 1
+
+// gobState is the state of a store in the gob format. The tree is kept
+// as a flat list of nodes, so that it is encoded without recursion.
+type gobState struct {
+	CurrentIndex   uint64
+	CurrentVersion int
+	RaftIndex      uint64
+	// Nodes are the nodes of the tree, the root first and each of the
+	// others after its parent.
+	Nodes   []gobNode
+	History gobHistory
+	Stats   *Stats
+	// RequestResults are the results of the requests applied with a
+	// token, encoded in JSON like the events.
+	RequestResults []byte
+
+	// the revisions of a mvcc store
+	Revisions    map[string][]keyRevision
+	MVCCHistory  gobEvents
+	CompactIndex uint64
+}
+
+type gobNode struct {
+	Path          string
+	Dir           bool
+	Value         string
+	CreatedIndex  uint64
+	ModifiedIndex uint64
+	ExpireTime    time.Time
+}
+
+// gobHistory is an event history. The events are kept in order, without
+// the empty slots of the queue.
+type gobHistory struct {
+	Capacity   int
+	StartIndex uint64
+	LastIndex  uint64
+	Events     gobEvents
+}
+
+// gobEvents are events encoded in JSON. Gob does not tell a pointer to
+// an empty value from a nil pointer, and so would lose the empty values
+// of the events.
+type gobEvents [][]byte
+
+func newGobEvents(events []*Event) (gobEvents, error) {
+	ges := make(gobEvents, 0, len(events))
+	for _, e := range events {
+		b, err := json.Marshal(e)
+		if err != nil {
+			return nil, err
+		}
+		ges = append(ges, b)
+	}
+	return ges, nil
+}
+
+func (ges gobEvents) events() ([]*Event, error) {
+	var events []*Event
+	for _, b := range ges {
+		e := &Event{}
+		if err := json.Unmarshal(b, e); err != nil {
+			return nil, err
+		}
+		events = append(events, e)
+	}
+	return events, nil
+}
+
+func isGobState(state []byte) bool {
+	return bytes.HasPrefix(state, gobStateMagic)
+}
+
+func encodeGobState(st *gobState) ([]byte, error) {
+	var buf bytes.Buffer
+	buf.Write(gobStateMagic)
+	buf.WriteByte(gobStateVersion)
+	if err := gob.NewEncoder(&buf).Encode(st); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func decodeGobState(state []byte) (*gobState, error) {
+	b := state[len(gobStateMagic):]
+	if len(b) == 0 || b[0] != gobStateVersion {
+		return nil, fmt.Errorf("store: unsupported version of gob state")
+	}
+	st := &gobState{}
+	if err := gob.NewDecoder(bytes.NewReader(b[1:])).Decode(st); err != nil {
+		return nil, err
+	}
+	return st, nil
+}
+
+func newGobNode(n *node) gobNode {
+	return gobNode{
+		Path:          n.Path,
+		Dir:           n.IsDir(),
+		Value:         n.Value,
+		CreatedIndex:  n.CreatedIndex,
+		ModifiedIndex: n.ModifiedIndex,
+		ExpireTime:    n.ExpireTime,
+	}
+}
+
+// appendGobNodes appends the nodes under the given node, each after its
+// parent.
+func appendGobNodes(nodes []gobNode, n *node) []gobNode {
+	for _, child := range n.Children {
+		nodes = append(nodes, newGobNode(child))
+		nodes = appendGobNodes(nodes, child)
+	}
+	return nodes
+}
+
+// tree builds the tree of the state for the given store.
+func (st *gobState) tree(s *store) (*node, error) {
+	if len(st.Nodes) == 0 || st.Nodes[0].Path != "/" || !st.Nodes[0].Dir {
+		return nil, fmt.Errorf("store: gob state does not start with the root")
+	}
+	dirs := make(map[string]*node)
+	var root *node
+	for _, gn := range st.Nodes {
+		var parent *node
+		if root != nil {
+			var ok bool
+			if parent, ok = dirs[path.Dir(gn.Path)]; !ok {
+				return nil, fmt.Errorf("store: gob state has node %q before its parent", gn.Path)
+			}
+		}
+		var n *node
+		if gn.Dir {
+			n = newDir(s, gn.Path, gn.CreatedIndex, parent, gn.ExpireTime)
+			dirs[gn.Path] = n
+		} else {
+			n = newKV(s, gn.Path, gn.Value, gn.CreatedIndex, parent, gn.ExpireTime)
+		}
+		n.ModifiedIndex = gn.ModifiedIndex
+		if root == nil {
+			root = n
+			continue
+		}
+		parent.Children[path.Base(gn.Path)] = n
+	}
+	return root, nil
+}
+
+func newGobHistory(eh *EventHistory) (gobHistory, error) {
+	events := make([]*Event, 0, eh.Queue.Size)
+	for i := 0; i < eh.Queue.Size; i++ {
+		events = append(events, eh.Queue.Events[(eh.Queue.Front+i)%eh.Queue.Capacity])
+	}
+	ges, err := newGobEvents(events)
+	if err != nil {
+		return gobHistory{}, err
+	}
+	return gobHistory{
+		Capacity:   eh.Queue.Capacity,
+		StartIndex: eh.StartIndex,
+		LastIndex:  eh.LastIndex,
+		Events:     ges,
+	}, nil
+}
+
+func (h gobHistory) eventHistory() (*EventHistory, error) {
+	events, err := h.Events.events()
+	if err != nil {
+		return nil, err
+	}
+	capacity := h.Capacity
+	if capacity < len(events) {
+		capacity = len(events)
+	}
+	if capacity == 0 {
+		capacity = DefaultHistorySize
+	}
+	eh := newEventHistory(capacity)
+	for _, e := range events {
+		eh.Queue.insert(e)
+	}
+	eh.StartIndex, eh.LastIndex = h.StartIndex, h.LastIndex
+	return eh, nil
+}
+
+// gobState returns the state of the store in the gob format. The caller
+// must make sure the tree does not change meanwhile.
+func (s *store) gobState() (*gobState, error) {
+	h, err := newGobHistory(s.WatcherHub.EventHistory)
+	if err != nil {
+		return nil, err
+	}
+	rrs, err := json.Marshal(s.RequestResults)
+	if err != nil {
+		return nil, err
+	}
+	root := s.root()
+	return &gobState{
+		CurrentIndex:   s.CurrentIndex,
+		CurrentVersion: s.CurrentVersion,
+		RaftIndex:      s.RaftIndex,
+		Nodes:          appendGobNodes([]gobNode{newGobNode(root)}, root),
+		History:        h,
+		Stats:          s.Stats,
+		RequestResults: rrs,
+	}, nil
+}
+
+// decodeState decodes a state saved in either format into the store. A
+// JSON state is decoded into v, which embeds the store. The state of a
+// gob state is returned for the caller to decode its own parts.
+func (s *store) decodeState(state []byte, v interface{}) (*gobState, error) {
+	// the states saved before the consistent index and the request
+	// results were recorded have none
+	s.RaftIndex = 0
+	s.RequestResults = newRequestResults()
+	if !isGobState(state) {
+		if err := json.Unmarshal(state, v); err != nil {
+			return nil, err
+		}
+		if s.Root == nil {
+			return nil, fmt.Errorf("store: state has no root")
+		}
+		if s.RequestResults == nil {
+			s.RequestResults = newRequestResults()
+		}
+		s.RequestResults.reindex()
+		return nil, nil
+	}
+	st, err := decodeGobState(state)
+	if err != nil {
+		return nil, err
+	}
+	root, err := st.tree(s)
+	if err != nil {
+		return nil, err
+	}
+	eh, err := st.History.eventHistory()
+	if err != nil {
+		return nil, err
+	}
+	if len(st.RequestResults) > 0 {
+		if err := json.Unmarshal(st.RequestResults, s.RequestResults); err != nil {
+			return nil, err
+		}
+		s.RequestResults.reindex()
+	}
+	s.Root = root
+	s.CurrentIndex = st.CurrentIndex
+	s.CurrentVersion = st.CurrentVersion
+	s.RaftIndex = st.RaftIndex
+	s.WatcherHub.EventHistory = eh
+	if st.Stats != nil {
+		s.Stats = st.Stats
+	}
+	return st, nil
+}
@@ -0,0 +1,83 @@
+// Copyright 2015 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package store
+
+import "github.com/coreos/etcd/Godeps/_workspace/src/github.com/prometheus/client_golang/prometheus"
+
+var (
+	operationCounter = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "store_operations_total",
+			Help: "The total number of store operations.",
+		},
+		[]string{"action", "result"},
+	)
+	expireCounter = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "store_expires_total",
+		Help: "The total number of expired keys.",
+	})
+	watcherGauge = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "store_watchers",
+		Help: "The number of watchers.",
+	})
+	watcherOverflowCounter = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "store_watcher_overflows_total",
+		Help: "The total number of watchers removed for falling behind the events.",
+	})
+)
+
+// operationLabels maps the stats fields of the operations to their
+// action and result labels.
+var operationLabels = map[int][2]string{
+	SetSuccess:              {Set, "success"},
+	SetFail:                 {Set, "fail"},
+	DeleteSuccess:           {Delete, "success"},
+	DeleteFail:              {Delete, "fail"},
+	CreateSuccess:           {Create, "success"},
+	CreateFail:              {Create, "fail"},
+	UpdateSuccess:           {Update, "success"},
+	UpdateFail:              {Update, "fail"},
+	CompareAndSwapSuccess:   {CompareAndSwap, "success"},
+	CompareAndSwapFail:      {CompareAndSwap, "fail"},
+	GetSuccess:              {Get, "success"},
+	GetFail:                 {Get, "fail"},
+	CompareAndDeleteSuccess: {CompareAndDelete, "success"},
+	CompareAndDeleteFail:    {CompareAndDelete, "fail"},
+	TxnSuccess:              {"txn", "success"},
+	TxnFail:                 {"txn", "fail"},
+	IncrSuccess:             {Incr, "success"},
+	IncrFail:                {Incr, "fail"},
+	MoveSuccess:             {Move, "success"},
+	MoveFail:                {Move, "fail"},
+	ImportSuccess:           {Import, "success"},
+	ImportFail:              {Import, "fail"},
+}
+
+func init() {
+	prometheus.MustRegister(operationCounter)
+	prometheus.MustRegister(expireCounter)
+	prometheus.MustRegister(watcherGauge)
+	prometheus.MustRegister(watcherOverflowCounter)
+}
+
+func reportOperation(field int) {
+	if field == ExpireCount {
+		expireCounter.Inc()
+		return
+	}
+	if l, ok := operationLabels[field]; ok {
+		operationCounter.WithLabelValues(l[0], l[1]).Inc()
+	}
+}
@@ -0,0 +1,542 @@
+// Copyright 2015 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package store
+
+import (
+	"encoding/json"
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/coreos/etcd/Godeps/_workspace/src/github.com/jonboulle/clockwork"
+	etcdErr "github.com/coreos/etcd/error"
+)
+
+// MVCCStore is a Store that keeps every revision of every key until the
+// revision is compacted. The revision of a change is the etcd index at
+// which it happens.
+type MVCCStore interface {
+	Store
+
+	// GetAtRevision returns a get event of the node at nodePath as it was
+	// at the given revision. The revision must not be compacted.
+	GetAtRevision(nodePath string, recursive, sorted bool, rev uint64) (*Event, error)
+	// Compact discards all the revisions that are superseded at rev.
+	// After compaction, reads and watches before rev are not available.
+	Compact(rev uint64) error
+	// CompactedRevision returns the revision of the last compaction.
+	CompactedRevision() uint64
+}
+
+// keyRevision is the state of a key after a change at ModifiedIndex.
+// A tombstone marks that the key is deleted.
+type keyRevision struct {
+	ModifiedIndex uint64 `json:"modifiedIndex"`
+	CreatedIndex  uint64 `json:"createdIndex"`
+	Value         string `json:"value"`
+	Dir           bool   `json:"dir"`
+	Tombstone     bool   `json:"tombstone"`
+}
+
+func (r *keyRevision) repr(key string) *NodeExtern {
+	n := &NodeExtern{
+		Key:           key,
+		Dir:           r.Dir,
+		ModifiedIndex: r.ModifiedIndex,
+		CreatedIndex:  r.CreatedIndex,
+	}
+	if !r.Dir {
+		value := r.Value
+		n.Value = &value
+	}
+	return n
+}
+
+// mvccStore keeps the current state in the v2 tree and records every
+// event applied to the tree as a new revision of the changed keys.
+// Unlike the event history of the watcher hub, the revisions are only
+// discarded by an explicit compaction, so a watcher can resume from any
+// index after the compacted one.
+// All the fields are protected by the world lock.
+type mvccStore struct {
+	*store
+
+	// Revisions holds the revisions of every key ordered by index.
+	Revisions map[string][]keyRevision
+	// History holds the events after the compacted revision ordered
+	// by index.
+	History []*Event
+	// CompactIndex is the revision of the last compaction.
+	CompactIndex uint64
+
+	// revisionsSize is the total size of the keys and values of the
+	// revisions.
+	revisionsSize int64
+}
+
+// NewMVCC creates a store that keeps the revisions of its keys.
+// The given namespaces will be created as initial directories in the returned store.
+func NewMVCC(historySize int, namespaces ...string) MVCCStore {
+	s := newMVCCStore(namespaces...)
+	s.WatcherHub = newWatchHub(historySize)
+	s.clock = clockwork.NewRealClock()
+	return s
+}
+
+func newMVCCStore(namespaces ...string) *mvccStore {
+	s := &mvccStore{store: newStore(namespaces...)}
+	s.onEvent = s.record
+	s.seedRevisions()
+	return s
+}
+
+// seedRevisions records the current tree as the first revision of
+// every key. The history before the current index is unknown, so it
+// is treated as compacted.
+func (s *mvccStore) seedRevisions() {
+	s.Revisions = make(map[string][]keyRevision)
+	s.History = nil
+	s.CompactIndex = s.CurrentIndex
+
+	var walk func(n *node)
+	walk = func(n *node) {
+		for _, child := range n.Children {
+			s.Revisions[child.Path] = []keyRevision{{
+				ModifiedIndex: child.ModifiedIndex,
+				CreatedIndex:  child.CreatedIndex,
+				Value:         child.Value,
+				Dir:           child.IsDir(),
+			}}
+			if child.IsDir() {
+				walk(child)
+			}
+		}
+	}
+	walk(s.root())
+	s.resetRevisionsSize()
+}
+
+// resetRevisionsSize recomputes the size of the revisions.
+func (s *mvccStore) resetRevisionsSize() {
+	s.revisionsSize = 0
+	for k, revs := range s.Revisions {
+		for _, r := range revs {
+			s.revisionsSize += int64(len(k) + len(r.Value))
+		}
+	}
+}
+
+// Size returns the size of the tree and the revisions.
+func (s *mvccStore) Size() int64 {
+	s.worldLock.RLock()
+	defer s.worldLock.RUnlock()
+	return s.keyBytes + s.valueBytes + s.revisionsSize
+}
+
+// MemoryUsage counts the revisions as a part of the history.
+func (s *mvccStore) MemoryUsage() MemoryUsage {
+	u := s.store.MemoryUsage()
+	s.worldLock.RLock()
+	defer s.worldLock.RUnlock()
+	u.HistoryBytes += s.revisionsSize
+	return u
+}
+
+func (s *mvccStore) JsonStats() []byte {
+	s.Stats.Watchers = uint64(s.WatcherHub.count)
+	s.Stats.setMemoryUsage(s.MemoryUsage())
+	return s.Stats.toJson()
+}
+
+// record records the given event as new revisions of the keys it changes.
+func (s *mvccStore) record(e *Event) {
+	index := e.Index()
+	key := e.Node.Key
+
+	switch e.Action {
+	case Move:
+		// the keys under the old key move with it, keeping their
+		// created index
+		from := e.PrevNode.Key
+		for k, revs := range s.Revisions {
+			if k != from && !strings.HasPrefix(k, from+"/") {
+				continue
+			}
+			live := revs[len(revs)-1]
+			if live.Tombstone {
+				continue
+			}
+			live.ModifiedIndex = index
+			to := key + strings.TrimPrefix(k, from)
+			s.Revisions[to] = append(s.Revisions[to], live)
+			s.revisionsSize += int64(len(to) + len(live.Value))
+			s.tombstone(k, index)
+		}
+		s.recordDirs(key, index)
+	case Import:
+		// the imported nodes are recorded from the tree, since the
+		// event only holds the imported node itself
+		s.recordDirs(key, index)
+		n, _ := s.internalGet(key)
+		var walk func(n *node)
+		walk = func(n *node) {
+			s.Revisions[n.Path] = append(s.Revisions[n.Path], keyRevision{
+				ModifiedIndex: index,
+				CreatedIndex:  index,
+				Value:         n.Value,
+				Dir:           n.IsDir(),
+			})
+			s.revisionsSize += int64(len(n.Path) + len(n.Value))
+			for _, child := range n.Children {
+				walk(child)
+			}
+		}
+		walk(n)
+	case Delete, CompareAndDelete, Expire:
+		// deleting a directory deletes all the keys under it
+		s.tombstone(key, index)
+		for k := range s.Revisions {
+			if strings.HasPrefix(k, key+"/") {
+				s.tombstone(k, index)
+			}
+		}
+	default:
+		s.recordDirs(key, index)
+
+		r := keyRevision{
+			ModifiedIndex: index,
+			CreatedIndex:  e.Node.CreatedIndex,
+			Dir:           e.Node.Dir,
+		}
+		if e.Node.Value != nil {
+			r.Value = *e.Node.Value
+		}
+		s.Revisions[key] = append(s.Revisions[key], r)
+		s.revisionsSize += int64(len(key) + len(r.Value))
+	}
+
+	s.History = append(s.History, e.Clone())
+}
+
+// recordDirs records the directories on the path of the key, which are
+// created with the key if they do not exist.
+func (s *mvccStore) recordDirs(key string, index uint64) {
+	for dir := path.Dir(key); dir != "/"; dir = path.Dir(dir) {
+		if s.liveRevision(dir) != nil {
+			break
+		}
+		s.Revisions[dir] = append(s.Revisions[dir], keyRevision{
+			ModifiedIndex: index,
+			CreatedIndex:  index,
+			Dir:           true,
+		})
+		s.revisionsSize += int64(len(dir))
+	}
+}
+
+func (s *mvccStore) tombstone(key string, index uint64) {
+	if s.liveRevision(key) == nil {
+		return
+	}
+	s.Revisions[key] = append(s.Revisions[key], keyRevision{ModifiedIndex: index, Tombstone: true})
+	s.revisionsSize += int64(len(key))
+}
+
+// liveRevision returns the latest revision of the key, or nil if the
+// key does not exist.
+func (s *mvccStore) liveRevision(key string) *keyRevision {
+	revs := s.Revisions[key]
+	if len(revs) == 0 || revs[len(revs)-1].Tombstone {
+		return nil
+	}
+	return &revs[len(revs)-1]
+}
+
+// revisionAt returns the revision of the key at the given index, or nil
+// if the key does not exist at that index.
+func (s *mvccStore) revisionAt(key string, index uint64) *keyRevision {
+	revs := s.Revisions[key]
+	i := sort.Search(len(revs), func(i int) bool { return revs[i].ModifiedIndex > index })
+	if i == 0 || revs[i-1].Tombstone {
+		return nil
+	}
+	return &revs[i-1]
+}
+
+func (s *mvccStore) GetAtRevision(nodePath string, recursive, sorted bool, rev uint64) (*Event, error) {
+	s.worldLock.RLock()
+	defer s.worldLock.RUnlock()
+
+	nodePath = path.Clean(path.Join("/", nodePath))
+
+	if err := s.checkRevision(rev); err != nil {
+		s.Stats.Inc(GetFail)
+		return nil, err
+	}
+
+	var eNode *NodeExtern
+	if nodePath == "/" {
+		eNode = &NodeExtern{Key: nodePath, Dir: true}
+	} else {
+		r := s.revisionAt(nodePath, rev)
+		if r == nil {
+			s.Stats.Inc(GetFail)
+			return nil, etcdErr.NewError(etcdErr.EcodeKeyNotFound, nodePath, s.CurrentIndex)
+		}
+		eNode = r.repr(nodePath)
+	}
+	if eNode.Dir {
+		eNode.Nodes = s.nodesAt(nodePath, rev, recursive, sorted)
+	}
+
+	s.Stats.Inc(GetSuccess)
+
+	return &Event{Action: Get, Node: eNode, EtcdIndex: s.CurrentIndex}, nil
+}
+
+// nodesAt returns the nodes under the given directory at the given index.
+// Like Get, it does not return hidden nodes.
+func (s *mvccStore) nodesAt(dir string, index uint64, recursive, sorted bool) NodeExterns {
+	prefix := dir
+	if prefix != "/" {
+		prefix += "/"
+	}
+
+	nodes := map[string]*NodeExtern{dir: {Key: dir, Dir: true}}
+	for k := range s.Revisions {
+		if !strings.HasPrefix(k, prefix) {
+			continue
+		}
+		rel := k[len(prefix):]
+		if (!recursive && strings.Contains(rel, "/")) || strings.HasPrefix(rel, "_") || strings.Contains(rel, "/_") {
+			continue
+		}
+		if r := s.revisionAt(k, index); r != nil {
+			nodes[k] = r.repr(k)
+		}
+	}
+	// attach every node to its parent; the parents of a live key
+	// are always live directories
+	for k, n := range nodes {
+		if k == dir {
+			continue
+		}
+		if parent, ok := nodes[path.Dir(k)]; ok {
+			parent.Nodes = append(parent.Nodes, n)
+		}
+	}
+	if sorted {
+		for _, n := range nodes {
+			sort.Sort(n.Nodes)
+		}
+	}
+	return nodes[dir].Nodes
+}
+
+// checkRevision checks that the store has the state at the given
+// revision. The caller must hold the world lock.
+func (s *mvccStore) checkRevision(rev uint64) *etcdErr.Error {
+	if rev < s.CompactIndex {
+		return etcdErr.NewCompactedError(s.CompactIndex, rev, s.CurrentIndex)
+	}
+	if rev > s.CurrentIndex {
+		return etcdErr.NewError(etcdErr.EcodeInvalidField,
+			fmt.Sprintf("the requested revision is in the future [%v/%v]", s.CurrentIndex, rev), s.CurrentIndex)
+	}
+	return nil
+}
+
+func (s *mvccStore) Compact(rev uint64) error {
+	s.worldLock.Lock()
+	defer s.worldLock.Unlock()
+
+	if err := s.checkRevision(rev); err != nil {
+		return err
+	}
+
+	for k, revs := range s.Revisions {
+		i := sort.Search(len(revs), func(i int) bool { return revs[i].ModifiedIndex > rev })
+		if i == 0 {
+			continue
+		}
+		// keep the revision that is current at rev unless it is a tombstone
+		kept := revs[i-1:]
+		if kept[0].Tombstone {
+			kept = kept[1:]
+		}
+		if len(kept) == 0 {
+			delete(s.Revisions, k)
+			continue
+		}
+		s.Revisions[k] = append([]keyRevision(nil), kept...)
+	}
+
+	i := sort.Search(len(s.History), func(i int) bool { return s.History[i].Index() > rev })
+	s.History = append([]*Event(nil), s.History[i:]...)
+	s.CompactIndex = rev
+	s.resetRevisionsSize()
+	return nil
+}
+
+func (s *mvccStore) CompactedRevision() uint64 {
+	s.worldLock.RLock()
+	defer s.worldLock.RUnlock()
+	return s.CompactIndex
+}
+
+// OldestWatchableIndex returns the index after the compacted revision
+// if the recorded history goes further back than the event history of
+// the watcher hub.
+func (s *mvccStore) OldestWatchableIndex() uint64 {
+	s.worldLock.RLock()
+	defer s.worldLock.RUnlock()
+	oldest := s.WatcherHub.EventHistory.oldestIndex()
+	if s.CompactIndex+1 < oldest {
+		return s.CompactIndex + 1
+	}
+	return oldest
+}
+
+// Watch works like the Watch of the v2 store, except that a sinceIndex
+// older than the event history of the watcher hub is served from the
+// recorded history as long as it is after the compacted revision.
+func (s *mvccStore) Watch(key string, recursive, stream bool, sinceIndex uint64, actions []string) (Watcher, error) {
+	s.worldLock.RLock()
+	defer s.worldLock.RUnlock()
+
+	key = path.Clean(path.Join("/", key))
+	if sinceIndex == 0 {
+		sinceIndex = s.CurrentIndex + 1
+	}
+
+	index := sinceIndex
+	if sinceIndex < s.WatcherHub.EventHistory.StartIndex {
+		if sinceIndex <= s.CompactIndex {
+			return nil, etcdErr.NewCompactedError(s.CompactIndex, sinceIndex, s.CurrentIndex)
+		}
+
+		i := sort.Search(len(s.History), func(i int) bool { return s.History[i].Index() >= sinceIndex })
+		for _, e := range s.History[i:] {
+			if e.affects(key, recursive) && matchAction(actions, e.Action) {
+				w := &watcher{
+					eventChan:  make(chan *Event, 1),
+					recursive:  recursive,
+					stream:     stream,
+					sinceIndex: sinceIndex,
+					startIndex: s.CurrentIndex,
+					actions:    actions,
+					hub:        s.WatcherHub,
+				}
+				e = e.Clone()
+				e.EtcdIndex = s.CurrentIndex
+				w.eventChan <- e
+				return w, nil
+			}
+		}
+		// nothing has happened on the key since sinceIndex, so waiting
+		// for the next event is equivalent
+		index = s.CurrentIndex + 1
+	}
+
+	w, err := s.WatcherHub.watch(key, recursive, stream, index, s.CurrentIndex, actions)
+	if err != nil {
+		return nil, err
+	}
+	return w, nil
+}
+
+func (s *mvccStore) Save() ([]byte, error) {
+	return s.Clone().SaveNoCopy()
+}
+
+func (s *mvccStore) SaveNoCopy() ([]byte, error) {
+	s.root()
+	if s.snapshotFormat == SnapshotFormatGob {
+		st, err := s.gobState()
+		if err != nil {
+			return nil, err
+		}
+		if st.MVCCHistory, err = newGobEvents(s.History); err != nil {
+			return nil, err
+		}
+		st.Revisions, st.CompactIndex = s.Revisions, s.CompactIndex
+		return encodeGobState(st)
+	}
+	b, err := json.Marshal(s)
+	if err != nil {
+		return nil, err
+	}
+
+	return b, nil
+}
+
+func (s *mvccStore) Clone() Store {
+	s.worldLock.Lock()
+	defer s.worldLock.Unlock()
+
+	clonedStore := &mvccStore{
+		store:        s.store.clone(),
+		Revisions:    make(map[string][]keyRevision, len(s.Revisions)),
+		History:      append([]*Event(nil), s.History...),
+		CompactIndex: s.CompactIndex,
+	}
+	clonedStore.onEvent = clonedStore.record
+	for k, revs := range s.Revisions {
+		clonedStore.Revisions[k] = append([]keyRevision(nil), revs...)
+	}
+	clonedStore.revisionsSize = s.revisionsSize
+	return clonedStore
+}
+
+// Recovery recovers the store from a state saved by either a v2 store
+// or an MVCC store. A state without revisions starts a new history from
+// its current index.
+func (s *mvccStore) Recovery(state []byte) error {
+	s.worldLock.Lock()
+	defer s.worldLock.Unlock()
+	// release the snapshot of a clone before its tree is replaced
+	s.root()
+
+	s.Revisions, s.History, s.CompactIndex = nil, nil, 0
+	// the saved history has the size of the store that saved it
+	historySize := s.WatcherHub.EventHistory.Queue.Capacity
+	s.Root = nil
+	st, err := s.decodeState(state, s)
+
+	if err != nil {
+		return err
+	}
+	if st != nil {
+		if s.History, err = st.MVCCHistory.events(); err != nil {
+			return err
+		}
+		s.Revisions, s.CompactIndex = st.Revisions, st.CompactIndex
+	}
+
+	s.WatcherHub.EventHistory.resize(historySize)
+
+	s.ttlWheel = newTTLWheel()
+
+	s.Root.store = s.store
+	s.Root.recoverAndclean()
+	s.keyBytes, s.valueBytes = s.Root.treeBytes()
+	if s.Revisions == nil {
+		s.seedRevisions()
+	} else {
+		s.resetRevisionsSize()
+	}
+	return nil
+}
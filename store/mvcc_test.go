@@ -0,0 +1,297 @@
+// Copyright 2015 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package store
+
+import (
+	"testing"
+
+	"github.com/coreos/etcd/Godeps/_workspace/src/github.com/stretchr/testify/assert"
+	etcdErr "github.com/coreos/etcd/error"
+)
+
+// Ensure that the MVCC store can read a key at any revision.
+func TestMVCCStoreGetAtRevision(t *testing.T) {
+	s := newMVCCStore()
+	s.Create("/foo", false, "bar", false, Permanent)
+	s.Set("/foo", false, "baz", Permanent)
+	s.Delete("/foo", false, false)
+
+	tests := []struct {
+		rev    uint64
+		wvalue string
+		wcode  int
+	}{
+		{0, "", etcdErr.EcodeKeyNotFound},
+		{1, "bar", 0},
+		{2, "baz", 0},
+		{3, "", etcdErr.EcodeKeyNotFound},
+		{4, "", etcdErr.EcodeInvalidField},
+	}
+	for i, tt := range tests {
+		e, err := s.GetAtRevision("/foo", false, false, tt.rev)
+		if tt.wcode != 0 {
+			if err == nil || err.(*etcdErr.Error).ErrorCode != tt.wcode {
+				t.Errorf("#%d: err = %v, want code %d", i, err, tt.wcode)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("#%d: unexpected error %v", i, err)
+		}
+		if *e.Node.Value != tt.wvalue {
+			t.Errorf("#%d: value = %s, want %s", i, *e.Node.Value, tt.wvalue)
+		}
+		if e.Node.ModifiedIndex != tt.rev {
+			t.Errorf("#%d: modifiedIndex = %d, want %d", i, e.Node.ModifiedIndex, tt.rev)
+		}
+		if e.EtcdIndex != 3 {
+			t.Errorf("#%d: etcdIndex = %d, want 3", i, e.EtcdIndex)
+		}
+	}
+}
+
+// Ensure that the memory usage of the MVCC store counts its revisions
+// as a part of the history.
+func TestMVCCStoreMemoryUsage(t *testing.T) {
+	s := newMVCCStore()
+	s.Create("/foo", false, "bar", false, Permanent)
+	s.Set("/foo", false, "baz", Permanent)
+
+	u := s.MemoryUsage()
+	assert.Equal(t, s.store.MemoryUsage().HistoryBytes+s.revisionsSize, u.HistoryBytes, "")
+	assert.True(t, s.revisionsSize > 0, "")
+}
+
+// Ensure that the MVCC store can read a directory at any revision.
+func TestMVCCStoreGetDirectoryAtRevision(t *testing.T) {
+	s := newMVCCStore()
+	s.Create("/foo/bar", false, "X", false, Permanent)
+	s.Create("/foo/_hidden", false, "*", false, Permanent)
+	s.Create("/foo/baz/bat", false, "Y", false, Permanent)
+	s.Delete("/foo", true, true)
+
+	e, err := s.GetAtRevision("/foo", true, true, 3)
+	assert.Nil(t, err, "")
+	assert.True(t, e.Node.Dir, "")
+	assert.Equal(t, e.Node.CreatedIndex, uint64(1), "")
+	assert.Equal(t, len(e.Node.Nodes), 2, "")
+	assert.Equal(t, e.Node.Nodes[0].Key, "/foo/bar", "")
+	assert.Equal(t, *e.Node.Nodes[0].Value, "X", "")
+	assert.Equal(t, e.Node.Nodes[1].Key, "/foo/baz", "")
+	assert.True(t, e.Node.Nodes[1].Dir, "")
+	assert.Equal(t, len(e.Node.Nodes[1].Nodes), 1, "")
+	assert.Equal(t, *e.Node.Nodes[1].Nodes[0].Value, "Y", "")
+
+	e, err = s.GetAtRevision("/foo", false, true, 3)
+	assert.Nil(t, err, "")
+	assert.Equal(t, len(e.Node.Nodes), 2, "")
+	assert.Nil(t, e.Node.Nodes[1].Nodes, "")
+
+	e, err = s.GetAtRevision("/", true, true, 1)
+	assert.Nil(t, err, "")
+	assert.Equal(t, len(e.Node.Nodes), 1, "")
+	assert.Equal(t, len(e.Node.Nodes[0].Nodes), 1, "")
+
+	// the recursive delete deletes all the keys under the directory
+	for _, key := range []string{"/foo", "/foo/bar", "/foo/baz/bat"} {
+		_, err = s.GetAtRevision(key, false, false, 4)
+		assert.Equal(t, err.(*etcdErr.Error).ErrorCode, etcdErr.EcodeKeyNotFound, key)
+	}
+}
+
+// Ensure that the MVCC store discards superseded revisions on compaction.
+func TestMVCCStoreCompact(t *testing.T) {
+	s := newMVCCStore()
+	s.Create("/foo", false, "bar", false, Permanent)
+	s.Set("/foo", false, "baz", Permanent)
+	s.Create("/tmp", false, "tmp", false, Permanent)
+	s.Delete("/tmp", false, false)
+	s.Set("/foo", false, "qux", Permanent)
+
+	assert.Nil(t, s.Compact(4), "")
+	assert.Equal(t, s.CompactedRevision(), uint64(4), "")
+	assert.Equal(t, len(s.Revisions["/foo"]), 2, "")
+	assert.Equal(t, len(s.Revisions["/tmp"]), 0, "")
+	assert.Equal(t, len(s.History), 1, "")
+
+	_, err := s.GetAtRevision("/foo", false, false, 3)
+	assert.Equal(t, err.(*etcdErr.Error).ErrorCode, etcdErr.EcodeEventIndexCleared, "")
+	e, err := s.GetAtRevision("/foo", false, false, 4)
+	assert.Nil(t, err, "")
+	assert.Equal(t, *e.Node.Value, "baz", "")
+	e, err = s.GetAtRevision("/foo", false, false, 5)
+	assert.Nil(t, err, "")
+	assert.Equal(t, *e.Node.Value, "qux", "")
+
+	err = s.Compact(3)
+	assert.Equal(t, err.(*etcdErr.Error).ErrorCode, etcdErr.EcodeEventIndexCleared, "")
+}
+
+// Ensure that the MVCC store resumes a watch beyond the event history of the watcher hub.
+func TestMVCCStoreWatchBeyondEventHistory(t *testing.T) {
+	s := newMVCCStore()
+	s.Create("/foo", false, "bar", false, Permanent)
+	for i := 0; i < 2000; i++ {
+		s.Set("/other", false, "v", Permanent)
+	}
+	s.Set("/foo", false, "baz", Permanent)
+
+	w, err := s.Watch("/foo", false, false, 2, nil)
+	assert.Nil(t, err, "")
+	e := nbselect(w.EventChan())
+	assert.Equal(t, e.Action, "set", "")
+	assert.Equal(t, e.Node.ModifiedIndex, uint64(2002), "")
+	assert.Equal(t, e.EtcdIndex, uint64(2002), "")
+
+	// nothing happened since the index, so the watcher waits
+	w, err = s.Watch("/foo", false, false, 2003, nil)
+	assert.Nil(t, err, "")
+	s.Delete("/foo", false, false)
+	e = nbselect(w.EventChan())
+	assert.Equal(t, e.Action, "delete", "")
+
+	// the v2 store has lost the history
+	v2 := newStore()
+	v2.Create("/foo", false, "bar", false, Permanent)
+	for i := 0; i < 2000; i++ {
+		v2.Set("/other", false, "v", Permanent)
+	}
+	_, err = v2.Watch("/foo", false, false, 2, nil)
+	assert.Equal(t, err.(*etcdErr.Error).ErrorCode, etcdErr.EcodeEventIndexCleared, "")
+
+	assert.Nil(t, s.Compact(1000), "")
+	_, err = s.Watch("/foo", false, false, 2, nil)
+	assert.Equal(t, err.(*etcdErr.Error).ErrorCode, etcdErr.EcodeEventIndexCleared, "")
+}
+
+// Ensure that the MVCC store records a move as new revisions of the moved keys.
+func TestMVCCStoreMove(t *testing.T) {
+	s := newMVCCStore()
+	s.Create("/foo/bar", false, "v", false, Permanent)
+	s.Move("/foo", "/baz/foo")
+
+	e, err := s.GetAtRevision("/foo/bar", false, false, 1)
+	assert.Nil(t, err, "")
+	assert.Equal(t, *e.Node.Value, "v", "")
+	_, err = s.GetAtRevision("/foo/bar", false, false, 2)
+	assert.Equal(t, err.(*etcdErr.Error).ErrorCode, etcdErr.EcodeKeyNotFound, "")
+	e, err = s.GetAtRevision("/baz/foo/bar", false, false, 2)
+	assert.Nil(t, err, "")
+	assert.Equal(t, *e.Node.Value, "v", "")
+	assert.Equal(t, e.Node.CreatedIndex, uint64(1), "")
+	_, err = s.GetAtRevision("/baz", false, false, 2)
+	assert.Nil(t, err, "")
+}
+
+func TestMVCCStoreImport(t *testing.T) {
+	s := newMVCCStore()
+	s.Create("/foo/bar", false, "v", false, Permanent)
+	ex, err := s.Export("/foo")
+	assert.Nil(t, err, "")
+	s.Import("/baz/foo", ex)
+
+	_, err = s.GetAtRevision("/baz/foo/bar", false, false, 1)
+	assert.Equal(t, err.(*etcdErr.Error).ErrorCode, etcdErr.EcodeKeyNotFound, "")
+	e, err := s.GetAtRevision("/baz/foo", true, false, 2)
+	assert.Nil(t, err, "")
+	assert.Equal(t, e.Node.Nodes[0].Key, "/baz/foo/bar", "")
+	assert.Equal(t, *e.Node.Nodes[0].Value, "v", "")
+	assert.Equal(t, e.Node.Nodes[0].CreatedIndex, uint64(2), "")
+	_, err = s.GetAtRevision("/baz", false, false, 2)
+	assert.Nil(t, err, "")
+}
+
+func TestMVCCStoreConsistentIndex(t *testing.T) {
+	testStoreConsistentIndex(t, newMVCCStore(), newMVCCStore())
+}
+
+func TestMVCCStoreRequestResults(t *testing.T) {
+	testStoreRequestResults(t, newMVCCStore(), newMVCCStore())
+}
+
+// Ensure that the MVCC store can recover its revisions, from the states
+// saved in either format.
+func TestMVCCStoreRecover(t *testing.T) {
+	for _, format := range []string{SnapshotFormatJSON, SnapshotFormatGob} {
+		s := newMVCCStore()
+		s.SetSnapshotFormat(format)
+		s.Create("/foo", false, "bar", false, Permanent)
+		s.Set("/foo", false, "baz", Permanent)
+		b, err := s.Save()
+		assert.Nil(t, err, format)
+
+		s2 := newMVCCStore()
+		assert.Nil(t, s2.Recovery(b), format)
+		e, err := s2.GetAtRevision("/foo", false, false, 1)
+		assert.Nil(t, err, format)
+		assert.Equal(t, *e.Node.Value, "bar", format)
+		assert.Equal(t, s2.revisionsSize, s.revisionsSize, format)
+
+		// revisions keep being recorded after recovery
+		s2.Set("/foo", false, "qux", Permanent)
+		e, err = s2.GetAtRevision("/foo", false, false, 3)
+		assert.Nil(t, err, format)
+		assert.Equal(t, *e.Node.Value, "qux", format)
+	}
+}
+
+// Ensure that the MVCC store can recover from the state of a v2 store.
+func TestMVCCStoreRecoverFromV2(t *testing.T) {
+	v2 := newStore()
+	v2.Create("/foo", false, "bar", false, Permanent)
+	v2.Set("/foo", false, "baz", Permanent)
+	b, err := v2.Save()
+	assert.Nil(t, err, "")
+
+	s := newMVCCStore()
+	assert.Nil(t, s.Recovery(b), "")
+	assert.Equal(t, s.CompactedRevision(), uint64(2), "")
+	e, err := s.GetAtRevision("/foo", false, false, 2)
+	assert.Nil(t, err, "")
+	assert.Equal(t, *e.Node.Value, "baz", "")
+	_, err = s.GetAtRevision("/foo", false, false, 1)
+	assert.Equal(t, err.(*etcdErr.Error).ErrorCode, etcdErr.EcodeEventIndexCleared, "")
+}
+
+// Ensure that the MVCC store can be watched from the compacted revision on.
+func TestMVCCStoreOldestWatchableIndex(t *testing.T) {
+	s := newMVCCStore()
+	s.WatcherHub = newWatchHub(10)
+	for i := 0; i < 20; i++ {
+		s.Set("/foo", false, "bar", Permanent)
+	}
+	assert.Equal(t, s.OldestWatchableIndex(), uint64(1), "")
+
+	assert.Nil(t, s.Compact(5), "")
+	assert.Equal(t, s.OldestWatchableIndex(), uint64(6), "")
+	_, err := s.Watch("/foo", false, false, 5, nil)
+	assert.Equal(t, err.(*etcdErr.Error).CompactIndex, uint64(5), "")
+
+	assert.Nil(t, s.Compact(15), "")
+	assert.Equal(t, s.OldestWatchableIndex(), uint64(11), "")
+}
+
+// Ensure that the size of the MVCC store includes the revisions.
+func TestMVCCStoreSize(t *testing.T) {
+	s := newMVCCStore()
+	s.Set("/foo", false, "bar", Permanent)
+	s.Set("/foo", false, "baz", Permanent)
+	// the tree and both revisions of the key
+	tree := int64(len("/") + len("/foo") + len("baz"))
+	assert.Equal(t, s.Size(), tree+int64(2*len("/foo")+len("bar")+len("baz")), "")
+
+	assert.Nil(t, s.Compact(2), "")
+	assert.Equal(t, s.Size(), tree+int64(len("/foo")+len("baz")), "")
+}
@@ -123,6 +123,8 @@ func (n *node) Write(value string, index uint64) *etcdErr.Error {
 		return etcdErr.NewError(etcdErr.EcodeNotFile, "", n.store.CurrentIndex)
 	}
 
+	n.preserve()
+	n.account(0, int64(len(value)-len(n.Value)))
 	n.Value = value
 	n.ModifiedIndex = index
 
@@ -131,24 +133,49 @@ func (n *node) Write(value string, index uint64) *etcdErr.Error {
 
 func (n *node) expirationAndTTL(clock clockwork.Clock) (*time.Time, int64) {
 	if !n.IsPermanent() {
-		/* compute ttl as:
-		   ceiling( (expireTime - timeNow) / nanosecondsPerSecond )
-		   which ranges from 1..n
-		   rather than as:
-		   ( (expireTime - timeNow) / nanosecondsPerSecond ) + 1
-		   which ranges 1..n+1
-		*/
-		ttlN := n.ExpireTime.Sub(clock.Now())
-		ttl := ttlN / time.Second
-		if (ttlN % time.Second) > 0 {
-			ttl++
-		}
-		t := n.ExpireTime.UTC()
-		return &t, int64(ttl)
+		return expirationAt(n.ExpireTime, clock)
 	}
 	return nil, 0
 }
 
+// expirationAt returns the expiration and the ttl of a node expiring at
+// the given time.
+func expirationAt(expireTime time.Time, clock clockwork.Clock) (*time.Time, int64) {
+	/* compute ttl as:
+	   ceiling( (expireTime - timeNow) / nanosecondsPerSecond )
+	   which ranges from 1..n
+	   rather than as:
+	   ( (expireTime - timeNow) / nanosecondsPerSecond ) + 1
+	   which ranges 1..n+1
+	*/
+	ttlN := expireTime.Sub(clock.Now())
+	ttl := ttlN / time.Second
+	if (ttlN % time.Second) > 0 {
+		ttl++
+	}
+	t := expireTime.UTC()
+	return &t, int64(ttl)
+}
+
+// ancestorsExpireTime returns the earliest expire time of the directories
+// above the node, or Permanent if none of them expires.
+func (n *node) ancestorsExpireTime() time.Time {
+	expireTime := Permanent
+	for p := n.Parent; p != nil; p = p.Parent {
+		expireTime = earlierExpireTime(expireTime, p.ExpireTime)
+	}
+	return expireTime
+}
+
+// earlierExpireTime returns the earlier of the two expire times, where
+// Permanent never expires.
+func earlierExpireTime(a, b time.Time) time.Time {
+	if a.IsZero() || (!b.IsZero() && b.Before(a)) {
+		return b
+	}
+	return a
+}
+
 // List function return a slice of nodes under the receiver node.
 // If the receiver node is not a directory, a "Not A Directory" error will be returned.
 func (n *node) List() ([]*node, *etcdErr.Error) {
@@ -200,7 +227,9 @@ func (n *node) Add(child *node) *etcdErr.Error {
 		return etcdErr.NewError(etcdErr.EcodeNodeExist, "", n.store.CurrentIndex)
 	}
 
+	n.preserve()
 	n.Children[name] = child
+	child.accountNode(1)
 
 	return nil
 }
@@ -226,7 +255,9 @@ func (n *node) Remove(dir, recursive bool, callback func(path string)) *etcdErr.
 
 		// find its parent and remove the node from the map
 		if n.Parent != nil && n.Parent.Children[name] == n {
+			n.Parent.preserve()
 			delete(n.Parent.Children, name)
+			n.accountNode(-1)
 		}
 
 		if callback != nil {
@@ -234,7 +265,7 @@ func (n *node) Remove(dir, recursive bool, callback func(path string)) *etcdErr.
 		}
 
 		if !n.IsPermanent() {
-			n.store.ttlKeyHeap.remove(n)
+			n.store.ttlWheel.remove(n)
 		}
 
 		return nil
@@ -247,14 +278,16 @@ func (n *node) Remove(dir, recursive bool, callback func(path string)) *etcdErr.
 	// delete self
 	_, name := path.Split(n.Path)
 	if n.Parent != nil && n.Parent.Children[name] == n {
+		n.Parent.preserve()
 		delete(n.Parent.Children, name)
+		n.accountNode(-1)
 
 		if callback != nil {
 			callback(n.Path)
 		}
 
 		if !n.IsPermanent() {
-			n.store.ttlKeyHeap.remove(n)
+			n.store.ttlWheel.remove(n)
 		}
 
 	}
@@ -316,26 +349,27 @@ func (n *node) Repr(recursive, sorted bool, clock clockwork.Clock) *NodeExtern {
 }
 
 func (n *node) UpdateTTL(expireTime time.Time) {
+	n.preserve()
 
 	if !n.IsPermanent() {
 		if expireTime.IsZero() {
 			// from ttl to permanent
 			n.ExpireTime = expireTime
-			// remove from ttl heap
-			n.store.ttlKeyHeap.remove(n)
+			// remove from ttl wheel
+			n.store.ttlWheel.remove(n)
 		} else {
 			// update ttl
 			n.ExpireTime = expireTime
-			// update ttl heap
-			n.store.ttlKeyHeap.update(n)
+			// update ttl wheel
+			n.store.ttlWheel.update(n)
 		}
 
 	} else {
 		if !expireTime.IsZero() {
 			// from permanent to ttl
 			n.ExpireTime = expireTime
-			// push into ttl heap
-			n.store.ttlKeyHeap.push(n)
+			// push into ttl wheel
+			n.store.ttlWheel.push(n)
 		}
 	}
 }
@@ -359,24 +393,56 @@ func (n *node) Compare(prevValue string, prevIndex uint64) (ok bool, which int)
 	return
 }
 
-// Clone function clone the node recursively and return the new node.
-// If the node is a directory, it will clone all the content under this directory.
-// If the node is a key-value pair, it will clone the pair.
-func (n *node) Clone() *node {
-	if !n.IsDir() {
-		newkv := newKV(n.store, n.Path, n.Value, n.CreatedIndex, n.Parent, n.ExpireTime)
-		newkv.ModifiedIndex = n.ModifiedIndex
-		return newkv
+// preserve keeps the current state of the node for the snapshots of
+// its store. It must be called before the node is modified.
+func (n *node) preserve() {
+	if n.store != nil {
+		n.store.preserve(n)
 	}
+}
+
+// treeBytes returns the total size of the paths and of the values of
+// the node and all the nodes under it.
+func (n *node) treeBytes() (keyBytes, valueBytes int64) {
+	keyBytes, valueBytes = int64(len(n.Path)), int64(len(n.Value))
+	for _, child := range n.Children {
+		kb, vb := child.treeBytes()
+		keyBytes += kb
+		valueBytes += vb
+	}
+	return keyBytes, valueBytes
+}
 
-	clone := newDir(n.store, n.Path, n.CreatedIndex, n.Parent, n.ExpireTime)
-	clone.ModifiedIndex = n.ModifiedIndex
+// usage returns the number of the nodes under the node and the total
+// size of their values.
+func (n *node) usage() (nodes, valueBytes int64) {
+	for _, child := range n.Children {
+		cn, cb := child.usage()
+		nodes += cn + 1
+		valueBytes += cb + int64(len(child.Value))
+	}
+	return nodes, valueBytes
+}
 
-	for key, child := range n.Children {
-		clone.Children[key] = child.Clone()
+// account adds the deltas to the key and value bytes of the store of
+// the node.
+func (n *node) account(keyDelta, valueDelta int64) {
+	if n.store != nil {
+		n.store.keyBytes += keyDelta
+		n.store.valueBytes += valueDelta
 	}
+}
+
+// accountNode adds the path and value of the node to the bytes of its
+// store if sign is 1, or subtracts them if sign is -1.
+func (n *node) accountNode(sign int64) {
+	n.account(sign*int64(len(n.Path)), sign*int64(len(n.Value)))
+}
 
-	return clone
+// accountTree is accountNode for the node and all the nodes under it.
+func (n *node) accountTree(sign int64) {
+	kb, vb := n.treeBytes()
+	n.account(sign*kb, sign*vb)
 }
 
 // recoverAndclean function help to do recovery.
@@ -396,7 +462,7 @@ func (n *node) recoverAndclean() {
 	}
 
 	if !n.ExpireTime.IsZero() {
-		n.store.ttlKeyHeap.push(n)
+		n.store.ttlWheel.push(n)
 	}
 
 }
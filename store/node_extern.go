@@ -16,6 +16,7 @@ package store
 
 import (
 	"sort"
+	"strings"
 	"time"
 
 	"github.com/coreos/etcd/Godeps/_workspace/src/github.com/jonboulle/clockwork"
@@ -71,6 +72,118 @@ func (eNode *NodeExtern) loadInternalNode(n *node, recursive, sorted bool, clock
 	eNode.Expiration, eNode.TTL = n.expirationAndTTL(clock)
 }
 
+// loadInternalNodePage loads at most limit nodes under n, in the order of
+// a sorted get, whose keys come after the key after. A limit of zero is
+// unlimited. The directories on the way to the loaded nodes are loaded
+// too, without being counted. It returns the key to continue from if more
+// nodes are left, or "".
+func (eNode *NodeExtern) loadInternalNodePage(n *node, recursive bool, limit int, after string, clock clockwork.Clock) string {
+	if !n.IsDir() {
+		eNode.loadInternalNode(n, recursive, true, clock)
+		return ""
+	}
+	p := &pager{recursive: recursive, after: after, left: limit, clock: clock}
+	if limit <= 0 {
+		p.left = -1
+	}
+	eNode.Dir = true
+	eNode.Nodes = make(NodeExterns, 0)
+	eNode.Expiration, eNode.TTL = n.expirationAndTTL(clock)
+	p.load(eNode, n)
+	if p.more {
+		return p.last
+	}
+	return ""
+}
+
+// pager walks the nodes in the order of a sorted get for a page.
+type pager struct {
+	recursive bool
+	after     string
+	// left is the number of nodes left for the page; -1 is unlimited.
+	left  int
+	last  string
+	more  bool
+	clock clockwork.Clock
+}
+
+func (p *pager) load(eNode *NodeExtern, n *node) {
+	children, _ := n.List()
+	sort.Sort(nodesByPath(children))
+	for _, child := range children {
+		if p.more {
+			return
+		}
+		if child.IsHidden() { // get will not return hidden nodes
+			continue
+		}
+		switch {
+		case child.Path == p.after || strings.HasPrefix(p.after, child.Path+"/"):
+			// the node is on a previous page, but the nodes under it may not be
+			if !p.recursive || !child.IsDir() {
+				continue
+			}
+			cn := child.Repr(false, false, p.clock)
+			p.load(cn, child)
+			if len(cn.Nodes) > 0 {
+				eNode.Nodes = append(eNode.Nodes, cn)
+			}
+		case p.after != "" && comparePath(child.Path, p.after) < 0:
+			continue
+		case p.left == 0:
+			p.more = true
+			return
+		default:
+			p.left--
+			p.last = child.Path
+			cn := child.Repr(false, false, p.clock)
+			if p.recursive && child.IsDir() {
+				p.load(cn, child)
+			}
+			eNode.Nodes = append(eNode.Nodes, cn)
+		}
+	}
+}
+
+// comparePath compares the keys in the order of a sorted recursive get,
+// where a directory comes right before the keys under it.
+func comparePath(a, b string) int {
+	as, bs := strings.Split(a, "/"), strings.Split(b, "/")
+	for i := 0; i < len(as) && i < len(bs); i++ {
+		if as[i] != bs[i] {
+			if as[i] < bs[i] {
+				return -1
+			}
+			return 1
+		}
+	}
+	return len(as) - len(bs)
+}
+
+type nodesByPath []*node
+
+func (ns nodesByPath) Len() int           { return len(ns) }
+func (ns nodesByPath) Less(i, j int) bool { return ns[i].Path < ns[j].Path }
+func (ns nodesByPath) Swap(i, j int)      { ns[i], ns[j] = ns[j], ns[i] }
+
+// inheritExpiration sets the expiration of the node and of the nodes
+// under it to the given expire time of the directories above them where
+// it is earlier than their own, as they are deleted with the directory
+// when it expires.
+// 子节点继承上层目录更早的过期时间
+func (eNode *NodeExtern) inheritExpiration(expireTime time.Time, clock clockwork.Clock) {
+	own := Permanent
+	if eNode.Expiration != nil {
+		own = *eNode.Expiration
+	}
+	if expireTime = earlierExpireTime(expireTime, own); !expireTime.IsZero() && !expireTime.Equal(own) {
+		eNode.Expiration, eNode.TTL = expirationAt(expireTime, clock)
+	}
+	for _, child := range eNode.Nodes {
+		child.inheritExpiration(expireTime, clock)
+	}
+}
+
 func (eNode *NodeExtern) Clone() *NodeExtern {
 	if eNode == nil {
 		return nil
@@ -0,0 +1,116 @@
+// Copyright 2015 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package store
+
+import (
+	etcdErr "github.com/coreos/etcd/error"
+)
+
+// DefaultRequestResults is the number of the results of the requests
+// applied with a token that a store remembers. The oldest results are
+// forgotten first.
+const DefaultRequestResults = 1000
+
+// RequestResult is the result of a request applied with a token. A
+// retry of the request with the same token gets the result back instead
+// of being applied again.
+// 带token的请求的应用结果，重试时直接返回该结果
+type RequestResult struct {
+	Token string
+	Event *Event         `json:",omitempty"`
+	Txn   *TxnResponse   `json:",omitempty"`
+	Err   *etcdErr.Error `json:",omitempty"`
+}
+
+func (r *RequestResult) clone() *RequestResult {
+	c := *r
+	if r.Event != nil {
+		c.Event = r.Event.Clone()
+	}
+	if r.Txn != nil {
+		txn := *r.Txn
+		txn.Events = make([]*Event, len(r.Txn.Events))
+		for i, e := range r.Txn.Events {
+			txn.Events[i] = e.Clone()
+		}
+		c.Txn = &txn
+	}
+	if r.Err != nil {
+		err := *r.Err
+		c.Err = &err
+	}
+	return &c
+}
+
+// requestResults are the last results of the requests applied with a
+// token, the oldest first. They are saved with the state of the store,
+// so that all the members, including the ones recovered from a state,
+// tell a retry from a new request the same way.
+type requestResults struct {
+	Results []*RequestResult
+
+	// byToken indexes the results by token. It is rebuilt by reindex
+	// after the results are decoded.
+	byToken map[string]*RequestResult
+}
+
+func newRequestResults() *requestResults {
+	return &requestResults{byToken: make(map[string]*RequestResult)}
+}
+
+func (rs *requestResults) get(token string) (*RequestResult, bool) {
+	r, ok := rs.byToken[token]
+	return r, ok
+}
+
+// add adds the result of a request. The result is not added if a result
+// with the same token is already remembered.
+func (rs *requestResults) add(r *RequestResult) {
+	if _, ok := rs.byToken[r.Token]; ok {
+		return
+	}
+	rs.Results = append(rs.Results, r)
+	rs.byToken[r.Token] = r
+	if n := len(rs.Results) - DefaultRequestResults; n > 0 {
+		for _, old := range rs.Results[:n] {
+			delete(rs.byToken, old.Token)
+		}
+		copy(rs.Results, rs.Results[n:])
+		for i := len(rs.Results) - n; i < len(rs.Results); i++ {
+			rs.Results[i] = nil
+		}
+		rs.Results = rs.Results[:len(rs.Results)-n]
+	}
+}
+
+// clone returns a copy of the results. The results themselves are never
+// modified, and so are shared.
+func (rs *requestResults) clone() *requestResults {
+	c := &requestResults{
+		Results: append([]*RequestResult(nil), rs.Results...),
+		byToken: make(map[string]*RequestResult, len(rs.byToken)),
+	}
+	for k, r := range rs.byToken {
+		c.byToken[k] = r
+	}
+	return c
+}
+
+func (rs *requestResults) reindex() {
+	rs.byToken = make(map[string]*RequestResult, len(rs.Results))
+	for _, r := range rs.Results {
+		rs.byToken[r.Token] = r
+	}
+}
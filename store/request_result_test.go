@@ -0,0 +1,40 @@
+// Copyright 2015 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package store
+
+import (
+	"fmt"
+	"testing"
+)
+
+// Ensure that only the last DefaultRequestResults results are remembered.
+func TestRequestResultsForgetOldest(t *testing.T) {
+	rs := newRequestResults()
+	for i := 0; i < DefaultRequestResults+10; i++ {
+		rs.add(&RequestResult{Token: fmt.Sprint(i)})
+	}
+	if len(rs.Results) != DefaultRequestResults || len(rs.byToken) != DefaultRequestResults {
+		t.Fatalf("len = %d, %d, want %d", len(rs.Results), len(rs.byToken), DefaultRequestResults)
+	}
+	for i := 0; i < DefaultRequestResults+10; i++ {
+		_, ok := rs.get(fmt.Sprint(i))
+		if w := i >= 10; ok != w {
+			t.Errorf("#%d: ok = %v, want %v", i, ok, w)
+		}
+	}
+	if g := rs.Results[0].Token; g != "10" {
+		t.Errorf("oldest token = %s, want 10", g)
+	}
+}
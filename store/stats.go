@@ -35,6 +35,14 @@ const (
 	ExpireCount
 	CompareAndDeleteSuccess
 	CompareAndDeleteFail
+	TxnSuccess
+	TxnFail
+	IncrSuccess
+	IncrFail
+	MoveSuccess
+	MoveFail
+	ImportSuccess
+	ImportFail
 )
 
 type Stats struct {
@@ -67,9 +75,43 @@ type Stats struct {
 	CompareAndDeleteSuccess uint64 `json:"compareAndDeleteSuccess"`
 	CompareAndDeleteFail    uint64 `json:"compareAndDeleteFail"`
 
+	// Number of txn requests
+	TxnSuccess uint64 `json:"txnSuccess"`
+	TxnFail    uint64 `json:"txnFail"`
+
+	// Number of incr requests
+	IncrSuccess uint64 `json:"incrSuccess"`
+	IncrFail    uint64 `json:"incrFail"`
+
+	// Number of move requests
+	MoveSuccess uint64 `json:"moveSuccess"`
+	MoveFail    uint64 `json:"moveFail"`
+
+	// Number of import requests
+	ImportSuccess uint64 `json:"importSuccess"`
+	ImportFail    uint64 `json:"importFail"`
+
 	ExpireCount uint64 `json:"expireCount"`
 
 	Watchers uint64 `json:"watchers"`
+
+	// Approximate memory used by the keys, the values and the event
+	// history, in bytes
+	KeyBytes     uint64 `json:"keyBytes"`
+	ValueBytes   uint64 `json:"valueBytes"`
+	HistoryBytes uint64 `json:"historyBytes"`
+}
+
+// MemoryUsage is the approximate memory used by the data of a store, in
+// bytes. It counts the sizes of the keys and values only, not the
+// overhead of the structures holding them.
+// 内存占用的估计值，只计算key和value的大小
+type MemoryUsage struct {
+	KeyBytes   int64
+	ValueBytes int64
+	// HistoryBytes is the size of the events kept for the watchers, and
+	// of the revisions of a mvcc store.
+	HistoryBytes int64
 }
 
 func newStats() *Stats {
@@ -93,8 +135,19 @@ func (s *Stats) clone() *Stats {
 		CompareAndSwapFail:      s.CompareAndSwapFail,
 		CompareAndDeleteSuccess: s.CompareAndDeleteSuccess,
 		CompareAndDeleteFail:    s.CompareAndDeleteFail,
+		TxnSuccess:              s.TxnSuccess,
+		TxnFail:                 s.TxnFail,
+		IncrSuccess:             s.IncrSuccess,
+		IncrFail:                s.IncrFail,
+		MoveSuccess:             s.MoveSuccess,
+		MoveFail:                s.MoveFail,
+		ImportSuccess:           s.ImportSuccess,
+		ImportFail:              s.ImportFail,
 		ExpireCount:             s.ExpireCount,
 		Watchers:                s.Watchers,
+		KeyBytes:                s.KeyBytes,
+		ValueBytes:              s.ValueBytes,
+		HistoryBytes:            s.HistoryBytes,
 	}
 }
 
@@ -103,6 +156,12 @@ func (s *Stats) toJson() []byte {
 	return b
 }
 
+func (s *Stats) setMemoryUsage(u MemoryUsage) {
+	s.KeyBytes = uint64(u.KeyBytes)
+	s.ValueBytes = uint64(u.ValueBytes)
+	s.HistoryBytes = uint64(u.HistoryBytes)
+}
+
 func (s *Stats) Inc(field int) {
 	switch field {
 	case SetSuccess:
@@ -133,7 +192,24 @@ func (s *Stats) Inc(field int) {
 		atomic.AddUint64(&s.CompareAndDeleteSuccess, 1)
 	case CompareAndDeleteFail:
 		atomic.AddUint64(&s.CompareAndDeleteFail, 1)
+	case TxnSuccess:
+		atomic.AddUint64(&s.TxnSuccess, 1)
+	case TxnFail:
+		atomic.AddUint64(&s.TxnFail, 1)
+	case IncrSuccess:
+		atomic.AddUint64(&s.IncrSuccess, 1)
+	case IncrFail:
+		atomic.AddUint64(&s.IncrFail, 1)
+	case MoveSuccess:
+		atomic.AddUint64(&s.MoveSuccess, 1)
+	case MoveFail:
+		atomic.AddUint64(&s.MoveFail, 1)
+	case ImportSuccess:
+		atomic.AddUint64(&s.ImportSuccess, 1)
+	case ImportFail:
+		atomic.AddUint64(&s.ImportFail, 1)
 	case ExpireCount:
 		atomic.AddUint64(&s.ExpireCount, 1)
 	}
+	reportOperation(field)
 }
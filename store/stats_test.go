@@ -15,6 +15,7 @@
 package store
 
 import (
+	"encoding/json"
 	"testing"
 	"time"
 
@@ -110,3 +111,29 @@ func TestStoreStatsExpireCount(t *testing.T) {
 	s.DeleteExpiredKeys(fc.Now())
 	assert.Equal(t, uint64(1), s.Stats.ExpireCount, "")
 }
+
+// Ensure that the memory usage follows the keys, the values and the
+// events kept by the history.
+func TestStoreStatsMemoryUsage(t *testing.T) {
+	s := newStore()
+	s.WatcherHub = newWatchHub(2)
+	e1, _ := s.Create("/foo", false, "bar", false, Permanent)
+	assert.Equal(t, MemoryUsage{KeyBytes: 5, ValueBytes: 3, HistoryBytes: eventBytes(e1)}, s.MemoryUsage(), "")
+
+	e2, _ := s.Set("/foo", false, "bazz", Permanent)
+	e3, _ := s.Delete("/foo", false, false)
+	// the first event is dropped from the history
+	assert.Equal(t, MemoryUsage{KeyBytes: 1, ValueBytes: 0, HistoryBytes: eventBytes(e2) + eventBytes(e3)}, s.MemoryUsage(), "")
+
+	var stats Stats
+	assert.Nil(t, json.Unmarshal(s.JsonStats(), &stats), "")
+	assert.Equal(t, uint64(1), stats.KeyBytes, "")
+	assert.Equal(t, uint64(eventBytes(e2)+eventBytes(e3)), stats.HistoryBytes, "")
+
+	// the recovered history is counted again
+	b, _ := s.Save()
+	s2 := newStore()
+	s2.WatcherHub = newWatchHub(2)
+	assert.Nil(t, s2.Recovery(b), "")
+	assert.Equal(t, s.MemoryUsage(), s2.MemoryUsage(), "")
+}
@@ -31,6 +31,14 @@ import (
 // The default version to set when the store is first initialized.
 const defaultVersion = 2
 
+// DefaultHistorySize is the default number of events kept for watchers.
+const DefaultHistorySize = 1000
+
+// defaultExpireBudget is the max number of the keys expired by a SYNC.
+// A SYNC that would expire more keys leaves them to the next ones, so
+// that a mass expiration does not stall the apply loop.
+const defaultExpireBudget = 10000
+
 var minExpireTime time.Time
 
 func init() {
@@ -42,16 +50,46 @@ type Store interface {
 	Index() uint64
 
 	Get(nodePath string, recursive, sorted bool) (*Event, error)
+	// GetPage gets at most limit nodes under the node path, sorted, whose
+	// keys come after the key after. Event.Continue is set to the key the
+	// next page continues from if more nodes are left. A limit of zero is
+	// unlimited.
+	GetPage(nodePath string, recursive bool, limit int, after string) (*Event, error)
 	Set(nodePath string, dir bool, value string, expireTime time.Time) (*Event, error)
 	Update(nodePath string, newValue string, expireTime time.Time) (*Event, error)
+	// Refresh resets the expire time of the node, keeping its value. It
+	// neither advances the index nor notifies the watchers.
+	Refresh(nodePath string, expireTime time.Time) (*Event, error)
 	Create(nodePath string, dir bool, value string, unique bool,
 		expireTime time.Time) (*Event, error)
 	CompareAndSwap(nodePath string, prevValue string, prevIndex uint64,
 		value string, expireTime time.Time) (*Event, error)
 	Delete(nodePath string, dir, recursive bool) (*Event, error)
 	CompareAndDelete(nodePath string, prevValue string, prevIndex uint64) (*Event, error)
-
-	Watch(prefix string, recursive, stream bool, sinceIndex uint64) (Watcher, error)
+	// CompareAndDeleteDir deletes the directory, and all the nodes under
+	// it if recursive, in a single operation if the compares hold: the
+	// modifiedIndex of the directory is prevIndex unless prevIndex is zero,
+	// and the value of the marker key, a path relative to the directory,
+	// is prevValue unless marker is empty.
+	CompareAndDeleteDir(nodePath, marker, prevValue string, prevIndex uint64, recursive bool) (*Event, error)
+	Txn(cmps []TxnCompare, success, failure []TxnOp) (*TxnResponse, error)
+	// Incr adds delta to the integer value of the node, and returns the
+	// node with the new value. A missing node is created with delta.
+	Incr(nodePath string, delta int64) (*Event, error)
+	// Move moves the node, and all the nodes under it, to the new path in
+	// a single operation. The moved nodes keep their indexes and ttls.
+	Move(nodePath, newPath string) (*Event, error)
+	// Export returns the node, and all the nodes under it including the
+	// hidden ones, with their values, indexes and expire times.
+	Export(nodePath string) (*Export, error)
+	// Import creates the nodes of the export at the path, which must not
+	// exist, in a single operation. The nodes keep their expire times,
+	// and are created at the index of the import.
+	Import(nodePath string, ex *Export) (*Event, error)
+
+	// Watch watches the prefix from sinceIndex. If actions is not empty,
+	// only the events of the given actions are sent to the watcher.
+	Watch(prefix string, recursive, stream bool, sinceIndex uint64, actions []string) (Watcher, error)
 
 	Save() ([]byte, error)
 	Recovery(state []byte) error
@@ -60,7 +98,49 @@ type Store interface {
 	SaveNoCopy() ([]byte, error)
 
 	JsonStats() []byte
+	// DeleteExpiredKeys deletes the keys that expire at or before cutoff.
+	// A call deletes a bounded number of keys, the ones that expire
+	// first; the others are deleted by the next calls.
 	DeleteExpiredKeys(cutoff time.Time)
+
+	// OldestWatchableIndex returns the smallest index a watcher can start
+	// from. Watching from an older index fails with EcodeEventIndexCleared.
+	OldestWatchableIndex() uint64
+	// CompactHistory drops the events at or before index from the event
+	// history, so that watching from them fails with
+	// EcodeEventIndexCleared.
+	CompactHistory(index uint64)
+
+	// Size returns the approximate size of the data kept by the store
+	// in bytes.
+	Size() int64
+	// Usage returns the number of the nodes under the directory, at any
+	// depth, and the total size of their values. The hidden nodes are
+	// counted as well.
+	Usage(nodePath string) (nodes, valueBytes int64, err error)
+	// MemoryUsage returns the approximate memory used by the data of the
+	// store.
+	MemoryUsage() MemoryUsage
+
+	// SetSnapshotFormat sets the format of the states saved by Save and
+	// SaveNoCopy, SnapshotFormatJSON or SnapshotFormatGob. Recovery
+	// accepts the states in either format.
+	SetSnapshotFormat(format string)
+
+	// ConsistentIndex returns the raft index of the last entry applied
+	// to the store. It is saved with the state of the store, so that the
+	// entries applied before a recovered state are not applied again.
+	ConsistentIndex() uint64
+	// SetConsistentIndex sets the raft index of the entry being applied.
+	SetConsistentIndex(index uint64)
+
+	// RequestResult returns the result of the request applied with the
+	// given token, if it is one of the last DefaultRequestResults
+	// results recorded.
+	RequestResult(token string) (*RequestResult, bool)
+	// RecordRequestResult records the result of a request applied with
+	// a token. The results are saved with the state of the store.
+	RecordRequestResult(r *RequestResult)
 }
 
 // store,负责存储键值对信息
@@ -70,15 +150,51 @@ type store struct {
 	CurrentIndex   uint64
 	Stats          *Stats
 	CurrentVersion int
-	ttlKeyHeap     *ttlKeyHeap  // need to recovery manually
+	ttlWheel       *ttlWheel    // need to recovery manually
 	worldLock      sync.RWMutex // stop the world lock
 	clock          clockwork.Clock
 	readonlySet    types.Set
+	// onEvent, if set, is called with every event after the watchers
+	// are notified. It is called with the world lock held.
+	onEvent func(e *Event)
+	// snapshots are the snapshots of the clones whose tree has not been
+	// copied yet, and of the reads in progress. The nodes are preserved
+	// for them before being modified. They are protected by snapshotsMu
+	// instead of the world lock, so that a read can take a snapshot with
+	// the world lock read-held.
+	snapshots   []*treeSnapshot
+	snapshotsMu sync.Mutex
+	// cow is the snapshot the tree of a clone is copied from.
+	cow *treeSnapshot
+	// keyBytes and valueBytes are the total size of the paths and of the
+	// values of the nodes in the tree. They are protected by the world
+	// lock.
+	keyBytes   int64
+	valueBytes int64
+	// expireBudget is the max number of the keys deleted by a call to
+	// DeleteExpiredKeys.
+	expireBudget int
+	// snapshotFormat is the format of the saved states.
+	snapshotFormat string
+
+	// RaftIndex is the consistent index, the raft index of the last entry
+	// applied to the store.
+	RaftIndex uint64
+	// RequestResults are the last results of the requests applied with
+	// a token.
+	RequestResults *requestResults
 }
 
 // The given namespaces will be created as initial directories in the returned store.
 func New(namespaces ...string) Store {
+	return NewWithHistorySize(DefaultHistorySize, namespaces...)
+}
+
+// NewWithHistorySize creates a store that keeps the given number of
+// events for watchers.
+func NewWithHistorySize(historySize int, namespaces ...string) Store {
 	s := newStore(namespaces...)
+	s.WatcherHub = newWatchHub(historySize)
 	s.clock = clockwork.NewRealClock()
 	return s
 }
@@ -90,9 +206,12 @@ func newStore(namespaces ...string) *store {
 	for _, namespace := range namespaces {
 		s.Root.Add(newDir(s, namespace, s.CurrentIndex, s.Root, Permanent))
 	}
+	s.keyBytes, s.valueBytes = s.Root.treeBytes()
 	s.Stats = newStats()
-	s.WatcherHub = newWatchHub(1000)
-	s.ttlKeyHeap = newTtlKeyHeap()
+	s.WatcherHub = newWatchHub(DefaultHistorySize)
+	s.RequestResults = newRequestResults()
+	s.ttlWheel = newTTLWheel()
+	s.expireBudget = defaultExpireBudget
 	s.readonlySet = types.NewUnsafeSet(append(namespaces, "/")...)
 	return s
 }
@@ -109,16 +228,60 @@ func (s *store) Index() uint64 {
 	return s.CurrentIndex
 }
 
+// Size returns the total size of the paths and values of the nodes.
+func (s *store) Size() int64 {
+	s.worldLock.RLock()
+	defer s.worldLock.RUnlock()
+	return s.keyBytes + s.valueBytes
+}
+
+func (s *store) MemoryUsage() MemoryUsage {
+	s.worldLock.RLock()
+	defer s.worldLock.RUnlock()
+	return MemoryUsage{
+		KeyBytes:     s.keyBytes,
+		ValueBytes:   s.valueBytes,
+		HistoryBytes: s.WatcherHub.EventHistory.historyBytes(),
+	}
+}
+
+// Usage returns the number of the nodes under the directory and the
+// total size of their values.
+func (s *store) Usage(nodePath string) (int64, int64, error) {
+	s.worldLock.RLock()
+	defer s.worldLock.RUnlock()
+
+	nodePath = path.Clean(path.Join("/", nodePath))
+	n, err := s.internalGet(nodePath)
+	if err != nil {
+		return 0, 0, err
+	}
+	if !n.IsDir() {
+		return 0, 0, etcdErr.NewError(etcdErr.EcodeNotDir, nodePath, s.CurrentIndex)
+	}
+	nodes, valueBytes := n.usage()
+	return nodes, valueBytes, nil
+}
+
+func (s *store) OldestWatchableIndex() uint64 {
+	s.worldLock.RLock()
+	defer s.worldLock.RUnlock()
+	return s.WatcherHub.EventHistory.oldestIndex()
+}
+
+func (s *store) CompactHistory(index uint64) {
+	s.worldLock.Lock()
+	defer s.worldLock.Unlock()
+	s.WatcherHub.EventHistory.compact(index)
+}
+
 // Get returns a get event.
 // If recursive is true, it will return all the content under the node path.
 // If sorted is true, it will sort the content by keys.
 func (s *store) Get(nodePath string, recursive, sorted bool) (*Event, error) {
-	s.worldLock.RLock()
-	defer s.worldLock.RUnlock()
-
 	nodePath = path.Clean(path.Join("/", nodePath))
 
-	n, err := s.internalGet(nodePath)
+	n, inherited, index, err := s.readNode(nodePath, recursive)
 
 	if err != nil {
 		s.Stats.Inc(GetFail)
@@ -126,8 +289,30 @@ func (s *store) Get(nodePath string, recursive, sorted bool) (*Event, error) {
 	}
 
 	e := newEvent(Get, nodePath, n.ModifiedIndex, n.CreatedIndex)
-	e.EtcdIndex = s.CurrentIndex
+	e.EtcdIndex = index
 	e.Node.loadInternalNode(n, recursive, sorted, s.clock)
+	e.Node.inheritExpiration(inherited, s.clock)
+
+	s.Stats.Inc(GetSuccess)
+
+	return e, nil
+}
+
+// GetPage returns a get event with a page of the content under the node path.
+func (s *store) GetPage(nodePath string, recursive bool, limit int, after string) (*Event, error) {
+	nodePath = path.Clean(path.Join("/", nodePath))
+
+	n, inherited, index, err := s.readNode(nodePath, recursive)
+
+	if err != nil {
+		s.Stats.Inc(GetFail)
+		return nil, err
+	}
+
+	e := newEvent(Get, nodePath, n.ModifiedIndex, n.CreatedIndex)
+	e.EtcdIndex = index
+	e.Continue = e.Node.loadInternalNodePage(n, recursive, limit, after, s.clock)
+	e.Node.inheritExpiration(inherited, s.clock)
 
 	s.Stats.Inc(GetSuccess)
 
@@ -144,7 +329,7 @@ func (s *store) Create(nodePath string, dir bool, value string, unique bool, exp
 
 	if err == nil {
 		e.EtcdIndex = s.CurrentIndex
-		s.WatcherHub.notify(e)
+		s.notify(e)
 		s.Stats.Inc(CreateSuccess)
 	} else {
 		s.Stats.Inc(CreateFail)
@@ -189,7 +374,7 @@ func (s *store) Set(nodePath string, dir bool, value string, expireTime time.Tim
 		e.PrevNode = prev.Node
 	}
 
-	s.WatcherHub.notify(e)
+	s.notify(e)
 
 	return e, nil
 }
@@ -255,18 +440,254 @@ func (s *store) CompareAndSwap(nodePath string, prevValue string, prevIndex uint
 	eNode.Value = &valueCopy
 	eNode.Expiration, eNode.TTL = n.expirationAndTTL(s.clock)
 
-	s.WatcherHub.notify(e)
+	s.notify(e)
 	s.Stats.Inc(CompareAndSwapSuccess)
 
 	return e, nil
 }
 
+func (s *store) Incr(nodePath string, delta int64) (*Event, error) {
+	s.worldLock.Lock()
+	defer s.worldLock.Unlock()
+
+	nodePath = path.Clean(path.Join("/", nodePath))
+	// we do not allow the user to change "/"
+	if s.readonlySet.Contains(nodePath) {
+		return nil, etcdErr.NewError(etcdErr.EcodeRootROnly, "/", s.CurrentIndex)
+	}
+
+	n, err := s.internalGet(nodePath)
+	if err != nil {
+		if err.ErrorCode != etcdErr.EcodeKeyNotFound {
+			s.Stats.Inc(IncrFail)
+			return nil, err
+		}
+		// a missing node counts as zero
+		e, err := s.internalCreate(nodePath, false, strconv.FormatInt(delta, 10), false, false, Permanent, Incr)
+		if err != nil {
+			s.Stats.Inc(IncrFail)
+			return nil, err
+		}
+		e.EtcdIndex = s.CurrentIndex
+		s.notify(e)
+		s.Stats.Inc(IncrSuccess)
+		return e, nil
+	}
+
+	if n.IsDir() { // can only incr file
+		s.Stats.Inc(IncrFail)
+		return nil, etcdErr.NewError(etcdErr.EcodeNotFile, nodePath, s.CurrentIndex)
+	}
+	value, nerr := addInt(n.Value, delta)
+	if nerr != nil {
+		s.Stats.Inc(IncrFail)
+		return nil, etcdErr.NewError(etcdErr.EcodeValueNaN, nodePath, s.CurrentIndex)
+	}
+
+	// update etcd index
+	s.CurrentIndex++
+
+	e := newEvent(Incr, nodePath, s.CurrentIndex, n.CreatedIndex)
+	e.EtcdIndex = s.CurrentIndex
+	e.PrevNode = n.Repr(false, false, s.clock)
+	eNode := e.Node
+
+	n.Write(value, s.CurrentIndex)
+
+	eNode.Value = &value
+	eNode.Expiration, eNode.TTL = n.expirationAndTTL(s.clock)
+
+	s.notify(e)
+	s.Stats.Inc(IncrSuccess)
+
+	return e, nil
+}
+
+func (s *store) Move(nodePath, newPath string) (*Event, error) {
+	s.worldLock.Lock()
+	defer s.worldLock.Unlock()
+
+	nodePath = path.Clean(path.Join("/", nodePath))
+	newPath = path.Clean(path.Join("/", newPath))
+	// we do not allow the user to change "/"
+	if s.readonlySet.Contains(nodePath) || s.readonlySet.Contains(newPath) {
+		return nil, etcdErr.NewError(etcdErr.EcodeRootROnly, "/", s.CurrentIndex)
+	}
+	if strings.HasPrefix(newPath, nodePath+"/") {
+		s.Stats.Inc(MoveFail)
+		return nil, etcdErr.NewError(etcdErr.EcodeInvalidField, "cannot move "+nodePath+" under itself", s.CurrentIndex)
+	}
+
+	n, err := s.internalGet(nodePath)
+	if err != nil { // if the node does not exist, return error
+		s.Stats.Inc(MoveFail)
+		return nil, err
+	}
+	if _, err := s.internalGet(newPath); err == nil {
+		s.Stats.Inc(MoveFail)
+		return nil, etcdErr.NewError(etcdErr.EcodeNodeExist, newPath, s.CurrentIndex)
+	}
+
+	nextIndex := s.CurrentIndex + 1
+	dirName, newName := path.Split(newPath)
+
+	// walk through the newPath, create dirs and get the last directory node
+	d, err := s.walk(dirName, s.checkDir)
+	if err != nil {
+		s.Stats.Inc(MoveFail)
+		err.Index = s.CurrentIndex
+		return nil, err
+	}
+
+	e := newEvent(Move, newPath, nextIndex, n.CreatedIndex)
+	e.EtcdIndex = nextIndex
+	e.PrevNode = n.Repr(false, false, s.clock)
+	eNode := e.Node
+
+	// detach the node from its old parent, and rename it and the nodes
+	// under it
+	var moved []string
+	var rename func(c *node, p string)
+	rename = func(c *node, p string) {
+		moved = append(moved, c.Path)
+		c.preserve()
+		c.Path = p
+		for name, child := range c.Children {
+			rename(child, path.Join(p, name))
+		}
+	}
+	_, name := path.Split(nodePath)
+	n.Parent.preserve()
+	delete(n.Parent.Children, name)
+	n.accountTree(-1)
+	rename(n, newPath)
+	n.Parent = d
+	d.preserve()
+	d.Children[newName] = n
+	n.accountTree(1)
+
+	if n.IsDir() {
+		eNode.Dir = true
+	} else {
+		// copy the value for safety
+		valueCopy := n.Value
+		eNode.Value = &valueCopy
+	}
+	eNode.Expiration, eNode.TTL = n.expirationAndTTL(s.clock)
+
+	s.CurrentIndex = nextIndex
+
+	s.WatcherHub.notifyMove(e, moved)
+	s.notify(e)
+	s.Stats.Inc(MoveSuccess)
+
+	return e, nil
+}
+
+func (s *store) Export(nodePath string) (*Export, error) {
+	n, _, index, err := s.readNode(path.Clean(path.Join("/", nodePath)), true)
+	if err != nil {
+		return nil, err
+	}
+	return &Export{Version: ExportVersion, Index: index, Node: exportNode(n, s.clock)}, nil
+}
+
+func (s *store) Import(nodePath string, ex *Export) (*Event, error) {
+	s.worldLock.Lock()
+	defer s.worldLock.Unlock()
+
+	nodePath = path.Clean(path.Join("/", nodePath))
+	// we do not allow the user to change "/"
+	if s.readonlySet.Contains(nodePath) {
+		return nil, etcdErr.NewError(etcdErr.EcodeRootROnly, "/", s.CurrentIndex)
+	}
+	if err := ex.Validate(); err != nil {
+		s.Stats.Inc(ImportFail)
+		return nil, etcdErr.NewError(etcdErr.EcodeInvalidField, err.Error(), s.CurrentIndex)
+	}
+	if _, err := s.internalGet(nodePath); err == nil {
+		s.Stats.Inc(ImportFail)
+		return nil, etcdErr.NewError(etcdErr.EcodeNodeExist, nodePath, s.CurrentIndex)
+	}
+
+	nextIndex := s.CurrentIndex + 1
+	dirName, name := path.Split(nodePath)
+
+	// walk through the nodePath, create dirs and get the last directory node
+	d, err := s.walk(dirName, s.checkDir)
+	if err != nil {
+		s.Stats.Inc(ImportFail)
+		err.Index = s.CurrentIndex
+		return nil, err
+	}
+
+	// build the imported nodes, all of them at the index of the import
+	nodes := map[string]*node{path.Clean(dirName): d}
+	walkExport(ex.Node, nodePath, func(p string, en *NodeExtern) error {
+		parent := nodes[path.Dir(p)]
+		var n *node
+		if en.Dir {
+			n = newDir(s, p, nextIndex, parent, exportExpireTime(en))
+			nodes[p] = n
+		} else {
+			n = newKV(s, p, *en.Value, nextIndex, parent, exportExpireTime(en))
+		}
+		parent.preserve()
+		parent.Children[path.Base(p)] = n
+		n.accountNode(1)
+		if !n.IsPermanent() {
+			s.ttlWheel.push(n)
+		}
+		return nil
+	})
+	n := d.Children[name]
+
+	e := newEvent(Import, nodePath, nextIndex, nextIndex)
+	e.EtcdIndex = nextIndex
+	eNode := e.Node
+	if n.IsDir() {
+		eNode.Dir = true
+	} else {
+		// copy the value for safety
+		valueCopy := n.Value
+		eNode.Value = &valueCopy
+	}
+	eNode.Expiration, eNode.TTL = n.expirationAndTTL(s.clock)
+
+	s.CurrentIndex = nextIndex
+
+	s.notify(e)
+	s.Stats.Inc(ImportSuccess)
+
+	return e, nil
+}
+
+// addInt adds delta to the integer value. It fails if the value is not
+// an integer, or if the sum overflows.
+func addInt(value string, delta int64) (string, error) {
+	v, err := strconv.ParseInt(value, 10, 64)
+	if err != nil {
+		return "", err
+	}
+	sum := v + delta
+	if (delta > 0 && sum < v) || (delta < 0 && sum > v) {
+		return "", strconv.ErrRange
+	}
+	return strconv.FormatInt(sum, 10), nil
+}
+
 // Delete deletes the node at the given path.
 // If the node is a directory, recursive must be true to delete it.
 func (s *store) Delete(nodePath string, dir, recursive bool) (*Event, error) {
 	s.worldLock.Lock()
 	defer s.worldLock.Unlock()
 
+	return s.internalDelete(nodePath, dir, recursive)
+}
+
+// internalDelete deletes the node at the given path. The caller must
+// hold the world lock.
+func (s *store) internalDelete(nodePath string, dir, recursive bool) (*Event, error) {
 	nodePath = path.Clean(path.Join("/", nodePath))
 	// we do not allow the user to change "/"
 	if s.readonlySet.Contains(nodePath) {
@@ -310,7 +731,7 @@ func (s *store) Delete(nodePath string, dir, recursive bool) (*Event, error) {
 	// update etcd index
 	s.CurrentIndex++
 
-	s.WatcherHub.notify(e)
+	s.notify(e)
 
 	s.Stats.Inc(DeleteSuccess)
 
@@ -360,13 +781,106 @@ func (s *store) CompareAndDelete(nodePath string, prevValue string, prevIndex ui
 		return nil, err
 	}
 
-	s.WatcherHub.notify(e)
+	s.notify(e)
 	s.Stats.Inc(CompareAndDeleteSuccess)
 
 	return e, nil
 }
 
-func (s *store) Watch(key string, recursive, stream bool, sinceIndex uint64) (Watcher, error) {
+func (s *store) CompareAndDeleteDir(nodePath, marker, prevValue string, prevIndex uint64, recursive bool) (*Event, error) {
+	nodePath = path.Clean(path.Join("/", nodePath))
+
+	s.worldLock.Lock()
+	defer s.worldLock.Unlock()
+
+	// we do not allow the user to change "/"
+	if s.readonlySet.Contains(nodePath) {
+		s.Stats.Inc(CompareAndDeleteFail)
+		return nil, etcdErr.NewError(etcdErr.EcodeRootROnly, "/", s.CurrentIndex)
+	}
+
+	n, err := s.internalGet(nodePath)
+	if err != nil {
+		s.Stats.Inc(CompareAndDeleteFail)
+		return nil, err
+	}
+	if !n.IsDir() {
+		s.Stats.Inc(CompareAndDeleteFail)
+		return nil, etcdErr.NewError(etcdErr.EcodeNotDir, nodePath, s.CurrentIndex)
+	}
+
+	var markerExists bool
+	var markerValue string
+	if marker != "" {
+		markerPath, err := markerPath(nodePath, marker, s.CurrentIndex)
+		if err != nil {
+			s.Stats.Inc(CompareAndDeleteFail)
+			return nil, err
+		}
+		// a missing marker fails the compare like a different value
+		if m, _ := s.internalGet(markerPath); m != nil && !m.IsDir() {
+			markerExists, markerValue = true, m.Value
+		}
+	}
+	if cause, ok := compareDir(n.ModifiedIndex, markerExists, markerValue, prevValue, prevIndex, marker); !ok {
+		s.Stats.Inc(CompareAndDeleteFail)
+		return nil, etcdErr.NewError(etcdErr.EcodeTestFailed, cause, s.CurrentIndex)
+	}
+	if !recursive && len(n.Children) != 0 {
+		s.Stats.Inc(CompareAndDeleteFail)
+		return nil, etcdErr.NewError(etcdErr.EcodeDirNotEmpty, nodePath, s.CurrentIndex)
+	}
+
+	s.CurrentIndex++
+
+	e := newEvent(CompareAndDelete, nodePath, s.CurrentIndex, n.CreatedIndex)
+	e.EtcdIndex = s.CurrentIndex
+	e.PrevNode = n.Repr(false, false, s.clock)
+	e.Node.Dir = true
+
+	callback := func(path string) { // notify function
+		// notify the watchers with deleted set true
+		s.WatcherHub.notifyWatchers(e, path, true)
+	}
+
+	if err := n.Remove(true, recursive, callback); err != nil {
+		return nil, err
+	}
+
+	s.notify(e)
+	s.Stats.Inc(CompareAndDeleteSuccess)
+
+	return e, nil
+}
+
+// markerPath returns the path of the marker key of a CompareAndDeleteDir,
+// which must be under the directory.
+func markerPath(dirPath, marker string, index uint64) (string, *etcdErr.Error) {
+	p := path.Join(dirPath, marker)
+	if !strings.HasPrefix(p, dirPath+"/") {
+		return "", etcdErr.NewError(etcdErr.EcodeInvalidField, "marker "+marker+" is not under "+dirPath, index)
+	}
+	return p, nil
+}
+
+// compareDir checks the compares of a CompareAndDeleteDir, and returns
+// the cause of the failure if they do not hold.
+func compareDir(modifiedIndex uint64, markerExists bool, markerValue, prevValue string, prevIndex uint64, marker string) (string, bool) {
+	var causes []string
+	if prevIndex != 0 && prevIndex != modifiedIndex {
+		causes = append(causes, fmt.Sprintf("[%v != %v]", prevIndex, modifiedIndex))
+	}
+	if marker != "" {
+		if !markerExists {
+			causes = append(causes, fmt.Sprintf("[marker %v not found]", marker))
+		} else if prevValue != markerValue {
+			causes = append(causes, fmt.Sprintf("[%v != %v]", prevValue, markerValue))
+		}
+	}
+	return strings.Join(causes, " "), len(causes) == 0
+}
+
+func (s *store) Watch(key string, recursive, stream bool, sinceIndex uint64, actions []string) (Watcher, error) {
 	s.worldLock.RLock()
 	defer s.worldLock.RUnlock()
 
@@ -375,7 +889,7 @@ func (s *store) Watch(key string, recursive, stream bool, sinceIndex uint64) (Wa
 		sinceIndex = s.CurrentIndex + 1
 	}
 	// WatchHub does not know about the current index, so we need to pass it in
-	w, err := s.WatcherHub.watch(key, recursive, stream, sinceIndex, s.CurrentIndex)
+	w, err := s.WatcherHub.watch(key, recursive, stream, sinceIndex, s.CurrentIndex, actions)
 	if err != nil {
 		return nil, err
 	}
@@ -387,7 +901,7 @@ func (s *store) Watch(key string, recursive, stream bool, sinceIndex uint64) (Wa
 func (s *store) walk(nodePath string, walkFunc func(prev *node, component string) (*node, *etcdErr.Error)) (*node, *etcdErr.Error) {
 	components := strings.Split(nodePath, "/")
 
-	curr := s.Root
+	curr := s.root()
 	var err *etcdErr.Error
 
 	for i := 1; i < len(components); i++ {
@@ -453,7 +967,7 @@ func (s *store) Update(nodePath string, newValue string, expireTime time.Time) (
 
 	eNode.Expiration, eNode.TTL = n.expirationAndTTL(s.clock)
 
-	s.WatcherHub.notify(e)
+	s.notify(e)
 
 	s.Stats.Inc(UpdateSuccess)
 
@@ -462,6 +976,38 @@ func (s *store) Update(nodePath string, newValue string, expireTime time.Time) (
 	return e, nil
 }
 
+func (s *store) Refresh(nodePath string, expireTime time.Time) (*Event, error) {
+	s.worldLock.Lock()
+	defer s.worldLock.Unlock()
+
+	nodePath = path.Clean(path.Join("/", nodePath))
+	// we do not allow the user to change "/"
+	if s.readonlySet.Contains(nodePath) {
+		return nil, etcdErr.NewError(etcdErr.EcodeRootROnly, "/", s.CurrentIndex)
+	}
+
+	n, err := s.internalGet(nodePath)
+	if err != nil { // if the node does not exist, return error
+		s.Stats.Inc(UpdateFail)
+		return nil, err
+	}
+
+	e := &Event{
+		Action:    Update,
+		PrevNode:  n.Repr(false, false, s.clock),
+		EtcdIndex: s.CurrentIndex,
+	}
+
+	// 只更新ttl, 不写value也不通知watcher
+	n.UpdateTTL(expireTime)
+
+	e.Node = n.Repr(false, false, s.clock)
+
+	s.Stats.Inc(UpdateSuccess)
+
+	return e, nil
+}
+
 func (s *store) internalCreate(nodePath string, dir bool, value string, unique, replace bool,
 	expireTime time.Time, action string) (*Event, error) {
 
@@ -532,7 +1078,7 @@ func (s *store) internalCreate(nodePath string, dir bool, value string, unique,
 
 	// node with TTL
 	if !n.IsPermanent() {
-		s.ttlKeyHeap.push(n)
+		s.ttlWheel.push(n)
 
 		eNode.Expiration, eNode.TTL = n.expirationAndTTL(s.clock)
 	}
@@ -569,16 +1115,58 @@ func (s *store) internalGet(nodePath string) (*node, *etcdErr.Error) {
 	return f, nil
 }
 
-// deleteExpiredKyes will delete all
+// readNode returns a copy of the node at the given path for a read, the
+// earliest expire time of the directories above it, and the index of the
+// store at the read. The world lock is only held to find
+// the node: for a directory, a snapshot of the tree is taken, and the
+// nodes under it are copied from the snapshot after the lock is released,
+// so that a large read neither blocks the writes nor is blocked by them.
+// The nodes under a directory are all copied if recursive, and only its
+// children otherwise.
+// 只在查找节点时持有读锁，目录的内容从快照中拷贝，大的读请求不会阻塞写
+func (s *store) readNode(nodePath string, recursive bool) (*node, time.Time, uint64, *etcdErr.Error) {
+	s.worldLock.RLock()
+	n, err := s.internalGet(nodePath)
+	index := s.CurrentIndex
+	if err != nil {
+		s.worldLock.RUnlock()
+		return nil, Permanent, index, err
+	}
+	inherited := n.ancestorsExpireTime()
+	if !n.IsDir() {
+		n = n.shallowCopy()
+		s.worldLock.RUnlock()
+		return n, inherited, index, nil
+	}
+	sn := newTreeSnapshot(s, n, index)
+	s.addSnapshot(sn)
+	s.worldLock.RUnlock()
+	defer s.releaseSnapshot(sn)
+
+	depth := 1
+	if recursive {
+		depth = -1
+	}
+	return sn.copyNode(nil, n, nil, depth), inherited, index, nil
+}
+
+// DeleteExpiredKeys deletes the keys that expire at or before cutoff, in
+// the order of their expire time, up to the expire budget. The keys left
+// are deleted by the next calls.
 func (s *store) DeleteExpiredKeys(cutoff time.Time) {
 	s.worldLock.Lock()
 	defer s.worldLock.Unlock()
 
-	for {
-		node := s.ttlKeyHeap.top()
-		if node == nil || node.ExpireTime.After(cutoff) {
+	deleted := 0
+	for _, node := range s.ttlWheel.expired(cutoff) {
+		if deleted == s.expireBudget {
 			break
 		}
+		// the node has been deleted with its expired parent
+		if !s.ttlWheel.contains(node) {
+			continue
+		}
+		deleted++
 
 		s.CurrentIndex++
 		e := newEvent(Expire, node.Path, s.CurrentIndex, node.CreatedIndex)
@@ -590,16 +1178,25 @@ func (s *store) DeleteExpiredKeys(cutoff time.Time) {
 			s.WatcherHub.notifyWatchers(e, path, true)
 		}
 
-		s.ttlKeyHeap.pop()
+		s.ttlWheel.remove(node)
 		node.Remove(true, true, callback)
 
 		s.Stats.Inc(ExpireCount)
 
-		s.WatcherHub.notify(e)
+		s.notify(e)
 	}
 
 }
 
+// notify notifies the watchers of the given event and passes it to
+// the onEvent hook.
+func (s *store) notify(e *Event) {
+	s.WatcherHub.notify(e)
+	if s.onEvent != nil {
+		s.onEvent(e)
+	}
+}
+
 // checkDir will check whether the component is a directory under parent node.
 // If it is a directory, this function will return the pointer to that node.
 // If it does not exist, this function will create a new directory and return the pointer to that node.
@@ -617,7 +1214,9 @@ func (s *store) checkDir(parent *node, dirName string) (*node, *etcdErr.Error) {
 
 	n := newDir(s, path.Join(parent.Path, dirName), s.CurrentIndex+1, parent, Permanent)
 
+	parent.preserve()
 	parent.Children[dirName] = n
+	n.accountNode(1)
 
 	return n, nil
 }
@@ -627,15 +1226,18 @@ func (s *store) checkDir(parent *node, dirName string) (*node, *etcdErr.Error) {
 // It will not save the parent field of the node. Or there will
 // be cyclic dependencies issue for the json package.
 func (s *store) Save() ([]byte, error) {
-	b, err := json.Marshal(s.Clone())
-	if err != nil {
-		return nil, err
-	}
-
-	return b, nil
+	return s.Clone().SaveNoCopy()
 }
 
 func (s *store) SaveNoCopy() ([]byte, error) {
+	s.root()
+	if s.snapshotFormat == SnapshotFormatGob {
+		st, err := s.gobState()
+		if err != nil {
+			return nil, err
+		}
+		return encodeGobState(st)
+	}
 	b, err := json.Marshal(s)
 	if err != nil {
 		return nil, err
@@ -644,17 +1246,65 @@ func (s *store) SaveNoCopy() ([]byte, error) {
 	return b, nil
 }
 
+func (s *store) SetSnapshotFormat(format string) {
+	s.worldLock.Lock()
+	defer s.worldLock.Unlock()
+	s.snapshotFormat = format
+}
+
+func (s *store) ConsistentIndex() uint64 {
+	s.worldLock.RLock()
+	defer s.worldLock.RUnlock()
+	return s.RaftIndex
+}
+
+func (s *store) SetConsistentIndex(index uint64) {
+	s.worldLock.Lock()
+	defer s.worldLock.Unlock()
+	s.RaftIndex = index
+}
+
+func (s *store) RequestResult(token string) (*RequestResult, bool) {
+	s.worldLock.RLock()
+	defer s.worldLock.RUnlock()
+	r, ok := s.RequestResults.get(token)
+	if !ok {
+		return nil, false
+	}
+	return r.clone(), true
+}
+
+func (s *store) RecordRequestResult(r *RequestResult) {
+	s.worldLock.Lock()
+	defer s.worldLock.Unlock()
+	s.RequestResults.add(r.clone())
+}
+
 func (s *store) Clone() Store {
 	s.worldLock.Lock()
+	defer s.worldLock.Unlock()
+	return s.clone()
+}
+
+// clone clones the static state of the store. The caller must hold
+// the world lock.
+// The tree is not copied here, which would block the store for a large
+// tree. The clone takes a snapshot of it instead, and copies the tree
+// from the snapshot on first use.
+func (s *store) clone() *store {
+	sn := newTreeSnapshot(s, s.root(), s.CurrentIndex)
+	s.addSnapshot(sn)
 
 	clonedStore := newStore()
 	clonedStore.CurrentIndex = s.CurrentIndex
-	clonedStore.Root = s.Root.Clone()
+	clonedStore.Root = nil
+	clonedStore.cow = sn
 	clonedStore.WatcherHub = s.WatcherHub.clone()
 	clonedStore.Stats = s.Stats.clone()
+	clonedStore.snapshotFormat = s.snapshotFormat
 	clonedStore.CurrentVersion = s.CurrentVersion
-
-	s.worldLock.Unlock()
+	clonedStore.RaftIndex = s.RaftIndex
+	clonedStore.RequestResults = s.RequestResults.clone()
 	return clonedStore
 }
 
@@ -665,19 +1315,75 @@ func (s *store) Clone() Store {
 func (s *store) Recovery(state []byte) error {
 	s.worldLock.Lock()
 	defer s.worldLock.Unlock()
-	err := json.Unmarshal(state, s)
+	// release the snapshot of a clone before its tree is replaced
+	s.root()
+	// the saved history has the size of the store that saved it
+	historySize := s.WatcherHub.EventHistory.Queue.Capacity
+	// decode the tree into new nodes; the snapshots in progress keep
+	// reading the old ones
+	s.Root = nil
+	_, err := s.decodeState(state, s)
 
 	if err != nil {
 		return err
 	}
 
-	s.ttlKeyHeap = newTtlKeyHeap()
+	s.WatcherHub.EventHistory.resize(historySize)
+
+	s.ttlWheel = newTTLWheel()
 
+	s.Root.store = s
 	s.Root.recoverAndclean()
+	s.keyBytes, s.valueBytes = s.Root.treeBytes()
 	return nil
 }
 
+// root returns the root node. The tree of a clone is copied from its
+// snapshot on first use.
+func (s *store) root() *node {
+	if s.cow != nil {
+		s.cow.once.Do(func() {
+			s.Root = s.cow.copyTree(s)
+			s.keyBytes, s.valueBytes = s.Root.treeBytes()
+			s.cow.src.releaseSnapshot(s.cow)
+		})
+	}
+	return s.Root
+}
+
+// preserve saves the node for the snapshots in progress before it is
+// modified. The caller must hold the world lock.
+func (s *store) preserve(n *node) {
+	s.snapshotsMu.Lock()
+	defer s.snapshotsMu.Unlock()
+	for _, sn := range s.snapshots {
+		sn.preserve(n)
+	}
+}
+
+// addSnapshot starts preserving the nodes for the given snapshot. The
+// caller must hold the world lock, at least for reading, so that the
+// tree does not change while the snapshot is taken.
+func (s *store) addSnapshot(sn *treeSnapshot) {
+	s.snapshotsMu.Lock()
+	defer s.snapshotsMu.Unlock()
+	s.snapshots = append(s.snapshots, sn)
+}
+
+// releaseSnapshot stops preserving the nodes for the given snapshot.
+func (s *store) releaseSnapshot(sn *treeSnapshot) {
+	s.snapshotsMu.Lock()
+	defer s.snapshotsMu.Unlock()
+	for i := range s.snapshots {
+		if s.snapshots[i] == sn {
+			s.snapshots = append(s.snapshots[:i], s.snapshots[i+1:]...)
+			break
+		}
+	}
+}
+
 func (s *store) JsonStats() []byte {
 	s.Stats.Watchers = uint64(s.WatcherHub.count)
+	s.Stats.setMemoryUsage(s.MemoryUsage())
 	return s.Stats.toJson()
 }
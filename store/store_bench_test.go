@@ -18,6 +18,7 @@ import (
 	"encoding/json"
 	"fmt"
 	"runtime"
+	"strings"
 	"testing"
 )
 
@@ -45,6 +46,37 @@ func BenchmarkStoreSetWithJson4096Bytes(b *testing.B) {
 	benchStoreSet(b, 4096, json.Marshal)
 }
 
+func BenchmarkStoreRecoveryJSON(b *testing.B) {
+	benchStoreRecovery(b, SnapshotFormatJSON)
+}
+
+func BenchmarkStoreRecoveryGob(b *testing.B) {
+	benchStoreRecovery(b, SnapshotFormatGob)
+}
+
+// benchStoreRecovery benchmarks the recovery of a store of 10000 keys
+// from a state saved in the given format.
+func benchStoreRecovery(b *testing.B, format string) {
+	s := newStore()
+	value := strings.Repeat("v", 128)
+	for i := 0; i < 10000; i++ {
+		s.Set(fmt.Sprintf("/foo/%d/%d", i/100, i), false, value, Permanent)
+	}
+	s.SetSnapshotFormat(format)
+	state, err := s.Save()
+	if err != nil {
+		b.Fatal(err)
+	}
+	b.SetBytes(int64(len(state)))
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		if err := newStore().Recovery(state); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
 func BenchmarkStoreDelete(b *testing.B) {
 	b.StopTimer()
 
@@ -107,7 +139,7 @@ func BenchmarkWatch(b *testing.B) {
 	runtime.ReadMemStats(memStats)
 
 	for i := 0; i < b.N; i++ {
-		w, _ := s.Watch(kvs[i][0], false, false, 0)
+		w, _ := s.Watch(kvs[i][0], false, false, 0, nil)
 
 		e := newEvent("set", kvs[i][0], uint64(i+1), uint64(i+1))
 		s.WatcherHub.notify(e)
@@ -130,7 +162,7 @@ func BenchmarkWatchWithSet(b *testing.B) {
 	b.StartTimer()
 
 	for i := 0; i < b.N; i++ {
-		w, _ := s.Watch(kvs[i][0], false, false, 0)
+		w, _ := s.Watch(kvs[i][0], false, false, 0, nil)
 
 		s.Set(kvs[i][0], false, "test", Permanent)
 		<-w.EventChan()
@@ -146,7 +178,7 @@ func BenchmarkWatchWithSetBatch(b *testing.B) {
 	watchers := make([]Watcher, b.N)
 
 	for i := 0; i < b.N; i++ {
-		watchers[i], _ = s.Watch(kvs[i][0], false, false, 0)
+		watchers[i], _ = s.Watch(kvs[i][0], false, false, 0, nil)
 	}
 
 	for i := 0; i < b.N; i++ {
@@ -164,7 +196,7 @@ func BenchmarkWatchOneKey(b *testing.B) {
 	watchers := make([]Watcher, b.N)
 
 	for i := 0; i < b.N; i++ {
-		watchers[i], _ = s.Watch("/foo", false, false, 0)
+		watchers[i], _ = s.Watch("/foo", false, false, 0, nil)
 	}
 
 	s.Set("/foo", false, "", Permanent)
@@ -15,6 +15,10 @@
 package store
 
 import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sync"
 	"testing"
 	"time"
 
@@ -148,6 +152,67 @@ func TestStoreGetSorted(t *testing.T) {
 	}
 }
 
+func TestStoreGetPage(t *testing.T) {
+	testStoreGetPage(t, newStore())
+}
+
+// testStoreGetPage tests that the pages of a get list the nodes in the
+// order of a sorted recursive get.
+func testStoreGetPage(t *testing.T, s Store) {
+	s.Create("/foo/a", false, "0", false, Permanent)
+	s.Create("/foo/b/c", false, "0", false, Permanent)
+	s.Create("/foo/b/d", false, "0", false, Permanent)
+	s.Create("/foo/b-x", false, "0", false, Permanent)
+	s.Create("/foo/c", false, "0", false, Permanent)
+
+	tests := []struct {
+		recursive bool
+		after     string
+
+		wkeys     []string
+		wcontinue string
+	}{
+		{true, "", []string{"/foo/a", "/foo/b"}, "/foo/b"},
+		// the directory holding the nodes of the page is listed again
+		{true, "/foo/b", []string{"/foo/b", "/foo/b/c", "/foo/b/d"}, "/foo/b/d"},
+		{true, "/foo/b/d", []string{"/foo/b-x", "/foo/c"}, ""},
+		{false, "", []string{"/foo/a", "/foo/b"}, "/foo/b"},
+		{false, "/foo/b", []string{"/foo/b-x", "/foo/c"}, ""},
+	}
+	for i, tt := range tests {
+		e, err := s.GetPage("/foo", tt.recursive, 2, tt.after)
+		if err != nil {
+			t.Fatalf("#%d: err = %v", i, err)
+		}
+		if keys := nodeKeys(e.Node.Nodes); !reflect.DeepEqual(keys, tt.wkeys) {
+			t.Errorf("#%d: keys = %v, want %v", i, keys, tt.wkeys)
+		}
+		if e.Continue != tt.wcontinue {
+			t.Errorf("#%d: continue = %q, want %q", i, e.Continue, tt.wcontinue)
+		}
+	}
+
+	// no limit
+	e, err := s.GetPage("/foo", true, 0, "/foo/a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	wkeys := []string{"/foo/b", "/foo/b/c", "/foo/b/d", "/foo/b-x", "/foo/c"}
+	if keys := nodeKeys(e.Node.Nodes); !reflect.DeepEqual(keys, wkeys) || e.Continue != "" {
+		t.Errorf("keys = %v, continue = %q, want %v and none", keys, e.Continue, wkeys)
+	}
+}
+
+// nodeKeys returns the keys of the nodes in the order of a recursive walk.
+func nodeKeys(ns NodeExterns) []string {
+	var keys []string
+	for _, n := range ns {
+		keys = append(keys, n.Key)
+		keys = append(keys, nodeKeys(n.Nodes)...)
+	}
+	return keys
+}
+
 func TestSet(t *testing.T) {
 	s := newStore()
 
@@ -535,6 +600,68 @@ func TestStoreCompareAndDeleteDiretoryFail(t *testing.T) {
 	assert.Equal(t, err.ErrorCode, etcdErr.EcodeNotFile, "")
 }
 
+func TestStoreCompareAndDeleteDir(t *testing.T) {
+	testStoreCompareAndDeleteDir(t, newStore())
+}
+
+// testStoreCompareAndDeleteDir tests that a directory is deleted with all
+// the nodes under it only if its modifiedIndex and the value of its
+// marker key match, and that a failed compare leaves it untouched.
+func testStoreCompareAndDeleteDir(t *testing.T, s Store) {
+	s.Create("/lock", true, "", false, Permanent)
+	s.Create("/lock/owner", false, "a", false, Permanent)
+	s.Create("/lock/lease/x", false, "x", false, Permanent)
+	s.Create("/file", false, "f", false, Permanent)
+	w, _ := s.Watch("/lock/lease/x", false, false, 0, nil)
+
+	tests := []struct {
+		path      string
+		marker    string
+		prevValue string
+		prevIndex uint64
+		recursive bool
+		wcode     int
+	}{
+		{"/lock", "", "", 2, true, etcdErr.EcodeTestFailed},
+		{"/lock", "owner", "b", 0, true, etcdErr.EcodeTestFailed},
+		{"/lock", "missing", "a", 0, true, etcdErr.EcodeTestFailed},
+		{"/lock", "lease", "", 0, true, etcdErr.EcodeTestFailed},
+		{"/lock", "owner", "a", 2, true, etcdErr.EcodeTestFailed},
+		{"/lock", "../file", "f", 0, true, etcdErr.EcodeInvalidField},
+		{"/lock", "owner", "a", 1, false, etcdErr.EcodeDirNotEmpty},
+		{"/file", "", "", 4, true, etcdErr.EcodeNotDir},
+		{"/missing", "", "", 1, true, etcdErr.EcodeKeyNotFound},
+		{"/", "", "", 0, true, etcdErr.EcodeRootROnly},
+	}
+	for i, tt := range tests {
+		_, err := s.CompareAndDeleteDir(tt.path, tt.marker, tt.prevValue, tt.prevIndex, tt.recursive)
+		if err == nil || err.(*etcdErr.Error).ErrorCode != tt.wcode {
+			t.Errorf("#%d: err = %v, want code %d", i, err, tt.wcode)
+		}
+	}
+	assert.Equal(t, s.Index(), uint64(4), "")
+
+	e, err := s.CompareAndDeleteDir("/lock", "owner", "a", 1, true)
+	assert.Nil(t, err, "")
+	assert.Equal(t, e.Action, CompareAndDelete, "")
+	assert.Equal(t, e.EtcdIndex, uint64(5), "")
+	assert.Equal(t, e.Node.Key, "/lock", "")
+	assert.Equal(t, e.Node.Dir, true, "")
+	assert.Equal(t, e.PrevNode.ModifiedIndex, uint64(1), "")
+	for _, k := range []string{"/lock", "/lock/owner", "/lock/lease/x"} {
+		_, err = s.Get(k, false, false)
+		assert.Equal(t, err.(*etcdErr.Error).ErrorCode, etcdErr.EcodeKeyNotFound, k)
+	}
+	e = nbselect(w.EventChan())
+	assert.Equal(t, e.Action, CompareAndDelete, "")
+	assert.Equal(t, e.Node.Key, "/lock", "")
+
+	// an empty directory is deleted without recursive
+	s.Create("/empty", true, "", false, Permanent)
+	_, err = s.CompareAndDeleteDir("/empty", "", "", 6, false)
+	assert.Nil(t, err, "")
+}
+
 // Ensure that the store can conditionally update a key if it has a previous value.
 func TestStoreCompareAndSwapPrevValue(t *testing.T) {
 	s := newStore()
@@ -608,11 +735,126 @@ func TestStoreCompareAndSwapPrevIndexFailsIfNotMatch(t *testing.T) {
 	assert.Equal(t, *e.Node.Value, "bar", "")
 }
 
+// Ensure that the store applies the success operations of a txn if all compares hold.
+func TestStoreTxnSuccess(t *testing.T) {
+	s := newStore()
+	s.Create("/foo", false, "bar", false, Permanent)
+	s.Create("/baz", false, "qux", false, Permanent)
+	cmps := []TxnCompare{
+		{Path: "/foo", Target: TxnCompareValue, Value: "bar"},
+		{Path: "/baz", Target: TxnCompareModifiedIndex, Index: 2},
+		{Path: "/none", Target: TxnCompareCreatedIndex, Index: 0},
+	}
+	success := []TxnOp{
+		{Action: Set, Path: "/foo", Value: "bar2", ExpireTime: Permanent},
+		{Action: Delete, Path: "/baz"},
+		{Action: Set, Path: "/dir/none", Value: "created", ExpireTime: Permanent},
+	}
+	resp, err := s.Txn(cmps, success, nil)
+	assert.Nil(t, err, "")
+	assert.True(t, resp.Succeeded, "")
+	assert.Equal(t, resp.EtcdIndex, uint64(5), "")
+	assert.Equal(t, len(resp.Events), 3, "")
+	assert.Equal(t, resp.Events[0].Action, "set", "")
+	assert.Equal(t, *resp.Events[0].PrevNode.Value, "bar", "")
+	assert.Equal(t, resp.Events[1].Action, "delete", "")
+	assert.Equal(t, resp.Events[2].Node.Key, "/dir/none", "")
+
+	e, _ := s.Get("/foo", false, false)
+	assert.Equal(t, *e.Node.Value, "bar2", "")
+	_, err = s.Get("/baz", false, false)
+	assert.Equal(t, err.(*etcdErr.Error).ErrorCode, etcdErr.EcodeKeyNotFound, "")
+	e, _ = s.Get("/dir/none", false, false)
+	assert.Equal(t, *e.Node.Value, "created", "")
+}
+
+// Ensure that the store applies the failure operations of a txn if any compare fails.
+func TestStoreTxnFailure(t *testing.T) {
+	s := newStore()
+	s.Create("/foo", false, "bar", false, Permanent)
+	cmps := []TxnCompare{
+		{Path: "/foo", Target: TxnCompareValue, Value: "bar"},
+		{Path: "/foo", Target: TxnCompareCreatedIndex, Index: 2},
+	}
+	success := []TxnOp{{Action: Set, Path: "/foo", Value: "success", ExpireTime: Permanent}}
+	failure := []TxnOp{{Action: Set, Path: "/foo", Value: "failure", ExpireTime: Permanent}}
+	resp, err := s.Txn(cmps, success, failure)
+	assert.Nil(t, err, "")
+	assert.False(t, resp.Succeeded, "")
+	assert.Equal(t, len(resp.Events), 1, "")
+
+	e, _ := s.Get("/foo", false, false)
+	assert.Equal(t, *e.Node.Value, "failure", "")
+}
+
+// Ensure that the store applies none of the operations of a txn if any of them would fail.
+func TestStoreTxnIsAtomic(t *testing.T) {
+	s := newStore()
+	var eidx uint64 = 2
+	s.Create("/foo", false, "bar", false, Permanent)
+	s.Create("/dir", true, "", false, Permanent)
+	tests := [][]TxnOp{
+		// delete of a missing key
+		{
+			{Action: Set, Path: "/foo", Value: "baz", ExpireTime: Permanent},
+			{Action: Delete, Path: "/none"},
+		},
+		// set of a directory
+		{
+			{Action: Set, Path: "/foo", Value: "baz", ExpireTime: Permanent},
+			{Action: Set, Path: "/dir", Value: "baz", ExpireTime: Permanent},
+		},
+		// set under a file
+		{
+			{Action: Set, Path: "/dir/a", Value: "baz", ExpireTime: Permanent},
+			{Action: Set, Path: "/foo/a", Value: "baz", ExpireTime: Permanent},
+		},
+		// overlapped keys
+		{
+			{Action: Set, Path: "/dir/a", Value: "baz", ExpireTime: Permanent},
+			{Action: Delete, Path: "/dir", Recursive: true},
+		},
+		// read only root
+		{
+			{Action: Set, Path: "/foo", Value: "baz", ExpireTime: Permanent},
+			{Action: Delete, Path: "/", Recursive: true},
+		},
+	}
+	for i, ops := range tests {
+		resp, err := s.Txn(nil, ops, nil)
+		if err == nil {
+			t.Errorf("#%d: err = nil, want non-nil", i)
+		}
+		if resp != nil {
+			t.Errorf("#%d: resp = %+v, want nil", i, resp)
+		}
+		e, _ := s.Get("/foo", false, false)
+		if *e.Node.Value != "bar" || e.EtcdIndex != eidx {
+			t.Errorf("#%d: /foo = %s at index %d, want bar at index %d", i, *e.Node.Value, e.EtcdIndex, eidx)
+		}
+	}
+}
+
+// Ensure that the watchers are notified of the operations applied by a txn.
+func TestStoreWatchTxn(t *testing.T) {
+	s := newStore()
+	var eidx uint64 = 1
+	s.Create("/foo", false, "bar", false, Permanent)
+	w, _ := s.Watch("/foo", false, false, 0, nil)
+	assert.Equal(t, w.StartIndex(), eidx, "")
+	s.Txn(nil, []TxnOp{{Action: Delete, Path: "/foo"}}, nil)
+	eidx = 2
+	e := nbselect(w.EventChan())
+	assert.Equal(t, e.EtcdIndex, eidx, "")
+	assert.Equal(t, e.Action, "delete", "")
+	assert.Equal(t, e.Node.Key, "/foo", "")
+}
+
 // Ensure that the store can watch for key creation.
 func TestStoreWatchCreate(t *testing.T) {
 	s := newStore()
 	var eidx uint64 = 0
-	w, _ := s.Watch("/foo", false, false, 0)
+	w, _ := s.Watch("/foo", false, false, 0, nil)
 	c := w.EventChan()
 	assert.Equal(t, w.StartIndex(), eidx, "")
 	s.Create("/foo", false, "bar", false, Permanent)
@@ -629,7 +871,7 @@ func TestStoreWatchCreate(t *testing.T) {
 func TestStoreWatchRecursiveCreate(t *testing.T) {
 	s := newStore()
 	var eidx uint64 = 0
-	w, _ := s.Watch("/foo", true, false, 0)
+	w, _ := s.Watch("/foo", true, false, 0, nil)
 	assert.Equal(t, w.StartIndex(), eidx, "")
 	eidx = 1
 	s.Create("/foo/bar", false, "baz", false, Permanent)
@@ -644,7 +886,7 @@ func TestStoreWatchUpdate(t *testing.T) {
 	s := newStore()
 	var eidx uint64 = 1
 	s.Create("/foo", false, "bar", false, Permanent)
-	w, _ := s.Watch("/foo", false, false, 0)
+	w, _ := s.Watch("/foo", false, false, 0, nil)
 	assert.Equal(t, w.StartIndex(), eidx, "")
 	eidx = 2
 	s.Update("/foo", "baz", Permanent)
@@ -659,7 +901,7 @@ func TestStoreWatchRecursiveUpdate(t *testing.T) {
 	s := newStore()
 	var eidx uint64 = 1
 	s.Create("/foo/bar", false, "baz", false, Permanent)
-	w, _ := s.Watch("/foo", true, false, 0)
+	w, _ := s.Watch("/foo", true, false, 0, nil)
 	assert.Equal(t, w.StartIndex(), eidx, "")
 	eidx = 2
 	s.Update("/foo/bar", "baz", Permanent)
@@ -674,7 +916,7 @@ func TestStoreWatchDelete(t *testing.T) {
 	s := newStore()
 	var eidx uint64 = 1
 	s.Create("/foo", false, "bar", false, Permanent)
-	w, _ := s.Watch("/foo", false, false, 0)
+	w, _ := s.Watch("/foo", false, false, 0, nil)
 	assert.Equal(t, w.StartIndex(), eidx, "")
 	eidx = 2
 	s.Delete("/foo", false, false)
@@ -689,7 +931,7 @@ func TestStoreWatchRecursiveDelete(t *testing.T) {
 	s := newStore()
 	var eidx uint64 = 1
 	s.Create("/foo/bar", false, "baz", false, Permanent)
-	w, _ := s.Watch("/foo", true, false, 0)
+	w, _ := s.Watch("/foo", true, false, 0, nil)
 	assert.Equal(t, w.StartIndex(), eidx, "")
 	eidx = 2
 	s.Delete("/foo/bar", false, false)
@@ -704,7 +946,7 @@ func TestStoreWatchCompareAndSwap(t *testing.T) {
 	s := newStore()
 	var eidx uint64 = 1
 	s.Create("/foo", false, "bar", false, Permanent)
-	w, _ := s.Watch("/foo", false, false, 0)
+	w, _ := s.Watch("/foo", false, false, 0, nil)
 	assert.Equal(t, w.StartIndex(), eidx, "")
 	eidx = 2
 	s.CompareAndSwap("/foo", "bar", 0, "baz", Permanent)
@@ -719,7 +961,7 @@ func TestStoreWatchRecursiveCompareAndSwap(t *testing.T) {
 	s := newStore()
 	var eidx uint64 = 1
 	s.Create("/foo/bar", false, "baz", false, Permanent)
-	w, _ := s.Watch("/foo", true, false, 0)
+	w, _ := s.Watch("/foo", true, false, 0, nil)
 	assert.Equal(t, w.StartIndex(), eidx, "")
 	eidx = 2
 	s.CompareAndSwap("/foo/bar", "baz", 0, "bat", Permanent)
@@ -739,7 +981,7 @@ func TestStoreWatchExpire(t *testing.T) {
 	s.Create("/foo", false, "bar", false, fc.Now().Add(500*time.Millisecond))
 	s.Create("/foofoo", false, "barbarbar", false, fc.Now().Add(500*time.Millisecond))
 
-	w, _ := s.Watch("/", true, false, 0)
+	w, _ := s.Watch("/", true, false, 0, nil)
 	assert.Equal(t, w.StartIndex(), eidx, "")
 	c := w.EventChan()
 	e := nbselect(c)
@@ -751,7 +993,7 @@ func TestStoreWatchExpire(t *testing.T) {
 	assert.Equal(t, e.EtcdIndex, eidx, "")
 	assert.Equal(t, e.Action, "expire", "")
 	assert.Equal(t, e.Node.Key, "/foo", "")
-	w, _ = s.Watch("/", true, false, 4)
+	w, _ = s.Watch("/", true, false, 4, nil)
 	eidx = 4
 	assert.Equal(t, w.StartIndex(), eidx, "")
 	e = nbselect(w.EventChan())
@@ -764,7 +1006,7 @@ func TestStoreWatchExpire(t *testing.T) {
 func TestStoreWatchStream(t *testing.T) {
 	s := newStore()
 	var eidx uint64 = 1
-	w, _ := s.Watch("/foo", false, true, 0)
+	w, _ := s.Watch("/foo", false, true, 0, nil)
 	// first modification
 	s.Create("/foo", false, "bar", false, Permanent)
 	e := nbselect(w.EventChan())
@@ -786,6 +1028,440 @@ func TestStoreWatchStream(t *testing.T) {
 	assert.Nil(t, e, "")
 }
 
+func TestStoreIncr(t *testing.T) {
+	testStoreIncr(t, newStore())
+}
+
+// testStoreIncr tests that incr adds the delta to the integer value of a
+// key, and creates a missing key with the delta.
+func testStoreIncr(t *testing.T, s Store) {
+	e, err := s.Incr("/foo", 5)
+	assert.Nil(t, err, "")
+	assert.Equal(t, e.Action, "incr", "")
+	assert.Equal(t, *e.Node.Value, "5", "")
+	assert.Nil(t, e.PrevNode, "")
+	assert.True(t, e.IsCreated(), "")
+
+	w, _ := s.Watch("/foo", false, false, 0, nil)
+	e, err = s.Incr("/foo", -7)
+	assert.Nil(t, err, "")
+	assert.Equal(t, e.EtcdIndex, uint64(2), "")
+	assert.Equal(t, *e.Node.Value, "-2", "")
+	assert.Equal(t, e.Node.ModifiedIndex, uint64(2), "")
+	assert.Equal(t, e.Node.CreatedIndex, uint64(1), "")
+	assert.Equal(t, *e.PrevNode.Value, "5", "")
+	e = nbselect(w.EventChan())
+	assert.Equal(t, e.Action, "incr", "")
+	assert.Equal(t, *e.Node.Value, "-2", "")
+
+	s.Set("/bar", false, "bar", Permanent)
+	_, err = s.Incr("/bar", 1)
+	assert.Equal(t, err.(*etcdErr.Error).ErrorCode, etcdErr.EcodeValueNaN, "")
+	s.Set("/max", false, "9223372036854775807", Permanent)
+	_, err = s.Incr("/max", 1)
+	assert.Equal(t, err.(*etcdErr.Error).ErrorCode, etcdErr.EcodeValueNaN, "")
+	s.Set("/dir", true, "", Permanent)
+	_, err = s.Incr("/dir", 1)
+	assert.Equal(t, err.(*etcdErr.Error).ErrorCode, etcdErr.EcodeNotFile, "")
+	assert.Equal(t, s.Index(), uint64(5), "")
+}
+
+func TestStoreUsage(t *testing.T) {
+	testStoreUsage(t, newStore())
+}
+
+// testStoreUsage tests that the usage of a directory counts the nodes
+// under it at any depth, including the hidden ones, and their values.
+func testStoreUsage(t *testing.T, s Store) {
+	s.Create("/foo/bar/baz", false, "baz", false, Permanent)
+	s.Create("/foo/_hidden", false, "hidden", false, Permanent)
+	s.Create("/foo/dir", true, "", false, Permanent)
+	s.Create("/other", false, "other", false, Permanent)
+
+	nodes, valueBytes, err := s.Usage("/foo")
+	assert.Nil(t, err, "")
+	assert.Equal(t, nodes, int64(4), "")
+	assert.Equal(t, valueBytes, int64(9), "")
+
+	nodes, valueBytes, err = s.Usage("/")
+	assert.Nil(t, err, "")
+	assert.Equal(t, nodes, int64(6), "")
+	assert.Equal(t, valueBytes, int64(14), "")
+
+	nodes, valueBytes, err = s.Usage("/foo/dir")
+	assert.Nil(t, err, "")
+	assert.Equal(t, nodes, int64(0), "")
+	assert.Equal(t, valueBytes, int64(0), "")
+
+	_, _, err = s.Usage("/other")
+	assert.Equal(t, err.(*etcdErr.Error).ErrorCode, etcdErr.EcodeNotDir, "")
+	_, _, err = s.Usage("/missing")
+	assert.Equal(t, err.(*etcdErr.Error).ErrorCode, etcdErr.EcodeKeyNotFound, "")
+}
+
+func TestStoreMove(t *testing.T) {
+	s := newStore()
+	fc := newFakeClock()
+	s.clock = fc
+	testStoreMove(t, s, fc)
+}
+
+// testStoreMove tests that a move relocates a directory and the nodes
+// under it, keeping their indexes and ttls, and notifies the watchers of
+// both paths.
+func testStoreMove(t *testing.T, s Store, fc clockwork.FakeClock) {
+	s.Create("/foo/a", false, "1", false, Permanent)
+	s.Create("/foo/b/c", false, "2", false, Permanent)
+	s.Create("/ttl", false, "3", false, fc.Now().Add(time.Hour))
+	s.Create("/file", false, "4", false, Permanent)
+
+	wOld, _ := s.Watch("/foo/b/c", false, false, 0, nil)
+	wNew, _ := s.Watch("/bar", true, false, 0, nil)
+	e, err := s.Move("/foo", "/bar/baz")
+	assert.Nil(t, err, "")
+	assert.Equal(t, e.Action, "move", "")
+	assert.Equal(t, e.EtcdIndex, uint64(5), "")
+	assert.Equal(t, e.Node.Key, "/bar/baz", "")
+	assert.Equal(t, e.Node.Dir, true, "")
+	assert.Equal(t, e.Node.CreatedIndex, uint64(1), "")
+	assert.Equal(t, e.PrevNode.Key, "/foo", "")
+	assert.Equal(t, nbselect(wOld.EventChan()).Action, "move", "")
+	assert.Equal(t, nbselect(wNew.EventChan()).Node.Key, "/bar/baz", "")
+
+	_, err = s.Get("/foo", false, false)
+	assert.Equal(t, err.(*etcdErr.Error).ErrorCode, etcdErr.EcodeKeyNotFound, "")
+	e, err = s.Get("/bar/baz/b/c", false, false)
+	assert.Nil(t, err, "")
+	assert.Equal(t, *e.Node.Value, "2", "")
+	assert.Equal(t, e.Node.CreatedIndex, uint64(2), "")
+	assert.Equal(t, e.Node.ModifiedIndex, uint64(2), "")
+
+	e, err = s.Move("/ttl", "/ttl2")
+	assert.Nil(t, err, "")
+	assert.Equal(t, *e.Node.Value, "3", "")
+	assert.Equal(t, e.Node.TTL, int64(3600), "")
+	e, err = s.Get("/ttl2", false, false)
+	assert.Nil(t, err, "")
+	assert.Equal(t, e.Node.TTL, int64(3600), "")
+
+	_, err = s.Move("/nokey", "/other")
+	assert.Equal(t, err.(*etcdErr.Error).ErrorCode, etcdErr.EcodeKeyNotFound, "")
+	_, err = s.Move("/ttl2", "/file")
+	assert.Equal(t, err.(*etcdErr.Error).ErrorCode, etcdErr.EcodeNodeExist, "")
+	_, err = s.Move("/bar", "/bar/baz/b/x")
+	assert.Equal(t, err.(*etcdErr.Error).ErrorCode, etcdErr.EcodeInvalidField, "")
+	_, err = s.Move("/ttl2", "/file/x")
+	assert.Equal(t, err.(*etcdErr.Error).ErrorCode, etcdErr.EcodeNotDir, "")
+	assert.Equal(t, s.Index(), uint64(6), "")
+}
+
+// Ensure that a watcher from a past index sees a move from its old key.
+func TestStoreWatchMoveHistory(t *testing.T) {
+	s := newStore()
+	s.Create("/dir/foo", false, "bar", false, Permanent)
+	s.Move("/dir/foo", "/other/foo")
+
+	w, _ := s.Watch("/dir", true, false, 2, nil)
+	e := nbselect(w.EventChan())
+	assert.Equal(t, e.Action, "move", "")
+	assert.Equal(t, e.PrevNode.Key, "/dir/foo", "")
+}
+
+func TestStoreExportImport(t *testing.T) {
+	s := newStore()
+	fc := newFakeClock()
+	s.clock = fc
+	testStoreExportImport(t, s, fc)
+}
+
+// testStoreExportImport tests that an export holds the subtree with its
+// hidden nodes, indexes and expire times, and that an import recreates
+// it at another path at the index of the import.
+func testStoreExportImport(t *testing.T, s Store, fc clockwork.FakeClock) {
+	s.Create("/dir/b", false, "B", false, fc.Now().Add(time.Hour))
+	s.Create("/dir/_hidden", false, "H", false, Permanent)
+	s.Create("/dir/sub/a", false, "A", false, Permanent)
+	s.Update("/dir/sub", "", fc.Now().Add(time.Minute))
+	s.Create("/other", false, "O", false, Permanent)
+
+	ex, err := s.Export("/dir")
+	assert.Nil(t, err, "")
+	assert.Equal(t, ex.Version, ExportVersion, "")
+	assert.Equal(t, ex.Index, uint64(5), "")
+	n := ex.Node
+	assert.Equal(t, n.Key, "/dir", "")
+	assert.Equal(t, n.CreatedIndex, uint64(1), "")
+	assert.Equal(t, len(n.Nodes), 3, "")
+	assert.Equal(t, n.Nodes[0].Key, "/dir/_hidden", "")
+	assert.Equal(t, *n.Nodes[0].Value, "H", "")
+	assert.Equal(t, n.Nodes[1].Key, "/dir/b", "")
+	assert.Equal(t, n.Nodes[1].CreatedIndex, uint64(1), "")
+	assert.Equal(t, *n.Nodes[1].Expiration, fc.Now().Add(time.Hour), "")
+	assert.Equal(t, n.Nodes[2].Key, "/dir/sub", "")
+	assert.Equal(t, n.Nodes[2].CreatedIndex, uint64(3), "")
+	assert.Equal(t, *n.Nodes[2].Expiration, fc.Now().Add(time.Minute), "")
+	assert.Equal(t, n.Nodes[2].Nodes[0].Key, "/dir/sub/a", "")
+
+	// an export is portable
+	b, err := json.Marshal(ex)
+	assert.Nil(t, err, "")
+	ex = &Export{}
+	assert.Nil(t, json.Unmarshal(b, ex), "")
+
+	w, _ := s.Watch("/copy", true, false, 0, nil)
+	e, err := s.Import("/copy/dir", ex)
+	assert.Nil(t, err, "")
+	assert.Equal(t, e.Action, "import", "")
+	assert.Equal(t, e.EtcdIndex, uint64(6), "")
+	assert.Equal(t, e.Node.Key, "/copy/dir", "")
+	assert.Equal(t, e.Node.Dir, true, "")
+	assert.Equal(t, e.Node.CreatedIndex, uint64(6), "")
+	assert.Equal(t, nbselect(w.EventChan()), e, "")
+	assert.Equal(t, s.Index(), uint64(6), "")
+
+	e, err = s.Get("/copy/dir", true, true)
+	assert.Nil(t, err, "")
+	assert.Equal(t, len(e.Node.Nodes), 2, "")
+	assert.Equal(t, e.Node.Nodes[0].Key, "/copy/dir/b", "")
+	assert.Equal(t, *e.Node.Nodes[0].Value, "B", "")
+	assert.Equal(t, e.Node.Nodes[0].CreatedIndex, uint64(6), "")
+	assert.Equal(t, e.Node.Nodes[0].ModifiedIndex, uint64(6), "")
+	assert.Equal(t, e.Node.Nodes[0].TTL, int64(3600), "")
+	assert.Equal(t, *e.Node.Nodes[1].Nodes[0].Value, "A", "")
+	e, err = s.Get("/copy/dir/_hidden", false, false)
+	assert.Nil(t, err, "")
+	assert.Equal(t, *e.Node.Value, "H", "")
+
+	// the imported ttls expire as the exported ones
+	fc.Advance(2 * time.Minute)
+	s.DeleteExpiredKeys(fc.Now())
+	_, err = s.Get("/copy/dir/sub", false, false)
+	assert.Equal(t, err.(*etcdErr.Error).ErrorCode, etcdErr.EcodeKeyNotFound, "")
+	_, err = s.Get("/copy/dir/b", false, false)
+	assert.Nil(t, err, "")
+
+	// a file is exported and imported on its own
+	ex, err = s.Export("/other")
+	assert.Nil(t, err, "")
+	e, err = s.Import("/copy/other", ex)
+	assert.Nil(t, err, "")
+	assert.Equal(t, *e.Node.Value, "O", "")
+
+	_, err = s.Import("/copy/dir", ex)
+	assert.Equal(t, err.(*etcdErr.Error).ErrorCode, etcdErr.EcodeNodeExist, "")
+	_, err = s.Import("/other/x", ex)
+	assert.Equal(t, err.(*etcdErr.Error).ErrorCode, etcdErr.EcodeNotDir, "")
+	_, err = s.Import("/new", &Export{Version: ExportVersion})
+	assert.Equal(t, err.(*etcdErr.Error).ErrorCode, etcdErr.EcodeInvalidField, "")
+	_, err = s.Export("/nokey")
+	assert.Equal(t, err.(*etcdErr.Error).ErrorCode, etcdErr.EcodeKeyNotFound, "")
+}
+
+func TestStoreRefresh(t *testing.T) {
+	s := newStore()
+	fc := newFakeClock()
+	s.clock = fc
+	testStoreRefresh(t, s, fc)
+}
+
+// testStoreRefresh tests that a refresh resets the ttl of a key, keeps
+// its value and index, and does not notify the watchers.
+func testStoreRefresh(t *testing.T, s Store, fc clockwork.FakeClock) {
+	s.Create("/foo", false, "bar", false, fc.Now().Add(2*time.Second))
+	w, _ := s.Watch("/foo", false, false, 0, nil)
+
+	e, err := s.Refresh("/foo", fc.Now().Add(10*time.Second))
+	assert.Nil(t, err, "")
+	assert.Equal(t, e.Action, "update", "")
+	assert.Equal(t, e.EtcdIndex, uint64(1), "")
+	assert.Equal(t, *e.Node.Value, "bar", "")
+	assert.Equal(t, e.Node.ModifiedIndex, uint64(1), "")
+	assert.Equal(t, e.Node.TTL, int64(10), "")
+	assert.Equal(t, e.PrevNode.TTL, int64(2), "")
+	assert.Nil(t, nbselect(w.EventChan()), "")
+	assert.Equal(t, s.Index(), uint64(1), "")
+
+	// the key outlives its old ttl
+	fc.Advance(5 * time.Second)
+	s.DeleteExpiredKeys(fc.Now())
+	e, err = s.Get("/foo", false, false)
+	assert.Nil(t, err, "")
+	assert.Equal(t, *e.Node.Value, "bar", "")
+	fc.Advance(6 * time.Second)
+	s.DeleteExpiredKeys(fc.Now())
+	_, err = s.Get("/foo", false, false)
+	assert.Equal(t, err.(*etcdErr.Error).ErrorCode, etcdErr.EcodeKeyNotFound, "")
+
+	_, err = s.Refresh("/nokey", fc.Now().Add(10*time.Second))
+	assert.Equal(t, err.(*etcdErr.Error).ErrorCode, etcdErr.EcodeKeyNotFound, "")
+}
+
+func TestStoreExpireBudget(t *testing.T) {
+	s := newStore()
+	fc := newFakeClock()
+	s.clock = fc
+	s.expireBudget = 2
+	testStoreExpireBudget(t, s, fc)
+}
+
+// testStoreExpireBudget tests that a round of expiration deletes at most
+// the budget of keys, in the order of their expire time and path, and
+// leaves the rest to the next rounds. The store has a budget of 2.
+func testStoreExpireBudget(t *testing.T, s Store, fc clockwork.FakeClock) {
+	s.Create("/b", false, "", false, fc.Now().Add(time.Second))
+	s.Create("/a", false, "", false, fc.Now().Add(time.Second))
+	s.Create("/c", false, "", false, fc.Now().Add(500*time.Millisecond))
+	s.Create("/d", false, "", false, fc.Now().Add(2*time.Second))
+	s.Create("/e", false, "", false, fc.Now().Add(time.Hour))
+	w, _ := s.Watch("/", true, true, 0, nil)
+
+	fc.Advance(3 * time.Second)
+	for _, wkeys := range [][]string{{"/c", "/a"}, {"/b", "/d"}, nil} {
+		s.DeleteExpiredKeys(fc.Now())
+		for _, k := range wkeys {
+			e := nbselect(w.EventChan())
+			assert.Equal(t, e.Action, "expire", "")
+			assert.Equal(t, e.Node.Key, k, "")
+		}
+		assert.Nil(t, nbselect(w.EventChan()), "")
+	}
+	_, err := s.Get("/e", false, false)
+	assert.Nil(t, err, "")
+}
+
+func TestStoreDirTTLInheritance(t *testing.T) {
+	s := newStore()
+	fc := newFakeClock()
+	s.clock = fc
+	testStoreDirTTLInheritance(t, s, fc)
+}
+
+// testStoreDirTTLInheritance tests that the nodes under a directory with
+// a ttl report the expiration of the directory unless they expire
+// earlier, and are deleted with it.
+func testStoreDirTTLInheritance(t *testing.T, s Store, fc clockwork.FakeClock) {
+	s.Create("/ns", true, "", false, fc.Now().Add(10*time.Second))
+	s.Create("/ns/a", false, "A", false, Permanent)
+	s.Create("/ns/sub/b", false, "B", false, fc.Now().Add(20*time.Second))
+	s.Create("/ns/c", false, "C", false, fc.Now().Add(5*time.Second))
+	s.Create("/other", false, "O", false, Permanent)
+	fc.Advance(500 * time.Millisecond)
+
+	for _, tt := range []struct {
+		key  string
+		wttl int64
+	}{
+		{"/ns/a", 10},
+		{"/ns/sub", 10},
+		{"/ns/sub/b", 10},
+		{"/ns/c", 5},
+	} {
+		e, err := s.Get(tt.key, false, false)
+		assert.Nil(t, err, "")
+		assert.Equal(t, e.Node.TTL, tt.wttl, tt.key)
+		assert.Equal(t, *e.Node.Expiration, fc.Now().Add(time.Duration(tt.wttl)*time.Second-500*time.Millisecond).UTC(), tt.key)
+	}
+
+	e, err := s.Get("/ns", true, true)
+	assert.Nil(t, err, "")
+	assert.Equal(t, e.Node.Nodes[0].TTL, int64(10), "")
+	assert.Equal(t, e.Node.Nodes[1].TTL, int64(5), "")
+	assert.Equal(t, e.Node.Nodes[2].Nodes[0].TTL, int64(10), "")
+	e, err = s.GetPage("/ns", true, 2, "/ns/c")
+	assert.Nil(t, err, "")
+	assert.Equal(t, e.Node.Nodes[0].Key, "/ns/sub", "")
+	assert.Equal(t, e.Node.Nodes[0].Nodes[0].TTL, int64(10), "")
+
+	e, err = s.Get("/", true, true)
+	assert.Nil(t, err, "")
+	assert.Nil(t, e.Node.Expiration, "")
+	assert.Nil(t, e.Node.Nodes[1].Expiration, "")
+	assert.Equal(t, e.Node.Nodes[1].Key, "/other", "")
+
+	fc.Advance(10 * time.Second)
+	s.DeleteExpiredKeys(fc.Now())
+	for _, k := range []string{"/ns/a", "/ns/sub/b", "/ns"} {
+		_, err = s.Get(k, false, false)
+		assert.Equal(t, err.(*etcdErr.Error).ErrorCode, etcdErr.EcodeKeyNotFound, k)
+	}
+	_, err = s.Get("/other", false, false)
+	assert.Nil(t, err, "")
+}
+
+// Ensure that the store only sends the events of the filtered actions to a watcher.
+func TestStoreWatchActions(t *testing.T) {
+	s := newStore()
+	w, _ := s.Watch("/foo", true, true, 0, []string{Delete, Expire})
+	s.Create("/foo/bar", false, "bar", false, Permanent)
+	s.Set("/foo/bar", false, "baz", Permanent)
+	e := nbselect(w.EventChan())
+	assert.Nil(t, e, "")
+	s.Delete("/foo/bar", false, false)
+	e = nbselect(w.EventChan())
+	assert.Equal(t, e.Action, "delete", "")
+	assert.Equal(t, e.Node.Key, "/foo/bar", "")
+
+	// the event history is filtered too
+	w, _ = s.Watch("/foo", true, false, 1, []string{Set})
+	e = nbselect(w.EventChan())
+	assert.Equal(t, e.Action, "set", "")
+	assert.Equal(t, e.Node.ModifiedIndex, uint64(2), "")
+}
+
+// Ensure that the store reports the index its watch history has been compacted at.
+func TestStoreWatchCompactedHistory(t *testing.T) {
+	s := NewWithHistorySize(10).(*store)
+	for i := 0; i < 20; i++ {
+		s.Set("/foo", false, "bar", Permanent)
+	}
+	assert.Equal(t, s.OldestWatchableIndex(), uint64(11), "")
+
+	_, err := s.Watch("/foo", false, false, 10, nil)
+	assert.Equal(t, err.(*etcdErr.Error).ErrorCode, etcdErr.EcodeEventIndexCleared, "")
+	assert.Equal(t, err.(*etcdErr.Error).CompactIndex, uint64(10), "")
+	assert.Equal(t, err.(*etcdErr.Error).Index, uint64(20), "")
+
+	w, err := s.Watch("/foo", false, false, 11, nil)
+	assert.Nil(t, err, "")
+	e := nbselect(w.EventChan())
+	assert.Equal(t, e.Node.ModifiedIndex, uint64(11), "")
+}
+
+// Ensure that the store compacts its watch history at the index.
+func TestStoreCompactHistory(t *testing.T) {
+	s := newStore()
+	for i := 0; i < 10; i++ {
+		s.Set("/foo", false, "bar", Permanent)
+	}
+	s.CompactHistory(6)
+	assert.Equal(t, s.OldestWatchableIndex(), uint64(7), "")
+
+	_, err := s.Watch("/foo", false, false, 6, nil)
+	assert.Equal(t, err.(*etcdErr.Error).ErrorCode, etcdErr.EcodeEventIndexCleared, "")
+	assert.Equal(t, err.(*etcdErr.Error).CompactIndex, uint64(6), "")
+
+	w, err := s.Watch("/foo", false, false, 7, nil)
+	assert.Nil(t, err, "")
+	e := nbselect(w.EventChan())
+	assert.Equal(t, e.Node.ModifiedIndex, uint64(7), "")
+}
+
+// Ensure that the store keeps its history size when it recovers from a
+// state saved by a store with another history size.
+func TestStoreRecoverHistorySize(t *testing.T) {
+	s := newStore()
+	for i := 0; i < 20; i++ {
+		s.Set("/foo", false, "bar", Permanent)
+	}
+	b, err := s.Save()
+	assert.Nil(t, err, "")
+
+	s2 := NewWithHistorySize(5).(*store)
+	assert.Nil(t, s2.Recovery(b), "")
+	assert.Equal(t, s2.WatcherHub.EventHistory.Queue.Capacity, 5, "")
+	assert.Equal(t, s2.OldestWatchableIndex(), uint64(16), "")
+}
+
 // Ensure that the store can recover from a previously saved state.
 func TestStoreRecover(t *testing.T) {
 	s := newStore()
@@ -812,6 +1488,109 @@ func TestStoreRecover(t *testing.T) {
 	assert.Equal(t, *e.Node.Value, "baz", "")
 }
 
+func TestStoreConsistentIndex(t *testing.T) {
+	testStoreConsistentIndex(t, newStore(), newStore())
+}
+
+// testStoreConsistentIndex tests that the consistent index is saved with
+// the state of s, in both formats, and recovered into s2, and that a
+// state without one recovers to zero.
+func testStoreConsistentIndex(t *testing.T, s, s2 Store) {
+	s.Create("/foo", false, "bar", false, Permanent)
+	s.SetConsistentIndex(10)
+	assert.Equal(t, s.Clone().ConsistentIndex(), uint64(10), "")
+	for _, format := range []string{SnapshotFormatJSON, SnapshotFormatGob} {
+		s.SetSnapshotFormat(format)
+		b, err := s.Save()
+		assert.Nil(t, err, "")
+		s2.SetConsistentIndex(5)
+		assert.Nil(t, s2.Recovery(b), "")
+		assert.Equal(t, s2.ConsistentIndex(), uint64(10), format)
+	}
+	assert.Nil(t, s2.Recovery([]byte(`{"Root":{"Path":"/","Children":{}},"CurrentIndex":1}`)), "")
+	assert.Equal(t, s2.ConsistentIndex(), uint64(0), "")
+}
+
+func TestStoreRequestResults(t *testing.T) {
+	testStoreRequestResults(t, newStore(), newStore())
+}
+
+// testStoreRequestResults tests that the results of the requests applied
+// with a token are saved with the state of s, in both formats, and
+// recovered into s2.
+func testStoreRequestResults(t *testing.T, s, s2 Store) {
+	e, err := s.Create("/foo", false, "bar", false, Permanent)
+	assert.Nil(t, err, "")
+	s.RecordRequestResult(&RequestResult{Token: "a", Event: e})
+	_, err = s.Create("/foo", false, "bar", false, Permanent)
+	s.RecordRequestResult(&RequestResult{Token: "b", Err: err.(*etcdErr.Error)})
+	// a result is recorded once
+	s.RecordRequestResult(&RequestResult{Token: "a"})
+
+	check := func(st Store, format string) {
+		r, ok := st.RequestResult("a")
+		assert.True(t, ok, format)
+		assert.Equal(t, r.Event.Action, Create, format)
+		assert.Equal(t, *r.Event.Node.Value, "bar", format)
+		assert.Equal(t, r.Event.Node.ModifiedIndex, e.Node.ModifiedIndex, format)
+		r, ok = st.RequestResult("b")
+		assert.True(t, ok, format)
+		assert.Equal(t, r.Err.ErrorCode, etcdErr.EcodeNodeExist, format)
+		_, ok = st.RequestResult("c")
+		assert.False(t, ok, format)
+	}
+	check(s, "")
+	check(s.Clone(), "clone")
+	for _, format := range []string{SnapshotFormatJSON, SnapshotFormatGob} {
+		s.SetSnapshotFormat(format)
+		b, err := s.Save()
+		assert.Nil(t, err, "")
+		assert.Nil(t, s2.Recovery(b), "")
+		check(s2, format)
+	}
+	assert.Nil(t, s2.Recovery([]byte(`{"Root":{"Path":"/","Children":{}},"CurrentIndex":1}`)), "")
+	_, ok := s2.RequestResult("a")
+	assert.False(t, ok, "")
+}
+
+// Ensure that a state saved in gob is recovered into the same state as
+// the one saved in JSON, and is smaller.
+func TestStoreGobSaveAndRecovery(t *testing.T) {
+	s := newStore("/0")
+	s.clock = newFakeClock()
+	for i := 0; i < 100; i++ {
+		s.Create(fmt.Sprintf("/foo/%d", i), false, "bar", false, Permanent)
+	}
+	s.Create("/foo/_hidden", false, "x", false, Permanent)
+	s.Create("/dir", true, "", false, s.clock.Now().Add(time.Hour))
+	s.Create("/empty", false, "", false, s.clock.Now().Add(time.Minute))
+	s.Delete("/foo/0", false, false)
+	jb, err := s.Save()
+	assert.Nil(t, err, "")
+
+	s.SetSnapshotFormat(SnapshotFormatGob)
+	gb, err := s.Save()
+	assert.Nil(t, err, "")
+	assert.True(t, isGobState(gb), "")
+	assert.True(t, len(gb) < len(jb), "")
+
+	s2 := newStore()
+	s2.clock = s.clock
+	assert.Nil(t, s2.Recovery(gb), "")
+	assert.Equal(t, s2.Size(), s.Size(), "")
+	b, err := s2.Save()
+	assert.Nil(t, err, "")
+	assert.Equal(t, string(b), string(jb), "")
+
+	// the recovered ttl keys expire
+	s2.DeleteExpiredKeys(s.clock.Now().Add(2 * time.Minute))
+	_, err = s2.Get("/empty", false, false)
+	assert.Equal(t, err.(*etcdErr.Error).ErrorCode, etcdErr.EcodeKeyNotFound, "")
+
+	gb[len(gobStateMagic)] = gobStateVersion + 1
+	assert.NotNil(t, newStore().Recovery(gb), "")
+}
+
 // Ensure that the store can recover from a previously saved state that includes an expiring key.
 func TestStoreRecoverWithExpiration(t *testing.T) {
 	s := newStore()
@@ -845,11 +1624,205 @@ func TestStoreRecoverWithExpiration(t *testing.T) {
 	assert.Nil(t, e, "")
 }
 
+// Ensure that the size of the store follows the changes of the tree.
+func TestStoreSize(t *testing.T) {
+	s := newStore()
+	fc := newFakeClock()
+	s.clock = fc
+	check := func(msg string) {
+		kb, vb := s.Root.treeBytes()
+		assert.Equal(t, s.keyBytes, kb, msg)
+		assert.Equal(t, s.valueBytes, vb, msg)
+		assert.Equal(t, s.Size(), kb+vb, msg)
+	}
+
+	check("empty store")
+	s.Create("/foo/bar", false, "baz", false, Permanent)
+	check("create")
+	assert.Equal(t, s.Size(), int64(len("/")+len("/foo")+len("/foo/bar")+len("baz")), "")
+	s.Update("/foo/bar", "bazbaz", Permanent)
+	check("update")
+	s.CompareAndSwap("/foo/bar", "bazbaz", 0, "b", Permanent)
+	check("compare and swap")
+	s.Set("/foo/bar", false, "barbar", Permanent)
+	check("set")
+	s.Create("/foo/ttl", false, "x", false, fc.Now().Add(time.Second))
+	check("create with ttl")
+	fc.Advance(2 * time.Second)
+	s.DeleteExpiredKeys(fc.Now())
+	check("expire")
+	s.Delete("/foo", true, true)
+	check("recursive delete")
+	assert.Equal(t, s.Size(), int64(len("/")), "")
+
+	s.Create("/foo/bar", false, "baz", false, Permanent)
+	b, err := s.Save()
+	assert.Nil(t, err, "")
+	s2 := newStore()
+	assert.Nil(t, s2.Recovery(b), "")
+	assert.Equal(t, s2.Size(), s.Size(), "")
+}
+
+// Ensure that the clone keeps the state of the store when it is taken
+// after the store is modified.
+func TestStoreCloneCopyOnWrite(t *testing.T) {
+	s := newStore()
+	s.clock = newFakeClock()
+	s.Create("/foo/x", false, "bar", false, Permanent)
+	s.Create("/foo/y", false, "baz", false, s.clock.Now().Add(time.Hour))
+	s.Create("/dir/z", false, "qux", false, Permanent)
+	c := s.Clone().(*store)
+	c.clock = s.clock
+
+	s.Update("/foo/x", "barbar", Permanent)
+	s.Update("/foo/y", "baz", Permanent)
+	s.Create("/foo/w", false, "new", false, Permanent)
+	s.Delete("/dir", false, true)
+	assert.Equal(t, len(s.snapshots), 1, "")
+
+	e, err := c.Get("/foo", true, true)
+	assert.Nil(t, err, "")
+	assert.Equal(t, e.EtcdIndex, uint64(3), "")
+	assert.Equal(t, len(e.Node.Nodes), 2, "")
+	assert.Equal(t, *e.Node.Nodes[0].Value, "bar", "")
+	assert.Equal(t, e.Node.Nodes[0].ModifiedIndex, uint64(1), "")
+	assert.NotNil(t, e.Node.Nodes[1].Expiration, "")
+	e, err = c.Get("/dir/z", false, false)
+	assert.Nil(t, err, "")
+	assert.Equal(t, *e.Node.Value, "qux", "")
+	// the snapshot is released once the tree is copied
+	assert.Equal(t, len(s.snapshots), 0, "")
+
+	e, err = s.Get("/foo/x", false, false)
+	assert.Nil(t, err, "")
+	assert.Equal(t, *e.Node.Value, "barbar", "")
+	_, err = s.Get("/dir/z", false, false)
+	assert.NotNil(t, err, "")
+}
+
+// Ensure that the clone can be saved while the store is being modified.
+func TestStoreCloneConcurrentWrites(t *testing.T) {
+	s := newStore()
+	for i := 0; i < 100; i++ {
+		s.Set(fmt.Sprintf("/foo/%d", i), false, "bar", Permanent)
+	}
+	c := s.Clone()
+
+	donec := make(chan []byte)
+	go func() {
+		b, err := c.SaveNoCopy()
+		assert.Nil(t, err, "")
+		donec <- b
+	}()
+	for i := 0; i < 100; i++ {
+		s.Set(fmt.Sprintf("/foo/%d", i), false, "baz", Permanent)
+		s.Delete(fmt.Sprintf("/foo/%d", (i+50)%100), false, false)
+	}
+	b := <-donec
+
+	s2 := newStore()
+	assert.Nil(t, s2.Recovery(b), "")
+	e, err := s2.Get("/foo", true, false)
+	assert.Nil(t, err, "")
+	assert.Equal(t, e.EtcdIndex, uint64(100), "")
+	assert.Equal(t, len(e.Node.Nodes), 100, "")
+	for _, n := range e.Node.Nodes {
+		assert.Equal(t, *n.Value, "bar", "")
+	}
+}
+
+// Ensure that a read of a directory copies it from a snapshot that is
+// not affected by the writes, down to the depth of the read.
+func TestStoreReadSnapshot(t *testing.T) {
+	s := newStore()
+	s.Create("/foo/x", false, "bar", false, Permanent)
+	s.Create("/foo/dir/y", false, "baz", false, Permanent)
+	n, _ := s.internalGet("/foo")
+	sn := newTreeSnapshot(s, n, s.CurrentIndex)
+	s.addSnapshot(sn)
+
+	s.Update("/foo/x", "barbar", Permanent)
+	s.Create("/foo/z", false, "new", false, Permanent)
+	s.Delete("/foo/dir", true, true)
+
+	c := sn.copyNode(nil, n, nil, 1)
+	assert.Equal(t, len(c.Children), 2, "")
+	assert.Equal(t, c.Children["x"].Value, "bar", "")
+	assert.Equal(t, len(c.Children["dir"].Children), 0, "")
+	c = sn.copyNode(nil, n, nil, -1)
+	assert.Equal(t, c.Children["dir"].Children["y"].Value, "baz", "")
+	s.releaseSnapshot(sn)
+
+	// the reads release their snapshots
+	e, err := s.Get("/foo", true, true)
+	assert.Nil(t, err, "")
+	assert.Equal(t, len(e.Node.Nodes), 2, "")
+	assert.Equal(t, *e.Node.Nodes[0].Value, "barbar", "")
+	assert.Equal(t, len(s.snapshots), 0, "")
+}
+
+func TestStoreConcurrentReads(t *testing.T) {
+	testStoreConcurrentReads(t, newStore())
+}
+
+// testStoreConcurrentReads tests that the reads running concurrently with
+// the writes see the store at a single index. The writes keep the values
+// of /dir/a and /dir/b equal.
+func testStoreConcurrentReads(t *testing.T, s Store) {
+	s.Set("/dir/a", false, "0", Permanent)
+	s.Set("/dir/b", false, "0", Permanent)
+
+	donec := make(chan struct{})
+	go func() {
+		defer close(donec)
+		for i := 1; i <= 200; i++ {
+			v := fmt.Sprint(i)
+			s.Txn(nil, []TxnOp{
+				{Action: Set, Path: "/dir/a", Value: v},
+				{Action: Set, Path: "/dir/b", Value: v},
+			}, nil)
+		}
+	}()
+
+	errc := make(chan error, 4)
+	var wg sync.WaitGroup
+	for r := 0; r < 4; r++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			var index uint64
+			for {
+				select {
+				case <-donec:
+					return
+				default:
+				}
+				e, err := s.Get("/dir", true, true)
+				if err != nil {
+					errc <- err
+					return
+				}
+				a, b := *e.Node.Nodes[0].Value, *e.Node.Nodes[1].Value
+				if a != b || e.EtcdIndex < index || e.Node.Nodes[1].ModifiedIndex > e.EtcdIndex {
+					errc <- fmt.Errorf("read a=%s b=%s at index %d after %d", a, b, e.EtcdIndex, index)
+					return
+				}
+				index = e.EtcdIndex
+			}
+		}()
+	}
+	wg.Wait()
+	close(errc)
+	for err := range errc {
+		t.Error(err)
+	}
+}
+
 // Ensure that the store can watch for hidden keys as long as it's an exact path match.
 func TestStoreWatchCreateWithHiddenKey(t *testing.T) {
 	s := newStore()
 	var eidx uint64 = 1
-	w, _ := s.Watch("/_foo", false, false, 0)
+	w, _ := s.Watch("/_foo", false, false, 0, nil)
 	s.Create("/_foo", false, "bar", false, Permanent)
 	e := nbselect(w.EventChan())
 	assert.Equal(t, e.EtcdIndex, eidx, "")
@@ -862,11 +1835,11 @@ func TestStoreWatchCreateWithHiddenKey(t *testing.T) {
 // Ensure that the store doesn't see hidden key creates without an exact path match in recursive mode.
 func TestStoreWatchRecursiveCreateWithHiddenKey(t *testing.T) {
 	s := newStore()
-	w, _ := s.Watch("/foo", true, false, 0)
+	w, _ := s.Watch("/foo", true, false, 0, nil)
 	s.Create("/foo/_bar", false, "baz", false, Permanent)
 	e := nbselect(w.EventChan())
 	assert.Nil(t, e, "")
-	w, _ = s.Watch("/foo", true, false, 0)
+	w, _ = s.Watch("/foo", true, false, 0, nil)
 	s.Create("/foo/_baz", true, "", false, Permanent)
 	e = nbselect(w.EventChan())
 	assert.Nil(t, e, "")
@@ -879,7 +1852,7 @@ func TestStoreWatchRecursiveCreateWithHiddenKey(t *testing.T) {
 func TestStoreWatchUpdateWithHiddenKey(t *testing.T) {
 	s := newStore()
 	s.Create("/_foo", false, "bar", false, Permanent)
-	w, _ := s.Watch("/_foo", false, false, 0)
+	w, _ := s.Watch("/_foo", false, false, 0, nil)
 	s.Update("/_foo", "baz", Permanent)
 	e := nbselect(w.EventChan())
 	assert.Equal(t, e.Action, "update", "")
@@ -892,7 +1865,7 @@ func TestStoreWatchUpdateWithHiddenKey(t *testing.T) {
 func TestStoreWatchRecursiveUpdateWithHiddenKey(t *testing.T) {
 	s := newStore()
 	s.Create("/foo/_bar", false, "baz", false, Permanent)
-	w, _ := s.Watch("/foo", true, false, 0)
+	w, _ := s.Watch("/foo", true, false, 0, nil)
 	s.Update("/foo/_bar", "baz", Permanent)
 	e := nbselect(w.EventChan())
 	assert.Nil(t, e, "")
@@ -903,7 +1876,7 @@ func TestStoreWatchDeleteWithHiddenKey(t *testing.T) {
 	s := newStore()
 	var eidx uint64 = 2
 	s.Create("/_foo", false, "bar", false, Permanent)
-	w, _ := s.Watch("/_foo", false, false, 0)
+	w, _ := s.Watch("/_foo", false, false, 0, nil)
 	s.Delete("/_foo", false, false)
 	e := nbselect(w.EventChan())
 	assert.Equal(t, e.EtcdIndex, eidx, "")
@@ -917,7 +1890,7 @@ func TestStoreWatchDeleteWithHiddenKey(t *testing.T) {
 func TestStoreWatchRecursiveDeleteWithHiddenKey(t *testing.T) {
 	s := newStore()
 	s.Create("/foo/_bar", false, "baz", false, Permanent)
-	w, _ := s.Watch("/foo", true, false, 0)
+	w, _ := s.Watch("/foo", true, false, 0, nil)
 	s.Delete("/foo/_bar", false, false)
 	e := nbselect(w.EventChan())
 	assert.Nil(t, e, "")
@@ -932,7 +1905,7 @@ func TestStoreWatchExpireWithHiddenKey(t *testing.T) {
 	s.Create("/_foo", false, "bar", false, fc.Now().Add(500*time.Millisecond))
 	s.Create("/foofoo", false, "barbarbar", false, fc.Now().Add(1000*time.Millisecond))
 
-	w, _ := s.Watch("/", true, false, 0)
+	w, _ := s.Watch("/", true, false, 0, nil)
 	c := w.EventChan()
 	e := nbselect(c)
 	assert.Nil(t, e, "")
@@ -951,7 +1924,7 @@ func TestStoreWatchExpireWithHiddenKey(t *testing.T) {
 func TestStoreWatchRecursiveCreateDeeperThanHiddenKey(t *testing.T) {
 	s := newStore()
 	var eidx uint64 = 1
-	w, _ := s.Watch("/_foo/bar", true, false, 0)
+	w, _ := s.Watch("/_foo/bar", true, false, 0, nil)
 	s.Create("/_foo/bar/baz", false, "baz", false, Permanent)
 
 	e := nbselect(w.EventChan())
@@ -970,7 +1943,7 @@ func TestStoreWatchRecursiveCreateDeeperThanHiddenKey(t *testing.T) {
 // to operate correctly.
 func TestStoreWatchSlowConsumer(t *testing.T) {
 	s := newStore()
-	s.Watch("/foo", true, true, 0)       // stream must be true
+	s.Watch("/foo", true, true, 0, nil)  // stream must be true
 	s.Set("/foo", false, "1", Permanent) // ok
 	s.Set("/foo", false, "2", Permanent) // ok
 	s.Set("/foo", false, "3", Permanent) // must not panic
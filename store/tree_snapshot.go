@@ -0,0 +1,115 @@
+// Copyright 2015 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package store
+
+import "sync"
+
+// treeSnapshot is a copy-on-write view of the node tree at the time it
+// is taken. Taking it does not copy the tree. Instead, the store saves
+// a copy of a node right before the node is modified for the first time,
+// and the snapshot reads the saved copy instead of the live node.
+// 快照时不拷贝整棵树，节点在第一次被修改前才保存一份拷贝
+type treeSnapshot struct {
+	// src is the store the snapshot is taken from.
+	src *store
+	// index is the store index when the snapshot is taken. The nodes
+	// created after it are not part of the snapshot.
+	index uint64
+	root  *node
+
+	mu sync.Mutex
+	// saved maps the live nodes modified since the snapshot is taken
+	// to their state at that time.
+	saved map[*node]*node
+
+	// once copies the tree into the clone that takes the snapshot.
+	once sync.Once
+}
+
+func newTreeSnapshot(src *store, root *node, index uint64) *treeSnapshot {
+	return &treeSnapshot{
+		src:   src,
+		index: index,
+		root:  root,
+		saved: make(map[*node]*node),
+	}
+}
+
+// preserve saves the current state of the node if it is part of the
+// snapshot and has not been saved yet. It must be called before the
+// node is modified.
+func (sn *treeSnapshot) preserve(n *node) {
+	if n.CreatedIndex > sn.index {
+		return
+	}
+	sn.mu.Lock()
+	defer sn.mu.Unlock()
+	if _, ok := sn.saved[n]; !ok {
+		sn.saved[n] = n.shallowCopy()
+	}
+}
+
+// get returns the state of the node when the snapshot is taken. The
+// live node is read with the lock held, so it cannot be modified
+// concurrently.
+func (sn *treeSnapshot) get(n *node) *node {
+	sn.mu.Lock()
+	defer sn.mu.Unlock()
+	if saved, ok := sn.saved[n]; ok {
+		return saved
+	}
+	return n.shallowCopy()
+}
+
+// copyTree copies the tree of the snapshot into the given store.
+func (sn *treeSnapshot) copyTree(s *store) *node {
+	return sn.copyNode(s, sn.root, nil, -1)
+}
+
+// copyNode copies the node of the snapshot into the given store, which
+// is nil for the copies of a read. The nodes under it are copied down to
+// the given depth, or all of them if the depth is negative.
+func (sn *treeSnapshot) copyNode(s *store, n *node, parent *node, depth int) *node {
+	v := sn.get(n)
+	if !v.IsDir() {
+		kv := newKV(s, v.Path, v.Value, v.CreatedIndex, parent, v.ExpireTime)
+		kv.ModifiedIndex = v.ModifiedIndex
+		return kv
+	}
+
+	dir := newDir(s, v.Path, v.CreatedIndex, parent, v.ExpireTime)
+	dir.ModifiedIndex = v.ModifiedIndex
+	if depth == 0 {
+		return dir
+	}
+	for key, child := range v.Children {
+		dir.Children[key] = sn.copyNode(s, child, dir, depth-1)
+	}
+	return dir
+}
+
+// shallowCopy copies the node without its children. The children map
+// of a directory is copied, so the copy is not affected by the changes
+// of the directory.
+func (n *node) shallowCopy() *node {
+	c := *n
+	if n.Children != nil {
+		c.Children = make(map[string]*node, len(n.Children))
+		for key, child := range n.Children {
+			c.Children[key] = child
+		}
+	}
+	return &c
+}
@@ -0,0 +1,229 @@
+// Copyright 2015 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package store
+
+import (
+	"sort"
+	"time"
+)
+
+const (
+	// ttlWheelTick is the time covered by a slot of the lowest level.
+	ttlWheelTick = 100 * time.Millisecond
+	// ttlWheelBits is the log2 of the number of the slots of a level.
+	ttlWheelBits  = 6
+	ttlWheelSlots = 1 << ttlWheelBits
+	ttlWheelMask  = ttlWheelSlots - 1
+	// ttlWheelLevels levels of 64 slots of 100ms cover more than ten
+	// thousand years. The nodes beyond are kept in the last slot of the
+	// highest level, and placed again when the wheel reaches it.
+	ttlWheelLevels = 7
+)
+
+// ttlWheelLoc is the slot of a node in the wheel. A level of -1 means
+// the node is due.
+type ttlWheelLoc struct {
+	level int
+	slot  int
+}
+
+// ttlWheel is a hierarchical timing wheel of the nodes with a ttl. A
+// node is kept in the slot of its expire tick, so adding and removing a
+// node are O(1), and so is advancing the wheel by a tick. A slot of a
+// higher level covers a whole turn of the level below it, and its nodes
+// are moved down when the wheel reaches the slot.
+// 分层时间轮，节点按过期时间放入对应的槽，增删节点都是O(1)
+type ttlWheel struct {
+	// slots[l][i] holds the nodes of the i-th slot of level l. The maps
+	// are allocated on first use.
+	slots [ttlWheelLevels][ttlWheelSlots]map[*node]bool
+	// due holds the nodes whose tick the wheel has reached. They expire
+	// once the cutoff passes their expire time.
+	due map[*node]bool
+	// locs maps the nodes in the wheel to their slots.
+	locs map[*node]ttlWheelLoc
+	// current is the last tick the wheel has advanced to. It is set by
+	// the first advance; until then, all the nodes are kept in due.
+	current int64
+	started bool
+}
+
+func newTTLWheel() *ttlWheel {
+	return &ttlWheel{
+		due:  make(map[*node]bool),
+		locs: make(map[*node]ttlWheelLoc),
+	}
+}
+
+// ttlTick returns the tick of the given time.
+func ttlTick(t time.Time) int64 {
+	return t.Unix()*int64(time.Second/ttlWheelTick) + int64(t.Nanosecond())/int64(ttlWheelTick)
+}
+
+func (w *ttlWheel) len() int { return len(w.locs) }
+
+func (w *ttlWheel) contains(n *node) bool {
+	_, ok := w.locs[n]
+	return ok
+}
+
+func (w *ttlWheel) push(n *node) {
+	if w.contains(n) {
+		return
+	}
+	w.insert(n)
+}
+
+// update moves the node to the slot of its new expire time.
+func (w *ttlWheel) update(n *node) {
+	if w.contains(n) {
+		w.remove(n)
+		w.insert(n)
+	}
+}
+
+func (w *ttlWheel) remove(n *node) {
+	loc, ok := w.locs[n]
+	if !ok {
+		return
+	}
+	delete(w.locs, n)
+	if loc.level < 0 {
+		delete(w.due, n)
+		return
+	}
+	delete(w.slots[loc.level][loc.slot], n)
+}
+
+// insert puts the node into the slot of its tick relative to the current
+// tick: the lowest level whose turn reaches the tick.
+func (w *ttlWheel) insert(n *node) {
+	t := ttlTick(n.ExpireTime)
+	delta := t - w.current
+	if !w.started || delta <= 0 {
+		w.due[n] = true
+		w.locs[n] = ttlWheelLoc{level: -1}
+		return
+	}
+	var loc ttlWheelLoc
+	if delta >= 1<<(ttlWheelBits*ttlWheelLevels) {
+		// beyond the range; wait in the slot reached last
+		loc.level = ttlWheelLevels - 1
+		loc.slot = int(w.current>>(ttlWheelBits*(ttlWheelLevels-1))+ttlWheelMask) & ttlWheelMask
+	} else {
+		for loc.level < ttlWheelLevels-1 && delta >= 1<<(ttlWheelBits*uint(loc.level+1)) {
+			loc.level++
+		}
+		loc.slot = int(t>>(ttlWheelBits*uint(loc.level))) & ttlWheelMask
+	}
+	slot := w.slots[loc.level][loc.slot]
+	if slot == nil {
+		slot = make(map[*node]bool)
+		w.slots[loc.level][loc.slot] = slot
+	}
+	slot[n] = true
+	w.locs[n] = loc
+}
+
+// advance moves the nodes up to the target tick into due. The wheel
+// jumps over the ticks if no node is left in the slots.
+func (w *ttlWheel) advance(target int64) {
+	if !w.started {
+		w.started = true
+		w.current = target
+		for n := range w.due {
+			if ttlTick(n.ExpireTime) > target {
+				w.remove(n)
+				w.insert(n)
+			}
+		}
+		return
+	}
+	for w.current < target {
+		if len(w.locs) == len(w.due) {
+			w.current = target
+			return
+		}
+		w.step()
+	}
+}
+
+// step advances the wheel by one tick. The slots of the higher levels
+// starting at the tick are moved down first, so that their nodes of the
+// tick end up in the slot of the lowest level, which is then due.
+func (w *ttlWheel) step() {
+	w.current++
+	c := w.current
+	for l := uint(ttlWheelLevels - 1); l >= 1; l-- {
+		if c&(1<<(ttlWheelBits*l)-1) != 0 {
+			continue
+		}
+		i := int(c>>(ttlWheelBits*l)) & ttlWheelMask
+		nodes := w.slots[l][i]
+		w.slots[l][i] = nil
+		for n := range nodes {
+			delete(w.locs, n)
+			w.insert(n)
+		}
+	}
+	i := int(c) & ttlWheelMask
+	for n := range w.slots[0][i] {
+		w.due[n] = true
+		w.locs[n] = ttlWheelLoc{level: -1}
+	}
+	w.slots[0][i] = nil
+}
+
+// expired returns the nodes that expire at or before the cutoff, sorted
+// by their expire time and path. The order does not depend on the
+// layout of the wheel, and is the order of the ttl bucket of the bolt
+// store, so all the members expire the same nodes.
+func (w *ttlWheel) expired(cutoff time.Time) []*node {
+	w.advance(ttlTick(cutoff))
+	var nodes []*node
+	for n := range w.due {
+		if !n.ExpireTime.After(cutoff) {
+			nodes = append(nodes, n)
+		}
+	}
+	sort.Sort(nodesByExpireTime(nodes))
+	return nodes
+}
+
+type nodesByExpireTime []*node
+
+func (a nodesByExpireTime) Len() int      { return len(a) }
+func (a nodesByExpireTime) Swap(i, j int) { a[i], a[j] = a[j], a[i] }
+func (a nodesByExpireTime) Less(i, j int) bool {
+	if !a[i].ExpireTime.Equal(a[j].ExpireTime) {
+		return a[i].ExpireTime.Before(a[j].ExpireTime)
+	}
+	return boltPathLess(a[i].Path, a[j].Path)
+}
+
+// boltPathLess reports whether the path a sorts before b as a bolt key,
+// in which the separator sorts before every other byte.
+func boltPathLess(a, b string) bool {
+	for i := 0; i < len(a) && i < len(b); i++ {
+		if a[i] == b[i] {
+			continue
+		}
+		if a[i] == '/' || b[i] == '/' {
+			return a[i] == '/'
+		}
+		return a[i] < b[i]
+	}
+	return len(a) < len(b)
+}
@@ -0,0 +1,142 @@
+// Copyright 2015 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package store
+
+import (
+	"fmt"
+	"math/rand"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestTTLWheelExpired(t *testing.T) {
+	w := newTTLWheel()
+	base := time.Unix(1000, 0)
+	ttls := []time.Duration{
+		50 * time.Millisecond,
+		time.Second,
+		time.Minute,
+		time.Hour,
+		30 * 24 * time.Hour,
+	}
+	// the wheel starts on the first advance, after the nodes are pushed
+	for i, ttl := range ttls {
+		w.push(newKV(nil, fmt.Sprint(i), "", 0, nil, base.Add(ttl)))
+	}
+	if n := w.expired(base); len(n) != 0 {
+		t.Fatalf("expired = %v, want none", n)
+	}
+	for i, ttl := range ttls {
+		if n := w.expired(base.Add(ttl - time.Millisecond)); len(n) != 0 {
+			t.Fatalf("#%d: expired = %v before the expire time", i, n)
+		}
+		n := w.expired(base.Add(ttl))
+		if len(n) != 1 || n[0].Path != fmt.Sprint(i) {
+			t.Fatalf("#%d: expired = %v, want %d", i, n, i)
+		}
+		w.remove(n[0])
+	}
+	if w.len() != 0 {
+		t.Errorf("len = %d, want 0", w.len())
+	}
+}
+
+func TestTTLWheelUpdateRemove(t *testing.T) {
+	w := newTTLWheel()
+	base := time.Unix(1000, 0)
+	w.expired(base)
+
+	kvs := make([]*node, 3)
+	for i := range kvs {
+		kvs[i] = newKV(nil, fmt.Sprint(i), "", 0, nil, base.Add(time.Duration(i+1)*time.Second))
+		w.push(kvs[i])
+	}
+	kvs[0].ExpireTime = base.Add(time.Hour)
+	w.update(kvs[0])
+	w.remove(kvs[1])
+
+	n := w.expired(base.Add(time.Minute))
+	if !reflect.DeepEqual(n, []*node{kvs[2]}) {
+		t.Errorf("expired = %v, want [2]", n)
+	}
+	n = w.expired(base.Add(time.Hour))
+	if !reflect.DeepEqual(n, []*node{kvs[2], kvs[0]}) {
+		t.Errorf("expired = %v, want [2 0]", n)
+	}
+}
+
+// TestTTLWheelOrder tests that the expired nodes are sorted by their
+// expire time and then by their path as a bolt key.
+func TestTTLWheelOrder(t *testing.T) {
+	w := newTTLWheel()
+	base := time.Unix(1000, 0)
+	for _, p := range []string{"/a-", "/b", "/a/b", "/a"} {
+		w.push(newKV(nil, p, "", 0, nil, base))
+	}
+	w.push(newKV(nil, "/c", "", 0, nil, base.Add(-time.Second)))
+
+	var paths []string
+	for _, n := range w.expired(base) {
+		paths = append(paths, n.Path)
+	}
+	if wpaths := []string{"/c", "/a", "/a/b", "/a-", "/b"}; !reflect.DeepEqual(paths, wpaths) {
+		t.Errorf("paths = %v, want %v", paths, wpaths)
+	}
+}
+
+// TestTTLWheelRandom tests that the wheel expires the same nodes as a
+// scan of all the nodes, for random expire times and cutoffs.
+func TestTTLWheelRandom(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	w := newTTLWheel()
+	base := time.Unix(1000, 0)
+	live := make(map[*node]bool)
+	cutoff := base
+	for round := 0; round < 500; round++ {
+		for i := 0; i < 20; i++ {
+			ttl := time.Duration(r.Int63n(int64(time.Duration(1+r.Intn(5)) * 24 * time.Hour)))
+			// some of them expire before the cutoff
+			n := newKV(nil, fmt.Sprint(round, "/", i), "", 0, nil, cutoff.Add(ttl-time.Minute))
+			w.push(n)
+			live[n] = true
+		}
+		cutoff = cutoff.Add(time.Duration(r.Int63n(int64(2 * time.Hour))))
+
+		var want []*node
+		for n := range live {
+			if !n.ExpireTime.After(cutoff) {
+				want = append(want, n)
+			}
+		}
+		got := w.expired(cutoff)
+		if len(got) != len(want) {
+			t.Fatalf("round %d: expired %d nodes, want %d", round, len(got), len(want))
+		}
+		for i, n := range got {
+			if !live[n] || n.ExpireTime.After(cutoff) {
+				t.Fatalf("round %d: unexpected expired node %s", round, n.Path)
+			}
+			if i > 0 && !nodesByExpireTime(got).Less(i-1, i) {
+				t.Fatalf("round %d: nodes are not sorted at %d", round, i)
+			}
+			w.remove(n)
+			delete(live, n)
+		}
+		if w.len() != len(live) {
+			t.Fatalf("round %d: len = %d, want %d", round, w.len(), len(live))
+		}
+	}
+}
@@ -0,0 +1,232 @@
+// Copyright 2015 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package store
+
+import (
+	"path"
+	"strings"
+	"time"
+
+	etcdErr "github.com/coreos/etcd/error"
+	"github.com/coreos/etcd/pkg/types"
+)
+
+// The targets a TxnCompare can check.
+const (
+	TxnCompareValue = iota
+	TxnCompareCreatedIndex
+	TxnCompareModifiedIndex
+)
+
+// TxnCompare is a guard of a transaction. It holds if the target of the
+// node at Path equals Value (for TxnCompareValue) or Index (for the index
+// targets). A node that does not exist has an empty value and zero indexes,
+// so comparing its created index with 0 checks that the key is absent.
+type TxnCompare struct {
+	Path   string
+	Target int
+	Value  string
+	Index  uint64
+}
+
+// TxnOp is an operation applied by a transaction. Action is either
+// Set or Delete, and the other fields have the same meaning as the
+// arguments of the corresponding store method.
+type TxnOp struct {
+	Action     string
+	Path       string
+	Value      string
+	Dir        bool
+	Recursive  bool
+	ExpireTime time.Time
+}
+
+// TxnResponse is the result of a transaction. Succeeded reports whether
+// all compares held, that is, whether the success operations were applied.
+// Events holds one event per applied operation.
+type TxnResponse struct {
+	Succeeded bool
+	Events    []*Event
+	EtcdIndex uint64
+}
+
+// Txn applies the success operations if all the compares hold, and the
+// failure operations otherwise.
+// The operations are applied atomically: if any of them would fail, none
+// of them is applied and the error is returned. The paths of the operations
+// in one branch must not overlap, so that the outcome of an operation does
+// not depend on the ones before it.
+func (s *store) Txn(cmps []TxnCompare, success, failure []TxnOp) (*TxnResponse, error) {
+	s.worldLock.Lock()
+	defer s.worldLock.Unlock()
+
+	succeeded := true
+	for _, c := range cmps {
+		if !s.txnCompare(c) {
+			succeeded = false
+			break
+		}
+	}
+	ops := failure
+	if succeeded {
+		ops = success
+	}
+
+	if err := s.checkTxnOps(ops); err != nil {
+		s.Stats.Inc(TxnFail)
+		return nil, err
+	}
+
+	resp := &TxnResponse{Succeeded: succeeded}
+	for _, op := range ops {
+		var e *Event
+		var err error
+		switch op.Action {
+		case Set:
+			e, err = s.internalCreate(op.Path, op.Dir, op.Value, false, true, op.ExpireTime, Set)
+			if err == nil {
+				e.EtcdIndex = s.CurrentIndex
+				s.notify(e)
+				s.Stats.Inc(SetSuccess)
+			}
+		case Delete:
+			e, err = s.internalDelete(op.Path, op.Dir, op.Recursive)
+		}
+		// checkTxnOps has verified that the operation can be applied,
+		// so this should never happen.
+		if err != nil {
+			s.Stats.Inc(TxnFail)
+			return nil, err
+		}
+		resp.Events = append(resp.Events, e)
+	}
+	resp.EtcdIndex = s.CurrentIndex
+
+	s.Stats.Inc(TxnSuccess)
+	return resp, nil
+}
+
+func (s *store) txnCompare(c TxnCompare) bool {
+	var value string
+	var createdIndex, modifiedIndex uint64
+	if n, err := s.internalGet(c.Path); err == nil {
+		value, createdIndex, modifiedIndex = n.Value, n.CreatedIndex, n.ModifiedIndex
+	}
+	return c.holds(value, createdIndex, modifiedIndex)
+}
+
+// holds reports whether the compare holds for a node with the given
+// value and indexes.
+func (c TxnCompare) holds(value string, createdIndex, modifiedIndex uint64) bool {
+	switch c.Target {
+	case TxnCompareValue:
+		return value == c.Value
+	case TxnCompareCreatedIndex:
+		return createdIndex == c.Index
+	case TxnCompareModifiedIndex:
+		return modifiedIndex == c.Index
+	default:
+		return false
+	}
+}
+
+// checkTxnOps verifies that all the given operations can be applied
+// without touching the store.
+func (s *store) checkTxnOps(ops []TxnOp) *etcdErr.Error {
+	if err := checkTxnPaths(ops, s.readonlySet, s.CurrentIndex); err != nil {
+		return err
+	}
+	for _, op := range ops {
+		nodePath := path.Clean(path.Join("/", op.Path))
+
+		var err *etcdErr.Error
+		switch op.Action {
+		case Set:
+			err = s.checkTxnSet(nodePath)
+		case Delete:
+			err = s.checkTxnDelete(nodePath, op.Dir, op.Recursive)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// checkTxnPaths verifies that the operations have supported actions and
+// that their paths are writable and do not overlap.
+func checkTxnPaths(ops []TxnOp, readonlySet types.Set, index uint64) *etcdErr.Error {
+	paths := make([]string, 0, len(ops))
+	for _, op := range ops {
+		if op.Action != Set && op.Action != Delete {
+			return etcdErr.NewError(etcdErr.EcodeInvalidField, "txn: unsupported action "+op.Action, index)
+		}
+		nodePath := path.Clean(path.Join("/", op.Path))
+		// we do not allow the user to change "/"
+		if readonlySet.Contains(nodePath) {
+			return etcdErr.NewError(etcdErr.EcodeRootROnly, "/", index)
+		}
+		for _, p := range paths {
+			if isPathOverlapped(nodePath, p) {
+				return etcdErr.NewError(etcdErr.EcodeInvalidField, "txn: overlapped key "+nodePath, index)
+			}
+		}
+		paths = append(paths, nodePath)
+	}
+	return nil
+}
+
+// checkTxnSet verifies that the node at nodePath can be set, which
+// requires every existing ancestor to be a directory and an existing
+// node to be a file.
+func (s *store) checkTxnSet(nodePath string) *etcdErr.Error {
+	curr := s.root()
+	for _, name := range strings.Split(nodePath, "/")[1:] {
+		if !curr.IsDir() {
+			return etcdErr.NewError(etcdErr.EcodeNotDir, curr.Path, s.CurrentIndex)
+		}
+		child, ok := curr.Children[name]
+		if !ok {
+			return nil
+		}
+		curr = child
+	}
+	if curr.IsDir() {
+		return etcdErr.NewError(etcdErr.EcodeNotFile, nodePath, s.CurrentIndex)
+	}
+	return nil
+}
+
+func (s *store) checkTxnDelete(nodePath string, dir, recursive bool) *etcdErr.Error {
+	n, err := s.internalGet(nodePath)
+	if err != nil {
+		return err
+	}
+	if n.IsDir() {
+		if !dir && !recursive {
+			return etcdErr.NewError(etcdErr.EcodeNotFile, nodePath, s.CurrentIndex)
+		}
+		if len(n.Children) != 0 && !recursive {
+			return etcdErr.NewError(etcdErr.EcodeDirNotEmpty, nodePath, s.CurrentIndex)
+		}
+	}
+	return nil
+}
+
+// isPathOverlapped reports whether one of the given paths equals the
+// other or is a directory containing it.
+func isPathOverlapped(a, b string) bool {
+	return a == b || strings.HasPrefix(a, b+"/") || strings.HasPrefix(b, a+"/")
+}
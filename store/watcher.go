@@ -22,10 +22,12 @@ type Watcher interface {
 
 type watcher struct {
 	eventChan  chan *Event
+	key        string
 	stream     bool
 	recursive  bool
 	sinceIndex uint64
 	startIndex uint64
+	actions    []string // 只关心这些action的事件, nil表示全部
 	hub        *watcherHub
 	removed    bool
 	remove     func()
@@ -57,20 +59,49 @@ func (w *watcher) notify(e *Event, originalPath bool, deleted bool) bool {
 	// at the file we need to delete.
 	// For example a watcher is watching at "/foo/bar". And we deletes "/foo". The watcher
 	// should get notified even if "/foo" is not the path it is watching.
-	if (w.recursive || originalPath || deleted) && e.Index() >= w.sinceIndex {
+	//
+	// The watcher is also only interested in the actions it filters on, if any.
+	if (w.recursive || originalPath || deleted) && e.Index() >= w.sinceIndex && matchAction(w.actions, e.Action) {
 		// We cannot block here if the eventChan capacity is full, otherwise
 		// etcd will hang. eventChan capacity is full when the rate of
 		// notifications are higher than our send rate.
-		// If this happens, we close the channel.
-		select {
-		case w.eventChan <- e:
-		default:
-			// We have missed a notification. Remove the watcher.
-			// Removing the watcher also closes the eventChan.
-			w.remove()
+		// The last slot of the eventChan is kept for an overflow event,
+		// which tells the receiver the index to re-sync from before the
+		// watcher is removed. Only the hub sends on the eventChan, under
+		// its mutex, so the slot is always free when needed.
+		// 缓冲区满时发送overflow事件，而不是静默丢弃
+		if len(w.eventChan) < cap(w.eventChan)-1 {
+			w.eventChan <- e
+			return true
 		}
+		w.eventChan <- newOverflowEvent(w.key, e.Index())
+		watcherOverflowCounter.Inc()
+		w.remove()
+		return true
+	}
+	return false
+}
+
+// newOverflowEvent returns the overflow event of a watcher of the key
+// that missed the event at the given index.
+func newOverflowEvent(key string, index uint64) *Event {
+	e := newEvent(Overflow, key, index, 0)
+	e.EtcdIndex = index
+	e.ResyncIndex = index
+	return e
+}
+
+// matchAction returns true if the action is one of the given actions.
+// Empty actions match any action.
+func matchAction(actions []string, action string) bool {
+	if len(actions) == 0 {
 		return true
 	}
+	for _, a := range actions {
+		if a == action {
+			return true
+		}
+	}
 	return false
 }
 
@@ -35,8 +35,15 @@ type watcherHub struct {
 	watchers     map[string]*list.List
 	count        int64 // current number of watchers.
 	EventHistory *EventHistory
+	// bufferSize is the number of events a watcher buffers, including
+	// the slot of its overflow event.
+	bufferSize int
 }
 
+// DefaultWatcherBufferSize is the number of events a watcher buffers
+// for a receiver that falls behind, before it overflows.
+const DefaultWatcherBufferSize = 100
+
 // newWatchHub creates a watchHub. The capacity determines how many events we will
 // keep in the eventHistory.
 // Typically, we only need to keep a small size of history[smaller than 20K].
@@ -45,6 +52,7 @@ func newWatchHub(capacity int) *watcherHub {
 	return &watcherHub{
 		watchers:     make(map[string]*list.List),
 		EventHistory: newEventHistory(capacity),
+		bufferSize:   DefaultWatcherBufferSize,
 	}
 }
 
@@ -52,8 +60,8 @@ func newWatchHub(capacity int) *watcherHub {
 // If recursive is true, the first change after index under key will be sent to the event channel of the watcher.
 // If recursive is false, the first change after index at key will be sent to the event channel of the watcher.
 // If index is zero, watch will start from the current index + 1.
-func (wh *watcherHub) watch(key string, recursive, stream bool, index, storeIndex uint64) (Watcher, *etcdErr.Error) {
-	event, err := wh.EventHistory.scan(key, recursive, index)
+func (wh *watcherHub) watch(key string, recursive, stream bool, index, storeIndex uint64, actions []string) (Watcher, *etcdErr.Error) {
+	event, err := wh.EventHistory.scan(key, recursive, index, actions)
 
 	if err != nil {
 		err.Index = storeIndex
@@ -61,11 +69,13 @@ func (wh *watcherHub) watch(key string, recursive, stream bool, index, storeInde
 	}
 
 	w := &watcher{
-		eventChan:  make(chan *Event, 100), // use a buffered channel
+		eventChan:  make(chan *Event, wh.bufferSize), // use a buffered channel
+		key:        key,
 		recursive:  recursive,
 		stream:     stream,
 		sinceIndex: index,
 		startIndex: storeIndex,
+		actions:    actions,
 		hub:        wh,
 	}
 
@@ -97,12 +107,14 @@ func (wh *watcherHub) watch(key string, recursive, stream bool, index, storeInde
 		w.removed = true
 		l.Remove(elem)
 		atomic.AddInt64(&wh.count, -1)
+		watcherGauge.Dec()
 		if l.Len() == 0 {
 			delete(wh.watchers, key)
 		}
 	}
 
 	atomic.AddInt64(&wh.count, 1)
+	watcherGauge.Inc()
 
 	return w, nil
 }
@@ -148,6 +160,7 @@ func (wh *watcherHub) notifyWatchers(e *Event, nodePath string, deleted bool) {
 					w.removed = true
 					l.Remove(curr)
 					atomic.AddInt64(&wh.count, -1)
+					watcherGauge.Dec()
 				}
 			}
 
@@ -162,6 +175,23 @@ func (wh *watcherHub) notifyWatchers(e *Event, nodePath string, deleted bool) {
 	}
 }
 
+// notifyMove notifies the watchers of the old key of a moved node: the
+// watchers of the moved nodes as if they were deleted, and the recursive
+// watchers of the directories the node is moved out of. The watchers of
+// the new key are notified by notify.
+func (wh *watcherHub) notifyMove(e *Event, moved []string) {
+	for _, p := range moved {
+		wh.notifyWatchers(e, p, true)
+	}
+	for dir := path.Dir(e.PrevNode.Key); dir != "/"; dir = path.Dir(dir) {
+		if strings.HasPrefix(e.Node.Key, dir+"/") {
+			// the common directories are notified by notify
+			break
+		}
+		wh.notifyWatchers(e, dir, false)
+	}
+}
+
 // clone function clones the watcherHub and return the cloned one.
 // only clone the static content. do not clone the current watchers.
 func (wh *watcherHub) clone() *watcherHub {
@@ -169,6 +199,7 @@ func (wh *watcherHub) clone() *watcherHub {
 
 	return &watcherHub{
 		EventHistory: clonedHistory,
+		bufferSize:   wh.bufferSize,
 	}
 }
 
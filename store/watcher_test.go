@@ -21,7 +21,7 @@ import (
 func TestWatcher(t *testing.T) {
 	s := newStore()
 	wh := s.WatcherHub
-	w, err := wh.watch("/foo", true, false, 1, 1)
+	w, err := wh.watch("/foo", true, false, 1, 1, nil)
 	if err != nil {
 		t.Fatalf("%v", err)
 	}
@@ -44,7 +44,7 @@ func TestWatcher(t *testing.T) {
 		t.Fatal("recv != send")
 	}
 
-	w, _ = wh.watch("/foo", false, false, 2, 1)
+	w, _ = wh.watch("/foo", false, false, 2, 1, nil)
 	c = w.EventChan()
 
 	e = newEvent(Create, "/foo/bar", 2, 2)
@@ -69,7 +69,7 @@ func TestWatcher(t *testing.T) {
 	}
 
 	// ensure we are doing exact matching rather than prefix matching
-	w, _ = wh.watch("/fo", true, false, 1, 1)
+	w, _ = wh.watch("/fo", true, false, 1, 1, nil)
 	c = w.EventChan()
 
 	select {
@@ -90,3 +90,36 @@ func TestWatcher(t *testing.T) {
 	}
 
 }
+
+// Ensure that a stream watcher that falls behind gets an overflow event
+// with the index to re-sync from, and is removed.
+func TestWatcherOverflow(t *testing.T) {
+	s := newStore()
+	wh := s.WatcherHub
+	wh.bufferSize = 3
+	w, _ := wh.watch("/foo", true, true, 1, 0, nil)
+
+	for i := uint64(1); i <= 4; i++ {
+		wh.notify(newEvent(Create, "/foo/bar", i, i))
+	}
+	if wh.count != 0 {
+		t.Fatalf("count = %d, want 0", wh.count)
+	}
+
+	c := w.EventChan()
+	for i := uint64(1); i <= 2; i++ {
+		if e := <-c; e.Action != Create || e.Index() != i {
+			t.Fatalf("#%d: event = %s %d, want create %d", i, e.Action, e.Index(), i)
+		}
+	}
+	e := <-c
+	if e.Action != Overflow || e.Node.Key != "/foo" || e.ResyncIndex != 3 {
+		t.Fatalf("event = %s %s %d, want overflow /foo 3", e.Action, e.Node.Key, e.ResyncIndex)
+	}
+	select {
+	case e = <-c:
+		t.Fatalf("unexpected event %v after overflow", e)
+	default:
+	}
+	w.Remove()
+}
@@ -107,8 +107,12 @@ func main() {
 				msg = fmt.Sprintf("%s\tnoop", msg)
 			case "SYNC":
 				msg = fmt.Sprintf("%s\tmethod=SYNC time=%q", msg, time.Unix(0, r.Time))
-			case "QGET", "DELETE":
+			case "QGET", "DELETE", "EXPORT":
 				msg = fmt.Sprintf("%s\tmethod=%s path=%s", msg, r.Method, excerpt(r.Path, 64, 64))
+			case "INCR":
+				msg = fmt.Sprintf("%s\tmethod=INCR path=%s delta=%d", msg, excerpt(r.Path, 64, 64), r.Delta)
+			case "MOVE":
+				msg = fmt.Sprintf("%s\tmethod=MOVE path=%s to=%s", msg, excerpt(r.Path, 64, 64), excerpt(r.MoveTo, 64, 64))
 			default:
 				msg = fmt.Sprintf("%s\tmethod=%s path=%s val=%s", msg, r.Method, excerpt(r.Path, 64, 64), excerpt(r.Val, 128, 0))
 			}
@@ -16,6 +16,7 @@ package version
 
 import (
 	"encoding/json"
+	"fmt"
 	"log"
 	"os"
 	"path"
@@ -25,7 +26,7 @@ import (
 )
 
 var (
-	Version = "2.0.4+git"
+	Version = "2.1.0+git"
 )
 
 // WalVersion is an enum for versions of etcd logs.
@@ -41,10 +42,35 @@ const (
 
 type Versions struct {
 	Server string `json:"etcdserver"`
-	// TODO: etcdcluster version
+	// Cluster is the cluster version, the lowest major and minor version
+	// of the members. It is empty while it is unknown.
+	Cluster string `json:"etcdcluster,omitempty"`
 	// TODO: raft state machine version
 }
 
+// MajorMinor returns the major and the minor version of the version v,
+// e.g. 2 and 1 for "2.1.3+git".
+func MajorMinor(v string) (major, minor int, err error) {
+	if _, err = fmt.Sscanf(v, "%d.%d", &major, &minor); err != nil {
+		return 0, 0, fmt.Errorf("version: cannot parse version %q (%v)", v, err)
+	}
+	return major, minor, nil
+}
+
+// LessMajorMinor reports whether the major and minor version of a is
+// lower than the one of b, ignoring the patch versions.
+func LessMajorMinor(a, b string) (bool, error) {
+	amajor, aminor, err := MajorMinor(a)
+	if err != nil {
+		return false, err
+	}
+	bmajor, bminor, err := MajorMinor(b)
+	if err != nil {
+		return false, err
+	}
+	return amajor < bmajor || (amajor == bmajor && aminor < bminor), nil
+}
+
 // MarshalJSON returns the JSON encoding of Versions struct.
 func MarshalJSON() []byte {
 	b, err := json.Marshal(Versions{Server: Version})
@@ -66,3 +66,53 @@ func mustMakeDir(t *testing.T, names ...string) string {
 	}
 	return p
 }
+
+func TestMajorMinor(t *testing.T) {
+	tests := []struct {
+		v string
+
+		wmajor, wminor int
+		werr           bool
+	}{
+		{"2.1.3", 2, 1, false},
+		{"2.0.4+git", 2, 0, false},
+		{"3.10.0-alpha.1", 3, 10, false},
+		{"v2", 0, 0, true},
+		{"", 0, 0, true},
+	}
+	for i, tt := range tests {
+		major, minor, err := MajorMinor(tt.v)
+		if (err != nil) != tt.werr {
+			t.Errorf("#%d: err = %v, want error %t", i, err, tt.werr)
+		}
+		if major != tt.wmajor || minor != tt.wminor {
+			t.Errorf("#%d: version = %d.%d, want %d.%d", i, major, minor, tt.wmajor, tt.wminor)
+		}
+	}
+}
+
+func TestLessMajorMinor(t *testing.T) {
+	tests := []struct {
+		a, b string
+
+		w    bool
+		werr bool
+	}{
+		{"2.0.4", "2.1.0", true, false},
+		{"2.1.3+git", "2.1.0", false, false},
+		{"2.1.0", "2.1.3", false, false},
+		{"3.0.0", "2.10.0", false, false},
+		{"2.10.0", "3.0.0", true, false},
+		{"v2", "2.1.0", false, true},
+		{"2.1.0", "", false, true},
+	}
+	for i, tt := range tests {
+		g, err := LessMajorMinor(tt.a, tt.b)
+		if (err != nil) != tt.werr {
+			t.Errorf("#%d: err = %v, want error %t", i, err, tt.werr)
+		}
+		if g != tt.w {
+			t.Errorf("#%d: less = %t, want %t", i, g, tt.w)
+		}
+	}
+}
@@ -22,6 +22,7 @@ import (
 	"sync"
 
 	"github.com/coreos/etcd/pkg/crc"
+	"github.com/coreos/etcd/pkg/encryption"
 	"github.com/coreos/etcd/pkg/pbutil"
 	"github.com/coreos/etcd/raft/raftpb"
 	"github.com/coreos/etcd/wal/walpb"
@@ -33,6 +34,11 @@ type decoder struct {
 
 	c   io.Closer
 	crc hash.Hash32
+
+	// lastValidOff is the offset after the last decoded record.
+	lastValidOff int64
+	// provider opens the sealed records if not nil
+	provider encryption.Provider
 }
 
 func newDecoder(rc io.ReadCloser) *decoder {
@@ -52,10 +58,31 @@ func (d *decoder) decode(rec *walpb.Record) error {
 	if err != nil {
 		return err
 	}
+	// the zeros after the last record in a preallocated file
+	if l == 0 {
+		return io.EOF
+	}
+	if l < 0 {
+		return d.tornOr(ErrInvalidRecordLength)
+	}
 	data := make([]byte, l)
 	if _, err = io.ReadFull(d.br, data); err != nil {
 		return err
 	}
+	if err := d.decodeRecord(rec, data); err != nil {
+		return d.tornOr(err)
+	}
+	d.lastValidOff += frameSizeBytes + l
+	return nil
+}
+
+func (d *decoder) decodeRecord(rec *walpb.Record, data []byte) error {
+	var err error
+	if d.provider != nil {
+		if data, err = d.provider.Open(data); err != nil {
+			return err
+		}
+	}
 	if err := rec.Unmarshal(data); err != nil {
 		return err
 	}
@@ -67,6 +94,27 @@ func (d *decoder) decode(rec *walpb.Record) error {
 	return rec.Validate(d.crc.Sum32())
 }
 
+// tornOr returns io.ErrUnexpectedEOF if the record that failed to decode
+// with err is followed by the zeros of the preallocation, since it is then
+// the last record, torn by a crash while it was written. Otherwise the WAL
+// is corrupted, and err is returned.
+// 最后一条记录解析失败时视为断电造成的写入不完整
+func (d *decoder) tornOr(err error) error {
+	b, perr := d.br.Peek(frameSizeBytes)
+	if perr != nil {
+		return err
+	}
+	for _, c := range b {
+		if c != 0 {
+			return err
+		}
+	}
+	return io.ErrUnexpectedEOF
+}
+
+// frameSizeBytes is the size of the length header of a record.
+const frameSizeBytes = 8
+
 func (d *decoder) updateCRC(prevCrc uint32) {
 	d.crc = crc.New(prevCrc, crcTable)
 }
@@ -48,6 +48,11 @@ increment from 0x0 to 0x1. The new file will be: 0000000000000001-00000000000000
 If a second cut issues 0x10 entries with incremental index later then the file will be called:
 0000000000000002-0000000000000031.wal.
 
+Each WAL file is preallocated to its full size when it is created, so the
+appends do not update the file size, and a full disk fails the creation of the
+file rather than a later Save. The records are followed by zeros up to the size;
+the zeros of a file are truncated when the file is cut.
+
 At a later time a WAL can be opened at a particular snapshot. If there is no
 snapshot, an empty snapshot should be passed in.
 
@@ -64,5 +69,14 @@ snapshot to the end of the WAL are read first:
 This will give you the metadata, the last raft.State and the slice of
 raft.Entry items in the log.
 
+Instead of a raft.MemoryStorage holding the whole log in memory, a Storage
+created on the WAL can serve the log to raft. It keeps the terms and a cache
+of the latest entries, and reads the older ones back from the WAL files:
+
+	s := wal.NewStorage(w, cacheSize)
+	...
+	err := w.Save(st, ents)
+	s.Append(ents)
+
 */
 package wal
@@ -22,6 +22,7 @@ import (
 	"sync"
 
 	"github.com/coreos/etcd/pkg/crc"
+	"github.com/coreos/etcd/pkg/encryption"
 	"github.com/coreos/etcd/wal/walpb"
 )
 
@@ -32,6 +33,8 @@ type encoder struct {
 	crc       hash.Hash32
 	buf       []byte
 	uint64buf []byte
+	// provider seals the records if not nil
+	provider encryption.Provider
 }
 
 func newEncoder(w io.Writer, prevCrc uint32) *encoder {
@@ -68,6 +71,11 @@ func (e *encoder) encode(rec *walpb.Record) error {
 		}
 		data = e.buf[:n]
 	}
+	if e.provider != nil {
+		if data, err = e.provider.Seal(data); err != nil {
+			return err
+		}
+	}
 	if err := writeInt64(e.bw, int64(len(data)), e.uint64buf); err != nil {
 		return err
 	}
@@ -21,6 +21,10 @@ var (
 		Name: "wal_fsync_durations_microseconds",
 		Help: "The latency distributions of fsync called by wal.",
 	})
+	syncBatchSaves = prometheus.NewSummary(prometheus.SummaryOpts{
+		Name: "wal_fsync_batch_saves",
+		Help: "The distributions of the number of saves committed by one fsync.",
+	})
 	lastIndexSaved = prometheus.NewGauge(prometheus.GaugeOpts{
 		Name: "wal_last_index_saved",
 		Help: "The index of the last entry saved by wal",
@@ -29,5 +33,6 @@ var (
 
 func init() {
 	prometheus.MustRegister(syncDurations)
+	prometheus.MustRegister(syncBatchSaves)
 	prometheus.MustRegister(lastIndexSaved)
 }
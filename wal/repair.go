@@ -20,31 +20,43 @@ import (
 	"os"
 	"path"
 
+	"github.com/coreos/etcd/pkg/encryption"
 	"github.com/coreos/etcd/pkg/fileutil"
 	"github.com/coreos/etcd/wal/walpb"
 )
 
 // Repair tries to repair the unexpectedEOF error in the
-// last wal file by truncating.
+// last wal file by truncating. The error is caused by a torn write of the
+// last record, e.g. on a power loss. The records after the last valid one
+// are discarded, and the original file is kept with the .broken suffix.
 func Repair(dirpath string) bool {
+	return RepairEncrypted(dirpath, nil)
+}
+
+// RepairEncrypted repairs the WAL created by CreateEncrypted with the same
+// key. Other than that, it is similar to Repair.
+func RepairEncrypted(dirpath string, p encryption.Provider) bool {
 	f, err := openLast(dirpath)
 	if err != nil {
 		return false
 	}
 	defer f.Close()
 
-	n := 0
 	rec := &walpb.Record{}
+	// index of the last valid entry in the file
+	var lastIndex uint64
 
 	decoder := newDecoder(f)
+	decoder.provider = p
 	defer decoder.close()
 	for {
 		err := decoder.decode(rec)
 		switch err {
 		case nil:
-			n += 8 + rec.Size()
 			// update crc of the decoder when necessary
 			switch rec.Type {
+			case entryType:
+				lastIndex = mustUnmarshalEntry(rec.Data).Index
 			case crcType:
 				crc := decoder.crc.Sum32()
 				// current crc of decoder must match the crc of the record.
@@ -76,7 +88,14 @@ func Repair(dirpath string) bool {
 				return false
 			}
 
-			if err = f.Truncate(int64(n)); err != nil {
+			fi, err := f.Stat()
+			if err != nil {
+				log.Printf("wal: could not repair %v, failed to stat file", f.Name())
+				return false
+			}
+			log.Printf("wal: discarding the records after offset %d of %v (%d bytes to the end of the file), the last valid entry has index %d",
+				decoder.lastValidOff, f.Name(), fi.Size()-decoder.lastValidOff, lastIndex)
+			if err = f.Truncate(decoder.lastValidOff); err != nil {
 				log.Printf("wal: could not repair %v, failed to truncate file", f.Name())
 				return false
 			}
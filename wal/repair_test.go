@@ -44,18 +44,18 @@ func TestRepair(t *testing.T) {
 			t.Fatal(err)
 		}
 	}
-	w.Close()
-
-	// break the wal.
-	f, err := openLast(p)
+	offset, err := w.offset()
 	if err != nil {
 		t.Fatal(err)
 	}
-	offset, err := f.Seek(-4, os.SEEK_END)
+	w.Close()
+
+	// break the wal by truncating the last record.
+	f, err := openLast(p)
 	if err != nil {
 		t.Fatal(err)
 	}
-	err = f.Truncate(offset)
+	err = f.Truncate(offset - 4)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -89,3 +89,87 @@ func TestRepair(t *testing.T) {
 		t.Fatalf("len(ents) = %d, want %d", len(ents), n-1)
 	}
 }
+
+// TestRepairTornWrite tests that a half-written last record followed by
+// the zeros of the preallocation is repaired, while a record corrupted in
+// the middle of the WAL is not.
+func TestRepairTornWrite(t *testing.T) {
+	p, err := ioutil.TempDir(os.TempDir(), "waltest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(p)
+	w, err := Create(p, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	n := 10
+	var offs []int64
+	for i := 1; i <= n; i++ {
+		es := []raftpb.Entry{{Index: uint64(i), Data: []byte("data")}}
+		if err = w.Save(raftpb.HardState{}, es); err != nil {
+			t.Fatal(err)
+		}
+		off, err := w.offset()
+		if err != nil {
+			t.Fatal(err)
+		}
+		offs = append(offs, off)
+	}
+	w.Close()
+
+	// write the head of a record, as a crash in the middle of the write
+	f, err := openLast(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	torn := []byte{64, 0, 0, 0, 0, 0, 0, 0, 8, 2, 16, 1, 2, 3}
+	if _, err = f.WriteAt(torn, offs[n-1]); err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+
+	w, err = Open(p, walpb.Snapshot{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, _, _, err = w.ReadAll(); err != io.ErrUnexpectedEOF {
+		t.Fatalf("err = %v, want %v", err, io.ErrUnexpectedEOF)
+	}
+	w.Close()
+	if !Repair(p) {
+		t.Fatalf("repair = false, want true")
+	}
+	w, err = Open(p, walpb.Snapshot{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, _, ents, err := w.ReadAll()
+	if err != nil {
+		t.Fatalf("err = %v, want nil", err)
+	}
+	if len(ents) != n {
+		t.Fatalf("len(ents) = %d, want %d", len(ents), n)
+	}
+	w.Close()
+
+	// corrupt the data of a record in the middle
+	if f, err = openLast(p); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = f.WriteAt([]byte("xxxx"), offs[n/2]-4); err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+	w, err = Open(p, walpb.Snapshot{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, _, _, err = w.ReadAll(); err == nil || err == io.ErrUnexpectedEOF {
+		t.Errorf("err = %v, want a corruption error", err)
+	}
+	w.Close()
+	if Repair(p) {
+		t.Errorf("repair = true, want false")
+	}
+}
@@ -0,0 +1,363 @@
+// Copyright 2015 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package wal
+
+import (
+	"io"
+	"log"
+	"os"
+	"path"
+	"sync"
+
+	"github.com/coreos/etcd/pkg/encryption"
+	"github.com/coreos/etcd/pkg/fileutil"
+	"github.com/coreos/etcd/raft"
+	"github.com/coreos/etcd/raft/raftpb"
+	"github.com/coreos/etcd/wal/walpb"
+)
+
+// Storage implements the raft.Storage interface on top of a WAL, as an
+// alternative to raft.MemoryStorage which holds the whole uncompacted log in
+// memory. It keeps only the terms of the entries and a cache of the latest
+// ones in memory, and reads the older entries back from the wal files.
+// The entries MUST be saved to the WAL before they are appended to the
+// Storage. The WAL keeps the files holding the entries of the Storage
+// locked, so that they are not purged.
+// 基于WAL文件的raft.Storage：内存中只保存entry的term和最近entry的缓存，
+// 较早的entry从wal文件中读取
+type Storage struct {
+	w *WAL
+	// cacheSize limits the total size of the cached entries.
+	cacheSize uint64
+
+	mu        sync.Mutex
+	hardState raftpb.HardState
+	snapshot  raftpb.Snapshot
+	// terms[i] is the term of the entry at offset+i. terms[0] is the term of
+	// the dummy entry at offset, which is the last compacted one.
+	offset uint64
+	terms  []uint64
+	// cache holds the latest entries, up to the last one, and cacheBytes
+	// is their total size.
+	cache      []raftpb.Entry
+	cacheBytes uint64
+}
+
+// NewStorage creates an empty Storage reading the entries back from the
+// given WAL, which caches the latest entries up to cacheSize bytes.
+func NewStorage(w *WAL, cacheSize uint64) *Storage {
+	w.retainFrom(1)
+	return &Storage{
+		w:         w,
+		cacheSize: cacheSize,
+		terms:     make([]uint64, 1),
+	}
+}
+
+// InitialState implements the raft.Storage interface.
+func (s *Storage) InitialState() (raftpb.HardState, raftpb.ConfState, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.hardState, s.snapshot.Metadata.ConfState, nil
+}
+
+// SetHardState saves the current HardState.
+func (s *Storage) SetHardState(st raftpb.HardState) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.hardState = st
+	return nil
+}
+
+// Entries implements the raft.Storage interface. The cached entries are
+// served from memory, and the older ones are read from the wal files.
+func (s *Storage) Entries(lo, hi, maxSize uint64) ([]raftpb.Entry, error) {
+	s.mu.Lock()
+	if lo <= s.offset {
+		s.mu.Unlock()
+		return nil, raft.ErrCompacted
+	}
+	last := s.lastIndex()
+	if hi > last+1 {
+		s.mu.Unlock()
+		log.Panicf("wal: entries's hi(%d) is out of bound lastindex(%d)", hi, last)
+	}
+	// only contains dummy entries.
+	if len(s.terms) == 1 {
+		s.mu.Unlock()
+		return nil, raft.ErrUnavailable
+	}
+	cacheFirst := last + 1 - uint64(len(s.cache))
+	if lo >= cacheFirst {
+		ents := limitSize(s.cache[lo-cacheFirst:hi-cacheFirst], maxSize)
+		s.mu.Unlock()
+		return ents, nil
+	}
+
+	// read the entries before the cache from the wal files without holding
+	// the lock, matching them by their terms.
+	whi := min(hi, cacheFirst)
+	terms := append([]uint64(nil), s.terms[lo-s.offset:whi-s.offset]...)
+	var cached []raftpb.Entry
+	if hi > cacheFirst {
+		cached = s.cache[:hi-cacheFirst]
+	}
+	s.mu.Unlock()
+
+	ents, err := readEntries(s.w.dir, s.w.provider, lo, terms, maxSize)
+	if err != nil {
+		return nil, err
+	}
+	if len(ents) == len(terms) && len(cached) != 0 {
+		ents = limitSize(append(ents, cached...), maxSize)
+	}
+	return ents, nil
+}
+
+// Term implements the raft.Storage interface.
+func (s *Storage) Term(i uint64) (uint64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if i < s.offset {
+		return 0, raft.ErrCompacted
+	}
+	if i > s.lastIndex() {
+		return 0, raft.ErrUnavailable
+	}
+	return s.terms[i-s.offset], nil
+}
+
+// LastIndex implements the raft.Storage interface.
+func (s *Storage) LastIndex() (uint64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.lastIndex(), nil
+}
+
+func (s *Storage) lastIndex() uint64 {
+	return s.offset + uint64(len(s.terms)) - 1
+}
+
+// FirstIndex implements the raft.Storage interface.
+func (s *Storage) FirstIndex() (uint64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.offset + 1, nil
+}
+
+// Snapshot implements the raft.Storage interface.
+func (s *Storage) Snapshot() (raftpb.Snapshot, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.snapshot, nil
+}
+
+// ApplySnapshot overwrites the contents of the Storage with those of the
+// given snapshot.
+func (s *Storage) ApplySnapshot(snap raftpb.Snapshot) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.snapshot = snap
+	s.offset = snap.Metadata.Index
+	s.terms = []uint64{snap.Metadata.Term}
+	s.cache, s.cacheBytes = nil, 0
+	s.w.retainFrom(s.offset + 1)
+	return nil
+}
+
+// CreateSnapshot creates a snapshot at index i, which can be retrieved with
+// the Snapshot method. If any configuration changes have been made since the
+// last compaction, the result of the last ApplyConfChange must be passed in.
+func (s *Storage) CreateSnapshot(i uint64, cs *raftpb.ConfState, data []byte) (raftpb.Snapshot, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if i <= s.snapshot.Metadata.Index {
+		return raftpb.Snapshot{}, raft.ErrSnapOutOfDate
+	}
+	if i > s.lastIndex() {
+		log.Panicf("wal: snapshot %d is out of bound lastindex(%d)", i, s.lastIndex())
+	}
+
+	s.snapshot.Metadata.Index = i
+	s.snapshot.Metadata.Term = s.terms[i-s.offset]
+	if cs != nil {
+		s.snapshot.Metadata.ConfState = *cs
+	}
+	s.snapshot.Data = data
+	return s.snapshot, nil
+}
+
+// Compact discards all log entries prior to compactIndex, and lets the WAL
+// release the files holding only those entries.
+// It is the application's responsibility to not attempt to compact an index
+// greater than the applied one.
+func (s *Storage) Compact(compactIndex uint64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if compactIndex <= s.offset {
+		return raft.ErrCompacted
+	}
+	if compactIndex > s.lastIndex() {
+		log.Panicf("wal: compact %d is out of bound lastindex(%d)", compactIndex, s.lastIndex())
+	}
+
+	s.terms = append([]uint64(nil), s.terms[compactIndex-s.offset:]...)
+	s.offset = compactIndex
+	for len(s.cache) != 0 && s.cache[0].Index <= compactIndex {
+		s.cacheBytes -= uint64(s.cache[0].Size())
+		s.cache = s.cache[1:]
+	}
+	s.w.retainFrom(compactIndex + 1)
+	return nil
+}
+
+// Append appends the new entries, which have been saved to the WAL, to the
+// Storage.
+func (s *Storage) Append(entries []raftpb.Entry) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(entries) == 0 {
+		return nil
+	}
+	first := s.offset + 1
+	last := entries[0].Index + uint64(len(entries)) - 1
+
+	// shortcut if there is no new entry.
+	if last < first {
+		return nil
+	}
+	// truncate compacted entries
+	if first > entries[0].Index {
+		entries = entries[first-entries[0].Index:]
+	}
+
+	off := entries[0].Index - s.offset
+	cacheFirst := s.lastIndex() + 1 - uint64(len(s.cache))
+	switch {
+	case uint64(len(s.terms)) > off:
+		// the conflicting entries are replaced, and the cache is copied, so
+		// that the slices returned by Entries are not changed.
+		s.terms = append([]uint64(nil), s.terms[:off]...)
+		var cache []raftpb.Entry
+		if entries[0].Index > cacheFirst {
+			cache = append(cache, s.cache[:entries[0].Index-cacheFirst]...)
+		}
+		s.cache, s.cacheBytes = cache, 0
+		for _, e := range s.cache {
+			s.cacheBytes += uint64(e.Size())
+		}
+	case uint64(len(s.terms)) == off:
+	default:
+		log.Panicf("wal: missing log entry [last: %d, append at: %d]", s.lastIndex(), entries[0].Index)
+	}
+	for _, e := range entries {
+		s.terms = append(s.terms, e.Term)
+		s.cache = append(s.cache, e)
+		s.cacheBytes += uint64(e.Size())
+	}
+	// evict the oldest entries from the cache.
+	for len(s.cache) != 0 && s.cacheBytes > s.cacheSize {
+		s.cacheBytes -= uint64(s.cache[0].Size())
+		s.cache = s.cache[1:]
+	}
+	return nil
+}
+
+// readEntries reads the entries from lo on with the given terms from the wal
+// files in dirpath, up to maxSize. An entry may have been saved more than
+// once, and the one with the given term is returned, which is the same entry
+// by the log matching property of raft.
+// 从wal文件中读取从lo开始、term与给定的terms一致的entry
+func readEntries(dirpath string, p encryption.Provider, lo uint64, terms []uint64, maxSize uint64) ([]raftpb.Entry, error) {
+	names, err := fileutil.ReadDir(dirpath)
+	if err != nil {
+		return nil, err
+	}
+	names = checkWalNames(names)
+	nameIndex, ok := searchIndex(names, lo)
+	if !ok {
+		return nil, raft.ErrCompacted
+	}
+	rcs := make([]io.ReadCloser, 0)
+	for _, name := range names[nameIndex:] {
+		f, err := os.Open(path.Join(dirpath, name))
+		if err != nil {
+			MultiReadCloser(rcs...).Close()
+			return nil, err
+		}
+		rcs = append(rcs, f)
+	}
+	d := newDecoder(MultiReadCloser(rcs...))
+	d.provider = p
+	defer d.close()
+
+	hi := lo + uint64(len(terms))
+	ents := make([]raftpb.Entry, len(terms))
+	found := make([]bool, len(terms))
+	// next is the first entry not found yet, and size is the size of the
+	// entries before it.
+	next, size := lo, uint64(0)
+	rec := &walpb.Record{}
+	for next < hi && size <= maxSize {
+		if err := d.decode(rec); err != nil {
+			if err == io.EOF || err == io.ErrUnexpectedEOF {
+				err = raft.ErrUnavailable
+			}
+			return nil, err
+		}
+		switch rec.Type {
+		case crcType:
+			if crc := d.crc.Sum32(); crc != 0 && rec.Validate(crc) != nil {
+				return nil, ErrCRCMismatch
+			}
+			d.updateCRC(rec.Crc)
+		case entryType:
+			e := mustUnmarshalEntry(rec.Data)
+			if e.Index < lo || e.Index >= hi || e.Term != terms[e.Index-lo] {
+				continue
+			}
+			ents[e.Index-lo], found[e.Index-lo] = e, true
+			for next < hi && found[next-lo] && size <= maxSize {
+				size += uint64(ents[next-lo].Size())
+				next++
+			}
+		}
+	}
+	return limitSize(ents[:next-lo], maxSize), nil
+}
+
+// limitSize returns the longest prefix of ents within maxSize, but at least
+// one entry.
+func limitSize(ents []raftpb.Entry, maxSize uint64) []raftpb.Entry {
+	if len(ents) == 0 {
+		return ents
+	}
+	size := ents[0].Size()
+	var limit int
+	for limit = 1; limit < len(ents); limit++ {
+		size += ents[limit].Size()
+		if uint64(size) > maxSize {
+			break
+		}
+	}
+	return ents[:limit]
+}
+
+func min(a, b uint64) uint64 {
+	if a > b {
+		return b
+	}
+	return a
+}
@@ -0,0 +1,209 @@
+// Copyright 2015 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package wal
+
+import (
+	"io/ioutil"
+	"math"
+	"os"
+	"reflect"
+	"testing"
+
+	"github.com/coreos/etcd/raft"
+	"github.com/coreos/etcd/raft/raftpb"
+)
+
+const noLimit = math.MaxUint64
+
+// newTestStorage creates a Storage on a new WAL in a temp dir, which the
+// returned func removes.
+func newTestStorage(t *testing.T, cacheSize uint64) (*WAL, *Storage, func()) {
+	p, err := ioutil.TempDir(os.TempDir(), "waltest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	w, err := Create(p, nil)
+	if err != nil {
+		os.RemoveAll(p)
+		t.Fatal(err)
+	}
+	return w, NewStorage(w, cacheSize), func() {
+		w.Close()
+		os.RemoveAll(p)
+	}
+}
+
+// saveAndAppend saves the entries to the WAL and appends them to the Storage.
+func saveAndAppend(t *testing.T, w *WAL, s *Storage, ents []raftpb.Entry) {
+	if err := w.Save(raftpb.HardState{}, ents); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Append(ents); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func testEntries(from, to, term uint64) []raftpb.Entry {
+	var ents []raftpb.Entry
+	for i := from; i < to; i++ {
+		ents = append(ents, raftpb.Entry{Index: i, Term: term, Data: []byte("somedata")})
+	}
+	return ents
+}
+
+func TestStorageEntries(t *testing.T) {
+	ents := testEntries(1, 11, 1)
+	size := uint64(ents[0].Size())
+	// the cache holds the last three entries.
+	w, s, cleanup := newTestStorage(t, 3*size)
+	defer cleanup()
+	saveAndAppend(t, w, s, ents[:5])
+	if err := w.cut(); err != nil {
+		t.Fatal(err)
+	}
+	saveAndAppend(t, w, s, ents[5:])
+
+	tests := []struct {
+		lo, hi, maxSize uint64
+		wents           []raftpb.Entry
+	}{
+		// across the wal files and the cache
+		{1, 11, noLimit, ents},
+		// from the wal files only
+		{2, 6, noLimit, ents[1:5]},
+		// from the cache only
+		{8, 11, noLimit, ents[7:]},
+		// limited by the size, with at least one entry
+		{2, 11, 2 * size, ents[1:3]},
+		{2, 11, 0, ents[1:2]},
+		{7, 11, 2 * size, ents[6:8]},
+	}
+	for i, tt := range tests {
+		g, err := s.Entries(tt.lo, tt.hi, tt.maxSize)
+		if err != nil {
+			t.Fatalf("#%d: err = %v, want nil", i, err)
+		}
+		if !reflect.DeepEqual(g, tt.wents) {
+			t.Errorf("#%d: ents = %+v, want %+v", i, g, tt.wents)
+		}
+	}
+	if _, err := s.Entries(0, 2, noLimit); err != raft.ErrCompacted {
+		t.Errorf("err = %v, want %v", err, raft.ErrCompacted)
+	}
+}
+
+// TestStorageEntriesConflict ensures that the Storage reads the entries which
+// replaced the conflicting ones saved earlier to the WAL.
+func TestStorageEntriesConflict(t *testing.T) {
+	w, s, cleanup := newTestStorage(t, 0)
+	defer cleanup()
+	saveAndAppend(t, w, s, testEntries(1, 6, 1))
+	saveAndAppend(t, w, s, testEntries(4, 7, 2))
+
+	wents := append(testEntries(1, 4, 1), testEntries(4, 7, 2)...)
+	g, err := s.Entries(1, 7, noLimit)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(g, wents) {
+		t.Errorf("ents = %+v, want %+v", g, wents)
+	}
+	for i := uint64(1); i < 7; i++ {
+		if term, _ := s.Term(i); term != wents[i-1].Term {
+			t.Errorf("term(%d) = %d, want %d", i, term, wents[i-1].Term)
+		}
+	}
+}
+
+// TestStorageCompact ensures that the WAL keeps the files holding the
+// entries after the compacted index locked.
+func TestStorageCompact(t *testing.T) {
+	w, s, cleanup := newTestStorage(t, 0)
+	defer cleanup()
+	// make 10 seperate files
+	for i := uint64(1); i <= 10; i++ {
+		saveAndAppend(t, w, s, testEntries(i, i+1, 1))
+		if err := w.cut(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := s.Compact(5); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Compact(5); err != raft.ErrCompacted {
+		t.Errorf("err = %v, want %v", err, raft.ErrCompacted)
+	}
+	if fi, _ := s.FirstIndex(); fi != 6 {
+		t.Errorf("firstIndex = %d, want 6", fi)
+	}
+	if term, err := s.Term(5); err != nil || term != 1 {
+		t.Errorf("term = %d, %v, want 1, nil", term, err)
+	}
+
+	// the locks are released to 6 instead, keeping the files from 5 on.
+	if err := w.ReleaseLockTo(10); err != nil {
+		t.Fatal(err)
+	}
+	if len(w.locks) != 7 {
+		t.Errorf("len(w.locks) = %d, want 7", len(w.locks))
+	}
+	g, err := s.Entries(6, 11, noLimit)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if wents := testEntries(6, 11, 1); !reflect.DeepEqual(g, wents) {
+		t.Errorf("ents = %+v, want %+v", g, wents)
+	}
+}
+
+func TestStorageApplySnapshot(t *testing.T) {
+	w, s, cleanup := newTestStorage(t, noLimit)
+	defer cleanup()
+	saveAndAppend(t, w, s, testEntries(1, 4, 1))
+
+	cs := &raftpb.ConfState{Nodes: []uint64{1, 2, 3}}
+	snap, err := s.CreateSnapshot(3, cs, []byte("data"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = s.CreateSnapshot(2, cs, nil); err != raft.ErrSnapOutOfDate {
+		t.Errorf("err = %v, want %v", err, raft.ErrSnapOutOfDate)
+	}
+
+	snap.Metadata.Index, snap.Metadata.Term = 10, 2
+	if err = s.ApplySnapshot(snap); err != nil {
+		t.Fatal(err)
+	}
+	if li, _ := s.LastIndex(); li != 10 {
+		t.Errorf("lastIndex = %d, want 10", li)
+	}
+	if _, err = s.Entries(10, 11, noLimit); err != raft.ErrCompacted {
+		t.Errorf("err = %v, want %v", err, raft.ErrCompacted)
+	}
+	_, gcs, _ := s.InitialState()
+	if !reflect.DeepEqual(gcs, *cs) {
+		t.Errorf("confState = %+v, want %+v", gcs, *cs)
+	}
+
+	saveAndAppend(t, w, s, testEntries(11, 12, 2))
+	g, err := s.Entries(11, 12, noLimit)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if wents := testEntries(11, 12, 2); !reflect.DeepEqual(g, wents) {
+		t.Errorf("ents = %+v, want %+v", g, wents)
+	}
+}
@@ -26,6 +26,7 @@ import (
 	"sync"
 	"time"
 
+	"github.com/coreos/etcd/pkg/encryption"
 	"github.com/coreos/etcd/pkg/fileutil"
 	"github.com/coreos/etcd/pkg/pbutil"
 	"github.com/coreos/etcd/raft"
@@ -50,12 +51,13 @@ const (
 )
 
 var (
-	ErrMetadataConflict = errors.New("wal: conflicting metadata found")
-	ErrFileNotFound     = errors.New("wal: file not found")
-	ErrCRCMismatch      = errors.New("wal: crc mismatch")
-	ErrSnapshotMismatch = errors.New("wal: snapshot mismatch")
-	ErrSnapshotNotFound = errors.New("wal: snapshot not found")
-	crcTable            = crc32.MakeTable(crc32.Castagnoli)
+	ErrMetadataConflict    = errors.New("wal: conflicting metadata found")
+	ErrFileNotFound        = errors.New("wal: file not found")
+	ErrCRCMismatch         = errors.New("wal: crc mismatch")
+	ErrSnapshotMismatch    = errors.New("wal: snapshot mismatch")
+	ErrSnapshotNotFound    = errors.New("wal: snapshot not found")
+	ErrInvalidRecordLength = errors.New("wal: invalid record length")
+	crcTable               = crc32.MakeTable(crc32.Castagnoli)
 )
 
 // WAL is a logical repersentation of the stable storage.
@@ -70,6 +72,10 @@ type WAL struct {
 
 	start   walpb.Snapshot // snapshot to start reading
 	decoder *decoder       // decoder to decode records
+	// readTail is true if the decoder reads the last wal file, whose
+	// records start at tailStart in the records read by the decoder.
+	readTail  bool
+	tailStart int64
 
 	mu      sync.Mutex
 	f       *os.File // underlay file opened for appending, sync
@@ -78,11 +84,44 @@ type WAL struct {
 	encoder *encoder // encoder to encode records
 
 	locks []fileutil.Lock // the file locks the WAL is holding (the name is increasing)
+	// retainIndex is the index of the first entry a Storage reads from the
+	// wal files. The locks of the files holding it and the later entries are
+	// not released. Zero if there is no Storage.
+	retainIndex uint64
+
+	provider encryption.Provider // seals the records if not nil
+
+	syncWindow time.Duration // time a Save waits for other Saves to share its fsync
+	batch      *syncBatch    // the Saves waiting for the next fsync
+}
+
+// syncBatch is a group of Saves committed by one fsync.
+type syncBatch struct {
+	saves int
+	err   error
+	done  chan struct{}
+}
+
+// SetSyncWindow sets the time a Save waits before it fsyncs the WAL, so
+// that the Saves called within the window are committed by one fsync.
+// Zero, the default, fsyncs every Save immediately.
+// 设置group commit的窗口，窗口内的多个Save共用一次fsync
+func (w *WAL) SetSyncWindow(d time.Duration) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.syncWindow = d
 }
 
 // Create creates a WAL ready for appending records. The given metadata is
 // recorded at the head of each WAL file, and can be retrieved with ReadAll.
 func Create(dirpath string, metadata []byte) (*WAL, error) {
+	return CreateEncrypted(dirpath, metadata, nil)
+}
+
+// CreateEncrypted creates a WAL whose records are sealed by the given
+// provider. A nil provider writes the records in plaintext.
+// 创建记录加密的WAL
+func CreateEncrypted(dirpath string, metadata []byte, p encryption.Provider) (*WAL, error) {
 	if Exist(dirpath) {
 		return nil, os.ErrExist
 	}
@@ -91,11 +130,14 @@ func Create(dirpath string, metadata []byte) (*WAL, error) {
 		return nil, err
 	}
 
-	p := path.Join(dirpath, walName(0, 0))
-	f, err := os.OpenFile(p, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	fpath := path.Join(dirpath, walName(0, 0))
+	f, err := os.OpenFile(fpath, os.O_WRONLY|os.O_CREATE, 0600)
 	if err != nil {
 		return nil, err
 	}
+	if err := fileutil.Preallocate(f, segmentSizeBytes); err != nil {
+		return nil, err
+	}
 	l, err := fileutil.NewLock(f.Name())
 	if err != nil {
 		return nil, err
@@ -110,8 +152,9 @@ func Create(dirpath string, metadata []byte) (*WAL, error) {
 		metadata: metadata,
 		seq:      0,
 		f:        f,
-		encoder:  newEncoder(f, 0),
+		provider: p,
 	}
+	w.encoder = w.newEncoder(0)
 	w.locks = append(w.locks, l)
 	if err := w.saveCrc(0); err != nil {
 		return nil, err
@@ -132,16 +175,22 @@ func Create(dirpath string, metadata []byte) (*WAL, error) {
 // the given snap. The WAL cannot be appended to before reading out all of its
 // previous records.
 func Open(dirpath string, snap walpb.Snapshot) (*WAL, error) {
-	return openAtIndex(dirpath, snap, true)
+	return openAtIndex(dirpath, snap, true, nil)
+}
+
+// OpenEncrypted opens the WAL created by CreateEncrypted with the same key.
+// Other than that, it is similar to Open.
+func OpenEncrypted(dirpath string, snap walpb.Snapshot, p encryption.Provider) (*WAL, error) {
+	return openAtIndex(dirpath, snap, true, p)
 }
 
 // OpenNotInUse only opens the wal files that are not in use.
 // Other than that, it is similar to Open.
 func OpenNotInUse(dirpath string, snap walpb.Snapshot) (*WAL, error) {
-	return openAtIndex(dirpath, snap, false)
+	return openAtIndex(dirpath, snap, false, nil)
 }
 
-func openAtIndex(dirpath string, snap walpb.Snapshot, all bool) (*WAL, error) {
+func openAtIndex(dirpath string, snap walpb.Snapshot, all bool, p encryption.Provider) (*WAL, error) {
 	names, err := fileutil.ReadDir(dirpath)
 	if err != nil {
 		return nil, err
@@ -182,6 +231,21 @@ func openAtIndex(dirpath string, snap walpb.Snapshot, all bool) (*WAL, error) {
 	}
 	rc := MultiReadCloser(rcs...)
 
+	// the files before the last one are truncated to their records when
+	// they are cut, so the records of the last file start after them.
+	var tailStart int64
+	readTail := len(rcs) == len(names)-nameIndex
+	if readTail {
+		for _, f := range rcs[:len(rcs)-1] {
+			fi, err := f.(*os.File).Stat()
+			if err != nil {
+				rc.Close()
+				return nil, err
+			}
+			tailStart += fi.Size()
+		}
+	}
+
 	// open the lastest wal file for appending
 	seq, _, err := parseWalName(names[len(names)-1])
 	if err != nil {
@@ -189,7 +253,7 @@ func openAtIndex(dirpath string, snap walpb.Snapshot, all bool) (*WAL, error) {
 		return nil, err
 	}
 	last := path.Join(dirpath, names[len(names)-1])
-	f, err := os.OpenFile(last, os.O_WRONLY|os.O_APPEND, 0)
+	f, err := os.OpenFile(last, os.O_WRONLY, 0)
 	if err != nil {
 		rc.Close()
 		return nil, err
@@ -197,14 +261,18 @@ func openAtIndex(dirpath string, snap walpb.Snapshot, all bool) (*WAL, error) {
 
 	// create a WAL ready for reading
 	w := &WAL{
-		dir:     dirpath,
-		start:   snap,
-		decoder: newDecoder(rc),
+		dir:       dirpath,
+		start:     snap,
+		decoder:   newDecoder(rc),
+		readTail:  readTail,
+		tailStart: tailStart,
+		provider:  p,
 
 		f:     f,
 		seq:   seq,
 		locks: ls,
 	}
+	w.decoder.provider = p
 	return w, nil
 }
 
@@ -277,8 +345,22 @@ func (w *WAL) ReadAll() (metadata []byte, state raftpb.HardState, ents []raftpb.
 	w.start = walpb.Snapshot{}
 
 	w.metadata = metadata
+	// append after the last record of the last file, which may be
+	// followed by the zeros of the preallocation
+	if w.readTail {
+		if _, serr := w.f.Seek(w.decoder.lastValidOff-w.tailStart, os.SEEK_SET); serr != nil {
+			state.Reset()
+			return nil, state, nil, serr
+		}
+		// preallocate the files written before the preallocation
+		if perr := fileutil.Preallocate(w.f, segmentSizeBytes); perr != nil {
+			state.Reset()
+			return nil, state, nil, perr
+		}
+	}
+
 	// create encoder (chain crc with the decoder), enable appending
-	w.encoder = newEncoder(w.f, w.decoder.lastCRC())
+	w.encoder = w.newEncoder(w.decoder.lastCRC())
 	w.decoder = nil
 	lastIndexSaved.Set(float64(w.enti))
 	return metadata, state, ents, err
@@ -288,6 +370,15 @@ func (w *WAL) ReadAll() (metadata []byte, state raftpb.HardState, ents []raftpb.
 // cut first creates a temp wal file and writes necessary headers into it.
 // Then cut atomtically rename temp wal file to a wal file.
 func (w *WAL) cut() error {
+	// truncate the preallocated zeros of the old wal file, so that the
+	// records of the next file follow its records when it is read
+	off, err := w.offset()
+	if err != nil {
+		return err
+	}
+	if err := w.f.Truncate(off); err != nil {
+		return err
+	}
 	// close old wal file
 	if err := w.sync(); err != nil {
 		return err
@@ -300,15 +391,19 @@ func (w *WAL) cut() error {
 	ftpath := fpath + ".tmp"
 
 	// create a temp wal file with name sequence + 1, or tuncate the existing one
-	ft, err := os.OpenFile(ftpath, os.O_WRONLY|os.O_APPEND|os.O_CREATE|os.O_TRUNC, 0600)
+	ft, err := os.OpenFile(ftpath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
 	if err != nil {
 		return err
 	}
+	if err := fileutil.Preallocate(ft, segmentSizeBytes); err != nil {
+		ft.Close()
+		return err
+	}
 
 	// update writer and save the previous crc
 	w.f = ft
 	prevCrc := w.encoder.crc.Sum32()
-	w.encoder = newEncoder(w.f, prevCrc)
+	w.encoder = w.newEncoder(prevCrc)
 	if err := w.saveCrc(prevCrc); err != nil {
 		return err
 	}
@@ -322,6 +417,9 @@ func (w *WAL) cut() error {
 	if err := w.sync(); err != nil {
 		return err
 	}
+	if off, err = w.offset(); err != nil {
+		return err
+	}
 	if err := w.f.Close(); err != nil {
 		return err
 	}
@@ -332,13 +430,17 @@ func (w *WAL) cut() error {
 	}
 
 	// open the wal file and update writer again
-	f, err := os.OpenFile(fpath, os.O_WRONLY|os.O_APPEND, 0600)
+	f, err := os.OpenFile(fpath, os.O_WRONLY, 0600)
 	if err != nil {
 		return err
 	}
+	if _, err := f.Seek(off, os.SEEK_SET); err != nil {
+		f.Close()
+		return err
+	}
 	w.f = f
 	prevCrc = w.encoder.crc.Sum32()
-	w.encoder = newEncoder(w.f, prevCrc)
+	w.encoder = w.newEncoder(prevCrc)
 
 	// lock the new wal file
 	l, err := fileutil.NewLock(f.Name())
@@ -359,6 +461,22 @@ func (w *WAL) cut() error {
 	return nil
 }
 
+// newEncoder returns an encoder appending to the current wal file, which
+// seals the records with the provider of the WAL.
+func (w *WAL) newEncoder(prevCrc uint32) *encoder {
+	e := newEncoder(w.f, prevCrc)
+	e.provider = w.provider
+	return e
+}
+
+// offset returns the offset of the next record in the current wal file.
+func (w *WAL) offset() (int64, error) {
+	if err := w.encoder.flush(); err != nil {
+		return 0, err
+	}
+	return w.f.Seek(0, os.SEEK_CUR)
+}
+
 func (w *WAL) sync() error {
 	if w.encoder != nil {
 		if err := w.encoder.flush(); err != nil {
@@ -368,17 +486,56 @@ func (w *WAL) sync() error {
 	start := time.Now()
 	err := w.f.Sync()
 	syncDurations.Observe(float64(time.Since(start).Nanoseconds() / int64(time.Microsecond)))
+	// the fsync also commits the records of the waiting Saves
+	if b := w.batch; b != nil {
+		w.batch = nil
+		syncBatchSaves.Observe(float64(b.saves))
+		b.err = err
+		close(b.done)
+	}
 	return err
 }
 
+// syncBatched joins the Save to the pending batch and waits for the fsync
+// of the batch. The first Save of a batch fsyncs it after the sync window,
+// unless another sync does it earlier. It must be called with w.mu held,
+// and returns with w.mu released.
+func (w *WAL) syncBatched() error {
+	b := w.batch
+	first := b == nil
+	if first {
+		b = &syncBatch{done: make(chan struct{})}
+		w.batch = b
+	}
+	b.saves++
+	w.mu.Unlock()
+
+	if first {
+		time.Sleep(w.syncWindow)
+		w.mu.Lock()
+		if w.batch == b {
+			w.sync()
+		}
+		w.mu.Unlock()
+	}
+	<-b.done
+	return b.err
+}
+
 // ReleaseLockTo releases the locks, which has smaller index than the given index
 // except the largest one among them.
 // For example, if WAL is holding lock 1,2,3,4,5,6, ReleaseLockTo(4) will release
 // lock 1,2 but keep 3. ReleaseLockTo(5) will release 1,2,3 but keep 4.
+// The locks of the files holding the entries still read by a Storage are
+// kept.
 func (w *WAL) ReleaseLockTo(index uint64) error {
 	w.mu.Lock()
 	defer w.mu.Unlock()
 
+	if w.retainIndex != 0 && index > w.retainIndex {
+		index = w.retainIndex
+	}
+
 	var smaller int
 	found := false
 
@@ -413,6 +570,14 @@ func (w *WAL) ReleaseLockTo(index uint64) error {
 	return nil
 }
 
+// retainFrom keeps the files holding the entries from index on locked for
+// the Storage reading them.
+func (w *WAL) retainFrom(index uint64) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.retainIndex = index
+}
+
 func (w *WAL) Close() error {
 	w.mu.Lock()
 	defer w.mu.Unlock()
@@ -455,35 +620,48 @@ func (w *WAL) saveState(s *raftpb.HardState) error {
 	return w.encoder.encode(rec)
 }
 
+// Save saves the state and the entries, and blocks until they are on the
+// stable storage. With a sync window set, concurrent Saves share an fsync.
 func (w *WAL) Save(st raftpb.HardState, ents []raftpb.Entry) error {
 	w.mu.Lock()
-	defer w.mu.Unlock()
 
 	// short cut, do not call sync
 	if raft.IsEmptyHardState(st) && len(ents) == 0 {
+		w.mu.Unlock()
 		return nil
 	}
 
 	// TODO(xiangli): no more reference operator
 	for i := range ents {
 		if err := w.saveEntry(&ents[i]); err != nil {
+			w.mu.Unlock()
 			return err
 		}
 	}
 	if err := w.saveState(&st); err != nil {
+		w.mu.Unlock()
 		return err
 	}
 
-	fstat, err := w.f.Stat()
+	off, err := w.offset()
 	if err != nil {
+		w.mu.Unlock()
 		return err
 	}
-	if fstat.Size() < segmentSizeBytes {
-		return w.sync()
+	if off >= segmentSizeBytes {
+		// TODO: add a test for this code path when refactoring the tests
+		err = w.cut()
+		w.mu.Unlock()
+		return err
 	}
-	// TODO: add a test for this code path when refactoring the tests
-	return w.cut()
+	if w.syncWindow > 0 {
+		return w.syncBatched()
+	}
+	err = w.sync()
+	w.mu.Unlock()
+	return err
 }
+
 // 设置wal里snapshot的状况
 func (w *WAL) SaveSnapshot(e walpb.Snapshot) error {
 	w.mu.Lock()
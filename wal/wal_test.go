@@ -21,7 +21,9 @@ import (
 	"path"
 	"reflect"
 	"testing"
+	"time"
 
+	"github.com/coreos/etcd/pkg/encryption"
 	"github.com/coreos/etcd/pkg/pbutil"
 	"github.com/coreos/etcd/raft/raftpb"
 	"github.com/coreos/etcd/wal/walpb"
@@ -65,8 +67,15 @@ func TestNew(t *testing.T) {
 		t.Fatalf("err = %v, want nil", err)
 	}
 	e.flush()
-	if !reflect.DeepEqual(gd, wb.Bytes()) {
-		t.Errorf("data = %v, want %v", gd, wb.Bytes())
+	// the file is preallocated with zeros after the records
+	if len(gd) != segmentSizeBytes {
+		t.Errorf("size = %d, want %d", len(gd), segmentSizeBytes)
+	}
+	if !reflect.DeepEqual(gd[:wb.Len()], wb.Bytes()) {
+		t.Errorf("data = %v, want %v", gd[:wb.Len()], wb.Bytes())
+	}
+	if !bytes.Equal(gd[wb.Len():], make([]byte, len(gd)-wb.Len())) {
+		t.Errorf("data after the records is not zeros")
 	}
 }
 
@@ -249,6 +258,157 @@ func TestRecover(t *testing.T) {
 	w.Close()
 }
 
+// TestAppendAfterOpen tests that the records saved after reopening the
+// WAL follow the previous records rather than the preallocated zeros.
+func TestAppendAfterOpen(t *testing.T) {
+	p, err := ioutil.TempDir(os.TempDir(), "waltest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(p)
+
+	w, err := Create(p, []byte("metadata"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ents := []raftpb.Entry{{Index: 1, Term: 1, Data: []byte{1}}, {Index: 2, Term: 1, Data: []byte{2}}}
+	if err = w.Save(raftpb.HardState{}, ents[:1]); err != nil {
+		t.Fatal(err)
+	}
+	w.Close()
+
+	if w, err = Open(p, walpb.Snapshot{}); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, _, err = w.ReadAll(); err != nil {
+		t.Fatal(err)
+	}
+	if err = w.Save(raftpb.HardState{}, ents[1:]); err != nil {
+		t.Fatal(err)
+	}
+	w.Close()
+
+	if w, err = Open(p, walpb.Snapshot{}); err != nil {
+		t.Fatal(err)
+	}
+	_, _, entries, err := w.ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(entries, ents) {
+		t.Errorf("ents = %+v, want %+v", entries, ents)
+	}
+	w.Close()
+}
+
+func TestEncrypted(t *testing.T) {
+	p, err := ioutil.TempDir(os.TempDir(), "waltest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(p)
+
+	ep, err := encryption.NewAESGCM(bytes.Repeat([]byte{1}, 32))
+	if err != nil {
+		t.Fatal(err)
+	}
+	w, err := CreateEncrypted(p, []byte("metadata"), ep)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ents := []raftpb.Entry{{Index: 1, Term: 1, Data: []byte("secret")}}
+	st := raftpb.HardState{Term: 1, Vote: 1, Commit: 1}
+	if err = w.Save(st, ents); err != nil {
+		t.Fatal(err)
+	}
+	fname := w.f.Name()
+	w.Close()
+
+	b, err := ioutil.ReadFile(fname)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, plain := range []string{"metadata", "secret"} {
+		if bytes.Contains(b, []byte(plain)) {
+			t.Errorf("wal file contains %q in plaintext", plain)
+		}
+	}
+
+	// the records cannot be read without the key
+	if w, err = Open(p, walpb.Snapshot{}); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, _, err = w.ReadAll(); err == nil {
+		t.Errorf("err = nil, want not nil")
+	}
+	w.Close()
+
+	if w, err = OpenEncrypted(p, walpb.Snapshot{}, ep); err != nil {
+		t.Fatal(err)
+	}
+	metadata, state, entries, err := w.ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(metadata) != "metadata" {
+		t.Errorf("metadata = %s, want %s", metadata, "metadata")
+	}
+	if !reflect.DeepEqual(state, st) {
+		t.Errorf("state = %+v, want %+v", state, st)
+	}
+	if !reflect.DeepEqual(entries, ents) {
+		t.Errorf("ents = %+v, want %+v", entries, ents)
+	}
+	w.Close()
+}
+
+// TestSaveSyncWindow tests that the Saves within the sync window share
+// one fsync.
+func TestSaveSyncWindow(t *testing.T) {
+	p, err := ioutil.TempDir(os.TempDir(), "waltest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(p)
+
+	w, err := Create(p, []byte("metadata"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.SetSyncWindow(200 * time.Millisecond)
+
+	n := 5
+	errc := make(chan error, n)
+	for i := 0; i < n; i++ {
+		go func(i int) {
+			errc <- w.Save(raftpb.HardState{Term: 1, Commit: uint64(i + 1)}, nil)
+		}(i)
+	}
+	time.Sleep(50 * time.Millisecond)
+	w.mu.Lock()
+	if w.batch == nil || w.batch.saves != n {
+		t.Errorf("batch = %+v, want %d saves", w.batch, n)
+	}
+	w.mu.Unlock()
+	for i := 0; i < n; i++ {
+		if err := <-errc; err != nil {
+			t.Errorf("#%d: err = %v, want nil", i, err)
+		}
+	}
+	if w.batch != nil {
+		t.Errorf("batch = %+v, want nil", w.batch)
+	}
+	w.Close()
+
+	if w, err = Open(p, walpb.Snapshot{}); err != nil {
+		t.Fatal(err)
+	}
+	if _, state, _, err := w.ReadAll(); err != nil || state.Term != 1 {
+		t.Errorf("state = %+v, err = %v, want term 1 and nil", state, err)
+	}
+	w.Close()
+}
+
 func TestSearchIndex(t *testing.T) {
 	tests := []struct {
 		names []string